@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// apiKeyTokenTTL is how long the access token exchanged for an API key is reused; revoked keys
+// stop working after at most this delay. user-service issues the tokens for 5 minutes.
+const apiKeyTokenTTL = time.Minute
+
+var (
+	errAPIKeyRejected    = errors.New("API key rejected by user-service")
+	errAPIKeyMFARequired = errors.New("API key owner must enrol in MFA")
+)
+
+// APIKeyAuthenticator exchanges the API keys of integrations for short-lived access tokens
+// from user-service and rate limits each key. Exchanged tokens are cached by key hash.
+type APIKeyAuthenticator struct {
+	client         *resty.Client
+	userServiceURL string
+	authPaths      map[string]bool // Routes requiring authentication, where keys are accepted
+	keyLimiter     *RateLimiter    // Requests per key
+	verifyLimiter  *RateLimiter    // Key verifications per client IP, against key guessing
+
+	mu     sync.Mutex
+	tokens map[string]cachedAPIKeyToken
+}
+
+type cachedAPIKeyToken struct {
+	accessToken string
+	fetchedAt   time.Time
+}
+
+// apiKeyTokenResponse is the part of user-service's key exchange the gateway needs
+type apiKeyTokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// NewAPIKeyAuthenticator creates an authenticator for the authenticated routes of the config
+func NewAPIKeyAuthenticator(config *Config) *APIKeyAuthenticator {
+	client := resty.New()
+	client.SetTimeout(5 * time.Second)
+	client.SetRetryCount(1)
+
+	authPaths := make(map[string]bool)
+	for _, route := range config.Routes {
+		if route.AuthRequired {
+			authPaths[route.Path] = true
+		}
+	}
+
+	return &APIKeyAuthenticator{
+		client:         client,
+		userServiceURL: config.Services["user-service"].BaseURL,
+		authPaths:      authPaths,
+		keyLimiter:     NewRateLimiter(config.APIKeyRateLimit),
+		verifyLimiter:  NewRateLimiter(config.RateLimit),
+		tokens:         make(map[string]cachedAPIKeyToken),
+	}
+}
+
+// Stop stops the cleanup goroutines of the rate limiters
+func (a *APIKeyAuthenticator) Stop() {
+	a.keyLimiter.Stop()
+	a.verifyLimiter.Stop()
+}
+
+// requestAPIKey reads the key from the X-API-Key header or an "Authorization: ApiKey" header
+func requestAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "ApiKey ") {
+		return strings.TrimSpace(strings.TrimPrefix(authHeader, "ApiKey "))
+	}
+	return ""
+}
+
+// Handles reports whether the request is authenticated with an API key on a route that
+// accepts keys. Those requests are rate limited per key instead of per client IP.
+func (a *APIKeyAuthenticator) Handles(c *gin.Context) bool {
+	return a.authPaths[c.FullPath()] && requestAPIKey(c) != ""
+}
+
+// hashAPIKey is the cache and rate limit key of an API key, so keys aren't kept in memory
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate returns an access token for the API key of the request. On failure it writes
+// the error response and returns false.
+func (a *APIKeyAuthenticator) Authenticate(c *gin.Context, key string) (string, bool) {
+	keyHash := hashAPIKey(key)
+
+	a.mu.Lock()
+	cached, found := a.tokens[keyHash]
+	a.mu.Unlock()
+
+	if !found || time.Since(cached.fetchedAt) > apiKeyTokenTTL {
+		if !a.verifyLimiter.allowRequest(c.ClientIP()) {
+			c.JSON(http.StatusTooManyRequests, ErrorResponse{
+				Error:   "Rate limit exceeded",
+				Code:    "RATE_LIMIT_EXCEEDED",
+				Message: "Too many API key verifications. Please try again later.",
+				Time:    time.Now(),
+			})
+			return "", false
+		}
+		accessToken, err := a.exchange(key, c.ClientIP())
+		if errors.Is(err, errAPIKeyRejected) {
+			a.mu.Lock()
+			delete(a.tokens, keyHash)
+			a.mu.Unlock()
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "Invalid API key",
+				Code:    "API_KEY_INVALID",
+				Message: "The API key is unknown, expired or revoked",
+			})
+			return "", false
+		}
+		if errors.Is(err, errAPIKeyMFARequired) {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "Multi-factor authentication required",
+				Code:    "MFA_REQUIRED",
+				Message: "The key owner's role requires multi-factor authentication; they must enrol before the key can be used",
+			})
+			return "", false
+		}
+		if err != nil {
+			logging.LogError("Failed to exchange API key", "error", err)
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+				Error:   "API key verification unavailable",
+				Code:    "API_KEY_UNAVAILABLE",
+				Message: "Could not verify the API key, try again later",
+			})
+			return "", false
+		}
+		cached = cachedAPIKeyToken{accessToken: accessToken, fetchedAt: time.Now()}
+		a.store(keyHash, cached)
+	}
+
+	if !a.keyLimiter.allowRequest(keyHash) {
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{
+			Error:   "Rate limit exceeded",
+			Code:    "API_KEY_RATE_LIMIT_EXCEEDED",
+			Message: "Too many requests for this API key. Please try again later.",
+			Time:    time.Now(),
+		})
+		return "", false
+	}
+	return cached.accessToken, true
+}
+
+// exchange asks user-service for an access token for the key, passing on the client IP that
+// user-service records as the key's last used IP
+func (a *APIKeyAuthenticator) exchange(key, clientIP string) (string, error) {
+	var token apiKeyTokenResponse
+	resp, err := a.client.R().
+		SetHeader("X-API-Key", key).
+		SetHeader("X-Forwarded-For", clientIP).
+		SetHeader("X-Real-IP", clientIP).
+		SetResult(&token).
+		Post(a.userServiceURL + "/api/auth/api-key/token")
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case resp.StatusCode() == http.StatusUnauthorized || resp.StatusCode() == http.StatusBadRequest:
+		return "", errAPIKeyRejected
+	case resp.StatusCode() == http.StatusForbidden:
+		return "", errAPIKeyMFARequired
+	case resp.IsError():
+		return "", fmt.Errorf("user-service returned %d", resp.StatusCode())
+	case token.AccessToken == "":
+		return "", errors.New("user-service returned no access token")
+	}
+	return token.AccessToken, nil
+}
+
+// store caches a token and drops the expired ones
+func (a *APIKeyAuthenticator) store(keyHash string, token cachedAPIKeyToken) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for hash, cached := range a.tokens {
+		if time.Since(cached.fetchedAt) > apiKeyTokenTTL {
+			delete(a.tokens, hash)
+		}
+	}
+	a.tokens[keyHash] = token
+}
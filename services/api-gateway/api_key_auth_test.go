@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubKeyExchange serves user-service's key exchange with the given status, and records the
+// client IP the gateway forwarded
+func stubKeyExchange(t *testing.T, status int, forwardedFor *string) *APIKeyAuthenticator {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/auth/api-key/token" || r.Header.Get("X-API-Key") == "" {
+			t.Errorf("unexpected exchange request %s %s", r.Method, r.URL.Path)
+		}
+		if forwardedFor != nil {
+			*forwardedFor = r.Header.Get("X-Forwarded-For")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token-for-key"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"error": http.StatusText(status)})
+	}))
+	t.Cleanup(server.Close)
+
+	config := &Config{Services: map[string]ServiceConfig{"user-service": {BaseURL: server.URL}}}
+	authenticator := NewAPIKeyAuthenticator(config)
+	authenticator.client.SetRetryCount(0)
+	t.Cleanup(authenticator.Stop)
+	return authenticator
+}
+
+func TestAPIKeyExchangeStatusMapping(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantToken string
+		wantErr   error
+	}{
+		{"exchanged", http.StatusOK, "token-for-key", nil},
+		{"unknown key", http.StatusUnauthorized, "", errAPIKeyRejected},
+		{"malformed key", http.StatusBadRequest, "", errAPIKeyRejected},
+		{"owner without required MFA", http.StatusForbidden, "", errAPIKeyMFARequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := stubKeyExchange(t, tt.status, nil).exchange("hk_test", "203.0.113.9")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("exchange error = %v, want %v", err, tt.wantErr)
+			}
+			if token != tt.wantToken {
+				t.Errorf("exchange token = %q, want %q", token, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestAPIKeyExchangeUnavailable(t *testing.T) {
+	_, err := stubKeyExchange(t, http.StatusInternalServerError, nil).exchange("hk_test", "203.0.113.9")
+	if err == nil || errors.Is(err, errAPIKeyRejected) || errors.Is(err, errAPIKeyMFARequired) {
+		t.Errorf("exchange error = %v, want an unavailability error", err)
+	}
+}
+
+func TestAPIKeyExchangeForwardsClientIP(t *testing.T) {
+	var forwardedFor string
+	if _, err := stubKeyExchange(t, http.StatusOK, &forwardedFor).exchange("hk_test", "203.0.113.9"); err != nil {
+		t.Fatal(err)
+	}
+	if forwardedFor != "203.0.113.9" {
+		t.Errorf("X-Forwarded-For = %q, want the client IP", forwardedFor)
+	}
+}
@@ -8,11 +8,30 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	logging "github.com/louhibi/healthcare-logging"
+	rbac "github.com/louhibi/healthcare-rbac"
 )
 
-// AuthMiddleware handles JWT authentication
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// serviceAccountRole is the role of user-service's service accounts, which only authenticate
+// with API keys and are the only tokens exempt from the MFA policy
+const serviceAccountRole = "service"
+
+// AuthMiddleware handles JWT authentication. Tokens without the mfa claim are rejected when
+// the policy of the user's entity requires MFA for their role. Integrations may send an API
+// key in X-API-Key or "Authorization: ApiKey <key>" instead; it is exchanged for an access
+// token that replaces it.
+func AuthMiddleware(jwtSecret string, mfaPolicy *MFAPolicyChecker, apiKeys *APIKeyAuthenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := requestAPIKey(c); key != "" {
+			accessToken, ok := apiKeys.Authenticate(c, key)
+			if !ok {
+				c.Abort()
+				return
+			}
+			c.Request.Header.Del("X-API-Key")
+			c.Request.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, ErrorResponse{
@@ -50,54 +69,87 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
+		// Tokens of personal API keys carry the mfa claim when the key's owner has enrolled.
+		// Service accounts have no second factor.
+		if !claims.MFA && claims.Role != serviceAccountRole {
+			required, err := mfaPolicy.RequiresMFA(claims.HealthcareEntityID, claims.Role, authHeader)
+			if err != nil {
+				logging.LogError("Failed to get MFA policy", "error", err, "healthcare_entity_id", claims.HealthcareEntityID)
+				c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+					Error:   "MFA policy unavailable",
+					Code:    "MFA_POLICY_UNAVAILABLE",
+					Message: "Could not check the multi-factor authentication policy, try again later",
+				})
+				c.Abort()
+				return
+			}
+			if required {
+				c.JSON(http.StatusUnauthorized, ErrorResponse{
+					Error:   "Multi-factor authentication required",
+					Code:    "MFA_REQUIRED",
+					Message: "Your role requires multi-factor authentication, please log in again",
+				})
+				c.Abort()
+				return
+			}
+		}
+
 		// Set claims in context
 		c.Set("user_claims", claims)
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
+		c.Set("user_permissions", claims.Permissions)
 		c.Set("healthcare_entity_id", claims.HealthcareEntityID)
+		c.Set("api_key_id", claims.APIKeyID)
 		
 		// Add user headers for downstream services  
 		c.Request.Header.Set("X-User-ID", fmt.Sprintf("%d", claims.UserID))
 		c.Request.Header.Set("X-User-Email", claims.Email)
 		c.Request.Header.Set("X-User-Role", claims.Role)
+		c.Request.Header.Set(rbac.Header, strings.Join(claims.Permissions, ","))
 		c.Request.Header.Set("X-Healthcare-Entity-ID", fmt.Sprintf("%d", claims.HealthcareEntityID))
+		c.Request.Header.Del("X-API-Key-ID")
+		if claims.APIKeyID != 0 {
+			c.Request.Header.Set("X-API-Key-ID", fmt.Sprintf("%d", claims.APIKeyID))
+		}
 		
 		c.Next()
 	}
 }
 
-// RoleMiddleware checks if user has required role
-func RoleMiddleware(allowedRoles []string) gin.HandlerFunc {
+// PermissionMiddleware checks the user has at least one of the route's permissions
+func PermissionMiddleware(permissions []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if len(allowedRoles) == 0 {
+		if len(permissions) == 0 {
 			c.Next()
 			return
 		}
 
-		userRole, exists := c.Get("user_role")
+		granted, exists := c.Get("user_permissions")
 		if !exists {
 			c.JSON(http.StatusForbidden, ErrorResponse{
-				Error:   "Role information not found",
-				Code:    "ROLE_MISSING",
-				Message: "User role information is missing from request context",
+				Error:   "Permission information not found",
+				Code:    "PERMISSIONS_MISSING",
+				Message: "User permission information is missing from request context",
 			})
 			c.Abort()
 			return
 		}
 
-		role := userRole.(string)
-		for _, allowedRole := range allowedRoles {
-			if role == allowedRole {
-				c.Next()
-				return
+		for _, permission := range permissions {
+			for _, grantedPermission := range granted.([]string) {
+				if grantedPermission == permission {
+					c.Next()
+					return
+				}
 			}
 		}
 
 		c.JSON(http.StatusForbidden, ErrorResponse{
 			Error:   "Insufficient permissions",
-			Code:    "ROLE_FORBIDDEN",
-			Message: "User role '" + role + "' is not allowed to access this resource",
+			Code:    "PERMISSION_DENIED",
+			Message: "One of the permissions " + strings.Join(permissions, ", ") + " is required to access this resource",
 		})
 		c.Abort()
 	}
@@ -151,10 +203,33 @@ func validateJWT(tokenString, jwtSecret string) (*UserClaims, error) {
 		return nil, errors.New("invalid healthcare_entity_id in token")
 	}
 
+	// mfa is absent from tokens issued before MFA existed
+	mfa, _ := claims["mfa"].(bool)
+
+	// akid is set on tokens exchanged for an API key
+	apiKeyID := 0
+	if akid, ok := claims["akid"].(float64); ok {
+		apiKeyID = int(akid)
+	}
+
+	// Tokens issued before permissions existed get those of the built-in role
+	permissions := rbac.RolePermissions(role)
+	if values, ok := claims["permissions"].([]interface{}); ok {
+		permissions = make([]string, 0, len(values))
+		for _, value := range values {
+			if permission, ok := value.(string); ok {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
 	return &UserClaims{
 		UserID:             int(userID),
 		Email:              email,
 		Role:               role,
 		HealthcareEntityID: int(healthcareEntityID),
+		MFA:                mfa,
+		Permissions:        permissions,
+		APIKeyID:           apiKeyID,
 	}, nil
 }
\ No newline at end of file
@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	rbac "github.com/louhibi/healthcare-rbac"
 )
 
 // Config represents the gateway configuration
@@ -13,6 +15,7 @@ type Config struct {
 	Services     map[string]ServiceConfig  `json:"services"`
 	Routes       []RouteConfig            `json:"routes"`
 	RateLimit    RateLimitConfig          `json:"rate_limit"`
+	APIKeyRateLimit RateLimitConfig       `json:"api_key_rate_limit"` // Per API key, instead of RateLimit per IP
 	JWTSecret    string                   `json:"jwt_secret"`
 	Timeout      time.Duration            `json:"timeout"`
 	LogLevel     string                   `json:"log_level"`
@@ -64,21 +67,247 @@ func LoadConfig() *Config {
 				Service:     "user-service",
 				StripPrefix: false,
 				AuthRequired: false,
-				RolesAllowed: []string{},
 			},
 			{
 				Path:        "/api/auth/register",
 				Service:     "user-service",
 				StripPrefix: false,
 				AuthRequired: false,
-				RolesAllowed: []string{},
 			},
 			{
 				Path:        "/api/auth/refresh",
 				Service:     "user-service",
 				StripPrefix: false,
 				AuthRequired: false,
-				RolesAllowed: []string{},
+			},
+			{
+				Path:        "/api/auth/logout",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: false,
+			},
+			{
+				Path:        "/api/auth/mfa/verify",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: false,
+			},
+			{
+				Path:        "/api/auth/mfa/enroll",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: false,
+			},
+			{
+				Path:        "/api/auth/mfa/enroll/confirm",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: false,
+			},
+			// Password reset (no auth required)
+			{
+				Path:        "/api/auth/password/forgot",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: false,
+			},
+			{
+				Path:        "/api/auth/password/reset",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: false,
+			},
+			// Invitation acceptance (no auth required)
+			{
+				Path:        "/api/auth/invitation",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: false,
+			},
+			{
+				Path:        "/api/auth/invitation/accept",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: false,
+			},
+			// MFA management (auth required)
+			{
+				Path:        "/api/auth/mfa",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			{
+				Path:        "/api/auth/mfa/setup",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			{
+				Path:        "/api/auth/mfa/activate",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			{
+				Path:        "/api/auth/mfa/recovery-codes",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			{
+				Path:        "/api/auth/mfa/disable",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			// Session management (auth required)
+			{
+				Path:        "/api/auth/sessions",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			{
+				Path:        "/api/auth/sessions/:sessionId",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			// Caller's current permissions and the permission catalog (auth required)
+			{
+				Path:        "/api/auth/permissions",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			{
+				Path:        "/api/permissions",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			// API key exchange for integrations (no auth required, the key is the credential)
+			{
+				Path:        "/api/auth/api-key/token",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: false,
+			},
+			// Personal API keys (auth required)
+			{
+				Path:        "/api/auth/api-keys",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			{
+				Path:        "/api/auth/api-keys/*path",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			// Service accounts and their API keys (service_account.manage)
+			{
+				Path:        "/api/service-accounts",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.ServiceAccountManage},
+			},
+			{
+				Path:        "/api/service-accounts/*path",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.ServiceAccountManage},
+			},
+			// Entities of the caller and switching between them (auth required)
+			{
+				Path:        "/api/auth/entities",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			{
+				Path:        "/api/auth/switch-entity",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			// Members from other entities (user.read or user.invite, changes need user.manage)
+			{
+				Path:        "/api/memberships",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserRead, rbac.UserInvite},
+			},
+			{
+				Path:        "/api/memberships/:userId",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserManage},
+			},
+			// Departments (readable by every user of the entity, checked per route by the service
+			// since heads assign members without department.manage)
+			{
+				Path:        "/api/departments",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			{
+				Path:        "/api/departments/*path",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+			},
+			// Custom roles (role.manage)
+			{
+				Path:        "/api/roles",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.RoleManage},
+			},
+			{
+				Path:        "/api/roles/*path",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.RoleManage},
+			},
+			// Staff invitations (user.invite)
+			{
+				Path:        "/api/invitations",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserInvite},
+			},
+			{
+				Path:        "/api/invitations/*path",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserInvite},
+			},
+			// Entity lifecycle (platform super admins)
+			{
+				Path:        "/api/platform/entities",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.PlatformManage},
+			},
+			{
+				Path:        "/api/platform/entities/*path",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.PlatformManage},
 			},
 			// entities routes (auth required)
 			{
@@ -86,7 +315,6 @@ func LoadConfig() *Config {
 				Service:     "user-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin", "doctor", "nurse", "staff"},
 			},
 			// User routes (auth required)
 			{
@@ -94,14 +322,62 @@ func LoadConfig() *Config {
 				Service:     "user-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin", "doctor", "nurse", "staff"},
 			},
 			{
 				Path:        "/api/users/",
 				Service:     "user-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin"},
+				Permissions: []string{rbac.UserRead},
+			},
+			{
+				Path:        "/api/users/:id/sessions",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserRead, rbac.UserManage},
+			},
+			{
+				Path:        "/api/users/:id/lockout",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserRead},
+			},
+			{
+				Path:        "/api/users/:id/unlock",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserManage},
+			},
+			{
+				Path:        "/api/security-events",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.SecurityAudit},
+			},
+			{
+				Path:        "/api/users/:id/permissions",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserRead},
+			},
+			{
+				Path:        "/api/users/:id/role",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserManage},
+			},
+			{
+				Path:        "/api/users/:id/departments",
+				Service:     "user-service",
+				StripPrefix: false,
+				AuthRequired: true,
+				Permissions: []string{rbac.UserRead},
 			},
 			// Patient routes (auth required)
 			{
@@ -109,7 +385,6 @@ func LoadConfig() *Config {
 				Service:     "patient-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin", "doctor", "nurse", "staff"},
 			},
 			// Appointment routes (auth required)
 			{
@@ -117,15 +392,14 @@ func LoadConfig() *Config {
 				Service:     "appointment-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin", "doctor", "nurse", "staff"},
 			},
-			// Admin routes for appointment service (admin only)
+			// Admin routes for appointment service (schedule.manage)
 			{
 				Path:        "/api/admin/*path",
 				Service:     "appointment-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin"},
+				Permissions: []string{rbac.ScheduleManage},
 			},
 			// Doctor routes for appointment service (auth required)
 			{
@@ -133,7 +407,7 @@ func LoadConfig() *Config {
 				Service:     "appointment-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin", "doctor"},
+				Permissions: []string{rbac.AppointmentRead},
 			},
 			// Availability routes for appointment service (auth required)
 			{
@@ -141,7 +415,6 @@ func LoadConfig() *Config {
 				Service:     "appointment-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin", "doctor", "nurse", "staff"},
 			},
 			// Form configuration routes (admin only)
 			{
@@ -149,8 +422,6 @@ func LoadConfig() *Config {
 				Service:     "user-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin", "doctor", "nurse", "staff"},
-
 			},
 			// Internationalization routes (auth required)
 			{
@@ -158,7 +429,6 @@ func LoadConfig() *Config {
 				Service:     "user-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin", "doctor", "nurse", "staff"},
 			},
 			// Location routes (no auth required)
 			{
@@ -166,7 +436,6 @@ func LoadConfig() *Config {
 				Service:     "location-service",
 				StripPrefix: false,
 				AuthRequired: false,
-				RolesAllowed: []string{},
 			},
 			// Config public routes (no auth)
 			{
@@ -174,35 +443,37 @@ func LoadConfig() *Config {
 				Service: "config-service",
 				StripPrefix: false,
 				AuthRequired: false,
-				RolesAllowed: []string{},
 			},
 			{
 				Path: "/api/config/public/*path",
 				Service: "config-service",
 				StripPrefix: false,
 				AuthRequired: false,
-				RolesAllowed: []string{},
 			},
-			// Config admin routes (auth required, admin only)
+			// Config admin routes (config.manage)
 			{
 				Path: "/api/config/admin/*path",
 				Service: "config-service",
 				StripPrefix: false,
 				AuthRequired: true,
-				RolesAllowed: []string{"admin"},
+				Permissions: []string{rbac.ConfigManage},
 			},
 		},
 		RateLimit: RateLimitConfig{
 			RequestsPerMinute: getEnvInt("RATE_LIMIT_RPM", 100),
 			BurstSize:         getEnvInt("RATE_LIMIT_BURST", 20),
 		},
+		APIKeyRateLimit: RateLimitConfig{
+			RequestsPerMinute: getEnvInt("API_KEY_RATE_LIMIT_RPM", 600),
+			BurstSize:         getEnvInt("API_KEY_RATE_LIMIT_BURST", 100),
+		},
 		JWTSecret: getEnv("JWT_SECRET", "your-very-secure-secret-key-change-in-production"),
 		Timeout:   time.Duration(getEnvInt("GATEWAY_TIMEOUT", 30)) * time.Second,
 		LogLevel:  getEnv("LOG_LEVEL", "info"),
 		CORS: CORSConfig{
 			AllowedOrigins: strings.Split(getEnv("ALLOWED_ORIGINS", "*"), ","),
 			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-			AllowedHeaders: []string{"Content-Type", "Authorization", "X-User-ID", "X-User-Email", "X-User-Role", "X-Healthcare-Entity-ID", "sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform", "User-Agent", "Accept", "Referer"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", "X-User-ID", "X-User-Email", "X-User-Role", "X-Healthcare-Entity-ID", "sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform", "User-Agent", "Accept", "Referer"},
 		},
 	}
 
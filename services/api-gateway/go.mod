@@ -8,6 +8,7 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/joho/godotenv v1.5.1
 	github.com/louhibi/healthcare-logging v0.1.0
+	github.com/louhibi/healthcare-rbac v0.1.0
 )
 
 require (
@@ -36,3 +37,5 @@ require (
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/louhibi/healthcare-rbac => ../pkg/rbac
@@ -111,6 +111,7 @@ func (le *LocationEnhancer) shouldEnhanceResponse(path, method string) bool {
 	// Enhance responses from services that return location data
 	enhancePaths := []string{
 		"/api/users/healthcare-entities",  // Healthcare entity endpoints
+		"/api/platform/entities",          // Entities listed to platform super admins
 		"/api/patients",                   // Patient endpoints
 		"/api/internal/healthcare-entities", // Internal healthcare entity endpoints
 	}
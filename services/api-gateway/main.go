@@ -34,6 +34,13 @@ func main() {
 	
 	proxyService := NewProxyService(config, stats, locationEnhancer)
 
+	// MFA policies are read from user-service
+	mfaPolicy := NewMFAPolicyChecker(config.Services["user-service"].BaseURL)
+
+	// API keys are exchanged with user-service and rate limited per key
+	apiKeys := NewAPIKeyAuthenticator(config)
+	defer apiKeys.Stop()
+
 	// Setup router
 	router := gin.Default()
 
@@ -43,7 +50,7 @@ func main() {
 	// Global middleware
 	router.Use(corsMiddleware(config.CORS))
 	router.Use(logging.RequestLoggingMiddleware())
-	router.Use(rateLimiter.RateLimitMiddleware())
+	router.Use(rateLimiter.RateLimitMiddleware(apiKeys.Handles))
 
 	// Gateway health check
 	router.GET("/health", proxyService.HealthCheckHandler())
@@ -63,6 +70,7 @@ func main() {
 				"services":   len(config.Services),
 				"routes":     len(config.Routes),
 				"rate_limit": config.RateLimit,
+				"api_key_rate_limit": config.APIKeyRateLimit,
 			},
 		})
 	})
@@ -75,7 +83,7 @@ func main() {
 
 	// Setup routes with authentication
 	for _, route := range config.Routes {
-		setupRoute(router, route, config.JWTSecret, proxyService)
+		setupRoute(router, route, config.JWTSecret, mfaPolicy, apiKeys, proxyService)
 	}
 
 	// Catch-all route for undefined paths
@@ -101,16 +109,18 @@ func main() {
 }
 
 // setupRoute sets up a route with appropriate middleware
-func setupRoute(router *gin.Engine, route RouteConfig, jwtSecret string, proxyService *ProxyService) {
+func setupRoute(router *gin.Engine, route RouteConfig, jwtSecret string, mfaPolicy *MFAPolicyChecker, apiKeys *APIKeyAuthenticator,
+	proxyService *ProxyService) {
 	var handlers []gin.HandlerFunc
 
 	// Add authentication middleware if required
 	if route.AuthRequired {
-		handlers = append(handlers, AuthMiddleware(jwtSecret))
+		handlers = append(handlers, AuthMiddleware(jwtSecret, mfaPolicy, apiKeys))
 		
-		// Add role-based authorization if roles are specified
-		if len(route.RolesAllowed) > 0 {
-			handlers = append(handlers, RoleMiddleware(route.RolesAllowed))
+		// Add permission-based authorization if permissions are specified; services check
+		// finer permissions per handler
+		if len(route.Permissions) > 0 {
+			handlers = append(handlers, PermissionMiddleware(route.Permissions))
 		}
 	}
 
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// mfaPolicyTTL is how long an entity's MFA policy is cached; policy changes apply to tokens
+// without the mfa claim after at most this delay
+const mfaPolicyTTL = time.Minute
+
+// MFAPolicyChecker tells whether an entity's policy requires MFA for a role. Policies are read
+// from user-service and cached per entity.
+type MFAPolicyChecker struct {
+	client         *resty.Client
+	userServiceURL string
+
+	mu       sync.Mutex
+	policies map[int]cachedMFAPolicy
+}
+
+type cachedMFAPolicy struct {
+	requiredRoles []string
+	fetchedAt     time.Time
+}
+
+// mfaPolicyResponse is the part of user-service's MFA policy the gateway needs
+type mfaPolicyResponse struct {
+	RequiredRoles []string `json:"required_roles"`
+}
+
+// NewMFAPolicyChecker creates a policy checker reading from user-service
+func NewMFAPolicyChecker(userServiceURL string) *MFAPolicyChecker {
+	client := resty.New()
+	client.SetTimeout(5 * time.Second)
+	client.SetRetryCount(1)
+
+	return &MFAPolicyChecker{
+		client:         client,
+		userServiceURL: userServiceURL,
+		policies:       make(map[int]cachedMFAPolicy),
+	}
+}
+
+// RequiresMFA reports whether the policy of the entity requires MFA for the role. The policy
+// is fetched with the caller's authorization header, which user-service accepts for the
+// caller's own entity. A stale policy is used when user-service can't be reached.
+func (m *MFAPolicyChecker) RequiresMFA(entityID int, role, authHeader string) (bool, error) {
+	m.mu.Lock()
+	cached, found := m.policies[entityID]
+	m.mu.Unlock()
+
+	if !found || time.Since(cached.fetchedAt) > mfaPolicyTTL {
+		var policy mfaPolicyResponse
+		resp, err := m.client.R().
+			SetHeader("Authorization", authHeader).
+			SetResult(&policy).
+			Get(fmt.Sprintf("%s/api/entities/%d/mfa-policy", m.userServiceURL, entityID))
+		if err == nil && resp.IsError() {
+			err = fmt.Errorf("user-service returned %d", resp.StatusCode())
+		}
+		if err != nil {
+			if !found {
+				return false, err
+			}
+			logging.LogWarn("Using stale MFA policy", "healthcare_entity_id", entityID, "error", err)
+		} else {
+			cached = cachedMFAPolicy{requiredRoles: policy.RequiredRoles, fetchedAt: time.Now()}
+			m.mu.Lock()
+			m.policies[entityID] = cached
+			m.mu.Unlock()
+		}
+	}
+
+	for _, requiredRole := range cached.requiredRoles {
+		if requiredRole == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
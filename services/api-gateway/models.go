@@ -17,7 +17,7 @@ type RouteConfig struct {
 	Service     string `json:"service"`
 	StripPrefix bool   `json:"strip_prefix"`
 	AuthRequired bool  `json:"auth_required"`
-	RolesAllowed []string `json:"roles_allowed"`
+	Permissions []string `json:"permissions"` // Any of them is required; none means authentication is enough
 }
 
 // UserClaims represents JWT claims
@@ -26,6 +26,9 @@ type UserClaims struct {
 	Email               string `json:"email"`
 	Role                string `json:"role"`
 	HealthcareEntityID  int    `json:"healthcare_entity_id"`
+	MFA                 bool   `json:"mfa"`
+	Permissions         []string `json:"permissions"`
+	APIKeyID            int      `json:"api_key_id"` // Set on tokens exchanged for an API key
 }
 
 // ProxyRequest represents a request to be proxied
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-resty/resty/v2"
+	rbac "github.com/louhibi/healthcare-rbac"
 )
 
 type ProxyService struct {
@@ -154,15 +155,33 @@ func (p *ProxyService) proxyRequest(c *gin.Context, route *RouteConfig, startTim
 		req.SetBody(requestBody)
 	}
 
-	// Add user information to headers for downstream services
+	// Pass on the client IP resolved from trusted proxies, replacing whatever the client sent;
+	// services key login throttling and session records on it
+	req.SetHeader("X-Forwarded-For", c.ClientIP())
+	req.SetHeader("X-Real-IP", c.ClientIP())
+
+	// Add user information to headers for downstream services. They only come from verified
+	// claims: headers of the same name sent by the client are dropped.
 	if userID, exists := c.Get("user_id"); exists {
 		req.SetHeader("X-User-ID", strconv.Itoa(userID.(int)))
+	} else {
+		req.Header.Del("X-User-ID")
 	}
 	if userEmail, exists := c.Get("user_email"); exists {
 		req.SetHeader("X-User-Email", userEmail.(string))
+	} else {
+		req.Header.Del("X-User-Email")
 	}
 	if userRole, exists := c.Get("user_role"); exists {
 		req.SetHeader("X-User-Role", userRole.(string))
+	} else {
+		req.Header.Del("X-User-Role")
+	}
+	// Services authorize on the permissions header, which only the gateway may set
+	if permissions, exists := c.Get("user_permissions"); exists {
+		req.SetHeader(rbac.Header, strings.Join(permissions.([]string), ","))
+	} else {
+		req.Header.Del(rbac.Header)
 	}
 
 	// Execute request
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headerEchoServer answers every request with the headers it received
+func headerEchoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.Header)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProxyForwardsIdentityHeadersOnlyFromClaims(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	backend := headerEchoServer(t)
+	config := &Config{
+		Services: map[string]ServiceConfig{"backend": {Name: "backend", BaseURL: backend.URL}},
+		Timeout:  5 * time.Second,
+	}
+	proxy := NewProxyService(config, NewStatsCollector(), nil)
+	route := RouteConfig{Path: "/api/test", Service: "backend"}
+
+	spoofed := map[string]string{
+		"X-User-ID":          "1",
+		"X-User-Email":       "admin@example.com",
+		"X-User-Role":        "admin",
+		"X-User-Permissions": "user.manage",
+	}
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   map[string]string
+	}{
+		{
+			name: "unauthenticated route drops client identity headers",
+			want: map[string]string{"X-User-Id": "", "X-User-Email": "", "X-User-Role": "", "X-User-Permissions": ""},
+		},
+		{
+			name: "authenticated route replaces them with the claims",
+			claims: map[string]interface{}{
+				"user_id": 42, "user_email": "nurse@example.com", "user_role": "nurse",
+				"user_permissions": []string{"patient.read"},
+			},
+			want: map[string]string{"X-User-Id": "42", "X-User-Email": "nurse@example.com", "X-User-Role": "nurse", "X-User-Permissions": "patient.read"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/api/test", func(c *gin.Context) {
+				for key, value := range tt.claims {
+					c.Set(key, value)
+				}
+				proxy.proxyRequest(c, &route, time.Now())
+			})
+			req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+			for key, value := range spoofed {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+			}
+
+			var received http.Header
+			if err := json.Unmarshal(w.Body.Bytes(), &received); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if got := received.Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
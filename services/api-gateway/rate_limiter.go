@@ -38,9 +38,15 @@ func NewRateLimiter(config RateLimitConfig) *RateLimiter {
 	return rl
 }
 
-// RateLimitMiddleware returns a rate limiting middleware
-func (rl *RateLimiter) RateLimitMiddleware() gin.HandlerFunc {
+// RateLimitMiddleware returns a rate limiting middleware. Requests for which skip returns
+// true are limited elsewhere.
+func (rl *RateLimiter) RateLimitMiddleware(skip func(c *gin.Context) bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if skip(c) {
+			c.Next()
+			return
+		}
+
 		// Use IP address as the key for rate limiting
 		clientIP := c.ClientIP()
 		
@@ -9,18 +9,31 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+// timezoneCacheTTL is how long an entity's timezone is used before it is fetched again, so
+// that timezone changes in user-service are picked up
+const timezoneCacheTTL = 5 * time.Minute
+
+type cachedTimezone struct {
+	converter *TimezoneConverter
+	expiresAt time.Time
+}
+
 type AppointmentService struct {
 	db             *sql.DB
-	timezoneCache  map[int]*TimezoneConverter // Cache for entity timezone converters
+	timezoneMu     sync.Mutex
+	timezoneCache  map[int]cachedTimezone // Cache for entity timezone converters
 }
 
 func NewAppointmentService(db *sql.DB) *AppointmentService {
 	return &AppointmentService{
 		db:            db,
-		timezoneCache: make(map[int]*TimezoneConverter),
+		timezoneCache: make(map[int]cachedTimezone),
 	}
 }
 
@@ -148,13 +161,22 @@ func (s *AppointmentService) GetAppointmentByID(id int) (*Appointment, error) {
 }
 
 // UpdateAppointment updates appointment information
-func (s *AppointmentService) UpdateAppointment(appointment *Appointment) error {
+func (s *AppointmentService) UpdateAppointment(appointment *Appointment, updatedBy int) error {
 	// Get room ID from appointment
 	roomID := 0
 	if appointment.RoomID.Valid {
 		roomID = int(appointment.RoomID.Int32)
 	}
 
+	// A signed encounter note documents this patient's visit with this doctor
+	patientID, doctorID, signed, err := s.signedNoteOf(appointment.ID)
+	if err != nil {
+		return err
+	}
+	if signed && (appointment.Status != "completed" || appointment.PatientID != patientID || appointment.DoctorID != doctorID) {
+		return ErrAppointmentHasSignedNote
+	}
+
 	// Check for conflicts if date/time or duration changed
 	hasConflict, err := s.CheckConflict(ConflictCheck{
 		DoctorID:           appointment.DoctorID,
@@ -175,7 +197,7 @@ func (s *AppointmentService) UpdateAppointment(appointment *Appointment) error {
 		UPDATE appointments SET
 			patient_id = $1, doctor_id = $2, date_time = $3, duration = $4,
 			type = $5, status = $6, reason = $7, notes = $8, priority = $9, room_id = $10,
-			updated_at = CURRENT_TIMESTAMP
+			updated_by = NULLIF($12, 0), updated_at = CURRENT_TIMESTAMP
 		WHERE id = $11 AND is_active = true
 		RETURNING updated_at
 	`
@@ -193,6 +215,7 @@ func (s *AppointmentService) UpdateAppointment(appointment *Appointment) error {
 		appointment.Priority,
 		appointment.RoomID,
 		appointment.ID,
+		updatedBy,
 	).Scan(&appointment.UpdatedAt)
 
 	if err != nil {
@@ -206,14 +229,22 @@ func (s *AppointmentService) UpdateAppointment(appointment *Appointment) error {
 }
 
 // UpdateAppointmentStatus updates only the appointment status and notes
-func (s *AppointmentService) UpdateAppointmentStatus(id int, status, notes string) error {
+func (s *AppointmentService) UpdateAppointmentStatus(id int, status, notes string, updatedBy int) error {
+	if status != "completed" {
+		if _, _, signed, err := s.signedNoteOf(id); err != nil {
+			return err
+		} else if signed {
+			return ErrAppointmentHasSignedNote
+		}
+	}
+
 	query := `
 		UPDATE appointments SET
-			status = $1, notes = $2, updated_at = CURRENT_TIMESTAMP
+			status = $1, notes = $2, updated_by = NULLIF($4, 0), updated_at = CURRENT_TIMESTAMP
 		WHERE id = $3 AND is_active = true
 	`
 
-	result, err := s.db.Exec(query, status, notes, id)
+	result, err := s.db.Exec(query, status, notes, id, updatedBy)
 	if err != nil {
 		return err
 	}
@@ -286,6 +317,14 @@ func (s *AppointmentService) GetAppointments(search AppointmentSearch) ([]Appoin
 		argIndex++
 	}
 
+	if search.DepartmentIDs != nil {
+		conditions = append(conditions, fmt.Sprintf(
+			"(doctor_id = ANY($%d) OR room_id IN (SELECT id FROM rooms WHERE department_id = ANY($%d)))",
+			argIndex, argIndex+1))
+		args = append(args, pq.Array(search.DepartmentDoctorIDs), pq.Array(search.DepartmentIDs))
+		argIndex += 2
+	}
+
 	// Build final query
 	finalQuery := baseQuery
 	if len(conditions) > 0 {
@@ -342,15 +381,50 @@ func (s *AppointmentService) GetAppointments(search AppointmentSearch) ([]Appoin
 	return appointments, nil
 }
 
+// GetLastAppointmentDates returns the date of the most recent appointment of each given patient,
+// ignoring cancelled appointments. Patients without appointments are absent from the result.
+func (s *AppointmentService) GetLastAppointmentDates(healthcareEntityID int, patientIDs []int) (map[int]time.Time, error) {
+	query := `
+		SELECT patient_id, MAX(date_time)
+		FROM appointments
+		WHERE healthcare_entity_id = $1 AND patient_id = ANY($2) AND is_active = true AND status <> 'cancelled'
+		GROUP BY patient_id
+	`
+
+	rows, err := s.db.Query(query, healthcareEntityID, pq.Array(patientIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastDates := make(map[int]time.Time)
+	for rows.Next() {
+		var patientID int
+		var lastDate time.Time
+		if err := rows.Scan(&patientID, &lastDate); err != nil {
+			return nil, err
+		}
+		lastDates[patientID] = lastDate
+	}
+
+	return lastDates, rows.Err()
+}
+
 // DeleteAppointment soft deletes an appointment
-func (s *AppointmentService) DeleteAppointment(id int) error {
+func (s *AppointmentService) DeleteAppointment(id int, deletedBy int) error {
+	if _, _, signed, err := s.signedNoteOf(id); err != nil {
+		return err
+	} else if signed {
+		return ErrAppointmentHasSignedNote
+	}
+
 	query := `
 		UPDATE appointments
-		SET is_active = false, updated_at = CURRENT_TIMESTAMP
+		SET is_active = false, updated_by = NULLIF($2, 0), updated_at = CURRENT_TIMESTAMP
 		WHERE id = $1 AND is_active = true
 	`
 
-	result, err := s.db.Exec(query, id)
+	result, err := s.db.Exec(query, id, deletedBy)
 	if err != nil {
 		return err
 	}
@@ -918,22 +992,27 @@ func (s *AppointmentService) fetchDoctorsFromUserService(healthcareEntityID int)
 // GetTimezoneConverter gets or creates a timezone converter for a healthcare entity
 func (s *AppointmentService) GetTimezoneConverter(healthcareEntityID int) (*TimezoneConverter, error) {
 	// Check cache first
-	if converter, exists := s.timezoneCache[healthcareEntityID]; exists {
-		return converter, nil
+	s.timezoneMu.Lock()
+	cached, exists := s.timezoneCache[healthcareEntityID]
+	s.timezoneMu.Unlock()
+	if exists && time.Now().Before(cached.expiresAt) {
+		return cached.converter, nil
 	}
 	
 	// Fetch timezone from user service
+	var converter *TimezoneConverter
 	timezoneInfo, err := s.fetchEntityTimezone(healthcareEntityID)
 	if err != nil {
 		// Fallback to UTC if we can't fetch timezone
-		converter := NewTimezoneConverter("UTC")
-		s.timezoneCache[healthcareEntityID] = converter
-		return converter, nil
+		converter = NewTimezoneConverter("UTC")
+	} else {
+		converter = NewTimezoneConverter(timezoneInfo.Timezone)
 	}
 	
-	// Create and cache the converter
-	converter := NewTimezoneConverter(timezoneInfo.Timezone)
-	s.timezoneCache[healthcareEntityID] = converter
+	// Cache the converter
+	s.timezoneMu.Lock()
+	s.timezoneCache[healthcareEntityID] = cachedTimezone{converter: converter, expiresAt: time.Now().Add(timezoneCacheTTL)}
+	s.timezoneMu.Unlock()
 	return converter, nil
 }
 
@@ -1589,10 +1668,10 @@ func (s *AppointmentService) GetDefaultDurationForType(healthcareEntityID int, a
 
 // Room Management Service Methods
 
-// GetRooms gets all rooms for a healthcare entity
-func (s *AppointmentService) GetRooms(healthcareEntityID int, roomType string, floor int) ([]RoomResponse, error) {
+// GetRooms gets all rooms for a healthcare entity, optionally only those of the given departments
+func (s *AppointmentService) GetRooms(healthcareEntityID int, roomType string, floor int, departmentIDs []int) ([]RoomResponse, error) {
 	query := `
-		SELECT id, healthcare_entity_id, room_number, room_name, room_type, floor, department, capacity, equipment, is_active, notes, created_at, updated_at
+		SELECT id, healthcare_entity_id, room_number, room_name, room_type, floor, department, department_id, capacity, equipment, is_active, notes, created_at, updated_at
 		FROM rooms
 		WHERE healthcare_entity_id = $1 AND is_active = true
 	`
@@ -1612,6 +1691,12 @@ func (s *AppointmentService) GetRooms(healthcareEntityID int, roomType string, f
 		args = append(args, floor)
 	}
 
+	if departmentIDs != nil {
+		argCount++
+		query += fmt.Sprintf(" AND department_id = ANY($%d)", argCount)
+		args = append(args, pq.Array(departmentIDs))
+	}
+
 	query += " ORDER BY floor, room_number"
 
 	rows, err := s.db.Query(query, args...)
@@ -1631,6 +1716,7 @@ func (s *AppointmentService) GetRooms(healthcareEntityID int, roomType string, f
 			&room.RoomType,
 			&room.Floor,
 			&room.Department,
+			&room.DepartmentID,
 			&room.Capacity,
 			&room.Equipment,
 			&room.IsActive,
@@ -1654,7 +1740,7 @@ func (s *AppointmentService) GetRooms(healthcareEntityID int, roomType string, f
 // GetRoomByID gets room by ID and healthcare entity ID
 func (s *AppointmentService) GetRoomByID(roomID, healthcareEntityID int) (*Room, error) {
 	query := `
-		SELECT id, healthcare_entity_id, room_number, room_name, room_type, floor, department, capacity, equipment, is_active, notes, created_at, updated_at
+		SELECT id, healthcare_entity_id, room_number, room_name, room_type, floor, department, department_id, capacity, equipment, is_active, notes, created_at, updated_at
 		FROM rooms
 		WHERE id = $1 AND healthcare_entity_id = $2
 	`
@@ -1668,6 +1754,7 @@ func (s *AppointmentService) GetRoomByID(roomID, healthcareEntityID int) (*Room,
 		&room.RoomType,
 		&room.Floor,
 		&room.Department,
+		&room.DepartmentID,
 		&room.Capacity,
 		&room.Equipment,
 		&room.IsActive,
@@ -1695,6 +1782,7 @@ func (s *AppointmentService) CreateRoom(healthcareEntityID, userID int, req Room
 		RoomType:           req.RoomType,
 		Floor:              req.Floor,
 		Department:         sql.NullString{String: req.Department, Valid: req.Department != ""},
+		DepartmentID:       nullInt32(req.DepartmentID),
 		Capacity:           req.Capacity,
 		Equipment:          sql.NullString{String: equipmentStr, Valid: equipmentStr != ""},
 		IsActive:           true,
@@ -1704,9 +1792,9 @@ func (s *AppointmentService) CreateRoom(healthcareEntityID, userID int, req Room
 
 	query := `
 		INSERT INTO rooms (
-			healthcare_entity_id, room_number, room_name, room_type, floor, department, capacity, equipment, is_active, notes, created_at, updated_at, created_by
+			healthcare_entity_id, room_number, room_name, room_type, floor, department, department_id, capacity, equipment, is_active, notes, created_at, updated_at, created_by
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14
 		) RETURNING id, created_at, updated_at
 	`
 
@@ -1719,6 +1807,7 @@ func (s *AppointmentService) CreateRoom(healthcareEntityID, userID int, req Room
 		room.RoomType,
 		room.Floor,
 		room.Department,
+		room.DepartmentID,
 		room.Capacity,
 		room.Equipment,
 		room.IsActive,
@@ -1743,9 +1832,9 @@ func (s *AppointmentService) UpdateRoom(roomID int, req RoomRequest) (*RoomRespo
 	query := `
 		UPDATE rooms SET
 			room_number = $1, room_name = $2, room_type = $3, floor = $4, department = $5,
-			capacity = $6, equipment = $7, notes = $8, updated_at = $9
+			capacity = $6, equipment = $7, notes = $8, updated_at = $9, department_id = $11
 		WHERE id = $10 AND is_active = true
-		RETURNING id, healthcare_entity_id, room_number, room_name, room_type, floor, department, capacity, equipment, is_active, notes, created_at, updated_at
+		RETURNING id, healthcare_entity_id, room_number, room_name, room_type, floor, department, department_id, capacity, equipment, is_active, notes, created_at, updated_at
 	`
 
 	var room Room
@@ -1761,6 +1850,7 @@ func (s *AppointmentService) UpdateRoom(roomID int, req RoomRequest) (*RoomRespo
 		req.Notes,
 		time.Now(),
 		roomID,
+		req.DepartmentID,
 	).Scan(
 		&room.ID,
 		&room.HealthcareEntityID,
@@ -1769,6 +1859,7 @@ func (s *AppointmentService) UpdateRoom(roomID int, req RoomRequest) (*RoomRespo
 		&room.RoomType,
 		&room.Floor,
 		&room.Department,
+		&room.DepartmentID,
 		&room.Capacity,
 		&room.Equipment,
 		&room.IsActive,
@@ -1819,7 +1910,7 @@ func (s *AppointmentService) GetAvailableRooms(healthcareEntityID int, dateTime
 
 	query := `
 		SELECT 
-			r.id, r.healthcare_entity_id, r.room_number, r.room_name, r.room_type, r.floor, r.department, r.capacity, r.equipment, r.is_active, r.notes, r.created_at, r.updated_at,
+			r.id, r.healthcare_entity_id, r.room_number, r.room_name, r.room_type, r.floor, r.department, r.department_id, r.capacity, r.equipment, r.is_active, r.notes, r.created_at, r.updated_at,
 			CASE 
 				WHEN EXISTS (
 					SELECT 1 FROM appointments a
@@ -1859,6 +1950,7 @@ func (s *AppointmentService) GetAvailableRooms(healthcareEntityID int, dateTime
 			&room.RoomType,
 			&room.Floor,
 			&room.Department,
+			&room.DepartmentID,
 			&room.Capacity,
 			&room.Equipment,
 			&room.IsActive,
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// GetPatientTimeline lists the appointment events of a patient, most recent first, with the
+// total number of events: appointments scheduled and deleted, status changes and signed
+// encounter notes
+func (s *AppointmentService) GetPatientTimeline(search AppointmentTimelineSearch) ([]AppointmentTimelineEvent, int, error) {
+	rows, err := s.db.Query(`
+		WITH patient_appointments AS (
+			SELECT id, doctor_id, date_time, type, status, reason, is_active, created_at, created_by, updated_at, updated_by
+			FROM appointments
+			WHERE healthcare_entity_id = $1 AND patient_id = $2
+		), events AS (
+			SELECT 'appointment:' || a.id AS id, a.created_at AS occurred_at, 'appointment.scheduled' AS type,
+				a.created_by AS actor_id, a.id AS appointment_id, NULL::VARCHAR AS from_status, NULL::VARCHAR AS to_status
+			FROM patient_appointments a
+			UNION ALL
+			SELECT 'appointment-status:' || h.id, h.changed_at, 'appointment.status_changed',
+				h.changed_by, h.appointment_id, h.from_status, h.to_status
+			FROM appointment_status_history h JOIN patient_appointments a ON a.id = h.appointment_id
+			UNION ALL
+			SELECT 'appointment-deleted:' || a.id, a.updated_at, 'appointment.deleted', a.updated_by, a.id, NULL, NULL
+			FROM patient_appointments a WHERE NOT a.is_active
+			UNION ALL
+			SELECT 'encounter-note:' || n.id, n.signed_at, 'encounter_note.signed', n.signed_by, n.appointment_id, NULL, NULL
+			FROM encounter_notes n JOIN patient_appointments a ON a.id = n.appointment_id
+			WHERE n.signed_at IS NOT NULL
+		)
+		SELECT e.id, e.occurred_at, e.type, e.actor_id, e.appointment_id, e.from_status, e.to_status,
+			a.doctor_id, a.date_time, a.type, a.status, a.reason, COUNT(*) OVER ()
+		FROM events e JOIN patient_appointments a ON a.id = e.appointment_id
+		WHERE ($3::TIMESTAMP IS NULL OR e.occurred_at >= $3) AND ($4::TIMESTAMP IS NULL OR e.occurred_at <= $4)
+		  AND ($7::TEXT[] IS NULL OR split_part(e.type, '.', 1) = ANY($7))
+		ORDER BY e.occurred_at DESC, e.id DESC
+		LIMIT $5 OFFSET $6
+	`, search.HealthcareEntityID, search.PatientID, search.From, search.To, search.Limit, search.Offset, pq.Array(search.Categories))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []AppointmentTimelineEvent{}
+	total := 0
+	for rows.Next() {
+		var e AppointmentTimelineEvent
+		var fromStatus, toStatus *string
+		var doctorID int
+		var dateTime time.Time
+		var appointmentType, status, reason string
+		if err := rows.Scan(&e.ID, &e.OccurredAt, &e.Type, &e.ActorID, &e.AppointmentID, &fromStatus, &toStatus,
+			&doctorID, &dateTime, &appointmentType, &status, &reason, &total); err != nil {
+			return nil, 0, err
+		}
+
+		e.Category = strings.SplitN(e.Type, ".", 2)[0]
+		e.Details = map[string]interface{}{
+			"doctor_id":        doctorID,
+			"date_time":        dateTime,
+			"appointment_type": appointmentType,
+			"status":           status,
+			"reason":           reason,
+		}
+		switch e.Type {
+		case "appointment.scheduled":
+			e.Summary = fmt.Sprintf("%s scheduled for %s", strings.ToUpper(appointmentType[:1])+appointmentType[1:],
+				dateTime.UTC().Format("2006-01-02 15:04 UTC"))
+		case "appointment.status_changed":
+			e.Summary = fmt.Sprintf("Appointment status changed from %s to %s", *fromStatus, *toStatus)
+			e.Details["from_status"], e.Details["to_status"] = *fromStatus, *toStatus
+		case "appointment.deleted":
+			e.Summary = "Appointment deleted"
+		case "encounter_note.signed":
+			e.Summary = "Encounter note signed"
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
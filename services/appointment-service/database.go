@@ -649,6 +649,140 @@ func RunMigrations(db *sql.DB) error {
 		return err
 	}
 
+	// Run migration 14: Structured encounter notes (SOAP) with signature and addenda
+	if err := runMigration(db, 14, `
+		CREATE TABLE IF NOT EXISTS encounter_notes (
+			id SERIAL PRIMARY KEY,
+			appointment_id INTEGER NOT NULL UNIQUE REFERENCES appointments(id),
+			healthcare_entity_id INTEGER NOT NULL,
+			patient_id INTEGER NOT NULL,
+			doctor_id INTEGER NOT NULL,
+			subjective TEXT NOT NULL DEFAULT '',
+			objective TEXT NOT NULL DEFAULT '',
+			assessment TEXT NOT NULL DEFAULT '',
+			plan TEXT NOT NULL DEFAULT '',
+			status VARCHAR(20) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'signed', 'amended')),
+			signed_at TIMESTAMP,
+			signed_by INTEGER,
+			content_hash CHAR(64), -- SHA-256 of the sections at signature
+			search_vector tsvector,
+			created_by INTEGER NOT NULL,
+			updated_by INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK ((status = 'draft') = (signed_at IS NULL))
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_encounter_notes_patient ON encounter_notes(healthcare_entity_id, patient_id);
+		CREATE INDEX IF NOT EXISTS idx_encounter_notes_search ON encounter_notes USING GIN (search_vector);
+
+		-- Corrections after signature; an addendum is never changed
+		CREATE TABLE IF NOT EXISTS encounter_note_addenda (
+			id SERIAL PRIMARY KEY,
+			note_id INTEGER NOT NULL REFERENCES encounter_notes(id),
+			content TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			author_id INTEGER NOT NULL,
+			author_role VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_encounter_note_addenda_note ON encounter_note_addenda(note_id, created_at);
+
+		-- Signed sections cannot change, and search covers the sections (assessment and plan
+		-- weigh most) and the addenda
+		CREATE OR REPLACE FUNCTION encounter_notes_before_write()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			IF TG_OP = 'UPDATE' AND OLD.status <> 'draft' AND (
+				NEW.subjective IS DISTINCT FROM OLD.subjective OR NEW.objective IS DISTINCT FROM OLD.objective OR
+				NEW.assessment IS DISTINCT FROM OLD.assessment OR NEW.plan IS DISTINCT FROM OLD.plan OR
+				NEW.content_hash IS DISTINCT FROM OLD.content_hash OR NEW.status = 'draft') THEN
+				RAISE EXCEPTION 'encounter note % is signed and cannot be edited', OLD.id;
+			END IF;
+
+			NEW.search_vector :=
+				setweight(to_tsvector('simple', NEW.assessment), 'A') ||
+				setweight(to_tsvector('simple', NEW.plan), 'B') ||
+				setweight(to_tsvector('simple', NEW.subjective || ' ' || NEW.objective), 'C') ||
+				setweight(to_tsvector('simple', COALESCE((
+					SELECT string_agg(a.content, ' ') FROM encounter_note_addenda a WHERE a.note_id = NEW.id), '')), 'D');
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS encounter_notes_before_write ON encounter_notes;
+		CREATE TRIGGER encounter_notes_before_write
+			BEFORE INSERT OR UPDATE ON encounter_notes
+			FOR EACH ROW
+			EXECUTE FUNCTION encounter_notes_before_write();
+
+		DROP TRIGGER IF EXISTS update_encounter_notes_updated_at ON encounter_notes;
+		CREATE TRIGGER update_encounter_notes_updated_at
+			BEFORE UPDATE ON encounter_notes
+			FOR EACH ROW
+			EXECUTE FUNCTION update_updated_at_column();
+
+		CREATE OR REPLACE FUNCTION encounter_note_addenda_protect()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			RAISE EXCEPTION 'encounter note addenda cannot be changed or deleted';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS encounter_note_addenda_protect ON encounter_note_addenda;
+		CREATE TRIGGER encounter_note_addenda_protect
+			BEFORE UPDATE OR DELETE ON encounter_note_addenda
+			FOR EACH ROW
+			EXECUTE FUNCTION encounter_note_addenda_protect();
+	`); err != nil {
+		return err
+	}
+
+	// Run migration 15: Appointment status history for the patient timeline
+	if err := runMigration(db, 15, `
+		ALTER TABLE appointments ADD COLUMN IF NOT EXISTS updated_by INTEGER;
+
+		CREATE TABLE IF NOT EXISTS appointment_status_history (
+			id SERIAL PRIMARY KEY,
+			appointment_id INTEGER NOT NULL REFERENCES appointments(id),
+			from_status VARCHAR(50) NOT NULL,
+			to_status VARCHAR(50) NOT NULL,
+			changed_by INTEGER,
+			changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_appointment_status_history_appointment ON appointment_status_history(appointment_id, changed_at);
+
+		-- Every status change is recorded, whichever code path made it
+		CREATE OR REPLACE FUNCTION record_appointment_status_change()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			INSERT INTO appointment_status_history (appointment_id, from_status, to_status, changed_by)
+			VALUES (NEW.id, OLD.status, NEW.status, NEW.updated_by);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS record_appointment_status_change ON appointments;
+		CREATE TRIGGER record_appointment_status_change
+			AFTER UPDATE OF status ON appointments
+			FOR EACH ROW
+			WHEN (OLD.status IS DISTINCT FROM NEW.status)
+			EXECUTE FUNCTION record_appointment_status_change();
+	`); err != nil {
+		return err
+	}
+
+	// Run migration 16: Rooms belong to departments of the user-service
+	if err := runMigration(db, 16, `
+		ALTER TABLE rooms ADD COLUMN IF NOT EXISTS department_id INTEGER;
+
+		CREATE INDEX IF NOT EXISTS idx_rooms_department ON rooms(healthcare_entity_id, department_id);
+	`); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrDepartmentNotFound is returned when a department does not exist in the caller's entity
+var ErrDepartmentNotFound = errors.New("department not found")
+
+// Department is a department of the entity as the user-service returns it
+type Department struct {
+	ID        int    `json:"id"`
+	ParentID  *int   `json:"parent_id"`
+	Name      string `json:"name"`
+	IsActive  bool   `json:"is_active"`
+	MemberIDs []int  `json:"member_ids"`
+}
+
+// DepartmentScope is a department with its sub-departments and the users of all of them
+type DepartmentScope struct {
+	DepartmentIDs []int
+	UserIDs       []int
+}
+
+// DepartmentClient reads departments from the user-service on behalf of the current user
+type DepartmentClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewDepartmentClientFromEnv reads the service location from USER_SERVICE_URL
+func NewDepartmentClientFromEnv() *DepartmentClient {
+	baseURL := os.Getenv("USER_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://user-service:8081"
+	}
+	return &DepartmentClient{baseURL: baseURL, httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// forwardedIdentityHeaders are the headers that identify the caller to the user-service
+func forwardedIdentityHeaders(c *gin.Context) map[string]string {
+	return map[string]string{
+		"Authorization":          c.GetHeader("Authorization"),
+		"X-User-ID":              c.GetHeader("X-User-ID"),
+		"X-User-Role":            c.GetHeader("X-User-Role"),
+		"X-User-Permissions":     c.GetHeader("X-User-Permissions"),
+		"X-Healthcare-Entity-ID": c.GetHeader("X-Healthcare-Entity-ID"),
+	}
+}
+
+// get sends a GET request with the caller's identity headers and decodes a 200 response
+func (c *DepartmentClient) get(path string, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		if value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call user service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrDepartmentNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("user service returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode user service response: %w", err)
+	}
+	return nil
+}
+
+// GetScope returns a department of the caller's entity with its sub-departments and users
+func (c *DepartmentClient) GetScope(departmentID int, headers map[string]string) (*DepartmentScope, error) {
+	var response struct {
+		DepartmentIDs []int `json:"department_ids"`
+		Members       []struct {
+			UserID int `json:"user_id"`
+		} `json:"members"`
+	}
+	path := fmt.Sprintf("/api/departments/%d/members?include_subdepartments=true", departmentID)
+	if err := c.get(path, headers, &response); err != nil {
+		return nil, err
+	}
+
+	scope := &DepartmentScope{DepartmentIDs: response.DepartmentIDs, UserIDs: []int{}}
+	seen := map[int]bool{}
+	for _, member := range response.Members {
+		if !seen[member.UserID] {
+			seen[member.UserID] = true
+			scope.UserIDs = append(scope.UserIDs, member.UserID)
+		}
+	}
+	return scope, nil
+}
+
+// GetDepartment returns a department of the caller's entity
+func (c *DepartmentClient) GetDepartment(departmentID int, headers map[string]string) (*Department, error) {
+	var department Department
+	if err := c.get(fmt.Sprintf("/api/departments/%d", departmentID), headers, &department); err != nil {
+		return nil, err
+	}
+	return &department, nil
+}
+
+// ListDepartments returns the active departments of the caller's entity with their users
+func (c *DepartmentClient) ListDepartments(headers map[string]string) ([]Department, error) {
+	var response struct {
+		Departments []Department `json:"departments"`
+	}
+	if err := c.get("/api/departments?include_members=true", headers, &response); err != nil {
+		return nil, err
+	}
+	return response.Departments, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// maxDepartmentReportDays caps the period of a department report
+const maxDepartmentReportDays = 366
+
+// respondDepartmentLookupError answers a failed department lookup in the user-service
+func respondDepartmentLookupError(c *gin.Context, err error) {
+	if errors.Is(err, ErrDepartmentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+		return
+	}
+	logging.LogError("Failed to look up department", "error", err)
+	c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to look up department"})
+}
+
+// departmentScope reads the optional ?department_id= filter and resolves it to the department,
+// its sub-departments and their users. Returns nil without a filter.
+func (h *AppointmentHandler) departmentScope(c *gin.Context) (*DepartmentScope, bool) {
+	value := c.Query("department_id")
+	if value == "" {
+		return nil, true
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+		return nil, false
+	}
+	scope, err := h.departments.GetScope(id, forwardedIdentityHeaders(c))
+	if err != nil {
+		respondDepartmentLookupError(c, err)
+		return nil, false
+	}
+	return scope, true
+}
+
+// validateRoomDepartment checks the department of a room request is an active department of
+// the caller's entity
+func (h *AppointmentHandler) validateRoomDepartment(c *gin.Context, req RoomRequest) bool {
+	if req.DepartmentID == nil {
+		return true
+	}
+	department, err := h.departments.GetDepartment(*req.DepartmentID, forwardedIdentityHeaders(c))
+	if errors.Is(err, ErrDepartmentNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Department not found"})
+		return false
+	}
+	if err != nil {
+		respondDepartmentLookupError(c, err)
+		return false
+	}
+	if !department.IsActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Department is deactivated"})
+		return false
+	}
+	return true
+}
+
+// GetDepartmentReport handles GET /api/appointments/reports/departments - appointments per
+// department between ?date_from= and ?date_to= (YYYY-MM-DD, inclusive), the last 30 days by
+// default
+func (h *AppointmentHandler) GetDepartmentReport(c *gin.Context) {
+	healthcareEntityID, err := strconv.Atoi(c.GetHeader("X-Healthcare-Entity-ID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid healthcare entity ID"})
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -29), today
+	if value := c.Query("date_from"); value != "" {
+		if from, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date_from must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+	if value := c.Query("date_to"); value != "" {
+		if to, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date_to must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+	if to.Before(from) || to.Sub(from) >= maxDepartmentReportDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The period must end after it starts and last at most 366 days"})
+		return
+	}
+
+	departments, err := h.departments.ListDepartments(forwardedIdentityHeaders(c))
+	if err != nil {
+		respondDepartmentLookupError(c, err)
+		return
+	}
+	reports, err := h.service.GetDepartmentReport(healthcareEntityID, from, to.AddDate(0, 0, 1), departments)
+	if err != nil {
+		logging.LogError("Failed to build department report", "error", err, "healthcare_entity_id", healthcareEntityID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build department report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"date_from":   from.Format("2006-01-02"),
+			"date_to":     to.Format("2006-01-02"),
+			"departments": reports,
+		},
+		"message":   "Department report retrieved successfully",
+		"timestamp": time.Now().UTC(),
+	})
+}
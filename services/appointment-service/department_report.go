@@ -0,0 +1,124 @@
+package main
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// appointmentStatuses are the statuses counted by department reports, in the order of the
+// report query's columns
+var appointmentStatuses = [...]string{"scheduled", "confirmed", "in-progress", "completed", "cancelled", "no-show"}
+
+// departmentSubtrees returns, for each department, its ID and the IDs of its sub-departments
+// at every depth
+func departmentSubtrees(departments []Department) map[int][]int {
+	children := map[int][]int{}
+	for _, department := range departments {
+		if department.ParentID != nil {
+			children[*department.ParentID] = append(children[*department.ParentID], department.ID)
+		}
+	}
+
+	subtrees := map[int][]int{}
+	for _, department := range departments {
+		ids := []int{}
+		seen := map[int]bool{}
+		pending := []int{department.ID}
+		for len(pending) > 0 {
+			id := pending[0]
+			pending = pending[1:]
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			ids = append(ids, id)
+			pending = append(pending, children[id]...)
+		}
+		subtrees[department.ID] = ids
+	}
+	return subtrees
+}
+
+// GetDepartmentReport reports the appointments of each department of the entity between from
+// (inclusive) and to (exclusive). Departments come from the user-service with their members;
+// the figures of a department include its sub-departments.
+func (s *AppointmentService) GetDepartmentReport(healthcareEntityID int, from, to time.Time, departments []Department) ([]DepartmentReport, error) {
+	roomCounts := map[int]int{}
+	rows, err := s.db.Query(`
+		SELECT department_id, COUNT(*) FROM rooms
+		WHERE healthcare_entity_id = $1 AND is_active = true AND department_id IS NOT NULL
+		GROUP BY department_id
+	`, healthcareEntityID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var departmentID, count int
+		if err := rows.Scan(&departmentID, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roomCounts[departmentID] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	members := map[int][]int{}
+	for _, department := range departments {
+		members[department.ID] = department.MemberIDs
+	}
+
+	subtrees := departmentSubtrees(departments)
+	reports := []DepartmentReport{}
+	for _, department := range departments {
+		report := DepartmentReport{
+			DepartmentID: department.ID,
+			ParentID:     department.ParentID,
+			Name:         department.Name,
+			ByStatus:     map[string]int{},
+		}
+		staff := map[int]bool{}
+		for _, id := range subtrees[department.ID] {
+			report.RoomCount += roomCounts[id]
+			for _, userID := range members[id] {
+				staff[userID] = true
+			}
+		}
+		report.StaffCount = len(staff)
+		staffIDs := make([]int, 0, len(staff))
+		for userID := range staff {
+			staffIDs = append(staffIDs, userID)
+		}
+
+		var counts [len(appointmentStatuses)]int
+		err := s.db.QueryRow(`
+			SELECT COUNT(*),
+			       COALESCE(SUM(duration) FILTER (WHERE status <> 'cancelled'), 0),
+			       COUNT(DISTINCT patient_id),
+			       COUNT(*) FILTER (WHERE status = 'scheduled'),
+			       COUNT(*) FILTER (WHERE status = 'confirmed'),
+			       COUNT(*) FILTER (WHERE status = 'in-progress'),
+			       COUNT(*) FILTER (WHERE status = 'completed'),
+			       COUNT(*) FILTER (WHERE status = 'cancelled'),
+			       COUNT(*) FILTER (WHERE status = 'no-show')
+			FROM appointments
+			WHERE healthcare_entity_id = $1 AND is_active = true AND date_time >= $2 AND date_time < $3
+			  AND (doctor_id = ANY($4)
+			       OR room_id IN (SELECT id FROM rooms WHERE healthcare_entity_id = $1 AND department_id = ANY($5)))
+		`, healthcareEntityID, from, to, pq.Array(staffIDs), pq.Array(subtrees[department.ID])).Scan(
+			&report.TotalAppointments, &report.BookedMinutes, &report.UniquePatients,
+			&counts[0], &counts[1], &counts[2], &counts[3], &counts[4], &counts[5],
+		)
+		if err != nil {
+			return nil, err
+		}
+		for i, status := range appointmentStatuses {
+			report.ByStatus[status] = counts[i]
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
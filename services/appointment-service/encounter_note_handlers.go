@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/louhibi/healthcare-logging"
+)
+
+// EncounterNoteHandler handles encounter note HTTP requests
+type EncounterNoteHandler struct {
+	noteService        *EncounterNoteService
+	appointmentService *AppointmentService
+}
+
+// NewEncounterNoteHandler creates a new encounter note handler
+func NewEncounterNoteHandler(noteService *EncounterNoteService, appointmentService *AppointmentService) *EncounterNoteHandler {
+	return &EncounterNoteHandler{noteService: noteService, appointmentService: appointmentService}
+}
+
+func noteError(c *gin.Context, status int, error, message string) {
+	c.JSON(status, gin.H{
+		"error":     error,
+		"message":   message,
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// loadAppointment loads the appointment of the :id parameter within the caller's healthcare entity,
+// writing the error response when it cannot
+func (h *EncounterNoteHandler) loadAppointment(c *gin.Context) (*Appointment, bool) {
+	healthcareEntityID, err := strconv.Atoi(c.GetHeader("X-Healthcare-Entity-ID"))
+	if err != nil {
+		noteError(c, http.StatusBadRequest, "Invalid healthcare entity ID", "Healthcare entity ID header is required")
+		return nil, false
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		noteError(c, http.StatusBadRequest, "Invalid appointment ID", "Appointment ID must be a number")
+		return nil, false
+	}
+	appointment, err := h.appointmentService.GetAppointmentByID(id)
+	if err != nil || appointment.HealthcareEntityID != healthcareEntityID {
+		noteError(c, http.StatusNotFound, "Appointment not found", "appointment not found")
+		return nil, false
+	}
+	return appointment, true
+}
+
+// loadNote loads the appointment and its encounter note
+func (h *EncounterNoteHandler) loadNote(c *gin.Context) (*Appointment, *EncounterNote, bool) {
+	appointment, ok := h.loadAppointment(c)
+	if !ok {
+		return nil, nil, false
+	}
+	note, err := h.noteService.GetNoteByAppointment(appointment.HealthcareEntityID, appointment.ID)
+	if err != nil {
+		if errors.Is(err, ErrEncounterNoteNotFound) {
+			noteError(c, http.StatusNotFound, "Encounter note not found", err.Error())
+		} else {
+			noteError(c, http.StatusInternalServerError, "Failed to get encounter note", err.Error())
+		}
+		return nil, nil, false
+	}
+	return appointment, note, true
+}
+
+// GetEncounterNote handles GET /api/appointments/:id/encounter-note
+func (h *EncounterNoteHandler) GetEncounterNote(c *gin.Context) {
+	_, note, ok := h.loadNote(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":      note,
+		"message":   "Encounter note retrieved successfully",
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// CreateEncounterNote handles POST /api/appointments/:id/encounter-note
+func (h *EncounterNoteHandler) CreateEncounterNote(c *gin.Context) {
+	var req EncounterNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		noteError(c, http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+	appointment, ok := h.loadAppointment(c)
+	if !ok {
+		return
+	}
+	if appointment.Status != "in-progress" && appointment.Status != "completed" {
+		noteError(c, http.StatusConflict, "Appointment not started",
+			"Encounter notes can only be written for in-progress or completed appointments")
+		return
+	}
+
+	note := &EncounterNote{
+		AppointmentID:       appointment.ID,
+		HealthcareEntityID:  appointment.HealthcareEntityID,
+		PatientID:           appointment.PatientID,
+		DoctorID:            appointment.DoctorID,
+		AppointmentDateTime: appointment.DateTime,
+		Subjective:          req.Subjective,
+		Objective:           req.Objective,
+		Assessment:          req.Assessment,
+		Plan:                req.Plan,
+		CreatedBy:           c.GetInt("user_id"),
+		Addenda:             []EncounterNoteAddendum{},
+	}
+	if err := h.noteService.CreateNote(note); err != nil {
+		if errors.Is(err, ErrEncounterNoteExists) {
+			noteError(c, http.StatusConflict, "Encounter note already exists", err.Error())
+			return
+		}
+		noteError(c, http.StatusInternalServerError, "Failed to create encounter note", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":      note,
+		"message":   "Encounter note created successfully",
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// UpdateEncounterNote handles PUT /api/appointments/:id/encounter-note
+func (h *EncounterNoteHandler) UpdateEncounterNote(c *gin.Context) {
+	var req EncounterNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		noteError(c, http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+	_, note, ok := h.loadNote(c)
+	if !ok {
+		return
+	}
+	if note.Status != "draft" {
+		noteError(c, http.StatusConflict, "Encounter note is signed", ErrEncounterNoteSigned.Error())
+		return
+	}
+
+	note.Subjective, note.Objective, note.Assessment, note.Plan = req.Subjective, req.Objective, req.Assessment, req.Plan
+	note.UpdatedBy = c.GetInt("user_id")
+	if err := h.noteService.UpdateDraft(note); err != nil {
+		if errors.Is(err, ErrEncounterNoteSigned) {
+			noteError(c, http.StatusConflict, "Encounter note is signed", err.Error())
+			return
+		}
+		noteError(c, http.StatusInternalServerError, "Failed to update encounter note", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      note,
+		"message":   "Encounter note updated successfully",
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// SignEncounterNote handles POST /api/appointments/:id/encounter-note/sign
+func (h *EncounterNoteHandler) SignEncounterNote(c *gin.Context) {
+	appointment, note, ok := h.loadNote(c)
+	if !ok {
+		return
+	}
+	userID := c.GetInt("user_id")
+	// encounter_note.sign is checked on the route; only the appointment's doctor signs
+	if userID != appointment.DoctorID {
+		noteError(c, http.StatusForbidden, "Access denied", "Only the treating doctor can sign the encounter note")
+		return
+	}
+	if appointment.Status != "completed" {
+		noteError(c, http.StatusConflict, "Appointment not completed",
+			"Encounter notes can only be signed once the appointment is completed")
+		return
+	}
+	if note.Status != "draft" {
+		noteError(c, http.StatusConflict, "Encounter note is signed", "Encounter note is already signed")
+		return
+	}
+
+	if err := h.noteService.Sign(note, userID); err != nil {
+		switch {
+		case errors.Is(err, ErrEncounterNoteEmpty):
+			noteError(c, http.StatusBadRequest, "Encounter note is empty", err.Error())
+		case errors.Is(err, ErrEncounterNoteSigned):
+			noteError(c, http.StatusConflict, "Encounter note changed",
+				"Encounter note was signed or edited meanwhile; review it and sign again")
+		default:
+			noteError(c, http.StatusInternalServerError, "Failed to sign encounter note", err.Error())
+		}
+		return
+	}
+
+	logging.LogInfo("Encounter note signed",
+		"note_id", note.ID,
+		"appointment_id", appointment.ID,
+		"doctor_id", userID,
+	)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      note,
+		"message":   "Encounter note signed successfully",
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// AddEncounterNoteAddendum handles POST /api/appointments/:id/encounter-note/addenda
+func (h *EncounterNoteHandler) AddEncounterNoteAddendum(c *gin.Context) {
+	var req EncounterNoteAddendumRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		noteError(c, http.StatusBadRequest, "Invalid request format", err.Error())
+		return
+	}
+	_, note, ok := h.loadNote(c)
+	if !ok {
+		return
+	}
+
+	addendum := &EncounterNoteAddendum{
+		Content:    req.Content,
+		Reason:     req.Reason,
+		AuthorID:   c.GetInt("user_id"),
+		AuthorRole: c.GetHeader("X-User-Role"),
+	}
+	if err := h.noteService.AddAddendum(note, addendum); err != nil {
+		if errors.Is(err, ErrEncounterNoteNotSigned) {
+			noteError(c, http.StatusConflict, "Encounter note is a draft", err.Error())
+			return
+		}
+		noteError(c, http.StatusInternalServerError, "Failed to add addendum", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":      note,
+		"message":   "Addendum added successfully",
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// SearchEncounterNotes handles GET /api/encounter-notes
+func (h *EncounterNoteHandler) SearchEncounterNotes(c *gin.Context) {
+	healthcareEntityID, err := strconv.Atoi(c.GetHeader("X-Healthcare-Entity-ID"))
+	if err != nil {
+		noteError(c, http.StatusBadRequest, "Invalid healthcare entity ID", "Healthcare entity ID header is required")
+		return
+	}
+	patientID, err := strconv.Atoi(c.Query("patient_id"))
+	if err != nil || patientID <= 0 {
+		noteError(c, http.StatusBadRequest, "Invalid patient ID", "patient_id is required")
+		return
+	}
+
+	search := EncounterNoteSearch{
+		HealthcareEntityID: healthcareEntityID,
+		PatientID:          patientID,
+		Status:             c.Query("status"),
+		Query:              c.Query("q"),
+		Limit:              20,
+	}
+	if search.Status != "" && search.Status != "draft" && search.Status != "signed" && search.Status != "amended" {
+		noteError(c, http.StatusBadRequest, "Invalid status", "status must be draft, signed or amended")
+		return
+	}
+	if doctorID := c.Query("doctor_id"); doctorID != "" {
+		if search.DoctorID, err = strconv.Atoi(doctorID); err != nil {
+			noteError(c, http.StatusBadRequest, "Invalid doctor ID", "doctor_id must be a number")
+			return
+		}
+	}
+	if search.DateFrom, err = parseNoteDate(c.Query("date_from")); err != nil {
+		noteError(c, http.StatusBadRequest, "Invalid date format", "date_from must be YYYY-MM-DD or RFC3339")
+		return
+	}
+	if search.DateTo, err = parseNoteDate(c.Query("date_to")); err != nil {
+		noteError(c, http.StatusBadRequest, "Invalid date format", "date_to must be YYYY-MM-DD or RFC3339")
+		return
+	}
+	if search.DateTo != nil && len(c.Query("date_to")) == len("2006-01-02") {
+		// A date includes the whole day
+		end := search.DateTo.Add(24*time.Hour - time.Nanosecond)
+		search.DateTo = &end
+	}
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 {
+		search.Limit = min(limit, 100)
+	}
+	if offset, err := strconv.Atoi(c.Query("offset")); err == nil && offset > 0 {
+		search.Offset = offset
+	}
+
+	notes, total, err := h.noteService.SearchNotes(search)
+	if err != nil {
+		noteError(c, http.StatusInternalServerError, "Failed to search encounter notes", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"notes":       notes,
+			"total_count": total,
+			"limit":       search.Limit,
+			"offset":      search.Offset,
+		},
+		"message":   "Encounter notes retrieved successfully",
+		"timestamp": time.Now().UTC(),
+	})
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrEncounterNoteNotFound  = errors.New("encounter note not found")
+	ErrEncounterNoteExists    = errors.New("appointment already has an encounter note")
+	ErrEncounterNoteSigned    = errors.New("encounter note is signed; add an addendum instead")
+	ErrEncounterNoteNotSigned = errors.New("encounter note is a draft; edit it instead")
+	ErrEncounterNoteEmpty     = errors.New("encounter note has no content")
+)
+
+// ErrAppointmentHasSignedNote is returned when a change would detach a signed encounter note
+// from the visit it documents
+var ErrAppointmentHasSignedNote = errors.New("appointment has a signed encounter note: it must stay completed, with the same patient and doctor")
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// EncounterNoteService manages structured encounter notes and their addenda
+type EncounterNoteService struct {
+	db *sql.DB
+}
+
+// NewEncounterNoteService creates a new encounter note service
+func NewEncounterNoteService(db *sql.DB) *EncounterNoteService {
+	return &EncounterNoteService{db: db}
+}
+
+const encounterNoteColumns = `n.id, n.appointment_id, n.healthcare_entity_id, n.patient_id, n.doctor_id, a.date_time,
+	n.subjective, n.objective, n.assessment, n.plan, n.status, n.signed_at, n.signed_by, n.content_hash,
+	n.created_by, n.updated_by, n.created_at, n.updated_at`
+
+const encounterNoteFrom = ` FROM encounter_notes n JOIN appointments a ON a.id = n.appointment_id`
+
+func scanEncounterNote(row rowScanner, extra ...interface{}) (*EncounterNote, error) {
+	n := &EncounterNote{Addenda: []EncounterNoteAddendum{}}
+	dest := []interface{}{&n.ID, &n.AppointmentID, &n.HealthcareEntityID, &n.PatientID, &n.DoctorID, &n.AppointmentDateTime,
+		&n.Subjective, &n.Objective, &n.Assessment, &n.Plan, &n.Status, &n.SignedAt, &n.SignedBy, &n.ContentHash,
+		&n.CreatedBy, &n.UpdatedBy, &n.CreatedAt, &n.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if n.ContentHash != nil {
+		valid := encounterNoteHash(n) == *n.ContentHash
+		n.SignatureValid = &valid
+	}
+	return n, nil
+}
+
+// encounterNoteHash hashes the sections of a note. It is stored at signature and compared on
+// every read, so a signed note shows whether its content is still what the doctor signed.
+func encounterNoteHash(n *EncounterNote) string {
+	content, _ := json.Marshal([]string{n.Subjective, n.Objective, n.Assessment, n.Plan})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadAddenda attaches the addenda of the notes, oldest first
+func (s *EncounterNoteService) loadAddenda(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, notes ...*EncounterNote) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	byID := make(map[int]*EncounterNote, len(notes))
+	ids := make([]int64, 0, len(notes))
+	for _, n := range notes {
+		byID[n.ID] = n
+		ids = append(ids, int64(n.ID))
+	}
+
+	rows, err := q.Query(`
+		SELECT id, note_id, content, reason, author_id, author_role, created_at
+		FROM encounter_note_addenda WHERE note_id = ANY($1)
+		ORDER BY created_at, id
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a EncounterNoteAddendum
+		if err := rows.Scan(&a.ID, &a.NoteID, &a.Content, &a.Reason, &a.AuthorID, &a.AuthorRole, &a.CreatedAt); err != nil {
+			return err
+		}
+		byID[a.NoteID].Addenda = append(byID[a.NoteID].Addenda, a)
+	}
+	return rows.Err()
+}
+
+// GetNoteByAppointment gets the encounter note of an appointment with its addenda
+func (s *EncounterNoteService) GetNoteByAppointment(healthcareEntityID, appointmentID int) (*EncounterNote, error) {
+	n, err := scanEncounterNote(s.db.QueryRow(`SELECT `+encounterNoteColumns+encounterNoteFrom+`
+		WHERE n.appointment_id = $1 AND n.healthcare_entity_id = $2`, appointmentID, healthcareEntityID))
+	if err == sql.ErrNoRows {
+		return nil, ErrEncounterNoteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadAddenda(s.db, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// CreateNote creates the draft encounter note of an appointment
+func (s *EncounterNoteService) CreateNote(n *EncounterNote) error {
+	err := s.db.QueryRow(`
+		INSERT INTO encounter_notes (appointment_id, healthcare_entity_id, patient_id, doctor_id,
+			subjective, objective, assessment, plan, created_by, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		RETURNING id, status, created_at, updated_at
+	`, n.AppointmentID, n.HealthcareEntityID, n.PatientID, n.DoctorID,
+		n.Subjective, n.Objective, n.Assessment, n.Plan, n.CreatedBy,
+	).Scan(&n.ID, &n.Status, &n.CreatedAt, &n.UpdatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrEncounterNoteExists
+		}
+		return err
+	}
+	n.UpdatedBy = n.CreatedBy
+	return nil
+}
+
+// UpdateDraft replaces the sections of a draft note
+func (s *EncounterNoteService) UpdateDraft(n *EncounterNote) error {
+	err := s.db.QueryRow(`
+		UPDATE encounter_notes SET subjective = $2, objective = $3, assessment = $4, plan = $5, updated_by = $6
+		WHERE id = $1 AND status = 'draft'
+		RETURNING updated_at
+	`, n.ID, n.Subjective, n.Objective, n.Assessment, n.Plan, n.UpdatedBy).Scan(&n.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrEncounterNoteSigned
+	}
+	return err
+}
+
+// Sign signs a draft note on behalf of the treating doctor, freezing its sections. The caller
+// checks that doctorID is the appointment's doctor and that the appointment is completed.
+func (s *EncounterNoteService) Sign(n *EncounterNote, doctorID int) error {
+	if strings.TrimSpace(n.Subjective+n.Objective+n.Assessment+n.Plan) == "" {
+		return ErrEncounterNoteEmpty
+	}
+	hash := encounterNoteHash(n)
+	err := s.db.QueryRow(`
+		UPDATE encounter_notes SET status = 'signed', signed_at = CURRENT_TIMESTAMP, signed_by = $2, doctor_id = $2,
+			content_hash = $3, updated_by = $2
+		WHERE id = $1 AND status = 'draft'
+		  AND subjective = $4 AND objective = $5 AND assessment = $6 AND plan = $7
+		RETURNING status, signed_at, updated_at
+	`, n.ID, doctorID, hash, n.Subjective, n.Objective, n.Assessment, n.Plan).Scan(&n.Status, &n.SignedAt, &n.UpdatedAt)
+	if err == sql.ErrNoRows {
+		// Signed meanwhile, or edited since it was read: the doctor must sign what they saw
+		return ErrEncounterNoteSigned
+	}
+	if err != nil {
+		return err
+	}
+	valid := true
+	n.DoctorID, n.SignedBy, n.UpdatedBy = doctorID, &doctorID, doctorID
+	n.ContentHash, n.SignatureValid = &hash, &valid
+	return nil
+}
+
+// AddAddendum appends an addendum to a signed note, which becomes amended
+func (s *EncounterNoteService) AddAddendum(n *EncounterNote, addendum *EncounterNoteAddendum) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM encounter_notes WHERE id = $1 FOR UPDATE`, n.ID).Scan(&status); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrEncounterNoteNotFound
+		}
+		return err
+	}
+	if status == "draft" {
+		return ErrEncounterNoteNotSigned
+	}
+
+	addendum.NoteID = n.ID
+	err = tx.QueryRow(`
+		INSERT INTO encounter_note_addenda (note_id, content, reason, author_id, author_role)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, addendum.NoteID, addendum.Content, addendum.Reason, addendum.AuthorID, addendum.AuthorRole).Scan(&addendum.ID, &addendum.CreatedAt)
+	if err != nil {
+		return err
+	}
+	// Also refreshes the search vector with the new addendum
+	if err := tx.QueryRow(`
+		UPDATE encounter_notes SET status = 'amended', updated_by = $2 WHERE id = $1
+		RETURNING status, updated_at
+	`, n.ID, addendum.AuthorID).Scan(&n.Status, &n.UpdatedAt); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	n.UpdatedBy = addendum.AuthorID
+	n.Addenda = append(n.Addenda, *addendum)
+	return nil
+}
+
+// SearchNotes lists a patient's encounter notes, most relevant first when there is a query and
+// most recent visit first otherwise, with the total number of matches
+func (s *EncounterNoteService) SearchNotes(search EncounterNoteSearch) ([]EncounterNote, int, error) {
+	conditions := []string{"n.healthcare_entity_id = $1", "n.patient_id = $2"}
+	args := []interface{}{search.HealthcareEntityID, search.PatientID}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if search.DoctorID > 0 {
+		addCondition("n.doctor_id = $%d", search.DoctorID)
+	}
+	if search.Status != "" {
+		addCondition("n.status = $%d", search.Status)
+	}
+	if search.DateFrom != nil {
+		addCondition("a.date_time >= $%d", *search.DateFrom)
+	}
+	if search.DateTo != nil {
+		addCondition("a.date_time <= $%d", *search.DateTo)
+	}
+
+	headline := `''`
+	order := `a.date_time DESC, n.id DESC`
+	if search.Query != "" {
+		addCondition("n.search_vector @@ plainto_tsquery('simple', $%d)", search.Query)
+		query := fmt.Sprintf("plainto_tsquery('simple', $%d)", len(args))
+		headline = `ts_headline('simple', concat_ws(' ', n.assessment, n.plan, n.subjective, n.objective), ` + query +
+			`, 'MaxWords=30, MinWords=10')`
+		order = `ts_rank(n.search_vector, ` + query + `) DESC, ` + order
+	}
+	args = append(args, search.Limit, search.Offset)
+
+	rows, err := s.db.Query(`SELECT `+encounterNoteColumns+`, `+headline+`, COUNT(*) OVER ()`+encounterNoteFrom+`
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY `+order+fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	notes := []EncounterNote{}
+	total := 0
+	for rows.Next() {
+		var headline string
+		n, err := scanEncounterNote(rows, &headline, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		n.Headline = headline
+		notes = append(notes, *n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	refs := make([]*EncounterNote, len(notes))
+	for i := range notes {
+		refs[i] = &notes[i]
+	}
+	if err := s.loadAddenda(s.db, refs...); err != nil {
+		return nil, 0, err
+	}
+	return notes, total, nil
+}
+
+// signedNoteOf returns the patient and doctor of the signed encounter note of an appointment
+func (s *AppointmentService) signedNoteOf(appointmentID int) (patientID, doctorID int, signed bool, err error) {
+	err = s.db.QueryRow(`
+		SELECT patient_id, doctor_id FROM encounter_notes WHERE appointment_id = $1 AND status <> 'draft'
+	`, appointmentID).Scan(&patientID, &doctorID)
+	if err == sql.ErrNoRows {
+		return 0, 0, false, nil
+	}
+	return patientID, doctorID, err == nil, err
+}
+
+// parseNoteDate parses an RFC3339 timestamp or a YYYY-MM-DD date, returning nil for an empty value
+func parseNoteDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newMockDB returns a database whose queries are matched by regular expressions
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+func soapNote() *EncounterNote {
+	return &EncounterNote{ID: 4, AppointmentID: 11, Subjective: "Cough for 3 days", Objective: "T 38.2",
+		Assessment: "Acute bronchitis", Plan: "Rest, fluids"}
+}
+
+func TestSignEncounterNote(t *testing.T) {
+	db, mock := newMockDB(t)
+	n := soapNote()
+	hash := encounterNoteHash(n)
+	mock.ExpectQuery(`UPDATE encounter_notes SET status = 'signed'`).
+		WithArgs(4, 3, hash, n.Subjective, n.Objective, n.Assessment, n.Plan).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "signed_at", "updated_at"}).AddRow("signed", time.Now(), time.Now()))
+
+	if err := NewEncounterNoteService(db).Sign(n, 3); err != nil {
+		t.Fatalf("Sign error = %v", err)
+	}
+	if n.Status != "signed" || n.ContentHash == nil || *n.ContentHash != hash || n.SignedBy == nil || *n.SignedBy != 3 {
+		t.Errorf("signed note = %+v", n)
+	}
+}
+
+func TestSignEncounterNoteRefusals(t *testing.T) {
+	t.Run("empty note", func(t *testing.T) {
+		db, _ := newMockDB(t)
+		if err := NewEncounterNoteService(db).Sign(&EncounterNote{ID: 4, Plan: "  "}, 3); !errors.Is(err, ErrEncounterNoteEmpty) {
+			t.Errorf("Sign error = %v, want ErrEncounterNoteEmpty", err)
+		}
+	})
+	t.Run("already signed or edited since read", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`UPDATE encounter_notes SET status = 'signed'`).WillReturnRows(sqlmock.NewRows(nil))
+		if err := NewEncounterNoteService(db).Sign(soapNote(), 3); !errors.Is(err, ErrEncounterNoteSigned) {
+			t.Errorf("Sign error = %v, want ErrEncounterNoteSigned", err)
+		}
+	})
+}
+
+func TestUpdateSignedNote(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`UPDATE encounter_notes SET subjective = \$2.*WHERE id = \$1 AND status = 'draft'`).
+		WillReturnRows(sqlmock.NewRows(nil))
+
+	if err := NewEncounterNoteService(db).UpdateDraft(soapNote()); !errors.Is(err, ErrEncounterNoteSigned) {
+		t.Errorf("UpdateDraft error = %v, want ErrEncounterNoteSigned", err)
+	}
+}
+
+func TestAddAddendum(t *testing.T) {
+	t.Run("to a draft", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT status FROM encounter_notes`).WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("draft"))
+		mock.ExpectRollback()
+
+		err := NewEncounterNoteService(db).AddAddendum(soapNote(), &EncounterNoteAddendum{Content: "Late entry", AuthorID: 3})
+		if !errors.Is(err, ErrEncounterNoteNotSigned) {
+			t.Errorf("AddAddendum error = %v, want ErrEncounterNoteNotSigned", err)
+		}
+	})
+	t.Run("to a signed note", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT status FROM encounter_notes`).WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("signed"))
+		mock.ExpectQuery(`INSERT INTO encounter_note_addenda`).WithArgs(4, "Late entry", "Forgot", 3, "doctor").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+		mock.ExpectQuery(`UPDATE encounter_notes SET status = 'amended'`).WithArgs(4, 3).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "updated_at"}).AddRow("amended", time.Now()))
+		mock.ExpectCommit()
+
+		n := soapNote()
+		err := NewEncounterNoteService(db).AddAddendum(n, &EncounterNoteAddendum{Content: "Late entry", Reason: "Forgot", AuthorID: 3, AuthorRole: "doctor"})
+		if err != nil {
+			t.Fatalf("AddAddendum error = %v", err)
+		}
+		if n.Status != "amended" || len(n.Addenda) != 1 {
+			t.Errorf("note is %q with %d addenda, want amended with 1", n.Status, len(n.Addenda))
+		}
+	})
+}
+
+func TestScanEncounterNoteChecksSignature(t *testing.T) {
+	signed := soapNote()
+	hash := encounterNoteHash(signed)
+	tests := []struct {
+		name string
+		plan string
+		want bool
+	}{
+		{"unchanged", signed.Plan, true},
+		{"edited after signature", "Antibiotics", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			rows := sqlmock.NewRows([]string{"id", "appointment_id", "healthcare_entity_id", "patient_id", "doctor_id",
+				"date_time", "subjective", "objective", "assessment", "plan", "status", "signed_at", "signed_by",
+				"content_hash", "created_by", "updated_by", "created_at", "updated_at"}).
+				AddRow(4, 11, 1, 5, 3, now, signed.Subjective, signed.Objective, signed.Assessment, tt.plan,
+					"signed", now, 3, hash, 3, 3, now, now)
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(`SELECT`).WillReturnRows(rows)
+
+			n, err := scanEncounterNote(db.QueryRow(`SELECT`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.SignatureValid == nil || *n.SignatureValid != tt.want {
+				t.Errorf("SignatureValid = %v, want %v", n.SignatureValid, tt.want)
+			}
+		})
+	}
+}
@@ -6,17 +6,20 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	rbac "github.com/louhibi/healthcare-rbac"
 )
 
 type AppointmentHandler struct {
-	service *AppointmentService
+	service     *AppointmentService
+	departments *DepartmentClient
 }
 
-func NewAppointmentHandler(service *AppointmentService) *AppointmentHandler {
-	return &AppointmentHandler{service: service}
+func NewAppointmentHandler(service *AppointmentService, departments *DepartmentClient) *AppointmentHandler {
+	return &AppointmentHandler{service: service, departments: departments}
 }
 
 // GetAppointments handles GET /api/appointments
@@ -69,6 +72,14 @@ func (h *AppointmentHandler) GetAppointments(c *gin.Context) {
 		DateFrom:           dateFrom,
 		DateTo:             dateTo,
 	}
+	departmentScope, ok := h.departmentScope(c)
+	if !ok {
+		return
+	}
+	if departmentScope != nil {
+		search.DepartmentIDs = departmentScope.DepartmentIDs
+		search.DepartmentDoctorIDs = departmentScope.UserIDs
+	}
 
 	appointments, err := h.service.GetAppointments(search)
 	if err != nil {
@@ -118,6 +129,134 @@ func (h *AppointmentHandler) GetAppointments(c *gin.Context) {
 	})
 }
 
+// maxLastVisitPatients caps the number of patients per last-visits request
+const maxLastVisitPatients = 500
+
+// GetLastVisits handles GET /api/appointments/last-visits?patient_ids=1,2,3
+// It is used by the patient-service retention job to find patients without recent appointments.
+func (h *AppointmentHandler) GetLastVisits(c *gin.Context) {
+	healthcareEntityIDStr := c.GetHeader("X-Healthcare-Entity-ID")
+	if healthcareEntityIDStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Healthcare entity ID header is required"})
+		return
+	}
+
+	healthcareEntityID, err := strconv.Atoi(healthcareEntityIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid healthcare entity ID"})
+		return
+	}
+
+	var patientIDs []int
+	for _, idStr := range strings.Split(c.Query("patient_ids"), ",") {
+		if strings.TrimSpace(idStr) == "" {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(idStr))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":     "Invalid patient ID",
+				"message":   "patient_ids must be a comma separated list of numbers",
+				"timestamp": time.Now().UTC(),
+			})
+			return
+		}
+		patientIDs = append(patientIDs, id)
+	}
+	if len(patientIDs) == 0 || len(patientIDs) > maxLastVisitPatients {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "Invalid patient IDs",
+			"message":   fmt.Sprintf("Between 1 and %d patient IDs are required", maxLastVisitPatients),
+			"timestamp": time.Now().UTC(),
+		})
+		return
+	}
+
+	lastDates, err := h.service.GetLastAppointmentDates(healthcareEntityID, patientIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":     "Failed to get last visits",
+			"message":   err.Error(),
+			"timestamp": time.Now().UTC(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"last_visits": lastDates,
+		},
+		"message":   "Last visits retrieved successfully",
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// maxTimelineEvents caps the number of events per timeline request
+const maxTimelineEvents = 1000
+
+// GetPatientTimeline handles GET /api/appointments/timeline?patient_id=1&categories=&from=&to=&limit=&offset=
+func (h *AppointmentHandler) GetPatientTimeline(c *gin.Context) {
+	healthcareEntityID, err := strconv.Atoi(c.GetHeader("X-Healthcare-Entity-ID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Healthcare entity ID header is required"})
+		return
+	}
+
+	search := AppointmentTimelineSearch{HealthcareEntityID: healthcareEntityID, Limit: 50}
+	if search.PatientID, err = strconv.Atoi(c.Query("patient_id")); err != nil || search.PatientID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "Invalid patient ID",
+			"message":   "patient_id is required",
+			"timestamp": time.Now().UTC(),
+		})
+		return
+	}
+	for param, bound := range map[string]**time.Time{"from": &search.From, "to": &search.To} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":     "Invalid date format",
+					"message":   param + " must be an RFC3339 timestamp",
+					"timestamp": time.Now().UTC(),
+				})
+				return
+			}
+			*bound = &t
+		}
+	}
+	if categories := c.Query("categories"); categories != "" {
+		search.Categories = strings.Split(categories, ",")
+	}
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 {
+		search.Limit = min(limit, maxTimelineEvents)
+	}
+	if offset, err := strconv.Atoi(c.Query("offset")); err == nil && offset > 0 {
+		search.Offset = offset
+	}
+
+	events, total, err := h.service.GetPatientTimeline(search)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":     "Failed to get patient timeline",
+			"message":   err.Error(),
+			"timestamp": time.Now().UTC(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"events":      events,
+			"total_count": total,
+			"limit":       search.Limit,
+			"offset":      search.Offset,
+		},
+		"message":   "Patient timeline retrieved successfully",
+		"timestamp": time.Now().UTC(),
+	})
+}
+
 // CreateAppointment handles POST /api/appointments
 func (h *AppointmentHandler) CreateAppointment(c *gin.Context) {
 	var req AppointmentRequest
@@ -316,7 +455,7 @@ func (h *AppointmentHandler) UpdateAppointment(c *gin.Context) {
 	}
 	appointment.RoomID = roomID
 
-	err = h.service.UpdateAppointment(appointment)
+	err = h.service.UpdateAppointment(appointment, c.GetInt("user_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":     "Failed to update appointment",
@@ -346,7 +485,7 @@ func (h *AppointmentHandler) DeleteAppointment(c *gin.Context) {
 		return
 	}
 
-	err = h.service.DeleteAppointment(id)
+	err = h.service.DeleteAppointment(id, c.GetInt("user_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":     "Failed to delete appointment",
@@ -385,7 +524,13 @@ func (h *AppointmentHandler) UpdateAppointmentStatus(c *gin.Context) {
 		return
 	}
 
-	err = h.service.UpdateAppointmentStatus(id, req.Status, req.Notes)
+	// Cancelling takes its own permission on top of appointment.write
+	if req.Status == "cancelled" && !rbac.Has(c, rbac.AppointmentCancel) {
+		rbac.Deny(c, rbac.AppointmentCancel)
+		return
+	}
+
+	err = h.service.UpdateAppointmentStatus(id, req.Status, req.Notes, c.GetInt("user_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":     "Failed to update appointment status",
@@ -935,6 +1080,11 @@ func (h *AppointmentHandler) GetDoctorsByEntity(c *gin.Context) {
 		return
 	}
 
+	departmentScope, ok := h.departmentScope(c)
+	if !ok {
+		return
+	}
+
 	doctors, err := h.service.GetDoctorsByEntity(healthcareEntityID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -944,6 +1094,19 @@ func (h *AppointmentHandler) GetDoctorsByEntity(c *gin.Context) {
 		})
 		return
 	}
+	if departmentScope != nil {
+		inDepartment := map[int]bool{}
+		for _, userID := range departmentScope.UserIDs {
+			inDepartment[userID] = true
+		}
+		filtered := []DoctorInfo{}
+		for _, doctor := range doctors {
+			if inDepartment[doctor.ID] {
+				filtered = append(filtered, doctor)
+			}
+		}
+		doctors = filtered
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"data":      doctors,
@@ -1247,7 +1410,16 @@ func (h *AppointmentHandler) GetRooms(c *gin.Context) {
 		floor, _ = strconv.Atoi(floorStr)
 	}
 
-	rooms, err := h.service.GetRooms(healthcareEntityID, roomType, floor)
+	var departmentIDs []int
+	departmentScope, ok := h.departmentScope(c)
+	if !ok {
+		return
+	}
+	if departmentScope != nil {
+		departmentIDs = departmentScope.DepartmentIDs
+	}
+
+	rooms, err := h.service.GetRooms(healthcareEntityID, roomType, floor, departmentIDs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":     "Failed to get rooms",
@@ -1300,6 +1472,10 @@ func (h *AppointmentHandler) CreateRoom(c *gin.Context) {
 		return
 	}
 
+	if !h.validateRoomDepartment(c, req) {
+		return
+	}
+
 	room, err := h.service.CreateRoom(healthcareEntityID, userID, req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -1340,6 +1516,10 @@ func (h *AppointmentHandler) UpdateRoom(c *gin.Context) {
 		return
 	}
 
+	if !h.validateRoomDepartment(c, req) {
+		return
+	}
+
 	room, err := h.service.UpdateRoom(roomID, req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/louhibi/healthcare-logging"
+	rbac "github.com/louhibi/healthcare-rbac"
 )
 
 func main() {
@@ -34,9 +35,11 @@ func main() {
 
 	// Initialize services
 	appointmentService := NewAppointmentService(db)
+	encounterNoteService := NewEncounterNoteService(db)
 
 	// Initialize handlers
-	appointmentHandler := NewAppointmentHandler(appointmentService)
+	appointmentHandler := NewAppointmentHandler(appointmentService, NewDepartmentClientFromEnv())
+	encounterNoteHandler := NewEncounterNoteHandler(encounterNoteService, appointmentService)
 
 	// Setup router
 	router := gin.Default()
@@ -87,50 +90,66 @@ func main() {
 	// Appointment routes (all protected via API Gateway)
 	appointments := router.Group("/api/appointments", authMiddleware)
 	{
-		appointments.GET("/", appointmentHandler.GetAppointments)
-		appointments.POST("/", appointmentHandler.CreateAppointment)
-		appointments.GET("/:id", appointmentHandler.GetAppointment)
-		appointments.PUT("/:id", appointmentHandler.UpdateAppointment)
-		appointments.DELETE("/:id", appointmentHandler.DeleteAppointment)
-		appointments.PATCH("/:id/status", appointmentHandler.UpdateAppointmentStatus)
+		appointments.GET("/", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetAppointments)
+		appointments.POST("/", rbac.Require(rbac.AppointmentWrite), appointmentHandler.CreateAppointment)
+		appointments.GET("/:id", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetAppointment)
+		appointments.PUT("/:id", rbac.Require(rbac.AppointmentWrite), appointmentHandler.UpdateAppointment)
+		appointments.DELETE("/:id", rbac.Require(rbac.AppointmentCancel), appointmentHandler.DeleteAppointment)
+		appointments.PATCH("/:id/status", rbac.Require(rbac.AppointmentWrite), appointmentHandler.UpdateAppointmentStatus)
 		
 		// Smart booking endpoints
-		appointments.POST("/book", appointmentHandler.BookAppointment)
-		appointments.GET("/slots", appointmentHandler.GetTimeSlots)
+		appointments.POST("/book", rbac.Require(rbac.AppointmentWrite), appointmentHandler.BookAppointment)
+		appointments.GET("/slots", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetTimeSlots)
+		appointments.GET("/last-visits", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetLastVisits)
+		appointments.GET("/timeline", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetPatientTimeline)
+		appointments.GET("/reports/departments", rbac.Require(rbac.ReportRead), appointmentHandler.GetDepartmentReport)
 		
 		// Duration options for appointment booking (moved from admin)
-		appointments.GET("/duration-options", appointmentHandler.GetDurationOptions)
+		appointments.GET("/duration-options", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetDurationOptions)
 		
 		// Rooms for appointment booking (moved from admin)
-		appointments.GET("/rooms", appointmentHandler.GetRooms)
+		appointments.GET("/rooms", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetRooms)
+
+		// Encounter notes
+		appointments.GET("/:id/encounter-note", rbac.Require(rbac.EncounterNoteRead), encounterNoteHandler.GetEncounterNote)
+		appointments.POST("/:id/encounter-note", rbac.Require(rbac.EncounterNoteWrite), encounterNoteHandler.CreateEncounterNote)
+		appointments.PUT("/:id/encounter-note", rbac.Require(rbac.EncounterNoteWrite), encounterNoteHandler.UpdateEncounterNote)
+		appointments.POST("/:id/encounter-note/sign", rbac.Require(rbac.EncounterNoteSign), encounterNoteHandler.SignEncounterNote)
+		appointments.POST("/:id/encounter-note/addenda", rbac.Require(rbac.EncounterNoteWrite), encounterNoteHandler.AddEncounterNoteAddendum)
+	}
+
+	// Encounter note search, per patient
+	encounterNotes := router.Group("/api/encounter-notes", authMiddleware, rbac.Require(rbac.EncounterNoteRead))
+	{
+		encounterNotes.GET("/", encounterNoteHandler.SearchEncounterNotes)
 	}
 
 	// Doctor schedule routes (for appointment availability slots)
 	schedules := router.Group("/api/schedules", authMiddleware)
 	{
-		schedules.GET("/", appointmentHandler.GetDoctorSchedules)
+		schedules.GET("/", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetDoctorSchedules)
 	}
 
 	// Doctor availability routes (for managing doctor working hours and status)
 	availability := router.Group("/api/availability", authMiddleware)
 	{
-		availability.GET("/", appointmentHandler.GetDoctorAvailability)
-		availability.POST("/", appointmentHandler.CreateDoctorAvailability)
-		availability.GET("/:id", appointmentHandler.GetDoctorAvailabilityByID)
-		availability.PUT("/:id", appointmentHandler.UpdateDoctorAvailability)
-		availability.DELETE("/:id", appointmentHandler.DeleteDoctorAvailability)
-		availability.GET("/calendar", appointmentHandler.GetAvailabilityCalendar)
-		availability.POST("/bulk", appointmentHandler.CreateBulkAvailability)
+		availability.GET("/", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetDoctorAvailability)
+		availability.POST("/", rbac.Require(rbac.AvailabilityManage), appointmentHandler.CreateDoctorAvailability)
+		availability.GET("/:id", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetDoctorAvailabilityByID)
+		availability.PUT("/:id", rbac.Require(rbac.AvailabilityManage), appointmentHandler.UpdateDoctorAvailability)
+		availability.DELETE("/:id", rbac.Require(rbac.AvailabilityManage), appointmentHandler.DeleteDoctorAvailability)
+		availability.GET("/calendar", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetAvailabilityCalendar)
+		availability.POST("/bulk", rbac.Require(rbac.AvailabilityManage), appointmentHandler.CreateBulkAvailability)
 	}
 
 	// Doctor management routes
 	doctors := router.Group("/api/doctors", authMiddleware)
 	{
-		doctors.GET("/", appointmentHandler.GetDoctorsByEntity)
+		doctors.GET("/", rbac.Require(rbac.AppointmentRead), appointmentHandler.GetDoctorsByEntity)
 	}
 
 	// Admin management routes
-	admin := router.Group("/api/admin", authMiddleware)
+	admin := router.Group("/api/admin", authMiddleware, rbac.Require(rbac.ScheduleManage))
 	{
 		// Duration settings management
 		admin.GET("/duration-settings", appointmentHandler.GetAppointmentDurationSettings)
@@ -149,8 +168,15 @@ func main() {
 		admin.DELETE("/duration-options/:id", appointmentHandler.DeleteDurationOption)
 	}
 
+	// Internal routes, called by other services with the internal service token. They are
+	// outside /api so the gateway never routes them.
+	internal := router.Group("/internal", InternalServiceMiddleware())
+	{
+		internal.GET("/appointments/last-visits", appointmentHandler.GetLastVisits)
+	}
+
 	// Available rooms endpoint (for appointment booking)
-	router.GET("/api/appointments/available-rooms", authMiddleware, appointmentHandler.GetAvailableRooms)
+	router.GET("/api/appointments/available-rooms", authMiddleware, rbac.Require(rbac.AppointmentRead), appointmentHandler.GetAvailableRooms)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -58,6 +58,10 @@ type AppointmentSearch struct {
 	IncludePast        bool      `form:"include_past"` // If false (default), only show future/current appointments
 	Limit              int       `form:"limit"`
 	Offset             int       `form:"offset"`
+	// Department filter, resolved through the user-service: appointments with a doctor of the
+	// departments or in one of their rooms
+	DepartmentIDs       []int `form:"-"`
+	DepartmentDoctorIDs []int `form:"-"`
 }
 
 // AppointmentResponse represents appointment data returned to client
@@ -452,7 +456,8 @@ type Room struct {
 	RoomName           sql.NullString `json:"room_name" db:"room_name"`
 	RoomType           string         `json:"room_type" db:"room_type" validate:"required,oneof=consultation examination procedure operating emergency"`
 	Floor              int            `json:"floor" db:"floor"`
-	Department         sql.NullString `json:"department" db:"department"`
+	Department         sql.NullString `json:"department" db:"department"` // Free-text label predating departments
+	DepartmentID       sql.NullInt32  `json:"department_id" db:"department_id"`
 	Capacity           int            `json:"capacity" db:"capacity" validate:"min=1"`
 	Equipment          sql.NullString `json:"equipment" db:"equipment"` // JSON string of equipment list
 	IsActive           bool           `json:"is_active" db:"is_active"`
@@ -462,21 +467,40 @@ type Room struct {
 	CreatedBy          int            `json:"created_by" db:"created_by"`
 }
 
+// departmentID returns the room's department, nil when it has none
+func (r Room) departmentID() *int {
+	if !r.DepartmentID.Valid {
+		return nil
+	}
+	id := int(r.DepartmentID.Int32)
+	return &id
+}
+
+// nullInt32 converts an optional ID to its column value
+func nullInt32(id *int) sql.NullInt32 {
+	if id == nil {
+		return sql.NullInt32{}
+	}
+	return sql.NullInt32{Int32: int32(*id), Valid: true}
+}
+
 // MarshalJSON provides custom JSON marshaling for Room
 func (r Room) MarshalJSON() ([]byte, error) {
 	type Alias Room
 	return json.Marshal(&struct {
 		*Alias
-		RoomName   string `json:"room_name"`
-		Department string `json:"department"`
-		Equipment  string `json:"equipment"`
-		Notes      string `json:"notes"`
+		RoomName     string `json:"room_name"`
+		Department   string `json:"department"`
+		DepartmentID *int   `json:"department_id"`
+		Equipment    string `json:"equipment"`
+		Notes        string `json:"notes"`
 	}{
-		Alias:      (*Alias)(&r),
-		RoomName:   r.RoomName.String,
-		Department: r.Department.String,
-		Equipment:  r.Equipment.String,
-		Notes:      r.Notes.String,
+		Alias:        (*Alias)(&r),
+		RoomName:     r.RoomName.String,
+		Department:   r.Department.String,
+		DepartmentID: r.departmentID(),
+		Equipment:    r.Equipment.String,
+		Notes:        r.Notes.String,
 	})
 }
 
@@ -486,7 +510,8 @@ type RoomRequest struct {
 	RoomName    string   `json:"room_name"`
 	RoomType    string   `json:"room_type" validate:"required,oneof=consultation examination procedure operating emergency"`
 	Floor       int      `json:"floor"`
-	Department  string   `json:"department"`
+	Department   string   `json:"department"`
+	DepartmentID *int     `json:"department_id"` // Department of the user-service
 	Capacity    int      `json:"capacity" validate:"min=1"`
 	Equipment   []string `json:"equipment"` // Array of equipment
 	Notes       string   `json:"notes"`
@@ -501,6 +526,7 @@ type RoomResponse struct {
 	RoomType           string   `json:"room_type"`
 	Floor              int      `json:"floor"`
 	Department         string   `json:"department"`
+	DepartmentID       *int     `json:"department_id"`
 	Capacity           int      `json:"capacity"`
 	Equipment          []string `json:"equipment"`
 	IsActive           bool     `json:"is_active"`
@@ -554,6 +580,7 @@ func (r *Room) ToRoomResponse() RoomResponse {
 		RoomType:           r.RoomType,
 		Floor:              r.Floor,
 		Department:         r.Department.String,
+		DepartmentID:       r.departmentID(),
 		Capacity:           r.Capacity,
 		Equipment:          equipment,
 		IsActive:           r.IsActive,
@@ -672,4 +699,105 @@ func (tc *TimezoneConverter) ParseEntityDateTime(dateTimeStr string) (time.Time,
 	}
 	
 	return time.Time{}, err
-}
\ No newline at end of file
+}
+// EncounterNote is the structured clinical note (SOAP) of an appointment. Drafts can be edited;
+// once signed by the treating doctor the sections are frozen and corrections are addenda.
+type EncounterNote struct {
+	ID                  int                     `json:"id" db:"id"`
+	AppointmentID       int                     `json:"appointment_id" db:"appointment_id"`
+	HealthcareEntityID  int                     `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	PatientID           int                     `json:"patient_id" db:"patient_id"`
+	DoctorID            int                     `json:"doctor_id" db:"doctor_id"`
+	AppointmentDateTime time.Time               `json:"appointment_date_time"`
+	Subjective          string                  `json:"subjective" db:"subjective"`
+	Objective           string                  `json:"objective" db:"objective"`
+	Assessment          string                  `json:"assessment" db:"assessment"`
+	Plan                string                  `json:"plan" db:"plan"`
+	Status              string                  `json:"status" db:"status"` // draft, signed, amended
+	SignedAt            *time.Time              `json:"signed_at" db:"signed_at"`
+	SignedBy            *int                    `json:"signed_by" db:"signed_by"`
+	ContentHash         *string                 `json:"content_hash" db:"content_hash"`
+	SignatureValid      *bool                   `json:"signature_valid,omitempty"` // Sections still match the signed hash
+	CreatedBy           int                     `json:"created_by" db:"created_by"`
+	UpdatedBy           int                     `json:"updated_by" db:"updated_by"`
+	CreatedAt           time.Time               `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time               `json:"updated_at" db:"updated_at"`
+	Addenda             []EncounterNoteAddendum `json:"addenda"`
+	Headline            string                  `json:"headline,omitempty"` // Matching excerpt in search results
+}
+
+// EncounterNoteAddendum is a correction or addition made to a signed encounter note
+type EncounterNoteAddendum struct {
+	ID         int       `json:"id" db:"id"`
+	NoteID     int       `json:"note_id" db:"note_id"`
+	Content    string    `json:"content" db:"content"`
+	Reason     string    `json:"reason" db:"reason"`
+	AuthorID   int       `json:"author_id" db:"author_id"`
+	AuthorRole string    `json:"author_role" db:"author_role"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// EncounterNoteRequest creates or updates the sections of a draft encounter note
+type EncounterNoteRequest struct {
+	Subjective string `json:"subjective" binding:"max=20000"`
+	Objective  string `json:"objective" binding:"max=20000"`
+	Assessment string `json:"assessment" binding:"max=20000"`
+	Plan       string `json:"plan" binding:"max=20000"`
+}
+
+// EncounterNoteAddendumRequest adds an addendum to a signed encounter note
+type EncounterNoteAddendumRequest struct {
+	Content string `json:"content" binding:"required,max=20000"`
+	Reason  string `json:"reason" binding:"max=500"`
+}
+
+// EncounterNoteSearch represents encounter note search parameters
+type EncounterNoteSearch struct {
+	HealthcareEntityID int
+	PatientID          int
+	DoctorID           int
+	Status             string
+	Query              string // Full-text search over sections and addenda
+	DateFrom           *time.Time
+	DateTo             *time.Time
+	Limit              int
+	Offset             int
+}
+
+// AppointmentTimelineEvent is an appointment event in a patient's timeline
+type AppointmentTimelineEvent struct {
+	ID            string                 `json:"id"` // Unique across event types, e.g. appointment-status:12
+	OccurredAt    time.Time              `json:"occurred_at"`
+	Category      string                 `json:"category"` // appointment, encounter_note
+	Type          string                 `json:"type"`     // appointment.scheduled, appointment.status_changed, appointment.deleted, encounter_note.signed
+	Summary       string                 `json:"summary"`
+	ActorID       *int                   `json:"actor_id"`
+	AppointmentID int                    `json:"appointment_id"`
+	Details       map[string]interface{} `json:"details"`
+}
+
+// AppointmentTimelineSearch represents patient timeline parameters
+type AppointmentTimelineSearch struct {
+	HealthcareEntityID int
+	PatientID          int
+	Categories         []string // appointment, encounter_note; all when empty
+	From               *time.Time
+	To                 *time.Time
+	Limit              int
+	Offset             int
+}
+
+// DepartmentReport is the appointment activity of a department and its sub-departments over
+// a period. An appointment counts for a department when its doctor works there or it takes
+// place in one of its rooms.
+type DepartmentReport struct {
+	DepartmentID      int            `json:"department_id"`
+	ParentID          *int           `json:"parent_id"`
+	Name              string         `json:"name"`
+	StaffCount        int            `json:"staff_count"`
+	RoomCount         int            `json:"room_count"`
+	TotalAppointments int            `json:"total_appointments"`
+	ByStatus          map[string]int `json:"by_status"`
+	BookedMinutes     int            `json:"booked_minutes"` // Cancelled appointments excluded
+	UniquePatients    int            `json:"unique_patients"`
+}
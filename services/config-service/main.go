@@ -5,6 +5,7 @@ import (
     "github.com/gin-gonic/gin"
     "github.com/joho/godotenv"
     logging "github.com/louhibi/healthcare-logging"
+    rbac "github.com/louhibi/healthcare-rbac"
 )
 
 func getEnv(key, def string) string { if v := os.Getenv(key); v != "" { return v }; return def }
@@ -42,8 +43,8 @@ func main() {
         pub.GET("/public/flags", h.GetPublicFlags)
     }
 
-    // Protected endpoints (gateway enforces auth & role) - treat same paths here
-    admin := r.Group("/api/config/admin")
+    // Protected endpoints (gateway enforces auth) - config.manage is checked here too
+    admin := r.Group("/api/config/admin", rbac.Require(rbac.ConfigManage))
     {
         admin.GET("/settings", h.GetAllSettings)
         admin.POST("/settings", h.UpsertSetting)
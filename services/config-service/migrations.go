@@ -48,6 +48,21 @@ func getMigrations() []Migration {
             `,
             Down: `DROP TABLE IF EXISTS feature_flags; DROP TABLE IF EXISTS config_settings;`,
         },
+        {
+            Version: 2,
+            Description: "Limit public registration; staff join through invitations",
+            Up: `
+                INSERT INTO config_settings (key, value, is_public, description) VALUES
+                    ('registration.allowed_roles', 'staff', true, 'Comma separated roles public registration may choose'),
+                    ('registration.allowed_email_domains', '', true, 'Comma separated email domains allowed to self-register (empty allows any)')
+                ON CONFLICT (key) DO NOTHING;
+                UPDATE feature_flags SET enabled = false, updated_at = CURRENT_TIMESTAMP WHERE name = 'registration.enabled';
+            `,
+            Down: `
+                DELETE FROM config_settings WHERE key IN ('registration.allowed_roles', 'registration.allowed_email_domains');
+                UPDATE feature_flags SET enabled = true, updated_at = CURRENT_TIMESTAMP WHERE name = 'registration.enabled';
+            `,
+        },
     }
 }
 
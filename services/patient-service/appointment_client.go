@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrAppointmentNotFound is returned when an appointment does not exist in the caller's entity
+var ErrAppointmentNotFound = errors.New("appointment not found")
+
+// AppointmentClient calls the appointment-service on behalf of the current user, or as the
+// service itself on the internal routes
+type AppointmentClient struct {
+	baseURL       string
+	internalToken string
+	httpClient    *http.Client
+}
+
+// NewAppointmentClientFromEnv reads the service location from APPOINTMENT_SERVICE_URL and the
+// internal routes' token from INTERNAL_SERVICE_TOKEN
+func NewAppointmentClientFromEnv() *AppointmentClient {
+	baseURL := os.Getenv("APPOINTMENT_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://appointment-service:8083"
+	}
+	return &AppointmentClient{
+		baseURL:       baseURL,
+		internalToken: os.Getenv("INTERNAL_SERVICE_TOKEN"),
+		httpClient:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// do sends a request with the caller's identity headers and returns the raw status and body,
+// so responses can be relayed unchanged
+func (c *AppointmentClient) do(method, path string, payload interface{}, headers map[string]string) (int, []byte, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		if value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to call appointment service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read appointment service response: %w", err)
+	}
+	return resp.StatusCode, data, nil
+}
+
+// BookAppointment books through the conflict-checking booking endpoint
+func (c *AppointmentClient) BookAppointment(booking map[string]interface{}, headers map[string]string) (int, []byte, error) {
+	return c.do(http.MethodPost, "/api/appointments/book", booking, headers)
+}
+
+// GetAppointmentPatientID returns the patient of an appointment of the caller's entity
+func (c *AppointmentClient) GetAppointmentPatientID(appointmentID int, headers map[string]string) (int, error) {
+	status, body, err := c.do(http.MethodGet, fmt.Sprintf("/api/appointments/%d", appointmentID), nil, headers)
+	if err != nil {
+		return 0, err
+	}
+	// The appointment-service answers 403 for appointments of other entities
+	if status == http.StatusNotFound || status == http.StatusForbidden {
+		return 0, ErrAppointmentNotFound
+	}
+	if status != http.StatusOK {
+		return 0, fmt.Errorf("appointment service returned status %d", status)
+	}
+
+	var response struct {
+		Data struct {
+			PatientID int `json:"patient_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, fmt.Errorf("failed to decode appointment service response: %w", err)
+	}
+	return response.Data.PatientID, nil
+}
+
+// appointmentPageSize is the page size used when walking a patient's appointments
+const appointmentPageSize = 100
+
+// ListPatientAppointments returns all appointments of a patient as the appointment-service
+// renders them, following pagination until a short page is returned
+func (c *AppointmentClient) ListPatientAppointments(patientID int, headers map[string]string) ([]json.RawMessage, error) {
+	appointments := []json.RawMessage{}
+	for offset := 0; ; offset += appointmentPageSize {
+		path := fmt.Sprintf("/api/appointments/?patient_id=%d&limit=%d&offset=%d", patientID, appointmentPageSize, offset)
+		status, body, err := c.do(http.MethodGet, path, nil, headers)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("appointment service returned status %d", status)
+		}
+
+		var response struct {
+			Data struct {
+				Appointments []json.RawMessage `json:"appointments"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return nil, fmt.Errorf("failed to decode appointment service response: %w", err)
+		}
+		appointments = append(appointments, response.Data.Appointments...)
+		if len(response.Data.Appointments) < appointmentPageSize {
+			return appointments, nil
+		}
+	}
+}
+
+// GetLastVisits returns the date of the last non-cancelled appointment of each patient of the
+// entity in the X-Healthcare-Entity-ID header. Patients without appointments are absent.
+func (c *AppointmentClient) GetLastVisits(patientIDs []int, headers map[string]string) (map[int]time.Time, error) {
+	return c.getLastVisits("/api/appointments/last-visits", patientIDs, headers)
+}
+
+// GetLastVisitsInternal is GetLastVisits without a user, for background jobs. It calls the
+// internal route with the service token.
+func (c *AppointmentClient) GetLastVisitsInternal(entityID int, patientIDs []int) (map[int]time.Time, error) {
+	if c.internalToken == "" {
+		return nil, errors.New("INTERNAL_SERVICE_TOKEN is not set")
+	}
+	return c.getLastVisits("/internal/appointments/last-visits", patientIDs, map[string]string{
+		"X-Internal-Service-Token": c.internalToken,
+		"X-Healthcare-Entity-ID":   strconv.Itoa(entityID),
+	})
+}
+
+func (c *AppointmentClient) getLastVisits(path string, patientIDs []int, headers map[string]string) (map[int]time.Time, error) {
+	ids := make([]string, len(patientIDs))
+	for i, id := range patientIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+	status, body, err := c.do(http.MethodGet, path+"?patient_ids="+strings.Join(ids, ","), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("appointment service returned status %d", status)
+	}
+
+	var response struct {
+		Data struct {
+			LastVisits map[int]time.Time `json:"last_visits"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode appointment service response: %w", err)
+	}
+	return response.Data.LastVisits, nil
+}
+
+// GetPatientTimeline returns the most recent appointment events of a patient (appointments
+// scheduled, status changes, signed encounter notes) and their total
+func (c *AppointmentClient) GetPatientTimeline(patientID int, categories []string, from, to *time.Time, limit int, headers map[string]string) ([]TimelineEvent, int, error) {
+	query := url.Values{}
+	query.Set("patient_id", strconv.Itoa(patientID))
+	query.Set("limit", strconv.Itoa(limit))
+	if len(categories) > 0 {
+		query.Set("categories", strings.Join(categories, ","))
+	}
+	if from != nil {
+		query.Set("from", from.UTC().Format(time.RFC3339))
+	}
+	if to != nil {
+		query.Set("to", to.UTC().Format(time.RFC3339))
+	}
+	status, body, err := c.do(http.MethodGet, "/api/appointments/timeline?"+query.Encode(), nil, headers)
+	if err != nil {
+		return nil, 0, err
+	}
+	if status != http.StatusOK {
+		return nil, 0, fmt.Errorf("appointment service returned status %d", status)
+	}
+
+	var response struct {
+		Data struct {
+			Events []struct {
+				TimelineEvent
+				AppointmentID int `json:"appointment_id"`
+			} `json:"events"`
+			TotalCount int `json:"total_count"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode appointment service response: %w", err)
+	}
+	events := make([]TimelineEvent, 0, len(response.Data.Events))
+	for _, e := range response.Data.Events {
+		event := e.TimelineEvent
+		if event.Details == nil {
+			event.Details = map[string]interface{}{}
+		}
+		event.Details["appointment_id"] = e.AppointmentID
+		event.Source = "appointment-service"
+		events = append(events, event)
+	}
+	return events, response.Data.TotalCount, nil
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// ClinicalHandler groups handlers for structured allergies, medications and problems
+type ClinicalHandler struct {
+	clinicalService *ClinicalService
+	patientService  *PatientService
+	validator       *validator.Validate
+}
+
+// NewClinicalHandler constructs a new ClinicalHandler
+func NewClinicalHandler(clinicalService *ClinicalService, patientService *PatientService) *ClinicalHandler {
+	return &ClinicalHandler{clinicalService: clinicalService, patientService: patientService, validator: validator.New()}
+}
+
+// parseOptionalDate parses a YYYY-MM-DD date, returning nil for an empty value
+func parseOptionalDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
+
+// itemID parses the :itemId route parameter, writing a 400 response on failure
+func itemID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// respondClinicalError maps clinical service errors to HTTP responses
+func respondClinicalError(c *gin.Context, err error, operation string, patientID int) {
+	if errors.Is(err, ErrClinicalItemNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+	logging.LogError("Clinical item operation failed", "operation", operation, "error", err, "patient_id", patientID)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+}
+
+// defaultStatus returns status, or "active" when empty
+func defaultStatus(status string) string {
+	if status == "" {
+		return "active"
+	}
+	return status
+}
+
+// ---- Allergies ----
+
+// GetAllergies lists the patient's allergies (?status= to filter)
+func (h *ClinicalHandler) GetAllergies(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	allergies, err := h.clinicalService.ListAllergies(patient.ID, c.Query("status"))
+	if err != nil {
+		respondClinicalError(c, err, "get allergies", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "allergies": allergies})
+}
+
+// GetAllergy returns a single allergy
+func (h *ClinicalHandler) GetAllergy(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	allergy, err := h.clinicalService.GetAllergy(patient.ID, id)
+	if err != nil {
+		respondClinicalError(c, err, "get allergy", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, allergy)
+}
+
+// CreateAllergy adds an allergy to the patient
+func (h *ClinicalHandler) CreateAllergy(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	var req AllergyRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	allergy := &PatientAllergy{
+		PatientID: patient.ID,
+		Substance: req.Substance,
+		Reaction:  req.Reaction,
+		Severity:  req.Severity,
+		Status:    defaultStatus(req.Status),
+		Notes:     req.Notes,
+		CreatedBy: c.GetInt("user_id"),
+	}
+	if err := h.clinicalService.CreateAllergy(allergy); err != nil {
+		respondClinicalError(c, err, "create allergy", patient.ID)
+		return
+	}
+	c.JSON(http.StatusCreated, allergy)
+}
+
+// UpdateAllergy replaces an allergy of the patient
+func (h *ClinicalHandler) UpdateAllergy(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	var req AllergyRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	allergy := &PatientAllergy{
+		ID:        id,
+		PatientID: patient.ID,
+		Substance: req.Substance,
+		Reaction:  req.Reaction,
+		Severity:  req.Severity,
+		Status:    defaultStatus(req.Status),
+		Notes:     req.Notes,
+		UpdatedBy: c.GetInt("user_id"),
+	}
+	if err := h.clinicalService.UpdateAllergy(allergy); err != nil {
+		respondClinicalError(c, err, "update allergy", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, allergy)
+}
+
+// DeleteAllergy removes an allergy from the patient (history is kept)
+func (h *ClinicalHandler) DeleteAllergy(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	if err := h.clinicalService.DeleteAllergy(patient.ID, id, c.GetInt("user_id")); err != nil {
+		respondClinicalError(c, err, "delete allergy", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Allergy deleted successfully"})
+}
+
+// ---- Medications ----
+
+// medicationFromRequest builds a medication from a request, validating dates
+func medicationFromRequest(c *gin.Context, req MedicationRequest) (*PatientMedication, bool) {
+	startDate, err := parseOptionalDate(req.StartDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date", "details": "Dates must be in YYYY-MM-DD format"})
+		return nil, false
+	}
+	stopDate, err := parseOptionalDate(req.StopDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stop_date", "details": "Dates must be in YYYY-MM-DD format"})
+		return nil, false
+	}
+	if startDate != nil && stopDate != nil && stopDate.Before(*startDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stop_date", "details": "stop_date must not be before start_date"})
+		return nil, false
+	}
+	return &PatientMedication{
+		Name:      req.Name,
+		Dose:      req.Dose,
+		Route:     req.Route,
+		Frequency: req.Frequency,
+		StartDate: startDate,
+		StopDate:  stopDate,
+		Status:    defaultStatus(req.Status),
+		Notes:     req.Notes,
+	}, true
+}
+
+// GetMedications lists the patient's medications (?status= to filter)
+func (h *ClinicalHandler) GetMedications(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	medications, err := h.clinicalService.ListMedications(patient.ID, c.Query("status"))
+	if err != nil {
+		respondClinicalError(c, err, "get medications", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "medications": medications})
+}
+
+// GetMedication returns a single medication
+func (h *ClinicalHandler) GetMedication(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	medication, err := h.clinicalService.GetMedication(patient.ID, id)
+	if err != nil {
+		respondClinicalError(c, err, "get medication", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, medication)
+}
+
+// CreateMedication adds a medication to the patient
+func (h *ClinicalHandler) CreateMedication(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	var req MedicationRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	medication, ok := medicationFromRequest(c, req)
+	if !ok {
+		return
+	}
+	medication.PatientID = patient.ID
+	medication.CreatedBy = c.GetInt("user_id")
+	if err := h.clinicalService.CreateMedication(medication); err != nil {
+		respondClinicalError(c, err, "create medication", patient.ID)
+		return
+	}
+	c.JSON(http.StatusCreated, medication)
+}
+
+// UpdateMedication replaces a medication of the patient
+func (h *ClinicalHandler) UpdateMedication(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	var req MedicationRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	medication, ok := medicationFromRequest(c, req)
+	if !ok {
+		return
+	}
+	medication.ID = id
+	medication.PatientID = patient.ID
+	medication.UpdatedBy = c.GetInt("user_id")
+	if err := h.clinicalService.UpdateMedication(medication); err != nil {
+		respondClinicalError(c, err, "update medication", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, medication)
+}
+
+// DeleteMedication removes a medication from the patient (history is kept)
+func (h *ClinicalHandler) DeleteMedication(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	if err := h.clinicalService.DeleteMedication(patient.ID, id, c.GetInt("user_id")); err != nil {
+		respondClinicalError(c, err, "delete medication", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Medication deleted successfully"})
+}
+
+// ---- Problems ----
+
+// problemFromRequest builds a problem from a request, validating dates
+func problemFromRequest(c *gin.Context, req ProblemRequest) (*PatientProblem, bool) {
+	onsetDate, err := parseOptionalDate(req.OnsetDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid onset_date", "details": "Dates must be in YYYY-MM-DD format"})
+		return nil, false
+	}
+	resolvedDate, err := parseOptionalDate(req.ResolvedDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolved_date", "details": "Dates must be in YYYY-MM-DD format"})
+		return nil, false
+	}
+	codeSystem := req.CodeSystem
+	if codeSystem == "" && req.Code != "" {
+		codeSystem = "ICD-10"
+	}
+	return &PatientProblem{
+		Code:         req.Code,
+		CodeSystem:   codeSystem,
+		Description:  req.Description,
+		OnsetDate:    onsetDate,
+		ResolvedDate: resolvedDate,
+		Status:       defaultStatus(req.Status),
+		Notes:        req.Notes,
+	}, true
+}
+
+// GetProblems lists the patient's problem list (?status= to filter)
+func (h *ClinicalHandler) GetProblems(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	problems, err := h.clinicalService.ListProblems(patient.ID, c.Query("status"))
+	if err != nil {
+		respondClinicalError(c, err, "get problems", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "problems": problems})
+}
+
+// GetProblem returns a single problem
+func (h *ClinicalHandler) GetProblem(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	problem, err := h.clinicalService.GetProblem(patient.ID, id)
+	if err != nil {
+		respondClinicalError(c, err, "get problem", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, problem)
+}
+
+// CreateProblem adds a problem to the patient's problem list
+func (h *ClinicalHandler) CreateProblem(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	var req ProblemRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	problem, ok := problemFromRequest(c, req)
+	if !ok {
+		return
+	}
+	problem.PatientID = patient.ID
+	problem.CreatedBy = c.GetInt("user_id")
+	if err := h.clinicalService.CreateProblem(problem); err != nil {
+		respondClinicalError(c, err, "create problem", patient.ID)
+		return
+	}
+	c.JSON(http.StatusCreated, problem)
+}
+
+// UpdateProblem replaces a problem of the patient
+func (h *ClinicalHandler) UpdateProblem(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	var req ProblemRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	problem, ok := problemFromRequest(c, req)
+	if !ok {
+		return
+	}
+	problem.ID = id
+	problem.PatientID = patient.ID
+	problem.UpdatedBy = c.GetInt("user_id")
+	if err := h.clinicalService.UpdateProblem(problem); err != nil {
+		respondClinicalError(c, err, "update problem", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, problem)
+}
+
+// DeleteProblem removes a problem from the patient's problem list (history is kept)
+func (h *ClinicalHandler) DeleteProblem(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	id, ok := itemID(c)
+	if !ok {
+		return
+	}
+	if err := h.clinicalService.DeleteProblem(patient.ID, id, c.GetInt("user_id")); err != nil {
+		respondClinicalError(c, err, "delete problem", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Problem deleted successfully"})
+}
+
+// ---- History ----
+
+// GetItemHistory returns a handler listing the change history of one item type
+func (h *ClinicalHandler) GetItemHistory(itemType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		patient, ok := loadEntityPatient(c, h.patientService)
+		if !ok {
+			return
+		}
+		id, ok := itemID(c)
+		if !ok {
+			return
+		}
+		history, err := h.clinicalService.GetItemHistory(patient.ID, itemType, id)
+		if err != nil {
+			respondClinicalError(c, err, "get "+itemType+" history", patient.ID)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"item_type": itemType, "item_id": id, "history": history})
+	}
+}
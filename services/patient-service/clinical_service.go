@@ -0,0 +1,459 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrClinicalItemNotFound is returned when an allergy, medication or problem does not
+// exist for the patient (or has been deleted)
+var ErrClinicalItemNotFound = errors.New("clinical item not found")
+
+// ClinicalService manages structured allergies, medications and problems
+type ClinicalService struct {
+	db *sql.DB
+}
+
+// NewClinicalService creates a new clinical service
+func NewClinicalService(db *sql.DB) *ClinicalService {
+	return &ClinicalService{db: db}
+}
+
+const (
+	allergyColumns    = `id, patient_id, substance, reaction, severity, status, notes, created_at, updated_at, created_by, updated_by`
+	medicationColumns = `id, patient_id, name, dose, route, frequency, start_date, stop_date, status, notes, created_at, updated_at, created_by, updated_by`
+	problemColumns    = `id, patient_id, code, code_system, description, onset_date, resolved_date, status, notes, created_at, updated_at, created_by, updated_by`
+)
+
+func scanAllergy(row rowScanner) (*PatientAllergy, error) {
+	a := &PatientAllergy{}
+	err := row.Scan(&a.ID, &a.PatientID, &a.Substance, &a.Reaction, &a.Severity, &a.Status, &a.Notes,
+		&a.CreatedAt, &a.UpdatedAt, &a.CreatedBy, &a.UpdatedBy)
+	return a, err
+}
+
+func scanMedication(row rowScanner) (*PatientMedication, error) {
+	m := &PatientMedication{}
+	err := row.Scan(&m.ID, &m.PatientID, &m.Name, &m.Dose, &m.Route, &m.Frequency, &m.StartDate, &m.StopDate,
+		&m.Status, &m.Notes, &m.CreatedAt, &m.UpdatedAt, &m.CreatedBy, &m.UpdatedBy)
+	return m, err
+}
+
+func scanProblem(row rowScanner) (*PatientProblem, error) {
+	p := &PatientProblem{}
+	err := row.Scan(&p.ID, &p.PatientID, &p.Code, &p.CodeSystem, &p.Description, &p.OnsetDate, &p.ResolvedDate,
+		&p.Status, &p.Notes, &p.CreatedAt, &p.UpdatedAt, &p.CreatedBy, &p.UpdatedBy)
+	return p, err
+}
+
+// recordClinicalHistory appends a snapshot of an item to patient_clinical_history
+func recordClinicalHistory(tx *sql.Tx, patientID int, itemType string, itemID int, action string, item interface{}, userID int) error {
+	snapshot, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO patient_clinical_history (patient_id, item_type, item_id, action, snapshot, changed_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, patientID, itemType, itemID, action, snapshot, userID)
+	return err
+}
+
+// withHistory runs a write that returns the changed row, then records it in the history,
+// all in a single transaction
+func (s *ClinicalService) withHistory(patientID int, itemType, action string, userID int, write func(tx *sql.Tx) (int, interface{}, error)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := writeWithHistory(tx, patientID, itemType, action, userID, write); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeWithHistory is withHistory within the caller's transaction
+func writeWithHistory(tx *sql.Tx, patientID int, itemType, action string, userID int, write func(tx *sql.Tx) (int, interface{}, error)) error {
+	itemID, item, err := write(tx)
+	if err == sql.ErrNoRows {
+		return ErrClinicalItemNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return recordClinicalHistory(tx, patientID, itemType, itemID, action, item, userID)
+}
+
+// listFilter builds the WHERE clause shared by the list queries
+func listFilter(status string) (string, []interface{}) {
+	if status == "" {
+		return "WHERE patient_id = $1 AND deleted_at IS NULL", nil
+	}
+	return "WHERE patient_id = $1 AND deleted_at IS NULL AND status = $2", []interface{}{status}
+}
+
+// ListAllergies lists a patient's allergies, optionally filtered by status
+func (s *ClinicalService) ListAllergies(patientID int, status string) ([]PatientAllergy, error) {
+	filter, extra := listFilter(status)
+	rows, err := s.db.Query(`SELECT `+allergyColumns+` FROM patient_allergies `+filter+` ORDER BY id`,
+		append([]interface{}{patientID}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allergies := []PatientAllergy{}
+	for rows.Next() {
+		a, err := scanAllergy(rows)
+		if err != nil {
+			return nil, err
+		}
+		allergies = append(allergies, *a)
+	}
+	return allergies, rows.Err()
+}
+
+// GetAllergy gets a single allergy of a patient
+func (s *ClinicalService) GetAllergy(patientID, id int) (*PatientAllergy, error) {
+	a, err := scanAllergy(s.db.QueryRow(`SELECT `+allergyColumns+` FROM patient_allergies
+		WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL`, id, patientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrClinicalItemNotFound
+	}
+	return a, err
+}
+
+// CreateAllergy adds an allergy to a patient
+func (s *ClinicalService) CreateAllergy(a *PatientAllergy) error {
+	return s.withHistory(a.PatientID, "allergy", "created", a.CreatedBy, func(tx *sql.Tx) (int, interface{}, error) {
+		return insertAllergy(tx, a)
+	})
+}
+
+// insertAllergy inserts an allergy with tx and returns it for the history
+func insertAllergy(tx *sql.Tx, a *PatientAllergy) (int, interface{}, error) {
+	created, err := scanAllergy(tx.QueryRow(`
+		INSERT INTO patient_allergies (patient_id, substance, reaction, severity, status, notes, created_by, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		RETURNING `+allergyColumns,
+		a.PatientID, a.Substance, a.Reaction, a.Severity, a.Status, a.Notes, a.CreatedBy))
+	if err != nil {
+		return 0, nil, err
+	}
+	*a = *created
+	return a.ID, a, nil
+}
+
+// UpdateAllergy updates an allergy of a patient
+func (s *ClinicalService) UpdateAllergy(a *PatientAllergy) error {
+	return s.withHistory(a.PatientID, "allergy", "updated", a.UpdatedBy, func(tx *sql.Tx) (int, interface{}, error) {
+		updated, err := scanAllergy(tx.QueryRow(`
+			UPDATE patient_allergies
+			SET substance = $3, reaction = $4, severity = $5, status = $6, notes = $7, updated_by = $8, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL
+			RETURNING `+allergyColumns,
+			a.ID, a.PatientID, a.Substance, a.Reaction, a.Severity, a.Status, a.Notes, a.UpdatedBy))
+		if err != nil {
+			return 0, nil, err
+		}
+		*a = *updated
+		return a.ID, a, nil
+	})
+}
+
+// DeleteAllergy soft deletes an allergy, keeping its history
+func (s *ClinicalService) DeleteAllergy(patientID, id, userID int) error {
+	return s.withHistory(patientID, "allergy", "deleted", userID, func(tx *sql.Tx) (int, interface{}, error) {
+		deleted, err := scanAllergy(tx.QueryRow(`
+			UPDATE patient_allergies SET deleted_at = CURRENT_TIMESTAMP, updated_by = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL
+			RETURNING `+allergyColumns, id, patientID, userID))
+		if err != nil {
+			return 0, nil, err
+		}
+		return deleted.ID, deleted, nil
+	})
+}
+
+// ListMedications lists a patient's medications, optionally filtered by status
+func (s *ClinicalService) ListMedications(patientID int, status string) ([]PatientMedication, error) {
+	filter, extra := listFilter(status)
+	rows, err := s.db.Query(`SELECT `+medicationColumns+` FROM patient_medications `+filter+` ORDER BY start_date DESC NULLS LAST, id`,
+		append([]interface{}{patientID}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	medications := []PatientMedication{}
+	for rows.Next() {
+		m, err := scanMedication(rows)
+		if err != nil {
+			return nil, err
+		}
+		medications = append(medications, *m)
+	}
+	return medications, rows.Err()
+}
+
+// GetMedication gets a single medication of a patient
+func (s *ClinicalService) GetMedication(patientID, id int) (*PatientMedication, error) {
+	m, err := scanMedication(s.db.QueryRow(`SELECT `+medicationColumns+` FROM patient_medications
+		WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL`, id, patientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrClinicalItemNotFound
+	}
+	return m, err
+}
+
+// CreateMedication adds a medication to a patient
+func (s *ClinicalService) CreateMedication(m *PatientMedication) error {
+	return s.withHistory(m.PatientID, "medication", "created", m.CreatedBy, func(tx *sql.Tx) (int, interface{}, error) {
+		return insertMedication(tx, m)
+	})
+}
+
+// insertMedication inserts a medication with tx and returns it for the history
+func insertMedication(tx *sql.Tx, m *PatientMedication) (int, interface{}, error) {
+	created, err := scanMedication(tx.QueryRow(`
+		INSERT INTO patient_medications (patient_id, name, dose, route, frequency, start_date, stop_date, status, notes, created_by, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+		RETURNING `+medicationColumns,
+		m.PatientID, m.Name, m.Dose, m.Route, m.Frequency, m.StartDate, m.StopDate, m.Status, m.Notes, m.CreatedBy))
+	if err != nil {
+		return 0, nil, err
+	}
+	*m = *created
+	return m.ID, m, nil
+}
+
+// UpdateMedication updates a medication of a patient
+func (s *ClinicalService) UpdateMedication(m *PatientMedication) error {
+	return s.withHistory(m.PatientID, "medication", "updated", m.UpdatedBy, func(tx *sql.Tx) (int, interface{}, error) {
+		updated, err := scanMedication(tx.QueryRow(`
+			UPDATE patient_medications
+			SET name = $3, dose = $4, route = $5, frequency = $6, start_date = $7, stop_date = $8, status = $9, notes = $10,
+				updated_by = $11, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL
+			RETURNING `+medicationColumns,
+			m.ID, m.PatientID, m.Name, m.Dose, m.Route, m.Frequency, m.StartDate, m.StopDate, m.Status, m.Notes, m.UpdatedBy))
+		if err != nil {
+			return 0, nil, err
+		}
+		*m = *updated
+		return m.ID, m, nil
+	})
+}
+
+// DeleteMedication soft deletes a medication, keeping its history
+func (s *ClinicalService) DeleteMedication(patientID, id, userID int) error {
+	return s.withHistory(patientID, "medication", "deleted", userID, func(tx *sql.Tx) (int, interface{}, error) {
+		deleted, err := scanMedication(tx.QueryRow(`
+			UPDATE patient_medications SET deleted_at = CURRENT_TIMESTAMP, updated_by = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL
+			RETURNING `+medicationColumns, id, patientID, userID))
+		if err != nil {
+			return 0, nil, err
+		}
+		return deleted.ID, deleted, nil
+	})
+}
+
+// ListProblems lists a patient's problem list, optionally filtered by status
+func (s *ClinicalService) ListProblems(patientID int, status string) ([]PatientProblem, error) {
+	filter, extra := listFilter(status)
+	rows, err := s.db.Query(`SELECT `+problemColumns+` FROM patient_problems `+filter+` ORDER BY onset_date DESC NULLS LAST, id`,
+		append([]interface{}{patientID}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []PatientProblem{}
+	for rows.Next() {
+		p, err := scanProblem(rows)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, *p)
+	}
+	return problems, rows.Err()
+}
+
+// GetProblem gets a single problem of a patient
+func (s *ClinicalService) GetProblem(patientID, id int) (*PatientProblem, error) {
+	p, err := scanProblem(s.db.QueryRow(`SELECT `+problemColumns+` FROM patient_problems
+		WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL`, id, patientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrClinicalItemNotFound
+	}
+	return p, err
+}
+
+// CreateProblem adds a problem to a patient's problem list
+func (s *ClinicalService) CreateProblem(p *PatientProblem) error {
+	return s.withHistory(p.PatientID, "problem", "created", p.CreatedBy, func(tx *sql.Tx) (int, interface{}, error) {
+		return insertProblem(tx, p)
+	})
+}
+
+// insertProblem inserts a problem with tx and returns it for the history
+func insertProblem(tx *sql.Tx, p *PatientProblem) (int, interface{}, error) {
+	created, err := scanProblem(tx.QueryRow(`
+		INSERT INTO patient_problems (patient_id, code, code_system, description, onset_date, resolved_date, status, notes, created_by, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		RETURNING `+problemColumns,
+		p.PatientID, p.Code, p.CodeSystem, p.Description, p.OnsetDate, p.ResolvedDate, p.Status, p.Notes, p.CreatedBy))
+	if err != nil {
+		return 0, nil, err
+	}
+	*p = *created
+	return p.ID, p, nil
+}
+
+// UpdateProblem updates a problem of a patient
+func (s *ClinicalService) UpdateProblem(p *PatientProblem) error {
+	return s.withHistory(p.PatientID, "problem", "updated", p.UpdatedBy, func(tx *sql.Tx) (int, interface{}, error) {
+		updated, err := scanProblem(tx.QueryRow(`
+			UPDATE patient_problems
+			SET code = $3, code_system = $4, description = $5, onset_date = $6, resolved_date = $7, status = $8, notes = $9,
+				updated_by = $10, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL
+			RETURNING `+problemColumns,
+			p.ID, p.PatientID, p.Code, p.CodeSystem, p.Description, p.OnsetDate, p.ResolvedDate, p.Status, p.Notes, p.UpdatedBy))
+		if err != nil {
+			return 0, nil, err
+		}
+		*p = *updated
+		return p.ID, p, nil
+	})
+}
+
+// DeleteProblem soft deletes a problem, keeping its history
+func (s *ClinicalService) DeleteProblem(patientID, id, userID int) error {
+	return s.withHistory(patientID, "problem", "deleted", userID, func(tx *sql.Tx) (int, interface{}, error) {
+		deleted, err := scanProblem(tx.QueryRow(`
+			UPDATE patient_problems SET deleted_at = CURRENT_TIMESTAMP, updated_by = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL
+			RETURNING `+problemColumns, id, patientID, userID))
+		if err != nil {
+			return 0, nil, err
+		}
+		return deleted.ID, deleted, nil
+	})
+}
+
+// queryClinicalHistory runs a clinical history query and collects the entries
+func (s *ClinicalService) queryClinicalHistory(query string, args ...interface{}) ([]ClinicalHistoryEntry, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []ClinicalHistoryEntry{}
+	for rows.Next() {
+		var entry ClinicalHistoryEntry
+		var snapshot []byte
+		if err := rows.Scan(&entry.ID, &entry.ItemType, &entry.ItemID, &entry.Action, &snapshot, &entry.ChangedBy, &entry.ChangedAt); err != nil {
+			return nil, err
+		}
+		entry.Snapshot = json.RawMessage(snapshot)
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// GetItemHistory returns the recorded changes of a clinical item, oldest first
+func (s *ClinicalService) GetItemHistory(patientID int, itemType string, itemID int) ([]ClinicalHistoryEntry, error) {
+	history, err := s.queryClinicalHistory(`
+		SELECT id, item_type, item_id, action, snapshot, changed_by, changed_at
+		FROM patient_clinical_history
+		WHERE patient_id = $1 AND item_type = $2 AND item_id = $3
+		ORDER BY changed_at, id
+	`, patientID, itemType, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, ErrClinicalItemNotFound
+	}
+	return history, nil
+}
+
+// GetPatientHistory returns every recorded change of a patient's clinical items, oldest first
+func (s *ClinicalService) GetPatientHistory(patientID int) ([]ClinicalHistoryEntry, error) {
+	return s.queryClinicalHistory(`
+		SELECT id, item_type, item_id, action, snapshot, changed_by, changed_at
+		FROM patient_clinical_history
+		WHERE patient_id = $1
+		ORDER BY changed_at, id
+	`, patientID)
+}
+
+var (
+	legacyListSeparator    = regexp.MustCompile(`[,;\n]+`)
+	legacyHistorySeparator = regexp.MustCompile(`[;\n]+`)
+	legacyNoneValues       = map[string]bool{
+		"none": true, "none known": true, "no known allergies": true, "nka": true, "nkda": true,
+		"no medications": true, "no significant medical history": true, "n/a": true, "na": true, "-": true,
+	}
+)
+
+// splitLegacyText splits a free-text field the same way migration 15 did
+func splitLegacyText(text string, separator *regexp.Regexp) []string {
+	var items []string
+	for _, item := range separator.Split(text, -1) {
+		item = strings.TrimSpace(item)
+		if item == "" || legacyNoneValues[strings.ToLower(item)] {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// truncateRunes shortens s to at most n runes to fit a column
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+// seedFromLegacyText creates structured items from the free-text fields of a patient
+// creation request with tx, so clients that still send them keep working
+func seedFromLegacyText(tx *sql.Tx, patientID, userID int, medicalHistory, allergies, medications string) error {
+	for _, substance := range splitLegacyText(allergies, legacyListSeparator) {
+		a := &PatientAllergy{PatientID: patientID, Substance: truncateRunes(substance, 255), Status: "active", CreatedBy: userID}
+		if err := writeWithHistory(tx, patientID, "allergy", "created", userID, func(tx *sql.Tx) (int, interface{}, error) {
+			return insertAllergy(tx, a)
+		}); err != nil {
+			return fmt.Errorf("failed to seed allergy: %w", err)
+		}
+	}
+	for _, name := range splitLegacyText(medications, legacyListSeparator) {
+		m := &PatientMedication{PatientID: patientID, Name: truncateRunes(name, 255), Status: "active", CreatedBy: userID}
+		if err := writeWithHistory(tx, patientID, "medication", "created", userID, func(tx *sql.Tx) (int, interface{}, error) {
+			return insertMedication(tx, m)
+		}); err != nil {
+			return fmt.Errorf("failed to seed medication: %w", err)
+		}
+	}
+	for _, description := range splitLegacyText(medicalHistory, legacyHistorySeparator) {
+		p := &PatientProblem{PatientID: patientID, Description: truncateRunes(description, 500), Status: "active", CreatedBy: userID}
+		if err := writeWithHistory(tx, patientID, "problem", "created", userID, func(tx *sql.Tx) (int, interface{}, error) {
+			return insertProblem(tx, p)
+		}); err != nil {
+			return fmt.Errorf("failed to seed problem: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// allergyRows returns allergy 8 of patient 5
+func allergyRows(status string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "patient_id", "substance", "reaction", "severity", "status", "notes",
+		"created_at", "updated_at", "created_by", "updated_by"}).
+		AddRow(8, 5, "Penicillin", "Rash", "moderate", status, "", now, now, 2, 2)
+}
+
+// snapshotOf matches a history snapshot whose JSON has the given status
+type snapshotOf struct{ status string }
+
+func (m snapshotOf) Match(v driver.Value) bool {
+	data, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+	var snapshot struct {
+		Status string `json:"status"`
+	}
+	return json.Unmarshal(data, &snapshot) == nil && snapshot.Status == m.status
+}
+
+func TestCreateAllergyRecordsHistory(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO patient_allergies`).WithArgs(5, "Penicillin", "Rash", "moderate", "active", "", 2).
+		WillReturnRows(allergyRows("active"))
+	mock.ExpectExec(`INSERT INTO patient_clinical_history`).WithArgs(5, "allergy", 8, "created", snapshotOf{"active"}, 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	allergy := &PatientAllergy{PatientID: 5, Substance: "Penicillin", Reaction: "Rash", Severity: "moderate", Status: "active", CreatedBy: 2}
+	if err := NewClinicalService(db).CreateAllergy(allergy); err != nil {
+		t.Fatalf("CreateAllergy error = %v", err)
+	}
+	if allergy.ID != 8 {
+		t.Errorf("allergy ID = %d, want 8", allergy.ID)
+	}
+}
+
+func TestUpdateAllergyRecordsHistory(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE patient_allergies`).WithArgs(8, 5, "Penicillin", "Rash", "moderate", "resolved", "", 3).
+		WillReturnRows(allergyRows("resolved"))
+	mock.ExpectExec(`INSERT INTO patient_clinical_history`).WithArgs(5, "allergy", 8, "updated", snapshotOf{"resolved"}, 3).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	allergy := &PatientAllergy{ID: 8, PatientID: 5, Substance: "Penicillin", Reaction: "Rash", Severity: "moderate", Status: "resolved", UpdatedBy: 3}
+	if err := NewClinicalService(db).UpdateAllergy(allergy); err != nil {
+		t.Fatalf("UpdateAllergy error = %v", err)
+	}
+}
+
+func TestDeleteMissingAllergyRecordsNothing(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE patient_allergies SET deleted_at`).WithArgs(8, 5, 3).WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectRollback()
+
+	if err := NewClinicalService(db).DeleteAllergy(5, 8, 3); !errors.Is(err, ErrClinicalItemNotFound) {
+		t.Errorf("DeleteAllergy error = %v, want ErrClinicalItemNotFound", err)
+	}
+}
+
+func TestSplitLegacyText(t *testing.T) {
+	tests := []struct {
+		text      string
+		separator string
+		want      []string
+	}{
+		{"Penicillin, peanuts;latex", "list", []string{"Penicillin", "peanuts", "latex"}},
+		{"NKDA", "list", nil},
+		{" none ;\n N/A ", "list", nil},
+		{"Metformin 500mg\nLisinopril", "list", []string{"Metformin 500mg", "Lisinopril"}},
+		{"Diabetes, type 2; Hypertension", "history", []string{"Diabetes, type 2", "Hypertension"}},
+		{"", "history", nil},
+	}
+	for _, tt := range tests {
+		separator := legacyListSeparator
+		if tt.separator == "history" {
+			separator = legacyHistorySeparator
+		}
+		if got := splitLegacyText(tt.text, separator); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitLegacyText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	if got := truncateRunes("Pénicilline", 3); got != "Pén" {
+		t.Errorf("truncateRunes = %q, want Pén", got)
+	}
+	if got := truncateRunes("latex", 10); got != "latex" {
+		t.Errorf("truncateRunes = %q, want latex", got)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// ConsentHandler groups consent related handlers
+type ConsentHandler struct {
+	consentService *ConsentService
+	patientService *PatientService
+	validator      *validator.Validate
+}
+
+// NewConsentHandler constructs a new ConsentHandler
+func NewConsentHandler(consentService *ConsentService, patientService *PatientService) *ConsentHandler {
+	v := validator.New()
+	v.RegisterValidation("consent_type", func(fl validator.FieldLevel) bool {
+		return validConsentType(fl.Field().String())
+	})
+	return &ConsentHandler{consentService: consentService, patientService: patientService, validator: v}
+}
+
+// validConsentType reports whether t is one of ConsentTypes
+func validConsentType(t string) bool {
+	return slices.Contains(ConsentTypes, t)
+}
+
+// parseOptionalTime parses an RFC3339 timestamp, returning fallback when value is empty
+func parseOptionalTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// CreateConsentDocument publishes a new consent document version for the caller's entity
+func (h *ConsentHandler) CreateConsentDocument(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	var req ConsentDocumentRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	effectiveFrom, err := parseOptionalTime(req.EffectiveFrom, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid effective_from", "details": "effective_from must be an RFC3339 timestamp"})
+		return
+	}
+
+	doc := &ConsentDocument{
+		HealthcareEntityID: entityID,
+		ConsentType:        req.ConsentType,
+		Title:              req.Title,
+		Content:            req.Content,
+		Language:           req.Language,
+		EffectiveFrom:      effectiveFrom,
+		CreatedBy:          c.GetInt("user_id"),
+	}
+	if err := h.consentService.CreateConsentDocument(doc); err != nil {
+		if errors.Is(err, ErrConsentConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Consent document conflict", "details": err.Error()})
+			return
+		}
+		logging.LogError("Failed to create consent document", "error", err, "healthcare_entity_id", entityID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create consent document"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Consent document created successfully", "document": doc})
+}
+
+// GetConsentDocuments lists consent document versions, optionally filtered by ?type=
+func (h *ConsentHandler) GetConsentDocuments(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	consentType := c.Query("type")
+	if consentType != "" && !validConsentType(consentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consent type"})
+		return
+	}
+
+	documents, err := h.consentService.GetConsentDocuments(entityID, consentType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get consent documents"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"documents": documents})
+}
+
+// GetPatientConsents returns the patient's current consents
+func (h *ConsentHandler) GetPatientConsents(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	consents, err := h.consentService.GetActiveConsents(patient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get consents"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "consents": consents})
+}
+
+// GrantConsent records a consent given by the patient
+func (h *ConsentHandler) GrantConsent(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	var req ConsentGrantRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	grantedAt, err := parseOptionalTime(req.GrantedAt, time.Now())
+	if err != nil || grantedAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid granted_at", "details": "granted_at must be an RFC3339 timestamp not in the future"})
+		return
+	}
+
+	consent := &PatientConsent{
+		PatientID:          patient.ID,
+		HealthcareEntityID: patient.HealthcareEntityID,
+		ConsentType:        req.ConsentType,
+		GrantedAt:          grantedAt,
+		GrantedBy:          c.GetInt("user_id"),
+		GrantChannel:       req.Channel,
+	}
+	if err := h.consentService.GrantConsent(consent, req.DocumentID); err != nil {
+		if errors.Is(err, ErrConsentDocumentNotFound) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Consent document not found", "details": fmt.Sprintf("No effective '%s' consent document exists for this entity", req.ConsentType)})
+			return
+		}
+		if errors.Is(err, ErrConsentConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Consent conflict", "details": err.Error()})
+			return
+		}
+		logging.LogError("Failed to grant consent", "error", err, "patient_id", patient.ID, "consent_type", req.ConsentType)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record consent"})
+		return
+	}
+
+	logging.LogInfo("Consent granted", "patient_id", patient.ID, "consent_type", consent.ConsentType, "document_version", consent.DocumentVersion, "channel", consent.GrantChannel)
+	c.JSON(http.StatusCreated, gin.H{"message": "Consent recorded successfully", "consent": consent})
+}
+
+// RevokeConsent revokes one of the patient's consents
+func (h *ConsentHandler) RevokeConsent(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	consentID, err := strconv.Atoi(c.Param("consentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consent ID"})
+		return
+	}
+	var req ConsentRevokeRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	consent, err := h.consentService.RevokeConsent(patient.ID, consentID, c.GetInt("user_id"), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrConsentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Consent not found"})
+		case errors.Is(err, ErrConsentAlreadyRevoked):
+			c.JSON(http.StatusConflict, gin.H{"error": "Consent already revoked"})
+		default:
+			logging.LogError("Failed to revoke consent", "error", err, "patient_id", patient.ID, "consent_id", consentID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke consent"})
+		}
+		return
+	}
+
+	logging.LogInfo("Consent revoked", "patient_id", patient.ID, "consent_type", consent.ConsentType, "channel", req.Channel)
+	c.JSON(http.StatusOK, gin.H{"message": "Consent revoked successfully", "consent": consent})
+}
+
+// CheckConsent answers whether the patient currently consents to ?type=.
+// Intended for other services (e.g. appointment notifications) before contacting a patient.
+func (h *ConsentHandler) CheckConsent(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	consentType := c.Query("type")
+	if !validConsentType(consentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consent type", "details": "type must be one of: " + strings.Join(ConsentTypes, ", ")})
+		return
+	}
+
+	check, err := h.consentService.CheckConsent(patient.ID, consentType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check consent"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, check)
+}
+
+// ExportConsentHistory returns the full consent history as JSON, or CSV with ?format=csv
+func (h *ConsentHandler) ExportConsentHistory(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	history, err := h.consentService.GetConsentHistory(patient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get consent history"})
+		return
+	}
+
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "history": history})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=patient-%d-consents.csv", patient.ID))
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"consent_id", "consent_type", "status", "document_id", "document_version", "document_title",
+		"granted_at", "granted_by", "grant_channel", "revoked_at", "revoked_by", "revoke_channel", "revocation_reason"})
+	for _, consent := range history {
+		revokedAt, revokedBy, revokeChannel, reason := "", "", "", ""
+		if consent.RevokedAt != nil {
+			revokedAt = consent.RevokedAt.Format(time.RFC3339)
+		}
+		if consent.RevokedBy != nil {
+			revokedBy = strconv.Itoa(*consent.RevokedBy)
+		}
+		if consent.RevokeChannel != nil {
+			revokeChannel = *consent.RevokeChannel
+		}
+		if consent.RevocationReason != nil {
+			reason = *consent.RevocationReason
+		}
+		w.Write([]string{
+			strconv.Itoa(consent.ID),
+			consent.ConsentType,
+			consent.Status,
+			strconv.Itoa(consent.DocumentID),
+			strconv.Itoa(consent.DocumentVersion),
+			consent.DocumentTitle,
+			consent.GrantedAt.Format(time.RFC3339),
+			strconv.Itoa(consent.GrantedBy),
+			consent.GrantChannel,
+			revokedAt,
+			revokedBy,
+			revokeChannel,
+			reason,
+		})
+	}
+	w.Flush()
+}
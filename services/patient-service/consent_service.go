@@ -0,0 +1,294 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrConsentDocumentNotFound = errors.New("consent document not found")
+	ErrConsentNotFound         = errors.New("consent not found")
+	ErrConsentAlreadyRevoked   = errors.New("consent already revoked")
+	ErrConsentConflict         = errors.New("a concurrent change to the same consent type was recorded first, retry")
+)
+
+// ConsentService manages consent documents and patient consents
+type ConsentService struct {
+	db *sql.DB
+}
+
+// NewConsentService creates a new consent service
+func NewConsentService(db *sql.DB) *ConsentService {
+	return &ConsentService{db: db}
+}
+
+const consentColumns = `
+	pc.id, pc.patient_id, pc.healthcare_entity_id, pc.consent_type, pc.document_id, cd.version, cd.title,
+	pc.granted_at, pc.granted_by, pc.grant_channel, pc.revoked_at, pc.revoked_by, pc.revoke_channel, pc.revocation_reason`
+
+// scanConsent scans a row selected with consentColumns
+func scanConsent(row rowScanner) (*PatientConsent, error) {
+	consent := &PatientConsent{}
+	err := row.Scan(
+		&consent.ID,
+		&consent.PatientID,
+		&consent.HealthcareEntityID,
+		&consent.ConsentType,
+		&consent.DocumentID,
+		&consent.DocumentVersion,
+		&consent.DocumentTitle,
+		&consent.GrantedAt,
+		&consent.GrantedBy,
+		&consent.GrantChannel,
+		&consent.RevokedAt,
+		&consent.RevokedBy,
+		&consent.RevokeChannel,
+		&consent.RevocationReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+	consent.Status = "granted"
+	if consent.RevokedAt != nil {
+		consent.Status = "revoked"
+	}
+	return consent, nil
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// CreateConsentDocument stores a new version of a consent document for an entity and type.
+// Two documents published at once would get the same version: the second one fails with
+// ErrConsentConflict.
+func (s *ConsentService) CreateConsentDocument(doc *ConsentDocument) error {
+	query := `
+		INSERT INTO consent_documents (healthcare_entity_id, consent_type, version, title, content, language, effective_from, created_by)
+		SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4, $5, $6, $7
+		FROM consent_documents
+		WHERE healthcare_entity_id = $1 AND consent_type = $2
+		RETURNING id, version, created_at
+	`
+	err := s.db.QueryRow(query,
+		doc.HealthcareEntityID,
+		doc.ConsentType,
+		doc.Title,
+		doc.Content,
+		doc.Language,
+		doc.EffectiveFrom,
+		doc.CreatedBy,
+	).Scan(&doc.ID, &doc.Version, &doc.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrConsentConflict
+	}
+	return err
+}
+
+// GetConsentDocuments lists consent documents of an entity, newest version first
+func (s *ConsentService) GetConsentDocuments(healthcareEntityID int, consentType string) ([]ConsentDocument, error) {
+	query := `
+		SELECT id, healthcare_entity_id, consent_type, version, title, content, language, effective_from, created_at, created_by
+		FROM consent_documents
+		WHERE healthcare_entity_id = $1 AND ($2 = '' OR consent_type = $2)
+		ORDER BY consent_type, version DESC
+	`
+	rows, err := s.db.Query(query, healthcareEntityID, consentType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := []ConsentDocument{}
+	for rows.Next() {
+		var doc ConsentDocument
+		if err := rows.Scan(&doc.ID, &doc.HealthcareEntityID, &doc.ConsentType, &doc.Version, &doc.Title,
+			&doc.Content, &doc.Language, &doc.EffectiveFrom, &doc.CreatedAt, &doc.CreatedBy); err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+	return documents, rows.Err()
+}
+
+// GrantConsent records a consent for a patient. When documentID is nil the latest effective
+// document version for the type is used. An existing active consent of the same type is
+// superseded so the patient always has a single current consent per type. Grants of a
+// patient are serialized by locking the patient row.
+func (s *ConsentService) GrantConsent(consent *PatientConsent, documentID *int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT id FROM patients WHERE id = $1 FOR UPDATE`, consent.PatientID); err != nil {
+		return err
+	}
+
+	docQuery := `
+		SELECT id, version, title FROM consent_documents
+		WHERE healthcare_entity_id = $1 AND consent_type = $2 AND effective_from <= CURRENT_TIMESTAMP
+		ORDER BY version DESC LIMIT 1
+	`
+	docArgs := []interface{}{consent.HealthcareEntityID, consent.ConsentType}
+	if documentID != nil {
+		docQuery = `
+			SELECT id, version, title FROM consent_documents
+			WHERE healthcare_entity_id = $1 AND consent_type = $2 AND id = $3
+		`
+		docArgs = append(docArgs, *documentID)
+	}
+	err = tx.QueryRow(docQuery, docArgs...).Scan(&consent.DocumentID, &consent.DocumentVersion, &consent.DocumentTitle)
+	if err == sql.ErrNoRows {
+		return ErrConsentDocumentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE patient_consents
+		SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $3, revoke_channel = $4, revocation_reason = 'Superseded by a new consent'
+		WHERE patient_id = $1 AND consent_type = $2 AND revoked_at IS NULL
+	`, consent.PatientID, consent.ConsentType, consent.GrantedBy, consent.GrantChannel)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO patient_consents (patient_id, healthcare_entity_id, consent_type, document_id, granted_at, granted_by, grant_channel)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, consent.PatientID, consent.HealthcareEntityID, consent.ConsentType, consent.DocumentID,
+		consent.GrantedAt, consent.GrantedBy, consent.GrantChannel).Scan(&consent.ID)
+	if isUniqueViolation(err) {
+		return ErrConsentConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	consent.Status = "granted"
+	return tx.Commit()
+}
+
+// RevokeConsent revokes a patient's consent. It takes effect immediately for all
+// subsequent consent checks since checks always read the current record.
+func (s *ConsentService) RevokeConsent(patientID, consentID, userID int, req ConsentRevokeRequest) (*PatientConsent, error) {
+	result, err := s.db.Exec(`
+		UPDATE patient_consents
+		SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $3, revoke_channel = $4, revocation_reason = NULLIF($5, '')
+		WHERE id = $1 AND patient_id = $2 AND revoked_at IS NULL
+	`, consentID, patientID, userID, req.Channel, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	consent, err := s.getConsent(patientID, consentID)
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrConsentAlreadyRevoked
+	}
+	return consent, nil
+}
+
+// getConsent loads a single consent record of a patient
+func (s *ConsentService) getConsent(patientID, consentID int) (*PatientConsent, error) {
+	query := `SELECT ` + consentColumns + `
+		FROM patient_consents pc
+		JOIN consent_documents cd ON cd.id = pc.document_id
+		WHERE pc.id = $1 AND pc.patient_id = $2
+	`
+	consent, err := scanConsent(s.db.QueryRow(query, consentID, patientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrConsentNotFound
+	}
+	return consent, err
+}
+
+// GetActiveConsents returns the current (non-revoked) consents of a patient
+func (s *ConsentService) GetActiveConsents(patientID int) ([]PatientConsent, error) {
+	return s.queryConsents(`
+		WHERE pc.patient_id = $1 AND pc.revoked_at IS NULL
+		ORDER BY pc.consent_type
+	`, patientID)
+}
+
+// GetConsentHistory returns every consent record of a patient, newest first
+func (s *ConsentService) GetConsentHistory(patientID int) ([]PatientConsent, error) {
+	return s.queryConsents(`
+		WHERE pc.patient_id = $1
+		ORDER BY pc.granted_at DESC, pc.id DESC
+	`, patientID)
+}
+
+func (s *ConsentService) queryConsents(filter string, args ...interface{}) ([]PatientConsent, error) {
+	query := `SELECT ` + consentColumns + `
+		FROM patient_consents pc
+		JOIN consent_documents cd ON cd.id = pc.document_id
+	` + filter
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consents := []PatientConsent{}
+	for rows.Next() {
+		consent, err := scanConsent(rows)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, *consent)
+	}
+	return consents, rows.Err()
+}
+
+// CheckConsent reports whether a patient currently consents to the given type.
+// The answer is read from the database on every call (no caching) so revocations
+// are honoured immediately by callers.
+func (s *ConsentService) CheckConsent(patientID int, consentType string) (*ConsentCheckResponse, error) {
+	check := &ConsentCheckResponse{
+		PatientID:   patientID,
+		ConsentType: consentType,
+		CheckedAt:   time.Now(),
+	}
+
+	var (
+		consentID int
+		version   int
+		grantedAt time.Time
+	)
+	err := s.db.QueryRow(`
+		SELECT pc.id, cd.version, pc.granted_at
+		FROM patient_consents pc
+		JOIN consent_documents cd ON cd.id = pc.document_id
+		WHERE pc.patient_id = $1 AND pc.consent_type = $2 AND pc.revoked_at IS NULL
+	`, patientID, consentType).Scan(&consentID, &version, &grantedAt)
+	if err == sql.ErrNoRows {
+		return check, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	check.Granted = true
+	check.ConsentID = &consentID
+	check.DocumentVersion = &version
+	check.GrantedAt = &grantedAt
+	return check, nil
+}
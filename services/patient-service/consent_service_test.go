@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newMockDB returns a database whose queries are matched by regular expressions
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+func TestGrantConsentSupersedesActiveConsent(t *testing.T) {
+	db, mock := newMockDB(t)
+	grantedAt := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM patients WHERE id = \$1 FOR UPDATE`).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT id, version, title FROM consent_documents .* ORDER BY version DESC LIMIT 1`).
+		WithArgs(1, "data_sharing").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "title"}).AddRow(12, 3, "Data sharing v3"))
+	mock.ExpectExec(`UPDATE patient_consents\s+SET revoked_at = CURRENT_TIMESTAMP.*'Superseded by a new consent'`).
+		WithArgs(5, "data_sharing", 2, "in_person").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO patient_consents`).
+		WithArgs(5, 1, "data_sharing", 12, grantedAt, 2, "in_person").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(40))
+	mock.ExpectCommit()
+
+	consent := &PatientConsent{PatientID: 5, HealthcareEntityID: 1, ConsentType: "data_sharing",
+		GrantedAt: grantedAt, GrantedBy: 2, GrantChannel: "in_person"}
+	if err := NewConsentService(db).GrantConsent(consent, nil); err != nil {
+		t.Fatalf("GrantConsent error = %v", err)
+	}
+	if consent.ID != 40 || consent.DocumentVersion != 3 || consent.Status != "granted" {
+		t.Errorf("consent %d of document version %d is %q, want consent 40 of version 3 granted",
+			consent.ID, consent.DocumentVersion, consent.Status)
+	}
+}
+
+func TestGrantConsentWithoutDocument(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM patients`).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM consent_documents`).WithArgs(1, "research", 99).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "title"}))
+	mock.ExpectRollback()
+
+	documentID := 99
+	consent := &PatientConsent{PatientID: 5, HealthcareEntityID: 1, ConsentType: "research", GrantedBy: 2, GrantChannel: "paper"}
+	if err := NewConsentService(db).GrantConsent(consent, &documentID); !errors.Is(err, ErrConsentDocumentNotFound) {
+		t.Errorf("GrantConsent error = %v, want ErrConsentDocumentNotFound", err)
+	}
+}
+
+func TestRevokeConsentTwice(t *testing.T) {
+	db, mock := newMockDB(t)
+	now := time.Now()
+	mock.ExpectExec(`UPDATE patient_consents`).WithArgs(40, 5, 2, "phone", "").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM patient_consents pc`).WithArgs(40, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "patient_id", "healthcare_entity_id", "consent_type", "document_id",
+			"version", "title", "granted_at", "granted_by", "grant_channel", "revoked_at", "revoked_by", "revoke_channel",
+			"revocation_reason"}).
+			AddRow(40, 5, 1, "data_sharing", 12, 3, "Data sharing v3", now, 2, "in_person", now, 2, "phone", nil))
+
+	_, err := NewConsentService(db).RevokeConsent(5, 40, 2, ConsentRevokeRequest{Channel: "phone"})
+	if !errors.Is(err, ErrConsentAlreadyRevoked) {
+		t.Errorf("RevokeConsent error = %v, want ErrConsentAlreadyRevoked", err)
+	}
+}
+
+func TestCheckConsent(t *testing.T) {
+	const checkQuery = `SELECT pc.id, cd.version, pc.granted_at\s+FROM patient_consents pc`
+	t.Run("granted", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(checkQuery).WithArgs(5, "data_sharing").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "version", "granted_at"}).AddRow(40, 3, time.Now()))
+
+		check, err := NewConsentService(db).CheckConsent(5, "data_sharing")
+		if err != nil {
+			t.Fatalf("CheckConsent error = %v", err)
+		}
+		if !check.Granted || check.ConsentID == nil || *check.ConsentID != 40 {
+			t.Errorf("CheckConsent = %+v, want consent 40 granted", check)
+		}
+	})
+	t.Run("none or revoked", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(checkQuery).WithArgs(5, "data_sharing").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "version", "granted_at"}))
+
+		check, err := NewConsentService(db).CheckConsent(5, "data_sharing")
+		if err != nil {
+			t.Fatalf("CheckConsent error = %v", err)
+		}
+		if check.Granted {
+			t.Errorf("CheckConsent = %+v, want not granted", check)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nationalIDValidators validate national identity numbers by country (ISO 3166-1 alpha-2).
+// National IDs of other countries are only checked against the form's validation rules.
+var nationalIDValidators = map[string]func(string) error{
+	"MA": validateMoroccanCIN,
+	"CA": validateCanadianSIN,
+	"FR": validateFrenchNIR,
+}
+
+// normalizeIdentifier removes the separators people type in identity numbers
+func normalizeIdentifier(value string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "", ".", "").Replace(value))
+}
+
+var moroccanCINPattern = regexp.MustCompile(`^[A-Z]{1,2}[0-9]{5,6}$`)
+
+// validateMoroccanCIN checks a Carte d'Identité Nationale number: one or two letters (the
+// issuing region) followed by 5 or 6 digits
+func validateMoroccanCIN(value string) error {
+	if !moroccanCINPattern.MatchString(normalizeIdentifier(value)) {
+		return errors.New("must be a Moroccan CIN: 1 or 2 letters followed by 5 or 6 digits")
+	}
+	return nil
+}
+
+// validateCanadianSIN checks a Social Insurance Number: 9 digits with a valid Luhn check digit
+func validateCanadianSIN(value string) error {
+	sin := normalizeIdentifier(value)
+	if len(sin) != 9 || strings.Trim(sin, "0123456789") != "" {
+		return errors.New("must be a Canadian SIN: 9 digits")
+	}
+	if !luhnValid(sin) {
+		return errors.New("is not a valid Canadian SIN (check digit mismatch)")
+	}
+	return nil
+}
+
+// luhnValid reports whether a string of digits ends with a valid Luhn check digit
+func luhnValid(digits string) bool {
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// frenchNIRPattern is sex (1, 2, or 3, 4, 7, 8 for temporary numbers), birth year, birth month
+// (20-42 and 50-99 are used when the month is unknown), department (2A and 2B for Corsica),
+// commune, order number and key
+var frenchNIRPattern = regexp.MustCompile(`^[1-478][0-9]{2}(0[1-9]|1[0-2]|[2-9][0-9])([0-9]{2}|2[AB])[0-9]{6}[0-9]{2}$`)
+
+// validateFrenchNIR checks a numéro d'inscription au répertoire (social security number): 13
+// characters and a 2-digit key equal to 97 minus the number modulo 97
+func validateFrenchNIR(value string) error {
+	nir := normalizeIdentifier(value)
+	if !frenchNIRPattern.MatchString(nir) {
+		return errors.New("must be a French NIR: 13 characters followed by a 2-digit key")
+	}
+	// Corsican departments are 2A → 19 and 2B → 18 for the key computation
+	number := strings.NewReplacer("2A", "19", "2B", "18").Replace(nir[:13])
+	n, _ := strconv.ParseInt(number, 10, 64)
+	key, _ := strconv.Atoi(nir[13:])
+	if int64(key) != 97-n%97 {
+		return errors.New("is not a valid French NIR (key mismatch)")
+	}
+	return nil
+}
+
+// phoneNumberingPlan describes the national significant numbers of a calling code
+type phoneNumberingPlan struct {
+	callingCode string
+	trunkPrefix string         // Dialled before national numbers, dropped in E.164
+	pattern     *regexp.Regexp // National significant number
+	adjective   string
+	example     string
+}
+
+var nanpPattern = regexp.MustCompile(`^[2-9][0-9]{2}[2-9][0-9]{6}$`)
+
+// phoneNumberingPlans are the numbering plans of the countries phone numbers are parsed for
+var phoneNumberingPlans = map[string]phoneNumberingPlan{
+	"MA": {callingCode: "212", trunkPrefix: "0", pattern: regexp.MustCompile(`^[5-8][0-9]{8}$`), adjective: "Moroccan", example: "+212 6 12 34 56 78"},
+	"FR": {callingCode: "33", trunkPrefix: "0", pattern: regexp.MustCompile(`^[1-9][0-9]{8}$`), adjective: "French", example: "+33 6 12 34 56 78"},
+	"CA": {callingCode: "1", trunkPrefix: "1", pattern: nanpPattern, adjective: "Canadian", example: "+1 613 555 0123"},
+	"US": {callingCode: "1", trunkPrefix: "1", pattern: nanpPattern, adjective: "US", example: "+1 202 555 0123"},
+}
+
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "")
+
+// parsePhoneNumber parses a phone number written in international format, or in the national
+// format of the country, and returns it in E.164 format (+ and up to 15 digits).
+// International numbers of calling codes without a numbering plan are only checked for length.
+// National numbers of countries without a numbering plan must have 10 to 15 digits and are
+// returned as digits only, since their calling code is unknown.
+func parsePhoneNumber(value, countryCode string) (string, error) {
+	number := phoneSeparators.Replace(strings.TrimSpace(value))
+	international := false
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number, international = number[1:], true
+	case strings.HasPrefix(number, "00"):
+		number, international = number[2:], true
+	}
+	if number == "" || strings.Trim(number, "0123456789") != "" {
+		return "", errors.New("must contain only digits, spaces, dashes, dots, parentheses and a leading +")
+	}
+
+	if international {
+		if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+			return "", errors.New("must be an international number of 8 to 15 digits")
+		}
+		// Check the number against the numbering plan of its calling code when known
+		if plan, ok := phoneNumberingPlans[countryCode]; ok && strings.HasPrefix(number, plan.callingCode) {
+			if !plan.pattern.MatchString(number[len(plan.callingCode):]) {
+				return "", fmt.Errorf("must be a valid %s phone number (e.g. %s)", plan.adjective, plan.example)
+			}
+			return "+" + number, nil
+		}
+		for _, plan := range phoneNumberingPlans {
+			if strings.HasPrefix(number, plan.callingCode) && !plan.pattern.MatchString(number[len(plan.callingCode):]) {
+				return "", fmt.Errorf("must be a valid +%s phone number", plan.callingCode)
+			}
+		}
+		return "+" + number, nil
+	}
+
+	plan, ok := phoneNumberingPlans[countryCode]
+	if !ok {
+		if len(number) < 10 || len(number) > 15 {
+			return "", errors.New("must contain 10 to 15 digits")
+		}
+		return number, nil
+	}
+	if nsn, ok := strings.CutPrefix(number, plan.trunkPrefix); ok && plan.pattern.MatchString(nsn) {
+		number = nsn
+	}
+	if !plan.pattern.MatchString(number) {
+		return "", fmt.Errorf("must be a valid %s phone number (e.g. %s)", plan.adjective, plan.example)
+	}
+	return "+" + plan.callingCode + number, nil
+}
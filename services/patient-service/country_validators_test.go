@@ -0,0 +1,98 @@
+package main
+
+import "testing"
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   bool
+	}{
+		{"79927398713", true},
+		{"79927398710", false},
+		{"046454286", true},
+		{"046454287", false},
+		{"0", true},
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.digits); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.digits, got, tt.want)
+		}
+	}
+}
+
+func TestNationalIDValidators(t *testing.T) {
+	tests := []struct {
+		name    string
+		country string
+		value   string
+		wantErr bool
+	}{
+		{"Moroccan CIN with one letter", "MA", "A123456", false},
+		{"Moroccan CIN with two letters", "MA", "BK12345", false},
+		{"Moroccan CIN in lower case with spaces", "MA", "bk 123 456", false},
+		{"Moroccan CIN without letters", "MA", "1234567", true},
+		{"Moroccan CIN with too many digits", "MA", "A1234567", true},
+		{"Canadian SIN", "CA", "046 454 286", false},
+		{"Canadian SIN with dashes", "CA", "046-454-286", false},
+		{"Canadian SIN with a wrong check digit", "CA", "046 454 287", true},
+		{"Canadian SIN with 8 digits", "CA", "04645428", true},
+		{"Canadian SIN with letters", "CA", "04645428A", true},
+		{"French NIR", "FR", "1 85 05 78 006 084 91", false},
+		{"French NIR with an unknown birth month", "FR", "269099900000103", false},
+		{"French NIR born in Corse-du-Sud", "FR", "185052A00608435", false},
+		{"French NIR born in Haute-Corse", "FR", "185052B00608462", false},
+		{"French NIR with a wrong key", "FR", "185057800608492", true},
+		{"French NIR with the Corse-du-Sud key of Haute-Corse", "FR", "185052B00608435", true},
+		{"French NIR with an invalid sex digit", "FR", "585057800608491", true},
+		{"French NIR with month 00", "FR", "185007800608491", true},
+		{"French NIR without a key", "FR", "1850578006084", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := nationalIDValidators[tt.country](tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validating %q: got error %v, want error %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePhoneNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		country string
+		want    string
+		wantErr bool
+	}{
+		{"Moroccan national number", "06 12 34 56 78", "MA", "+212612345678", false},
+		{"Moroccan international number", "+212 6 12 34 56 78", "MA", "+212612345678", false},
+		{"Moroccan number with 00 prefix", "00212612345678", "MA", "+212612345678", false},
+		{"Moroccan number with an invalid prefix", "02 12 34 56 78", "MA", "", true},
+		{"French national number", "06.12.34.56.78", "FR", "+33612345678", false},
+		{"French number too short", "06 12 34 56", "FR", "", true},
+		{"Canadian number without trunk prefix", "(613) 555-0123", "CA", "+16135550123", false},
+		{"Canadian number with trunk prefix", "1 613 555 0123", "CA", "+16135550123", false},
+		{"US number with an invalid area code", "123 555 0123", "US", "", true},
+		{"International number of another country's plan", "+33 6 12 34 56 78", "MA", "+33612345678", false},
+		{"International number invalid in its plan", "+212 1 23 45 67 89", "FR", "", true},
+		{"International number without a plan", "+44 20 7946 0958", "FR", "+442079460958", false},
+		{"International number too short", "+4420794", "FR", "", true},
+		{"National number of a country without a plan", "020 7946 0958", "GB", "02079460958", false},
+		{"National number without a plan too short", "79460958", "GB", "", true},
+		{"Number without a country", "+212612345678", "", "+212612345678", false},
+		{"Number with letters", "06 12 AB 56 78", "MA", "", true},
+		{"Empty number", " ", "MA", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePhoneNumber(tt.value, tt.country)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePhoneNumber(%q, %q) error = %v, want error %v", tt.value, tt.country, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parsePhoneNumber(%q, %q) = %q, want %q", tt.value, tt.country, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// CoverageHandler groups insurance coverage and eligibility check handlers
+type CoverageHandler struct {
+	coverageService *CoverageService
+	patientService  *PatientService
+	validator       *validator.Validate
+}
+
+// NewCoverageHandler constructs a new CoverageHandler
+func NewCoverageHandler(coverageService *CoverageService, patientService *PatientService) *CoverageHandler {
+	return &CoverageHandler{coverageService: coverageService, patientService: patientService, validator: validator.New()}
+}
+
+// respondCoverageError maps coverage service errors to HTTP responses
+func respondCoverageError(c *gin.Context, err error, operation string, patientID int) {
+	switch {
+	case errors.Is(err, ErrCoverageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coverage not found"})
+	case errors.Is(err, ErrCoverageOverlap):
+		c.JSON(http.StatusConflict, gin.H{"error": "Coverage overlaps", "details": err.Error()})
+	case errors.Is(err, ErrProviderNotOfType):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+	case errors.Is(err, ErrInsuranceDirectoryUnavailable):
+		logging.LogWarn("Failed to validate insurance provider", "error", err, "patient_id", patientID)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not validate the insurance provider", "details": "location-service is unavailable, try again later"})
+	default:
+		logging.LogError("Coverage operation failed", "operation", operation, "error", err, "patient_id", patientID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// coverageFromRequest builds a coverage of the patient from a create or update request
+func coverageFromRequest(req *CoverageRequest, patient *Patient) (*PatientCoverage, error) {
+	effectiveFrom, err := time.Parse("2006-01-02", req.EffectiveFrom)
+	if err != nil {
+		return nil, errors.New("effective_from must be a YYYY-MM-DD date")
+	}
+	effectiveTo, err := parseOptionalDate(req.EffectiveTo)
+	if err != nil {
+		return nil, errors.New("effective_to must be a YYYY-MM-DD date")
+	}
+	if effectiveTo != nil && effectiveTo.Before(effectiveFrom) {
+		return nil, errors.New("effective_to must not be before effective_from")
+	}
+
+	cov := &PatientCoverage{
+		PatientID:              patient.ID,
+		HealthcareEntityID:     patient.HealthcareEntityID,
+		Rank:                   req.Rank,
+		InsuranceTypeID:        req.InsuranceTypeID,
+		InsuranceProviderID:    req.InsuranceProviderID,
+		PolicyNumber:           strings.TrimSpace(req.PolicyNumber),
+		MemberID:               strings.TrimSpace(req.MemberID),
+		GroupNumber:            strings.TrimSpace(req.GroupNumber),
+		SubscriberRelationship: req.SubscriberRelationship,
+		EffectiveFrom:          effectiveFrom,
+		EffectiveTo:            effectiveTo,
+		AnnualLimit:            req.AnnualLimit,
+		Deductible:             req.Deductible,
+		Copay:                  req.Copay,
+		CoinsurancePercent:     req.CoinsurancePercent,
+		Currency:               strings.ToUpper(req.Currency),
+		Notes:                  strings.TrimSpace(req.Notes),
+	}
+	if cov.MemberID == "" {
+		return nil, errors.New("member_id is required")
+	}
+	// The patient is the subscriber of a self coverage; other coverages name the policy holder
+	if cov.SubscriberRelationship != "self" {
+		cov.SubscriberName = strings.TrimSpace(req.SubscriberName)
+		if cov.SubscriberName == "" {
+			return nil, errors.New("subscriber_name is required unless subscriber_relationship is self")
+		}
+		if cov.SubscriberDateOfBirth, err = parseOptionalDate(req.SubscriberDateOfBirth); err != nil {
+			return nil, errors.New("subscriber_date_of_birth must be a YYYY-MM-DD date")
+		}
+	}
+	return cov, nil
+}
+
+// loadCoverage loads the :coverageId coverage of the :id patient
+func (h *CoverageHandler) loadCoverage(c *gin.Context) (*Patient, *PatientCoverage, bool) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return nil, nil, false
+	}
+	coverageID, err := strconv.Atoi(c.Param("coverageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coverage ID"})
+		return nil, nil, false
+	}
+	cov, err := h.coverageService.GetCoverage(patient.ID, coverageID)
+	if err != nil {
+		respondCoverageError(c, err, "get coverage", patient.ID)
+		return nil, nil, false
+	}
+	return patient, cov, true
+}
+
+// GetCoverages lists the patient's coverages by rank (?active_on=YYYY-MM-DD, ?include_deleted=true)
+func (h *CoverageHandler) GetCoverages(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	activeOn, err := parseOptionalDate(c.Query("active_on"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid active_on", "details": "active_on must be a YYYY-MM-DD date"})
+		return
+	}
+	filter := CoverageFilter{ActiveOn: activeOn, IncludeDeleted: c.Query("include_deleted") == "true"}
+	coverages, err := h.coverageService.ListCoverages(patient.ID, filter)
+	if err != nil {
+		respondCoverageError(c, err, "get coverages", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "coverages": coverages})
+}
+
+// CreateCoverage adds an insurance coverage to the patient
+func (h *CoverageHandler) CreateCoverage(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	var req CoverageRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	cov, err := coverageFromRequest(&req, patient)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+	userID := c.GetInt("user_id")
+	cov.CreatedBy = &userID
+
+	if err := h.coverageService.CreateCoverage(cov); err != nil {
+		respondCoverageError(c, err, "create coverage", patient.ID)
+		return
+	}
+
+	logging.LogInfo("Patient coverage created", "coverage_id", cov.ID, "patient_id", patient.ID, "rank", cov.Rank,
+		"insurance_type_id", cov.InsuranceTypeID, "insurance_provider_id", cov.InsuranceProviderID)
+	c.JSON(http.StatusCreated, gin.H{"message": "Coverage created successfully", "coverage": cov})
+}
+
+// GetCoverage returns a coverage of the patient
+func (h *CoverageHandler) GetCoverage(c *gin.Context) {
+	_, cov, ok := h.loadCoverage(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, cov)
+}
+
+// UpdateCoverage replaces the details of a coverage
+func (h *CoverageHandler) UpdateCoverage(c *gin.Context) {
+	patient, existing, ok := h.loadCoverage(c)
+	if !ok {
+		return
+	}
+	var req CoverageRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	cov, err := coverageFromRequest(&req, patient)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+	cov.ID = existing.ID
+
+	if err := h.coverageService.UpdateCoverage(cov, c.GetInt("user_id")); err != nil {
+		respondCoverageError(c, err, "update coverage", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, cov)
+}
+
+// DeleteCoverage removes a coverage from the patient's active coverages; it stays in the history
+func (h *CoverageHandler) DeleteCoverage(c *gin.Context) {
+	patient, cov, ok := h.loadCoverage(c)
+	if !ok {
+		return
+	}
+	if err := h.coverageService.DeleteCoverage(patient.ID, cov.ID, c.GetInt("user_id")); err != nil {
+		respondCoverageError(c, err, "delete coverage", patient.ID)
+		return
+	}
+	logging.LogInfo("Patient coverage deleted", "coverage_id", cov.ID, "patient_id", patient.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Coverage deleted successfully"})
+}
+
+// CheckEligibility asks the configured eligibility checker whether a coverage applies on the
+// service date and records the answer. Checker failures are recorded and answered with 502.
+func (h *CoverageHandler) CheckEligibility(c *gin.Context) {
+	patient, cov, ok := h.loadCoverage(c)
+	if !ok {
+		return
+	}
+	var req EligibilityCheckRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	serviceDate := time.Now().UTC()
+	if req.ServiceDate != "" {
+		date, err := time.Parse("2006-01-02", req.ServiceDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": "service_date must be a YYYY-MM-DD date"})
+			return
+		}
+		serviceDate = date
+	}
+
+	check, err := h.coverageService.CheckEligibility(patient, cov, serviceDate, strings.TrimSpace(req.ServiceType), c.GetInt("user_id"))
+	if err != nil {
+		respondCoverageError(c, err, "check eligibility", patient.ID)
+		return
+	}
+	if check.Status == "error" {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Eligibility check failed", "details": fmt.Sprintf("%s: %s", check.Checker, check.Message), "check": check})
+		return
+	}
+	c.JSON(http.StatusOK, check)
+}
+
+// GetEligibilityChecks lists the recorded eligibility checks of a coverage, newest first
+func (h *CoverageHandler) GetEligibilityChecks(c *gin.Context) {
+	patient, cov, ok := h.loadCoverage(c)
+	if !ok {
+		return
+	}
+	checks, err := h.coverageService.ListEligibilityChecks(patient.ID, cov.ID)
+	if err != nil {
+		respondCoverageError(c, err, "get eligibility checks", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"coverage_id": cov.ID, "checks": checks})
+}
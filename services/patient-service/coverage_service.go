@@ -0,0 +1,298 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+var (
+	ErrCoverageNotFound              = errors.New("coverage not found")
+	ErrCoverageOverlap               = errors.New("another coverage of the same rank is effective in this period")
+	ErrProviderNotOfType             = errors.New("insurance provider does not offer this insurance type")
+	ErrInsuranceDirectoryUnavailable = errors.New("insurance providers could not be looked up")
+)
+
+// coverageColumns are the patient_coverages columns read by scanCoverage. They are unqualified
+// so they can be used in RETURNING clauses.
+const coverageColumns = `
+	id, patient_id, healthcare_entity_id, rank, insurance_type_id, insurance_provider_id, policy_number,
+	member_id, group_number, subscriber_relationship, subscriber_name, subscriber_date_of_birth,
+	effective_from, effective_to, annual_limit, deductible, copay, coinsurance_percent, currency, notes,
+	(deleted_at IS NULL AND effective_from <= CURRENT_DATE AND (effective_to IS NULL OR effective_to >= CURRENT_DATE)),
+	created_by, created_at, updated_by, updated_at, deleted_at, deleted_by`
+
+func scanCoverage(row rowScanner) (*PatientCoverage, error) {
+	cov := &PatientCoverage{}
+	err := row.Scan(&cov.ID, &cov.PatientID, &cov.HealthcareEntityID, &cov.Rank, &cov.InsuranceTypeID,
+		&cov.InsuranceProviderID, &cov.PolicyNumber, &cov.MemberID, &cov.GroupNumber, &cov.SubscriberRelationship,
+		&cov.SubscriberName, &cov.SubscriberDateOfBirth, &cov.EffectiveFrom, &cov.EffectiveTo, &cov.AnnualLimit,
+		&cov.Deductible, &cov.Copay, &cov.CoinsurancePercent, &cov.Currency, &cov.Notes, &cov.IsEffective,
+		&cov.CreatedBy, &cov.CreatedAt, &cov.UpdatedBy, &cov.UpdatedAt, &cov.DeletedAt, &cov.DeletedBy)
+	if err != nil {
+		return nil, err
+	}
+	return cov, nil
+}
+
+const eligibilityCheckColumns = `
+	id, coverage_id, patient_id, checker, status, service_date, service_type, message, details, checked_by, checked_at`
+
+func scanEligibilityCheck(row rowScanner) (*EligibilityCheck, error) {
+	check := &EligibilityCheck{}
+	var details []byte
+	err := row.Scan(&check.ID, &check.CoverageID, &check.PatientID, &check.Checker, &check.Status, &check.ServiceDate,
+		&check.ServiceType, &check.Message, &details, &check.CheckedBy, &check.CheckedAt)
+	if err != nil {
+		return nil, err
+	}
+	check.Details = details
+	return check, nil
+}
+
+// coversDate reports whether the coverage's effective window includes the day of t
+func (cov *PatientCoverage) coversDate(t time.Time) bool {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return !day.Before(cov.EffectiveFrom) && (cov.EffectiveTo == nil || !day.After(*cov.EffectiveTo))
+}
+
+// CoverageService manages the insurance coverages of patients and their eligibility checks
+type CoverageService struct {
+	db           *sql.DB
+	entityClient *EntityClient
+	checker      EligibilityChecker
+}
+
+// NewCoverageService creates a new coverage service. Providers are validated against
+// location-service; eligibility is asked of checker.
+func NewCoverageService(db *sql.DB, entityClient *EntityClient, checker EligibilityChecker) *CoverageService {
+	return &CoverageService{db: db, entityClient: entityClient, checker: checker}
+}
+
+// ListCoverages returns the coverages of a patient by rank, the latest first within a rank
+func (s *CoverageService) ListCoverages(patientID int, filter CoverageFilter) ([]PatientCoverage, error) {
+	query := `SELECT ` + coverageColumns + ` FROM patient_coverages WHERE patient_id = $1`
+	args := []interface{}{patientID}
+	if !filter.IncludeDeleted {
+		query += ` AND deleted_at IS NULL`
+	}
+	if filter.ActiveOn != nil {
+		args = append(args, *filter.ActiveOn)
+		query += ` AND effective_from <= $2 AND (effective_to IS NULL OR effective_to >= $2)`
+	}
+	query += ` ORDER BY rank, effective_from DESC, id DESC`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get coverages: %w", err)
+	}
+	defer rows.Close()
+
+	coverages := []PatientCoverage{}
+	for rows.Next() {
+		cov, err := scanCoverage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan coverage: %w", err)
+		}
+		coverages = append(coverages, *cov)
+	}
+	return coverages, rows.Err()
+}
+
+// GetCoverage returns a coverage of a patient that has not been deleted
+func (s *CoverageService) GetCoverage(patientID, coverageID int) (*PatientCoverage, error) {
+	cov, err := scanCoverage(s.db.QueryRow(`SELECT `+coverageColumns+`
+		FROM patient_coverages WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL`, coverageID, patientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrCoverageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get coverage: %w", err)
+	}
+	return cov, nil
+}
+
+// validateProvider checks with location-service that the provider offers the coverage's type
+func (s *CoverageService) validateProvider(cov *PatientCoverage) error {
+	offers, err := s.entityClient.InsuranceProviderOffersType(cov.InsuranceProviderID, cov.InsuranceTypeID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInsuranceDirectoryUnavailable, err)
+	}
+	if !offers {
+		return ErrProviderNotOfType
+	}
+	return nil
+}
+
+// checkRankWindow locks the patient row, serialising coverage changes of the patient, and
+// rejects a coverage whose effective window overlaps another coverage of the same rank
+func checkRankWindow(tx *sql.Tx, cov *PatientCoverage) error {
+	if _, err := tx.Exec(`SELECT id FROM patients WHERE id = $1 FOR UPDATE`, cov.PatientID); err != nil {
+		return err
+	}
+	var conflictID int
+	err := tx.QueryRow(`
+		SELECT id FROM patient_coverages
+		WHERE patient_id = $1 AND rank = $2 AND id <> $3 AND deleted_at IS NULL
+		  AND effective_from <= COALESCE($5::DATE, 'infinity'::DATE)
+		  AND (effective_to IS NULL OR effective_to >= $4)
+		ORDER BY effective_from LIMIT 1
+	`, cov.PatientID, cov.Rank, cov.ID, cov.EffectiveFrom, cov.EffectiveTo).Scan(&conflictID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w (coverage %d)", ErrCoverageOverlap, conflictID)
+}
+
+// CreateCoverage adds a coverage to a patient
+func (s *CoverageService) CreateCoverage(cov *PatientCoverage) error {
+	if err := s.validateProvider(cov); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkRankWindow(tx, cov); err != nil {
+		return err
+	}
+	created, err := scanCoverage(tx.QueryRow(`
+		INSERT INTO patient_coverages (
+			patient_id, healthcare_entity_id, rank, insurance_type_id, insurance_provider_id, policy_number, member_id,
+			group_number, subscriber_relationship, subscriber_name, subscriber_date_of_birth, effective_from, effective_to,
+			annual_limit, deductible, copay, coinsurance_percent, currency, notes, created_by, updated_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $20)
+		RETURNING `+coverageColumns,
+		cov.PatientID, cov.HealthcareEntityID, cov.Rank, cov.InsuranceTypeID, cov.InsuranceProviderID, cov.PolicyNumber,
+		cov.MemberID, cov.GroupNumber, cov.SubscriberRelationship, cov.SubscriberName, cov.SubscriberDateOfBirth,
+		cov.EffectiveFrom, cov.EffectiveTo, cov.AnnualLimit, cov.Deductible, cov.Copay, cov.CoinsurancePercent,
+		cov.Currency, cov.Notes, cov.CreatedBy,
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create coverage: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*cov = *created
+	return nil
+}
+
+// UpdateCoverage replaces the details of a coverage
+func (s *CoverageService) UpdateCoverage(cov *PatientCoverage, userID int) error {
+	if err := s.validateProvider(cov); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkRankWindow(tx, cov); err != nil {
+		return err
+	}
+	updated, err := scanCoverage(tx.QueryRow(`
+		UPDATE patient_coverages SET
+			rank = $3, insurance_type_id = $4, insurance_provider_id = $5, policy_number = $6, member_id = $7,
+			group_number = $8, subscriber_relationship = $9, subscriber_name = $10, subscriber_date_of_birth = $11,
+			effective_from = $12, effective_to = $13, annual_limit = $14, deductible = $15, copay = $16,
+			coinsurance_percent = $17, currency = $18, notes = $19, updated_by = $20, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL
+		RETURNING `+coverageColumns,
+		cov.ID, cov.PatientID, cov.Rank, cov.InsuranceTypeID, cov.InsuranceProviderID, cov.PolicyNumber, cov.MemberID,
+		cov.GroupNumber, cov.SubscriberRelationship, cov.SubscriberName, cov.SubscriberDateOfBirth, cov.EffectiveFrom,
+		cov.EffectiveTo, cov.AnnualLimit, cov.Deductible, cov.Copay, cov.CoinsurancePercent, cov.Currency, cov.Notes,
+		userID,
+	))
+	if err == sql.ErrNoRows {
+		return ErrCoverageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update coverage: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*cov = *updated
+	return nil
+}
+
+// DeleteCoverage soft deletes a coverage; its eligibility checks are kept
+func (s *CoverageService) DeleteCoverage(patientID, coverageID, userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE patient_coverages SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $3
+		WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL
+	`, coverageID, patientID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete coverage: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCoverageNotFound
+	}
+	return nil
+}
+
+// CheckEligibility asks the eligibility checker whether a coverage applies on serviceDate and
+// records the answer. A checker failure is recorded as a check with the error status.
+func (s *CoverageService) CheckEligibility(patient *Patient, cov *PatientCoverage, serviceDate time.Time, serviceType string, userID int) (*EligibilityCheck, error) {
+	result, err := s.checker.CheckEligibility(EligibilityQuery{
+		Patient:     patient,
+		Coverage:    cov,
+		ServiceDate: serviceDate,
+		ServiceType: serviceType,
+	})
+	if err != nil {
+		logging.LogWarn("Eligibility check failed", "error", err, "checker", s.checker.Name(), "coverage_id", cov.ID)
+		result = &EligibilityResult{Status: "error", Message: err.Error()}
+	}
+	details := []byte("{}")
+	if result.Details != nil {
+		if details, err = json.Marshal(result.Details); err != nil {
+			return nil, fmt.Errorf("failed to encode eligibility details: %w", err)
+		}
+	}
+
+	check, err := scanEligibilityCheck(s.db.QueryRow(`
+		INSERT INTO coverage_eligibility_checks (coverage_id, patient_id, checker, status, service_date, service_type, message, details, checked_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+eligibilityCheckColumns,
+		cov.ID, cov.PatientID, s.checker.Name(), result.Status, serviceDate, serviceType, result.Message, string(details), userID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record eligibility check: %w", err)
+	}
+	return check, nil
+}
+
+// ListEligibilityChecks returns the eligibility checks of a patient, newest first. A non-zero
+// coverageID restricts them to that coverage.
+func (s *CoverageService) ListEligibilityChecks(patientID, coverageID int) ([]EligibilityCheck, error) {
+	rows, err := s.db.Query(`SELECT `+eligibilityCheckColumns+`
+		FROM coverage_eligibility_checks
+		WHERE patient_id = $1 AND ($2 = 0 OR coverage_id = $2)
+		ORDER BY checked_at DESC, id DESC`, patientID, coverageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get eligibility checks: %w", err)
+	}
+	defer rows.Close()
+
+	checks := []EligibilityCheck{}
+	for rows.Next() {
+		check, err := scanEligibilityCheck(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan eligibility check: %w", err)
+		}
+		checks = append(checks, *check)
+	}
+	return checks, rows.Err()
+}
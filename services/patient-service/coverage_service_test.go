@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestCoverageCoversDate(t *testing.T) {
+	end := date(2025, 12, 31)
+	tests := []struct {
+		name string
+		to   *time.Time
+		at   time.Time
+		want bool
+	}{
+		{"before the start", &end, date(2024, 12, 31), false},
+		{"first day", &end, date(2025, 1, 1), true},
+		{"last day, late evening", &end, time.Date(2025, 12, 31, 22, 0, 0, 0, time.UTC), true},
+		{"after the end", &end, date(2026, 1, 1), false},
+		{"open-ended", nil, date(2040, 1, 1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cov := &PatientCoverage{EffectiveFrom: date(2025, 1, 1), EffectiveTo: tt.to}
+			if got := cov.coversDate(tt.at); got != tt.want {
+				t.Errorf("coversDate(%v) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMockEligibilityChecker(t *testing.T) {
+	copay := 50.0
+	tests := []struct {
+		memberID    string
+		serviceDate time.Time
+		wantStatus  string
+		wantErr     error
+	}{
+		{"CNSS-123", date(2025, 6, 1), "eligible", nil},
+		{"CNSS-123", date(2024, 6, 1), "ineligible", nil},
+		{"x-404", date(2025, 6, 1), "ineligible", nil},
+		{"ERR-1", date(2025, 6, 1), "", ErrEligibilityUnavailable},
+	}
+	for _, tt := range tests {
+		cov := &PatientCoverage{MemberID: tt.memberID, Rank: 1, EffectiveFrom: date(2025, 1, 1), Copay: &copay, Currency: "MAD"}
+		result, err := MockEligibilityChecker{}.CheckEligibility(EligibilityQuery{Coverage: cov, ServiceDate: tt.serviceDate})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s on %v: error = %v, want %v", tt.memberID, tt.serviceDate, err, tt.wantErr)
+			continue
+		}
+		if err == nil && result.Status != tt.wantStatus {
+			t.Errorf("%s on %v: status = %q, want %q", tt.memberID, tt.serviceDate, result.Status, tt.wantStatus)
+		}
+		if err == nil && result.Status == "eligible" && (result.Details["copay"] != copay || result.Details["currency"] != "MAD") {
+			t.Errorf("eligible details = %v, want the copay and currency", result.Details)
+		}
+	}
+}
+
+func TestCheckRankWindow(t *testing.T) {
+	const overlapQuery = `SELECT id FROM patient_coverages\s+WHERE patient_id = \$1 AND rank = \$2`
+	cov := &PatientCoverage{ID: 0, PatientID: 5, Rank: 1, EffectiveFrom: date(2025, 1, 1)}
+
+	t.Run("overlapping coverage of the same rank", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT id FROM patients WHERE id = \$1 FOR UPDATE`).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(overlapQuery).WithArgs(5, 1, 0, cov.EffectiveFrom, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectRollback()
+
+		tx, _ := db.Begin()
+		defer tx.Rollback()
+		if err := checkRankWindow(tx, cov); !errors.Is(err, ErrCoverageOverlap) {
+			t.Errorf("checkRankWindow error = %v, want ErrCoverageOverlap", err)
+		}
+	})
+	t.Run("free window", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT id FROM patients WHERE id = \$1 FOR UPDATE`).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(overlapQuery).WillReturnRows(sqlmock.NewRows(nil))
+		mock.ExpectRollback()
+
+		tx, _ := db.Begin()
+		defer tx.Rollback()
+		if err := checkRankWindow(tx, cov); err != nil {
+			t.Errorf("checkRankWindow error = %v", err)
+		}
+	})
+}
+
+func TestCheckEligibilityRecordsCheckerFailure(t *testing.T) {
+	db, mock := newMockDB(t)
+	serviceDate := date(2025, 6, 1)
+	mock.ExpectQuery(`INSERT INTO coverage_eligibility_checks`).
+		WithArgs(4, 5, "mock", "error", serviceDate, "", ErrEligibilityUnavailable.Error(), "{}", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "coverage_id", "patient_id", "checker", "status", "service_date",
+			"service_type", "message", "details", "checked_by", "checked_at"}).
+			AddRow(1, 4, 5, "mock", "error", serviceDate, "", ErrEligibilityUnavailable.Error(), []byte("{}"), 2, time.Now()))
+
+	service := NewCoverageService(db, nil, MockEligibilityChecker{})
+	cov := &PatientCoverage{ID: 4, PatientID: 5, MemberID: "ERR-1", EffectiveFrom: date(2025, 1, 1)}
+	check, err := service.CheckEligibility(&Patient{ID: 5}, cov, serviceDate, "", 2)
+	if err != nil {
+		t.Fatalf("CheckEligibility error = %v", err)
+	}
+	if check.Status != "error" {
+		t.Errorf("recorded status = %q, want error", check.Status)
+	}
+}
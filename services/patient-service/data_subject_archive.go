@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// dossierTemplate renders the human-readable copy of a patient dossier
+var dossierTemplate = template.Must(template.New("dossier").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2006-01-02") },
+	"datetime": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04")
+	},
+	"optdate": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Patient dossier {{.Patient.MRN}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; } h2 { font-size: 1.15em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+th, td { border: 1px solid #ddd; padding: 4px 6px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+.meta { color: #666; font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>Patient dossier &ndash; {{.Patient.FirstName}} {{.Patient.LastName}} ({{.Patient.MRN}})</h1>
+<p class="meta">Generated {{datetime .GeneratedAt}} UTC for data subject request #{{.RequestID}} ({{.LegalBasis}}).
+The complete machine-readable data is in dossier.json; attached documents are in the documents folder.</p>
+
+<h2>Demographics</h2>
+<table>
+<tr><th>Date of birth</th><td>{{date .Patient.DateOfBirth}}</td><th>Gender</th><td>{{.Patient.Gender}}</td></tr>
+<tr><th>Phone</th><td>{{.Patient.Phone}}</td><th>Email</th><td>{{.Patient.Email}}</td></tr>
+<tr><th>Address</th><td colspan="3">{{.Patient.Address}} {{.Patient.PostalCode}}</td></tr>
+<tr><th>National ID</th><td>{{.Patient.NationalID}}</td><th>Custom identifier</th><td>{{.Patient.PatientID}}</td></tr>
+<tr><th>Preferred language</th><td>{{.Patient.PreferredLanguage}}</td><th>Marital status</th><td>{{.Patient.MaritalStatus}}</td></tr>
+<tr><th>Occupation</th><td>{{.Patient.Occupation}}</td><th>Blood type</th><td>{{.Patient.BloodType}}</td></tr>
+<tr><th>Insurance policy</th><td>{{.Patient.PolicyNumber}}</td><th>Emergency contact</th><td>{{.Patient.EmergencyContactName}} {{.Patient.EmergencyContactPhone}} {{.Patient.EmergencyContactRelationship}}</td></tr>
+</table>
+
+<h2>Allergies</h2>
+<table><tr><th>Substance</th><th>Reaction</th><th>Severity</th><th>Status</th></tr>
+{{range .Allergies}}<tr><td>{{.Substance}}</td><td>{{.Reaction}}</td><td>{{.Severity}}</td><td>{{.Status}}</td></tr>
+{{else}}<tr><td colspan="4">None recorded</td></tr>{{end}}</table>
+
+<h2>Medications</h2>
+<table><tr><th>Name</th><th>Dose</th><th>Route</th><th>Frequency</th><th>Start</th><th>Stop</th><th>Status</th></tr>
+{{range .Medications}}<tr><td>{{.Name}}</td><td>{{.Dose}}</td><td>{{.Route}}</td><td>{{.Frequency}}</td><td>{{optdate .StartDate}}</td><td>{{optdate .StopDate}}</td><td>{{.Status}}</td></tr>
+{{else}}<tr><td colspan="7">None recorded</td></tr>{{end}}</table>
+
+<h2>Problems</h2>
+<table><tr><th>Description</th><th>Code</th><th>Onset</th><th>Resolved</th><th>Status</th></tr>
+{{range .Problems}}<tr><td>{{.Description}}</td><td>{{.Code}}</td><td>{{optdate .OnsetDate}}</td><td>{{optdate .ResolvedDate}}</td><td>{{.Status}}</td></tr>
+{{else}}<tr><td colspan="5">None recorded</td></tr>{{end}}</table>
+
+<h2>Vital signs</h2>
+<p>Metric units: mmHg, bpm, °C, %, kg, cm, kg/m², mmol/L.</p>
+<table><tr><th>Measured</th><th>Blood pressure</th><th>Heart rate</th><th>Temperature</th><th>SpO2</th><th>Weight</th><th>Height</th><th>BMI</th><th>Glucose</th></tr>
+{{range .Vitals}}<tr><td>{{datetime .MeasuredAt}}{{if .DeletedAt}} (entered in error){{end}}</td><td>{{with .Systolic}}{{.}}{{end}}{{with .Diastolic}}/{{.}}{{end}}</td><td>{{with .HeartRate}}{{.}}{{end}}</td><td>{{with .Temperature}}{{.}}{{end}}</td><td>{{with .SpO2}}{{.}}{{end}}</td><td>{{with .Weight}}{{.}}{{end}}</td><td>{{with .Height}}{{.}}{{end}}</td><td>{{with .BMI}}{{.}}{{end}}</td><td>{{with .Glucose}}{{.}}{{end}} {{.GlucoseContext}}</td></tr>
+{{else}}<tr><td colspan="9">None recorded</td></tr>{{end}}</table>
+
+<h2>Appointments</h2>
+<p>{{len .Appointments}} appointment(s); details are in dossier.json.</p>
+
+<h2>Consents</h2>
+<table><tr><th>Type</th><th>Granted</th><th>Channel</th><th>Revoked</th></tr>
+{{range .Consents}}<tr><td>{{.ConsentType}}</td><td>{{datetime .GrantedAt}}</td><td>{{.GrantChannel}}</td><td>{{if .RevokedAt}}{{datetime .RevokedAt}}{{end}}</td></tr>
+{{else}}<tr><td colspan="4">None recorded</td></tr>{{end}}</table>
+
+<h2>Relationships</h2>
+<table><tr><th>Role</th><th>Patient</th><th>Legal guardian</th></tr>
+{{range .Relationships}}<tr><td>{{.Role}}</td><td>{{.OtherPatient.FirstName}} {{.OtherPatient.LastName}} ({{.OtherPatient.MRN}})</td><td>{{if .IsLegalGuardian}}yes{{end}}</td></tr>
+{{else}}<tr><td colspan="3">None recorded</td></tr>{{end}}</table>
+
+<h2>Documents</h2>
+<table><tr><th>File</th><th>Type</th><th>Description</th><th>Uploaded</th></tr>
+{{range .Documents}}<tr><td>{{.FileName}}</td><td>{{.DocumentType}}</td><td>{{.Description}}</td><td>{{datetime .CreatedAt}}</td></tr>
+{{else}}<tr><td colspan="4">None</td></tr>{{end}}</table>
+
+<h2>Document access log</h2>
+<table><tr><th>When</th><th>Document</th><th>Action</th><th>User</th></tr>
+{{range .DocumentAccessLog}}<tr><td>{{datetime .AccessedAt}}</td><td>{{.DocumentID}}</td><td>{{.Action}}</td><td>{{if .Actor}}{{.Actor}}{{else}}{{.UserID}}{{end}}</td></tr>
+{{else}}<tr><td colspan="4">No entries</td></tr>{{end}}</table>
+
+<h2>Data subject requests</h2>
+<table><tr><th>#</th><th>Type</th><th>Basis</th><th>Status</th><th>Received</th></tr>
+{{range .DataSubjectRequests}}<tr><td>{{.ID}}</td><td>{{.RequestType}}</td><td>{{.LegalBasis}}</td><td>{{.Status}}</td><td>{{datetime .CreatedAt}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// writeDossierArchive writes a zip archive with dossier.json, dossier.html and the content of
+// every listed document under documents/
+func writeDossierArchive(w io.Writer, dossier *PatientDossier, openDocument func(doc PatientDocument) (io.ReadCloser, error)) error {
+	zw := zip.NewWriter(w)
+
+	jsonFile, err := zw.Create("dossier.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(jsonFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(dossier); err != nil {
+		return err
+	}
+
+	htmlFile, err := zw.Create("dossier.html")
+	if err != nil {
+		return err
+	}
+	if err := dossierTemplate.Execute(htmlFile, dossier); err != nil {
+		return err
+	}
+
+	for _, doc := range dossier.Documents {
+		content, err := openDocument(doc)
+		if err != nil {
+			return fmt.Errorf("failed to open document %d: %w", doc.ID, err)
+		}
+		entry, err := zw.Create(fmt.Sprintf("documents/%d-%s", doc.ID, filepath.Base(doc.FileName)))
+		if err != nil {
+			content.Close()
+			return err
+		}
+		_, err = io.Copy(entry, content)
+		content.Close()
+		if err != nil {
+			return fmt.Errorf("failed to archive document %d: %w", doc.ID, err)
+		}
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// DataSubjectHandler groups data subject request (export/erasure) handlers
+type DataSubjectHandler struct {
+	dataSubjectService *DataSubjectService
+	patientService     *PatientService
+	appointmentClient  *AppointmentClient
+	validator          *validator.Validate
+}
+
+// NewDataSubjectHandler constructs a new DataSubjectHandler
+func NewDataSubjectHandler(dataSubjectService *DataSubjectService, patientService *PatientService, appointmentClient *AppointmentClient) *DataSubjectHandler {
+	return &DataSubjectHandler{
+		dataSubjectService: dataSubjectService,
+		patientService:     patientService,
+		appointmentClient:  appointmentClient,
+		validator:          validator.New(),
+	}
+}
+
+// loadRequest loads the :requestId request of the caller's entity
+func (h *DataSubjectHandler) loadRequest(c *gin.Context) (*DataSubjectRequest, bool) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return nil, false
+	}
+	requestID, err := strconv.Atoi(c.Param("requestId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return nil, false
+	}
+	req, err := h.dataSubjectService.GetRequest(entityID, requestID)
+	if err != nil {
+		if errors.Is(err, ErrDataSubjectRequestNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Data subject request not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get data subject request"})
+		return nil, false
+	}
+	return req, true
+}
+
+// CreateRequest records an export or erasure request for the patient
+func (h *DataSubjectHandler) CreateRequest(c *gin.Context) {
+	patient, ok := loadEntityPatientAnyStatus(c, h.patientService)
+	if !ok {
+		return
+	}
+	var body DataSubjectRequestCreate
+	if !bindAndValidate(c, h.validator, &body) {
+		return
+	}
+	receivedVia := body.ReceivedVia
+	if receivedVia == "" {
+		receivedVia = "in_person"
+	}
+
+	req := &DataSubjectRequest{
+		PatientID:          patient.ID,
+		HealthcareEntityID: patient.HealthcareEntityID,
+		RequestType:        body.RequestType,
+		LegalBasis:         body.LegalBasis,
+		ReceivedVia:        receivedVia,
+		Notes:              strings.TrimSpace(body.Notes),
+		RequestedBy:        c.GetInt("user_id"),
+	}
+	if err := h.dataSubjectService.CreateRequest(req); err != nil {
+		logging.LogError("Failed to create data subject request", "error", err, "patient_id", patient.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create data subject request"})
+		return
+	}
+
+	logging.LogInfo("Data subject request received", "request_id", req.ID, "patient_id", patient.ID,
+		"request_type", req.RequestType, "legal_basis", req.LegalBasis)
+	c.JSON(http.StatusCreated, gin.H{"message": "Data subject request recorded", "request": req})
+}
+
+// GetPatientRequests lists the patient's data subject requests
+func (h *DataSubjectHandler) GetPatientRequests(c *gin.Context) {
+	patient, ok := loadEntityPatientAnyStatus(c, h.patientService)
+	if !ok {
+		return
+	}
+	requests, err := h.dataSubjectService.ListRequests(patient.HealthcareEntityID, patient.ID, "", "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get data subject requests"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "requests": requests})
+}
+
+// ListRequests lists the entity's data subject requests (?status=, ?type=)
+func (h *DataSubjectHandler) ListRequests(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	requests, err := h.dataSubjectService.ListRequests(entityID, 0, c.Query("status"), c.Query("type"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get data subject requests"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"requests": requests, "total_count": len(requests)})
+}
+
+// GetRequest returns a data subject request and its status
+func (h *DataSubjectHandler) GetRequest(c *gin.Context) {
+	req, ok := h.loadRequest(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// ProcessRequest runs an export or erasure (admin only). Failed requests can be processed again.
+func (h *DataSubjectHandler) ProcessRequest(c *gin.Context) {
+	req, ok := h.loadRequest(c)
+	if !ok {
+		return
+	}
+	patient, err := h.patientService.GetPatientIncludingInactive(req.PatientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get patient"})
+		return
+	}
+	access := documentAccess(c)
+	if err := h.dataSubjectService.StartProcessing(req, access.UserID); err != nil {
+		if errors.Is(err, ErrDataSubjectRequestState) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Request cannot be processed", "details": fmt.Sprintf("Request is %s", req.Status)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start processing"})
+		return
+	}
+
+	switch req.RequestType {
+	case "export":
+		err = h.processExport(c, req, patient, access)
+	case "erasure":
+		err = h.dataSubjectService.CompleteErasure(req, access)
+	}
+	if err != nil {
+		if errors.Is(err, ErrLegalHoldActive) {
+			logging.LogInfo("Erasure request rejected because of a legal hold", "request_id", req.ID, "patient_id", req.PatientID)
+			c.JSON(http.StatusConflict, gin.H{"error": "Patient is under legal hold", "request": req})
+			return
+		}
+		logging.LogError("Failed to process data subject request", "error", err, "request_id", req.ID, "request_type", req.RequestType)
+		h.dataSubjectService.MarkFailed(req, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process data subject request", "request": req})
+		return
+	}
+
+	logging.LogInfo("Data subject request completed", "request_id", req.ID, "patient_id", req.PatientID,
+		"request_type", req.RequestType, "processed_by", access.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Data subject request completed", "request": req})
+}
+
+// processExport gathers appointments with the caller's identity, then builds and stores the archive
+func (h *DataSubjectHandler) processExport(c *gin.Context, req *DataSubjectRequest, patient *Patient, access DocumentAccess) error {
+	headers := forwardedIdentityHeaders(c)
+	appointments, err := h.appointmentClient.ListPatientAppointments(patient.ID, headers)
+	if err != nil {
+		return fmt.Errorf("failed to get appointments: %w", err)
+	}
+	dossier, err := h.dataSubjectService.BuildDossier(req, patient, appointments)
+	if err != nil {
+		return fmt.Errorf("failed to build dossier: %w", err)
+	}
+	return h.dataSubjectService.CompleteExport(req, dossier, access)
+}
+
+// RejectRequest rejects a pending or failed request with a reason (admin only)
+func (h *DataSubjectHandler) RejectRequest(c *gin.Context) {
+	req, ok := h.loadRequest(c)
+	if !ok {
+		return
+	}
+	var body DataSubjectRejectRequest
+	if !bindAndValidate(c, h.validator, &body) {
+		return
+	}
+	if err := h.dataSubjectService.Reject(req, c.GetInt("user_id"), body.Reason); err != nil {
+		if errors.Is(err, ErrDataSubjectRequestState) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Request cannot be rejected", "details": fmt.Sprintf("Request is %s", req.Status)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject data subject request"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Data subject request rejected", "request": req})
+}
+
+// DownloadArchive streams the zip archive of a completed export (admin only)
+func (h *DataSubjectHandler) DownloadArchive(c *gin.Context) {
+	req, ok := h.loadRequest(c)
+	if !ok {
+		return
+	}
+	content, err := h.dataSubjectService.OpenArchive(req)
+	if err != nil {
+		if errors.Is(err, ErrArchiveNotAvailable) || errors.Is(err, ErrStoredObjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Export archive not available"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open export archive"})
+		return
+	}
+	defer content.Close()
+
+	logging.LogInfo("Data subject export archive downloaded", "request_id", req.ID, "patient_id", req.PatientID, "user_id", c.GetInt("user_id"))
+	c.DataFromReader(http.StatusOK, *req.ArchiveSizeBytes, "application/zip", content, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="patient-%d-export-%d.zip"`, req.PatientID, req.ID),
+		"X-Checksum-SHA256":   *req.ArchiveChecksumSHA256,
+		"Cache-Control":       "private, no-store",
+	})
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+var (
+	ErrDataSubjectRequestNotFound = errors.New("data subject request not found")
+	ErrDataSubjectRequestState    = errors.New("data subject request cannot be processed in its current status")
+	ErrLegalHoldActive            = errors.New("patient is under an active legal hold")
+	ErrArchiveNotAvailable        = errors.New("export archive not available")
+)
+
+// dataSubjectResponseDays is the default deadline for answering a request
+const dataSubjectResponseDays = 30
+
+// anonymisedFields lists the patient fields cleared or generalised by an erasure
+var anonymisedFields = []string{
+	"first_name", "last_name", "patient_id", "date_of_birth (reduced to year)", "phone", "email", "address",
+	"state_id", "city_id", "postal_code", "nationality_id", "marital_status", "occupation", "policy_number",
+	"national_id", "emergency_contact_name", "emergency_contact_phone", "emergency_contact_relationship",
+}
+
+// retainedOnErasure describes what an erasure keeps and why
+var retainedOnErasure = []string{
+	"clinical items and their history, and vital signs, under the pseudonymous MRN (medical record retention)",
+	"consent records (proof of consent)",
+	"document, consent and data subject request audit trails",
+	"appointments in appointment-service, linked by patient ID only",
+}
+
+const dataSubjectRequestColumns = `
+	id, patient_id, healthcare_entity_id, request_type, legal_basis, status, received_via, notes, requested_by, due_at,
+	processed_by, started_at, completed_at, status_reason, result, archive_storage_key, archive_size_bytes,
+	archive_checksum_sha256, created_at, updated_at`
+
+func scanDataSubjectRequest(row rowScanner) (*DataSubjectRequest, error) {
+	req := &DataSubjectRequest{}
+	var result []byte
+	err := row.Scan(&req.ID, &req.PatientID, &req.HealthcareEntityID, &req.RequestType, &req.LegalBasis, &req.Status,
+		&req.ReceivedVia, &req.Notes, &req.RequestedBy, &req.DueAt, &req.ProcessedBy, &req.StartedAt, &req.CompletedAt,
+		&req.StatusReason, &result, &req.ArchiveStorageKey, &req.ArchiveSizeBytes, &req.ArchiveChecksumSHA256,
+		&req.CreatedAt, &req.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if result != nil {
+		req.Result = json.RawMessage(result)
+	}
+	return req, nil
+}
+
+// DataSubjectService runs the data subject workflow: request tracking, dossier export and erasure
+type DataSubjectService struct {
+	db                  *sql.DB
+	storage             DocumentStorage
+	clinicalService     *ClinicalService
+	consentService      *ConsentService
+	relationshipService *RelationshipService
+	documentService     *DocumentService
+	legalHoldService    *LegalHoldService
+	vitalsService       *VitalsService
+	versionService      *PatientVersionService
+	coverageService     *CoverageService
+}
+
+// NewDataSubjectService creates a new data subject service. Export archives are kept in the
+// document storage next to patient documents.
+func NewDataSubjectService(db *sql.DB, storage DocumentStorage, clinicalService *ClinicalService, consentService *ConsentService,
+	relationshipService *RelationshipService, documentService *DocumentService, legalHoldService *LegalHoldService,
+	vitalsService *VitalsService, versionService *PatientVersionService, coverageService *CoverageService) *DataSubjectService {
+	return &DataSubjectService{
+		db:                  db,
+		storage:             storage,
+		clinicalService:     clinicalService,
+		consentService:      consentService,
+		relationshipService: relationshipService,
+		documentService:     documentService,
+		legalHoldService:    legalHoldService,
+		vitalsService:       vitalsService,
+		versionService:      versionService,
+		coverageService:     coverageService,
+	}
+}
+
+// CreateRequest records a new pending request, due dataSubjectResponseDays from now
+func (s *DataSubjectService) CreateRequest(req *DataSubjectRequest) error {
+	return s.db.QueryRow(`
+		INSERT INTO data_subject_requests (patient_id, healthcare_entity_id, request_type, legal_basis, received_via, notes, requested_by, due_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP + make_interval(days => $8))
+		RETURNING id, status, due_at, status_reason, created_at, updated_at
+	`, req.PatientID, req.HealthcareEntityID, req.RequestType, req.LegalBasis, req.ReceivedVia, req.Notes, req.RequestedBy,
+		dataSubjectResponseDays,
+	).Scan(&req.ID, &req.Status, &req.DueAt, &req.StatusReason, &req.CreatedAt, &req.UpdatedAt)
+}
+
+// GetRequest gets a request of an entity
+func (s *DataSubjectService) GetRequest(entityID, requestID int) (*DataSubjectRequest, error) {
+	req, err := scanDataSubjectRequest(s.db.QueryRow(`SELECT `+dataSubjectRequestColumns+`
+		FROM data_subject_requests
+		WHERE id = $1 AND healthcare_entity_id = $2
+	`, requestID, entityID))
+	if err == sql.ErrNoRows {
+		return nil, ErrDataSubjectRequestNotFound
+	}
+	return req, err
+}
+
+// ListRequests lists an entity's requests, optionally for one patient and/or filtered by status
+// and type, newest first
+func (s *DataSubjectService) ListRequests(entityID, patientID int, status, requestType string) ([]DataSubjectRequest, error) {
+	rows, err := s.db.Query(`SELECT `+dataSubjectRequestColumns+`
+		FROM data_subject_requests
+		WHERE healthcare_entity_id = $1 AND ($2 = 0 OR patient_id = $2)
+		  AND ($3 = '' OR status = $3) AND ($4 = '' OR request_type = $4)
+		ORDER BY created_at DESC, id DESC
+	`, entityID, patientID, status, requestType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []DataSubjectRequest{}
+	for rows.Next() {
+		req, err := scanDataSubjectRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *req)
+	}
+	return requests, rows.Err()
+}
+
+// StartProcessing moves a pending or failed request to processing. The conditional update
+// makes sure two administrators cannot process the same request concurrently.
+func (s *DataSubjectService) StartProcessing(req *DataSubjectRequest, userID int) error {
+	err := s.db.QueryRow(`
+		UPDATE data_subject_requests
+		SET status = 'processing', processed_by = $2, started_at = CURRENT_TIMESTAMP, status_reason = '',
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status IN ('pending', 'failed')
+		RETURNING status, processed_by, started_at, updated_at
+	`, req.ID, userID).Scan(&req.Status, &req.ProcessedBy, &req.StartedAt, &req.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrDataSubjectRequestState
+	}
+	return err
+}
+
+// finish sets the final status of a request
+func (s *DataSubjectService) finish(req *DataSubjectRequest, status, reason string, result interface{}) error {
+	var resultParam interface{}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resultParam = string(data)
+	}
+	var resultJSON []byte
+	err := s.db.QueryRow(`
+		UPDATE data_subject_requests
+		SET status = $2, status_reason = $3, result = COALESCE($4::jsonb, result),
+			completed_at = CASE WHEN $2 IN ('completed', 'rejected') THEN CURRENT_TIMESTAMP ELSE completed_at END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING status, status_reason, result, completed_at, updated_at
+	`, req.ID, status, reason, resultParam).Scan(&req.Status, &req.StatusReason, &resultJSON, &req.CompletedAt, &req.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if resultJSON != nil {
+		req.Result = json.RawMessage(resultJSON)
+	}
+	return nil
+}
+
+// Reject rejects a pending or failed request
+func (s *DataSubjectService) Reject(req *DataSubjectRequest, userID int, reason string) error {
+	if req.Status != "pending" && req.Status != "failed" {
+		return ErrDataSubjectRequestState
+	}
+	if err := s.StartProcessing(req, userID); err != nil {
+		return err
+	}
+	return s.finish(req, "rejected", reason, nil)
+}
+
+// MarkFailed records a processing failure; failed requests can be processed again
+func (s *DataSubjectService) MarkFailed(req *DataSubjectRequest, cause error) {
+	if err := s.finish(req, "failed", cause.Error(), nil); err != nil {
+		logging.LogError("Failed to mark data subject request as failed", "error", err, "request_id", req.ID)
+	}
+}
+
+// BuildDossier gathers everything held about a patient. Appointments come from the
+// appointment-service and are passed in by the caller.
+func (s *DataSubjectService) BuildDossier(req *DataSubjectRequest, patient *Patient, appointments []json.RawMessage) (*PatientDossier, error) {
+	dossier := &PatientDossier{
+		GeneratedAt:  time.Now().UTC(),
+		RequestID:    req.ID,
+		LegalBasis:   req.LegalBasis,
+		Patient:      patient.ToPatientResponse(),
+		Appointments: appointments,
+	}
+
+	var err error
+	if dossier.Allergies, err = s.clinicalService.ListAllergies(patient.ID, ""); err != nil {
+		return nil, fmt.Errorf("allergies: %w", err)
+	}
+	if dossier.Medications, err = s.clinicalService.ListMedications(patient.ID, ""); err != nil {
+		return nil, fmt.Errorf("medications: %w", err)
+	}
+	if dossier.Problems, err = s.clinicalService.ListProblems(patient.ID, ""); err != nil {
+		return nil, fmt.Errorf("problems: %w", err)
+	}
+	if dossier.ClinicalHistory, err = s.clinicalService.GetPatientHistory(patient.ID); err != nil {
+		return nil, fmt.Errorf("clinical history: %w", err)
+	}
+	if dossier.Versions, err = s.versionService.ListVersions(patient.ID, true); err != nil {
+		return nil, fmt.Errorf("patient versions: %w", err)
+	}
+	if dossier.Vitals, err = s.vitalsService.ListVitals(patient.ID, VitalSignsFilter{IncludeDeleted: true, Ascending: true}); err != nil {
+		return nil, fmt.Errorf("vital signs: %w", err)
+	}
+	if dossier.Coverages, err = s.coverageService.ListCoverages(patient.ID, CoverageFilter{IncludeDeleted: true}); err != nil {
+		return nil, fmt.Errorf("coverages: %w", err)
+	}
+	if dossier.EligibilityChecks, err = s.coverageService.ListEligibilityChecks(patient.ID, 0); err != nil {
+		return nil, fmt.Errorf("eligibility checks: %w", err)
+	}
+	if dossier.Consents, err = s.consentService.GetConsentHistory(patient.ID); err != nil {
+		return nil, fmt.Errorf("consents: %w", err)
+	}
+	if dossier.Relationships, err = s.relationshipService.ListRelationships(patient.HealthcareEntityID, patient.ID); err != nil {
+		return nil, fmt.Errorf("relationships: %w", err)
+	}
+	if dossier.Documents, err = s.documentService.GetDocuments(patient.HealthcareEntityID, patient.ID, ""); err != nil {
+		return nil, fmt.Errorf("documents: %w", err)
+	}
+	if dossier.DocumentAccessLog, err = s.documentService.GetPatientAccessLog(patient.ID); err != nil {
+		return nil, fmt.Errorf("document access log: %w", err)
+	}
+	if dossier.LegalHolds, err = s.legalHoldService.ListHolds(patient.HealthcareEntityID, patient.ID); err != nil {
+		return nil, fmt.Errorf("legal holds: %w", err)
+	}
+	if dossier.DataSubjectRequests, err = s.ListRequests(patient.HealthcareEntityID, patient.ID, "", ""); err != nil {
+		return nil, fmt.Errorf("data subject requests: %w", err)
+	}
+	return dossier, nil
+}
+
+// CompleteExport writes the dossier archive to storage and completes the request. Every
+// exported document is recorded in the document audit log.
+func (s *DataSubjectService) CompleteExport(req *DataSubjectRequest, dossier *PatientDossier, access DocumentAccess) error {
+	key, err := newStorageKey(req.HealthcareEntityID, req.PatientID)
+	if err != nil {
+		return err
+	}
+	key = "exports/" + key + ".zip"
+
+	// Stream the archive straight into storage while hashing it
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeDossierArchive(pw, dossier, func(doc PatientDocument) (io.ReadCloser, error) {
+			return s.storage.Open(doc.StorageKey)
+		}))
+	}()
+	hash := sha256.New()
+	size, err := s.storage.Save(key, io.TeeReader(pr, hash))
+	pr.Close()
+	if err != nil {
+		s.storage.Delete(key)
+		return fmt.Errorf("failed to store export archive: %w", err)
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	for i := range dossier.Documents {
+		if err := logDocumentAccess(s.db, &dossier.Documents[i], "export", access); err != nil {
+			s.storage.Delete(key)
+			return fmt.Errorf("failed to audit document export: %w", err)
+		}
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE data_subject_requests
+		SET archive_storage_key = $2, archive_size_bytes = $3, archive_checksum_sha256 = $4
+		WHERE id = $1
+	`, req.ID, key, size, checksum)
+	if err != nil {
+		s.storage.Delete(key)
+		return err
+	}
+	req.ArchiveStorageKey = &key
+	req.ArchiveSizeBytes = &size
+	req.ArchiveChecksumSHA256 = &checksum
+
+	return s.finish(req, "completed", "", map[string]interface{}{
+		"appointments":     len(dossier.Appointments),
+		"documents":        len(dossier.Documents),
+		"allergies":        len(dossier.Allergies),
+		"medications":      len(dossier.Medications),
+		"problems":         len(dossier.Problems),
+		"consents":         len(dossier.Consents),
+		"relationships":    len(dossier.Relationships),
+		"audit_entries":    len(dossier.DocumentAccessLog),
+		"archive_contents": []string{"dossier.json", "dossier.html", "documents/"},
+	})
+}
+
+// OpenArchive opens the export archive of a completed export request
+func (s *DataSubjectService) OpenArchive(req *DataSubjectRequest) (io.ReadCloser, error) {
+	if req.RequestType != "export" || req.Status != "completed" || req.ArchiveStorageKey == nil {
+		return nil, ErrArchiveNotAvailable
+	}
+	return s.storage.Open(*req.ArchiveStorageKey)
+}
+
+// CompleteErasure anonymises the patient unless a legal hold is active, in which case the
+// request is rejected. Identifying data is cleared or generalised, relationships are removed,
+// active consents are revoked and document contents are purged; the clinical record is kept
+// under the pseudonymous MRN to meet medical record retention obligations.
+func (s *DataSubjectService) CompleteErasure(req *DataSubjectRequest, access DocumentAccess) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the patient row so a hold placed concurrently is seen by one side or the other
+	if _, err := tx.Exec(`SELECT id FROM patients WHERE id = $1 FOR UPDATE`, req.PatientID); err != nil {
+		return err
+	}
+	held, holdReasons, err := activeLegalHoldReasons(tx, req.PatientID)
+	if err != nil {
+		return err
+	}
+	if held {
+		tx.Rollback()
+		if err := s.finish(req, "rejected", "Patient is under legal hold: "+holdReasons, nil); err != nil {
+			return err
+		}
+		return ErrLegalHoldActive
+	}
+
+	erasure, err := anonymisePatient(tx, req.PatientID, access, fmt.Sprintf("Erasure request #%d", req.ID))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Content is removed only once the anonymisation is committed
+	erasure.purgeContent(s.storage, req.PatientID)
+
+	return s.finish(req, "completed", "", erasure.summary())
+}
+
+// patientErasure records what anonymisePatient changed. Stored content is purged with
+// purgeContent once the transaction is committed.
+type patientErasure struct {
+	versionsRemoved      int64
+	coveragesErased      int64
+	relationshipsRemoved int64
+	consentsRevoked      int64
+	documents            []PatientDocument
+	archiveKeys          []string
+}
+
+// anonymisePatient clears or generalises the identifying data of a patient and its coverages,
+// removes its relationships, revokes its active consents and soft deletes its documents. The
+// caller must hold the patient row lock and have checked legal holds.
+func anonymisePatient(tx *sql.Tx, patientID int, access DocumentAccess, reason string) (*patientErasure, error) {
+	_, err := tx.Exec(`
+		UPDATE patients SET
+			first_name = 'Anonymised', last_name = 'Patient', patient_id = NULL,
+			date_of_birth = date_trunc('year', date_of_birth)::date,
+			phone = '', email = NULL, address = '', state_id = NULL, city_id = NULL, postal_code = '',
+			nationality_id = NULL, marital_status = '', occupation = '', policy_number = '', national_id = '',
+			emergency_contact_name = '', emergency_contact_phone = '', emergency_contact_relationship = '',
+			is_active = false, anonymised_at = CURRENT_TIMESTAMP, updated_by = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, patientID, access.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymise patient: %w", err)
+	}
+	erasure := &patientErasure{}
+
+	// Earlier versions of the patient row hold the erased data; only the anonymised one is kept
+	result, err := tx.Exec(`DELETE FROM patient_versions WHERE patient_id = $1 AND valid_to IS NOT NULL`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	erasure.versionsRemoved, _ = result.RowsAffected()
+
+	// Coverages identify the patient and the policy holder; the eligibility answers echo them
+	result, err = tx.Exec(`
+		UPDATE patient_coverages SET
+			policy_number = '', member_id = '', group_number = '', subscriber_name = '', subscriber_date_of_birth = NULL,
+			notes = '', deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP), deleted_by = COALESCE(deleted_by, $2)
+		WHERE patient_id = $1
+	`, patientID, access.UserID)
+	if err != nil {
+		return nil, err
+	}
+	erasure.coveragesErased, _ = result.RowsAffected()
+	if _, err := tx.Exec(`DELETE FROM coverage_eligibility_checks WHERE patient_id = $1`, patientID); err != nil {
+		return nil, err
+	}
+
+	result, err = tx.Exec(`DELETE FROM patient_relationships WHERE patient_id = $1 OR related_patient_id = $1`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	erasure.relationshipsRemoved, _ = result.RowsAffected()
+
+	result, err = tx.Exec(`
+		UPDATE patient_consents
+		SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $2, revocation_reason = $3
+		WHERE patient_id = $1 AND revoked_at IS NULL
+	`, patientID, access.UserID, reason)
+	if err != nil {
+		return nil, err
+	}
+	erasure.consentsRevoked, _ = result.RowsAffected()
+
+	rows, err := tx.Query(`
+		UPDATE patient_documents
+		SET deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP), deleted_by = COALESCE(deleted_by, $2),
+			description = '', file_name = 'erased'
+		WHERE patient_id = $1
+		RETURNING `+documentColumns, patientID, access.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		erasure.documents = append(erasure.documents, *doc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range erasure.documents {
+		if err := logDocumentAccess(tx, &erasure.documents[i], "erase", access); err != nil {
+			return nil, err
+		}
+	}
+
+	// Earlier export archives contain the erased data as well
+	archiveRows, err := tx.Query(`
+		UPDATE data_subject_requests d SET archive_storage_key = NULL
+		FROM data_subject_requests old
+		WHERE old.id = d.id AND d.patient_id = $1 AND d.archive_storage_key IS NOT NULL
+		RETURNING old.archive_storage_key
+	`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	for archiveRows.Next() {
+		var key string
+		if err := archiveRows.Scan(&key); err != nil {
+			archiveRows.Close()
+			return nil, err
+		}
+		erasure.archiveKeys = append(erasure.archiveKeys, key)
+	}
+	archiveRows.Close()
+	if err := archiveRows.Err(); err != nil {
+		return nil, err
+	}
+	return erasure, nil
+}
+
+// purgeContent deletes the erased documents and export archives from storage
+func (e *patientErasure) purgeContent(storage DocumentStorage, patientID int) {
+	for _, doc := range e.documents {
+		if err := storage.Delete(doc.StorageKey); err != nil {
+			logging.LogError("Failed to purge document content", "error", err, "document_id", doc.ID)
+		}
+	}
+	for _, key := range e.archiveKeys {
+		if err := storage.Delete(key); err != nil {
+			logging.LogError("Failed to purge export archive", "error", err, "patient_id", patientID)
+		}
+	}
+}
+
+// summary describes the erasure for request results and reports
+func (e *patientErasure) summary() map[string]interface{} {
+	return map[string]interface{}{
+		"anonymised_fields":      anonymisedFields,
+		"versions_removed":       e.versionsRemoved,
+		"coverages_erased":       e.coveragesErased,
+		"relationships_removed":  e.relationshipsRemoved,
+		"consents_revoked":       e.consentsRevoked,
+		"documents_purged":       len(e.documents),
+		"export_archives_purged": len(e.archiveKeys),
+		"retained":               retainedOnErasure,
+	}
+}
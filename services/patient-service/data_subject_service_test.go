@@ -0,0 +1,105 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCompleteErasureUnderLegalHold(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM patients WHERE id = \$1 FOR UPDATE`).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COUNT\(\*\), string_agg\(reason`).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "string_agg"}).AddRow(1, "Pending litigation"))
+	mock.ExpectRollback()
+	mock.ExpectQuery(`UPDATE data_subject_requests\s+SET status = \$2`).
+		WithArgs(9, "rejected", "Patient is under legal hold: Pending litigation", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "status_reason", "result", "completed_at", "updated_at"}).
+			AddRow("rejected", "Patient is under legal hold: Pending litigation", nil, time.Now(), time.Now()))
+
+	req := &DataSubjectRequest{ID: 9, PatientID: 5, HealthcareEntityID: 1, RequestType: "erasure", Status: "processing"}
+	service := &DataSubjectService{db: db}
+	if err := service.CompleteErasure(req, DocumentAccess{UserID: 2}); !errors.Is(err, ErrLegalHoldActive) {
+		t.Fatalf("CompleteErasure error = %v, want ErrLegalHoldActive", err)
+	}
+	if req.Status != "rejected" {
+		t.Errorf("request status = %q, want rejected", req.Status)
+	}
+}
+
+func TestRejectClosedRequest(t *testing.T) {
+	for _, status := range []string{"processing", "completed", "rejected"} {
+		req := &DataSubjectRequest{ID: 9, Status: status}
+		if err := (&DataSubjectService{}).Reject(req, 2, "duplicate"); !errors.Is(err, ErrDataSubjectRequestState) {
+			t.Errorf("Reject of a %s request error = %v, want ErrDataSubjectRequestState", status, err)
+		}
+	}
+}
+
+func TestWriteDossierArchive(t *testing.T) {
+	dossier := &PatientDossier{
+		RequestID:  9,
+		LegalBasis: "law_09_08",
+		Patient:    PatientResponse{ID: 5, FirstName: "Salma", LastName: "Idrissi"},
+		Documents:  []PatientDocument{{ID: 30, FileName: "../../scans/report.pdf"}},
+	}
+	var buf bytes.Buffer
+	err := writeDossierArchive(&buf, dossier, func(doc PatientDocument) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(pdfContent)), nil
+	})
+	if err != nil {
+		t.Fatalf("writeDossierArchive error = %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(content)
+	}
+
+	var exported PatientDossier
+	if err := json.Unmarshal([]byte(files["dossier.json"]), &exported); err != nil || exported.RequestID != 9 {
+		t.Errorf("dossier.json = %q, want the dossier of request 9", files["dossier.json"])
+	}
+	if !strings.Contains(files["dossier.html"], "Idrissi") {
+		t.Error("dossier.html doesn't show the patient")
+	}
+	if files["documents/30-report.pdf"] != pdfContent {
+		t.Errorf("archive files %v, want the document content under documents/30-report.pdf", keys(files))
+	}
+}
+
+func TestWriteDossierArchiveMissingDocument(t *testing.T) {
+	dossier := &PatientDossier{Documents: []PatientDocument{{ID: 30, FileName: "report.pdf"}}}
+	err := writeDossierArchive(io.Discard, dossier, func(doc PatientDocument) (io.ReadCloser, error) {
+		return nil, ErrStoredObjectNotFound
+	})
+	if !errors.Is(err, ErrStoredObjectNotFound) {
+		t.Errorf("writeDossierArchive error = %v, want ErrStoredObjectNotFound", err)
+	}
+}
+
+func keys(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// DocumentHandler groups patient document handlers
+type DocumentHandler struct {
+	documentService *DocumentService
+	patientService  *PatientService
+	validator       *validator.Validate
+}
+
+// NewDocumentHandler constructs a new DocumentHandler
+func NewDocumentHandler(documentService *DocumentService, patientService *PatientService) *DocumentHandler {
+	return &DocumentHandler{documentService: documentService, patientService: patientService, validator: validator.New()}
+}
+
+// documentAccess captures the caller for the document audit log
+func documentAccess(c *gin.Context) DocumentAccess {
+	return DocumentAccess{
+		UserID:    c.GetInt("user_id"),
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// loadDocument loads the patient and the :documentId document, both scoped to the caller's entity
+func (h *DocumentHandler) loadDocument(c *gin.Context) (*PatientDocument, bool) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return nil, false
+	}
+	documentID, err := strconv.Atoi(c.Param("documentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return nil, false
+	}
+	doc, err := h.documentService.GetDocument(patient.HealthcareEntityID, patient.ID, documentID)
+	if err != nil {
+		if errors.Is(err, ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document"})
+		return nil, false
+	}
+	return doc, true
+}
+
+// UploadDocument handles multipart uploads (file + document_type, description, appointment_id)
+func (h *DocumentHandler) UploadDocument(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+
+	// Cap the whole request body; allow some room for multipart framing and form fields
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.documentService.MaxSize()+1<<20)
+
+	var req DocumentUploadRequest
+	if err := c.ShouldBind(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Document too large", "details": fmt.Sprintf("Maximum size is %d MB", h.documentService.MaxSize()>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload", "details": err.Error()})
+		return
+	}
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required", "details": "Send the document in the 'file' multipart field"})
+		return
+	}
+	if fileHeader.Size > h.documentService.MaxSize() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Document too large", "details": fmt.Sprintf("Maximum size is %d MB", h.documentService.MaxSize()>>20)})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+	defer file.Close()
+
+	doc := &PatientDocument{
+		PatientID:          patient.ID,
+		HealthcareEntityID: patient.HealthcareEntityID,
+		DocumentType:       req.DocumentType,
+		Description:        req.Description,
+		AppointmentID:      req.AppointmentID,
+		FileName:           truncateRunes(filepath.Base(fileHeader.Filename), 255),
+		UploadedBy:         c.GetInt("user_id"),
+	}
+	if err := h.documentService.UploadDocument(doc, file, documentAccess(c)); err != nil {
+		switch {
+		case errors.Is(err, ErrDocumentTypeNotAllowed):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported document type", "details": fmt.Sprintf("Detected content type '%s'; PDF and image files are accepted", doc.ContentType)})
+		case errors.Is(err, ErrDocumentTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Document too large", "details": fmt.Sprintf("Maximum size is %d MB", h.documentService.MaxSize()>>20)})
+		default:
+			logging.LogError("Failed to upload document", "error", err, "patient_id", patient.ID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload document"})
+		}
+		return
+	}
+
+	logging.LogInfo("Document uploaded", "document_id", doc.ID, "patient_id", patient.ID, "document_type", doc.DocumentType, "size_bytes", doc.SizeBytes)
+	c.JSON(http.StatusCreated, gin.H{"message": "Document uploaded successfully", "document": doc})
+}
+
+// GetDocuments lists the patient's documents (?type= to filter)
+func (h *DocumentHandler) GetDocuments(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	documents, err := h.documentService.GetDocuments(patient.HealthcareEntityID, patient.ID, c.Query("type"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get documents"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "documents": documents})
+}
+
+// GetDocument returns the metadata of a document
+func (h *DocumentHandler) GetDocument(c *gin.Context) {
+	doc, ok := h.loadDocument(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, doc)
+}
+
+// UpdateDocument updates the metadata of a document
+func (h *DocumentHandler) UpdateDocument(c *gin.Context) {
+	doc, ok := h.loadDocument(c)
+	if !ok {
+		return
+	}
+	var req DocumentUpdateRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	doc.DocumentType = req.DocumentType
+	doc.Description = req.Description
+	doc.AppointmentID = req.AppointmentID
+	if err := h.documentService.UpdateDocument(doc, documentAccess(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+		return
+	}
+	c.JSON(http.StatusOK, doc)
+}
+
+// DeleteDocument removes a document from the patient's record
+func (h *DocumentHandler) DeleteDocument(c *gin.Context) {
+	doc, ok := h.loadDocument(c)
+	if !ok {
+		return
+	}
+	if err := h.documentService.DeleteDocument(doc, documentAccess(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
+}
+
+// DownloadDocument streams the document content; every download is audited
+func (h *DocumentHandler) DownloadDocument(c *gin.Context) {
+	doc, ok := h.loadDocument(c)
+	if !ok {
+		return
+	}
+	content, err := h.documentService.OpenDocument(doc, documentAccess(c))
+	if errors.Is(err, ErrStoredObjectNotFound) {
+		logging.LogError("Document content missing from storage", "document_id", doc.ID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document content not found"})
+		return
+	}
+	if err != nil {
+		logging.LogError("Failed to open document", "error", err, "document_id", doc.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download document"})
+		return
+	}
+	defer content.Close()
+
+	disposition := "attachment"
+	if c.Query("inline") == "true" {
+		disposition = "inline"
+	}
+	c.DataFromReader(http.StatusOK, doc.SizeBytes, doc.ContentType, content, map[string]string{
+		"Content-Disposition": fmt.Sprintf("%s; filename=%q", disposition, doc.FileName),
+		"ETag":                `"` + doc.ChecksumSHA256 + `"`,
+		"X-Checksum-SHA256":   doc.ChecksumSHA256,
+		"Cache-Control":       "private, no-store",
+	})
+}
+
+// GetDocumentAccessLog returns the audit trail of a document
+func (h *DocumentHandler) GetDocumentAccessLog(c *gin.Context) {
+	doc, ok := h.loadDocument(c)
+	if !ok {
+		return
+	}
+	entries, err := h.documentService.GetAccessLog(doc.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document access log"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"document_id": doc.ID, "access_log": entries})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+var (
+	ErrDocumentNotFound       = errors.New("document not found")
+	ErrDocumentTooLarge       = errors.New("document exceeds the maximum allowed size")
+	ErrDocumentTypeNotAllowed = errors.New("document content type not allowed")
+)
+
+// allowedDocumentTypes are the sniffed content types accepted for upload
+var allowedDocumentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+}
+
+// DocumentService manages patient document metadata, content and access auditing
+type DocumentService struct {
+	db      *sql.DB
+	storage DocumentStorage
+	maxSize int64
+}
+
+// NewDocumentService creates a new document service. The maximum upload size is read
+// from DOCUMENT_MAX_SIZE_MB (default 20).
+func NewDocumentService(db *sql.DB, storage DocumentStorage) *DocumentService {
+	maxSizeMB := 20
+	if v, err := strconv.Atoi(os.Getenv("DOCUMENT_MAX_SIZE_MB")); err == nil && v > 0 {
+		maxSizeMB = v
+	}
+	return &DocumentService{db: db, storage: storage, maxSize: int64(maxSizeMB) << 20}
+}
+
+// MaxSize returns the maximum accepted document size in bytes
+func (s *DocumentService) MaxSize() int64 {
+	return s.maxSize
+}
+
+const documentColumns = `
+	id, patient_id, healthcare_entity_id, document_type, description, appointment_id, file_name,
+	content_type, size_bytes, checksum_sha256, storage_key, uploaded_by, created_at, updated_at`
+
+func scanDocument(row rowScanner) (*PatientDocument, error) {
+	doc := &PatientDocument{}
+	err := row.Scan(&doc.ID, &doc.PatientID, &doc.HealthcareEntityID, &doc.DocumentType, &doc.Description,
+		&doc.AppointmentID, &doc.FileName, &doc.ContentType, &doc.SizeBytes, &doc.ChecksumSHA256,
+		&doc.StorageKey, &doc.UploadedBy, &doc.CreatedAt, &doc.UpdatedAt)
+	return doc, err
+}
+
+// logDocumentAccess writes an audit entry for a document
+func logDocumentAccess(exec interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, doc *PatientDocument, action string, access DocumentAccess) error {
+	_, err := exec.Exec(`
+		INSERT INTO document_access_log (document_id, patient_id, healthcare_entity_id, user_id, actor, action, client_ip, user_agent)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)
+	`, doc.ID, doc.PatientID, doc.HealthcareEntityID, access.UserID, access.Actor, action, access.ClientIP, access.UserAgent)
+	return err
+}
+
+// newStorageKey builds a random, non-guessable key namespaced by entity and patient
+func newStorageKey(entityID, patientID int) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d/%d/%s", entityID, patientID, hex.EncodeToString(buf)), nil
+}
+
+// UploadDocument sniffs, checksums and stores content, then records its metadata.
+// doc must carry the patient, entity, type, file name and uploader.
+func (s *DocumentService) UploadDocument(doc *PatientDocument, content io.Reader, access DocumentAccess) error {
+	// Sniff the real content type from the first bytes rather than trusting the client
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+	doc.ContentType = http.DetectContentType(head)
+	if !allowedDocumentTypes[doc.ContentType] {
+		return ErrDocumentTypeNotAllowed
+	}
+
+	key, err := newStorageKey(doc.HealthcareEntityID, doc.PatientID)
+	if err != nil {
+		return err
+	}
+
+	// Hash while streaming to storage; read one byte past the limit to detect oversize files
+	hash := sha256.New()
+	limited := io.LimitReader(io.MultiReader(bytes.NewReader(head), content), s.maxSize+1)
+	size, err := s.storage.Save(key, io.TeeReader(limited, hash))
+	if err != nil {
+		s.storage.Delete(key)
+		return fmt.Errorf("failed to store document: %w", err)
+	}
+	if size > s.maxSize {
+		s.storage.Delete(key)
+		return ErrDocumentTooLarge
+	}
+
+	doc.StorageKey = key
+	doc.SizeBytes = size
+	doc.ChecksumSHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	if err := s.insertDocument(doc, access); err != nil {
+		s.storage.Delete(key)
+		return err
+	}
+	return nil
+}
+
+func (s *DocumentService) insertDocument(doc *PatientDocument, access DocumentAccess) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO patient_documents (
+			patient_id, healthcare_entity_id, document_type, description, appointment_id, file_name,
+			content_type, size_bytes, checksum_sha256, storage_key, uploaded_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, updated_at
+	`, doc.PatientID, doc.HealthcareEntityID, doc.DocumentType, doc.Description, doc.AppointmentID, doc.FileName,
+		doc.ContentType, doc.SizeBytes, doc.ChecksumSHA256, doc.StorageKey, doc.UploadedBy,
+	).Scan(&doc.ID, &doc.CreatedAt, &doc.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if err := logDocumentAccess(tx, doc, "upload", access); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDocuments lists the documents of a patient within an entity, newest first
+func (s *DocumentService) GetDocuments(entityID, patientID int, documentType string) ([]PatientDocument, error) {
+	rows, err := s.db.Query(`SELECT `+documentColumns+`
+		FROM patient_documents
+		WHERE healthcare_entity_id = $1 AND patient_id = $2 AND deleted_at IS NULL AND ($3 = '' OR document_type = $3)
+		ORDER BY created_at DESC, id DESC
+	`, entityID, patientID, documentType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := []PatientDocument{}
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, *doc)
+	}
+	return documents, rows.Err()
+}
+
+// GetDocument gets a document of a patient within an entity
+func (s *DocumentService) GetDocument(entityID, patientID, documentID int) (*PatientDocument, error) {
+	doc, err := scanDocument(s.db.QueryRow(`SELECT `+documentColumns+`
+		FROM patient_documents
+		WHERE id = $1 AND healthcare_entity_id = $2 AND patient_id = $3 AND deleted_at IS NULL
+	`, documentID, entityID, patientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrDocumentNotFound
+	}
+	return doc, err
+}
+
+// UpdateDocument updates the metadata of a document
+func (s *DocumentService) UpdateDocument(doc *PatientDocument, access DocumentAccess) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		UPDATE patient_documents
+		SET document_type = $2, description = $3, appointment_id = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING updated_at
+	`, doc.ID, doc.DocumentType, doc.Description, doc.AppointmentID).Scan(&doc.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrDocumentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := logDocumentAccess(tx, doc, "update", access); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteDocument soft deletes a document. The stored content is kept for retention
+// and audit purposes; it is no longer listed or downloadable.
+func (s *DocumentService) DeleteDocument(doc *PatientDocument, access DocumentAccess) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE patient_documents SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`, doc.ID, access.UserID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrDocumentNotFound
+	}
+	if err := logDocumentAccess(tx, doc, "delete", access); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// OpenDocument opens the stored content and audits the download. Only downloads that can
+// be served are audited, and the download is refused if it cannot be recorded.
+func (s *DocumentService) OpenDocument(doc *PatientDocument, access DocumentAccess) (io.ReadCloser, error) {
+	content, err := s.storage.Open(doc.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := logDocumentAccess(s.db, doc, "download", access); err != nil {
+		content.Close()
+		return nil, fmt.Errorf("failed to audit document download: %w", err)
+	}
+	return content, nil
+}
+
+// GetAccessLog returns the audit trail of a document, newest first
+func (s *DocumentService) GetAccessLog(documentID int) ([]DocumentAccessLogEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT id, document_id, user_id, actor, action, client_ip, user_agent, accessed_at
+		FROM document_access_log
+		WHERE document_id = $1
+		ORDER BY accessed_at DESC, id DESC
+	`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []DocumentAccessLogEntry{}
+	for rows.Next() {
+		var entry DocumentAccessLogEntry
+		if err := rows.Scan(&entry.ID, &entry.DocumentID, &entry.UserID, &entry.Actor, &entry.Action, &entry.ClientIP,
+			&entry.UserAgent, &entry.AccessedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetPatientAccessLog returns the audit trail of all documents of a patient, newest first
+func (s *DocumentService) GetPatientAccessLog(patientID int) ([]DocumentAccessLogEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT id, document_id, user_id, actor, action, client_ip, user_agent, accessed_at
+		FROM document_access_log
+		WHERE patient_id = $1
+		ORDER BY accessed_at DESC, id DESC
+	`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []DocumentAccessLogEntry{}
+	for rows.Next() {
+		var entry DocumentAccessLogEntry
+		if err := rows.Scan(&entry.ID, &entry.DocumentID, &entry.UserID, &entry.Actor, &entry.Action, &entry.ClientIP,
+			&entry.UserAgent, &entry.AccessedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// pdfContent is enough of a PDF for content sniffing
+const pdfContent = "%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\n"
+
+func newTestStorage(t *testing.T) *LocalDocumentStorage {
+	t.Helper()
+	storage, err := NewLocalDocumentStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+// storedFiles returns the files kept by storage, ignoring directories
+func storedFiles(t *testing.T, storage *LocalDocumentStorage) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(storage.baseDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestLocalDocumentStorage(t *testing.T) {
+	storage := newTestStorage(t)
+	written, err := storage.Save("1/5/abc", strings.NewReader(pdfContent))
+	if err != nil || written != int64(len(pdfContent)) {
+		t.Fatalf("Save = (%d, %v), want (%d, nil)", written, err, len(pdfContent))
+	}
+
+	r, err := storage.Open("1/5/abc")
+	if err != nil {
+		t.Fatalf("Open error = %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != pdfContent {
+		t.Errorf("Open read %q, want %q", content, pdfContent)
+	}
+
+	if err := storage.Delete("1/5/abc"); err != nil {
+		t.Fatalf("Delete error = %v", err)
+	}
+	if _, err := storage.Open("1/5/abc"); !errors.Is(err, ErrStoredObjectNotFound) {
+		t.Errorf("Open after Delete error = %v, want ErrStoredObjectNotFound", err)
+	}
+	if err := storage.Delete("1/5/abc"); err != nil {
+		t.Errorf("Delete of a missing key error = %v", err)
+	}
+}
+
+func TestLocalDocumentStorageRejectsEscapingKeys(t *testing.T) {
+	storage := newTestStorage(t)
+	for _, key := range []string{"../outside", "1/../../outside", "", "."} {
+		if _, err := storage.Save(key, strings.NewReader(pdfContent)); err == nil {
+			t.Errorf("Save(%q) stored content outside the base directory", key)
+		}
+		if _, err := storage.Open(key); err == nil || errors.Is(err, ErrStoredObjectNotFound) {
+			t.Errorf("Open(%q) error = %v, want an invalid key error", key, err)
+		}
+	}
+}
+
+func TestUploadDocumentRejections(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		maxSize int64
+		wantErr error
+	}{
+		{"content type sniffed from the bytes", "#!/bin/sh\necho not a scan\n", 1 << 20, ErrDocumentTypeNotAllowed},
+		{"oversize document", pdfContent + strings.Repeat(" ", 100), int64(len(pdfContent)), ErrDocumentTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newTestStorage(t)
+			service := &DocumentService{storage: storage, maxSize: tt.maxSize}
+			doc := &PatientDocument{PatientID: 5, HealthcareEntityID: 1, FileName: "scan.pdf"}
+
+			if err := service.UploadDocument(doc, strings.NewReader(tt.content), DocumentAccess{UserID: 2}); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UploadDocument error = %v, want %v", err, tt.wantErr)
+			}
+			if files := storedFiles(t, storage); len(files) != 0 {
+				t.Errorf("rejected upload left %v in storage", files)
+			}
+		})
+	}
+}
+
+func TestUploadDocumentAuditsUpload(t *testing.T) {
+	db, mock := newMockDB(t)
+	storage := newTestStorage(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO patient_documents`).
+		WithArgs(5, 1, "lab_result", "", nil, "scan.pdf", "application/pdf", int64(len(pdfContent)),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(30, time.Now(), time.Now()))
+	mock.ExpectExec(`INSERT INTO document_access_log`).WithArgs(30, 5, 1, 2, "", "upload", "10.0.0.1", "test").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	service := &DocumentService{db: db, storage: storage, maxSize: 1 << 20}
+	doc := &PatientDocument{PatientID: 5, HealthcareEntityID: 1, DocumentType: "lab_result", FileName: "scan.pdf", UploadedBy: 2}
+	access := DocumentAccess{UserID: 2, ClientIP: "10.0.0.1", UserAgent: "test"}
+	if err := service.UploadDocument(doc, strings.NewReader(pdfContent), access); err != nil {
+		t.Fatalf("UploadDocument error = %v", err)
+	}
+	if len(doc.ChecksumSHA256) != 64 || !strings.HasPrefix(doc.StorageKey, "1/5/") {
+		t.Errorf("stored under %q with checksum %q", doc.StorageKey, doc.ChecksumSHA256)
+	}
+}
+
+func TestOpenDocumentAuditsDownload(t *testing.T) {
+	storage := newTestStorage(t)
+	if _, err := storage.Save("1/5/abc", strings.NewReader(pdfContent)); err != nil {
+		t.Fatal(err)
+	}
+	doc := &PatientDocument{ID: 30, PatientID: 5, HealthcareEntityID: 1, StorageKey: "1/5/abc"}
+	access := DocumentAccess{UserID: 2, ClientIP: "10.0.0.1", UserAgent: "test"}
+	const auditQuery = `INSERT INTO document_access_log`
+
+	t.Run("audited", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(auditQuery).WithArgs(30, 5, 1, 2, "", "download", "10.0.0.1", "test").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		content, err := (&DocumentService{db: db, storage: storage}).OpenDocument(doc, access)
+		if err != nil {
+			t.Fatalf("OpenDocument error = %v", err)
+		}
+		content.Close()
+	})
+	t.Run("refused when the audit fails", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(auditQuery).WillReturnError(errors.New("connection lost"))
+
+		if _, err := (&DocumentService{db: db, storage: storage}).OpenDocument(doc, access); err == nil {
+			t.Error("OpenDocument served a download it couldn't audit")
+		}
+	})
+}
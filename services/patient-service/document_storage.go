@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrStoredObjectNotFound is returned when a storage key has no stored content
+var ErrStoredObjectNotFound = errors.New("stored object not found")
+
+// DocumentStorage stores document content by opaque key. Metadata lives in the database;
+// implementations only deal with bytes so other backends (S3, MinIO, ...) can be plugged in.
+type DocumentStorage interface {
+	// Save writes the content of r under key and returns the number of bytes written
+	Save(key string, r io.Reader) (int64, error)
+	// Open returns a reader for the content stored under key
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes the content stored under key
+	Delete(key string) error
+}
+
+// NewDocumentStorageFromEnv builds the storage backend selected by DOCUMENT_STORAGE_DRIVER
+func NewDocumentStorageFromEnv() (DocumentStorage, error) {
+	driver := os.Getenv("DOCUMENT_STORAGE_DRIVER")
+	if driver == "" {
+		driver = "local"
+	}
+
+	switch driver {
+	case "local":
+		path := os.Getenv("DOCUMENT_STORAGE_PATH")
+		if path == "" {
+			path = "/var/lib/patient-service/documents"
+		}
+		return NewLocalDocumentStorage(path)
+	default:
+		return nil, fmt.Errorf("unsupported document storage driver %q", driver)
+	}
+}
+
+// LocalDocumentStorage stores documents on the local filesystem under a base directory
+type LocalDocumentStorage struct {
+	baseDir string
+}
+
+// NewLocalDocumentStorage creates the base directory if needed
+func NewLocalDocumentStorage(baseDir string) (*LocalDocumentStorage, error) {
+	absDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(absDir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create document storage directory: %w", err)
+	}
+	return &LocalDocumentStorage{baseDir: absDir}, nil
+}
+
+// path resolves a key inside the base directory, rejecting keys that escape it
+func (s *LocalDocumentStorage) path(key string) (string, error) {
+	p := filepath.Join(s.baseDir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, s.baseDir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return p, nil
+}
+
+// Save writes to a temporary file first and renames it so readers never see partial content
+func (s *LocalDocumentStorage) Save(key string, r io.Reader) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return written, err
+	}
+	if err := tmp.Close(); err != nil {
+		return written, err
+	}
+	return written, os.Rename(tmp.Name(), p)
+}
+
+// Open opens the stored file for streaming
+func (s *LocalDocumentStorage) Open(key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrStoredObjectNotFound
+	}
+	return f, err
+}
+
+// Delete removes the stored file; deleting a missing key is not an error
+func (s *LocalDocumentStorage) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
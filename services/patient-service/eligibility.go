@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrEligibilityUnavailable is returned when the payer cannot answer an eligibility check
+var ErrEligibilityUnavailable = errors.New("eligibility service unavailable")
+
+// EligibilityQuery is what an eligibility check asks about: a coverage of a patient, on a
+// service date, optionally for a type of service
+type EligibilityQuery struct {
+	Patient     *Patient
+	Coverage    *PatientCoverage
+	ServiceDate time.Time
+	ServiceType string
+}
+
+// EligibilityResult is the answer of an eligibility checker
+type EligibilityResult struct {
+	Status  string                 // eligible or ineligible
+	Message string                 // Human readable reason, mostly for ineligible coverages
+	Details map[string]interface{} // Checker specific, e.g. plan name or remaining benefits
+}
+
+// EligibilityChecker verifies coverages with the payer. Implementations wrap a clearinghouse
+// or payer API so others can be plugged in next to the local mock.
+type EligibilityChecker interface {
+	// Name identifies the checker in recorded checks
+	Name() string
+	// CheckEligibility returns whether the coverage applies on the query's service date
+	CheckEligibility(query EligibilityQuery) (*EligibilityResult, error)
+}
+
+// NewEligibilityCheckerFromEnv builds the eligibility checker selected by ELIGIBILITY_CHECK_DRIVER
+func NewEligibilityCheckerFromEnv() (EligibilityChecker, error) {
+	driver := os.Getenv("ELIGIBILITY_CHECK_DRIVER")
+	if driver == "" {
+		driver = "mock"
+	}
+
+	switch driver {
+	case "mock":
+		return MockEligibilityChecker{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported eligibility check driver %q", driver)
+	}
+}
+
+// MockEligibilityChecker answers from the coverage itself, for development and tests. Member
+// IDs starting with X are unknown to the payer and member IDs starting with ERR make the
+// payer unavailable; any other coverage is eligible within its effective window.
+type MockEligibilityChecker struct{}
+
+// Name identifies the mock in recorded checks
+func (MockEligibilityChecker) Name() string {
+	return "mock"
+}
+
+// CheckEligibility applies the mock rules to the coverage
+func (MockEligibilityChecker) CheckEligibility(query EligibilityQuery) (*EligibilityResult, error) {
+	coverage := query.Coverage
+	memberID := strings.ToUpper(coverage.MemberID)
+	switch {
+	case strings.HasPrefix(memberID, "ERR"):
+		return nil, ErrEligibilityUnavailable
+	case strings.HasPrefix(memberID, "X"):
+		return &EligibilityResult{Status: "ineligible", Message: "Member not found"}, nil
+	case !coverage.coversDate(query.ServiceDate):
+		return &EligibilityResult{Status: "ineligible", Message: "Coverage is not effective on the service date"}, nil
+	}
+
+	details := map[string]interface{}{"member_id": coverage.MemberID, "rank": coverage.Rank}
+	benefits := map[string]*float64{
+		"annual_limit":        coverage.AnnualLimit,
+		"deductible":          coverage.Deductible,
+		"copay":               coverage.Copay,
+		"coinsurance_percent": coverage.CoinsurancePercent,
+	}
+	for name, value := range benefits {
+		if value != nil {
+			details[name] = *value
+		}
+	}
+	if coverage.Currency != "" {
+		details["currency"] = coverage.Currency
+	}
+	return &EligibilityResult{Status: "eligible", Message: "Coverage is active", Details: details}, nil
+}
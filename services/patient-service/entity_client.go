@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imperialCountryCodes lists the countries (ISO 3166-1 alpha-2) that measure in imperial units
+var imperialCountryCodes = map[string]bool{"US": true, "LR": true, "MM": true}
+
+// entitySettingsTTL is how long entity settings looked up in other services are cached
+const entitySettingsTTL = time.Hour
+
+type cachedUnitSystem struct {
+	system    string
+	expiresAt time.Time
+}
+
+type cachedCountryCode struct {
+	code      string
+	expiresAt time.Time
+}
+
+type cachedInsuranceProviders struct {
+	providerIDs map[int]bool
+	expiresAt   time.Time
+}
+
+// EntityClient looks up healthcare entity data held by user-service (the entity's country and
+// users) and location-service (country codes, insurance providers and location names)
+type EntityClient struct {
+	userServiceURL     string
+	locationServiceURL string
+	httpClient         *http.Client
+
+	mu           sync.Mutex
+	unitSystems        map[int]cachedUnitSystem
+	countryCodes       map[int]cachedCountryCode
+	insuranceProviders map[int]cachedInsuranceProviders
+}
+
+// NewEntityClientFromEnv reads the service locations from USER_SERVICE_URL and LOCATION_SERVICE_URL
+func NewEntityClientFromEnv() *EntityClient {
+	userServiceURL := os.Getenv("USER_SERVICE_URL")
+	if userServiceURL == "" {
+		userServiceURL = "http://user-service:8081"
+	}
+	locationServiceURL := os.Getenv("LOCATION_SERVICE_URL")
+	if locationServiceURL == "" {
+		locationServiceURL = "http://location-service:8084"
+	}
+	return &EntityClient{
+		userServiceURL:     userServiceURL,
+		locationServiceURL: locationServiceURL,
+		httpClient:         &http.Client{Timeout: 10 * time.Second},
+		unitSystems:        map[int]cachedUnitSystem{},
+		countryCodes:       map[int]cachedCountryCode{},
+		insuranceProviders: map[int]cachedInsuranceProviders{},
+	}
+}
+
+// getJSON sends a GET request with the given headers and decodes a 200 response into out
+func (c *EntityClient) getJSON(url string, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		if value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// UnitSystem returns the unit system (metric or imperial) of the entity's country. Entities
+// without a country use metric.
+func (c *EntityClient) UnitSystem(entityID int, headers map[string]string) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.unitSystems[entityID]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.system, nil
+	}
+
+	var entity struct {
+		CountryID int `json:"country_id"`
+	}
+	if err := c.getJSON(fmt.Sprintf("%s/api/entities/%d", c.userServiceURL, entityID), headers, &entity); err != nil {
+		return "", fmt.Errorf("failed to get healthcare entity: %w", err)
+	}
+
+	system := unitSystemMetric
+	if entity.CountryID != 0 {
+		code, err := c.CountryCode(entity.CountryID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get entity country: %w", err)
+		}
+		if imperialCountryCodes[code] {
+			system = unitSystemImperial
+		}
+	}
+
+	c.mu.Lock()
+	c.unitSystems[entityID] = cachedUnitSystem{system: system, expiresAt: time.Now().Add(entitySettingsTTL)}
+	c.mu.Unlock()
+	return system, nil
+}
+
+// CountryCode returns the ISO 3166-1 alpha-2 code of a location-service country, in upper case.
+// Unknown countries have an empty code.
+func (c *EntityClient) CountryCode(countryID int) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.countryCodes[countryID]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.code, nil
+	}
+
+	var countries struct {
+		Data []struct {
+			Code string `json:"code"`
+		} `json:"data"`
+	}
+	url := fmt.Sprintf("%s/api/locations/countries/by-ids?ids=%d", c.locationServiceURL, countryID)
+	if err := c.getJSON(url, nil, &countries); err != nil {
+		return "", err
+	}
+	code := ""
+	if len(countries.Data) > 0 {
+		code = strings.ToUpper(countries.Data[0].Code)
+	}
+
+	c.mu.Lock()
+	c.countryCodes[countryID] = cachedCountryCode{code: code, expiresAt: time.Now().Add(entitySettingsTTL)}
+	c.mu.Unlock()
+	return code, nil
+}
+
+// InsuranceProviderOffersType reports whether a location-service insurance provider offers an
+// insurance type. Unknown types offer no provider.
+func (c *EntityClient) InsuranceProviderOffersType(providerID, typeID int) (bool, error) {
+	c.mu.Lock()
+	cached, ok := c.insuranceProviders[typeID]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.providerIDs[providerID], nil
+	}
+
+	var providers struct {
+		Data []struct {
+			ID int `json:"id"`
+		} `json:"data"`
+	}
+	url := fmt.Sprintf("%s/api/locations/insurance-types/%d/providers", c.locationServiceURL, typeID)
+	if err := c.getJSON(url, nil, &providers); err != nil {
+		return false, fmt.Errorf("failed to get insurance providers: %w", err)
+	}
+	providerIDs := make(map[int]bool, len(providers.Data))
+	for _, p := range providers.Data {
+		providerIDs[p.ID] = true
+	}
+
+	c.mu.Lock()
+	c.insuranceProviders[typeID] = cachedInsuranceProviders{providerIDs: providerIDs, expiresAt: time.Now().Add(entitySettingsTTL)}
+	c.mu.Unlock()
+	return providerIDs[providerID], nil
+}
+
+// UsersByIDs returns the users of the caller's healthcare entity with the given IDs. Users of
+// other entities are absent.
+func (c *EntityClient) UsersByIDs(ids []int, headers map[string]string) (map[int]TimelineActor, error) {
+	idStrings := make([]string, len(ids))
+	for i, id := range ids {
+		idStrings[i] = strconv.Itoa(id)
+	}
+	var users struct {
+		Data []struct {
+			ID        int    `json:"id"`
+			FirstName string `json:"first_name"`
+			LastName  string `json:"last_name"`
+			Role      string `json:"role"`
+		} `json:"data"`
+	}
+	url := fmt.Sprintf("%s/api/users/by-ids?ids=%s", c.userServiceURL, strings.Join(idStrings, ","))
+	if err := c.getJSON(url, headers, &users); err != nil {
+		return nil, err
+	}
+	actors := make(map[int]TimelineActor, len(users.Data))
+	for _, u := range users.Data {
+		actors[u.ID] = TimelineActor{ID: u.ID, Name: strings.TrimSpace(u.FirstName + " " + u.LastName), Role: u.Role}
+	}
+	return actors, nil
+}
+
+// locationNamesBatch caps the IDs of one location-service by-ids request
+const locationNamesBatch = 200
+
+// LocationNames returns the localised names of location-service records of one kind:
+// countries, states, cities, nationalities, insurance-types or insurance-providers. Unknown
+// IDs are absent.
+func (c *EntityClient) LocationNames(kind string, ids []int, locale string) (map[int]string, error) {
+	names := make(map[int]string, len(ids))
+	for start := 0; start < len(ids); start += locationNamesBatch {
+		batch := ids[start:min(start+locationNamesBatch, len(ids))]
+		idStrings := make([]string, len(batch))
+		for i, id := range batch {
+			idStrings[i] = strconv.Itoa(id)
+		}
+		var records struct {
+			Data []struct {
+				ID   int    `json:"id"`
+				Name string `json:"name"`
+			} `json:"data"`
+		}
+		url := fmt.Sprintf("%s/api/locations/%s/by-ids?ids=%s&locale=%s", c.locationServiceURL, kind, strings.Join(idStrings, ","), locale)
+		if err := c.getJSON(url, nil, &records); err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", kind, err)
+		}
+		for _, r := range records.Data {
+			names[r.ID] = r.Name
+		}
+	}
+	return names, nil
+}
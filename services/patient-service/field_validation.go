@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// Form fields are validated with the rules of their field definition (validation_rules),
+// overridden rule by rule by the entity's field configuration (custom_validation):
+//
+//	pattern, pattern_message   regular expression the whole value must match, and its error message
+//	min_length, max_length     number of characters
+//	min, max                   numeric value
+//	min_date, max_date         YYYY-MM-DD, "today", or an offset from today such as "-120y", "-6m", "+30d"
+//	required_if                {"field": "...", "values": [...]}: required when the other field is
+//	                           filled, or has one of the values
+//	different_from             field the value must differ from
+//	before_field, after_field  date field the value must precede or follow
+//	message                    replaces the message of any failed rule
+type fieldRules map[string]interface{}
+
+// validationContext is what field rules can check a value against besides the value itself
+type validationContext struct {
+	values      map[string]string // Submitted values by field name
+	labels      map[string]string // Display names of the enabled fields
+	countryCode string            // ISO 3166-1 alpha-2 code of the patient's country, if needed and known
+	today       time.Time
+}
+
+func (ctx validationContext) label(fieldName string) string {
+	if label, ok := ctx.labels[fieldName]; ok {
+		return label
+	}
+	return fieldName
+}
+
+// formValue converts a request value to the string the form rules check; unset optional
+// references are empty
+func formValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case *int:
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// effectiveRules merges the field definition's rules with the entity's custom rules
+func effectiveRules(field FormField) fieldRules {
+	rules := fieldRules{}
+	for name, rule := range field.ValidationRules {
+		rules[name] = rule
+	}
+	for name, rule := range field.CustomValidation {
+		rules[name] = rule
+	}
+	return rules
+}
+
+func (r fieldRules) text(name string) string {
+	s, _ := r[name].(string)
+	return s
+}
+
+func (r fieldRules) number(name string) (float64, bool) {
+	switch v := r[name].(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// invalidRule logs a rule that cannot be applied. Misconfigured rules are skipped rather than
+// blocking patient registration.
+func invalidRule(field, rule string, err error) {
+	logging.LogWarn("Ignoring invalid validation rule", "field", field, "rule", rule, "error", err)
+}
+
+// requiredIf reports whether the required_if rule makes the field required
+func (r fieldRules) requiredIf(ctx validationContext) bool {
+	condition, ok := r["required_if"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	other, _ := condition["field"].(string)
+	value := strings.TrimSpace(ctx.values[other])
+	if value == "" {
+		return false
+	}
+	expected, _ := condition["values"].([]interface{})
+	if single, ok := condition["value"]; ok {
+		expected = append(expected, single)
+	}
+	if len(expected) == 0 {
+		return true
+	}
+	for _, e := range expected {
+		if strings.EqualFold(fmt.Sprintf("%v", e), value) {
+			return true
+		}
+	}
+	return false
+}
+
+var relativeDatePattern = regexp.MustCompile(`^([+-][0-9]+)([dmy])$`)
+
+// ruleDate resolves a min_date or max_date rule
+func ruleDate(rule string, today time.Time) (time.Time, error) {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	if rule == "today" {
+		return today, nil
+	}
+	if m := relativeDatePattern.FindStringSubmatch(rule); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "d":
+			return today.AddDate(0, 0, n), nil
+		case "m":
+			return today.AddDate(0, n, 0), nil
+		default:
+			return today.AddDate(n, 0, 0), nil
+		}
+	}
+	return time.Parse("2006-01-02", rule)
+}
+
+// check applies the rules to a non-empty value
+func (r fieldRules) check(fieldName, label, value string, ctx validationContext) error {
+	err := r.checkValue(fieldName, label, value, ctx)
+	if err != nil && r.text("message") != "" {
+		return errors.New(r.text("message"))
+	}
+	return err
+}
+
+func (r fieldRules) checkValue(fieldName, label, value string, ctx validationContext) error {
+	if pattern := r.text("pattern"); pattern != "" {
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			invalidRule(fieldName, "pattern", err)
+		} else if !re.MatchString(value) {
+			if message := r.text("pattern_message"); message != "" {
+				return fmt.Errorf("%s %s", label, message)
+			}
+			return fmt.Errorf("%s has an invalid format", label)
+		}
+	}
+
+	length := len([]rune(value))
+	if minLength, ok := r.number("min_length"); ok && float64(length) < minLength {
+		return fmt.Errorf("%s must be at least %g characters", label, minLength)
+	}
+	if maxLength, ok := r.number("max_length"); ok && float64(length) > maxLength {
+		return fmt.Errorf("%s must be at most %g characters", label, maxLength)
+	}
+
+	minValue, hasMin := r.number("min")
+	maxValue, hasMax := r.number("max")
+	if hasMin || hasMax {
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", label)
+		}
+		if hasMin && n < minValue {
+			return fmt.Errorf("%s must be at least %g", label, minValue)
+		}
+		if hasMax && n > maxValue {
+			return fmt.Errorf("%s must be at most %g", label, maxValue)
+		}
+	}
+
+	if other := r.text("different_from"); other != "" && ctx.values[other] != "" &&
+		strings.EqualFold(strings.TrimSpace(ctx.values[other]), strings.TrimSpace(value)) {
+		return fmt.Errorf("%s must be different from %s", label, ctx.label(other))
+	}
+
+	minDate, maxDate := r.text("min_date"), r.text("max_date")
+	before, after := r.text("before_field"), r.text("after_field")
+	if minDate == "" && maxDate == "" && before == "" && after == "" {
+		return nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return fmt.Errorf("%s must be a date (YYYY-MM-DD)", label)
+	}
+	if minDate != "" {
+		if bound, err := ruleDate(minDate, ctx.today); err != nil {
+			invalidRule(fieldName, "min_date", err)
+		} else if date.Before(bound) {
+			return fmt.Errorf("%s must be on or after %s", label, bound.Format("2006-01-02"))
+		}
+	}
+	if maxDate != "" {
+		if bound, err := ruleDate(maxDate, ctx.today); err != nil {
+			invalidRule(fieldName, "max_date", err)
+		} else if date.After(bound) {
+			return fmt.Errorf("%s must be on or before %s", label, bound.Format("2006-01-02"))
+		}
+	}
+	// Cross-field date rules only apply once the other field is a date too
+	if other, err := time.Parse("2006-01-02", ctx.values[before]); before != "" && err == nil && !date.Before(other) {
+		return fmt.Errorf("%s must be before %s", label, ctx.label(before))
+	}
+	if other, err := time.Parse("2006-01-02", ctx.values[after]); after != "" && err == nil && !date.After(other) {
+		return fmt.Errorf("%s must be after %s", label, ctx.label(after))
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 )
 
 // AuthMiddleware validates user authentication (simplified for patient service)
@@ -25,4 +26,85 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Set("user_id", id)
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// getHealthcareEntityID reads the X-Healthcare-Entity-ID header set by the API Gateway.
+// On failure it writes a 400 response and returns false.
+func getHealthcareEntityID(c *gin.Context) (int, bool) {
+	entityIDStr := c.GetHeader("X-Healthcare-Entity-ID")
+	if entityIDStr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Healthcare entity ID header is required"})
+		return 0, false
+	}
+	entityID, err := strconv.Atoi(entityIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid healthcare entity ID"})
+		return 0, false
+	}
+	return entityID, true
+}
+
+// loadEntityPatient loads the patient referenced by the :id route parameter and makes sure
+// it belongs to the caller's healthcare entity. On failure it writes the error response
+// and returns false.
+func loadEntityPatient(c *gin.Context, patientService *PatientService) (*Patient, bool) {
+	return loadPatientForEntity(c, patientService.GetPatientByID)
+}
+
+// loadEntityPatientAnyStatus is loadEntityPatient for routes that also apply to deleted or
+// anonymised patients (data subject requests, legal holds, retention)
+func loadEntityPatientAnyStatus(c *gin.Context, patientService *PatientService) (*Patient, bool) {
+	return loadPatientForEntity(c, patientService.GetPatientIncludingInactive)
+}
+
+func loadPatientForEntity(c *gin.Context, load func(id int) (*Patient, error)) (*Patient, bool) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return nil, false
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return nil, false
+	}
+	patient, err := load(id)
+	if err != nil {
+		if err.Error() == "patient not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get patient"})
+		return nil, false
+	}
+	if patient.HealthcareEntityID != entityID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return nil, false
+	}
+	return patient, true
+}
+
+// bindAndValidate binds a JSON body and runs struct validation. On failure it writes a
+// 400 response and returns false.
+func bindAndValidate(c *gin.Context, validate *validator.Validate, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return false
+	}
+	if err := validate.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return false
+	}
+	return true
+}
+
+// forwardedIdentityHeaders returns the caller's identity headers for calls to other services
+func forwardedIdentityHeaders(c *gin.Context) map[string]string {
+	return map[string]string{
+		"Authorization":          c.GetHeader("Authorization"),
+		"X-User-ID":              c.GetHeader("X-User-ID"),
+		"X-User-Email":           c.GetHeader("X-User-Email"),
+		"X-User-Role":            c.GetHeader("X-User-Role"),
+		"X-User-Permissions":     c.GetHeader("X-User-Permissions"),
+		"X-Healthcare-Entity-ID": c.GetHeader("X-Healthcare-Entity-ID"),
+	}
+}
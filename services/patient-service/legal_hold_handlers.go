@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// LegalHoldHandler groups legal hold handlers
+type LegalHoldHandler struct {
+	legalHoldService *LegalHoldService
+	patientService   *PatientService
+	validator        *validator.Validate
+}
+
+// NewLegalHoldHandler constructs a new LegalHoldHandler
+func NewLegalHoldHandler(legalHoldService *LegalHoldService, patientService *PatientService) *LegalHoldHandler {
+	return &LegalHoldHandler{legalHoldService: legalHoldService, patientService: patientService, validator: validator.New()}
+}
+
+// GetLegalHolds lists the patient's legal holds, active and released
+func (h *LegalHoldHandler) GetLegalHolds(c *gin.Context) {
+	patient, ok := loadEntityPatientAnyStatus(c, h.patientService)
+	if !ok {
+		return
+	}
+	holds, err := h.legalHoldService.ListHolds(patient.HealthcareEntityID, patient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get legal holds"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "legal_holds": holds})
+}
+
+// PlaceLegalHold places a legal hold on the patient (admin only)
+func (h *LegalHoldHandler) PlaceLegalHold(c *gin.Context) {
+	patient, ok := loadEntityPatientAnyStatus(c, h.patientService)
+	if !ok {
+		return
+	}
+	var req LegalHoldRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	hold := &LegalHold{
+		PatientID:          patient.ID,
+		HealthcareEntityID: patient.HealthcareEntityID,
+		Reason:             req.Reason,
+		Reference:          req.Reference,
+		PlacedBy:           c.GetInt("user_id"),
+	}
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil || !expiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expires_at", "details": "expires_at must be a future RFC3339 timestamp"})
+			return
+		}
+		hold.ExpiresAt = &expiresAt
+	}
+	if err := h.legalHoldService.PlaceHold(hold); err != nil {
+		logging.LogError("Failed to place legal hold", "error", err, "patient_id", patient.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to place legal hold"})
+		return
+	}
+
+	logging.LogInfo("Legal hold placed", "legal_hold_id", hold.ID, "patient_id", patient.ID, "placed_by", hold.PlacedBy)
+	c.JSON(http.StatusCreated, gin.H{"message": "Legal hold placed successfully", "legal_hold": hold})
+}
+
+// ReleaseLegalHold releases a legal hold (admin only)
+func (h *LegalHoldHandler) ReleaseLegalHold(c *gin.Context) {
+	patient, ok := loadEntityPatientAnyStatus(c, h.patientService)
+	if !ok {
+		return
+	}
+	holdID, err := strconv.Atoi(c.Param("holdId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid legal hold ID"})
+		return
+	}
+	var req LegalHoldReleaseRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	hold, err := h.legalHoldService.GetHold(patient.HealthcareEntityID, patient.ID, holdID)
+	if err == nil {
+		err = h.legalHoldService.ReleaseHold(hold, c.GetInt("user_id"), req.Reason)
+	}
+	if err != nil {
+		if errors.Is(err, ErrLegalHoldNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Legal hold not found or already released"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release legal hold"})
+		return
+	}
+
+	logging.LogInfo("Legal hold released", "legal_hold_id", hold.ID, "patient_id", patient.ID, "released_by", c.GetInt("user_id"))
+	c.JSON(http.StatusOK, hold)
+}
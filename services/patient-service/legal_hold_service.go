@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrLegalHoldNotFound = errors.New("legal hold not found")
+
+// activeLegalHold is the SQL condition for a hold that is still in force
+const activeLegalHold = `released_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
+
+const legalHoldColumns = `
+	id, patient_id, healthcare_entity_id, reason, reference, placed_by, placed_at, expires_at,
+	released_by, released_at, release_reason, (` + activeLegalHold + `) AS is_active`
+
+func scanLegalHold(row rowScanner) (*LegalHold, error) {
+	hold := &LegalHold{}
+	err := row.Scan(&hold.ID, &hold.PatientID, &hold.HealthcareEntityID, &hold.Reason, &hold.Reference, &hold.PlacedBy,
+		&hold.PlacedAt, &hold.ExpiresAt, &hold.ReleasedBy, &hold.ReleasedAt, &hold.ReleaseReason, &hold.IsActive)
+	return hold, err
+}
+
+// LegalHoldService manages legal holds, which block erasure and retention purges
+type LegalHoldService struct {
+	db *sql.DB
+}
+
+// NewLegalHoldService creates a new legal hold service
+func NewLegalHoldService(db *sql.DB) *LegalHoldService {
+	return &LegalHoldService{db: db}
+}
+
+// PlaceHold places a legal hold on a patient
+func (s *LegalHoldService) PlaceHold(hold *LegalHold) error {
+	return s.db.QueryRow(`
+		INSERT INTO patient_legal_holds (patient_id, healthcare_entity_id, reason, reference, placed_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, placed_at, (`+activeLegalHold+`)
+	`, hold.PatientID, hold.HealthcareEntityID, hold.Reason, hold.Reference, hold.PlacedBy, hold.ExpiresAt,
+	).Scan(&hold.ID, &hold.PlacedAt, &hold.IsActive)
+}
+
+// queryHolds runs a legal hold query and collects the results
+func (s *LegalHoldService) queryHolds(query string, args ...interface{}) ([]LegalHold, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []LegalHold{}
+	for rows.Next() {
+		hold, err := scanLegalHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, *hold)
+	}
+	return holds, rows.Err()
+}
+
+// ListHolds lists every hold of a patient, newest first
+func (s *LegalHoldService) ListHolds(entityID, patientID int) ([]LegalHold, error) {
+	return s.queryHolds(`SELECT `+legalHoldColumns+`
+		FROM patient_legal_holds
+		WHERE healthcare_entity_id = $1 AND patient_id = $2
+		ORDER BY placed_at DESC, id DESC
+	`, entityID, patientID)
+}
+
+// ActiveHolds lists the holds of a patient that are in force
+func (s *LegalHoldService) ActiveHolds(patientID int) ([]LegalHold, error) {
+	return s.queryHolds(`SELECT `+legalHoldColumns+`
+		FROM patient_legal_holds
+		WHERE patient_id = $1 AND `+activeLegalHold+`
+		ORDER BY placed_at, id
+	`, patientID)
+}
+
+// activeLegalHoldReasons reports whether the patient has holds in force and joins their reasons.
+// It runs on the caller's transaction so the check and the change it guards are atomic.
+func activeLegalHoldReasons(q queryRower, patientID int) (bool, string, error) {
+	var holds int
+	var reasons sql.NullString
+	err := q.QueryRow(`
+		SELECT COUNT(*), string_agg(reason, '; ' ORDER BY placed_at)
+		FROM patient_legal_holds
+		WHERE patient_id = $1 AND `+activeLegalHold, patientID).Scan(&holds, &reasons)
+	return holds > 0, reasons.String, err
+}
+
+// GetHold gets a hold of a patient within an entity
+func (s *LegalHoldService) GetHold(entityID, patientID, holdID int) (*LegalHold, error) {
+	hold, err := scanLegalHold(s.db.QueryRow(`SELECT `+legalHoldColumns+`
+		FROM patient_legal_holds
+		WHERE id = $1 AND healthcare_entity_id = $2 AND patient_id = $3
+	`, holdID, entityID, patientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrLegalHoldNotFound
+	}
+	return hold, err
+}
+
+// ReleaseHold releases a hold that has not been released yet
+func (s *LegalHoldService) ReleaseHold(hold *LegalHold, userID int, reason string) error {
+	err := s.db.QueryRow(`
+		UPDATE patient_legal_holds
+		SET released_by = $2, released_at = CURRENT_TIMESTAMP, release_reason = $3
+		WHERE id = $1 AND released_at IS NULL
+		RETURNING released_by, released_at, release_reason
+	`, hold.ID, userID, reason).Scan(&hold.ReleasedBy, &hold.ReleasedAt, &hold.ReleaseReason)
+	if err == sql.ErrNoRows {
+		return ErrLegalHoldNotFound
+	}
+	if err != nil {
+		return err
+	}
+	hold.IsActive = false
+	return nil
+}
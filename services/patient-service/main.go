@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	logging "github.com/louhibi/healthcare-logging"
+	rbac "github.com/louhibi/healthcare-rbac"
 )
 
 func main() {
@@ -32,10 +33,49 @@ func main() {
 	}
 
 	// Initialize services
-	patientService := NewPatientService(db)
+	entityClient := NewEntityClientFromEnv()
+	patientService := NewPatientService(db, entityClient)
+	consentService := NewConsentService(db)
+	clinicalService := NewClinicalService(db)
+	mrnService := NewMRNService(db)
+	relationshipService := NewRelationshipService(db)
+	vitalsService := NewVitalsService(db)
+	versionService := NewPatientVersionService(db)
+	statsService := NewPatientStatsService(db, entityClient)
+	appointmentClient := NewAppointmentClientFromEnv()
+
+	documentStorage, err := NewDocumentStorageFromEnv()
+	if err != nil {
+		logging.LogError("Failed to initialize document storage", "error", err)
+		os.Exit(1)
+	}
+	eligibilityChecker, err := NewEligibilityCheckerFromEnv()
+	if err != nil {
+		logging.LogError("Failed to initialize eligibility checker", "error", err)
+		os.Exit(1)
+	}
+	coverageService := NewCoverageService(db, entityClient, eligibilityChecker)
+	documentService := NewDocumentService(db, documentStorage)
+	legalHoldService := NewLegalHoldService(db)
+	dataSubjectService := NewDataSubjectService(db, documentStorage, clinicalService, consentService, relationshipService, documentService, legalHoldService, vitalsService, versionService, coverageService)
+	retentionService := NewRetentionService(db, documentStorage, appointmentClient)
+	retentionService.StartScheduleFromEnv()
+	timelineService := NewTimelineService(db, appointmentClient, entityClient)
 
 	// Initialize handlers
-	patientHandler := NewPatientHandler(patientService)
+	patientHandler := NewPatientHandler(patientService, relationshipService, versionService)
+	consentHandler := NewConsentHandler(consentService, patientService)
+	clinicalHandler := NewClinicalHandler(clinicalService, patientService)
+	vitalsHandler := NewVitalsHandler(vitalsService, patientService, entityClient, appointmentClient)
+	timelineHandler := NewTimelineHandler(timelineService, patientService)
+	statsHandler := NewPatientStatsHandler(statsService)
+	documentHandler := NewDocumentHandler(documentService, patientService)
+	mrnHandler := NewMRNHandler(mrnService)
+	coverageHandler := NewCoverageHandler(coverageService, patientService)
+	relationshipHandler := NewRelationshipHandler(relationshipService, patientService, appointmentClient)
+	legalHoldHandler := NewLegalHoldHandler(legalHoldService, patientService)
+	dataSubjectHandler := NewDataSubjectHandler(dataSubjectService, patientService, appointmentClient)
+	retentionHandler := NewRetentionHandler(retentionService)
 
 	// Setup router
 	router := gin.Default()
@@ -69,12 +109,111 @@ func main() {
 	patients := apiGroup.Group("/patients")
 	patients.Use(AuthMiddleware())
 	{
-		patients.POST("/", patientHandler.CreatePatient)
-		patients.GET("/", patientHandler.GetPatients)
-		patients.GET("/stats", patientHandler.GetPatientStats)
-		patients.GET("/:id", patientHandler.GetPatient)
-		patients.PUT("/:id", patientHandler.UpdatePatient)
-		patients.DELETE("/:id", patientHandler.DeletePatient)
+		patients.POST("/", rbac.Require(rbac.PatientWrite), patientHandler.CreatePatient)
+		patients.GET("/", rbac.Require(rbac.PatientRead), patientHandler.GetPatients)
+		patients.GET("/stats", rbac.Require(rbac.PatientRead), statsHandler.GetPatientStats)
+		patients.GET("/mrn/:mrn", rbac.Require(rbac.PatientRead), patientHandler.GetPatientByMRN)
+		patients.GET("/mrn-config", rbac.Require(rbac.PatientRead), mrnHandler.GetConfiguration)
+		patients.PUT("/mrn-config", rbac.Require(rbac.PatientRecordsManage), mrnHandler.UpdateConfiguration)
+		patients.GET("/:id", rbac.Require(rbac.PatientRead), patientHandler.GetPatient)
+		patients.PUT("/:id", rbac.Require(rbac.PatientWrite), patientHandler.UpdatePatient)
+		patients.DELETE("/:id", rbac.Require(rbac.PatientDelete), patientHandler.DeletePatient)
+
+		// Versions of the patient row (GET /:id?as_of= serves the version current at a time)
+		patients.GET("/:id/versions", rbac.Require(rbac.PatientRead), patientHandler.GetPatientVersions)
+		patients.GET("/:id/versions/diff", rbac.Require(rbac.PatientRead), patientHandler.DiffPatientVersions)
+		patients.GET("/:id/versions/:version", rbac.Require(rbac.PatientRead), patientHandler.GetPatientVersion)
+		patients.POST("/:id/versions/:version/restore", rbac.Require(rbac.PatientRecordsManage), patientHandler.RestorePatientVersion)
+
+		// Consent documents (per entity) and patient consents
+		patients.GET("/consent-documents", rbac.Require(rbac.PatientRead), consentHandler.GetConsentDocuments)
+		patients.POST("/consent-documents", rbac.Require(rbac.PatientWrite), consentHandler.CreateConsentDocument)
+		patients.GET("/:id/consents", rbac.Require(rbac.PatientRead), consentHandler.GetPatientConsents)
+		patients.POST("/:id/consents", rbac.Require(rbac.PatientWrite), consentHandler.GrantConsent)
+		patients.GET("/:id/consents/check", rbac.Require(rbac.PatientRead), consentHandler.CheckConsent)
+		patients.GET("/:id/consents/history", rbac.Require(rbac.PatientRead), consentHandler.ExportConsentHistory)
+		patients.POST("/:id/consents/:consentId/revoke", rbac.Require(rbac.PatientWrite), consentHandler.RevokeConsent)
+
+		// Structured allergies, medications and problem list
+		patients.GET("/:id/allergies", rbac.Require(rbac.PatientReadClinical), clinicalHandler.GetAllergies)
+		patients.POST("/:id/allergies", rbac.Require(rbac.PatientWriteClinical), clinicalHandler.CreateAllergy)
+		patients.GET("/:id/allergies/:itemId", rbac.Require(rbac.PatientReadClinical), clinicalHandler.GetAllergy)
+		patients.PUT("/:id/allergies/:itemId", rbac.Require(rbac.PatientWriteClinical), clinicalHandler.UpdateAllergy)
+		patients.DELETE("/:id/allergies/:itemId", rbac.Require(rbac.PatientWriteClinical), clinicalHandler.DeleteAllergy)
+		patients.GET("/:id/allergies/:itemId/history", rbac.Require(rbac.PatientReadClinical), clinicalHandler.GetItemHistory("allergy"))
+		patients.GET("/:id/medications", rbac.Require(rbac.PatientReadClinical), clinicalHandler.GetMedications)
+		patients.POST("/:id/medications", rbac.Require(rbac.PatientWriteClinical), clinicalHandler.CreateMedication)
+		patients.GET("/:id/medications/:itemId", rbac.Require(rbac.PatientReadClinical), clinicalHandler.GetMedication)
+		patients.PUT("/:id/medications/:itemId", rbac.Require(rbac.PatientWriteClinical), clinicalHandler.UpdateMedication)
+		patients.DELETE("/:id/medications/:itemId", rbac.Require(rbac.PatientWriteClinical), clinicalHandler.DeleteMedication)
+		patients.GET("/:id/medications/:itemId/history", rbac.Require(rbac.PatientReadClinical), clinicalHandler.GetItemHistory("medication"))
+		patients.GET("/:id/problems", rbac.Require(rbac.PatientReadClinical), clinicalHandler.GetProblems)
+		patients.POST("/:id/problems", rbac.Require(rbac.PatientWriteClinical), clinicalHandler.CreateProblem)
+		patients.GET("/:id/problems/:itemId", rbac.Require(rbac.PatientReadClinical), clinicalHandler.GetProblem)
+		patients.PUT("/:id/problems/:itemId", rbac.Require(rbac.PatientWriteClinical), clinicalHandler.UpdateProblem)
+		patients.DELETE("/:id/problems/:itemId", rbac.Require(rbac.PatientWriteClinical), clinicalHandler.DeleteProblem)
+		patients.GET("/:id/problems/:itemId/history", rbac.Require(rbac.PatientReadClinical), clinicalHandler.GetItemHistory("problem"))
+
+		// Vital signs with reference-range flags and trends
+		patients.GET("/:id/vitals", rbac.Require(rbac.PatientReadClinical), vitalsHandler.GetVitals)
+		patients.POST("/:id/vitals", rbac.Require(rbac.PatientWriteClinical), vitalsHandler.CreateVitals)
+		patients.GET("/:id/vitals/trends", rbac.Require(rbac.PatientReadClinical), vitalsHandler.GetVitalTrends)
+		patients.GET("/:id/vitals/reference-ranges", rbac.Require(rbac.PatientReadClinical), vitalsHandler.GetReferenceRanges)
+		patients.GET("/:id/vitals/:vitalsId", rbac.Require(rbac.PatientReadClinical), vitalsHandler.GetVitalSigns)
+		patients.DELETE("/:id/vitals/:vitalsId", rbac.Require(rbac.PatientWriteClinical), vitalsHandler.DeleteVitals)
+
+		// Insurance coverages and eligibility checks
+		patients.GET("/:id/coverages", rbac.Require(rbac.PatientRead), coverageHandler.GetCoverages)
+		patients.POST("/:id/coverages", rbac.Require(rbac.PatientWrite), coverageHandler.CreateCoverage)
+		patients.GET("/:id/coverages/:coverageId", rbac.Require(rbac.PatientRead), coverageHandler.GetCoverage)
+		patients.PUT("/:id/coverages/:coverageId", rbac.Require(rbac.PatientWrite), coverageHandler.UpdateCoverage)
+		patients.DELETE("/:id/coverages/:coverageId", rbac.Require(rbac.PatientWrite), coverageHandler.DeleteCoverage)
+		patients.GET("/:id/coverages/:coverageId/eligibility", rbac.Require(rbac.PatientRead), coverageHandler.GetEligibilityChecks)
+		patients.POST("/:id/coverages/:coverageId/eligibility", rbac.Require(rbac.PatientWrite), coverageHandler.CheckEligibility)
+
+		// Events across services, newest first
+		patients.GET("/:id/timeline", rbac.Require(rbac.PatientReadClinical), timelineHandler.GetTimeline)
+
+		// Relationships, family groups and booking on behalf of dependants
+		patients.GET("/:id/relationships", rbac.Require(rbac.PatientRead), relationshipHandler.GetRelationships)
+		patients.POST("/:id/relationships", rbac.Require(rbac.PatientWrite), relationshipHandler.CreateRelationship)
+		patients.PUT("/:id/relationships/:relationshipId", rbac.Require(rbac.PatientWrite), relationshipHandler.UpdateRelationship)
+		patients.DELETE("/:id/relationships/:relationshipId", rbac.Require(rbac.PatientWrite), relationshipHandler.DeleteRelationship)
+		patients.GET("/:id/family", rbac.Require(rbac.PatientRead), relationshipHandler.GetFamilyGroup)
+		patients.GET("/:id/dependants", rbac.Require(rbac.PatientRead), relationshipHandler.GetDependants)
+		patients.POST("/:id/dependants/:dependantId/appointments", rbac.Require(rbac.PatientRead, rbac.AppointmentWrite), relationshipHandler.BookForDependant)
+
+		// Document attachments
+		patients.GET("/:id/documents", rbac.Require(rbac.PatientReadClinical), documentHandler.GetDocuments)
+		patients.POST("/:id/documents", rbac.Require(rbac.PatientWriteClinical), documentHandler.UploadDocument)
+		patients.GET("/:id/documents/:documentId", rbac.Require(rbac.PatientReadClinical), documentHandler.GetDocument)
+		patients.PUT("/:id/documents/:documentId", rbac.Require(rbac.PatientWriteClinical), documentHandler.UpdateDocument)
+		patients.DELETE("/:id/documents/:documentId", rbac.Require(rbac.PatientWriteClinical), documentHandler.DeleteDocument)
+		patients.GET("/:id/documents/:documentId/download", rbac.Require(rbac.PatientReadClinical), documentHandler.DownloadDocument)
+		patients.GET("/:id/documents/:documentId/access-log", rbac.Require(rbac.PatientReadClinical), documentHandler.GetDocumentAccessLog)
+
+		// Data subject requests (export/erasure) and legal holds
+		patients.GET("/data-requests", rbac.Require(rbac.PatientRead), dataSubjectHandler.ListRequests)
+		patients.GET("/data-requests/:requestId", rbac.Require(rbac.PatientRead), dataSubjectHandler.GetRequest)
+		patients.POST("/data-requests/:requestId/process", rbac.Require(rbac.PatientRecordsManage), dataSubjectHandler.ProcessRequest)
+		patients.POST("/data-requests/:requestId/reject", rbac.Require(rbac.PatientRecordsManage), dataSubjectHandler.RejectRequest)
+		patients.GET("/data-requests/:requestId/archive", rbac.Require(rbac.PatientRecordsManage), dataSubjectHandler.DownloadArchive)
+		patients.GET("/:id/data-requests", rbac.Require(rbac.PatientRead), dataSubjectHandler.GetPatientRequests)
+		patients.POST("/:id/data-requests", rbac.Require(rbac.PatientWrite), dataSubjectHandler.CreateRequest)
+		patients.GET("/:id/legal-holds", rbac.Require(rbac.PatientRead), legalHoldHandler.GetLegalHolds)
+		patients.POST("/:id/legal-holds", rbac.Require(rbac.PatientRecordsManage), legalHoldHandler.PlaceLegalHold)
+		patients.POST("/:id/legal-holds/:holdId/release", rbac.Require(rbac.PatientRecordsManage), legalHoldHandler.ReleaseLegalHold)
+
+		// Retention policies, retention runs and the patient archive
+		patients.GET("/retention-policies", rbac.Require(rbac.PatientRead), retentionHandler.GetPolicies)
+		patients.POST("/retention-policies", rbac.Require(rbac.PatientRecordsManage), retentionHandler.CreatePolicy)
+		patients.PUT("/retention-policies/:policyId", rbac.Require(rbac.PatientRecordsManage), retentionHandler.UpdatePolicy)
+		patients.DELETE("/retention-policies/:policyId", rbac.Require(rbac.PatientRecordsManage), retentionHandler.DeletePolicy)
+		patients.GET("/retention-runs", rbac.Require(rbac.PatientRecordsManage), retentionHandler.GetRuns)
+		patients.POST("/retention-runs", rbac.Require(rbac.PatientRecordsManage), retentionHandler.StartRun)
+		patients.GET("/retention-runs/:runId", rbac.Require(rbac.PatientRecordsManage), retentionHandler.GetRun)
+		patients.GET("/archive", rbac.Require(rbac.PatientRecordsManage), retentionHandler.GetArchivedPatients)
+		patients.POST("/archive/:patientId/restore", rbac.Require(rbac.PatientRecordsManage), retentionHandler.RestoreArchivedPatient)
 	}
 
 	port := os.Getenv("PORT")
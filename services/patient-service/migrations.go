@@ -459,6 +459,1459 @@ func GetMigrations() []Migration {
 				DROP COLUMN IF EXISTS insurance_provider_id;
 			`,
 		},
+		{
+			Version:     13,
+			Description: "Add full-text and trigram search support for patients",
+			Up: `
+				CREATE EXTENSION IF NOT EXISTS pg_trgm;
+				CREATE EXTENSION IF NOT EXISTS unaccent;
+
+				-- Normalize text for search: strip Latin accents, Arabic diacritics (tashkeel)
+				-- and tatweel, fold Arabic letter variants (alef forms, alef maqsura, ta marbuta,
+				-- hamza carriers) and lowercase. Declared IMMUTABLE so it can back indexes.
+				CREATE OR REPLACE FUNCTION patient_search_normalize(input TEXT)
+				RETURNS TEXT AS $$
+					SELECT lower(
+						translate(
+							regexp_replace(unaccent('unaccent', COALESCE(input, '')), '[\u064B-\u065F\u0670\u0640]', '', 'g'),
+							'أإآٱىةؤئ',
+							'اااايهوي'
+						)
+					);
+				$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+				ALTER TABLE patients ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+				-- Weighted search document: names (A), identifiers and contact (B),
+				-- demographics (C), clinical free text (D)
+				CREATE OR REPLACE FUNCTION patients_search_vector_update()
+				RETURNS TRIGGER AS $$
+				BEGIN
+					NEW.search_vector :=
+						setweight(to_tsvector('simple', patient_search_normalize(NEW.first_name || ' ' || NEW.last_name)), 'A') ||
+						setweight(to_tsvector('simple', patient_search_normalize(
+							COALESCE(NEW.patient_id, '') || ' ' || COALESCE(NEW.national_id, '') || ' ' ||
+							COALESCE(NEW.email, '') || ' ' || COALESCE(NEW.phone, '') || ' ' ||
+							regexp_replace(COALESCE(NEW.phone, ''), '\D', '', 'g'))), 'B') ||
+						setweight(to_tsvector('simple', patient_search_normalize(
+							COALESCE(NEW.address, '') || ' ' || COALESCE(NEW.postal_code, '') || ' ' ||
+							COALESCE(NEW.occupation, ''))), 'C') ||
+						setweight(to_tsvector('simple', patient_search_normalize(
+							COALESCE(NEW.medical_history, '') || ' ' || COALESCE(NEW.allergies, '') || ' ' ||
+							COALESCE(NEW.medications, ''))), 'D');
+					RETURN NEW;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				DROP TRIGGER IF EXISTS update_patients_search_vector ON patients;
+				CREATE TRIGGER update_patients_search_vector
+					BEFORE INSERT OR UPDATE ON patients
+					FOR EACH ROW
+					EXECUTE FUNCTION patients_search_vector_update();
+
+				-- Back-fill existing rows without bumping updated_at
+				ALTER TABLE patients DISABLE TRIGGER update_patients_updated_at;
+				UPDATE patients SET search_vector = NULL;
+				ALTER TABLE patients ENABLE TRIGGER update_patients_updated_at;
+
+				CREATE INDEX IF NOT EXISTS idx_patients_search_vector ON patients USING GIN (search_vector);
+				CREATE INDEX IF NOT EXISTS idx_patients_name_trgm ON patients
+					USING GIN (patient_search_normalize(first_name || ' ' || last_name) gin_trgm_ops);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_patients_name_trgm;
+				DROP INDEX IF EXISTS idx_patients_search_vector;
+				DROP TRIGGER IF EXISTS update_patients_search_vector ON patients;
+				DROP FUNCTION IF EXISTS patients_search_vector_update();
+				ALTER TABLE patients DROP COLUMN IF EXISTS search_vector;
+				DROP FUNCTION IF EXISTS patient_search_normalize(TEXT);
+			`,
+		},
+		{
+			Version:     14,
+			Description: "Create consent documents and patient consents tables",
+			Up: `
+				CREATE TABLE IF NOT EXISTS consent_documents (
+					id SERIAL PRIMARY KEY,
+					healthcare_entity_id INTEGER NOT NULL,
+					consent_type VARCHAR(30) NOT NULL CHECK (consent_type IN ('data_sharing', 'sms_contact', 'email_contact', 'research', 'photo')),
+					version INTEGER NOT NULL,
+					title VARCHAR(255) NOT NULL,
+					content TEXT NOT NULL,
+					language VARCHAR(10) NOT NULL DEFAULT '',
+					effective_from TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					created_by INTEGER NOT NULL,
+					UNIQUE (healthcare_entity_id, consent_type, version)
+				);
+
+				CREATE TABLE IF NOT EXISTS patient_consents (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					healthcare_entity_id INTEGER NOT NULL,
+					consent_type VARCHAR(30) NOT NULL CHECK (consent_type IN ('data_sharing', 'sms_contact', 'email_contact', 'research', 'photo')),
+					document_id INTEGER NOT NULL REFERENCES consent_documents(id),
+					granted_at TIMESTAMP NOT NULL,
+					granted_by INTEGER NOT NULL,
+					grant_channel VARCHAR(20) NOT NULL CHECK (grant_channel IN ('in_person', 'paper', 'phone', 'email', 'sms', 'portal')),
+					revoked_at TIMESTAMP,
+					revoked_by INTEGER,
+					revoke_channel VARCHAR(20) CHECK (revoke_channel IS NULL OR revoke_channel IN ('in_person', 'paper', 'phone', 'email', 'sms', 'portal')),
+					revocation_reason TEXT,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				-- At most one active (non-revoked) consent per patient and type
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_patient_consents_active
+				ON patient_consents(patient_id, consent_type)
+				WHERE revoked_at IS NULL;
+
+				CREATE INDEX IF NOT EXISTS idx_patient_consents_patient ON patient_consents(patient_id, granted_at DESC);
+				CREATE INDEX IF NOT EXISTS idx_consent_documents_entity_type ON consent_documents(healthcare_entity_id, consent_type, version DESC);
+			`,
+			Down: `
+				DROP TABLE IF EXISTS patient_consents;
+				DROP TABLE IF EXISTS consent_documents;
+			`,
+		},
+		{
+			Version:     15,
+			Description: "Structured allergies, medications and problem list replacing free-text fields",
+			Up: `
+				CREATE TABLE IF NOT EXISTS patient_allergies (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					substance VARCHAR(255) NOT NULL,
+					reaction VARCHAR(255) NOT NULL DEFAULT '',
+					severity VARCHAR(20) NOT NULL DEFAULT '' CHECK (severity IN ('', 'mild', 'moderate', 'severe', 'life_threatening')),
+					status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'inactive', 'resolved', 'entered_in_error')),
+					notes TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					created_by INTEGER NOT NULL,
+					updated_by INTEGER NOT NULL,
+					deleted_at TIMESTAMP
+				);
+
+				CREATE TABLE IF NOT EXISTS patient_medications (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					name VARCHAR(255) NOT NULL,
+					dose VARCHAR(100) NOT NULL DEFAULT '',
+					route VARCHAR(50) NOT NULL DEFAULT '',
+					frequency VARCHAR(100) NOT NULL DEFAULT '',
+					start_date DATE,
+					stop_date DATE,
+					status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'on_hold', 'stopped', 'completed', 'entered_in_error')),
+					notes TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					created_by INTEGER NOT NULL,
+					updated_by INTEGER NOT NULL,
+					deleted_at TIMESTAMP,
+					CHECK (stop_date IS NULL OR start_date IS NULL OR stop_date >= start_date)
+				);
+
+				CREATE TABLE IF NOT EXISTS patient_problems (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					code VARCHAR(20) NOT NULL DEFAULT '',
+					code_system VARCHAR(20) NOT NULL DEFAULT 'ICD-10',
+					description VARCHAR(500) NOT NULL,
+					onset_date DATE,
+					resolved_date DATE,
+					status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'inactive', 'resolved', 'entered_in_error')),
+					notes TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					created_by INTEGER NOT NULL,
+					updated_by INTEGER NOT NULL,
+					deleted_at TIMESTAMP
+				);
+
+				-- Append-only change history for all structured clinical items
+				CREATE TABLE IF NOT EXISTS patient_clinical_history (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					item_type VARCHAR(20) NOT NULL CHECK (item_type IN ('allergy', 'medication', 'problem')),
+					item_id INTEGER NOT NULL,
+					action VARCHAR(20) NOT NULL CHECK (action IN ('migrated', 'created', 'updated', 'deleted')),
+					snapshot JSONB NOT NULL,
+					changed_by INTEGER NOT NULL,
+					changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_patient_allergies_patient ON patient_allergies(patient_id) WHERE deleted_at IS NULL;
+				CREATE INDEX IF NOT EXISTS idx_patient_medications_patient ON patient_medications(patient_id) WHERE deleted_at IS NULL;
+				CREATE INDEX IF NOT EXISTS idx_patient_problems_patient ON patient_problems(patient_id) WHERE deleted_at IS NULL;
+				CREATE INDEX IF NOT EXISTS idx_patient_problems_code ON patient_problems(code) WHERE code <> '';
+				CREATE INDEX IF NOT EXISTS idx_patient_clinical_history_item ON patient_clinical_history(item_type, item_id, changed_at);
+
+				-- Move free-text values into structured rows, one row per comma/semicolon/line separated entry
+				INSERT INTO patient_allergies (patient_id, substance, created_by, updated_by, created_at, updated_at)
+				SELECT p.id, LEFT(TRIM(item), 255), p.created_by, p.created_by, p.created_at, p.updated_at
+				FROM patients p, LATERAL regexp_split_to_table(COALESCE(p.allergies, ''), '[,;\n]+') AS item
+				WHERE TRIM(item) <> ''
+				  AND LOWER(TRIM(item)) NOT IN ('none', 'none known', 'no known allergies', 'nka', 'nkda', 'n/a', 'na', '-');
+
+				INSERT INTO patient_medications (patient_id, name, created_by, updated_by, created_at, updated_at)
+				SELECT p.id, LEFT(TRIM(item), 255), p.created_by, p.created_by, p.created_at, p.updated_at
+				FROM patients p, LATERAL regexp_split_to_table(COALESCE(p.medications, ''), '[,;\n]+') AS item
+				WHERE TRIM(item) <> ''
+				  AND LOWER(TRIM(item)) NOT IN ('none', 'no medications', 'n/a', 'na', '-');
+
+				INSERT INTO patient_problems (patient_id, description, code_system, created_by, updated_by, created_at, updated_at)
+				SELECT p.id, LEFT(TRIM(item), 500), '', p.created_by, p.created_by, p.created_at, p.updated_at
+				FROM patients p, LATERAL regexp_split_to_table(COALESCE(p.medical_history, ''), '[;\n]+') AS item
+				WHERE TRIM(item) <> ''
+				  AND LOWER(TRIM(item)) NOT IN ('none', 'no significant medical history', 'n/a', 'na', '-');
+
+				INSERT INTO patient_clinical_history (patient_id, item_type, item_id, action, snapshot, changed_by)
+				SELECT patient_id, 'allergy', id, 'migrated', to_jsonb(a), created_by FROM patient_allergies a;
+				INSERT INTO patient_clinical_history (patient_id, item_type, item_id, action, snapshot, changed_by)
+				SELECT patient_id, 'medication', id, 'migrated', to_jsonb(m), created_by FROM patient_medications m;
+				INSERT INTO patient_clinical_history (patient_id, item_type, item_id, action, snapshot, changed_by)
+				SELECT patient_id, 'problem', id, 'migrated', to_jsonb(pr), created_by FROM patient_problems pr;
+
+				-- The search document no longer indexes the dropped free-text columns
+				CREATE OR REPLACE FUNCTION patients_search_vector_update()
+				RETURNS TRIGGER AS $$
+				BEGIN
+					NEW.search_vector :=
+						setweight(to_tsvector('simple', patient_search_normalize(NEW.first_name || ' ' || NEW.last_name)), 'A') ||
+						setweight(to_tsvector('simple', patient_search_normalize(
+							COALESCE(NEW.patient_id, '') || ' ' || COALESCE(NEW.national_id, '') || ' ' ||
+							COALESCE(NEW.email, '') || ' ' || COALESCE(NEW.phone, '') || ' ' ||
+							regexp_replace(COALESCE(NEW.phone, ''), '\D', '', 'g'))), 'B') ||
+						setweight(to_tsvector('simple', patient_search_normalize(
+							COALESCE(NEW.address, '') || ' ' || COALESCE(NEW.postal_code, '') || ' ' ||
+							COALESCE(NEW.occupation, ''))), 'C');
+					RETURN NEW;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				ALTER TABLE patients
+				DROP COLUMN IF EXISTS medical_history,
+				DROP COLUMN IF EXISTS allergies,
+				DROP COLUMN IF EXISTS medications;
+			`,
+			Down: `
+				ALTER TABLE patients
+				ADD COLUMN IF NOT EXISTS medical_history TEXT,
+				ADD COLUMN IF NOT EXISTS allergies TEXT,
+				ADD COLUMN IF NOT EXISTS medications TEXT;
+
+				-- Best-effort restore of the free-text columns
+				UPDATE patients p SET
+					allergies = (SELECT string_agg(substance, ', ' ORDER BY id) FROM patient_allergies WHERE patient_id = p.id AND deleted_at IS NULL),
+					medications = (SELECT string_agg(name, ', ' ORDER BY id) FROM patient_medications WHERE patient_id = p.id AND deleted_at IS NULL),
+					medical_history = (SELECT string_agg(description, '; ' ORDER BY id) FROM patient_problems WHERE patient_id = p.id AND deleted_at IS NULL);
+
+				DROP TABLE IF EXISTS patient_clinical_history;
+				DROP TABLE IF EXISTS patient_problems;
+				DROP TABLE IF EXISTS patient_medications;
+				DROP TABLE IF EXISTS patient_allergies;
+			`,
+		},
+		{
+			Version:     16,
+			Description: "Create patient documents and document access audit tables",
+			Up: `
+				CREATE TABLE IF NOT EXISTS patient_documents (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					healthcare_entity_id INTEGER NOT NULL,
+					document_type VARCHAR(30) NOT NULL CHECK (document_type IN ('referral', 'id_card', 'insurance_card', 'lab_result', 'imaging', 'prescription', 'consent_form', 'other')),
+					description TEXT NOT NULL DEFAULT '',
+					appointment_id INTEGER, -- Appointment service reference (nullable)
+					file_name VARCHAR(255) NOT NULL,
+					content_type VARCHAR(100) NOT NULL,
+					size_bytes BIGINT NOT NULL,
+					checksum_sha256 CHAR(64) NOT NULL,
+					storage_key VARCHAR(500) NOT NULL UNIQUE,
+					uploaded_by INTEGER NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					deleted_at TIMESTAMP,
+					deleted_by INTEGER
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_patient_documents_patient ON patient_documents(healthcare_entity_id, patient_id) WHERE deleted_at IS NULL;
+				CREATE INDEX IF NOT EXISTS idx_patient_documents_appointment ON patient_documents(appointment_id) WHERE appointment_id IS NOT NULL;
+
+				-- Every upload, download and deletion of a document is recorded here
+				CREATE TABLE IF NOT EXISTS document_access_log (
+					id SERIAL PRIMARY KEY,
+					document_id INTEGER NOT NULL REFERENCES patient_documents(id) ON DELETE CASCADE,
+					patient_id INTEGER NOT NULL,
+					healthcare_entity_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					action VARCHAR(20) NOT NULL CHECK (action IN ('upload', 'download', 'update', 'delete')),
+					client_ip VARCHAR(64) NOT NULL DEFAULT '',
+					user_agent TEXT NOT NULL DEFAULT '',
+					accessed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_document_access_log_document ON document_access_log(document_id, accessed_at);
+				CREATE INDEX IF NOT EXISTS idx_document_access_log_patient ON document_access_log(patient_id, accessed_at);
+			`,
+			Down: `
+				DROP TABLE IF EXISTS document_access_log;
+				DROP TABLE IF EXISTS patient_documents;
+			`,
+		},
+		{
+			Version:     17,
+			Description: "Add per-entity medical record number (MRN) generation",
+			Up: `
+				CREATE TABLE IF NOT EXISTS mrn_configurations (
+					healthcare_entity_id INTEGER PRIMARY KEY,
+					prefix VARCHAR(10) NOT NULL DEFAULT 'MRN',
+					separator VARCHAR(1) NOT NULL DEFAULT '-' CHECK (separator IN ('', '-', '/')),
+					include_year BOOLEAN NOT NULL DEFAULT true,
+					yearly_reset BOOLEAN NOT NULL DEFAULT true,
+					sequence_digits INTEGER NOT NULL DEFAULT 6 CHECK (sequence_digits BETWEEN 4 AND 12),
+					check_digit VARCHAR(10) NOT NULL DEFAULT 'luhn' CHECK (check_digit IN ('none', 'luhn', 'mod11')),
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_by INTEGER
+				);
+
+				-- One counter per entity and period (the year when numbering resets yearly, else 0)
+				CREATE TABLE IF NOT EXISTS mrn_sequences (
+					healthcare_entity_id INTEGER NOT NULL,
+					period INTEGER NOT NULL,
+					last_value BIGINT NOT NULL,
+					PRIMARY KEY (healthcare_entity_id, period)
+				);
+
+				-- Luhn check digit over a string of digits
+				CREATE OR REPLACE FUNCTION mrn_luhn_check_digit(digits TEXT)
+				RETURNS TEXT AS $$
+				DECLARE
+					total INTEGER := 0;
+					d INTEGER;
+					len INTEGER := length(digits);
+				BEGIN
+					FOR i IN 1..len LOOP
+						d := substr(digits, len - i + 1, 1)::INTEGER;
+						IF i % 2 = 1 THEN
+							d := d * 2;
+							IF d > 9 THEN
+								d := d - 9;
+							END IF;
+						END IF;
+						total := total + d;
+					END LOOP;
+					RETURN ((10 - total % 10) % 10)::TEXT;
+				END;
+				$$ LANGUAGE plpgsql IMMUTABLE;
+
+				-- Mod-11 check digit with weights 2..7 from the right; 10 is written as X
+				CREATE OR REPLACE FUNCTION mrn_mod11_check_digit(digits TEXT)
+				RETURNS TEXT AS $$
+				DECLARE
+					total INTEGER := 0;
+					len INTEGER := length(digits);
+					remainder INTEGER;
+				BEGIN
+					FOR i IN 1..len LOOP
+						total := total + substr(digits, len - i + 1, 1)::INTEGER * (2 + (i - 1) % 6);
+					END LOOP;
+					remainder := 11 - total % 11;
+					IF remainder = 11 THEN
+						RETURN '0';
+					ELSIF remainder = 10 THEN
+						RETURN 'X';
+					END IF;
+					RETURN remainder::TEXT;
+				END;
+				$$ LANGUAGE plpgsql IMMUTABLE;
+
+				-- Format an MRN: prefix, year, zero-padded sequence and check digit joined by the separator.
+				-- The check digit covers the numeric part (year + sequence).
+				CREATE OR REPLACE FUNCTION format_patient_mrn(p_prefix TEXT, p_separator TEXT, p_include_year BOOLEAN,
+					p_sequence_digits INTEGER, p_check_digit TEXT, p_year INTEGER, p_sequence BIGINT)
+				RETURNS TEXT AS $$
+				DECLARE
+					seq TEXT := p_sequence::TEXT;
+					year_part TEXT := CASE WHEN p_include_year THEN p_year::TEXT ELSE '' END;
+					parts TEXT[] := ARRAY[]::TEXT[];
+				BEGIN
+					IF length(seq) < p_sequence_digits THEN
+						seq := lpad(seq, p_sequence_digits, '0');
+					END IF;
+					IF p_prefix <> '' THEN
+						parts := parts || p_prefix;
+					END IF;
+					IF year_part <> '' THEN
+						parts := parts || year_part;
+					END IF;
+					parts := parts || seq;
+					IF p_check_digit = 'luhn' THEN
+						parts := parts || mrn_luhn_check_digit(year_part || seq);
+					ELSIF p_check_digit = 'mod11' THEN
+						parts := parts || mrn_mod11_check_digit(year_part || seq);
+					END IF;
+					RETURN array_to_string(parts, p_separator);
+				END;
+				$$ LANGUAGE plpgsql IMMUTABLE;
+
+				-- Allocate the next MRN of an entity. The sequence row is bumped with an upsert, which
+				-- locks it for the rest of the transaction, so concurrent callers never share a number.
+				-- Numbers already taken (e.g. after a pattern change) are skipped.
+				CREATE OR REPLACE FUNCTION next_patient_mrn(p_entity_id INTEGER, p_at TIMESTAMP)
+				RETURNS TEXT AS $$
+				DECLARE
+					cfg mrn_configurations%ROWTYPE;
+					v_year INTEGER := EXTRACT(YEAR FROM p_at)::INTEGER;
+					v_period INTEGER;
+					v_sequence BIGINT;
+					v_mrn TEXT;
+				BEGIN
+					SELECT * INTO cfg FROM mrn_configurations WHERE healthcare_entity_id = p_entity_id;
+					IF NOT FOUND THEN
+						cfg.prefix := 'MRN';
+						cfg.separator := '-';
+						cfg.include_year := true;
+						cfg.yearly_reset := true;
+						cfg.sequence_digits := 6;
+						cfg.check_digit := 'luhn';
+					END IF;
+
+					v_period := CASE WHEN cfg.include_year AND cfg.yearly_reset THEN v_year ELSE 0 END;
+
+					LOOP
+						INSERT INTO mrn_sequences (healthcare_entity_id, period, last_value)
+						VALUES (p_entity_id, v_period, 1)
+						ON CONFLICT (healthcare_entity_id, period)
+						DO UPDATE SET last_value = mrn_sequences.last_value + 1
+						RETURNING last_value INTO v_sequence;
+
+						v_mrn := format_patient_mrn(cfg.prefix, cfg.separator, cfg.include_year, cfg.sequence_digits,
+							cfg.check_digit, v_year, v_sequence);
+						EXIT WHEN NOT EXISTS (SELECT 1 FROM patients WHERE healthcare_entity_id = p_entity_id AND mrn = v_mrn);
+					END LOOP;
+					RETURN v_mrn;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				ALTER TABLE patients ADD COLUMN IF NOT EXISTS mrn VARCHAR(50);
+
+				-- Assign MRNs to existing patients in creation order, without bumping updated_at
+				ALTER TABLE patients DISABLE TRIGGER update_patients_updated_at;
+				DO $$
+				DECLARE r RECORD;
+				BEGIN
+					FOR r IN SELECT id, healthcare_entity_id, created_at FROM patients WHERE mrn IS NULL ORDER BY created_at, id LOOP
+						UPDATE patients SET mrn = next_patient_mrn(r.healthcare_entity_id, COALESCE(r.created_at, CURRENT_TIMESTAMP)) WHERE id = r.id;
+					END LOOP;
+				END$$;
+				ALTER TABLE patients ENABLE TRIGGER update_patients_updated_at;
+
+				ALTER TABLE patients ALTER COLUMN mrn SET NOT NULL;
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_patients_entity_mrn ON patients(healthcare_entity_id, mrn);
+
+				-- Index the MRN in the search document
+				CREATE OR REPLACE FUNCTION patients_search_vector_update()
+				RETURNS TRIGGER AS $$
+				BEGIN
+					NEW.search_vector :=
+						setweight(to_tsvector('simple', patient_search_normalize(NEW.first_name || ' ' || NEW.last_name)), 'A') ||
+						setweight(to_tsvector('simple', patient_search_normalize(
+							COALESCE(NEW.mrn, '') || ' ' || COALESCE(NEW.patient_id, '') || ' ' || COALESCE(NEW.national_id, '') || ' ' ||
+							COALESCE(NEW.email, '') || ' ' || COALESCE(NEW.phone, '') || ' ' ||
+							regexp_replace(COALESCE(NEW.phone, ''), '\D', '', 'g'))), 'B') ||
+						setweight(to_tsvector('simple', patient_search_normalize(
+							COALESCE(NEW.address, '') || ' ' || COALESCE(NEW.postal_code, '') || ' ' ||
+							COALESCE(NEW.occupation, ''))), 'C');
+					RETURN NEW;
+				END;
+				$$ LANGUAGE plpgsql;
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_patients_entity_mrn;
+				ALTER TABLE patients DROP COLUMN IF EXISTS mrn;
+				DROP FUNCTION IF EXISTS next_patient_mrn(INTEGER, TIMESTAMP);
+				DROP FUNCTION IF EXISTS format_patient_mrn(TEXT, TEXT, BOOLEAN, INTEGER, TEXT, INTEGER, BIGINT);
+				DROP FUNCTION IF EXISTS mrn_mod11_check_digit(TEXT);
+				DROP FUNCTION IF EXISTS mrn_luhn_check_digit(TEXT);
+				DROP TABLE IF EXISTS mrn_sequences;
+				DROP TABLE IF EXISTS mrn_configurations;
+			`,
+		},
+		{
+			Version:     18,
+			Description: "Add patient relationships with legal guardian flag and proxy rights",
+			Up: `
+				-- related_patient_id is the patient_id's <relationship_type> (e.g. related is the parent of patient)
+				CREATE TABLE IF NOT EXISTS patient_relationships (
+					id SERIAL PRIMARY KEY,
+					healthcare_entity_id INTEGER NOT NULL,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					related_patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					relationship_type VARCHAR(20) NOT NULL CHECK (relationship_type IN ('parent', 'guardian', 'spouse', 'sibling', 'caregiver')),
+					is_legal_guardian BOOLEAN NOT NULL DEFAULT false,
+					can_book_appointments BOOLEAN NOT NULL DEFAULT false,
+					can_view_records BOOLEAN NOT NULL DEFAULT false,
+					can_manage_consents BOOLEAN NOT NULL DEFAULT false,
+					notes TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					created_by INTEGER NOT NULL,
+					CHECK (patient_id <> related_patient_id),
+					CHECK (NOT is_legal_guardian OR relationship_type IN ('parent', 'guardian'))
+				);
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_patient_relationships_unique
+					ON patient_relationships(patient_id, related_patient_id, relationship_type);
+				CREATE INDEX IF NOT EXISTS idx_patient_relationships_patient ON patient_relationships(patient_id);
+				CREATE INDEX IF NOT EXISTS idx_patient_relationships_related ON patient_relationships(related_patient_id);
+				CREATE INDEX IF NOT EXISTS idx_patient_relationships_entity ON patient_relationships(healthcare_entity_id);
+			`,
+			Down: `
+				DROP TABLE IF EXISTS patient_relationships;
+			`,
+		},
+		{
+			Version:     19,
+			Description: "Add legal holds and data subject (export/erasure) requests",
+			Up: `
+				-- A legal hold blocks erasure and retention purges of a patient while active
+				CREATE TABLE IF NOT EXISTS patient_legal_holds (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					healthcare_entity_id INTEGER NOT NULL,
+					reason TEXT NOT NULL,
+					reference VARCHAR(100) NOT NULL DEFAULT '',
+					placed_by INTEGER NOT NULL,
+					placed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP,
+					released_by INTEGER,
+					released_at TIMESTAMP,
+					release_reason TEXT
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_patient_legal_holds_patient ON patient_legal_holds(patient_id) WHERE released_at IS NULL;
+
+				CREATE TABLE IF NOT EXISTS data_subject_requests (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id),
+					healthcare_entity_id INTEGER NOT NULL,
+					request_type VARCHAR(20) NOT NULL CHECK (request_type IN ('export', 'erasure')),
+					legal_basis VARCHAR(20) NOT NULL CHECK (legal_basis IN ('gdpr', 'law_09_08', 'pipeda', 'other')),
+					status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'completed', 'rejected', 'failed')),
+					received_via VARCHAR(20) NOT NULL DEFAULT 'in_person' CHECK (received_via IN ('in_person', 'paper', 'phone', 'email', 'portal')),
+					notes TEXT NOT NULL DEFAULT '',
+					requested_by INTEGER NOT NULL,
+					due_at TIMESTAMP NOT NULL,
+					processed_by INTEGER,
+					started_at TIMESTAMP,
+					completed_at TIMESTAMP,
+					status_reason TEXT NOT NULL DEFAULT '',
+					result JSONB,
+					archive_storage_key VARCHAR(255),
+					archive_size_bytes BIGINT,
+					archive_checksum_sha256 CHAR(64),
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_data_subject_requests_entity ON data_subject_requests(healthcare_entity_id, status, created_at DESC);
+				CREATE INDEX IF NOT EXISTS idx_data_subject_requests_patient ON data_subject_requests(patient_id, created_at DESC);
+
+				-- Anonymised patients keep their clinical record under a pseudonymous MRN
+				ALTER TABLE patients ADD COLUMN IF NOT EXISTS anonymised_at TIMESTAMP;
+
+				ALTER TABLE document_access_log DROP CONSTRAINT IF EXISTS document_access_log_action_check;
+				ALTER TABLE document_access_log ADD CONSTRAINT document_access_log_action_check
+					CHECK (action IN ('upload', 'download', 'update', 'delete', 'export', 'erase'));
+			`,
+			Down: `
+				ALTER TABLE document_access_log DROP CONSTRAINT IF EXISTS document_access_log_action_check;
+				ALTER TABLE document_access_log ADD CONSTRAINT document_access_log_action_check
+					CHECK (action IN ('upload', 'download', 'update', 'delete'));
+				ALTER TABLE patients DROP COLUMN IF EXISTS anonymised_at;
+				DROP TABLE IF EXISTS data_subject_requests;
+				DROP TABLE IF EXISTS patient_legal_holds;
+			`,
+		},
+		{
+			Version:     20,
+			Description: "Add retention policies, retention runs and the patient archive",
+			Up: `
+				-- Per-entity retention policy; a policy with a country_id applies to patients of that
+				-- country and takes precedence over the entity default (country_id NULL)
+				CREATE TABLE IF NOT EXISTS retention_policies (
+					id SERIAL PRIMARY KEY,
+					healthcare_entity_id INTEGER NOT NULL,
+					country_id INTEGER, -- Location service country ID (nullable)
+					archive_after_years INTEGER CHECK (archive_after_years BETWEEN 1 AND 100),
+					purge_after_years INTEGER CHECK (purge_after_years BETWEEN 1 AND 100),
+					purge_action VARCHAR(20) NOT NULL DEFAULT 'anonymise' CHECK (purge_action IN ('anonymise', 'delete')),
+					is_active BOOLEAN NOT NULL DEFAULT true,
+					notes TEXT NOT NULL DEFAULT '',
+					created_by INTEGER NOT NULL,
+					updated_by INTEGER NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					CHECK (archive_after_years IS NOT NULL OR purge_after_years IS NOT NULL),
+					CHECK (archive_after_years IS NULL OR purge_after_years IS NULL OR purge_after_years > archive_after_years)
+				);
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_retention_policies_scope
+					ON retention_policies(healthcare_entity_id, COALESCE(country_id, 0));
+
+				CREATE TABLE IF NOT EXISTS retention_runs (
+					id SERIAL PRIMARY KEY,
+					healthcare_entity_id INTEGER NOT NULL,
+					dry_run BOOLEAN NOT NULL,
+					triggered_by INTEGER, -- NULL when started by the scheduled job
+					status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'completed', 'failed')),
+					started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					completed_at TIMESTAMP,
+					patients_evaluated INTEGER NOT NULL DEFAULT 0,
+					archived_count INTEGER NOT NULL DEFAULT 0,
+					anonymised_count INTEGER NOT NULL DEFAULT 0,
+					deleted_count INTEGER NOT NULL DEFAULT 0,
+					skipped_count INTEGER NOT NULL DEFAULT 0,
+					failed_count INTEGER NOT NULL DEFAULT 0,
+					error TEXT NOT NULL DEFAULT ''
+				);
+
+				-- One run at a time per entity
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_retention_runs_running
+					ON retention_runs(healthcare_entity_id) WHERE status = 'running';
+				CREATE INDEX IF NOT EXISTS idx_retention_runs_entity ON retention_runs(healthcare_entity_id, started_at DESC);
+
+				-- The run report: one row per patient due for an action
+				CREATE TABLE IF NOT EXISTS retention_run_items (
+					id SERIAL PRIMARY KEY,
+					run_id INTEGER NOT NULL REFERENCES retention_runs(id) ON DELETE CASCADE,
+					patient_id INTEGER NOT NULL,
+					mrn VARCHAR(50) NOT NULL,
+					policy_id INTEGER NOT NULL,
+					action VARCHAR(20) NOT NULL CHECK (action IN ('archive', 'anonymise', 'delete')),
+					outcome VARCHAR(20) NOT NULL CHECK (outcome IN ('planned', 'done', 'skipped', 'failed')),
+					last_activity_at TIMESTAMP NOT NULL,
+					detail TEXT NOT NULL DEFAULT '',
+					processed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_retention_run_items_run ON retention_run_items(run_id, id);
+
+				-- Archived patients leave the hot tables. The patient row and every dependent row are
+				-- kept as JSON (records is keyed by table name) so the patient can be restored as is.
+				CREATE TABLE IF NOT EXISTS patient_archive (
+					patient_id INTEGER PRIMARY KEY,
+					healthcare_entity_id INTEGER NOT NULL,
+					mrn VARCHAR(50) NOT NULL,
+					country_id INTEGER,
+					last_activity_at TIMESTAMP NOT NULL,
+					archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					retention_run_id INTEGER REFERENCES retention_runs(id) ON DELETE SET NULL,
+					patient JSONB NOT NULL,
+					records JSONB NOT NULL
+				);
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_patient_archive_entity_mrn ON patient_archive(healthcare_entity_id, mrn);
+
+				-- Move a patient and its dependent rows into patient_archive. Tables referencing
+				-- patients must be listed here and in restore_patient.
+				CREATE OR REPLACE FUNCTION archive_patient(p_patient_id INTEGER, p_last_activity TIMESTAMP, p_run_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_patient patients%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_patient FROM patients WHERE id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patient_archive (patient_id, healthcare_entity_id, mrn, country_id, last_activity_at, retention_run_id, patient, records)
+					VALUES (v_patient.id, v_patient.healthcare_entity_id, v_patient.mrn, v_patient.country_id, p_last_activity, p_run_id,
+						to_jsonb(v_patient) - 'search_vector',
+						jsonb_build_object(
+							'patient_consents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_consents t WHERE t.patient_id = p_patient_id),
+							'patient_allergies', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_allergies t WHERE t.patient_id = p_patient_id),
+							'patient_medications', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_medications t WHERE t.patient_id = p_patient_id),
+							'patient_problems', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_problems t WHERE t.patient_id = p_patient_id),
+							'patient_clinical_history', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_clinical_history t WHERE t.patient_id = p_patient_id),
+							'patient_documents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_documents t WHERE t.patient_id = p_patient_id),
+							'document_access_log', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM document_access_log t WHERE t.patient_id = p_patient_id),
+							'patient_relationships', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_relationships t WHERE t.patient_id = p_patient_id OR t.related_patient_id = p_patient_id),
+							'patient_legal_holds', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_legal_holds t WHERE t.patient_id = p_patient_id),
+							'data_subject_requests', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM data_subject_requests t WHERE t.patient_id = p_patient_id)
+						));
+
+					-- data_subject_requests does not cascade; everything else goes with the patient row
+					DELETE FROM data_subject_requests WHERE patient_id = p_patient_id;
+					DELETE FROM patients WHERE id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				-- Move an archived patient back into the hot tables with its original IDs. Relationships
+				-- with patients that are no longer in the hot tables are dropped.
+				CREATE OR REPLACE FUNCTION restore_patient(p_patient_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_archive patient_archive%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_archive FROM patient_archive WHERE patient_id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'archived patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patients SELECT * FROM jsonb_populate_record(NULL::patients, v_archive.patient);
+					INSERT INTO patient_consents SELECT * FROM jsonb_populate_recordset(NULL::patient_consents, v_archive.records->'patient_consents');
+					INSERT INTO patient_allergies SELECT * FROM jsonb_populate_recordset(NULL::patient_allergies, v_archive.records->'patient_allergies');
+					INSERT INTO patient_medications SELECT * FROM jsonb_populate_recordset(NULL::patient_medications, v_archive.records->'patient_medications');
+					INSERT INTO patient_problems SELECT * FROM jsonb_populate_recordset(NULL::patient_problems, v_archive.records->'patient_problems');
+					INSERT INTO patient_clinical_history SELECT * FROM jsonb_populate_recordset(NULL::patient_clinical_history, v_archive.records->'patient_clinical_history');
+					INSERT INTO patient_documents SELECT * FROM jsonb_populate_recordset(NULL::patient_documents, v_archive.records->'patient_documents');
+					INSERT INTO document_access_log SELECT * FROM jsonb_populate_recordset(NULL::document_access_log, v_archive.records->'document_access_log');
+					INSERT INTO patient_relationships
+						SELECT r.* FROM jsonb_populate_recordset(NULL::patient_relationships, v_archive.records->'patient_relationships') r
+						WHERE EXISTS (SELECT 1 FROM patients WHERE id = r.patient_id)
+						  AND EXISTS (SELECT 1 FROM patients WHERE id = r.related_patient_id);
+					INSERT INTO patient_legal_holds SELECT * FROM jsonb_populate_recordset(NULL::patient_legal_holds, v_archive.records->'patient_legal_holds');
+					INSERT INTO data_subject_requests SELECT * FROM jsonb_populate_recordset(NULL::data_subject_requests, v_archive.records->'data_subject_requests');
+
+					DELETE FROM patient_archive WHERE patient_id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				-- MRNs of archived patients stay reserved
+				CREATE OR REPLACE FUNCTION next_patient_mrn(p_entity_id INTEGER, p_at TIMESTAMP)
+				RETURNS TEXT AS $$
+				DECLARE
+					cfg mrn_configurations%ROWTYPE;
+					v_year INTEGER := EXTRACT(YEAR FROM p_at)::INTEGER;
+					v_period INTEGER;
+					v_sequence BIGINT;
+					v_mrn TEXT;
+				BEGIN
+					SELECT * INTO cfg FROM mrn_configurations WHERE healthcare_entity_id = p_entity_id;
+					IF NOT FOUND THEN
+						cfg.prefix := 'MRN';
+						cfg.separator := '-';
+						cfg.include_year := true;
+						cfg.yearly_reset := true;
+						cfg.sequence_digits := 6;
+						cfg.check_digit := 'luhn';
+					END IF;
+
+					v_period := CASE WHEN cfg.include_year AND cfg.yearly_reset THEN v_year ELSE 0 END;
+
+					LOOP
+						INSERT INTO mrn_sequences (healthcare_entity_id, period, last_value)
+						VALUES (p_entity_id, v_period, 1)
+						ON CONFLICT (healthcare_entity_id, period)
+						DO UPDATE SET last_value = mrn_sequences.last_value + 1
+						RETURNING last_value INTO v_sequence;
+
+						v_mrn := format_patient_mrn(cfg.prefix, cfg.separator, cfg.include_year, cfg.sequence_digits,
+							cfg.check_digit, v_year, v_sequence);
+						EXIT WHEN NOT EXISTS (SELECT 1 FROM patients WHERE healthcare_entity_id = p_entity_id AND mrn = v_mrn)
+							AND NOT EXISTS (SELECT 1 FROM patient_archive WHERE healthcare_entity_id = p_entity_id AND mrn = v_mrn);
+					END LOOP;
+					RETURN v_mrn;
+				END;
+				$$ LANGUAGE plpgsql;
+			`,
+			Down: `
+				CREATE OR REPLACE FUNCTION next_patient_mrn(p_entity_id INTEGER, p_at TIMESTAMP)
+				RETURNS TEXT AS $$
+				DECLARE
+					cfg mrn_configurations%ROWTYPE;
+					v_year INTEGER := EXTRACT(YEAR FROM p_at)::INTEGER;
+					v_period INTEGER;
+					v_sequence BIGINT;
+					v_mrn TEXT;
+				BEGIN
+					SELECT * INTO cfg FROM mrn_configurations WHERE healthcare_entity_id = p_entity_id;
+					IF NOT FOUND THEN
+						cfg.prefix := 'MRN';
+						cfg.separator := '-';
+						cfg.include_year := true;
+						cfg.yearly_reset := true;
+						cfg.sequence_digits := 6;
+						cfg.check_digit := 'luhn';
+					END IF;
+
+					v_period := CASE WHEN cfg.include_year AND cfg.yearly_reset THEN v_year ELSE 0 END;
+
+					LOOP
+						INSERT INTO mrn_sequences (healthcare_entity_id, period, last_value)
+						VALUES (p_entity_id, v_period, 1)
+						ON CONFLICT (healthcare_entity_id, period)
+						DO UPDATE SET last_value = mrn_sequences.last_value + 1
+						RETURNING last_value INTO v_sequence;
+
+						v_mrn := format_patient_mrn(cfg.prefix, cfg.separator, cfg.include_year, cfg.sequence_digits,
+							cfg.check_digit, v_year, v_sequence);
+						EXIT WHEN NOT EXISTS (SELECT 1 FROM patients WHERE healthcare_entity_id = p_entity_id AND mrn = v_mrn);
+					END LOOP;
+					RETURN v_mrn;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				DROP FUNCTION IF EXISTS restore_patient(INTEGER);
+				DROP FUNCTION IF EXISTS archive_patient(INTEGER, TIMESTAMP, INTEGER);
+				DROP TABLE IF EXISTS patient_archive;
+				DROP TABLE IF EXISTS retention_run_items;
+				DROP TABLE IF EXISTS retention_runs;
+				DROP TABLE IF EXISTS retention_policies;
+			`,
+		},
+		{
+			Version:     21,
+			Description: "Create patient vital signs table and include it in patient archives",
+			Up: `
+				-- One set of vital signs taken at the same time. Values are stored in metric units
+				-- (mmHg, bpm, °C, %, kg, cm, kg/m², mmol/L) whatever the units they were entered in.
+				CREATE TABLE IF NOT EXISTS patient_vitals (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					healthcare_entity_id INTEGER NOT NULL,
+					appointment_id INTEGER, -- Appointment service reference (nullable)
+					measured_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					systolic_mmhg SMALLINT CHECK (systolic_mmhg BETWEEN 40 AND 300),
+					diastolic_mmhg SMALLINT CHECK (diastolic_mmhg BETWEEN 20 AND 200),
+					heart_rate_bpm SMALLINT CHECK (heart_rate_bpm BETWEEN 20 AND 300),
+					temperature_c NUMERIC(4,2) CHECK (temperature_c BETWEEN 25 AND 45),
+					spo2_percent SMALLINT CHECK (spo2_percent BETWEEN 50 AND 100),
+					weight_kg NUMERIC(6,3) CHECK (weight_kg BETWEEN 0.2 AND 500),
+					height_cm NUMERIC(5,2) CHECK (height_cm BETWEEN 20 AND 260),
+					bmi NUMERIC(4,1),
+					glucose_mmol_l NUMERIC(5,2) CHECK (glucose_mmol_l BETWEEN 0.5 AND 60),
+					glucose_context VARCHAR(20) NOT NULL DEFAULT '' CHECK (glucose_context IN ('', 'fasting', 'random', 'post_meal')),
+					entered_unit_system VARCHAR(10) NOT NULL CHECK (entered_unit_system IN ('metric', 'imperial')),
+					notes TEXT NOT NULL DEFAULT '',
+					recorded_by INTEGER NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					deleted_at TIMESTAMP, -- Entered in error
+					deleted_by INTEGER,
+					deletion_reason TEXT NOT NULL DEFAULT '',
+					CHECK ((systolic_mmhg IS NULL) = (diastolic_mmhg IS NULL)),
+					CHECK (diastolic_mmhg < systolic_mmhg),
+					CHECK (glucose_context = '' OR glucose_mmol_l IS NOT NULL),
+					CHECK (num_nonnulls(systolic_mmhg, heart_rate_bpm, temperature_c, spo2_percent, weight_kg, height_cm, glucose_mmol_l) > 0)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_patient_vitals_patient ON patient_vitals(patient_id, measured_at) WHERE deleted_at IS NULL;
+				CREATE INDEX IF NOT EXISTS idx_patient_vitals_appointment ON patient_vitals(appointment_id) WHERE appointment_id IS NOT NULL;
+
+				-- Move a patient and its dependent rows into patient_archive. Tables referencing
+				-- patients must be listed here and in restore_patient.
+				CREATE OR REPLACE FUNCTION archive_patient(p_patient_id INTEGER, p_last_activity TIMESTAMP, p_run_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_patient patients%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_patient FROM patients WHERE id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patient_archive (patient_id, healthcare_entity_id, mrn, country_id, last_activity_at, retention_run_id, patient, records)
+					VALUES (v_patient.id, v_patient.healthcare_entity_id, v_patient.mrn, v_patient.country_id, p_last_activity, p_run_id,
+						to_jsonb(v_patient) - 'search_vector',
+						jsonb_build_object(
+							'patient_consents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_consents t WHERE t.patient_id = p_patient_id),
+							'patient_allergies', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_allergies t WHERE t.patient_id = p_patient_id),
+							'patient_medications', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_medications t WHERE t.patient_id = p_patient_id),
+							'patient_problems', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_problems t WHERE t.patient_id = p_patient_id),
+							'patient_clinical_history', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_clinical_history t WHERE t.patient_id = p_patient_id),
+							'patient_documents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_documents t WHERE t.patient_id = p_patient_id),
+							'document_access_log', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM document_access_log t WHERE t.patient_id = p_patient_id),
+							'patient_relationships', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_relationships t WHERE t.patient_id = p_patient_id OR t.related_patient_id = p_patient_id),
+							'patient_legal_holds', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_legal_holds t WHERE t.patient_id = p_patient_id),
+							'data_subject_requests', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM data_subject_requests t WHERE t.patient_id = p_patient_id),
+							'patient_vitals', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_vitals t WHERE t.patient_id = p_patient_id)
+						));
+
+					-- data_subject_requests does not cascade; everything else goes with the patient row
+					DELETE FROM data_subject_requests WHERE patient_id = p_patient_id;
+					DELETE FROM patients WHERE id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				-- Move an archived patient back into the hot tables with its original IDs. Relationships
+				-- with patients that are no longer in the hot tables are dropped.
+				CREATE OR REPLACE FUNCTION restore_patient(p_patient_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_archive patient_archive%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_archive FROM patient_archive WHERE patient_id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'archived patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patients SELECT * FROM jsonb_populate_record(NULL::patients, v_archive.patient);
+					INSERT INTO patient_consents SELECT * FROM jsonb_populate_recordset(NULL::patient_consents, v_archive.records->'patient_consents');
+					INSERT INTO patient_allergies SELECT * FROM jsonb_populate_recordset(NULL::patient_allergies, v_archive.records->'patient_allergies');
+					INSERT INTO patient_medications SELECT * FROM jsonb_populate_recordset(NULL::patient_medications, v_archive.records->'patient_medications');
+					INSERT INTO patient_problems SELECT * FROM jsonb_populate_recordset(NULL::patient_problems, v_archive.records->'patient_problems');
+					INSERT INTO patient_clinical_history SELECT * FROM jsonb_populate_recordset(NULL::patient_clinical_history, v_archive.records->'patient_clinical_history');
+					INSERT INTO patient_documents SELECT * FROM jsonb_populate_recordset(NULL::patient_documents, v_archive.records->'patient_documents');
+					INSERT INTO document_access_log SELECT * FROM jsonb_populate_recordset(NULL::document_access_log, v_archive.records->'document_access_log');
+					INSERT INTO patient_relationships
+						SELECT r.* FROM jsonb_populate_recordset(NULL::patient_relationships, v_archive.records->'patient_relationships') r
+						WHERE EXISTS (SELECT 1 FROM patients WHERE id = r.patient_id)
+						  AND EXISTS (SELECT 1 FROM patients WHERE id = r.related_patient_id);
+					INSERT INTO patient_legal_holds SELECT * FROM jsonb_populate_recordset(NULL::patient_legal_holds, v_archive.records->'patient_legal_holds');
+					INSERT INTO data_subject_requests SELECT * FROM jsonb_populate_recordset(NULL::data_subject_requests, v_archive.records->'data_subject_requests');
+					-- Patients archived before vital signs existed have no patient_vitals entry
+					INSERT INTO patient_vitals SELECT * FROM jsonb_populate_recordset(NULL::patient_vitals, COALESCE(v_archive.records->'patient_vitals', '[]'));
+
+					DELETE FROM patient_archive WHERE patient_id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+			`,
+			Down: `
+				-- Move a patient and its dependent rows into patient_archive. Tables referencing
+				-- patients must be listed here and in restore_patient.
+				CREATE OR REPLACE FUNCTION archive_patient(p_patient_id INTEGER, p_last_activity TIMESTAMP, p_run_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_patient patients%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_patient FROM patients WHERE id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patient_archive (patient_id, healthcare_entity_id, mrn, country_id, last_activity_at, retention_run_id, patient, records)
+					VALUES (v_patient.id, v_patient.healthcare_entity_id, v_patient.mrn, v_patient.country_id, p_last_activity, p_run_id,
+						to_jsonb(v_patient) - 'search_vector',
+						jsonb_build_object(
+							'patient_consents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_consents t WHERE t.patient_id = p_patient_id),
+							'patient_allergies', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_allergies t WHERE t.patient_id = p_patient_id),
+							'patient_medications', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_medications t WHERE t.patient_id = p_patient_id),
+							'patient_problems', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_problems t WHERE t.patient_id = p_patient_id),
+							'patient_clinical_history', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_clinical_history t WHERE t.patient_id = p_patient_id),
+							'patient_documents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_documents t WHERE t.patient_id = p_patient_id),
+							'document_access_log', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM document_access_log t WHERE t.patient_id = p_patient_id),
+							'patient_relationships', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_relationships t WHERE t.patient_id = p_patient_id OR t.related_patient_id = p_patient_id),
+							'patient_legal_holds', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_legal_holds t WHERE t.patient_id = p_patient_id),
+							'data_subject_requests', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM data_subject_requests t WHERE t.patient_id = p_patient_id)
+						));
+
+					-- data_subject_requests does not cascade; everything else goes with the patient row
+					DELETE FROM data_subject_requests WHERE patient_id = p_patient_id;
+					DELETE FROM patients WHERE id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				-- Move an archived patient back into the hot tables with its original IDs. Relationships
+				-- with patients that are no longer in the hot tables are dropped.
+				CREATE OR REPLACE FUNCTION restore_patient(p_patient_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_archive patient_archive%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_archive FROM patient_archive WHERE patient_id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'archived patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patients SELECT * FROM jsonb_populate_record(NULL::patients, v_archive.patient);
+					INSERT INTO patient_consents SELECT * FROM jsonb_populate_recordset(NULL::patient_consents, v_archive.records->'patient_consents');
+					INSERT INTO patient_allergies SELECT * FROM jsonb_populate_recordset(NULL::patient_allergies, v_archive.records->'patient_allergies');
+					INSERT INTO patient_medications SELECT * FROM jsonb_populate_recordset(NULL::patient_medications, v_archive.records->'patient_medications');
+					INSERT INTO patient_problems SELECT * FROM jsonb_populate_recordset(NULL::patient_problems, v_archive.records->'patient_problems');
+					INSERT INTO patient_clinical_history SELECT * FROM jsonb_populate_recordset(NULL::patient_clinical_history, v_archive.records->'patient_clinical_history');
+					INSERT INTO patient_documents SELECT * FROM jsonb_populate_recordset(NULL::patient_documents, v_archive.records->'patient_documents');
+					INSERT INTO document_access_log SELECT * FROM jsonb_populate_recordset(NULL::document_access_log, v_archive.records->'document_access_log');
+					INSERT INTO patient_relationships
+						SELECT r.* FROM jsonb_populate_recordset(NULL::patient_relationships, v_archive.records->'patient_relationships') r
+						WHERE EXISTS (SELECT 1 FROM patients WHERE id = r.patient_id)
+						  AND EXISTS (SELECT 1 FROM patients WHERE id = r.related_patient_id);
+					INSERT INTO patient_legal_holds SELECT * FROM jsonb_populate_recordset(NULL::patient_legal_holds, v_archive.records->'patient_legal_holds');
+					INSERT INTO data_subject_requests SELECT * FROM jsonb_populate_recordset(NULL::data_subject_requests, v_archive.records->'data_subject_requests');
+
+					DELETE FROM patient_archive WHERE patient_id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				DROP TABLE IF EXISTS patient_vitals;
+			`,
+		},
+		{
+			Version:     22,
+			Description: "Create patient versions table recording every change of a patient row",
+			Up: `
+				-- Set by every update of a patient, as the author of the version it creates
+				ALTER TABLE patients ADD COLUMN IF NOT EXISTS updated_by INTEGER;
+
+				-- Successive states of a patient row. A version is the row as it was from valid_from
+				-- until valid_to (NULL for the current version), without its search vector.
+				CREATE TABLE IF NOT EXISTS patient_versions (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					version INTEGER NOT NULL,
+					data JSONB NOT NULL,
+					valid_from TIMESTAMP NOT NULL,
+					valid_to TIMESTAMP,
+					change_type VARCHAR(20) NOT NULL CHECK (change_type IN ('migrated', 'created', 'updated', 'restored', 'deactivated', 'anonymised')),
+					changed_fields TEXT[] NOT NULL DEFAULT '{}',
+					changed_by INTEGER,
+					restored_from_version INTEGER,
+					change_reason TEXT NOT NULL DEFAULT '',
+					UNIQUE (patient_id, version),
+					CHECK (valid_to IS NULL OR valid_to >= valid_from)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_patient_versions_validity ON patient_versions(patient_id, valid_from);
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_patient_versions_current ON patient_versions(patient_id) WHERE valid_to IS NULL;
+
+				-- Existing patients start with their current data, valid from their registration
+				INSERT INTO patient_versions (patient_id, version, data, valid_from, change_type, changed_by)
+				SELECT p.id, 1, to_jsonb(p) - 'search_vector', COALESCE(p.created_at, CURRENT_TIMESTAMP), 'migrated', p.created_by
+				FROM patients p
+				WHERE NOT EXISTS (SELECT 1 FROM patient_versions v WHERE v.patient_id = p.id);
+
+				-- Close the current version and record the new row, unless only the update
+				-- timestamp or author changed
+				CREATE OR REPLACE FUNCTION record_patient_version()
+				RETURNS TRIGGER AS $$
+				DECLARE
+					v_data JSONB := to_jsonb(NEW) - 'search_vector';
+					v_changed TEXT[];
+					v_type TEXT := 'created';
+					v_at TIMESTAMP := clock_timestamp();
+				BEGIN
+					IF TG_OP = 'UPDATE' THEN
+						SELECT array_agg(n.key ORDER BY n.key) INTO v_changed
+						FROM jsonb_each(v_data) n
+						WHERE n.key NOT IN ('updated_at', 'updated_by') AND n.value IS DISTINCT FROM to_jsonb(OLD)->n.key;
+						IF v_changed IS NULL THEN
+							RETURN NULL;
+						END IF;
+						v_type := CASE
+							WHEN NEW.anonymised_at IS NOT NULL AND OLD.anonymised_at IS NULL THEN 'anonymised'
+							WHEN OLD.is_active AND NOT NEW.is_active THEN 'deactivated'
+							ELSE 'updated'
+						END;
+						UPDATE patient_versions SET valid_to = v_at WHERE patient_id = NEW.id AND valid_to IS NULL;
+					END IF;
+
+					INSERT INTO patient_versions (patient_id, version, data, valid_from, change_type, changed_fields, changed_by)
+					SELECT NEW.id, COALESCE(MAX(version), 0) + 1, v_data, v_at, v_type, COALESCE(v_changed, '{}'),
+						CASE WHEN TG_OP = 'INSERT' THEN NEW.created_by ELSE NEW.updated_by END
+					FROM patient_versions WHERE patient_id = NEW.id;
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				DROP TRIGGER IF EXISTS record_patient_version ON patients;
+				CREATE TRIGGER record_patient_version
+					AFTER INSERT OR UPDATE ON patients
+					FOR EACH ROW EXECUTE FUNCTION record_patient_version();
+
+				-- Move a patient and its dependent rows into patient_archive. Tables referencing
+				-- patients must be listed here and in restore_patient.
+				CREATE OR REPLACE FUNCTION archive_patient(p_patient_id INTEGER, p_last_activity TIMESTAMP, p_run_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_patient patients%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_patient FROM patients WHERE id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patient_archive (patient_id, healthcare_entity_id, mrn, country_id, last_activity_at, retention_run_id, patient, records)
+					VALUES (v_patient.id, v_patient.healthcare_entity_id, v_patient.mrn, v_patient.country_id, p_last_activity, p_run_id,
+						to_jsonb(v_patient) - 'search_vector',
+						jsonb_build_object(
+							'patient_consents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_consents t WHERE t.patient_id = p_patient_id),
+							'patient_allergies', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_allergies t WHERE t.patient_id = p_patient_id),
+							'patient_medications', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_medications t WHERE t.patient_id = p_patient_id),
+							'patient_problems', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_problems t WHERE t.patient_id = p_patient_id),
+							'patient_clinical_history', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_clinical_history t WHERE t.patient_id = p_patient_id),
+							'patient_documents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_documents t WHERE t.patient_id = p_patient_id),
+							'document_access_log', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM document_access_log t WHERE t.patient_id = p_patient_id),
+							'patient_relationships', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_relationships t WHERE t.patient_id = p_patient_id OR t.related_patient_id = p_patient_id),
+							'patient_legal_holds', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_legal_holds t WHERE t.patient_id = p_patient_id),
+							'data_subject_requests', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM data_subject_requests t WHERE t.patient_id = p_patient_id),
+							'patient_vitals', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_vitals t WHERE t.patient_id = p_patient_id),
+							'patient_versions', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_versions t WHERE t.patient_id = p_patient_id)
+						));
+
+					-- data_subject_requests does not cascade; everything else goes with the patient row
+					DELETE FROM data_subject_requests WHERE patient_id = p_patient_id;
+					DELETE FROM patients WHERE id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				-- Move an archived patient back into the hot tables with its original IDs. Relationships
+				-- with patients that are no longer in the hot tables are dropped.
+				CREATE OR REPLACE FUNCTION restore_patient(p_patient_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_archive patient_archive%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_archive FROM patient_archive WHERE patient_id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'archived patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patients SELECT * FROM jsonb_populate_record(NULL::patients, v_archive.patient);
+					INSERT INTO patient_consents SELECT * FROM jsonb_populate_recordset(NULL::patient_consents, v_archive.records->'patient_consents');
+					INSERT INTO patient_allergies SELECT * FROM jsonb_populate_recordset(NULL::patient_allergies, v_archive.records->'patient_allergies');
+					INSERT INTO patient_medications SELECT * FROM jsonb_populate_recordset(NULL::patient_medications, v_archive.records->'patient_medications');
+					INSERT INTO patient_problems SELECT * FROM jsonb_populate_recordset(NULL::patient_problems, v_archive.records->'patient_problems');
+					INSERT INTO patient_clinical_history SELECT * FROM jsonb_populate_recordset(NULL::patient_clinical_history, v_archive.records->'patient_clinical_history');
+					INSERT INTO patient_documents SELECT * FROM jsonb_populate_recordset(NULL::patient_documents, v_archive.records->'patient_documents');
+					INSERT INTO document_access_log SELECT * FROM jsonb_populate_recordset(NULL::document_access_log, v_archive.records->'document_access_log');
+					INSERT INTO patient_relationships
+						SELECT r.* FROM jsonb_populate_recordset(NULL::patient_relationships, v_archive.records->'patient_relationships') r
+						WHERE EXISTS (SELECT 1 FROM patients WHERE id = r.patient_id)
+						  AND EXISTS (SELECT 1 FROM patients WHERE id = r.related_patient_id);
+					INSERT INTO patient_legal_holds SELECT * FROM jsonb_populate_recordset(NULL::patient_legal_holds, v_archive.records->'patient_legal_holds');
+					INSERT INTO data_subject_requests SELECT * FROM jsonb_populate_recordset(NULL::data_subject_requests, v_archive.records->'data_subject_requests');
+					-- Patients archived before vital signs existed have no patient_vitals entry
+					INSERT INTO patient_vitals SELECT * FROM jsonb_populate_recordset(NULL::patient_vitals, COALESCE(v_archive.records->'patient_vitals', '[]'));
+					-- Inserting the patient row recorded a new version; the archived versions replace it.
+					-- Patients archived before versioning keep it as their migrated version.
+					IF v_archive.records ? 'patient_versions' THEN
+						DELETE FROM patient_versions WHERE patient_id = p_patient_id;
+						INSERT INTO patient_versions SELECT * FROM jsonb_populate_recordset(NULL::patient_versions, v_archive.records->'patient_versions');
+					ELSE
+						UPDATE patient_versions SET change_type = 'migrated', valid_from = COALESCE((v_archive.patient->>'created_at')::TIMESTAMP, valid_from)
+						WHERE patient_id = p_patient_id;
+					END IF;
+
+					DELETE FROM patient_archive WHERE patient_id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+			`,
+			Down: `
+				DROP TRIGGER IF EXISTS record_patient_version ON patients;
+				DROP FUNCTION IF EXISTS record_patient_version();
+
+				-- Move a patient and its dependent rows into patient_archive. Tables referencing
+				-- patients must be listed here and in restore_patient.
+				CREATE OR REPLACE FUNCTION archive_patient(p_patient_id INTEGER, p_last_activity TIMESTAMP, p_run_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_patient patients%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_patient FROM patients WHERE id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patient_archive (patient_id, healthcare_entity_id, mrn, country_id, last_activity_at, retention_run_id, patient, records)
+					VALUES (v_patient.id, v_patient.healthcare_entity_id, v_patient.mrn, v_patient.country_id, p_last_activity, p_run_id,
+						to_jsonb(v_patient) - 'search_vector',
+						jsonb_build_object(
+							'patient_consents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_consents t WHERE t.patient_id = p_patient_id),
+							'patient_allergies', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_allergies t WHERE t.patient_id = p_patient_id),
+							'patient_medications', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_medications t WHERE t.patient_id = p_patient_id),
+							'patient_problems', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_problems t WHERE t.patient_id = p_patient_id),
+							'patient_clinical_history', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_clinical_history t WHERE t.patient_id = p_patient_id),
+							'patient_documents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_documents t WHERE t.patient_id = p_patient_id),
+							'document_access_log', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM document_access_log t WHERE t.patient_id = p_patient_id),
+							'patient_relationships', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_relationships t WHERE t.patient_id = p_patient_id OR t.related_patient_id = p_patient_id),
+							'patient_legal_holds', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_legal_holds t WHERE t.patient_id = p_patient_id),
+							'data_subject_requests', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM data_subject_requests t WHERE t.patient_id = p_patient_id),
+							'patient_vitals', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_vitals t WHERE t.patient_id = p_patient_id)
+						));
+
+					-- data_subject_requests does not cascade; everything else goes with the patient row
+					DELETE FROM data_subject_requests WHERE patient_id = p_patient_id;
+					DELETE FROM patients WHERE id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				-- Move an archived patient back into the hot tables with its original IDs. Relationships
+				-- with patients that are no longer in the hot tables are dropped.
+				CREATE OR REPLACE FUNCTION restore_patient(p_patient_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_archive patient_archive%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_archive FROM patient_archive WHERE patient_id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'archived patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patients SELECT * FROM jsonb_populate_record(NULL::patients, v_archive.patient);
+					INSERT INTO patient_consents SELECT * FROM jsonb_populate_recordset(NULL::patient_consents, v_archive.records->'patient_consents');
+					INSERT INTO patient_allergies SELECT * FROM jsonb_populate_recordset(NULL::patient_allergies, v_archive.records->'patient_allergies');
+					INSERT INTO patient_medications SELECT * FROM jsonb_populate_recordset(NULL::patient_medications, v_archive.records->'patient_medications');
+					INSERT INTO patient_problems SELECT * FROM jsonb_populate_recordset(NULL::patient_problems, v_archive.records->'patient_problems');
+					INSERT INTO patient_clinical_history SELECT * FROM jsonb_populate_recordset(NULL::patient_clinical_history, v_archive.records->'patient_clinical_history');
+					INSERT INTO patient_documents SELECT * FROM jsonb_populate_recordset(NULL::patient_documents, v_archive.records->'patient_documents');
+					INSERT INTO document_access_log SELECT * FROM jsonb_populate_recordset(NULL::document_access_log, v_archive.records->'document_access_log');
+					INSERT INTO patient_relationships
+						SELECT r.* FROM jsonb_populate_recordset(NULL::patient_relationships, v_archive.records->'patient_relationships') r
+						WHERE EXISTS (SELECT 1 FROM patients WHERE id = r.patient_id)
+						  AND EXISTS (SELECT 1 FROM patients WHERE id = r.related_patient_id);
+					INSERT INTO patient_legal_holds SELECT * FROM jsonb_populate_recordset(NULL::patient_legal_holds, v_archive.records->'patient_legal_holds');
+					INSERT INTO data_subject_requests SELECT * FROM jsonb_populate_recordset(NULL::data_subject_requests, v_archive.records->'data_subject_requests');
+					-- Patients archived before vital signs existed have no patient_vitals entry
+					INSERT INTO patient_vitals SELECT * FROM jsonb_populate_recordset(NULL::patient_vitals, COALESCE(v_archive.records->'patient_vitals', '[]'));
+
+					DELETE FROM patient_archive WHERE patient_id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				DROP TABLE IF EXISTS patient_versions;
+				ALTER TABLE patients DROP COLUMN IF EXISTS updated_by;
+			`,
+		},
+		{
+			Version:     23,
+			Description: "Create patient insurance coverage and eligibility check tables",
+			Up: `
+				-- Insurance coverages of a patient. Coverages of the same rank (1 = primary,
+				-- 2 = secondary, ...) must not have overlapping effective windows; this is checked
+				-- by the service under the patient row lock. effective_to is inclusive.
+				CREATE TABLE IF NOT EXISTS patient_coverages (
+					id SERIAL PRIMARY KEY,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					healthcare_entity_id INTEGER NOT NULL,
+					rank SMALLINT NOT NULL CHECK (rank BETWEEN 1 AND 9),
+					insurance_type_id INTEGER NOT NULL, -- Location service reference
+					insurance_provider_id INTEGER NOT NULL, -- Location service reference
+					policy_number VARCHAR(100) NOT NULL DEFAULT '',
+					member_id VARCHAR(100) NOT NULL DEFAULT '',
+					group_number VARCHAR(100) NOT NULL DEFAULT '',
+					subscriber_relationship VARCHAR(20) NOT NULL DEFAULT 'self' CHECK (subscriber_relationship IN ('self', 'spouse', 'child', 'other')),
+					subscriber_name VARCHAR(200) NOT NULL DEFAULT '', -- Policy holder when not the patient
+					subscriber_date_of_birth DATE,
+					effective_from DATE NOT NULL,
+					effective_to DATE,
+					annual_limit NUMERIC(12,2) CHECK (annual_limit >= 0),
+					deductible NUMERIC(12,2) CHECK (deductible >= 0),
+					copay NUMERIC(12,2) CHECK (copay >= 0),
+					coinsurance_percent NUMERIC(5,2) CHECK (coinsurance_percent BETWEEN 0 AND 100),
+					currency VARCHAR(3) NOT NULL DEFAULT '',
+					notes TEXT NOT NULL DEFAULT '',
+					created_by INTEGER,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					updated_by INTEGER,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					deleted_at TIMESTAMP,
+					deleted_by INTEGER,
+					CHECK (effective_to IS NULL OR effective_to >= effective_from)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_patient_coverages_patient ON patient_coverages(patient_id, rank, effective_from) WHERE deleted_at IS NULL;
+
+				-- Results of eligibility checks against a coverage, kept as an audit trail
+				CREATE TABLE IF NOT EXISTS coverage_eligibility_checks (
+					id SERIAL PRIMARY KEY,
+					coverage_id INTEGER NOT NULL REFERENCES patient_coverages(id) ON DELETE CASCADE,
+					patient_id INTEGER NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+					checker VARCHAR(50) NOT NULL,
+					status VARCHAR(20) NOT NULL CHECK (status IN ('eligible', 'ineligible', 'error')),
+					service_date DATE NOT NULL,
+					service_type VARCHAR(50) NOT NULL DEFAULT '',
+					message TEXT NOT NULL DEFAULT '',
+					details JSONB NOT NULL DEFAULT '{}',
+					checked_by INTEGER,
+					checked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_coverage_eligibility_checks_coverage ON coverage_eligibility_checks(coverage_id, checked_at);
+
+				-- Existing patients get their insurance as a primary coverage of their own, effective
+				-- from their registration
+				INSERT INTO patient_coverages (patient_id, healthcare_entity_id, rank, insurance_type_id, insurance_provider_id,
+					policy_number, member_id, effective_from, created_by, created_at)
+				SELECT p.id, p.healthcare_entity_id, 1, p.insurance_type_id, p.insurance_provider_id,
+					COALESCE(p.policy_number, ''), COALESCE(p.policy_number, ''), COALESCE(p.created_at, CURRENT_TIMESTAMP)::DATE, p.created_by, CURRENT_TIMESTAMP
+				FROM patients p
+				WHERE p.insurance_type_id IS NOT NULL AND p.insurance_provider_id IS NOT NULL AND p.anonymised_at IS NULL
+				  AND NOT EXISTS (SELECT 1 FROM patient_coverages c WHERE c.patient_id = p.id);
+
+				-- Move a patient and its dependent rows into patient_archive. Tables referencing
+				-- patients must be listed here and in restore_patient.
+				CREATE OR REPLACE FUNCTION archive_patient(p_patient_id INTEGER, p_last_activity TIMESTAMP, p_run_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_patient patients%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_patient FROM patients WHERE id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patient_archive (patient_id, healthcare_entity_id, mrn, country_id, last_activity_at, retention_run_id, patient, records)
+					VALUES (v_patient.id, v_patient.healthcare_entity_id, v_patient.mrn, v_patient.country_id, p_last_activity, p_run_id,
+						to_jsonb(v_patient) - 'search_vector',
+						jsonb_build_object(
+							'patient_consents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_consents t WHERE t.patient_id = p_patient_id),
+							'patient_allergies', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_allergies t WHERE t.patient_id = p_patient_id),
+							'patient_medications', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_medications t WHERE t.patient_id = p_patient_id),
+							'patient_problems', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_problems t WHERE t.patient_id = p_patient_id),
+							'patient_clinical_history', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_clinical_history t WHERE t.patient_id = p_patient_id),
+							'patient_documents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_documents t WHERE t.patient_id = p_patient_id),
+							'document_access_log', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM document_access_log t WHERE t.patient_id = p_patient_id),
+							'patient_relationships', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_relationships t WHERE t.patient_id = p_patient_id OR t.related_patient_id = p_patient_id),
+							'patient_legal_holds', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_legal_holds t WHERE t.patient_id = p_patient_id),
+							'data_subject_requests', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM data_subject_requests t WHERE t.patient_id = p_patient_id),
+							'patient_vitals', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_vitals t WHERE t.patient_id = p_patient_id),
+							'patient_versions', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_versions t WHERE t.patient_id = p_patient_id),
+							'patient_coverages', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_coverages t WHERE t.patient_id = p_patient_id),
+							'coverage_eligibility_checks', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM coverage_eligibility_checks t WHERE t.patient_id = p_patient_id)
+						));
+
+					-- data_subject_requests does not cascade; everything else goes with the patient row
+					DELETE FROM data_subject_requests WHERE patient_id = p_patient_id;
+					DELETE FROM patients WHERE id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				-- Move an archived patient back into the hot tables with its original IDs. Relationships
+				-- with patients that are no longer in the hot tables are dropped.
+				CREATE OR REPLACE FUNCTION restore_patient(p_patient_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_archive patient_archive%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_archive FROM patient_archive WHERE patient_id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'archived patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patients SELECT * FROM jsonb_populate_record(NULL::patients, v_archive.patient);
+					INSERT INTO patient_consents SELECT * FROM jsonb_populate_recordset(NULL::patient_consents, v_archive.records->'patient_consents');
+					INSERT INTO patient_allergies SELECT * FROM jsonb_populate_recordset(NULL::patient_allergies, v_archive.records->'patient_allergies');
+					INSERT INTO patient_medications SELECT * FROM jsonb_populate_recordset(NULL::patient_medications, v_archive.records->'patient_medications');
+					INSERT INTO patient_problems SELECT * FROM jsonb_populate_recordset(NULL::patient_problems, v_archive.records->'patient_problems');
+					INSERT INTO patient_clinical_history SELECT * FROM jsonb_populate_recordset(NULL::patient_clinical_history, v_archive.records->'patient_clinical_history');
+					INSERT INTO patient_documents SELECT * FROM jsonb_populate_recordset(NULL::patient_documents, v_archive.records->'patient_documents');
+					INSERT INTO document_access_log SELECT * FROM jsonb_populate_recordset(NULL::document_access_log, v_archive.records->'document_access_log');
+					INSERT INTO patient_relationships
+						SELECT r.* FROM jsonb_populate_recordset(NULL::patient_relationships, v_archive.records->'patient_relationships') r
+						WHERE EXISTS (SELECT 1 FROM patients WHERE id = r.patient_id)
+						  AND EXISTS (SELECT 1 FROM patients WHERE id = r.related_patient_id);
+					INSERT INTO patient_legal_holds SELECT * FROM jsonb_populate_recordset(NULL::patient_legal_holds, v_archive.records->'patient_legal_holds');
+					INSERT INTO data_subject_requests SELECT * FROM jsonb_populate_recordset(NULL::data_subject_requests, v_archive.records->'data_subject_requests');
+					-- Patients archived before vital signs existed have no patient_vitals entry
+					INSERT INTO patient_vitals SELECT * FROM jsonb_populate_recordset(NULL::patient_vitals, COALESCE(v_archive.records->'patient_vitals', '[]'));
+					-- Inserting the patient row recorded a new version; the archived versions replace it.
+					-- Patients archived before versioning keep it as their migrated version.
+					IF v_archive.records ? 'patient_versions' THEN
+						DELETE FROM patient_versions WHERE patient_id = p_patient_id;
+						INSERT INTO patient_versions SELECT * FROM jsonb_populate_recordset(NULL::patient_versions, v_archive.records->'patient_versions');
+					ELSE
+						UPDATE patient_versions SET change_type = 'migrated', valid_from = COALESCE((v_archive.patient->>'created_at')::TIMESTAMP, valid_from)
+						WHERE patient_id = p_patient_id;
+					END IF;
+					-- Patients archived before coverages existed have no patient_coverages entry
+					INSERT INTO patient_coverages SELECT * FROM jsonb_populate_recordset(NULL::patient_coverages, COALESCE(v_archive.records->'patient_coverages', '[]'));
+					INSERT INTO coverage_eligibility_checks SELECT * FROM jsonb_populate_recordset(NULL::coverage_eligibility_checks, COALESCE(v_archive.records->'coverage_eligibility_checks', '[]'));
+
+					DELETE FROM patient_archive WHERE patient_id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+			`,
+			Down: `
+				-- Move a patient and its dependent rows into patient_archive. Tables referencing
+				-- patients must be listed here and in restore_patient.
+				CREATE OR REPLACE FUNCTION archive_patient(p_patient_id INTEGER, p_last_activity TIMESTAMP, p_run_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_patient patients%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_patient FROM patients WHERE id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patient_archive (patient_id, healthcare_entity_id, mrn, country_id, last_activity_at, retention_run_id, patient, records)
+					VALUES (v_patient.id, v_patient.healthcare_entity_id, v_patient.mrn, v_patient.country_id, p_last_activity, p_run_id,
+						to_jsonb(v_patient) - 'search_vector',
+						jsonb_build_object(
+							'patient_consents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_consents t WHERE t.patient_id = p_patient_id),
+							'patient_allergies', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_allergies t WHERE t.patient_id = p_patient_id),
+							'patient_medications', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_medications t WHERE t.patient_id = p_patient_id),
+							'patient_problems', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_problems t WHERE t.patient_id = p_patient_id),
+							'patient_clinical_history', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_clinical_history t WHERE t.patient_id = p_patient_id),
+							'patient_documents', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_documents t WHERE t.patient_id = p_patient_id),
+							'document_access_log', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM document_access_log t WHERE t.patient_id = p_patient_id),
+							'patient_relationships', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_relationships t WHERE t.patient_id = p_patient_id OR t.related_patient_id = p_patient_id),
+							'patient_legal_holds', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_legal_holds t WHERE t.patient_id = p_patient_id),
+							'data_subject_requests', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM data_subject_requests t WHERE t.patient_id = p_patient_id),
+							'patient_vitals', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_vitals t WHERE t.patient_id = p_patient_id),
+							'patient_versions', (SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.id), '[]') FROM patient_versions t WHERE t.patient_id = p_patient_id)
+						));
+
+					-- data_subject_requests does not cascade; everything else goes with the patient row
+					DELETE FROM data_subject_requests WHERE patient_id = p_patient_id;
+					DELETE FROM patients WHERE id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				-- Move an archived patient back into the hot tables with its original IDs. Relationships
+				-- with patients that are no longer in the hot tables are dropped.
+				CREATE OR REPLACE FUNCTION restore_patient(p_patient_id INTEGER)
+				RETURNS VOID AS $$
+				DECLARE
+					v_archive patient_archive%ROWTYPE;
+				BEGIN
+					SELECT * INTO v_archive FROM patient_archive WHERE patient_id = p_patient_id FOR UPDATE;
+					IF NOT FOUND THEN
+						RAISE EXCEPTION 'archived patient % not found', p_patient_id;
+					END IF;
+
+					INSERT INTO patients SELECT * FROM jsonb_populate_record(NULL::patients, v_archive.patient);
+					INSERT INTO patient_consents SELECT * FROM jsonb_populate_recordset(NULL::patient_consents, v_archive.records->'patient_consents');
+					INSERT INTO patient_allergies SELECT * FROM jsonb_populate_recordset(NULL::patient_allergies, v_archive.records->'patient_allergies');
+					INSERT INTO patient_medications SELECT * FROM jsonb_populate_recordset(NULL::patient_medications, v_archive.records->'patient_medications');
+					INSERT INTO patient_problems SELECT * FROM jsonb_populate_recordset(NULL::patient_problems, v_archive.records->'patient_problems');
+					INSERT INTO patient_clinical_history SELECT * FROM jsonb_populate_recordset(NULL::patient_clinical_history, v_archive.records->'patient_clinical_history');
+					INSERT INTO patient_documents SELECT * FROM jsonb_populate_recordset(NULL::patient_documents, v_archive.records->'patient_documents');
+					INSERT INTO document_access_log SELECT * FROM jsonb_populate_recordset(NULL::document_access_log, v_archive.records->'document_access_log');
+					INSERT INTO patient_relationships
+						SELECT r.* FROM jsonb_populate_recordset(NULL::patient_relationships, v_archive.records->'patient_relationships') r
+						WHERE EXISTS (SELECT 1 FROM patients WHERE id = r.patient_id)
+						  AND EXISTS (SELECT 1 FROM patients WHERE id = r.related_patient_id);
+					INSERT INTO patient_legal_holds SELECT * FROM jsonb_populate_recordset(NULL::patient_legal_holds, v_archive.records->'patient_legal_holds');
+					INSERT INTO data_subject_requests SELECT * FROM jsonb_populate_recordset(NULL::data_subject_requests, v_archive.records->'data_subject_requests');
+					-- Patients archived before vital signs existed have no patient_vitals entry
+					INSERT INTO patient_vitals SELECT * FROM jsonb_populate_recordset(NULL::patient_vitals, COALESCE(v_archive.records->'patient_vitals', '[]'));
+					-- Inserting the patient row recorded a new version; the archived versions replace it.
+					-- Patients archived before versioning keep it as their migrated version.
+					IF v_archive.records ? 'patient_versions' THEN
+						DELETE FROM patient_versions WHERE patient_id = p_patient_id;
+						INSERT INTO patient_versions SELECT * FROM jsonb_populate_recordset(NULL::patient_versions, v_archive.records->'patient_versions');
+					ELSE
+						UPDATE patient_versions SET change_type = 'migrated', valid_from = COALESCE((v_archive.patient->>'created_at')::TIMESTAMP, valid_from)
+						WHERE patient_id = p_patient_id;
+					END IF;
+
+					DELETE FROM patient_archive WHERE patient_id = p_patient_id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				DROP TABLE IF EXISTS coverage_eligibility_checks;
+				DROP TABLE IF EXISTS patient_coverages;
+			`,
+		},
+		{
+			Version:     24,
+			Description: "Record system actors in the document access log",
+			Up: `
+				-- NULL when a user (user_id) acted, the name of the system job otherwise. Nullable so
+				-- that archived log rows without it can still be restored.
+				ALTER TABLE document_access_log ADD COLUMN IF NOT EXISTS actor VARCHAR(50);
+
+				-- Rows written by scheduled retention runs so far
+				UPDATE document_access_log SET actor = 'retention-job'
+				WHERE user_id = 0 AND user_agent = 'retention-job' AND actor IS NULL;
+			`,
+			Down: `
+				ALTER TABLE document_access_log DROP COLUMN IF EXISTS actor;
+			`,
+		},
 	}
 }
 
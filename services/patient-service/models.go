@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -9,6 +10,7 @@ type Patient struct {
 	ID                   int       `json:"id" db:"id"`
 	HealthcareEntityID   int       `json:"healthcare_entity_id" db:"healthcare_entity_id" validate:"required"`
 	PatientID            string    `json:"patient_id" db:"patient_id"` // Custom patient identifier
+	MRN                  string    `json:"mrn" db:"mrn"`               // Generated medical record number, unique per entity
 	FirstName            string    `json:"first_name" db:"first_name" validate:"required"`
 	LastName             string    `json:"last_name" db:"last_name" validate:"required"`
 	DateOfBirth          time.Time `json:"date_of_birth" db:"date_of_birth" validate:"required"`
@@ -31,9 +33,9 @@ type Patient struct {
 	EmergencyContactName string    `json:"emergency_contact_name" db:"emergency_contact_name"`
 	EmergencyContactPhone string   `json:"emergency_contact_phone" db:"emergency_contact_phone"`
 	EmergencyContactRelationship string `json:"emergency_contact_relationship" db:"emergency_contact_relationship"`
-	MedicalHistory       string    `json:"medical_history" db:"medical_history"`
-	Allergies            string    `json:"allergies" db:"allergies"`
-	Medications          string    `json:"medications" db:"medications"`
+	MedicalHistory       string    `json:"medical_history" db:"-"` // Computed summary of the problem list
+	Allergies            string    `json:"allergies" db:"-"`       // Computed summary of active allergies
+	Medications          string    `json:"medications" db:"-"`     // Computed summary of active medications
 	BloodType            string    `json:"blood_type" db:"blood_type" validate:"oneof=A+ A- B+ B- AB+ AB- O+ O-"`
 	IsActive             bool      `json:"is_active" db:"is_active"`
 	CreatedAt            time.Time `json:"created_at" db:"created_at"`
@@ -66,10 +68,11 @@ type PatientRequest struct {
 	EmergencyContactName string    `json:"emergency_contact_name"`
 	EmergencyContactPhone string   `json:"emergency_contact_phone"`
 	EmergencyContactRelationship string `json:"emergency_contact_relationship"`
-	MedicalHistory       string    `json:"medical_history"`
-	Allergies            string    `json:"allergies"`
-	Medications          string    `json:"medications"`
+	MedicalHistory       string    `json:"medical_history"` // Seeds the problem list on creation, read-only on update
+	Allergies            string    `json:"allergies"`       // Seeds allergies on creation, read-only on update
+	Medications          string    `json:"medications"`     // Seeds medications on creation, read-only on update
 	BloodType            string    `json:"blood_type" validate:"omitempty,oneof=A+ A- B+ B- AB+ AB- O+ O-"`
+	Guardian             *GuardianLinkRequest `json:"guardian,omitempty"` // Required on creation when the patient is a minor
 }
 
 // PatientSearchRequest represents search parameters
@@ -77,8 +80,10 @@ type PatientSearchRequest struct {
 	HealthcareEntityID int    `form:"healthcare_entity_id"`
 	Query             string `form:"q"`
 	Gender            string `form:"gender"`
-	Country           string `form:"country"`
-	State             string `form:"state"`
+	CountryID         *int   `form:"country_id"` // Location service country ID
+	StateID           *int   `form:"state_id"`   // Location service state ID
+	Country           *int   `form:"country"`    // Deprecated alias of country_id, removed in the next release
+	State             *int   `form:"state"`      // Deprecated alias of state_id, removed in the next release
 	InsuranceTypeID   *int   `form:"insurance_type_id"`
 	BloodType         string `form:"blood_type"`
 	PreferredLanguage string `form:"preferred_language"`
@@ -86,6 +91,15 @@ type PatientSearchRequest struct {
 	AgeMax            int    `form:"age_max"`
 	Limit             int    `form:"limit"`
 	Offset            int    `form:"offset"`
+	Cursor            string `form:"cursor"` // Opaque keyset cursor from a previous page (takes precedence over offset)
+}
+
+// PatientSearchResult represents one page of search results
+type PatientSearchResult struct {
+	Patients   []Patient
+	TotalCount int
+	HasMore    bool
+	NextCursor string
 }
 
 // PatientResponse represents patient data returned to client
@@ -93,6 +107,7 @@ type PatientResponse struct {
 	ID                   int       `json:"id"`
 	HealthcareEntityID   int       `json:"healthcare_entity_id"`
 	PatientID            string    `json:"patient_id"`
+	MRN                  string    `json:"mrn"`
 	FirstName            string    `json:"first_name"`
 	LastName             string    `json:"last_name"`
 	DateOfBirth          time.Time `json:"date_of_birth"`
@@ -130,6 +145,7 @@ func (p *Patient) ToPatientResponse() PatientResponse {
 		ID:                 p.ID,
 		HealthcareEntityID: p.HealthcareEntityID,
 		PatientID:          p.PatientID,
+		MRN:                p.MRN,
 		FirstName:          p.FirstName,
 		LastName:           p.LastName,
 		DateOfBirth:        p.DateOfBirth,
@@ -173,4 +189,805 @@ func calculateAge(birthDate time.Time) int {
 	}
 	
 	return age
-}
\ No newline at end of file
+}
+// ConsentTypes are the supported consent types. The consent_type CHECK constraints of
+// consent_documents and patient_consents list the same values.
+var ConsentTypes = []string{"data_sharing", "sms_contact", "email_contact", "research", "photo"}
+
+// ConsentDocument is a versioned consent text an entity presents to patients
+type ConsentDocument struct {
+	ID                 int       `json:"id" db:"id"`
+	HealthcareEntityID int       `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	ConsentType        string    `json:"consent_type" db:"consent_type"`
+	Version            int       `json:"version" db:"version"`
+	Title              string    `json:"title" db:"title"`
+	Content            string    `json:"content" db:"content"`
+	Language           string    `json:"language" db:"language"`
+	EffectiveFrom      time.Time `json:"effective_from" db:"effective_from"`
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	CreatedBy          int       `json:"created_by" db:"created_by"`
+}
+
+// ConsentDocumentRequest represents a new consent document version
+type ConsentDocumentRequest struct {
+	ConsentType   string `json:"consent_type" validate:"required,consent_type"`
+	Title         string `json:"title" validate:"required"`
+	Content       string `json:"content" validate:"required"`
+	Language      string `json:"language"`
+	EffectiveFrom string `json:"effective_from"` // RFC3339, defaults to now
+}
+
+// PatientConsent records a consent granted by a patient and its revocation, if any
+type PatientConsent struct {
+	ID                 int        `json:"id" db:"id"`
+	PatientID          int        `json:"patient_id" db:"patient_id"`
+	HealthcareEntityID int        `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	ConsentType        string     `json:"consent_type" db:"consent_type"`
+	DocumentID         int        `json:"document_id" db:"document_id"`
+	DocumentVersion    int        `json:"document_version" db:"-"` // Joined from consent_documents
+	DocumentTitle      string     `json:"document_title" db:"-"`   // Joined from consent_documents
+	Status             string     `json:"status" db:"-"`           // granted or revoked, derived from revoked_at
+	GrantedAt          time.Time  `json:"granted_at" db:"granted_at"`
+	GrantedBy          int        `json:"granted_by" db:"granted_by"`
+	GrantChannel       string     `json:"grant_channel" db:"grant_channel"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	RevokedBy          *int       `json:"revoked_by,omitempty" db:"revoked_by"`
+	RevokeChannel      *string    `json:"revoke_channel,omitempty" db:"revoke_channel"`
+	RevocationReason   *string    `json:"revocation_reason,omitempty" db:"revocation_reason"`
+}
+
+// ConsentGrantRequest represents a patient granting a consent
+type ConsentGrantRequest struct {
+	ConsentType string `json:"consent_type" validate:"required,consent_type"`
+	DocumentID  *int   `json:"document_id,omitempty"` // Defaults to the latest document version for the type
+	Channel     string `json:"channel" validate:"required,oneof=in_person paper phone email sms portal"`
+	GrantedAt   string `json:"granted_at"` // RFC3339, defaults to now (for back-dated paper forms)
+}
+
+// ConsentRevokeRequest represents a patient revoking a consent
+type ConsentRevokeRequest struct {
+	Channel string `json:"channel" validate:"required,oneof=in_person paper phone email sms portal"`
+	Reason  string `json:"reason"`
+}
+
+// ConsentCheckResponse answers whether a patient currently consents to a given use
+type ConsentCheckResponse struct {
+	PatientID       int        `json:"patient_id"`
+	ConsentType     string     `json:"consent_type"`
+	Granted         bool       `json:"granted"`
+	ConsentID       *int       `json:"consent_id,omitempty"`
+	DocumentVersion *int       `json:"document_version,omitempty"`
+	GrantedAt       *time.Time `json:"granted_at,omitempty"`
+	CheckedAt       time.Time  `json:"checked_at"`
+}
+
+// PatientAllergy is a structured allergy or intolerance of a patient
+type PatientAllergy struct {
+	ID        int       `json:"id" db:"id"`
+	PatientID int       `json:"patient_id" db:"patient_id"`
+	Substance string    `json:"substance" db:"substance"`
+	Reaction  string    `json:"reaction" db:"reaction"`
+	Severity  string    `json:"severity" db:"severity"`
+	Status    string    `json:"status" db:"status"`
+	Notes     string    `json:"notes" db:"notes"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	CreatedBy int       `json:"created_by" db:"created_by"`
+	UpdatedBy int       `json:"updated_by" db:"updated_by"`
+}
+
+// AllergyRequest represents allergy creation/update request
+type AllergyRequest struct {
+	Substance string `json:"substance" validate:"required,max=255"`
+	Reaction  string `json:"reaction" validate:"max=255"`
+	Severity  string `json:"severity" validate:"omitempty,oneof=mild moderate severe life_threatening"`
+	Status    string `json:"status" validate:"omitempty,oneof=active inactive resolved entered_in_error"` // Defaults to active
+	Notes     string `json:"notes"`
+}
+
+// PatientMedication is a structured medication entry of a patient
+type PatientMedication struct {
+	ID        int        `json:"id" db:"id"`
+	PatientID int        `json:"patient_id" db:"patient_id"`
+	Name      string     `json:"name" db:"name"`
+	Dose      string     `json:"dose" db:"dose"`
+	Route     string     `json:"route" db:"route"`
+	Frequency string     `json:"frequency" db:"frequency"`
+	StartDate *time.Time `json:"start_date,omitempty" db:"start_date"`
+	StopDate  *time.Time `json:"stop_date,omitempty" db:"stop_date"`
+	Status    string     `json:"status" db:"status"`
+	Notes     string     `json:"notes" db:"notes"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	CreatedBy int        `json:"created_by" db:"created_by"`
+	UpdatedBy int        `json:"updated_by" db:"updated_by"`
+}
+
+// MedicationRequest represents medication creation/update request
+type MedicationRequest struct {
+	Name      string `json:"name" validate:"required,max=255"`
+	Dose      string `json:"dose" validate:"max=100"`
+	Route     string `json:"route" validate:"max=50"`
+	Frequency string `json:"frequency" validate:"max=100"`
+	StartDate string `json:"start_date"` // YYYY-MM-DD
+	StopDate  string `json:"stop_date"`  // YYYY-MM-DD
+	Status    string `json:"status" validate:"omitempty,oneof=active on_hold stopped completed entered_in_error"` // Defaults to active
+	Notes     string `json:"notes"`
+}
+
+// PatientProblem is an entry of a patient's problem list
+type PatientProblem struct {
+	ID           int        `json:"id" db:"id"`
+	PatientID    int        `json:"patient_id" db:"patient_id"`
+	Code         string     `json:"code" db:"code"`               // e.g. ICD-10 code
+	CodeSystem   string     `json:"code_system" db:"code_system"` // e.g. ICD-10
+	Description  string     `json:"description" db:"description"`
+	OnsetDate    *time.Time `json:"onset_date,omitempty" db:"onset_date"`
+	ResolvedDate *time.Time `json:"resolved_date,omitempty" db:"resolved_date"`
+	Status       string     `json:"status" db:"status"`
+	Notes        string     `json:"notes" db:"notes"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	CreatedBy    int        `json:"created_by" db:"created_by"`
+	UpdatedBy    int        `json:"updated_by" db:"updated_by"`
+}
+
+// ProblemRequest represents problem creation/update request
+type ProblemRequest struct {
+	Code         string `json:"code" validate:"max=20"`
+	CodeSystem   string `json:"code_system" validate:"max=20"` // Defaults to ICD-10 when a code is given
+	Description  string `json:"description" validate:"required,max=500"`
+	OnsetDate    string `json:"onset_date"`    // YYYY-MM-DD
+	ResolvedDate string `json:"resolved_date"` // YYYY-MM-DD
+	Status       string `json:"status" validate:"omitempty,oneof=active inactive resolved entered_in_error"` // Defaults to active
+	Notes        string `json:"notes"`
+}
+
+// ClinicalHistoryEntry is one recorded change of a structured clinical item
+type ClinicalHistoryEntry struct {
+	ID        int             `json:"id" db:"id"`
+	ItemType  string          `json:"item_type" db:"item_type"`
+	ItemID    int             `json:"item_id" db:"item_id"`
+	Action    string          `json:"action" db:"action"`
+	Snapshot  json.RawMessage `json:"snapshot" db:"snapshot"`
+	ChangedBy int             `json:"changed_by" db:"changed_by"`
+	ChangedAt time.Time       `json:"changed_at" db:"changed_at"`
+}
+
+// PatientDocument is the metadata of a file attached to a patient
+type PatientDocument struct {
+	ID                 int       `json:"id" db:"id"`
+	PatientID          int       `json:"patient_id" db:"patient_id"`
+	HealthcareEntityID int       `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	DocumentType       string    `json:"document_type" db:"document_type"`
+	Description        string    `json:"description" db:"description"`
+	AppointmentID      *int      `json:"appointment_id,omitempty" db:"appointment_id"` // Appointment service reference
+	FileName           string    `json:"file_name" db:"file_name"`
+	ContentType        string    `json:"content_type" db:"content_type"`
+	SizeBytes          int64     `json:"size_bytes" db:"size_bytes"`
+	ChecksumSHA256     string    `json:"checksum_sha256" db:"checksum_sha256"`
+	StorageKey         string    `json:"-" db:"storage_key"`
+	UploadedBy         int       `json:"uploaded_by" db:"uploaded_by"`
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// DocumentUploadRequest represents the form fields sent with a document upload
+type DocumentUploadRequest struct {
+	DocumentType  string `form:"document_type" validate:"required,oneof=referral id_card insurance_card lab_result imaging prescription consent_form other"`
+	Description   string `form:"description"`
+	AppointmentID *int   `form:"appointment_id"`
+}
+
+// DocumentUpdateRequest represents a document metadata update
+type DocumentUpdateRequest struct {
+	DocumentType  string `json:"document_type" validate:"required,oneof=referral id_card insurance_card lab_result imaging prescription consent_form other"`
+	Description   string `json:"description"`
+	AppointmentID *int   `json:"appointment_id,omitempty"`
+}
+
+// DocumentAccess identifies who touched a document, for the access audit log
+type DocumentAccess struct {
+	UserID    int
+	Actor     string // Name of the system job acting without a user, empty for users
+	ClientIP  string
+	UserAgent string
+}
+
+// DocumentAccessLogEntry is one audited access to a document
+type DocumentAccessLogEntry struct {
+	ID         int       `json:"id" db:"id"`
+	DocumentID int       `json:"document_id" db:"document_id"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	Actor      *string   `json:"actor" db:"actor"` // Set when a system job acted instead of a user
+	Action     string    `json:"action" db:"action"`
+	ClientIP   string    `json:"client_ip" db:"client_ip"`
+	UserAgent  string    `json:"user_agent" db:"user_agent"`
+	AccessedAt time.Time `json:"accessed_at" db:"accessed_at"`
+}
+
+// MRNConfiguration is the per-entity pattern used to generate medical record numbers:
+// prefix, year, zero-padded sequence and check digit, joined by the separator.
+type MRNConfiguration struct {
+	HealthcareEntityID int        `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	Prefix             string     `json:"prefix" db:"prefix"`
+	Separator          string     `json:"separator" db:"separator"`
+	IncludeYear        bool       `json:"include_year" db:"include_year"`
+	YearlyReset        bool       `json:"yearly_reset" db:"yearly_reset"` // Restart the sequence every year (requires include_year)
+	SequenceDigits     int        `json:"sequence_digits" db:"sequence_digits"`
+	CheckDigit         string     `json:"check_digit" db:"check_digit"` // none, luhn, mod11
+	IsDefault          bool       `json:"is_default" db:"-"`            // True when the entity has no saved configuration
+	UpdatedAt          *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+	UpdatedBy          *int       `json:"updated_by,omitempty" db:"updated_by"`
+}
+
+// MRNConfigurationRequest represents an MRN pattern update
+type MRNConfigurationRequest struct {
+	Prefix         string `json:"prefix" validate:"max=10"` // Uppercase letters and digits, may be empty
+	Separator      string `json:"separator" validate:"omitempty,oneof=- /"`
+	IncludeYear    bool   `json:"include_year"`
+	YearlyReset    bool   `json:"yearly_reset"`
+	SequenceDigits int    `json:"sequence_digits" validate:"required,min=4,max=12"`
+	CheckDigit     string `json:"check_digit" validate:"required,oneof=none luhn mod11"`
+}
+
+// PatientRelationship links two patients: RelatedPatientID is the PatientID's RelationshipType
+// (e.g. the related patient is the parent of the patient). Proxy rights are held by the
+// related patient over the patient.
+type PatientRelationship struct {
+	ID                  int       `json:"id" db:"id"`
+	HealthcareEntityID  int       `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	PatientID           int       `json:"patient_id" db:"patient_id"`
+	RelatedPatientID    int       `json:"related_patient_id" db:"related_patient_id"`
+	RelationshipType    string    `json:"relationship_type" db:"relationship_type"` // parent, guardian, spouse, sibling, caregiver
+	IsLegalGuardian     bool      `json:"is_legal_guardian" db:"is_legal_guardian"`
+	CanBookAppointments bool      `json:"can_book_appointments" db:"can_book_appointments"`
+	CanViewRecords      bool      `json:"can_view_records" db:"can_view_records"`
+	CanManageConsents   bool      `json:"can_manage_consents" db:"can_manage_consents"`
+	Notes               string    `json:"notes" db:"notes"`
+	CreatedAt           time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at" db:"updated_at"`
+	CreatedBy           int       `json:"created_by" db:"created_by"`
+}
+
+// RelationshipRequest creates a link where the related patient is the patient's relationship_type
+type RelationshipRequest struct {
+	RelatedPatientID    int    `json:"related_patient_id" validate:"required"`
+	RelationshipType    string `json:"relationship_type" validate:"required,oneof=parent guardian spouse sibling caregiver"`
+	IsLegalGuardian     bool   `json:"is_legal_guardian"`
+	CanBookAppointments bool   `json:"can_book_appointments"`
+	CanViewRecords      bool   `json:"can_view_records"`
+	CanManageConsents   bool   `json:"can_manage_consents"`
+	Notes               string `json:"notes" validate:"max=500"`
+}
+
+// RelationshipUpdateRequest updates the guardian flag, proxy rights and notes of a link
+type RelationshipUpdateRequest struct {
+	IsLegalGuardian     bool   `json:"is_legal_guardian"`
+	CanBookAppointments bool   `json:"can_book_appointments"`
+	CanViewRecords      bool   `json:"can_view_records"`
+	CanManageConsents   bool   `json:"can_manage_consents"`
+	Notes               string `json:"notes" validate:"max=500"`
+}
+
+// GuardianLinkRequest names the guardian of a minor at patient creation. The guardian is
+// recorded as a legal guardian with full proxy rights.
+type GuardianLinkRequest struct {
+	PatientID        int    `json:"patient_id" validate:"required"`
+	RelationshipType string `json:"relationship_type" validate:"required,oneof=parent guardian"`
+}
+
+// RelatedPatientSummary is the minimal view of a patient shown in relationships and family groups
+type RelatedPatientSummary struct {
+	ID          int       `json:"id"`
+	MRN         string    `json:"mrn"`
+	FirstName   string    `json:"first_name"`
+	LastName    string    `json:"last_name"`
+	DateOfBirth time.Time `json:"date_of_birth"`
+	Age         int       `json:"age"`
+	IsMinor     bool      `json:"is_minor"`
+}
+
+// PatientRelationshipView is a relationship seen from one patient: Role is what the other
+// patient is to them (e.g. "parent", or "child" for the inverse of a parent link)
+type PatientRelationshipView struct {
+	PatientRelationship
+	Role         string                `json:"role"`
+	OtherPatient RelatedPatientSummary `json:"other_patient"`
+}
+
+// FamilyGroup is the set of patients connected to a patient through relationships
+type FamilyGroup struct {
+	PatientID     int                     `json:"patient_id"`
+	Members       []RelatedPatientSummary `json:"members"`
+	Relationships []PatientRelationship   `json:"relationships"`
+}
+
+// DependantBookingRequest books an appointment for a dependant; the dependant is taken from the URL
+type DependantBookingRequest struct {
+	DoctorID       int    `json:"doctor_id" validate:"required"`
+	DateTime       string `json:"date_time" validate:"required"` // ISO 8601 UTC format: 2006-01-02T15:04:05Z
+	Duration       int    `json:"duration" validate:"required,min=15,max=480"`
+	Type           string `json:"type" validate:"required,oneof=consultation follow-up procedure emergency"`
+	Reason         string `json:"reason" validate:"required"`
+	Notes          string `json:"notes"`
+	Priority       string `json:"priority" validate:"omitempty,oneof=low normal high urgent"`
+	RoomID         int    `json:"room_id"`
+	CheckConflicts bool   `json:"check_conflicts"`
+}
+
+// LegalHold blocks erasure and retention purges of a patient while active
+type LegalHold struct {
+	ID                 int        `json:"id" db:"id"`
+	PatientID          int        `json:"patient_id" db:"patient_id"`
+	HealthcareEntityID int        `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	Reason             string     `json:"reason" db:"reason"`
+	Reference          string     `json:"reference" db:"reference"` // Case or court reference
+	PlacedBy           int        `json:"placed_by" db:"placed_by"`
+	PlacedAt           time.Time  `json:"placed_at" db:"placed_at"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	ReleasedBy         *int       `json:"released_by,omitempty" db:"released_by"`
+	ReleasedAt         *time.Time `json:"released_at,omitempty" db:"released_at"`
+	ReleaseReason      *string    `json:"release_reason,omitempty" db:"release_reason"`
+	IsActive           bool       `json:"is_active" db:"-"`
+}
+
+// LegalHoldRequest places a legal hold on a patient
+type LegalHoldRequest struct {
+	Reason    string `json:"reason" validate:"required,max=1000"`
+	Reference string `json:"reference" validate:"max=100"`
+	ExpiresAt string `json:"expires_at"` // Optional RFC3339 timestamp; no expiry when empty
+}
+
+// LegalHoldReleaseRequest releases a legal hold
+type LegalHoldReleaseRequest struct {
+	Reason string `json:"reason" validate:"required,max=1000"`
+}
+
+// DataSubjectRequest is a patient's request to export or erase their data (GDPR, Law 09-08, PIPEDA)
+type DataSubjectRequest struct {
+	ID                    int             `json:"id" db:"id"`
+	PatientID             int             `json:"patient_id" db:"patient_id"`
+	HealthcareEntityID    int             `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	RequestType           string          `json:"request_type" db:"request_type"` // export, erasure
+	LegalBasis            string          `json:"legal_basis" db:"legal_basis"`   // gdpr, law_09_08, pipeda, other
+	Status                string          `json:"status" db:"status"`             // pending, processing, completed, rejected, failed
+	ReceivedVia           string          `json:"received_via" db:"received_via"`
+	Notes                 string          `json:"notes" db:"notes"`
+	RequestedBy           int             `json:"requested_by" db:"requested_by"`
+	DueAt                 time.Time       `json:"due_at" db:"due_at"`
+	ProcessedBy           *int            `json:"processed_by,omitempty" db:"processed_by"`
+	StartedAt             *time.Time      `json:"started_at,omitempty" db:"started_at"`
+	CompletedAt           *time.Time      `json:"completed_at,omitempty" db:"completed_at"`
+	StatusReason          string          `json:"status_reason" db:"status_reason"`
+	Result                json.RawMessage `json:"result,omitempty" db:"result"`
+	ArchiveStorageKey     *string         `json:"-" db:"archive_storage_key"`
+	ArchiveSizeBytes      *int64          `json:"archive_size_bytes,omitempty" db:"archive_size_bytes"`
+	ArchiveChecksumSHA256 *string         `json:"archive_checksum_sha256,omitempty" db:"archive_checksum_sha256"`
+	CreatedAt             time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt             time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// DataSubjectRequestCreate records a new data subject request
+type DataSubjectRequestCreate struct {
+	RequestType string `json:"request_type" validate:"required,oneof=export erasure"`
+	LegalBasis  string `json:"legal_basis" validate:"required,oneof=gdpr law_09_08 pipeda other"`
+	ReceivedVia string `json:"received_via" validate:"omitempty,oneof=in_person paper phone email portal"`
+	Notes       string `json:"notes" validate:"max=2000"`
+}
+
+// DataSubjectRejectRequest rejects a data subject request
+type DataSubjectRejectRequest struct {
+	Reason string `json:"reason" validate:"required,max=2000"`
+}
+
+// PatientDossier is the full export of a patient's data
+type PatientDossier struct {
+	GeneratedAt         time.Time                 `json:"generated_at"`
+	RequestID           int                       `json:"request_id"`
+	LegalBasis          string                    `json:"legal_basis"`
+	Patient             PatientResponse           `json:"patient"`
+	Versions            []PatientVersion          `json:"versions"` // Past and current states of the patient record
+	Allergies           []PatientAllergy          `json:"allergies"`
+	Medications         []PatientMedication       `json:"medications"`
+	Problems            []PatientProblem          `json:"problems"`
+	ClinicalHistory     []ClinicalHistoryEntry    `json:"clinical_history"`
+	Vitals              []VitalSigns              `json:"vitals"` // Metric units
+	Coverages           []PatientCoverage         `json:"coverages"`
+	EligibilityChecks   []EligibilityCheck        `json:"eligibility_checks"`
+	Consents            []PatientConsent          `json:"consents"`
+	Relationships       []PatientRelationshipView `json:"relationships"`
+	Documents           []PatientDocument         `json:"documents"`
+	DocumentAccessLog   []DocumentAccessLogEntry  `json:"document_access_log"`
+	Appointments        []json.RawMessage         `json:"appointments"`
+	LegalHolds          []LegalHold               `json:"legal_holds"`
+	DataSubjectRequests []DataSubjectRequest      `json:"data_subject_requests"`
+}
+
+// RetentionPolicy defines when patients without activity are archived and purged. A policy with
+// a country applies to patients of that country and overrides the entity default.
+type RetentionPolicy struct {
+	ID                 int       `json:"id" db:"id"`
+	HealthcareEntityID int       `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	CountryID          *int      `json:"country_id" db:"country_id"`
+	ArchiveAfterYears  *int      `json:"archive_after_years" db:"archive_after_years"`
+	PurgeAfterYears    *int      `json:"purge_after_years" db:"purge_after_years"`
+	PurgeAction        string    `json:"purge_action" db:"purge_action"`
+	IsActive           bool      `json:"is_active" db:"is_active"`
+	Notes              string    `json:"notes" db:"notes"`
+	CreatedBy          int       `json:"created_by" db:"created_by"`
+	UpdatedBy          int       `json:"updated_by" db:"updated_by"`
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RetentionPolicyRequest creates or updates a retention policy
+type RetentionPolicyRequest struct {
+	CountryID         *int   `json:"country_id" validate:"omitempty,min=1"`
+	ArchiveAfterYears *int   `json:"archive_after_years" validate:"omitempty,min=1,max=100"`
+	PurgeAfterYears   *int   `json:"purge_after_years" validate:"omitempty,min=1,max=100"`
+	PurgeAction       string `json:"purge_action" validate:"omitempty,oneof=anonymise delete"`
+	IsActive          *bool  `json:"is_active"`
+	Notes             string `json:"notes" validate:"max=2000"`
+}
+
+// RetentionRun is one evaluation of an entity's retention policies
+type RetentionRun struct {
+	ID                 int                `json:"id" db:"id"`
+	HealthcareEntityID int                `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	DryRun             bool               `json:"dry_run" db:"dry_run"`
+	TriggeredBy        *int               `json:"triggered_by" db:"triggered_by"`
+	Status             string             `json:"status" db:"status"`
+	StartedAt          time.Time          `json:"started_at" db:"started_at"`
+	CompletedAt        *time.Time         `json:"completed_at" db:"completed_at"`
+	PatientsEvaluated  int                `json:"patients_evaluated" db:"patients_evaluated"`
+	ArchivedCount      int                `json:"archived_count" db:"archived_count"`
+	AnonymisedCount    int                `json:"anonymised_count" db:"anonymised_count"`
+	DeletedCount       int                `json:"deleted_count" db:"deleted_count"`
+	SkippedCount       int                `json:"skipped_count" db:"skipped_count"`
+	FailedCount        int                `json:"failed_count" db:"failed_count"`
+	Error              string             `json:"error,omitempty" db:"error"`
+	Items              []RetentionRunItem `json:"items,omitempty"`
+}
+
+// RetentionRunItem is the report line of a patient due for a retention action
+type RetentionRunItem struct {
+	ID             int       `json:"id" db:"id"`
+	RunID          int       `json:"run_id" db:"run_id"`
+	PatientID      int       `json:"patient_id" db:"patient_id"`
+	MRN            string    `json:"mrn" db:"mrn"`
+	PolicyID       int       `json:"policy_id" db:"policy_id"`
+	Action         string    `json:"action" db:"action"`   // archive, anonymise, delete
+	Outcome        string    `json:"outcome" db:"outcome"` // planned (dry run), done, skipped, failed
+	LastActivityAt time.Time `json:"last_activity_at" db:"last_activity_at"`
+	Detail         string    `json:"detail,omitempty" db:"detail"`
+	ProcessedAt    time.Time `json:"processed_at" db:"processed_at"`
+}
+
+// RetentionRunRequest starts a retention run
+type RetentionRunRequest struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// ArchivedPatient is a patient moved out of the hot tables by a retention run
+type ArchivedPatient struct {
+	PatientID          int       `json:"patient_id" db:"patient_id"`
+	HealthcareEntityID int       `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	MRN                string    `json:"mrn" db:"mrn"`
+	FirstName          string    `json:"first_name"`
+	LastName           string    `json:"last_name"`
+	CountryID          *int      `json:"country_id" db:"country_id"`
+	LastActivityAt     time.Time `json:"last_activity_at" db:"last_activity_at"`
+	ArchivedAt         time.Time `json:"archived_at" db:"archived_at"`
+	RetentionRunID     *int      `json:"retention_run_id" db:"retention_run_id"`
+}
+
+// VitalSigns is a set of vital signs taken at the same time. Values are stored in metric units
+// and rendered in the unit system given by UnitSystem; absent measurements are null.
+type VitalSigns struct {
+	ID                 int               `json:"id" db:"id"`
+	PatientID          int               `json:"patient_id" db:"patient_id"`
+	HealthcareEntityID int               `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	AppointmentID      *int              `json:"appointment_id" db:"appointment_id"`
+	MeasuredAt         time.Time         `json:"measured_at" db:"measured_at"`
+	UnitSystem         string            `json:"unit_system"` // metric or imperial, the units of the values below
+	Units              map[string]string `json:"units"`
+	Systolic           *int              `json:"systolic" db:"systolic_mmhg"`
+	Diastolic          *int              `json:"diastolic" db:"diastolic_mmhg"`
+	HeartRate          *int              `json:"heart_rate" db:"heart_rate_bpm"`
+	Temperature        *float64          `json:"temperature" db:"temperature_c"`
+	SpO2               *int              `json:"spo2" db:"spo2_percent"`
+	Weight             *float64          `json:"weight" db:"weight_kg"`
+	Height             *float64          `json:"height" db:"height_cm"`
+	BMI                *float64          `json:"bmi" db:"bmi"` // Computed from weight and height
+	Glucose            *float64          `json:"glucose" db:"glucose_mmol_l"`
+	GlucoseContext     string            `json:"glucose_context" db:"glucose_context"`
+	EnteredUnitSystem  string            `json:"entered_unit_system" db:"entered_unit_system"`
+	Notes              string            `json:"notes" db:"notes"`
+	Flags              []VitalFlag       `json:"flags"`
+	RecordedBy         int               `json:"recorded_by" db:"recorded_by"`
+	CreatedAt          time.Time         `json:"created_at" db:"created_at"`
+	DeletedAt          *time.Time        `json:"deleted_at,omitempty" db:"deleted_at"`
+	DeletedBy          *int              `json:"deleted_by,omitempty" db:"deleted_by"`
+	DeletionReason     string            `json:"deletion_reason,omitempty" db:"deletion_reason"`
+}
+
+// VitalSignsRequest records a set of vital signs. Values are in the units of unit_system, which
+// defaults to the unit system of the entity's country.
+type VitalSignsRequest struct {
+	AppointmentID  *int     `json:"appointment_id" validate:"omitempty,min=1"`
+	MeasuredAt     string   `json:"measured_at"` // RFC3339, defaults to now
+	UnitSystem     string   `json:"unit_system" validate:"omitempty,oneof=metric imperial"`
+	Systolic       *int     `json:"systolic" validate:"omitempty,min=40,max=300"`
+	Diastolic      *int     `json:"diastolic" validate:"omitempty,min=20,max=200"`
+	HeartRate      *int     `json:"heart_rate" validate:"omitempty,min=20,max=300"`
+	Temperature    *float64 `json:"temperature" validate:"omitempty,gt=0"`
+	SpO2           *int     `json:"spo2" validate:"omitempty,min=50,max=100"`
+	Weight         *float64 `json:"weight" validate:"omitempty,gt=0"`
+	Height         *float64 `json:"height" validate:"omitempty,gt=0"`
+	Glucose        *float64 `json:"glucose" validate:"omitempty,gt=0"`
+	GlucoseContext string   `json:"glucose_context" validate:"omitempty,oneof=fasting random post_meal"`
+	Notes          string   `json:"notes" validate:"max=2000"`
+}
+
+// VitalSignsDeleteRequest marks a set of vital signs as entered in error
+type VitalSignsDeleteRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// VitalFlag marks a measurement outside the reference range of the patient's age and gender
+type VitalFlag struct {
+	Measurement   string   `json:"measurement"`
+	Level         string   `json:"level"` // low, high, critical_low, critical_high
+	ReferenceLow  *float64 `json:"reference_low,omitempty"`
+	ReferenceHigh *float64 `json:"reference_high,omitempty"`
+}
+
+// ReferenceRange is the normal range of a measurement for a patient. Values from Low to High
+// are normal; values below CriticalLow or above CriticalHigh are critical.
+type ReferenceRange struct {
+	Measurement  string   `json:"measurement"`
+	Unit         string   `json:"unit"`
+	Low          *float64 `json:"low,omitempty"`
+	High         *float64 `json:"high,omitempty"`
+	CriticalLow  *float64 `json:"critical_low,omitempty"`
+	CriticalHigh *float64 `json:"critical_high,omitempty"`
+	Basis        string   `json:"basis"` // Age band, and gender where the range depends on it
+}
+
+// VitalTrend is the series of one measurement over time
+type VitalTrend struct {
+	Measurement string            `json:"measurement"`
+	Unit        string            `json:"unit"`
+	Points      []VitalTrendPoint `json:"points"`
+	Count       int               `json:"count"`
+	Latest      *float64          `json:"latest"`
+	Min         *float64          `json:"min"`
+	Max         *float64          `json:"max"`
+	Mean        *float64          `json:"mean"`
+	Change      *float64          `json:"change"` // Latest minus first value of the period
+}
+
+// VitalTrendPoint is one value of a trend
+type VitalTrendPoint struct {
+	VitalsID   int       `json:"vitals_id"`
+	MeasuredAt time.Time `json:"measured_at"`
+	Value      float64   `json:"value"`
+	Flag       string    `json:"flag,omitempty"`
+}
+
+// TimelineEvent is one event of a patient's timeline, recorded by this service or by another
+type TimelineEvent struct {
+	ID         string                 `json:"id"` // Unique across sources, e.g. clinical:12 or appointment-status:4
+	OccurredAt time.Time              `json:"occurred_at"`
+	Category   string                 `json:"category"` // record, clinical, vitals, document, consent, relationship, appointment, encounter_note
+	Type       string                 `json:"type"`     // e.g. allergy.created, consent.revoked, appointment.status_changed
+	Summary    string                 `json:"summary"`
+	Source     string                 `json:"source"` // Service holding the event
+	ActorID    *int                   `json:"actor_id"`
+	Actor      *TimelineActor         `json:"actor"` // Nil when unknown or when names could not be resolved
+	Details    map[string]interface{} `json:"details"`
+}
+
+// TimelineActor is the user who performed a timeline event
+type TimelineActor struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// TimelineSection reports whether one source of the timeline could be read. A degraded section
+// leaves its events (or actor names) out of the timeline rather than failing it.
+type TimelineSection struct {
+	Name   string `json:"name"`   // patient_records, appointments, actors
+	Status string `json:"status"` // ok, degraded, skipped
+	Error  string `json:"error,omitempty"`
+}
+
+// PatientTimeline is one page of a patient's timeline, most recent event first
+type PatientTimeline struct {
+	PatientID  int               `json:"patient_id"`
+	Events     []TimelineEvent   `json:"events"`
+	TotalCount int               `json:"total_count"` // Events of the sections that could be read
+	Limit      int               `json:"limit"`
+	Offset     int               `json:"offset"`
+	Degraded   bool              `json:"degraded"`
+	Sections   []TimelineSection `json:"sections"`
+}
+
+// TimelineFilter represents patient timeline parameters
+type TimelineFilter struct {
+	Categories []string // All when empty
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+	Offset     int
+}
+
+// PatientVersion is one state of a patient row, valid from ValidFrom until ValidTo (nil for
+// the current version)
+type PatientVersion struct {
+	ID                  int             `json:"id" db:"id"`
+	PatientID           int             `json:"patient_id" db:"patient_id"`
+	Version             int             `json:"version" db:"version"`
+	ValidFrom           time.Time       `json:"valid_from" db:"valid_from"`
+	ValidTo             *time.Time      `json:"valid_to" db:"valid_to"`
+	ChangeType          string          `json:"change_type" db:"change_type"`       // migrated, created, updated, restored, deactivated, anonymised
+	ChangedFields       []string        `json:"changed_fields" db:"changed_fields"` // Columns that differ from the previous version
+	ChangedBy           *int            `json:"changed_by" db:"changed_by"`
+	RestoredFromVersion *int            `json:"restored_from_version,omitempty" db:"restored_from_version"`
+	ChangeReason        string          `json:"change_reason,omitempty" db:"change_reason"`
+	Data                json.RawMessage `json:"data,omitempty" db:"data"` // The patient row; omitted from version lists
+}
+
+// PatientFieldChange is a field that differs between two versions of a patient
+type PatientFieldChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from"`
+	To    json.RawMessage `json:"to"`
+}
+
+// PatientVersionDiff lists the fields that differ between two versions of a patient
+type PatientVersionDiff struct {
+	PatientID   int                  `json:"patient_id"`
+	FromVersion int                  `json:"from_version"`
+	ToVersion   int                  `json:"to_version"`
+	Changes     []PatientFieldChange `json:"changes"`
+}
+
+// PatientVersionRestoreRequest represents a restore-to-version request
+type PatientVersionRestoreRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// StatsCount is one group of a patient distribution
+type StatsCount struct {
+	Value string `json:"value,omitempty"` // Age band or gender
+	ID    *int   `json:"id,omitempty"`    // Location-service ID; absent for patients without one
+	Name  string `json:"name,omitempty"`  // Location-service name of the ID
+	Count int    `json:"count"`
+}
+
+// RegistrationBucket counts the patients registered in one week or month
+type RegistrationBucket struct {
+	PeriodStart time.Time `json:"period_start"`
+	Count       int       `json:"count"`
+}
+
+// RegistrationStats counts patient registrations per week or month, empty periods included
+type RegistrationStats struct {
+	Interval string               `json:"interval"` // week or month
+	From     time.Time            `json:"from"`
+	To       time.Time            `json:"to"`
+	Buckets  []RegistrationBucket `json:"buckets"`
+}
+
+// PatientStatistics is the demographic dashboard of a healthcare entity. Distributions count
+// active patients; registrations count every patient registered in the period.
+type PatientStatistics struct {
+	HealthcareEntityID int               `json:"healthcare_entity_id"`
+	GeneratedAt        time.Time         `json:"generated_at"`
+	TotalPatients      int               `json:"total_patients"` // Active patients
+	ActivePatients     int               `json:"active_patients"`
+	InactivePatients   int               `json:"inactive_patients"` // Deleted or anonymised
+	AnonymisedPatients int               `json:"anonymised_patients"`
+	AgeBands           []StatsCount      `json:"age_bands"`
+	Genders            []StatsCount      `json:"genders"`
+	Nationalities      []StatsCount      `json:"nationalities"`
+	InsuranceTypes     []StatsCount      `json:"insurance_types"`
+	InsuranceProviders []StatsCount      `json:"insurance_providers"`
+	States             []StatsCount      `json:"states"`
+	Cities             []StatsCount      `json:"cities"`
+	Registrations      RegistrationStats `json:"registrations"`
+	NamesResolved      bool              `json:"names_resolved"` // False when location-service could not be reached
+}
+
+// PatientStatsFilter represents patient statistics parameters
+type PatientStatsFilter struct {
+	Interval string // week or month
+	From     time.Time
+	To       time.Time
+	Locale   string // Language of location names
+}
+
+// PatientCoverage is an insurance coverage of a patient. Rank orders the coverages that apply
+// at the same time: 1 is primary, 2 secondary, and so on.
+type PatientCoverage struct {
+	ID                     int        `json:"id" db:"id"`
+	PatientID              int        `json:"patient_id" db:"patient_id"`
+	HealthcareEntityID     int        `json:"healthcare_entity_id" db:"healthcare_entity_id"`
+	Rank                   int        `json:"rank" db:"rank"`
+	InsuranceTypeID        int        `json:"insurance_type_id" db:"insurance_type_id"`         // Foreign key to location service
+	InsuranceProviderID    int        `json:"insurance_provider_id" db:"insurance_provider_id"` // Foreign key to location service
+	PolicyNumber           string     `json:"policy_number" db:"policy_number"`
+	MemberID               string     `json:"member_id" db:"member_id"`
+	GroupNumber            string     `json:"group_number" db:"group_number"`
+	SubscriberRelationship string     `json:"subscriber_relationship" db:"subscriber_relationship"` // Patient's relationship to the policy holder: self, spouse, child, other
+	SubscriberName         string     `json:"subscriber_name" db:"subscriber_name"`
+	SubscriberDateOfBirth  *time.Time `json:"subscriber_date_of_birth,omitempty" db:"subscriber_date_of_birth"`
+	EffectiveFrom          time.Time  `json:"effective_from" db:"effective_from"`
+	EffectiveTo            *time.Time `json:"effective_to,omitempty" db:"effective_to"` // Inclusive; open-ended when absent
+	AnnualLimit            *float64   `json:"annual_limit,omitempty" db:"annual_limit"`
+	Deductible             *float64   `json:"deductible,omitempty" db:"deductible"`
+	Copay                  *float64   `json:"copay,omitempty" db:"copay"`
+	CoinsurancePercent     *float64   `json:"coinsurance_percent,omitempty" db:"coinsurance_percent"`
+	Currency               string     `json:"currency" db:"currency"` // ISO 4217, for the amounts above
+	Notes                  string     `json:"notes" db:"notes"`
+	IsEffective            bool       `json:"is_effective"` // Effective today and not deleted
+	CreatedBy              *int       `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt              time.Time  `json:"created_at" db:"created_at"`
+	UpdatedBy              *int       `json:"updated_by,omitempty" db:"updated_by"`
+	UpdatedAt              time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt              *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	DeletedBy              *int       `json:"deleted_by,omitempty" db:"deleted_by"`
+}
+
+// CoverageRequest creates or replaces a coverage. Dates are YYYY-MM-DD; the subscriber name is
+// required unless the patient is the subscriber.
+type CoverageRequest struct {
+	Rank                   int      `json:"rank" validate:"required,min=1,max=9"`
+	InsuranceTypeID        int      `json:"insurance_type_id" validate:"required,min=1"`
+	InsuranceProviderID    int      `json:"insurance_provider_id" validate:"required,min=1"`
+	PolicyNumber           string   `json:"policy_number" validate:"max=100"`
+	MemberID               string   `json:"member_id" validate:"required,max=100"`
+	GroupNumber            string   `json:"group_number" validate:"max=100"`
+	SubscriberRelationship string   `json:"subscriber_relationship" validate:"required,oneof=self spouse child other"`
+	SubscriberName         string   `json:"subscriber_name" validate:"max=200"`
+	SubscriberDateOfBirth  string   `json:"subscriber_date_of_birth"`
+	EffectiveFrom          string   `json:"effective_from" validate:"required"`
+	EffectiveTo            string   `json:"effective_to"`
+	AnnualLimit            *float64 `json:"annual_limit" validate:"omitempty,gte=0"`
+	Deductible             *float64 `json:"deductible" validate:"omitempty,gte=0"`
+	Copay                  *float64 `json:"copay" validate:"omitempty,gte=0"`
+	CoinsurancePercent     *float64 `json:"coinsurance_percent" validate:"omitempty,gte=0,lte=100"`
+	Currency               string   `json:"currency" validate:"omitempty,len=3,alpha"`
+	Notes                  string   `json:"notes" validate:"max=2000"`
+}
+
+// CoverageFilter represents coverage list parameters
+type CoverageFilter struct {
+	ActiveOn       *time.Time // Only coverages effective on this date
+	IncludeDeleted bool
+}
+
+// EligibilityCheckRequest asks whether a coverage applies to a service. The service date is
+// YYYY-MM-DD and defaults to today.
+type EligibilityCheckRequest struct {
+	ServiceDate string `json:"service_date"`
+	ServiceType string `json:"service_type" validate:"max=50"`
+}
+
+// EligibilityCheck is the recorded result of an eligibility check of a coverage
+type EligibilityCheck struct {
+	ID          int             `json:"id" db:"id"`
+	CoverageID  int             `json:"coverage_id" db:"coverage_id"`
+	PatientID   int             `json:"patient_id" db:"patient_id"`
+	Checker     string          `json:"checker" db:"checker"` // Name of the EligibilityChecker that answered
+	Status      string          `json:"status" db:"status"`   // eligible, ineligible, error
+	ServiceDate time.Time       `json:"service_date" db:"service_date"`
+	ServiceType string          `json:"service_type" db:"service_type"`
+	Message     string          `json:"message" db:"message"`
+	Details     json.RawMessage `json:"details" db:"details"` // Checker specific, e.g. remaining benefits
+	CheckedBy   *int            `json:"checked_by,omitempty" db:"checked_by"`
+	CheckedAt   time.Time       `json:"checked_at" db:"checked_at"`
+}
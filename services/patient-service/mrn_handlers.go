@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+var mrnPrefixPattern = regexp.MustCompile(`^[A-Z0-9]*$`)
+
+// MRNHandler groups MRN configuration handlers
+type MRNHandler struct {
+	mrnService *MRNService
+	validator  *validator.Validate
+}
+
+// NewMRNHandler constructs a new MRNHandler
+func NewMRNHandler(mrnService *MRNService) *MRNHandler {
+	return &MRNHandler{mrnService: mrnService, validator: validator.New()}
+}
+
+// GetConfiguration returns the entity's MRN pattern and the next MRN it would produce
+func (h *MRNHandler) GetConfiguration(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	cfg, err := h.mrnService.GetConfiguration(entityID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get MRN configuration"})
+		return
+	}
+	next, err := h.mrnService.PreviewNext(cfg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to preview next MRN"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"configuration": cfg, "next_mrn": next})
+}
+
+// UpdateConfiguration replaces the entity's MRN pattern (admin only)
+func (h *MRNHandler) UpdateConfiguration(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	var req MRNConfigurationRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	req.Prefix = strings.ToUpper(strings.TrimSpace(req.Prefix))
+	if !mrnPrefixPattern.MatchString(req.Prefix) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": "prefix may only contain letters and digits"})
+		return
+	}
+	if req.YearlyReset && !req.IncludeYear {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": "yearly_reset requires include_year, otherwise MRNs would repeat"})
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	cfg := &MRNConfiguration{
+		HealthcareEntityID: entityID,
+		Prefix:             req.Prefix,
+		Separator:          req.Separator,
+		IncludeYear:        req.IncludeYear,
+		YearlyReset:        req.YearlyReset,
+		SequenceDigits:     req.SequenceDigits,
+		CheckDigit:         req.CheckDigit,
+		UpdatedBy:          &userID,
+	}
+	if err := h.mrnService.SaveConfiguration(cfg); err != nil {
+		logging.LogError("Failed to save MRN configuration", "error", err, "healthcare_entity_id", entityID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save MRN configuration"})
+		return
+	}
+	next, err := h.mrnService.PreviewNext(cfg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to preview next MRN"})
+		return
+	}
+
+	logging.LogInfo("MRN configuration updated", "healthcare_entity_id", entityID, "prefix", cfg.Prefix, "check_digit", cfg.CheckDigit, "updated_by", userID)
+	c.JSON(http.StatusOK, gin.H{"configuration": cfg, "next_mrn": next})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// defaultMRNConfiguration mirrors the defaults applied by next_patient_mrn for entities
+// without a saved configuration
+func defaultMRNConfiguration(entityID int) *MRNConfiguration {
+	return &MRNConfiguration{
+		HealthcareEntityID: entityID,
+		Prefix:             "MRN",
+		Separator:          "-",
+		IncludeYear:        true,
+		YearlyReset:        true,
+		SequenceDigits:     6,
+		CheckDigit:         "luhn",
+		IsDefault:          true,
+	}
+}
+
+// MRNService manages the per-entity medical record number patterns. Numbers themselves are
+// allocated in the database by next_patient_mrn when a patient is inserted.
+type MRNService struct {
+	db *sql.DB
+}
+
+// NewMRNService creates a new MRN service
+func NewMRNService(db *sql.DB) *MRNService {
+	return &MRNService{db: db}
+}
+
+// GetConfiguration returns the entity's MRN pattern, or the default pattern if none is saved
+func (s *MRNService) GetConfiguration(entityID int) (*MRNConfiguration, error) {
+	cfg := &MRNConfiguration{}
+	err := s.db.QueryRow(`
+		SELECT healthcare_entity_id, prefix, separator, include_year, yearly_reset, sequence_digits, check_digit,
+			updated_at, updated_by
+		FROM mrn_configurations
+		WHERE healthcare_entity_id = $1
+	`, entityID).Scan(&cfg.HealthcareEntityID, &cfg.Prefix, &cfg.Separator, &cfg.IncludeYear, &cfg.YearlyReset,
+		&cfg.SequenceDigits, &cfg.CheckDigit, &cfg.UpdatedAt, &cfg.UpdatedBy)
+	if err == sql.ErrNoRows {
+		return defaultMRNConfiguration(entityID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SaveConfiguration creates or replaces the entity's MRN pattern. Existing MRNs are kept;
+// the new pattern applies to patients created afterwards.
+func (s *MRNService) SaveConfiguration(cfg *MRNConfiguration) error {
+	return s.db.QueryRow(`
+		INSERT INTO mrn_configurations (
+			healthcare_entity_id, prefix, separator, include_year, yearly_reset, sequence_digits, check_digit, updated_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (healthcare_entity_id) DO UPDATE SET
+			prefix = EXCLUDED.prefix, separator = EXCLUDED.separator, include_year = EXCLUDED.include_year,
+			yearly_reset = EXCLUDED.yearly_reset, sequence_digits = EXCLUDED.sequence_digits,
+			check_digit = EXCLUDED.check_digit, updated_by = EXCLUDED.updated_by, updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at
+	`, cfg.HealthcareEntityID, cfg.Prefix, cfg.Separator, cfg.IncludeYear, cfg.YearlyReset, cfg.SequenceDigits,
+		cfg.CheckDigit, cfg.UpdatedBy).Scan(&cfg.UpdatedAt)
+}
+
+// PreviewNext formats the MRN the next patient would receive under cfg, without
+// consuming a sequence number
+func (s *MRNService) PreviewNext(cfg *MRNConfiguration) (string, error) {
+	year := time.Now().Year()
+	period := 0
+	if cfg.IncludeYear && cfg.YearlyReset {
+		period = year
+	}
+
+	var mrn string
+	err := s.db.QueryRow(`
+		SELECT format_patient_mrn($1, $2, $3, $4, $5, $6, COALESCE((
+			SELECT last_value FROM mrn_sequences WHERE healthcare_entity_id = $7 AND period = $8
+		), 0) + 1)
+	`, cfg.Prefix, cfg.Separator, cfg.IncludeYear, cfg.SequenceDigits, cfg.CheckDigit, year,
+		cfg.HealthcareEntityID, period).Scan(&mrn)
+	return mrn, err
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The check digits are computed in the database by mrn_luhn_check_digit, mrn_mod11_check_digit
+// and format_patient_mrn (migration 17). These mirrors follow the PL/pgSQL line by line so the
+// algorithms can be checked against known vectors without a database.
+
+func luhnCheckDigit(digits string) string {
+	total := 0
+	for i := 1; i <= len(digits); i++ {
+		d := int(digits[len(digits)-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		total += d
+	}
+	return strconv.Itoa((10 - total%10) % 10)
+}
+
+func mod11CheckDigit(digits string) string {
+	total := 0
+	for i := 1; i <= len(digits); i++ {
+		total += int(digits[len(digits)-i]-'0') * (2 + (i-1)%6)
+	}
+	switch remainder := 11 - total%11; remainder {
+	case 11:
+		return "0"
+	case 10:
+		return "X"
+	default:
+		return strconv.Itoa(remainder)
+	}
+}
+
+func formatPatientMRN(cfg *MRNConfiguration, year int, sequence int64) string {
+	seq := strconv.FormatInt(sequence, 10)
+	yearPart := ""
+	if cfg.IncludeYear {
+		yearPart = strconv.Itoa(year)
+	}
+	if len(seq) < cfg.SequenceDigits {
+		seq = strings.Repeat("0", cfg.SequenceDigits-len(seq)) + seq
+	}
+	parts := []string{}
+	if cfg.Prefix != "" {
+		parts = append(parts, cfg.Prefix)
+	}
+	if yearPart != "" {
+		parts = append(parts, yearPart)
+	}
+	parts = append(parts, seq)
+	switch cfg.CheckDigit {
+	case "luhn":
+		parts = append(parts, luhnCheckDigit(yearPart+seq))
+	case "mod11":
+		parts = append(parts, mod11CheckDigit(yearPart+seq))
+	}
+	return strings.Join(parts, cfg.Separator)
+}
+
+// validLuhnNumber is the standard Luhn validation of a number ending in its check digit
+func validLuhnNumber(number string) bool {
+	total := 0
+	for i := 0; i < len(number); i++ {
+		d := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		total += d
+	}
+	return total%10 == 0
+}
+
+// validMod11Number checks a number ending in its check digit: with the check digit weighted 1,
+// the weighted sum is a multiple of 11
+func validMod11Number(number string) bool {
+	check := 10
+	if last := number[len(number)-1]; last != 'X' {
+		check = int(last - '0')
+	}
+	payload := number[:len(number)-1]
+	total := check
+	for i := 1; i <= len(payload); i++ {
+		total += int(payload[len(payload)-i]-'0') * (2 + (i-1)%6)
+	}
+	return total%11 == 0
+}
+
+func TestLuhnCheckDigit(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   string
+	}{
+		{"7992739871", "3"}, // the textbook Luhn example
+		{"2025000001", "3"},
+		{"2025000042", "7"},
+		{"000001", "8"},
+		{"0", "0"},
+	}
+	for _, tt := range tests {
+		got := luhnCheckDigit(tt.digits)
+		if got != tt.want {
+			t.Errorf("luhnCheckDigit(%s) = %s, want %s", tt.digits, got, tt.want)
+		}
+		if !validLuhnNumber(tt.digits + got) {
+			t.Errorf("%s%s fails Luhn validation", tt.digits, got)
+		}
+	}
+}
+
+func TestMod11CheckDigit(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   string
+	}{
+		{"2025000001", "5"},
+		{"2025000042", "2"},
+		{"2025000004", "X"}, // remainder 10
+		{"2025000009", "0"}, // remainder 11
+		{"123456789", "2"},
+	}
+	for _, tt := range tests {
+		got := mod11CheckDigit(tt.digits)
+		if got != tt.want {
+			t.Errorf("mod11CheckDigit(%s) = %s, want %s", tt.digits, got, tt.want)
+		}
+		if !validMod11Number(tt.digits + got) {
+			t.Errorf("%s%s fails mod-11 validation", tt.digits, got)
+		}
+	}
+}
+
+func TestFormatPatientMRN(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      *MRNConfiguration
+		sequence int64
+		want     string
+	}{
+		{"default pattern", defaultMRNConfiguration(1), 42, "MRN-2025-000042-7"},
+		{"mod11 without year", &MRNConfiguration{Prefix: "CHU", Separator: "/", SequenceDigits: 6, CheckDigit: "mod11"}, 1, "CHU/000001/9"},
+		{"no prefix or check digit", &MRNConfiguration{Separator: "-", IncludeYear: true, SequenceDigits: 4, CheckDigit: "none"}, 7, "2025-0007"},
+		{"sequence wider than the padding", &MRNConfiguration{Prefix: "P", Separator: "-", SequenceDigits: 4, CheckDigit: "none"}, 123456, "P-123456"},
+		{"empty separator", &MRNConfiguration{Prefix: "MRN", IncludeYear: true, SequenceDigits: 6, CheckDigit: "luhn"}, 1, "MRN20250000013"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatPatientMRN(tt.cfg, 2025, tt.sequence); got != tt.want {
+				t.Errorf("formatPatientMRN = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateMRNConfigurationValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"yearly reset without year", `{"prefix":"MRN","separator":"-","yearly_reset":true,"sequence_digits":6,"check_digit":"luhn"}`},
+		{"prefix with punctuation", `{"prefix":"MR-N","separator":"-","sequence_digits":6,"check_digit":"luhn"}`},
+		{"unknown check digit", `{"prefix":"MRN","separator":"-","sequence_digits":6,"check_digit":"crc"}`},
+		{"too few sequence digits", `{"prefix":"MRN","separator":"-","sequence_digits":2,"check_digit":"none"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tt.body))
+			c.Request.Header.Set("X-Healthcare-Entity-ID", "1")
+
+			NewMRNHandler(NewMRNService(nil)).UpdateConfiguration(c)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -14,13 +15,15 @@ import (
 
 // PatientHandler groups all patient related handlers
 type PatientHandler struct {
-    patientService *PatientService
-    validator      *validator.Validate
+    patientService      *PatientService
+    relationshipService *RelationshipService
+    versionService      *PatientVersionService
+    validator           *validator.Validate
 }
 
 // NewPatientHandler constructs a new PatientHandler
-func NewPatientHandler(patientService *PatientService) *PatientHandler {
-    return &PatientHandler{patientService: patientService, validator: validator.New()}
+func NewPatientHandler(patientService *PatientService, relationshipService *RelationshipService, versionService *PatientVersionService) *PatientHandler {
+    return &PatientHandler{patientService: patientService, relationshipService: relationshipService, versionService: versionService, validator: validator.New()}
 }
 
 // parseAndValidateRequest is a generic helper to parse and validate JSON requests with logging
@@ -86,6 +89,7 @@ func (h *PatientHandler) CreatePatient(c *gin.Context) {
         "X-User-ID":      c.GetHeader("X-User-ID"),
         "X-User-Email":   c.GetHeader("X-User-Email"),
         "X-User-Role":    c.GetHeader("X-User-Role"),
+        "X-User-Permissions": c.GetHeader("X-User-Permissions"),
     }
 
     validationErrors, err := h.patientService.ValidatePatientRequest(&req, healthcareEntityID, authHeaders)
@@ -109,6 +113,12 @@ func (h *PatientHandler) CreatePatient(c *gin.Context) {
     dateOfBirth, err := time.Parse("2006-01-02", req.DateOfBirth)
     if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format", "details": fmt.Sprintf("Date of birth must be in YYYY-MM-DD format, received: '%s'", req.DateOfBirth), "parse_error": err.Error()}); return }
 
+    // Minors must be created with a legal guardian
+    if isMinor(dateOfBirth) && req.Guardian == nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Guardian required", "details": fmt.Sprintf("Patient is %d years old; patients under %d must be created with a guardian (guardian.patient_id and guardian.relationship_type)", calculateAge(dateOfBirth), adultAge)})
+        return
+    }
+
     if strings.TrimSpace(req.Email) != "" {
         emailExists, err := h.patientService.EmailExists(req.Email, healthcareEntityID, 0)
         if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error", "details": "Failed to check email existence", "internal_error": err.Error()}); return }
@@ -147,16 +157,28 @@ func (h *PatientHandler) CreatePatient(c *gin.Context) {
         CreatedBy:      userID.(int),
     }
 
-    if err := h.patientService.CreatePatient(patient); err != nil {
+    if req.Guardian != nil {
+        if _, err := h.relationshipService.CreatePatientWithGuardian(patient, req.Guardian); err != nil {
+            if errors.Is(err, ErrRelatedPatientNotFound) { c.JSON(http.StatusBadRequest, gin.H{"error": "Guardian not found", "details": "The guardian must be an active patient of the same healthcare entity"}); return }
+            if errors.Is(err, ErrProxyMustBeAdult) { c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid guardian", "details": err.Error()}); return }
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create patient", "details": "Database operation failed", "internal_error": err.Error(), "patient_data": patient})
+            return
+        }
+    } else if err := h.patientService.CreatePatient(patient); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create patient", "details": "Database operation failed", "internal_error": err.Error(), "patient_data": patient})
         return
     }
 
+    if created, err := h.patientService.GetPatientByID(patient.ID); err == nil {
+        patient = created
+    }
+
     c.JSON(http.StatusCreated, gin.H{"message": "Patient created successfully", "patient": patient.ToPatientResponse()})
 }
 
 // GetPatient returns a patient by ID
 func (h *PatientHandler) GetPatient(c *gin.Context) {
+    if asOf := c.Query("as_of"); asOf != "" { h.getPatientAsOf(c, asOf); return }
     id, err := strconv.Atoi(c.Param("id"))
     if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"}); return }
     patient, err := h.patientService.GetPatientByID(id)
@@ -164,6 +186,16 @@ func (h *PatientHandler) GetPatient(c *gin.Context) {
     c.JSON(http.StatusOK, patient.ToPatientResponse())
 }
 
+// GetPatientByMRN looks a patient up by medical record number within the caller's entity
+func (h *PatientHandler) GetPatientByMRN(c *gin.Context) {
+    entityID, ok := getHealthcareEntityID(c)
+    if !ok { return }
+    mrn := strings.ToUpper(strings.TrimSpace(c.Param("mrn")))
+    patient, err := h.patientService.GetPatientByMRN(entityID, mrn)
+    if err != nil { if err.Error()=="patient not found" { c.JSON(http.StatusNotFound, gin.H{"error":"Patient not found"}); return }; c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to get patient"}); return }
+    c.JSON(http.StatusOK, patient.ToPatientResponse())
+}
+
 // UpdatePatient updates a patient record
 func (h *PatientHandler) UpdatePatient(c *gin.Context) {
     id, err := strconv.Atoi(c.Param("id"))
@@ -172,46 +204,88 @@ func (h *PatientHandler) UpdatePatient(c *gin.Context) {
     if err := h.parseAndValidateRequest(c, &req, "UpdatePatient"); err != nil { return }
     existing, err := h.patientService.GetPatientByID(id)
     if err != nil { if err.Error()=="patient not found" { c.JSON(http.StatusNotFound, gin.H{"error":"Patient not found"}); return }; c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to get patient"}); return }
+    if fields := changedMedicalSummaries(&req, existing); len(fields) > 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Medical summaries are read-only", "details": fmt.Sprintf("%s can't be changed on the patient; use the allergies, medications and problems endpoints", strings.Join(fields, ", "))})
+        return
+    }
+    validationErrors, err := h.patientService.ValidatePatientRequest(&req, existing.HealthcareEntityID, forwardedIdentityHeaders(c))
+    if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": "Validation system error", "details": err.Error()}); return }
+    if len(validationErrors) > 0 { var msgs []string; for _, ve := range validationErrors { msgs = append(msgs, ve.Message) }; c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "validation_errors": msgs}); return }
     dob, err := time.Parse("2006-01-02", req.DateOfBirth)
     if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid date format", "details": fmt.Sprintf("Date of birth must be in YYYY-MM-DD format, received: '%s'", req.DateOfBirth), "parse_error": err.Error()}); return }
     req.Email = strings.TrimSpace(req.Email)
     if req.Email != "" { emailExists, err := h.patientService.EmailExists(req.Email, existing.HealthcareEntityID, id); if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"Internal server error"}); return }; if emailExists { c.JSON(http.StatusConflict, gin.H{"error":"Email already exists"}); return } }
     if req.CountryID <= 0 { c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid country", "details":"Country ID must be a positive integer"}); return }
-    existing.FirstName = req.FirstName; existing.LastName=req.LastName; existing.DateOfBirth=dob; existing.Gender=req.Gender; existing.Phone=req.Phone; existing.Email=req.Email; existing.Address=req.Address; existing.CountryID=req.CountryID; existing.StateID=req.StateID; existing.CityID=req.CityID; existing.PostalCode=req.PostalCode; existing.NationalityID=req.NationalityID; existing.PreferredLanguage=req.PreferredLanguage; existing.MaritalStatus=req.MaritalStatus; existing.Occupation=req.Occupation; existing.InsuranceTypeID=req.InsuranceTypeID; existing.PolicyNumber=req.PolicyNumber; existing.InsuranceProviderID=req.InsuranceProviderID; existing.NationalID=req.NationalID; existing.EmergencyContactName=req.EmergencyContactName; existing.EmergencyContactPhone=req.EmergencyContactPhone; existing.EmergencyContactRelationship=req.EmergencyContactRelationship; existing.MedicalHistory=req.MedicalHistory; existing.Allergies=req.Allergies; existing.Medications=req.Medications; existing.BloodType=req.BloodType
-    if err := h.patientService.UpdatePatient(existing); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to update patient"}); return }
+    existing.FirstName = req.FirstName; existing.LastName=req.LastName; existing.DateOfBirth=dob; existing.Gender=req.Gender; existing.Phone=req.Phone; existing.Email=req.Email; existing.Address=req.Address; existing.CountryID=req.CountryID; existing.StateID=req.StateID; existing.CityID=req.CityID; existing.PostalCode=req.PostalCode; existing.NationalityID=req.NationalityID; existing.PreferredLanguage=req.PreferredLanguage; existing.MaritalStatus=req.MaritalStatus; existing.Occupation=req.Occupation; existing.InsuranceTypeID=req.InsuranceTypeID; existing.PolicyNumber=req.PolicyNumber; existing.InsuranceProviderID=req.InsuranceProviderID; existing.NationalID=req.NationalID; existing.EmergencyContactName=req.EmergencyContactName; existing.EmergencyContactPhone=req.EmergencyContactPhone; existing.EmergencyContactRelationship=req.EmergencyContactRelationship; existing.BloodType=req.BloodType
+    if err := h.patientService.UpdatePatient(existing, c.GetInt("user_id")); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to update patient"}); return }
     c.JSON(http.StatusOK, existing.ToPatientResponse())
 }
 
+// changedMedicalSummaries returns the legacy medical fields of an update request that differ
+// from the patient's computed summaries. Sending them back unchanged, or empty, is allowed.
+func changedMedicalSummaries(req *PatientRequest, existing *Patient) []string {
+    var fields []string
+    for _, f := range []struct{ name, sent, current string }{
+        {"medical_history", req.MedicalHistory, existing.MedicalHistory},
+        {"allergies", req.Allergies, existing.Allergies},
+        {"medications", req.Medications, existing.Medications},
+    } {
+        if f.sent != "" && strings.TrimSpace(f.sent) != strings.TrimSpace(f.current) {
+            fields = append(fields, f.name)
+        }
+    }
+    return fields
+}
+
 // DeletePatient deletes a patient
 func (h *PatientHandler) DeletePatient(c *gin.Context) {
     id, err := strconv.Atoi(c.Param("id"))
     if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid patient ID"}); return }
-    if err := h.patientService.DeletePatient(id); err != nil { if err.Error()=="patient not found" { c.JSON(http.StatusNotFound, gin.H{"error":"Patient not found"}); return }; c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to delete patient"}); return }
+    if err := h.patientService.DeletePatient(id, c.GetInt("user_id")); err != nil { if err.Error()=="patient not found" { c.JSON(http.StatusNotFound, gin.H{"error":"Patient not found"}); return }; c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to delete patient"}); return }
     c.JSON(http.StatusOK, gin.H{"message":"Patient deleted successfully"})
 }
 
-// GetPatients returns paginated patients
+// GetPatients searches patients with filters, relevance ranking and pagination
 func (h *PatientHandler) GetPatients(c *gin.Context) {
     var search PatientSearchRequest
-    if err := c.ShouldBindQuery(&search); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid query parameters"}); return }
+    if err := c.ShouldBindQuery(&search); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid query parameters", "details": err.Error()}); return }
     heIDStr := c.GetHeader("X-Healthcare-Entity-ID")
     if heIDStr == "" { c.JSON(http.StatusBadRequest, gin.H{"error":"Healthcare entity ID header is required"}); return }
     heID, err := strconv.Atoi(heIDStr); if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid healthcare entity ID"}); return }
     search.HealthcareEntityID = heID
+    applyDeprecatedSearchAliases(c, &search)
     if search.Limit <=0 || search.Limit>100 { search.Limit = 10 }
     if search.Offset <0 { search.Offset = 0 }
-    patients, err := h.patientService.GetPatients(search); if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to get patients"}); return }
-    var resp []PatientResponse
-    for _, p := range patients { resp = append(resp, p.ToPatientResponse()) }
-    total, err := h.patientService.GetPatientCount(heID); if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to get patient count"}); return }
-    c.JSON(http.StatusOK, gin.H{"patients":resp, "total_count": total, "limit": search.Limit, "offset": search.Offset, "has_more": search.Offset+search.Limit < total})
+
+    result, err := h.patientService.SearchPatients(search)
+    if err != nil {
+        if errors.Is(err, ErrInvalidCursor) {
+            c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid cursor", "details":"The cursor is malformed or does not match the search query"})
+            return
+        }
+        logging.LogError("Failed to search patients", "error", err, "healthcare_entity_id", heID)
+        c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to get patients"})
+        return
+    }
+
+    resp := make([]PatientResponse, 0, len(result.Patients))
+    for _, p := range result.Patients { resp = append(resp, p.ToPatientResponse()) }
+    c.JSON(http.StatusOK, gin.H{
+        "patients":    resp,
+        "total_count": result.TotalCount,
+        "limit":       search.Limit,
+        "offset":      search.Offset,
+        "has_more":    result.HasMore,
+        "next_cursor": result.NextCursor,
+    })
 }
 
-// GetPatientStats returns patient statistics
-func (h *PatientHandler) GetPatientStats(c *gin.Context) {
-    heIDStr := c.GetHeader("X-Healthcare-Entity-ID")
-    if heIDStr == "" { c.JSON(http.StatusBadRequest, gin.H{"error":"Healthcare entity ID header is required"}); return }
-    heID, err := strconv.Atoi(heIDStr); if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid healthcare entity ID"}); return }
-    total, err := h.patientService.GetPatientCount(heID); if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to get patient stats"}); return }
-    c.JSON(http.StatusOK, gin.H{"total_patients": total})
+// applyDeprecatedSearchAliases maps the old country and state parameters onto country_id and
+// state_id when those aren't set, and flags the response as using a deprecated parameter
+func applyDeprecatedSearchAliases(c *gin.Context, search *PatientSearchRequest) {
+    if search.Country == nil && search.State == nil { return }
+    if search.CountryID == nil { search.CountryID = search.Country }
+    if search.StateID == nil { search.StateID = search.State }
+    c.Header("Deprecation", "true")
+    logging.LogWarn("Deprecated patient search parameters used", "healthcare_entity_id", search.HealthcareEntityID)
 }
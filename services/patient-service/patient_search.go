@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or
+// does not match the sort order of the request
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// searchNameExpr is the normalized full name, matching idx_patients_name_trgm
+const searchNameExpr = "patient_search_normalize(first_name || ' ' || last_name)"
+
+// patientSearchCursor is the keyset position encoded into next_cursor.
+// Relevance-sorted pages carry Rank, recency-sorted pages carry CreatedAt.
+type patientSearchCursor struct {
+	Rank      string     `json:"r,omitempty"`
+	CreatedAt *time.Time `json:"c,omitempty"`
+	ID        int        `json:"i"`
+}
+
+// encodeSearchCursor serializes a cursor into an opaque URL-safe token
+func encodeSearchCursor(cursor patientSearchCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSearchCursor parses a token produced by encodeSearchCursor
+func decodeSearchCursor(token string) (*patientSearchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor patientSearchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// buildPrefixTSQuery turns free text into an AND-ed prefix tsquery ("ali:* & ben:*").
+// Only letters, digits and combining marks are kept so the result is always valid
+// tsquery syntax; accents and Arabic diacritics are folded later in SQL.
+func buildPrefixTSQuery(query string) string {
+	tokens := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	})
+	terms := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		terms = append(terms, token+":*")
+	}
+	return strings.Join(terms, " & ")
+}
+
+// escapeLike escapes LIKE wildcards so user input is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchPatients searches active patients of an entity with full-text, fuzzy name and
+// identifier matching, honouring all filters of the request. Results are ranked by
+// relevance when a query is given, otherwise by most recently created.
+func (s *PatientService) SearchPatients(searchReq PatientSearchRequest) (*PatientSearchResult, error) {
+	if searchReq.Limit <= 0 || searchReq.Limit > 100 {
+		searchReq.Limit = 10
+	}
+	if searchReq.Offset < 0 {
+		searchReq.Offset = 0
+	}
+
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// Healthcare entity filtering for multi-tenant isolation
+	conditions := []string{"is_active = true", "healthcare_entity_id = " + arg(searchReq.HealthcareEntityID)}
+
+	if searchReq.Gender != "" {
+		conditions = append(conditions, "LOWER(gender) = LOWER("+arg(searchReq.Gender)+")")
+	}
+	if searchReq.CountryID != nil {
+		conditions = append(conditions, "country_id = "+arg(*searchReq.CountryID))
+	}
+	if searchReq.StateID != nil {
+		conditions = append(conditions, "state_id = "+arg(*searchReq.StateID))
+	}
+	if searchReq.InsuranceTypeID != nil {
+		conditions = append(conditions, "insurance_type_id = "+arg(*searchReq.InsuranceTypeID))
+	}
+	if searchReq.BloodType != "" {
+		conditions = append(conditions, "blood_type = "+arg(searchReq.BloodType))
+	}
+	if searchReq.PreferredLanguage != "" {
+		conditions = append(conditions, "LOWER(preferred_language) = LOWER("+arg(searchReq.PreferredLanguage)+")")
+	}
+	if searchReq.AgeMin > 0 {
+		conditions = append(conditions, "date_of_birth <= CURRENT_DATE - make_interval(years => "+arg(searchReq.AgeMin)+")")
+	}
+	if searchReq.AgeMax > 0 {
+		conditions = append(conditions, "date_of_birth > CURRENT_DATE - make_interval(years => "+arg(searchReq.AgeMax+1)+")")
+	}
+
+	rankExpr := "0"
+	query := strings.TrimSpace(searchReq.Query)
+	if query != "" {
+		q := arg(query)
+		prefix := arg(escapeLike(strings.ToLower(query)) + "%")
+
+		matches := []string{
+			// Fuzzy name match (typos, partial words)
+			fmt.Sprintf("patient_search_normalize(%s) <%% %s", q, searchNameExpr),
+			// Identifier prefix match (emails and codes tokenize poorly)
+			fmt.Sprintf("LOWER(mrn) LIKE %s", prefix),
+			fmt.Sprintf("LOWER(COALESCE(patient_id, '')) LIKE %s", prefix),
+			fmt.Sprintf("LOWER(COALESCE(national_id, '')) LIKE %s", prefix),
+			fmt.Sprintf("LOWER(COALESCE(email, '')) LIKE %s", prefix),
+		}
+		ranks := []string{
+			fmt.Sprintf("word_similarity(patient_search_normalize(%s), %s)", q, searchNameExpr),
+			fmt.Sprintf(`CASE WHEN LOWER(mrn) = LOWER(%[1]s)
+				OR LOWER(COALESCE(patient_id, '')) = LOWER(%[1]s)
+				OR LOWER(COALESCE(national_id, '')) = LOWER(%[1]s)
+				OR LOWER(COALESCE(email, '')) = LOWER(%[1]s) THEN 1 ELSE 0 END`, q),
+		}
+
+		if tsq := buildPrefixTSQuery(query); tsq != "" {
+			tsquery := fmt.Sprintf("to_tsquery('simple', patient_search_normalize(%s))", arg(tsq))
+			matches = append(matches, "search_vector @@ "+tsquery)
+			ranks = append(ranks, fmt.Sprintf("ts_rank(search_vector, %s)", tsquery))
+		}
+
+		conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+		rankExpr = "ROUND((" + strings.Join(ranks, " + ") + ")::numeric, 6)"
+	}
+
+	whereClause := strings.Join(conditions, " AND ")
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM patients WHERE "+whereClause, args...).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	orderBy := "created_at DESC, id DESC"
+	if query != "" {
+		orderBy = "search_rank DESC, id DESC"
+	}
+
+	cursorClause := ""
+	offset := searchReq.Offset
+	if searchReq.Cursor != "" {
+		cursor, err := decodeSearchCursor(searchReq.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if query != "" {
+			if cursor.Rank == "" {
+				return nil, ErrInvalidCursor
+			}
+			cursorClause = fmt.Sprintf("WHERE (search_rank, id) < (%s::numeric, %s)", arg(cursor.Rank), arg(cursor.ID))
+		} else {
+			if cursor.CreatedAt == nil {
+				return nil, ErrInvalidCursor
+			}
+			cursorClause = fmt.Sprintf("WHERE (created_at, id) < (%s::timestamp, %s)", arg(*cursor.CreatedAt), arg(cursor.ID))
+		}
+		offset = 0
+	}
+
+	// Fetch one extra row to know whether another page exists
+	finalQuery := fmt.Sprintf(`
+		SELECT * FROM (
+			SELECT %s, %s AS search_rank
+			FROM patients
+			WHERE %s
+		) ranked
+		%s
+		ORDER BY %s
+		LIMIT %s OFFSET %s
+	`, patientColumns, rankExpr, whereClause, cursorClause, orderBy, arg(searchReq.Limit+1), arg(offset))
+
+	rows, err := s.db.Query(finalQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &PatientSearchResult{TotalCount: total}
+	var lastRank string
+	for rows.Next() {
+		var rank string
+		patient, err := scanPatient(rows, &rank)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Patients) == searchReq.Limit {
+			result.HasMore = true
+			break
+		}
+		result.Patients = append(result.Patients, *patient)
+		lastRank = rank
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if result.HasMore {
+		last := result.Patients[len(result.Patients)-1]
+		cursor := patientSearchCursor{ID: last.ID}
+		if query != "" {
+			cursor.Rank = lastRank
+		} else {
+			cursor.CreatedAt = &last.CreatedAt
+		}
+		result.NextCursor = encodeSearchCursor(cursor)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 3, 9, 14, 30, 0, 123456000, time.UTC)
+	tests := []struct {
+		name   string
+		cursor patientSearchCursor
+	}{
+		{"relevance", patientSearchCursor{Rank: "0.854210", ID: 42}},
+		{"recency", patientSearchCursor{CreatedAt: &createdAt, ID: 7}},
+		{"zero rank", patientSearchCursor{Rank: "0", ID: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := encodeSearchCursor(tt.cursor)
+			got, err := decodeSearchCursor(token)
+			if err != nil {
+				t.Fatalf("decodeSearchCursor(%q) error = %v", token, err)
+			}
+			if got.Rank != tt.cursor.Rank || got.ID != tt.cursor.ID {
+				t.Errorf("decoded %+v, want %+v", *got, tt.cursor)
+			}
+			if (got.CreatedAt == nil) != (tt.cursor.CreatedAt == nil) ||
+				got.CreatedAt != nil && !got.CreatedAt.Equal(*tt.cursor.CreatedAt) {
+				t.Errorf("decoded created at %v, want %v", got.CreatedAt, tt.cursor.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestDecodeSearchCursorRejectsInvalidTokens(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"i":1}`))},
+		{"not JSON", encode("hello")},
+		{"missing ID", encode(`{"r":"0.5"}`)},
+		{"zero ID", encode(`{"r":"0.5","i":0}`)},
+		{"negative ID", encode(`{"i":-3}`)},
+		{"wrong field type", encode(`{"i":"42"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeSearchCursor(tt.token); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeSearchCursor(%q) error = %v, want ErrInvalidCursor", tt.token, err)
+			}
+		})
+	}
+}
+
+func TestBuildPrefixTSQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"ali", "ali:*"},
+		{"  Ali   Ben ", "Ali:* & Ben:*"},
+		{"Benali-Alaoui", "Benali:* & Alaoui:*"},
+		{"O'Brien", "O:* & Brien:*"},
+		{"Élodie", "Élodie:*"},
+		{"مُحَمَّد", "مُحَمَّد:*"}, // diacritics are kept for folding in SQL
+		{"MRN-2025-000042", "MRN:* & 2025:* & 000042:*"},
+		{"ali & !ben | (x:*)", "ali:* & ben:* & x:*"}, // tsquery operators are dropped
+		{"", ""},
+		{"&|!():*", ""},
+	}
+	for _, tt := range tests {
+		if got := buildPrefixTSQuery(tt.query); got != tt.want {
+			t.Errorf("buildPrefixTSQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"AB123456", "AB123456"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`a\b`, `a\\b`},
+		{`%_\`, `\%\_\\`},
+	}
+	for _, tt := range tests {
+		if got := escapeLike(tt.s); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestApplyDeprecatedSearchAliases(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	one, two, three := 1, 2, 3
+	tests := []struct {
+		name            string
+		search          PatientSearchRequest
+		wantCountry     *int
+		wantState       *int
+		wantDeprecation bool
+	}{
+		{"current parameters", PatientSearchRequest{CountryID: &one, StateID: &two}, &one, &two, false},
+		{"deprecated parameters", PatientSearchRequest{Country: &one, State: &two}, &one, &two, true},
+		{"current parameters win", PatientSearchRequest{CountryID: &one, Country: &three}, &one, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			applyDeprecatedSearchAliases(c, &tt.search)
+			if tt.search.CountryID != tt.wantCountry || tt.search.StateID != tt.wantState {
+				t.Errorf("country_id, state_id = %v, %v, want %v, %v", tt.search.CountryID, tt.search.StateID, tt.wantCountry, tt.wantState)
+			}
+			if got := w.Header().Get("Deprecation") == "true"; got != tt.wantDeprecation {
+				t.Errorf("Deprecation header set = %v, want %v", got, tt.wantDeprecation)
+			}
+		})
+	}
+}
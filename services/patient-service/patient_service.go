@@ -11,14 +11,17 @@ import (
 	"regexp"
 	"strings"
 	"time"
+
+	logging "github.com/louhibi/healthcare-logging"
 )
 
 type PatientService struct {
-	db *sql.DB
+	db           *sql.DB
+	entityClient *EntityClient // Country codes for country-specific validation
 }
 
-func NewPatientService(db *sql.DB) *PatientService {
-	return &PatientService{db: db}
+func NewPatientService(db *sql.DB, entityClient *EntityClient) *PatientService {
+	return &PatientService{db: db, entityClient: entityClient}
 }
 
 // Form validation structures
@@ -32,6 +35,7 @@ type FormField struct {
 	IsCore       bool                   `json:"is_core"`
 	Options      []string               `json:"options"`
 	ValidationRules map[string]interface{} `json:"validation_rules"`
+	CustomValidation map[string]interface{} `json:"custom_validation"` // Entity overrides of ValidationRules
 }
 
 type FormMetadata struct {
@@ -63,14 +67,6 @@ func (s *PatientService) ValidatePatientRequest(req *PatientRequest, entityID in
 	}
 
 	var errors []ValidationError
-	
-	// Create a map for easy field lookup
-	fieldMap := make(map[string]FormField)
-	for _, field := range formConfig.Fields {
-		if field.IsEnabled {
-			fieldMap[field.Name] = field
-		}
-	}
 
 	// Validate each field based on configuration
 	patientData := map[string]interface{}{
@@ -102,17 +98,46 @@ func (s *PatientService) ValidatePatientRequest(req *PatientRequest, entityID in
 		"blood_type":                    req.BloodType,
 	}
 
-	// Validate each configured field
-	for fieldName, fieldConfig := range fieldMap {
-		value, exists := patientData[fieldName]
-		if !exists {
+	ctx := validationContext{
+		values: make(map[string]string, len(patientData)),
+		labels: make(map[string]string),
+		today:  time.Now().UTC(),
+	}
+	for fieldName, value := range patientData {
+		ctx.values[fieldName] = formValue(value)
+	}
+	needsCountry := false
+	for _, field := range formConfig.Fields {
+		if !field.IsEnabled {
+			continue
+		}
+		ctx.labels[field.Name] = field.DisplayName
+		if (field.Name == "national_id" || field.FieldType == "phone") && strings.TrimSpace(ctx.values[field.Name]) != "" {
+			needsCountry = true
+		}
+	}
+
+	// National IDs and phone numbers are checked against the rules of the patient's country.
+	// When it can't be resolved, only the country-independent checks apply.
+	if needsCountry && req.CountryID > 0 {
+		countryCode, err := s.entityClient.CountryCode(req.CountryID)
+		if err != nil {
+			logging.LogWarn("Failed to get patient country, skipping country-specific validation", "error", err, "country_id", req.CountryID)
+		}
+		ctx.countryCode = countryCode
+	}
+
+	// Validate each enabled field, in form order
+	for _, fieldConfig := range formConfig.Fields {
+		fieldName := fieldConfig.Name
+		if _, exists := patientData[fieldName]; !exists || !fieldConfig.IsEnabled {
 			continue
 		}
+		strValue := ctx.values[fieldName]
 
-		strValue := fmt.Sprintf("%v", value)
-		
 		// Check if required field is empty
-		if fieldConfig.IsRequired && strings.TrimSpace(strValue) == "" {
+		required := fieldConfig.IsRequired || effectiveRules(fieldConfig).requiredIf(ctx)
+		if required && strings.TrimSpace(strValue) == "" {
 			errors = append(errors, ValidationError{
 				Field:   fieldName,
 				Message: fmt.Sprintf("%s is required", fieldConfig.DisplayName),
@@ -125,8 +150,8 @@ func (s *PatientService) ValidatePatientRequest(req *PatientRequest, entityID in
 			continue
 		}
 
-		// Validate field type and options
-		if err := s.validateFieldValue(fieldName, strValue, fieldConfig); err != nil {
+		// Validate field type, options and rules
+		if err := s.validateFieldValue(fieldName, strValue, fieldConfig, ctx); err != nil {
 			errors = append(errors, ValidationError{
 				Field:   fieldName,
 				Message: err.Error(),
@@ -134,11 +159,29 @@ func (s *PatientService) ValidatePatientRequest(req *PatientRequest, entityID in
 		}
 	}
 
+	if len(errors) == 0 {
+		normalizePhoneNumbers(req, formConfig.Fields, ctx)
+	}
 	return errors, nil
 }
 
+// normalizePhoneNumbers stores the validated phone fields of a request in E.164 format. Numbers
+// that can't be put in E.164 (national numbers of an unknown country) are kept as typed.
+func normalizePhoneNumbers(req *PatientRequest, fields []FormField, ctx validationContext) {
+	phoneFields := map[string]*string{"phone": &req.Phone, "emergency_contact_phone": &req.EmergencyContactPhone}
+	for _, field := range fields {
+		value, ok := phoneFields[field.Name]
+		if !ok || !field.IsEnabled || field.FieldType != "phone" || strings.TrimSpace(*value) == "" {
+			continue
+		}
+		if number, err := parsePhoneNumber(*value, ctx.countryCode); err == nil && strings.HasPrefix(number, "+") {
+			*value = number
+		}
+	}
+}
+
 // validateFieldValue validates a specific field value against its configuration
-func (s *PatientService) validateFieldValue(fieldName, value string, field FormField) error {
+func (s *PatientService) validateFieldValue(fieldName, value string, field FormField, ctx validationContext) error {
 	// Email validation
 	if field.FieldType == "email" && value != "" {
 		emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
@@ -147,27 +190,38 @@ func (s *PatientService) validateFieldValue(fieldName, value string, field FormF
 		}
 	}
 
-	// Phone validation
+	// Phone validation, by the numbering plan of the patient's country
 	if field.FieldType == "phone" && value != "" {
-		// Basic phone validation - at least 10 digits
-		phoneRegex := regexp.MustCompile(`\d`)
-		digits := phoneRegex.FindAllString(value, -1)
-		if len(digits) < 10 {
-			return fmt.Errorf("%s must contain at least 10 digits", field.DisplayName)
+		if _, err := parsePhoneNumber(value, ctx.countryCode); err != nil {
+			return fmt.Errorf("%s %w", field.DisplayName, err)
+		}
+	}
+
+	// National ID validation, by the patient's country
+	if fieldName == "national_id" && value != "" {
+		if validate, ok := nationalIDValidators[ctx.countryCode]; ok {
+			if err := validate(value); err != nil {
+				return fmt.Errorf("%s %w", field.DisplayName, err)
+			}
 		}
 	}
 
 	// Select field validation (check if value is in options)
 	if field.FieldType == "select" && len(field.Options) > 0 {
+		valid := false
 		for _, option := range field.Options {
 			if option == value {
-				return nil // Valid option found
+				valid = true
+				break
 			}
 		}
-		return fmt.Errorf("%s must be one of: %s", field.DisplayName, strings.Join(field.Options, ", "))
+		if !valid {
+			return fmt.Errorf("%s must be one of: %s", field.DisplayName, strings.Join(field.Options, ", "))
+		}
 	}
 
-	return nil
+	// Configured validation rules
+	return effectiveRules(field).check(fieldName, field.DisplayName, value, ctx)
 }
 
 // getFormConfiguration fetches form configuration from user-service
@@ -220,18 +274,120 @@ func nullableString(s string) interface{} {
 	return strings.TrimSpace(s)
 }
 
-// CreatePatient creates a new patient
+// patientColumns is the column list matching scanPatient. The legacy medical_history,
+// allergies and medications fields are computed summaries of the structured clinical items.
+const patientColumns = `
+	id, healthcare_entity_id, patient_id, mrn, first_name, last_name, date_of_birth, gender, phone, COALESCE(email, '') AS email,
+	address, country_id, state_id, city_id, postal_code, nationality_id, preferred_language, marital_status,
+	occupation, insurance_type_id, policy_number, insurance_provider_id, national_id,
+	emergency_contact_name, emergency_contact_phone, emergency_contact_relationship,
+	` + medicalHistorySummary + ` AS medical_history,
+	` + allergiesSummary + ` AS allergies,
+	` + medicationsSummary + ` AS medications,
+	blood_type, is_active, created_at, updated_at, created_by`
+
+// Summary expressions for the legacy free-text fields, evaluated against the outer patients row
+const (
+	medicalHistorySummary = `COALESCE((
+		SELECT string_agg(pr.description || CASE WHEN pr.code <> '' THEN ' (' || pr.code || ')' ELSE '' END, '; ' ORDER BY pr.id)
+		FROM patient_problems pr
+		WHERE pr.patient_id = patients.id AND pr.deleted_at IS NULL AND pr.status <> 'entered_in_error'), '')`
+	allergiesSummary = `COALESCE((
+		SELECT string_agg(a.substance || CASE WHEN a.reaction <> '' THEN ' (' || a.reaction || ')' ELSE '' END, ', ' ORDER BY a.id)
+		FROM patient_allergies a
+		WHERE a.patient_id = patients.id AND a.deleted_at IS NULL AND a.status = 'active'), '')`
+	medicationsSummary = `COALESCE((
+		SELECT string_agg(concat_ws(' ', m.name, NULLIF(m.dose, ''), NULLIF(m.route, ''), NULLIF(m.frequency, '')), ', ' ORDER BY m.id)
+		FROM patient_medications m
+		WHERE m.patient_id = patients.id AND m.deleted_at IS NULL AND m.status = 'active'
+		  AND (m.stop_date IS NULL OR m.stop_date >= CURRENT_DATE)), '')`
+)
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPatient scans a row selected with patientColumns, followed by any extra destinations
+func scanPatient(row rowScanner, extra ...interface{}) (*Patient, error) {
+	patient := &Patient{}
+	dest := []interface{}{
+		&patient.ID,
+		&patient.HealthcareEntityID,
+		&patient.PatientID,
+		&patient.MRN,
+		&patient.FirstName,
+		&patient.LastName,
+		&patient.DateOfBirth,
+		&patient.Gender,
+		&patient.Phone,
+		&patient.Email,
+		&patient.Address,
+		&patient.CountryID,
+		&patient.StateID,
+		&patient.CityID,
+		&patient.PostalCode,
+		&patient.NationalityID,
+		&patient.PreferredLanguage,
+		&patient.MaritalStatus,
+		&patient.Occupation,
+		&patient.InsuranceTypeID,
+		&patient.PolicyNumber,
+		&patient.InsuranceProviderID,
+		&patient.NationalID,
+		&patient.EmergencyContactName,
+		&patient.EmergencyContactPhone,
+		&patient.EmergencyContactRelationship,
+		&patient.MedicalHistory,
+		&patient.Allergies,
+		&patient.Medications,
+		&patient.BloodType,
+		&patient.IsActive,
+		&patient.CreatedAt,
+		&patient.UpdatedAt,
+		&patient.CreatedBy,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return patient, nil
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CreatePatient creates a new patient and assigns the next MRN of its entity. Free-text
+// medical fields are not stored on the patient row; they seed structured clinical items in
+// the same transaction.
 func (s *PatientService) CreatePatient(patient *Patient) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertPatient(tx, patient); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertPatient inserts a patient and the clinical items seeded from its free-text medical
+// fields with tx, so it can take part in a larger transaction
+func insertPatient(tx *sql.Tx, patient *Patient) error {
 	query := `
 		INSERT INTO patients (
 			healthcare_entity_id, patient_id, first_name, last_name, date_of_birth, gender, phone, email,
 			address, country_id, state_id, city_id, postal_code, nationality_id, preferred_language, marital_status,
 			occupation, insurance_type_id, policy_number, insurance_provider_id, national_id,
 			emergency_contact_name, emergency_contact_phone, emergency_contact_relationship,
-			medical_history, allergies, medications, blood_type, is_active, created_at, updated_at, created_by
+			blood_type, is_active, created_at, updated_at, created_by, mrn
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32
-		) RETURNING id, created_at, updated_at
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29,
+			next_patient_mrn($1, $27)
+		) RETURNING id, mrn, created_at, updated_at
 	`
 	
 	now := time.Now()
@@ -239,7 +395,7 @@ func (s *PatientService) CreatePatient(patient *Patient) error {
 	patient.CreatedAt = now
 	patient.UpdatedAt = now
 
-	err := s.db.QueryRow(
+	err := tx.QueryRow(
 		query,
 		patient.HealthcareEntityID,
 		patient.PatientID,
@@ -265,99 +421,84 @@ func (s *PatientService) CreatePatient(patient *Patient) error {
 		patient.EmergencyContactName,
 		patient.EmergencyContactPhone,
 		patient.EmergencyContactRelationship,
-		patient.MedicalHistory,
-		patient.Allergies,
-		patient.Medications,
 		patient.BloodType,
 		patient.IsActive,
 		patient.CreatedAt,
 		patient.UpdatedAt,
 		patient.CreatedBy,
-	).Scan(&patient.ID, &patient.CreatedAt, &patient.UpdatedAt)
+	).Scan(&patient.ID, &patient.MRN, &patient.CreatedAt, &patient.UpdatedAt)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return seedFromLegacyText(tx, patient.ID, patient.CreatedBy, patient.MedicalHistory, patient.Allergies, patient.Medications)
 }
 
 // GetPatientByID gets patient by ID
 func (s *PatientService) GetPatientByID(id int) (*Patient, error) {
-	patient := &Patient{}
-	query := `
-		SELECT 
-			id, healthcare_entity_id, patient_id, first_name, last_name, date_of_birth, gender, phone, COALESCE(email, '') AS email,
-			address, country_id, state_id, city_id, postal_code, nationality_id, preferred_language, marital_status,
-			occupation, insurance_type_id, policy_number, insurance_provider_id, national_id,
-			emergency_contact_name, emergency_contact_phone, emergency_contact_relationship,
-			medical_history, allergies, medications, blood_type, is_active, created_at, updated_at, created_by
+	query := `SELECT ` + patientColumns + `
 		FROM patients
 		WHERE id = $1 AND is_active = true
 	`
 
-	err := s.db.QueryRow(query, id).Scan(
-		&patient.ID,
-		&patient.HealthcareEntityID,
-		&patient.PatientID,
-		&patient.FirstName,
-		&patient.LastName,
-		&patient.DateOfBirth,
-		&patient.Gender,
-		&patient.Phone,
-		&patient.Email,
-		&patient.Address,
-		&patient.CountryID,
-		&patient.StateID,
-		&patient.CityID,
-		&patient.PostalCode,
-		&patient.NationalityID,
-		&patient.PreferredLanguage,
-		&patient.MaritalStatus,
-		&patient.Occupation,
-		&patient.InsuranceTypeID,
-		&patient.PolicyNumber,
-		&patient.InsuranceProviderID,
-		&patient.NationalID,
-		&patient.EmergencyContactName,
-		&patient.EmergencyContactPhone,
-		&patient.EmergencyContactRelationship,
-		&patient.MedicalHistory,
-		&patient.Allergies,
-		&patient.Medications,
-		&patient.BloodType,
-		&patient.IsActive,
-		&patient.CreatedAt,
-		&patient.UpdatedAt,
-		&patient.CreatedBy,
-	)
+	patient, err := scanPatient(s.db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("patient not found")
+		}
+		return nil, err
+	}
 
 	// Debug logging
 	log.Printf("DEBUG: Patient %d loaded - CountryID: %d, StateID: %v, CityID: %v", 
 		patient.ID, patient.CountryID, patient.StateID, patient.CityID)
 
+	return patient, nil
+}
+
+// GetPatientIncludingInactive gets a patient by ID whether or not it has been deleted or
+// anonymised, for data subject requests and retention processing
+func (s *PatientService) GetPatientIncludingInactive(id int) (*Patient, error) {
+	patient, err := scanPatient(s.db.QueryRow(`SELECT `+patientColumns+` FROM patients WHERE id = $1`, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("patient not found")
 		}
 		return nil, err
 	}
+	return patient, nil
+}
 
+// GetPatientByMRN gets an active patient by medical record number within an entity
+func (s *PatientService) GetPatientByMRN(healthcareEntityID int, mrn string) (*Patient, error) {
+	query := `SELECT ` + patientColumns + `
+		FROM patients
+		WHERE healthcare_entity_id = $1 AND mrn = $2 AND is_active = true
+	`
+
+	patient, err := scanPatient(s.db.QueryRow(query, healthcareEntityID, mrn))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("patient not found")
+		}
+		return nil, err
+	}
 	return patient, nil
 }
 
-// UpdatePatient updates patient information
-func (s *PatientService) UpdatePatient(patient *Patient) error {
+// UpdatePatient updates patient information. The medical summary fields are computed
+// from structured clinical items and are not written here.
+func (s *PatientService) UpdatePatient(patient *Patient, updatedBy int) error {
 	query := `
 		UPDATE patients SET
 			first_name = $1, last_name = $2, date_of_birth = $3, gender = $4,
 			phone = $5, email = $6, address = $7, country_id = $8, state_id = $9, city_id = $10, postal_code = $11,
 			nationality_id = $12, preferred_language = $13, marital_status = $14,
-			occupation = $15, insurance = $16, policy_number = $17, insurance_provider = $18,
+			occupation = $15, insurance_type_id = $16, policy_number = $17, insurance_provider_id = $18,
 			national_id = $19, emergency_contact_name = $20, emergency_contact_phone = $21,
-			emergency_contact_relationship = $22, medical_history = $23, allergies = $24,
-			medications = $25, blood_type = $26, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $27 AND is_active = true
+			emergency_contact_relationship = $22, blood_type = $23, updated_by = $25, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $24 AND is_active = true
 		RETURNING updated_at
 	`
 
@@ -385,11 +526,9 @@ func (s *PatientService) UpdatePatient(patient *Patient) error {
 		patient.EmergencyContactName,
 		patient.EmergencyContactPhone,
 		patient.EmergencyContactRelationship,
-		patient.MedicalHistory,
-		patient.Allergies,
-		patient.Medications,
 		patient.BloodType,
 		patient.ID,
+		updatedBy,
 	).Scan(&patient.UpdatedAt)
 
 	if err != nil {
@@ -402,133 +541,15 @@ func (s *PatientService) UpdatePatient(patient *Patient) error {
 	return nil
 }
 
-// GetPatients gets patients with pagination and filtering
-func (s *PatientService) GetPatients(searchReq PatientSearchRequest) ([]Patient, error) {
-	var conditions []string
-	var args []interface{}
-	argIndex := 1
-
-	// Base query with healthcare entity filtering for multi-tenant isolation
-	baseQuery := `
-		SELECT 
-			id, healthcare_entity_id, patient_id, first_name, last_name, date_of_birth, gender, phone, COALESCE(email, '') AS email,
-			address, country_id, state_id, city_id, postal_code, nationality_id, preferred_language, marital_status,
-			occupation, insurance_type_id, policy_number, insurance_provider_id, national_id,
-			emergency_contact_name, emergency_contact_phone, emergency_contact_relationship,
-			medical_history, allergies, medications, blood_type, is_active, created_at, updated_at, created_by
-		FROM patients
-		WHERE is_active = true AND healthcare_entity_id = $1
-	`
-	
-	// Add healthcare entity ID as the first parameter
-	args = append(args, searchReq.HealthcareEntityID)
-	argIndex++
-
-	// Add comprehensive search conditions
-	if searchReq.Query != "" {
-		searchQuery := fmt.Sprintf("%%%s%%", strings.ToLower(searchReq.Query))
-		conditions = append(conditions, fmt.Sprintf(`
-			(LOWER(first_name) LIKE $%d OR 
-			 LOWER(last_name) LIKE $%d OR 
-			 LOWER(email) LIKE $%d OR 
-			 LOWER(patient_id) LIKE $%d OR
-			 phone LIKE $%d OR
-			 LOWER(address) LIKE $%d OR
-			 postal_code LIKE $%d OR
-			 LOWER(occupation) LIKE $%d OR
-			 LOWER(insurance) LIKE $%d OR
-			 LOWER(insurance_provider) LIKE $%d OR
-			 national_id LIKE $%d OR
-			 LOWER(medical_history) LIKE $%d OR
-			 LOWER(allergies) LIKE $%d OR
-			 LOWER(medications) LIKE $%d)
-		`, argIndex, argIndex, argIndex, argIndex, argIndex, argIndex, argIndex, argIndex, argIndex, argIndex, argIndex, argIndex, argIndex, argIndex))
-		args = append(args, searchQuery)
-		argIndex++
-	}
-
-	// Build final query
-	finalQuery := baseQuery
-	if len(conditions) > 0 {
-		finalQuery += " AND " + strings.Join(conditions, " AND ")
-	}
-
-	finalQuery += " ORDER BY created_at DESC"
-
-	// Add pagination
-	if searchReq.Limit <= 0 || searchReq.Limit > 100 {
-		searchReq.Limit = 10
-	}
-	if searchReq.Offset < 0 {
-		searchReq.Offset = 0
-	}
-
-	finalQuery += fmt.Sprintf(" LIMIT $%d OFFSET $%d", argIndex, argIndex+1)
-	args = append(args, searchReq.Limit, searchReq.Offset)
-
-	// Execute query
-	rows, err := s.db.Query(finalQuery, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var patients []Patient
-	for rows.Next() {
-		var patient Patient
-		err := rows.Scan(
-			&patient.ID,
-			&patient.HealthcareEntityID,
-			&patient.PatientID,
-			&patient.FirstName,
-			&patient.LastName,
-			&patient.DateOfBirth,
-			&patient.Gender,
-			&patient.Phone,
-			&patient.Email,
-			&patient.Address,
-			&patient.CountryID,
-			&patient.StateID,
-			&patient.CityID,
-			&patient.PostalCode,
-			&patient.NationalityID,
-			&patient.PreferredLanguage,
-			&patient.MaritalStatus,
-			&patient.Occupation,
-			&patient.InsuranceTypeID,
-			&patient.PolicyNumber,
-			&patient.InsuranceProviderID,
-			&patient.NationalID,
-			&patient.EmergencyContactName,
-			&patient.EmergencyContactPhone,
-			&patient.EmergencyContactRelationship,
-			&patient.MedicalHistory,
-			&patient.Allergies,
-			&patient.Medications,
-			&patient.BloodType,
-			&patient.IsActive,
-			&patient.CreatedAt,
-			&patient.UpdatedAt,
-			&patient.CreatedBy,
-		)
-		if err != nil {
-			return nil, err
-		}
-		patients = append(patients, patient)
-	}
-
-	return patients, nil
-}
-
 // DeletePatient soft deletes a patient
-func (s *PatientService) DeletePatient(id int) error {
+func (s *PatientService) DeletePatient(id int, deletedBy int) error {
 	query := `
 		UPDATE patients
-		SET is_active = false, updated_at = CURRENT_TIMESTAMP
+		SET is_active = false, updated_by = $2, updated_at = CURRENT_TIMESTAMP
 		WHERE id = $1 AND is_active = true
 	`
 
-	result, err := s.db.Exec(query, id)
+	result, err := s.db.Exec(query, id, deletedBy)
 	if err != nil {
 		return err
 	}
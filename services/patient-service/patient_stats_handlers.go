@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// statsLocales are the languages location-service names are available in
+var statsLocales = map[string]bool{"en": true, "fr": true, "ar": true}
+
+// PatientStatsHandler groups patient statistics handlers
+type PatientStatsHandler struct {
+	statsService *PatientStatsService
+}
+
+// NewPatientStatsHandler constructs a new PatientStatsHandler
+func NewPatientStatsHandler(statsService *PatientStatsService) *PatientStatsHandler {
+	return &PatientStatsHandler{statsService: statsService}
+}
+
+// statsLocale reads ?locale=, else the first language of Accept-Language, else English
+func statsLocale(c *gin.Context) string {
+	locale := c.Query("locale")
+	if locale == "" {
+		first, _, _ := strings.Cut(c.GetHeader("Accept-Language"), ",")
+		locale, _, _ = strings.Cut(strings.TrimSpace(first), "-")
+	}
+	locale = strings.ToLower(locale)
+	if !statsLocales[locale] {
+		return "en"
+	}
+	return locale
+}
+
+// GetPatientStats returns the demographic statistics of the caller's entity (?interval=week|month,
+// ?from=, ?to= for registrations, ?locale=, ?format=csv)
+func (h *PatientStatsHandler) GetPatientStats(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	filter := PatientStatsFilter{Interval: c.DefaultQuery("interval", "month"), Locale: statsLocale(c)}
+	if filter.Interval != "week" && filter.Interval != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interval", "details": "interval must be week or month"})
+		return
+	}
+	// Registrations default to the last 12 weeks or months, the current one included. The
+	// default end is truncated to the cache TTL so that it doesn't change the cache key of
+	// every request.
+	filter.To = time.Now().UTC().Truncate(patientStatsTTL)
+	if to != nil {
+		filter.To = to.UTC()
+	}
+	if from != nil {
+		filter.From = from.UTC()
+	} else if filter.Interval == "week" {
+		filter.From = periodStart(filter.To, "week").AddDate(0, 0, -7*11)
+	} else {
+		filter.From = periodStart(filter.To, "month").AddDate(0, -11, 0)
+	}
+	if filter.From.After(filter.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period", "details": "from must be before to"})
+		return
+	}
+	if registrationBucketCount(periodStart(filter.From, filter.Interval), periodStart(filter.To, filter.Interval), filter.Interval) > maxRegistrationBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Period too long", "details": fmt.Sprintf("registrations cover at most %d weeks or months", maxRegistrationBuckets)})
+		return
+	}
+
+	stats, err := h.statsService.GetStatistics(entityID, filter)
+	if err != nil {
+		logging.LogError("Failed to get patient statistics", "error", err, "healthcare_entity_id", entityID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get patient stats"})
+		return
+	}
+
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, stats)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=patient-stats-%d-%s.csv", entityID, stats.GeneratedAt.Format("2006-01-02")))
+	w := csv.NewWriter(c.Writer)
+	if err := w.WriteAll(stats.csvRows()); err != nil {
+		// The status is already sent, the client gets a truncated file
+		logging.LogError("Failed to write patient statistics CSV", "error", err, "healthcare_entity_id", entityID)
+		c.Abort()
+	}
+}
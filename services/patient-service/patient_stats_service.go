@@ -0,0 +1,303 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// patientStatsTTL is how long computed statistics are served from cache. Dashboards refresh
+// often; a minute keeps them cheap without hiding new registrations for long.
+const patientStatsTTL = time.Minute
+
+// maxRegistrationBuckets caps the weeks or months of a registrations series
+const maxRegistrationBuckets = 366
+
+// ageBands are the age groups of the age distribution; max is exclusive
+var ageBands = []struct {
+	label    string
+	min, max int
+}{
+	{"0-4", 0, 5}, {"5-17", 5, 18}, {"18-29", 18, 30}, {"30-44", 30, 45},
+	{"45-64", 45, 65}, {"65-79", 65, 80}, {"80+", 80, 1 << 30},
+}
+
+type cachedPatientStats struct {
+	stats     *PatientStatistics
+	expiresAt time.Time
+}
+
+// PatientStatsService computes the demographic statistics of an entity's patients
+type PatientStatsService struct {
+	db           *sql.DB
+	entityClient *EntityClient
+
+	mu    sync.Mutex
+	cache map[string]cachedPatientStats
+}
+
+// NewPatientStatsService creates a new patient statistics service
+func NewPatientStatsService(db *sql.DB, entityClient *EntityClient) *PatientStatsService {
+	return &PatientStatsService{db: db, entityClient: entityClient, cache: map[string]cachedPatientStats{}}
+}
+
+// periodStart truncates a time to the start of its week (Monday, like date_trunc) or month
+func periodStart(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	if interval == "week" {
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// registrationBucketCount returns the number of weeks or months from one period start to another
+func registrationBucketCount(from, to time.Time, interval string) int {
+	if interval == "week" {
+		return int(to.Sub(from).Hours()/(24*7)) + 1
+	}
+	return (to.Year()-from.Year())*12 + int(to.Month()-from.Month()) + 1
+}
+
+// GetStatistics returns the statistics of an entity, from cache when computed less than
+// patientStatsTTL ago. Location names are left empty when location-service cannot be reached;
+// such results are not cached.
+func (s *PatientStatsService) GetStatistics(healthcareEntityID int, filter PatientStatsFilter) (*PatientStatistics, error) {
+	key := fmt.Sprintf("%d|%s|%s|%s|%s", healthcareEntityID, filter.Interval,
+		filter.From.Format(time.RFC3339), filter.To.Format(time.RFC3339), filter.Locale)
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.stats, nil
+	}
+
+	stats, err := s.computeStatistics(healthcareEntityID, filter)
+	if err != nil {
+		return nil, err
+	}
+	if stats.NamesResolved {
+		s.mu.Lock()
+		for k, entry := range s.cache {
+			if time.Now().After(entry.expiresAt) {
+				delete(s.cache, k)
+			}
+		}
+		s.cache[key] = cachedPatientStats{stats: stats, expiresAt: time.Now().Add(patientStatsTTL)}
+		s.mu.Unlock()
+	}
+	return stats, nil
+}
+
+func (s *PatientStatsService) computeStatistics(healthcareEntityID int, filter PatientStatsFilter) (*PatientStatistics, error) {
+	stats := &PatientStatistics{HealthcareEntityID: healthcareEntityID, GeneratedAt: time.Now().UTC()}
+
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE is_active), COUNT(*) FILTER (WHERE NOT is_active),
+			COUNT(*) FILTER (WHERE anonymised_at IS NOT NULL)
+		FROM patients WHERE healthcare_entity_id = $1
+	`, healthcareEntityID).Scan(&stats.ActivePatients, &stats.InactivePatients, &stats.AnonymisedPatients)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count patients: %w", err)
+	}
+	stats.TotalPatients = stats.ActivePatients
+
+	if stats.AgeBands, err = s.ageDistribution(healthcareEntityID); err != nil {
+		return nil, err
+	}
+	if stats.Genders, err = s.genderDistribution(healthcareEntityID); err != nil {
+		return nil, err
+	}
+
+	locations := []struct {
+		column string
+		kind   string // location-service collection
+		dest   *[]StatsCount
+	}{
+		{"nationality_id", "nationalities", &stats.Nationalities},
+		{"insurance_type_id", "insurance-types", &stats.InsuranceTypes},
+		{"insurance_provider_id", "insurance-providers", &stats.InsuranceProviders},
+		{"state_id", "states", &stats.States},
+		{"city_id", "cities", &stats.Cities},
+	}
+	stats.NamesResolved = true
+	for _, l := range locations {
+		if *l.dest, err = s.locationDistribution(healthcareEntityID, l.column); err != nil {
+			return nil, err
+		}
+		if stats.NamesResolved && !s.resolveNames(*l.dest, l.kind, filter.Locale) {
+			stats.NamesResolved = false
+		}
+	}
+
+	if stats.Registrations, err = s.registrations(healthcareEntityID, filter); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ageDistribution counts active patients per age band, empty bands included
+func (s *PatientStatsService) ageDistribution(healthcareEntityID int) ([]StatsCount, error) {
+	rows, err := s.db.Query(`
+		SELECT date_part('year', age(CURRENT_DATE, date_of_birth))::INT, COUNT(*)
+		FROM patients WHERE healthcare_entity_id = $1 AND is_active = true
+		GROUP BY 1
+	`, healthcareEntityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count patients by age: %w", err)
+	}
+	defer rows.Close()
+
+	bands := make([]StatsCount, len(ageBands))
+	for i, band := range ageBands {
+		bands[i].Value = band.label
+	}
+	for rows.Next() {
+		var age, count int
+		if err := rows.Scan(&age, &count); err != nil {
+			return nil, err
+		}
+		for i, band := range ageBands {
+			if age >= band.min && age < band.max {
+				bands[i].Count += count
+				break
+			}
+		}
+	}
+	return bands, rows.Err()
+}
+
+// genderDistribution counts active patients per gender
+func (s *PatientStatsService) genderDistribution(healthcareEntityID int) ([]StatsCount, error) {
+	rows, err := s.db.Query(`
+		SELECT gender, COUNT(*) FROM patients
+		WHERE healthcare_entity_id = $1 AND is_active = true
+		GROUP BY gender ORDER BY COUNT(*) DESC, gender
+	`, healthcareEntityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count patients by gender: %w", err)
+	}
+	defer rows.Close()
+
+	genders := []StatsCount{}
+	for rows.Next() {
+		var g StatsCount
+		if err := rows.Scan(&g.Value, &g.Count); err != nil {
+			return nil, err
+		}
+		genders = append(genders, g)
+	}
+	return genders, rows.Err()
+}
+
+// locationDistribution counts active patients per value of a location-service reference
+// column, most common first. column must be a trusted column name.
+func (s *PatientStatsService) locationDistribution(healthcareEntityID int, column string) ([]StatsCount, error) {
+	rows, err := s.db.Query(`
+		SELECT `+column+`, COUNT(*) FROM patients
+		WHERE healthcare_entity_id = $1 AND is_active = true
+		GROUP BY 1 ORDER BY COUNT(*) DESC, 1
+	`, healthcareEntityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count patients by %s: %w", column, err)
+	}
+	defer rows.Close()
+
+	counts := []StatsCount{}
+	for rows.Next() {
+		var c StatsCount
+		if err := rows.Scan(&c.ID, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// resolveNames sets the location-service names of the counted IDs, reporting whether they
+// could be looked up
+func (s *PatientStatsService) resolveNames(counts []StatsCount, kind, locale string) bool {
+	ids := []int{}
+	for _, c := range counts {
+		if c.ID != nil {
+			ids = append(ids, *c.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return true
+	}
+	sort.Ints(ids)
+	names, err := s.entityClient.LocationNames(kind, ids, locale)
+	if err != nil {
+		logging.LogWarn("Failed to resolve location names for patient statistics", "kind", kind, "error", err)
+		return false
+	}
+	for i := range counts {
+		if counts[i].ID != nil {
+			counts[i].Name = names[*counts[i].ID]
+		}
+	}
+	return true
+}
+
+// registrations counts the patients registered in each week or month of the filter's period
+func (s *PatientStatsService) registrations(healthcareEntityID int, filter PatientStatsFilter) (RegistrationStats, error) {
+	result := RegistrationStats{Interval: filter.Interval, From: filter.From, To: filter.To, Buckets: []RegistrationBucket{}}
+	rows, err := s.db.Query(`
+		SELECT b.period, COUNT(p.id)
+		FROM generate_series($2::TIMESTAMP, $3::TIMESTAMP, ('1 ' || $4)::INTERVAL) AS b(period)
+		LEFT JOIN patients p ON p.healthcare_entity_id = $1
+			AND date_trunc($4, p.created_at) = b.period AND p.created_at <= $5
+		GROUP BY b.period ORDER BY b.period
+	`, healthcareEntityID, periodStart(filter.From, filter.Interval), periodStart(filter.To, filter.Interval),
+		filter.Interval, filter.To.UTC())
+	if err != nil {
+		return result, fmt.Errorf("failed to count registrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b RegistrationBucket
+		if err := rows.Scan(&b.PeriodStart, &b.Count); err != nil {
+			return result, err
+		}
+		result.Buckets = append(result.Buckets, b)
+	}
+	return result, rows.Err()
+}
+
+// csvRows flattens the statistics into section, value, id, name, count rows
+func (stats *PatientStatistics) csvRows() [][]string {
+	rows := [][]string{
+		{"section", "value", "id", "name", "count"},
+		{"summary", "active", "", "", strconv.Itoa(stats.ActivePatients)},
+		{"summary", "inactive", "", "", strconv.Itoa(stats.InactivePatients)},
+		{"summary", "anonymised", "", "", strconv.Itoa(stats.AnonymisedPatients)},
+	}
+	sections := []struct {
+		name   string
+		counts []StatsCount
+	}{
+		{"age_band", stats.AgeBands}, {"gender", stats.Genders}, {"nationality", stats.Nationalities},
+		{"insurance_type", stats.InsuranceTypes}, {"insurance_provider", stats.InsuranceProviders},
+		{"state", stats.States}, {"city", stats.Cities},
+	}
+	for _, section := range sections {
+		for _, c := range section.counts {
+			id := ""
+			if c.ID != nil {
+				id = strconv.Itoa(*c.ID)
+			}
+			rows = append(rows, []string{section.name, c.Value, id, c.Name, strconv.Itoa(c.Count)})
+		}
+	}
+	for _, b := range stats.Registrations.Buckets {
+		rows = append(rows, []string{"registrations_" + stats.Registrations.Interval, b.PeriodStart.Format("2006-01-02"), "", "", strconv.Itoa(b.Count)})
+	}
+	return rows
+}
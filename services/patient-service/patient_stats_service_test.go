@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPeriodStart(t *testing.T) {
+	tests := []struct {
+		at       time.Time
+		interval string
+		want     time.Time
+	}{
+		{time.Date(2025, 3, 5, 15, 0, 0, 0, time.UTC), "week", time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 3, 9, 23, 0, 0, 0, time.UTC), "week", time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC), "week", time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 3, 5, 15, 0, 0, 0, time.UTC), "month", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 3, 1, 0, 30, 0, 0, time.FixedZone("Casablanca", 3600)), "month", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := periodStart(tt.at, tt.interval); !got.Equal(tt.want) {
+			t.Errorf("periodStart(%v, %s) = %v, want %v", tt.at, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestRegistrationBucketCount(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		to       time.Time
+		interval string
+		want     int
+	}{
+		{from, "week", 1},
+		{from.AddDate(0, 0, 7*51), "week", 52},
+		{from, "month", 1},
+		{time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC), "month", 24},
+	}
+	for _, tt := range tests {
+		if got := registrationBucketCount(from, tt.to, tt.interval); got != tt.want {
+			t.Errorf("registrationBucketCount(%s to %v) = %d, want %d", tt.interval, tt.to, got, tt.want)
+		}
+	}
+	tenYears := periodStart(from.AddDate(10, 0, 0), "week")
+	if registrationBucketCount(from, tenYears, "week") <= maxRegistrationBuckets {
+		t.Error("ten years of weeks fit under maxRegistrationBuckets")
+	}
+}
+
+func TestAgeDistributionKeepsEmptyBands(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT date_part\('year', age\(CURRENT_DATE, date_of_birth\)\)`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"age", "count"}).AddRow(4, 2).AddRow(17, 1).AddRow(18, 3).AddRow(92, 1))
+
+	bands, err := NewPatientStatsService(db, nil).ageDistribution(1)
+	if err != nil {
+		t.Fatalf("ageDistribution error = %v", err)
+	}
+	want := map[string]int{"0-4": 2, "5-17": 1, "18-29": 3, "30-44": 0, "45-64": 0, "65-79": 0, "80+": 1}
+	if len(bands) != len(ageBands) {
+		t.Fatalf("got %d bands, want %d", len(bands), len(ageBands))
+	}
+	for _, band := range bands {
+		if band.Count != want[band.Value] {
+			t.Errorf("band %s = %d, want %d", band.Value, band.Count, want[band.Value])
+		}
+	}
+}
+
+func TestGetStatisticsServesCache(t *testing.T) {
+	filter := PatientStatsFilter{Interval: "month", From: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC), Locale: "fr"}
+	cached := &PatientStatistics{HealthcareEntityID: 1, ActivePatients: 12, NamesResolved: true}
+
+	t.Run("fresh", func(t *testing.T) {
+		db, _ := newMockDB(t)
+		service := NewPatientStatsService(db, nil)
+		service.cache["1|month|2025-01-01T00:00:00Z|2025-06-30T00:00:00Z|fr"] = cachedPatientStats{stats: cached, expiresAt: time.Now().Add(time.Minute)}
+		if got, err := service.GetStatistics(1, filter); err != nil || got != cached {
+			t.Errorf("GetStatistics = (%+v, %v), want the cached statistics", got, err)
+		}
+	})
+	t.Run("expired", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FILTER \(WHERE is_active\)`).WithArgs(1).WillReturnError(errors.New("connection reset"))
+		service := NewPatientStatsService(db, nil)
+		service.cache["1|month|2025-01-01T00:00:00Z|2025-06-30T00:00:00Z|fr"] = cachedPatientStats{stats: cached, expiresAt: time.Now().Add(-time.Second)}
+		if _, err := service.GetStatistics(1, filter); err == nil {
+			t.Error("GetStatistics served expired statistics")
+		}
+	})
+	t.Run("other locale", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FILTER \(WHERE is_active\)`).WithArgs(1).WillReturnError(errors.New("connection reset"))
+		service := NewPatientStatsService(db, nil)
+		service.cache["1|month|2025-01-01T00:00:00Z|2025-06-30T00:00:00Z|fr"] = cachedPatientStats{stats: cached, expiresAt: time.Now().Add(time.Minute)}
+		arabic := filter
+		arabic.Locale = "ar"
+		if _, err := service.GetStatistics(1, arabic); err == nil {
+			t.Error("GetStatistics served statistics cached for another locale")
+		}
+	})
+}
+
+func TestPatientStatisticsCSVRows(t *testing.T) {
+	id := 7
+	stats := &PatientStatistics{
+		ActivePatients: 12, InactivePatients: 2,
+		Genders:       []StatsCount{{Value: "female", Count: 7}},
+		Cities:        []StatsCount{{Value: "7", ID: &id, Name: "Rabat", Count: 4}},
+		Registrations: RegistrationStats{Interval: "month", Buckets: []RegistrationBucket{{PeriodStart: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), Count: 5}}},
+	}
+	rows := stats.csvRows()
+	want := [][]string{
+		{"section", "value", "id", "name", "count"},
+		{"summary", "active", "", "", "12"},
+		{"summary", "inactive", "", "", "2"},
+		{"summary", "anonymised", "", "", "0"},
+		{"gender", "female", "", "", "7"},
+		{"city", "7", "7", "Rabat", "4"},
+		{"registrations_month", "2025-03-01", "", "", "5"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("csvRows = %v, want %v", rows, want)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if rows[i][j] != want[i][j] {
+				t.Errorf("row %d = %v, want %v", i, rows[i], want[i])
+				break
+			}
+		}
+	}
+}
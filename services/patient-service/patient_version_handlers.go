@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// parseAsOf reads a point in time given as an RFC3339 timestamp or a YYYY-MM-DD date, which
+// stands for the end of that day
+func parseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+// versionParam reads a version number from a route or query parameter
+func versionParam(c *gin.Context, name, value string) (int, bool) {
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name, "details": name + " must be a version number"})
+		return 0, false
+	}
+	return version, true
+}
+
+func respondVersionError(c *gin.Context, err error, operation string, patientID int) {
+	switch {
+	case errors.Is(err, ErrPatientVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient version not found"})
+	case errors.Is(err, ErrPatientVersionCurrent):
+		c.JSON(http.StatusConflict, gin.H{"error": "Nothing to restore", "details": err.Error()})
+	default:
+		logging.LogError("Failed to "+operation, "error", err, "patient_id", patientID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// getPatientAsOf returns the patient as it was at ?as_of=. The version served is identified
+// by the X-Patient-Version header.
+func (h *PatientHandler) getPatientAsOf(c *gin.Context, asOf string) {
+	at, err := parseAsOf(asOf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid as_of", "details": "as_of must be an RFC3339 timestamp or a YYYY-MM-DD date"})
+		return
+	}
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	version, err := h.versionService.GetVersionAt(patient.ID, at)
+	if err != nil {
+		if errors.Is(err, ErrPatientVersionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Patient version not found", "details": "The patient had no recorded version at that time"})
+			return
+		}
+		respondVersionError(c, err, "get patient version", patient.ID)
+		return
+	}
+	past, err := h.versionService.VersionPatient(version)
+	if err != nil {
+		respondVersionError(c, err, "get patient version", patient.ID)
+		return
+	}
+	c.Header("X-Patient-Version", strconv.Itoa(version.Version))
+	c.JSON(http.StatusOK, past.ToPatientResponse())
+}
+
+// GetPatientVersions lists the versions of a patient, oldest first
+func (h *PatientHandler) GetPatientVersions(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	versions, err := h.versionService.ListVersions(patient.ID, false)
+	if err != nil {
+		respondVersionError(c, err, "get patient versions", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "versions": versions})
+}
+
+// GetPatientVersion returns one version of a patient with the patient as it was then
+func (h *PatientHandler) GetPatientVersion(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	number, ok := versionParam(c, "version", c.Param("version"))
+	if !ok {
+		return
+	}
+	version, err := h.versionService.GetVersion(patient.ID, number)
+	if err != nil {
+		respondVersionError(c, err, "get patient version", patient.ID)
+		return
+	}
+	past, err := h.versionService.VersionPatient(version)
+	if err != nil {
+		respondVersionError(c, err, "get patient version", patient.ID)
+		return
+	}
+	version.Data = nil
+	c.JSON(http.StatusOK, gin.H{"version": version, "patient": past.ToPatientResponse()})
+}
+
+// DiffPatientVersions lists the fields that differ between ?from= and ?to= versions
+func (h *PatientHandler) DiffPatientVersions(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	from, ok := versionParam(c, "from", c.Query("from"))
+	if !ok {
+		return
+	}
+	to, ok := versionParam(c, "to", c.Query("to"))
+	if !ok {
+		return
+	}
+	diff, err := h.versionService.Diff(patient.ID, from, to)
+	if err != nil {
+		respondVersionError(c, err, "compare patient versions", patient.ID)
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+// RestorePatientVersion writes the demographics of a version back to the patient (admin only)
+func (h *PatientHandler) RestorePatientVersion(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	number, ok := versionParam(c, "version", c.Param("version"))
+	if !ok {
+		return
+	}
+	var req PatientVersionRestoreRequest
+	if err := h.parseAndValidateRequest(c, &req, "RestorePatientVersion"); err != nil {
+		return
+	}
+
+	version, err := h.versionService.GetVersion(patient.ID, number)
+	if err != nil {
+		respondVersionError(c, err, "restore patient version", patient.ID)
+		return
+	}
+	// The email may have been given to another patient since
+	var restored struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(version.Data, &restored); err != nil {
+		respondVersionError(c, err, "restore patient version", patient.ID)
+		return
+	}
+	if restored.Email != "" {
+		exists, err := h.patientService.EmailExists(restored.Email, patient.HealthcareEntityID, patient.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		if exists {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists", "details": "The version's email now belongs to another patient"})
+			return
+		}
+	}
+
+	userID := c.GetInt("user_id")
+	newVersion, err := h.versionService.RestoreVersion(patient.ID, number, userID, req.Reason)
+	if err != nil {
+		respondVersionError(c, err, "restore patient version", patient.ID)
+		return
+	}
+	current, err := h.patientService.GetPatientByID(patient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get patient"})
+		return
+	}
+
+	logging.LogInfo("Patient version restored",
+		"patient_id", patient.ID,
+		"restored_from_version", number,
+		"version", newVersion.Version,
+		"user_id", userID,
+	)
+	c.JSON(http.StatusOK, gin.H{"version": newVersion, "patient": current.ToPatientResponse()})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrPatientVersionNotFound = errors.New("patient version not found")
+	ErrPatientVersionCurrent  = errors.New("patient already matches this version")
+)
+
+// restorableColumns are the patient columns written back by a restore: the demographics
+// UpdatePatient writes. Identifiers, status and audit columns are left as they are.
+var restorableColumns = []string{
+	"first_name", "last_name", "date_of_birth", "gender", "phone", "email", "address",
+	"country_id", "state_id", "city_id", "postal_code", "nationality_id", "preferred_language",
+	"marital_status", "occupation", "insurance_type_id", "policy_number", "insurance_provider_id",
+	"national_id", "emergency_contact_name", "emergency_contact_phone", "emergency_contact_relationship",
+	"blood_type",
+}
+
+// versionDiffIgnored are the columns that change with every version
+var versionDiffIgnored = map[string]bool{"updated_at": true, "updated_by": true}
+
+// PatientVersionService reads and restores the versions of patient rows. Versions are recorded
+// by the record_patient_version trigger on every insert and update of a patient.
+type PatientVersionService struct {
+	db *sql.DB
+}
+
+// NewPatientVersionService creates a new patient version service
+func NewPatientVersionService(db *sql.DB) *PatientVersionService {
+	return &PatientVersionService{db: db}
+}
+
+const patientVersionColumns = `id, patient_id, version, valid_from, valid_to, change_type, changed_fields,
+	changed_by, restored_from_version, change_reason`
+
+func scanPatientVersion(row rowScanner, extra ...interface{}) (*PatientVersion, error) {
+	v := &PatientVersion{}
+	dest := []interface{}{&v.ID, &v.PatientID, &v.Version, &v.ValidFrom, &v.ValidTo, &v.ChangeType,
+		pq.Array(&v.ChangedFields), &v.ChangedBy, &v.RestoredFromVersion, &v.ChangeReason}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// ListVersions returns the versions of a patient, oldest first. The row data is included
+// only when withData is set.
+func (s *PatientVersionService) ListVersions(patientID int, withData bool) ([]PatientVersion, error) {
+	rows, err := s.db.Query(`SELECT `+patientVersionColumns+`, data
+		FROM patient_versions WHERE patient_id = $1 ORDER BY version`, patientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get patient versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []PatientVersion{}
+	for rows.Next() {
+		var data []byte
+		v, err := scanPatientVersion(rows, &data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan patient version: %w", err)
+		}
+		if withData {
+			v.Data = data
+		}
+		versions = append(versions, *v)
+	}
+	return versions, rows.Err()
+}
+
+// GetVersion returns a version of a patient with its row data
+func (s *PatientVersionService) GetVersion(patientID, version int) (*PatientVersion, error) {
+	var data []byte
+	v, err := scanPatientVersion(s.db.QueryRow(`SELECT `+patientVersionColumns+`, data
+		FROM patient_versions WHERE patient_id = $1 AND version = $2`, patientID, version), &data)
+	if err == sql.ErrNoRows {
+		return nil, ErrPatientVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get patient version: %w", err)
+	}
+	v.Data = data
+	return v, nil
+}
+
+// GetVersionAt returns the version of a patient that was current at the given time
+func (s *PatientVersionService) GetVersionAt(patientID int, at time.Time) (*PatientVersion, error) {
+	var data []byte
+	v, err := scanPatientVersion(s.db.QueryRow(`SELECT `+patientVersionColumns+`, data
+		FROM patient_versions
+		WHERE patient_id = $1 AND valid_from <= $2 AND (valid_to IS NULL OR valid_to > $2)`, patientID, at.UTC()), &data)
+	if err == sql.ErrNoRows {
+		return nil, ErrPatientVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get patient version: %w", err)
+	}
+	v.Data = data
+	return v, nil
+}
+
+// VersionPatient returns the patient as recorded in a version. The medical summary fields are
+// computed from the current clinical items; their past states are in the clinical history.
+func (s *PatientVersionService) VersionPatient(v *PatientVersion) (*Patient, error) {
+	// The summary expressions refer to the row as patients
+	patient, err := scanPatient(s.db.QueryRow(`SELECT `+patientColumns+`
+		FROM jsonb_populate_record(NULL::patients, $1::JSONB) AS patients`, string(v.Data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patient version: %w", err)
+	}
+	return patient, nil
+}
+
+// Diff lists the fields that differ between two versions of a patient
+func (s *PatientVersionService) Diff(patientID, fromVersion, toVersion int) (*PatientVersionDiff, error) {
+	from, err := s.GetVersion(patientID, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.GetVersion(patientID, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var fromFields, toFields map[string]json.RawMessage
+	if err := json.Unmarshal(from.Data, &fromFields); err != nil {
+		return nil, fmt.Errorf("failed to decode patient version %d: %w", fromVersion, err)
+	}
+	if err := json.Unmarshal(to.Data, &toFields); err != nil {
+		return nil, fmt.Errorf("failed to decode patient version %d: %w", toVersion, err)
+	}
+
+	fields := []string{}
+	for field := range fromFields {
+		fields = append(fields, field)
+	}
+	for field := range toFields {
+		if _, ok := fromFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	diff := &PatientVersionDiff{PatientID: patientID, FromVersion: fromVersion, ToVersion: toVersion, Changes: []PatientFieldChange{}}
+	null := json.RawMessage("null")
+	for _, field := range fields {
+		if versionDiffIgnored[field] {
+			continue
+		}
+		// Both sides come from jsonb, whose text form is canonical
+		a, b := fromFields[field], toFields[field]
+		if a == nil {
+			a = null
+		}
+		if b == nil {
+			b = null
+		}
+		if !bytes.Equal(a, b) {
+			diff.Changes = append(diff.Changes, PatientFieldChange{Field: field, From: a, To: b})
+		}
+	}
+	return diff, nil
+}
+
+// RestoreVersion writes the demographics of a version back to an active patient. The change
+// is recorded as a new version marked as restored from the given one.
+func (s *PatientVersionService) RestoreVersion(patientID, version, userID int, reason string) (*PatientVersion, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current int
+	err = tx.QueryRow(`SELECT version FROM patient_versions WHERE patient_id = $1 AND valid_to IS NULL`, patientID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	assignments := make([]string, len(restorableColumns))
+	for i, column := range restorableColumns {
+		assignments[i] = fmt.Sprintf("%s = v.%s", column, column)
+	}
+	result, err := tx.Exec(`
+		UPDATE patients p SET `+strings.Join(assignments, ", ")+`, updated_by = $3, updated_at = CURRENT_TIMESTAMP
+		FROM patient_versions pv, jsonb_populate_record(NULL::patients, pv.data) v
+		WHERE p.id = $1 AND p.is_active = true AND pv.patient_id = p.id AND pv.version = $2
+	`, patientID, version, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore patient version: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrPatientVersionNotFound
+	}
+
+	restored, err := scanPatientVersion(tx.QueryRow(`
+		UPDATE patient_versions SET change_type = 'restored', restored_from_version = $2, change_reason = $3
+		WHERE patient_id = $1 AND valid_to IS NULL AND version > $4
+		RETURNING `+patientVersionColumns, patientID, version, reason, current))
+	if err == sql.ErrNoRows {
+		// The trigger records no version when nothing changed
+		return nil, ErrPatientVersionCurrent
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record restored version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
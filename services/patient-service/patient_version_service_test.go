@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// patientVersionRows returns version of patient 5 with the given row data
+func patientVersionRows(version int, data string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "patient_id", "version", "valid_from", "valid_to", "change_type", "changed_fields",
+		"changed_by", "restored_from_version", "change_reason", "data"}).
+		AddRow(100+version, 5, version, time.Now(), nil, "updated", "{}", 2, nil, "", []byte(data))
+}
+
+func TestDiffPatientVersions(t *testing.T) {
+	db, mock := newMockDB(t)
+	const versionQuery = `FROM patient_versions WHERE patient_id = \$1 AND version = \$2`
+	mock.ExpectQuery(versionQuery).WithArgs(5, 1).WillReturnRows(patientVersionRows(1,
+		`{"first_name": "Salma", "last_name": "Idrissi", "phone": "+212600000001", "email": null, "updated_at": "2025-01-01T10:00:00"}`))
+	mock.ExpectQuery(versionQuery).WithArgs(5, 2).WillReturnRows(patientVersionRows(2,
+		`{"first_name": "Salma", "last_name": "Bennani", "phone": "+212600000001", "email": "salma@example.com", "updated_at": "2025-03-01T10:00:00", "mrn": "MRN-1"}`))
+
+	diff, err := NewPatientVersionService(db).Diff(5, 1, 2)
+	if err != nil {
+		t.Fatalf("Diff error = %v", err)
+	}
+	want := []PatientFieldChange{
+		{Field: "email", From: json.RawMessage(`null`), To: json.RawMessage(`"salma@example.com"`)},
+		{Field: "last_name", From: json.RawMessage(`"Idrissi"`), To: json.RawMessage(`"Bennani"`)},
+		{Field: "mrn", From: json.RawMessage(`null`), To: json.RawMessage(`"MRN-1"`)},
+	}
+	if len(diff.Changes) != len(want) {
+		t.Fatalf("changes = %+v, want %+v", diff.Changes, want)
+	}
+	for i, change := range diff.Changes {
+		if change.Field != want[i].Field || string(change.From) != string(want[i].From) || string(change.To) != string(want[i].To) {
+			t.Errorf("change %d = %s: %s -> %s, want %s: %s -> %s", i, change.Field, change.From, change.To,
+				want[i].Field, want[i].From, want[i].To)
+		}
+	}
+}
+
+func TestGetVersionAt(t *testing.T) {
+	const atQuery = `WHERE patient_id = \$1 AND valid_from <= \$2 AND \(valid_to IS NULL OR valid_to > \$2\)`
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.FixedZone("Casablanca", 3600))
+
+	t.Run("found", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(atQuery).WithArgs(5, at.UTC()).WillReturnRows(patientVersionRows(2, `{}`))
+		v, err := NewPatientVersionService(db).GetVersionAt(5, at)
+		if err != nil || v.Version != 2 {
+			t.Errorf("GetVersionAt = (%v, %v), want version 2", v, err)
+		}
+	})
+	t.Run("before the patient existed", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(atQuery).WithArgs(5, at.UTC()).WillReturnRows(sqlmock.NewRows(nil))
+		if _, err := NewPatientVersionService(db).GetVersionAt(5, at); !errors.Is(err, ErrPatientVersionNotFound) {
+			t.Errorf("GetVersionAt error = %v, want ErrPatientVersionNotFound", err)
+		}
+	})
+}
+
+func TestRestoreVersionWithoutChanges(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT version FROM patient_versions`).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectExec(`UPDATE patients p SET first_name = v.first_name`).WithArgs(5, 2, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE patient_versions SET change_type = 'restored'`).WithArgs(5, 2, "Wrong patient merged", 3).
+		WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectRollback()
+
+	_, err := NewPatientVersionService(db).RestoreVersion(5, 2, 7, "Wrong patient merged")
+	if !errors.Is(err, ErrPatientVersionCurrent) {
+		t.Errorf("RestoreVersion error = %v, want ErrPatientVersionCurrent", err)
+	}
+}
+
+func TestParseAsOf(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2025-03-01T08:30:00Z", time.Date(2025, 3, 1, 8, 30, 0, 0, time.UTC), false},
+		{"2025-03-01", time.Date(2025, 3, 1, 23, 59, 59, 999999999, time.UTC), false},
+		{"01/03/2025", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseAsOf(tt.value)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseAsOf(%q) = (%v, %v), want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
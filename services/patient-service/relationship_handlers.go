@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// RelationshipHandler groups patient relationship, family group and proxy booking handlers
+type RelationshipHandler struct {
+	relationshipService *RelationshipService
+	patientService      *PatientService
+	appointmentClient   *AppointmentClient
+	validator           *validator.Validate
+}
+
+// NewRelationshipHandler constructs a new RelationshipHandler
+func NewRelationshipHandler(relationshipService *RelationshipService, patientService *PatientService, appointmentClient *AppointmentClient) *RelationshipHandler {
+	return &RelationshipHandler{
+		relationshipService: relationshipService,
+		patientService:      patientService,
+		appointmentClient:   appointmentClient,
+		validator:           validator.New(),
+	}
+}
+
+// writeRelationshipError maps relationship service errors to responses
+func writeRelationshipError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrRelationshipNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Relationship not found"})
+	case errors.Is(err, ErrRelatedPatientNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Related patient not found", "details": "The related patient must be an active patient of the same healthcare entity"})
+	case errors.Is(err, ErrRelationshipExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "Relationship already exists"})
+	case errors.Is(err, ErrInvalidLegalGuardian), errors.Is(err, ErrProxyMustBeAdult):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+	case errors.Is(err, ErrLastLegalGuardian):
+		c.JSON(http.StatusConflict, gin.H{"error": "Legal guardian required", "details": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// loadRelationship loads the :relationshipId relationship of the :id patient
+func (h *RelationshipHandler) loadRelationship(c *gin.Context) (*PatientRelationship, bool) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return nil, false
+	}
+	relationshipID, err := strconv.Atoi(c.Param("relationshipId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid relationship ID"})
+		return nil, false
+	}
+	rel, err := h.relationshipService.GetRelationship(patient.HealthcareEntityID, patient.ID, relationshipID)
+	if err != nil {
+		writeRelationshipError(c, err, "Failed to get relationship")
+		return nil, false
+	}
+	return rel, true
+}
+
+// GetRelationships lists the patient's relationships in both directions
+func (h *RelationshipHandler) GetRelationships(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	relationships, err := h.relationshipService.ListRelationships(patient.HealthcareEntityID, patient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get relationships"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "relationships": relationships})
+}
+
+// CreateRelationship records that related_patient_id is the patient's relationship_type
+func (h *RelationshipHandler) CreateRelationship(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	var req RelationshipRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	if req.RelatedPatientID == patient.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": "A patient cannot be related to themselves"})
+		return
+	}
+
+	rel := &PatientRelationship{
+		HealthcareEntityID:  patient.HealthcareEntityID,
+		PatientID:           patient.ID,
+		RelatedPatientID:    req.RelatedPatientID,
+		RelationshipType:    req.RelationshipType,
+		IsLegalGuardian:     req.IsLegalGuardian,
+		CanBookAppointments: req.CanBookAppointments,
+		CanViewRecords:      req.CanViewRecords,
+		CanManageConsents:   req.CanManageConsents,
+		Notes:               strings.TrimSpace(req.Notes),
+		CreatedBy:           c.GetInt("user_id"),
+	}
+	if err := h.relationshipService.CreateRelationship(rel); err != nil {
+		if !errors.Is(err, ErrRelationshipExists) && !errors.Is(err, ErrRelatedPatientNotFound) {
+			logging.LogError("Failed to create relationship", "error", err, "patient_id", patient.ID)
+		}
+		writeRelationshipError(c, err, "Failed to create relationship")
+		return
+	}
+
+	logging.LogInfo("Patient relationship created", "relationship_id", rel.ID, "patient_id", rel.PatientID,
+		"related_patient_id", rel.RelatedPatientID, "relationship_type", rel.RelationshipType, "is_legal_guardian", rel.IsLegalGuardian)
+	c.JSON(http.StatusCreated, gin.H{"message": "Relationship created successfully", "relationship": rel})
+}
+
+// UpdateRelationship updates the legal guardian flag, proxy rights and notes of a relationship
+func (h *RelationshipHandler) UpdateRelationship(c *gin.Context) {
+	rel, ok := h.loadRelationship(c)
+	if !ok {
+		return
+	}
+	var req RelationshipUpdateRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	wasLegalGuardian := rel.IsLegalGuardian
+	rel.IsLegalGuardian = req.IsLegalGuardian
+	rel.CanBookAppointments = req.CanBookAppointments
+	rel.CanViewRecords = req.CanViewRecords
+	rel.CanManageConsents = req.CanManageConsents
+	rel.Notes = strings.TrimSpace(req.Notes)
+	if err := h.relationshipService.UpdateRelationship(rel, wasLegalGuardian); err != nil {
+		writeRelationshipError(c, err, "Failed to update relationship")
+		return
+	}
+	c.JSON(http.StatusOK, rel)
+}
+
+// DeleteRelationship removes a relationship; the last legal guardian of a minor cannot be removed
+func (h *RelationshipHandler) DeleteRelationship(c *gin.Context) {
+	rel, ok := h.loadRelationship(c)
+	if !ok {
+		return
+	}
+	if err := h.relationshipService.DeleteRelationship(rel); err != nil {
+		writeRelationshipError(c, err, "Failed to delete relationship")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Relationship deleted successfully"})
+}
+
+// GetFamilyGroup returns all patients connected to the patient and the links between them
+func (h *RelationshipHandler) GetFamilyGroup(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	group, err := h.relationshipService.GetFamilyGroup(patient.HealthcareEntityID, patient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get family group"})
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// GetDependants lists the patients the patient can act for (legal guardian or booking proxy)
+func (h *RelationshipHandler) GetDependants(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	dependants, err := h.relationshipService.GetDependants(patient.HealthcareEntityID, patient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get dependants"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "dependants": dependants})
+}
+
+// BookForDependant books an appointment for :dependantId on behalf of the :id patient, who
+// must be its legal guardian or hold the booking proxy right
+func (h *RelationshipHandler) BookForDependant(c *gin.Context) {
+	proxy, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	dependantID, err := strconv.Atoi(c.Param("dependantId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dependant ID"})
+		return
+	}
+	var req DependantBookingRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	dependant, err := h.relationshipService.GetPatientSummary(proxy.HealthcareEntityID, dependantID)
+	if err != nil {
+		if errors.Is(err, ErrRelatedPatientNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dependant not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get dependant"})
+		return
+	}
+	rel, err := h.relationshipService.FindBookingProxy(proxy.HealthcareEntityID, dependant.ID, proxy.ID)
+	if err != nil {
+		if errors.Is(err, ErrNoProxyRights) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Booking not allowed", "details": "The patient is not a legal guardian of the dependant and has no booking proxy right"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check proxy rights"})
+		return
+	}
+
+	priority := req.Priority
+	if priority == "" {
+		priority = "normal"
+	}
+	notes := fmt.Sprintf("Booked by %s %s (%s, MRN %s) on behalf of the patient", proxy.FirstName, proxy.LastName, rel.RelationshipType, proxy.MRN)
+	if strings.TrimSpace(req.Notes) != "" {
+		notes = strings.TrimSpace(req.Notes) + "\n" + notes
+	}
+	booking := map[string]interface{}{
+		"patient_id":      dependant.ID,
+		"doctor_id":       req.DoctorID,
+		"date_time":       req.DateTime,
+		"duration":        req.Duration,
+		"type":            req.Type,
+		"reason":          req.Reason,
+		"notes":           notes,
+		"priority":        priority,
+		"room_id":         req.RoomID,
+		"check_conflicts": req.CheckConflicts,
+	}
+	headers := forwardedIdentityHeaders(c)
+
+	status, body, err := h.appointmentClient.BookAppointment(booking, headers)
+	if err != nil {
+		logging.LogError("Failed to book appointment for dependant", "error", err, "patient_id", proxy.ID, "dependant_id", dependant.ID)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Appointment service unavailable"})
+		return
+	}
+
+	logging.LogInfo("Appointment booking requested on behalf of dependant", "patient_id", proxy.ID, "dependant_id", dependant.ID,
+		"relationship_id", rel.ID, "status", status)
+	c.Data(status, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// adultAge is the age of majority; younger patients must have a legal guardian
+const adultAge = 18
+
+var (
+	ErrRelationshipNotFound   = errors.New("relationship not found")
+	ErrRelationshipExists     = errors.New("relationship already exists")
+	ErrRelatedPatientNotFound = errors.New("related patient not found")
+	ErrInvalidLegalGuardian   = errors.New("only parent and guardian relationships can be legal guardians")
+	ErrProxyMustBeAdult       = errors.New("legal guardians and proxies must be adults")
+	ErrLastLegalGuardian      = errors.New("a minor must keep at least one legal guardian")
+	ErrNoProxyRights          = errors.New("no proxy rights over this patient")
+)
+
+// relationshipInverse names a relationship from the other side (the parent's view of a parent link is "child")
+var relationshipInverse = map[string]string{
+	"parent":    "child",
+	"guardian":  "ward",
+	"caregiver": "care_recipient",
+	"spouse":    "spouse",
+	"sibling":   "sibling",
+}
+
+// isMinor reports whether a patient born on dateOfBirth is under the age of majority
+func isMinor(dateOfBirth time.Time) bool {
+	return calculateAge(dateOfBirth) < adultAge
+}
+
+// familyGroupMembers selects the ids of every patient connected to $1 within entity $2,
+// following relationships in both directions up to a bounded depth
+const familyGroupMembers = `
+	WITH RECURSIVE family(patient_id, depth) AS (
+		SELECT $1::INTEGER, 0
+		UNION
+		SELECT CASE WHEN r.patient_id = f.patient_id THEN r.related_patient_id ELSE r.patient_id END, f.depth + 1
+		FROM patient_relationships r
+		JOIN family f ON r.patient_id = f.patient_id OR r.related_patient_id = f.patient_id
+		WHERE r.healthcare_entity_id = $2 AND f.depth < 6
+	)
+	SELECT DISTINCT patient_id FROM family`
+
+const relationshipColumns = `
+	r.id, r.healthcare_entity_id, r.patient_id, r.related_patient_id, r.relationship_type, r.is_legal_guardian,
+	r.can_book_appointments, r.can_view_records, r.can_manage_consents, r.notes, r.created_at, r.updated_at, r.created_by`
+
+func scanRelationship(row rowScanner, extra ...interface{}) (*PatientRelationship, error) {
+	rel := &PatientRelationship{}
+	dest := []interface{}{&rel.ID, &rel.HealthcareEntityID, &rel.PatientID, &rel.RelatedPatientID, &rel.RelationshipType,
+		&rel.IsLegalGuardian, &rel.CanBookAppointments, &rel.CanViewRecords, &rel.CanManageConsents, &rel.Notes,
+		&rel.CreatedAt, &rel.UpdatedAt, &rel.CreatedBy}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// RelationshipService manages links between patients (family, guardians, caregivers)
+type RelationshipService struct {
+	db *sql.DB
+}
+
+// NewRelationshipService creates a new relationship service
+func NewRelationshipService(db *sql.DB) *RelationshipService {
+	return &RelationshipService{db: db}
+}
+
+// GetPatientSummary loads an active patient of the entity as a summary
+func (s *RelationshipService) GetPatientSummary(entityID, patientID int) (*RelatedPatientSummary, error) {
+	summary := &RelatedPatientSummary{}
+	err := s.db.QueryRow(`
+		SELECT id, mrn, first_name, last_name, date_of_birth
+		FROM patients
+		WHERE id = $1 AND healthcare_entity_id = $2 AND is_active = true
+	`, patientID, entityID).Scan(&summary.ID, &summary.MRN, &summary.FirstName, &summary.LastName, &summary.DateOfBirth)
+	if err == sql.ErrNoRows {
+		return nil, ErrRelatedPatientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	summary.Age = calculateAge(summary.DateOfBirth)
+	summary.IsMinor = isMinor(summary.DateOfBirth)
+	return summary, nil
+}
+
+// validateRelationship checks the related patient and the guardian/proxy rules
+func (s *RelationshipService) validateRelationship(rel *PatientRelationship) error {
+	if rel.IsLegalGuardian && rel.RelationshipType != "parent" && rel.RelationshipType != "guardian" {
+		return ErrInvalidLegalGuardian
+	}
+	related, err := s.GetPatientSummary(rel.HealthcareEntityID, rel.RelatedPatientID)
+	if err != nil {
+		return err
+	}
+	hasProxyRights := rel.IsLegalGuardian || rel.CanBookAppointments || rel.CanViewRecords || rel.CanManageConsents
+	if hasProxyRights && related.IsMinor {
+		return ErrProxyMustBeAdult
+	}
+	return nil
+}
+
+// insertRelationship inserts a relationship with q so it can take part in a larger transaction
+func insertRelationship(q queryRower, rel *PatientRelationship) error {
+	err := q.QueryRow(`
+		INSERT INTO patient_relationships (
+			healthcare_entity_id, patient_id, related_patient_id, relationship_type, is_legal_guardian,
+			can_book_appointments, can_view_records, can_manage_consents, notes, created_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at, updated_at
+	`, rel.HealthcareEntityID, rel.PatientID, rel.RelatedPatientID, rel.RelationshipType, rel.IsLegalGuardian,
+		rel.CanBookAppointments, rel.CanViewRecords, rel.CanManageConsents, rel.Notes, rel.CreatedBy,
+	).Scan(&rel.ID, &rel.CreatedAt, &rel.UpdatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrRelationshipExists
+	}
+	return err
+}
+
+// CreateRelationship links rel.RelatedPatientID to rel.PatientID as its rel.RelationshipType
+func (s *RelationshipService) CreateRelationship(rel *PatientRelationship) error {
+	if err := s.validateRelationship(rel); err != nil {
+		return err
+	}
+	return insertRelationship(s.db, rel)
+}
+
+// CreatePatientWithGuardian creates a patient together with its legal guardian link in one
+// transaction. The guardian must be an adult patient of the same entity; it receives full proxy rights.
+func (s *RelationshipService) CreatePatientWithGuardian(patient *Patient, guardian *GuardianLinkRequest) (*PatientRelationship, error) {
+	rel := &PatientRelationship{
+		HealthcareEntityID:  patient.HealthcareEntityID,
+		RelatedPatientID:    guardian.PatientID,
+		RelationshipType:    guardian.RelationshipType,
+		IsLegalGuardian:     true,
+		CanBookAppointments: true,
+		CanViewRecords:      true,
+		CanManageConsents:   true,
+		CreatedBy:           patient.CreatedBy,
+	}
+	if err := s.validateRelationship(rel); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertPatient(tx, patient); err != nil {
+		return nil, err
+	}
+	rel.PatientID = patient.ID
+	if err := insertRelationship(tx, rel); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// ListRelationships returns every relationship of a patient, in both directions, seen from that patient
+func (s *RelationshipService) ListRelationships(entityID, patientID int) ([]PatientRelationshipView, error) {
+	rows, err := s.db.Query(`SELECT `+relationshipColumns+`, o.id, o.mrn, o.first_name, o.last_name, o.date_of_birth
+		FROM patient_relationships r
+		JOIN patients o ON o.id = CASE WHEN r.patient_id = $1 THEN r.related_patient_id ELSE r.patient_id END
+		WHERE r.healthcare_entity_id = $2 AND (r.patient_id = $1 OR r.related_patient_id = $1) AND o.is_active = true
+		ORDER BY r.is_legal_guardian DESC, r.relationship_type, o.last_name, o.first_name
+	`, patientID, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := []PatientRelationshipView{}
+	for rows.Next() {
+		var other RelatedPatientSummary
+		rel, err := scanRelationship(rows, &other.ID, &other.MRN, &other.FirstName, &other.LastName, &other.DateOfBirth)
+		if err != nil {
+			return nil, err
+		}
+		other.Age = calculateAge(other.DateOfBirth)
+		other.IsMinor = isMinor(other.DateOfBirth)
+
+		role := rel.RelationshipType
+		if rel.RelatedPatientID == patientID {
+			role = relationshipInverse[rel.RelationshipType]
+		}
+		views = append(views, PatientRelationshipView{PatientRelationship: *rel, Role: role, OtherPatient: other})
+	}
+	return views, rows.Err()
+}
+
+// GetRelationship gets a relationship the patient takes part in, on either side
+func (s *RelationshipService) GetRelationship(entityID, patientID, relationshipID int) (*PatientRelationship, error) {
+	rel, err := scanRelationship(s.db.QueryRow(`SELECT `+relationshipColumns+`
+		FROM patient_relationships r
+		WHERE r.id = $1 AND r.healthcare_entity_id = $2 AND (r.patient_id = $3 OR r.related_patient_id = $3)
+	`, relationshipID, entityID, patientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrRelationshipNotFound
+	}
+	return rel, err
+}
+
+// ensureGuardianRemains refuses to drop the last legal guardian of a minor
+func (s *RelationshipService) ensureGuardianRemains(rel *PatientRelationship) error {
+	var dateOfBirth time.Time
+	var otherGuardians int
+	err := s.db.QueryRow(`
+		SELECT p.date_of_birth, (
+			SELECT COUNT(*) FROM patient_relationships r
+			WHERE r.patient_id = p.id AND r.is_legal_guardian = true AND r.id <> $2
+		)
+		FROM patients p
+		WHERE p.id = $1
+	`, rel.PatientID, rel.ID).Scan(&dateOfBirth, &otherGuardians)
+	if err != nil {
+		return err
+	}
+	if isMinor(dateOfBirth) && otherGuardians == 0 {
+		return ErrLastLegalGuardian
+	}
+	return nil
+}
+
+// UpdateRelationship updates the legal guardian flag, proxy rights and notes of rel
+func (s *RelationshipService) UpdateRelationship(rel *PatientRelationship, wasLegalGuardian bool) error {
+	if err := s.validateRelationship(rel); err != nil {
+		return err
+	}
+	if wasLegalGuardian && !rel.IsLegalGuardian {
+		if err := s.ensureGuardianRemains(rel); err != nil {
+			return err
+		}
+	}
+	err := s.db.QueryRow(`
+		UPDATE patient_relationships
+		SET is_legal_guardian = $2, can_book_appointments = $3, can_view_records = $4, can_manage_consents = $5,
+			notes = $6, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING updated_at
+	`, rel.ID, rel.IsLegalGuardian, rel.CanBookAppointments, rel.CanViewRecords, rel.CanManageConsents, rel.Notes,
+	).Scan(&rel.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrRelationshipNotFound
+	}
+	return err
+}
+
+// DeleteRelationship removes a relationship
+func (s *RelationshipService) DeleteRelationship(rel *PatientRelationship) error {
+	if rel.IsLegalGuardian {
+		if err := s.ensureGuardianRemains(rel); err != nil {
+			return err
+		}
+	}
+	result, err := s.db.Exec(`DELETE FROM patient_relationships WHERE id = $1`, rel.ID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrRelationshipNotFound
+	}
+	return nil
+}
+
+// GetFamilyGroup returns every patient connected to patientID and the relationships between them
+func (s *RelationshipService) GetFamilyGroup(entityID, patientID int) (*FamilyGroup, error) {
+	group := &FamilyGroup{PatientID: patientID, Members: []RelatedPatientSummary{}, Relationships: []PatientRelationship{}}
+
+	rows, err := s.db.Query(`
+		SELECT id, mrn, first_name, last_name, date_of_birth
+		FROM patients
+		WHERE id IN (`+familyGroupMembers+`) AND healthcare_entity_id = $2 AND is_active = true
+		ORDER BY date_of_birth, id
+	`, patientID, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var member RelatedPatientSummary
+		if err := rows.Scan(&member.ID, &member.MRN, &member.FirstName, &member.LastName, &member.DateOfBirth); err != nil {
+			return nil, err
+		}
+		member.Age = calculateAge(member.DateOfBirth)
+		member.IsMinor = isMinor(member.DateOfBirth)
+		group.Members = append(group.Members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	relRows, err := s.db.Query(`SELECT `+relationshipColumns+`
+		FROM patient_relationships r
+		WHERE r.healthcare_entity_id = $2
+		  AND r.patient_id IN (`+familyGroupMembers+`)
+		  AND r.related_patient_id IN (`+familyGroupMembers+`)
+		ORDER BY r.id
+	`, patientID, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer relRows.Close()
+	for relRows.Next() {
+		rel, err := scanRelationship(relRows)
+		if err != nil {
+			return nil, err
+		}
+		group.Relationships = append(group.Relationships, *rel)
+	}
+	return group, relRows.Err()
+}
+
+// GetDependants lists the patients over whom proxyID can act: those it is the legal guardian
+// of or may book appointments for
+func (s *RelationshipService) GetDependants(entityID, proxyID int) ([]PatientRelationshipView, error) {
+	rows, err := s.db.Query(`SELECT `+relationshipColumns+`, o.id, o.mrn, o.first_name, o.last_name, o.date_of_birth
+		FROM patient_relationships r
+		JOIN patients o ON o.id = r.patient_id
+		WHERE r.healthcare_entity_id = $1 AND r.related_patient_id = $2 AND o.is_active = true
+		  AND (r.is_legal_guardian OR r.can_book_appointments)
+		ORDER BY o.date_of_birth DESC, o.id
+	`, entityID, proxyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := []PatientRelationshipView{}
+	for rows.Next() {
+		var other RelatedPatientSummary
+		rel, err := scanRelationship(rows, &other.ID, &other.MRN, &other.FirstName, &other.LastName, &other.DateOfBirth)
+		if err != nil {
+			return nil, err
+		}
+		other.Age = calculateAge(other.DateOfBirth)
+		other.IsMinor = isMinor(other.DateOfBirth)
+		views = append(views, PatientRelationshipView{PatientRelationship: *rel, Role: relationshipInverse[rel.RelationshipType], OtherPatient: other})
+	}
+	return views, rows.Err()
+}
+
+// FindBookingProxy returns the relationship allowing proxyID to book for dependantID. Legal
+// guardians may always book; other relationships need the can_book_appointments right.
+func (s *RelationshipService) FindBookingProxy(entityID, dependantID, proxyID int) (*PatientRelationship, error) {
+	rel, err := scanRelationship(s.db.QueryRow(`SELECT `+relationshipColumns+`
+		FROM patient_relationships r
+		WHERE r.healthcare_entity_id = $1 AND r.patient_id = $2 AND r.related_patient_id = $3
+		  AND (r.is_legal_guardian OR r.can_book_appointments)
+		ORDER BY r.is_legal_guardian DESC
+		LIMIT 1
+	`, entityID, dependantID, proxyID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoProxyRights
+	}
+	return rel, err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const patientSummaryQuery = `SELECT id, mrn, first_name, last_name, date_of_birth\s+FROM patients`
+
+// bornYearsAgo returns a date of birth for someone turning years old a month ago
+func bornYearsAgo(years int) time.Time {
+	return time.Now().AddDate(-years, -1, 0)
+}
+
+func patientSummaryRows(id int, dateOfBirth time.Time) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "mrn", "first_name", "last_name", "date_of_birth"}).
+		AddRow(id, "MRN-2025-000001-3", "Salma", "Idrissi", dateOfBirth)
+}
+
+func TestCreateRelationshipRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		rel       PatientRelationship
+		relatedAt time.Time // date of birth of the related patient, zero if it isn't looked up
+		wantErr   error
+	}{
+		{"sibling as legal guardian", PatientRelationship{RelationshipType: "sibling", IsLegalGuardian: true}, time.Time{}, ErrInvalidLegalGuardian},
+		{"minor guardian", PatientRelationship{RelationshipType: "guardian", IsLegalGuardian: true}, bornYearsAgo(16), ErrProxyMustBeAdult},
+		{"minor proxy", PatientRelationship{RelationshipType: "sibling", CanBookAppointments: true}, bornYearsAgo(12), ErrProxyMustBeAdult},
+		{"minor without proxy rights", PatientRelationship{RelationshipType: "sibling"}, bornYearsAgo(12), nil},
+		{"adult legal guardian", PatientRelationship{RelationshipType: "parent", IsLegalGuardian: true, CanViewRecords: true}, bornYearsAgo(40), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			rel := tt.rel
+			rel.HealthcareEntityID, rel.PatientID, rel.RelatedPatientID, rel.CreatedBy = 1, 5, 6, 2
+			if !tt.relatedAt.IsZero() {
+				mock.ExpectQuery(patientSummaryQuery).WithArgs(6, 1).WillReturnRows(patientSummaryRows(6, tt.relatedAt))
+			}
+			if tt.wantErr == nil {
+				mock.ExpectQuery(`INSERT INTO patient_relationships`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(20, time.Now(), time.Now()))
+			}
+
+			if err := NewRelationshipService(db).CreateRelationship(&rel); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CreateRelationship error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeleteLegalGuardian(t *testing.T) {
+	const guardiansQuery = `SELECT p.date_of_birth, \(\s+SELECT COUNT\(\*\) FROM patient_relationships`
+	tests := []struct {
+		name           string
+		dateOfBirth    time.Time
+		otherGuardians int
+		wantErr        error
+	}{
+		{"last guardian of a minor", bornYearsAgo(10), 0, ErrLastLegalGuardian},
+		{"minor keeps another guardian", bornYearsAgo(10), 1, nil},
+		{"last guardian of an adult", bornYearsAgo(30), 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(guardiansQuery).WithArgs(5, 20).
+				WillReturnRows(sqlmock.NewRows([]string{"date_of_birth", "count"}).AddRow(tt.dateOfBirth, tt.otherGuardians))
+			if tt.wantErr == nil {
+				mock.ExpectExec(`DELETE FROM patient_relationships WHERE id = \$1`).WithArgs(20).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			rel := &PatientRelationship{ID: 20, PatientID: 5, RelatedPatientID: 6, RelationshipType: "parent", IsLegalGuardian: true}
+			if err := NewRelationshipService(db).DeleteRelationship(rel); !errors.Is(err, tt.wantErr) {
+				t.Errorf("DeleteRelationship error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
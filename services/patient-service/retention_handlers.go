@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// RetentionHandler groups retention policy, retention run and patient archive handlers
+type RetentionHandler struct {
+	retentionService *RetentionService
+	validator        *validator.Validate
+}
+
+// NewRetentionHandler constructs a new RetentionHandler
+func NewRetentionHandler(retentionService *RetentionService) *RetentionHandler {
+	return &RetentionHandler{retentionService: retentionService, validator: validator.New()}
+}
+
+// writeRetentionError maps retention service errors to responses
+func writeRetentionError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrRetentionPolicyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Retention policy not found"})
+	case errors.Is(err, ErrRetentionPolicyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "Retention policy already exists", "details": "There is already a policy for this country; update it instead"})
+	case errors.Is(err, ErrRetentionRunNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Retention run not found"})
+	case errors.Is(err, ErrRetentionRunInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": "Retention run in progress", "details": err.Error()})
+	case errors.Is(err, ErrArchivedPatientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Archived patient not found"})
+	case errors.Is(err, ErrArchiveRestoreConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "Restore conflict", "details": "An active patient already uses the archived patient's email or identifier"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// bindPolicy validates a policy request and applies it to policy
+func (h *RetentionHandler) bindPolicy(c *gin.Context, policy *RetentionPolicy) bool {
+	var req RetentionPolicyRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return false
+	}
+	if req.ArchiveAfterYears == nil && req.PurgeAfterYears == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": "archive_after_years or purge_after_years is required"})
+		return false
+	}
+	if req.ArchiveAfterYears != nil && req.PurgeAfterYears != nil && *req.PurgeAfterYears <= *req.ArchiveAfterYears {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": "purge_after_years must be greater than archive_after_years"})
+		return false
+	}
+
+	policy.CountryID = req.CountryID
+	policy.ArchiveAfterYears = req.ArchiveAfterYears
+	policy.PurgeAfterYears = req.PurgeAfterYears
+	policy.PurgeAction = req.PurgeAction
+	if policy.PurgeAction == "" {
+		policy.PurgeAction = "anonymise"
+	}
+	policy.IsActive = req.IsActive == nil || *req.IsActive
+	policy.Notes = strings.TrimSpace(req.Notes)
+	return true
+}
+
+// loadPolicy loads the :policyId policy of the caller's entity
+func (h *RetentionHandler) loadPolicy(c *gin.Context) (*RetentionPolicy, bool) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return nil, false
+	}
+	policyID, err := strconv.Atoi(c.Param("policyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return nil, false
+	}
+	policy, err := h.retentionService.GetPolicy(entityID, policyID)
+	if err != nil {
+		writeRetentionError(c, err, "Failed to get retention policy")
+		return nil, false
+	}
+	return policy, true
+}
+
+// GetPolicies lists the entity's retention policies
+func (h *RetentionHandler) GetPolicies(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	policies, err := h.retentionService.ListPolicies(entityID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get retention policies"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// CreatePolicy creates a retention policy for the entity or one of its patient countries (admin only)
+func (h *RetentionHandler) CreatePolicy(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	policy := &RetentionPolicy{HealthcareEntityID: entityID, CreatedBy: c.GetInt("user_id")}
+	if !h.bindPolicy(c, policy) {
+		return
+	}
+	if err := h.retentionService.CreatePolicy(policy); err != nil {
+		if !errors.Is(err, ErrRetentionPolicyExists) {
+			logging.LogError("Failed to create retention policy", "error", err, "healthcare_entity_id", entityID)
+		}
+		writeRetentionError(c, err, "Failed to create retention policy")
+		return
+	}
+
+	logging.LogInfo("Retention policy created", "policy_id", policy.ID, "healthcare_entity_id", entityID,
+		"purge_action", policy.PurgeAction, "created_by", policy.CreatedBy)
+	c.JSON(http.StatusCreated, policy)
+}
+
+// UpdatePolicy replaces a retention policy (admin only)
+func (h *RetentionHandler) UpdatePolicy(c *gin.Context) {
+	policy, ok := h.loadPolicy(c)
+	if !ok {
+		return
+	}
+	if !h.bindPolicy(c, policy) {
+		return
+	}
+	policy.UpdatedBy = c.GetInt("user_id")
+	if err := h.retentionService.UpdatePolicy(policy); err != nil {
+		writeRetentionError(c, err, "Failed to update retention policy")
+		return
+	}
+
+	logging.LogInfo("Retention policy updated", "policy_id", policy.ID, "healthcare_entity_id", policy.HealthcareEntityID,
+		"purge_action", policy.PurgeAction, "is_active", policy.IsActive, "updated_by", policy.UpdatedBy)
+	c.JSON(http.StatusOK, policy)
+}
+
+// DeletePolicy deletes a retention policy (admin only)
+func (h *RetentionHandler) DeletePolicy(c *gin.Context) {
+	policy, ok := h.loadPolicy(c)
+	if !ok {
+		return
+	}
+	if err := h.retentionService.DeletePolicy(policy.HealthcareEntityID, policy.ID); err != nil {
+		writeRetentionError(c, err, "Failed to delete retention policy")
+		return
+	}
+	logging.LogInfo("Retention policy deleted", "policy_id", policy.ID, "healthcare_entity_id", policy.HealthcareEntityID, "user_id", c.GetInt("user_id"))
+	c.JSON(http.StatusOK, gin.H{"message": "Retention policy deleted successfully"})
+}
+
+// StartRun starts a retention run of the entity in the background (admin only). With dry_run
+// the report lists what would happen without changing anything.
+func (h *RetentionHandler) StartRun(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	var req RetentionRunRequest
+	if c.Request.ContentLength != 0 && !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	run, err := h.retentionService.StartRun(entityID, req.DryRun, &userID)
+	if err != nil {
+		writeRetentionError(c, err, "Failed to start retention run")
+		return
+	}
+
+	// The request context ends with the response; capture the caller before going async.
+	// The response shows the run as started, the background run updates its own copy.
+	started := *run
+	access := documentAccess(c)
+	headers := forwardedIdentityHeaders(c)
+	go func() {
+		if err := h.retentionService.Execute(run, access, headers); err != nil {
+			logging.LogError("Retention run failed", "error", err, "run_id", run.ID, "healthcare_entity_id", entityID)
+		}
+	}()
+
+	logging.LogInfo("Retention run started", "run_id", run.ID, "healthcare_entity_id", entityID, "dry_run", req.DryRun, "user_id", userID)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Retention run started", "run": started})
+}
+
+// GetRuns lists the entity's most recent retention runs (?limit=, default 20)
+func (h *RetentionHandler) GetRuns(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+	runs, err := h.retentionService.ListRuns(entityID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get retention runs"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// GetRun returns a retention run with its report
+func (h *RetentionHandler) GetRun(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	runID, err := strconv.Atoi(c.Param("runId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid run ID"})
+		return
+	}
+	run, err := h.retentionService.GetRun(entityID, runID)
+	if err != nil {
+		writeRetentionError(c, err, "Failed to get retention run")
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// GetArchivedPatients lists the entity's archived patients (?mrn=, ?limit=, ?offset=)
+func (h *RetentionHandler) GetArchivedPatients(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	patients, err := h.retentionService.ListArchivedPatients(entityID, strings.TrimSpace(c.Query("mrn")), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get archived patients"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"patients": patients, "limit": limit, "offset": offset})
+}
+
+// RestoreArchivedPatient moves an archived patient back into the active records (admin only)
+func (h *RetentionHandler) RestoreArchivedPatient(c *gin.Context) {
+	entityID, ok := getHealthcareEntityID(c)
+	if !ok {
+		return
+	}
+	patientID, err := strconv.Atoi(c.Param("patientId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return
+	}
+	if err := h.retentionService.RestorePatient(entityID, patientID); err != nil {
+		if !errors.Is(err, ErrArchivedPatientNotFound) && !errors.Is(err, ErrArchiveRestoreConflict) {
+			logging.LogError("Failed to restore archived patient", "error", err, "patient_id", patientID)
+		}
+		writeRetentionError(c, err, "Failed to restore archived patient")
+		return
+	}
+
+	logging.LogInfo("Archived patient restored", "patient_id", patientID, "healthcare_entity_id", entityID, "user_id", c.GetInt("user_id"))
+	c.JSON(http.StatusOK, gin.H{"message": "Patient restored successfully", "patient_id": patientID})
+}
@@ -0,0 +1,749 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+var (
+	ErrRetentionPolicyNotFound = errors.New("retention policy not found")
+	ErrRetentionPolicyExists   = errors.New("a retention policy already exists for this country")
+	ErrRetentionRunNotFound    = errors.New("retention run not found")
+	ErrRetentionRunInProgress  = errors.New("a retention run is already in progress for this entity")
+	ErrArchivedPatientNotFound = errors.New("archived patient not found")
+	ErrArchiveRestoreConflict  = errors.New("archived patient conflicts with an existing patient")
+)
+
+// Scheduled runs have no user: they are recorded as retentionSystemUserID with
+// retentionSystemActor as the actor
+const (
+	retentionSystemUserID = 0
+	retentionSystemActor  = "retention-job"
+)
+
+// retentionLookupBatch is the number of patients per last-visit lookup
+const retentionLookupBatch = 200
+
+// staleRetentionRunHours is how long a run may stay running before it is considered interrupted
+const staleRetentionRunHours = 6
+
+const retentionPolicyColumns = `
+	id, healthcare_entity_id, country_id, archive_after_years, purge_after_years, purge_action, is_active, notes,
+	created_by, updated_by, created_at, updated_at`
+
+func scanRetentionPolicy(row rowScanner) (*RetentionPolicy, error) {
+	policy := &RetentionPolicy{}
+	err := row.Scan(&policy.ID, &policy.HealthcareEntityID, &policy.CountryID, &policy.ArchiveAfterYears,
+		&policy.PurgeAfterYears, &policy.PurgeAction, &policy.IsActive, &policy.Notes, &policy.CreatedBy,
+		&policy.UpdatedBy, &policy.CreatedAt, &policy.UpdatedAt)
+	return policy, err
+}
+
+const retentionRunColumns = `
+	id, healthcare_entity_id, dry_run, triggered_by, status, started_at, completed_at, patients_evaluated,
+	archived_count, anonymised_count, deleted_count, skipped_count, failed_count, error`
+
+func scanRetentionRun(row rowScanner) (*RetentionRun, error) {
+	run := &RetentionRun{}
+	err := row.Scan(&run.ID, &run.HealthcareEntityID, &run.DryRun, &run.TriggeredBy, &run.Status, &run.StartedAt,
+		&run.CompletedAt, &run.PatientsEvaluated, &run.ArchivedCount, &run.AnonymisedCount, &run.DeletedCount,
+		&run.SkippedCount, &run.FailedCount, &run.Error)
+	return run, err
+}
+
+// retentionCandidate is a patient, in the hot tables or archived, old enough to be due for an action
+type retentionCandidate struct {
+	patientID         int
+	mrn               string
+	archived          bool
+	lastActivity      time.Time // creation date, or the recorded last activity of archived patients
+	policyID          int
+	archiveAfterYears *int
+	purgeAfterYears   *int
+	purgeAction       string
+}
+
+// dueAction returns the action due for the candidate given its last activity, or "" if none
+func (c *retentionCandidate) dueAction(lastActivity, now time.Time) string {
+	if c.purgeAfterYears != nil && lastActivity.Before(now.AddDate(-*c.purgeAfterYears, 0, 0)) {
+		return c.purgeAction
+	}
+	if !c.archived && c.archiveAfterYears != nil && lastActivity.Before(now.AddDate(-*c.archiveAfterYears, 0, 0)) {
+		return "archive"
+	}
+	return ""
+}
+
+// RetentionService manages retention policies and runs them against patients
+type RetentionService struct {
+	db                *sql.DB
+	storage           DocumentStorage
+	appointmentClient *AppointmentClient
+}
+
+// NewRetentionService creates a new retention service
+func NewRetentionService(db *sql.DB, storage DocumentStorage, appointmentClient *AppointmentClient) *RetentionService {
+	return &RetentionService{db: db, storage: storage, appointmentClient: appointmentClient}
+}
+
+// ListPolicies lists the retention policies of an entity, entity default first
+func (s *RetentionService) ListPolicies(entityID int) ([]RetentionPolicy, error) {
+	rows, err := s.db.Query(`SELECT `+retentionPolicyColumns+`
+		FROM retention_policies
+		WHERE healthcare_entity_id = $1
+		ORDER BY country_id NULLS FIRST, id
+	`, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []RetentionPolicy{}
+	for rows.Next() {
+		policy, err := scanRetentionPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *policy)
+	}
+	return policies, rows.Err()
+}
+
+// GetPolicy gets a retention policy of an entity
+func (s *RetentionService) GetPolicy(entityID, policyID int) (*RetentionPolicy, error) {
+	policy, err := scanRetentionPolicy(s.db.QueryRow(`SELECT `+retentionPolicyColumns+`
+		FROM retention_policies
+		WHERE id = $1 AND healthcare_entity_id = $2
+	`, policyID, entityID))
+	if err == sql.ErrNoRows {
+		return nil, ErrRetentionPolicyNotFound
+	}
+	return policy, err
+}
+
+// CreatePolicy creates a retention policy; there is at most one per entity and country
+func (s *RetentionService) CreatePolicy(policy *RetentionPolicy) error {
+	err := s.db.QueryRow(`
+		INSERT INTO retention_policies (healthcare_entity_id, country_id, archive_after_years, purge_after_years,
+			purge_action, is_active, notes, created_by, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING id, created_at, updated_at
+	`, policy.HealthcareEntityID, policy.CountryID, policy.ArchiveAfterYears, policy.PurgeAfterYears,
+		policy.PurgeAction, policy.IsActive, policy.Notes, policy.CreatedBy,
+	).Scan(&policy.ID, &policy.CreatedAt, &policy.UpdatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrRetentionPolicyExists
+	}
+	if err != nil {
+		return err
+	}
+	policy.UpdatedBy = policy.CreatedBy
+	return nil
+}
+
+// UpdatePolicy updates a retention policy
+func (s *RetentionService) UpdatePolicy(policy *RetentionPolicy) error {
+	err := s.db.QueryRow(`
+		UPDATE retention_policies
+		SET country_id = $3, archive_after_years = $4, purge_after_years = $5, purge_action = $6, is_active = $7,
+			notes = $8, updated_by = $9, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND healthcare_entity_id = $2
+		RETURNING updated_at
+	`, policy.ID, policy.HealthcareEntityID, policy.CountryID, policy.ArchiveAfterYears, policy.PurgeAfterYears,
+		policy.PurgeAction, policy.IsActive, policy.Notes, policy.UpdatedBy,
+	).Scan(&policy.UpdatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrRetentionPolicyExists
+	}
+	if err == sql.ErrNoRows {
+		return ErrRetentionPolicyNotFound
+	}
+	return err
+}
+
+// DeletePolicy deletes a retention policy
+func (s *RetentionService) DeletePolicy(entityID, policyID int) error {
+	result, err := s.db.Exec(`DELETE FROM retention_policies WHERE id = $1 AND healthcare_entity_id = $2`, policyID, entityID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrRetentionPolicyNotFound
+	}
+	return nil
+}
+
+// StartRun records a new run of an entity. Runs left running by a stopped instance are marked
+// as failed first, so they do not block new runs forever.
+func (s *RetentionService) StartRun(entityID int, dryRun bool, triggeredBy *int) (*RetentionRun, error) {
+	_, err := s.db.Exec(`
+		UPDATE retention_runs
+		SET status = 'failed', error = 'Run interrupted', completed_at = CURRENT_TIMESTAMP
+		WHERE healthcare_entity_id = $1 AND status = 'running'
+		  AND started_at < CURRENT_TIMESTAMP - make_interval(hours => $2)
+	`, entityID, staleRetentionRunHours)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := scanRetentionRun(s.db.QueryRow(`
+		INSERT INTO retention_runs (healthcare_entity_id, dry_run, triggered_by)
+		VALUES ($1, $2, $3)
+		RETURNING `+retentionRunColumns, entityID, dryRun, triggeredBy))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrRetentionRunInProgress
+	}
+	return run, err
+}
+
+// ListRuns lists the most recent runs of an entity
+func (s *RetentionService) ListRuns(entityID, limit int) ([]RetentionRun, error) {
+	rows, err := s.db.Query(`SELECT `+retentionRunColumns+`
+		FROM retention_runs
+		WHERE healthcare_entity_id = $1
+		ORDER BY started_at DESC, id DESC
+		LIMIT $2
+	`, entityID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []RetentionRun{}
+	for rows.Next() {
+		run, err := scanRetentionRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+	return runs, rows.Err()
+}
+
+// GetRun gets a run of an entity with its report items
+func (s *RetentionService) GetRun(entityID, runID int) (*RetentionRun, error) {
+	run, err := scanRetentionRun(s.db.QueryRow(`SELECT `+retentionRunColumns+`
+		FROM retention_runs
+		WHERE id = $1 AND healthcare_entity_id = $2
+	`, runID, entityID))
+	if err == sql.ErrNoRows {
+		return nil, ErrRetentionRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, run_id, patient_id, mrn, policy_id, action, outcome, last_activity_at, detail, processed_at
+		FROM retention_run_items
+		WHERE run_id = $1
+		ORDER BY id
+	`, run.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	run.Items = []RetentionRunItem{}
+	for rows.Next() {
+		var item RetentionRunItem
+		if err := rows.Scan(&item.ID, &item.RunID, &item.PatientID, &item.MRN, &item.PolicyID, &item.Action,
+			&item.Outcome, &item.LastActivityAt, &item.Detail, &item.ProcessedAt); err != nil {
+			return nil, err
+		}
+		run.Items = append(run.Items, item)
+	}
+	return run, rows.Err()
+}
+
+// lastVisitLookup returns the last visit of each of a batch of patients of the run's entity
+type lastVisitLookup func(patientIDs []int) (map[int]time.Time, error)
+
+// Execute evaluates the entity's policies and, unless the run is a dry run, archives, anonymises
+// or deletes the patients that are due. Every patient due for an action gets a report item.
+// The appointment lookups are made with the given identity headers.
+func (s *RetentionService) Execute(run *RetentionRun, access DocumentAccess, headers map[string]string) error {
+	return s.finish(run, s.execute(run, access, func(patientIDs []int) (map[int]time.Time, error) {
+		return s.appointmentClient.GetLastVisits(patientIDs, headers)
+	}))
+}
+
+// executeScheduled is Execute for the scheduled job: it acts as the system, and looks the
+// appointments up on the appointment-service's internal route
+func (s *RetentionService) executeScheduled(run *RetentionRun) error {
+	access := DocumentAccess{UserID: retentionSystemUserID, Actor: retentionSystemActor, UserAgent: retentionSystemActor}
+	return s.finish(run, s.execute(run, access, func(patientIDs []int) (map[int]time.Time, error) {
+		return s.appointmentClient.GetLastVisitsInternal(run.HealthcareEntityID, patientIDs)
+	}))
+}
+
+// finish records the outcome of a run
+func (s *RetentionService) finish(run *RetentionRun, runErr error) error {
+	status, message := "completed", ""
+	if runErr != nil {
+		status, message = "failed", runErr.Error()
+	}
+
+	err := s.db.QueryRow(`
+		UPDATE retention_runs
+		SET status = $2, error = $3, completed_at = CURRENT_TIMESTAMP, patients_evaluated = $4, archived_count = $5,
+			anonymised_count = $6, deleted_count = $7, skipped_count = $8, failed_count = $9
+		WHERE id = $1
+		RETURNING completed_at
+	`, run.ID, status, message, run.PatientsEvaluated, run.ArchivedCount, run.AnonymisedCount, run.DeletedCount,
+		run.SkippedCount, run.FailedCount).Scan(&run.CompletedAt)
+	if err != nil {
+		logging.LogError("Failed to record retention run result", "error", err, "run_id", run.ID)
+	}
+	run.Status, run.Error = status, message
+
+	logging.LogInfo("Retention run finished", "run_id", run.ID, "healthcare_entity_id", run.HealthcareEntityID,
+		"dry_run", run.DryRun, "status", status, "evaluated", run.PatientsEvaluated, "archived", run.ArchivedCount,
+		"anonymised", run.AnonymisedCount, "deleted", run.DeletedCount, "skipped", run.SkippedCount, "failed", run.FailedCount)
+	if runErr != nil {
+		return runErr
+	}
+	return err
+}
+
+func (s *RetentionService) execute(run *RetentionRun, access DocumentAccess, lastVisitsOf lastVisitLookup) error {
+	candidates, err := s.findCandidates(run.HealthcareEntityID)
+	if err != nil {
+		return fmt.Errorf("failed to find candidates: %w", err)
+	}
+	run.PatientsEvaluated = len(candidates)
+
+	now := time.Now()
+	for start := 0; start < len(candidates); start += retentionLookupBatch {
+		batch := candidates[start:min(start+retentionLookupBatch, len(candidates))]
+
+		// Never act without knowing the appointments: a lookup failure fails the run
+		ids := make([]int, len(batch))
+		for i, c := range batch {
+			ids[i] = c.patientID
+		}
+		lastVisits, err := lastVisitsOf(ids)
+		if err != nil {
+			return fmt.Errorf("failed to get last visits: %w", err)
+		}
+
+		for i := range batch {
+			c := &batch[i]
+			lastActivity := c.lastActivity
+			if visit, ok := lastVisits[c.patientID]; ok && visit.After(lastActivity) {
+				lastActivity = visit
+			}
+			action := c.dueAction(lastActivity, now)
+			if action == "" {
+				continue
+			}
+
+			item := RetentionRunItem{
+				RunID:          run.ID,
+				PatientID:      c.patientID,
+				MRN:            c.mrn,
+				PolicyID:       c.policyID,
+				Action:         action,
+				LastActivityAt: lastActivity,
+			}
+			if run.DryRun {
+				item.Outcome, item.Detail, err = s.plan(c)
+			} else {
+				item.Outcome, item.Detail, err = s.apply(run, c, action, lastActivity, access)
+			}
+			if err != nil {
+				logging.LogError("Retention action failed", "error", err, "run_id", run.ID, "patient_id", c.patientID, "action", action)
+				item.Outcome, item.Detail = "failed", err.Error()
+			}
+			run.count(item)
+
+			if err := s.insertItem(&item); err != nil {
+				return fmt.Errorf("failed to record report item: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// count adds a report item to the run totals
+func (run *RetentionRun) count(item RetentionRunItem) {
+	switch item.Outcome {
+	case "skipped":
+		run.SkippedCount++
+	case "failed":
+		run.FailedCount++
+	case "done":
+		switch item.Action {
+		case "archive":
+			run.ArchivedCount++
+		case "anonymise":
+			run.AnonymisedCount++
+		case "delete":
+			run.DeletedCount++
+		}
+	}
+}
+
+func (s *RetentionService) insertItem(item *RetentionRunItem) error {
+	return s.db.QueryRow(`
+		INSERT INTO retention_run_items (run_id, patient_id, mrn, policy_id, action, outcome, last_activity_at, detail)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, processed_at
+	`, item.RunID, item.PatientID, item.MRN, item.PolicyID, item.Action, item.Outcome, item.LastActivityAt, item.Detail,
+	).Scan(&item.ID, &item.ProcessedAt)
+}
+
+// findCandidates lists the patients of an entity old enough for their policy to apply: patients
+// in the hot tables created before the shortest period of their policy, and archived patients
+// whose recorded last activity is older than the purge period. A country policy takes
+// precedence over the entity default. Anonymised patients are no longer subject to retention.
+func (s *RetentionService) findCandidates(entityID int) ([]retentionCandidate, error) {
+	rows, err := s.db.Query(`
+		SELECT p.id, p.mrn, false, COALESCE(p.created_at, CURRENT_TIMESTAMP),
+			pol.id, pol.archive_after_years, pol.purge_after_years, pol.purge_action
+		FROM patients p
+		JOIN LATERAL (
+			SELECT r.* FROM retention_policies r
+			WHERE r.healthcare_entity_id = p.healthcare_entity_id AND r.is_active
+			  AND (r.country_id = p.country_id OR r.country_id IS NULL)
+			ORDER BY r.country_id NULLS LAST
+			LIMIT 1
+		) pol ON true
+		WHERE p.healthcare_entity_id = $1 AND p.anonymised_at IS NULL
+		  AND p.created_at < CURRENT_TIMESTAMP - make_interval(years => LEAST(pol.archive_after_years, pol.purge_after_years))
+		UNION ALL
+		SELECT a.patient_id, a.mrn, true, a.last_activity_at,
+			pol.id, pol.archive_after_years, pol.purge_after_years, pol.purge_action
+		FROM patient_archive a
+		JOIN LATERAL (
+			SELECT r.* FROM retention_policies r
+			WHERE r.healthcare_entity_id = a.healthcare_entity_id AND r.is_active
+			  AND (r.country_id = a.country_id OR r.country_id IS NULL)
+			ORDER BY r.country_id NULLS LAST
+			LIMIT 1
+		) pol ON true
+		WHERE a.healthcare_entity_id = $1 AND pol.purge_after_years IS NOT NULL
+		  AND a.last_activity_at < CURRENT_TIMESTAMP - make_interval(years => pol.purge_after_years)
+		ORDER BY 1
+	`, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []retentionCandidate
+	for rows.Next() {
+		var c retentionCandidate
+		if err := rows.Scan(&c.patientID, &c.mrn, &c.archived, &c.lastActivity, &c.policyID, &c.archiveAfterYears,
+			&c.purgeAfterYears, &c.purgeAction); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// blockingReason explains why a patient in the hot tables must be left alone, or returns ""
+func blockingReason(q queryRower, patientID int) (string, error) {
+	held, holdReasons, err := activeLegalHoldReasons(q, patientID)
+	if err != nil {
+		return "", err
+	}
+	if held {
+		return "Legal hold: " + holdReasons, nil
+	}
+
+	var openRequest, guardianOfMinor bool
+	err = q.QueryRow(`
+		SELECT
+			EXISTS (SELECT 1 FROM data_subject_requests WHERE patient_id = $1 AND status IN ('pending', 'processing')),
+			EXISTS (
+				SELECT 1 FROM patient_relationships r
+				JOIN patients m ON m.id = r.patient_id
+				WHERE r.related_patient_id = $1 AND r.is_legal_guardian AND m.is_active
+				  AND m.date_of_birth > CURRENT_DATE - make_interval(years => $2)
+			)
+	`, patientID, adultAge).Scan(&openRequest, &guardianOfMinor)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case openRequest:
+		return "Open data subject request", nil
+	case guardianOfMinor:
+		return "Legal guardian of a minor patient", nil
+	}
+	return "", nil
+}
+
+// plan reports what a dry run would do with a candidate
+func (s *RetentionService) plan(c *retentionCandidate) (string, string, error) {
+	if c.archived {
+		return "planned", "", nil
+	}
+	reason, err := blockingReason(s.db, c.patientID)
+	if err != nil {
+		return "", "", err
+	}
+	if reason != "" {
+		return "skipped", reason, nil
+	}
+	return "planned", "", nil
+}
+
+// apply archives, anonymises or deletes a candidate in its own transaction and returns the
+// report outcome and detail
+func (s *RetentionService) apply(run *RetentionRun, c *retentionCandidate, action string, lastActivity time.Time, access DocumentAccess) (string, string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	if c.archived && action == "anonymise" {
+		// Anonymised records live in the hot tables
+		if _, err := tx.Exec(`SELECT restore_patient($1)`, c.patientID); err != nil {
+			return "", "", fmt.Errorf("failed to restore archived patient: %w", err)
+		}
+	}
+	if !c.archived || action == "anonymise" {
+		// Lock the patient row so a hold placed concurrently is seen by one side or the other
+		var patientID int
+		err := tx.QueryRow(`SELECT id FROM patients WHERE id = $1 AND anonymised_at IS NULL FOR UPDATE`, c.patientID).Scan(&patientID)
+		if err == sql.ErrNoRows {
+			return "skipped", "Patient no longer eligible", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		reason, err := blockingReason(tx, c.patientID)
+		if err != nil {
+			return "", "", err
+		}
+		if reason != "" {
+			return "skipped", reason, nil
+		}
+	}
+
+	var detail string
+	var purgeContent func()
+	switch action {
+	case "archive":
+		if _, err := tx.Exec(`SELECT archive_patient($1, $2, $3)`, c.patientID, lastActivity, run.ID); err != nil {
+			return "", "", fmt.Errorf("failed to archive patient: %w", err)
+		}
+	case "anonymise":
+		erasure, err := anonymisePatient(tx, c.patientID, access, fmt.Sprintf("Retention run #%d", run.ID))
+		if err != nil {
+			return "", "", err
+		}
+		detail = fmt.Sprintf("%d consents revoked, %d relationships removed, %d documents purged",
+			erasure.consentsRevoked, erasure.relationshipsRemoved, len(erasure.documents))
+		purgeContent = func() { erasure.purgeContent(s.storage, c.patientID) }
+	case "delete":
+		keys, err := deletePatientRecords(tx, c.patientID, c.archived)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to delete patient: %w", err)
+		}
+		detail = fmt.Sprintf("%d stored files purged", len(keys))
+		purgeContent = func() {
+			for _, key := range keys {
+				if err := s.storage.Delete(key); err != nil {
+					logging.LogError("Failed to purge stored content", "error", err, "patient_id", c.patientID)
+				}
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	// Content is removed only once the change is committed
+	if purgeContent != nil {
+		purgeContent()
+	}
+	return "done", detail, nil
+}
+
+// deletePatientRecords deletes a patient, from the hot tables or the archive, and returns the
+// storage keys of its documents and export archives
+func deletePatientRecords(tx *sql.Tx, patientID int, archived bool) ([]string, error) {
+	query := `
+		SELECT storage_key FROM patient_documents WHERE patient_id = $1
+		UNION ALL
+		SELECT archive_storage_key FROM data_subject_requests WHERE patient_id = $1 AND archive_storage_key IS NOT NULL`
+	if archived {
+		query = `
+			SELECT d->>'storage_key'
+			FROM patient_archive a, jsonb_array_elements(a.records->'patient_documents') d
+			WHERE a.patient_id = $1
+			UNION ALL
+			SELECT r->>'archive_storage_key'
+			FROM patient_archive a, jsonb_array_elements(a.records->'data_subject_requests') r
+			WHERE a.patient_id = $1 AND r->>'archive_storage_key' IS NOT NULL`
+	}
+	rows, err := tx.Query(query, patientID)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if archived {
+		_, err = tx.Exec(`DELETE FROM patient_archive WHERE patient_id = $1`, patientID)
+		return keys, err
+	}
+	// data_subject_requests does not cascade; everything else goes with the patient row
+	if _, err := tx.Exec(`DELETE FROM data_subject_requests WHERE patient_id = $1`, patientID); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM patients WHERE id = $1`, patientID)
+	return keys, err
+}
+
+// ListArchivedPatients lists an entity's archived patients, optionally filtered by MRN
+func (s *RetentionService) ListArchivedPatients(entityID int, mrn string, limit, offset int) ([]ArchivedPatient, error) {
+	rows, err := s.db.Query(`
+		SELECT patient_id, healthcare_entity_id, mrn, patient->>'first_name', patient->>'last_name', country_id,
+			last_activity_at, archived_at, retention_run_id
+		FROM patient_archive
+		WHERE healthcare_entity_id = $1 AND ($2 = '' OR mrn = $2)
+		ORDER BY archived_at DESC, patient_id
+		LIMIT $3 OFFSET $4
+	`, entityID, mrn, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	patients := []ArchivedPatient{}
+	for rows.Next() {
+		var p ArchivedPatient
+		if err := rows.Scan(&p.PatientID, &p.HealthcareEntityID, &p.MRN, &p.FirstName, &p.LastName, &p.CountryID,
+			&p.LastActivityAt, &p.ArchivedAt, &p.RetentionRunID); err != nil {
+			return nil, err
+		}
+		patients = append(patients, p)
+	}
+	return patients, rows.Err()
+}
+
+// RestorePatient moves an archived patient of the entity back into the hot tables
+func (s *RetentionService) RestorePatient(entityID, patientID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM patient_archive WHERE patient_id = $1 AND healthcare_entity_id = $2)
+	`, patientID, entityID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrArchivedPatientNotFound
+	}
+
+	_, err = tx.Exec(`SELECT restore_patient($1)`, patientID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrArchiveRestoreConflict
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RunScheduled runs the policies of every entity that has an active policy
+func (s *RetentionService) RunScheduled(dryRun bool) {
+	rows, err := s.db.Query(`SELECT DISTINCT healthcare_entity_id FROM retention_policies WHERE is_active ORDER BY 1`)
+	if err != nil {
+		logging.LogError("Failed to list entities with retention policies", "error", err)
+		return
+	}
+	var entityIDs []int
+	for rows.Next() {
+		var entityID int
+		if err := rows.Scan(&entityID); err != nil {
+			rows.Close()
+			logging.LogError("Failed to list entities with retention policies", "error", err)
+			return
+		}
+		entityIDs = append(entityIDs, entityID)
+	}
+	rows.Close()
+
+	for _, entityID := range entityIDs {
+		run, err := s.StartRun(entityID, dryRun, nil)
+		if err != nil {
+			if errors.Is(err, ErrRetentionRunInProgress) {
+				logging.LogInfo("Retention run already in progress", "healthcare_entity_id", entityID)
+				continue
+			}
+			logging.LogError("Failed to start retention run", "error", err, "healthcare_entity_id", entityID)
+			continue
+		}
+		if err := s.executeScheduled(run); err != nil {
+			logging.LogError("Retention run failed", "error", err, "run_id", run.ID, "healthcare_entity_id", entityID)
+		}
+	}
+}
+
+// StartScheduleFromEnv runs RunScheduled every RETENTION_JOB_INTERVAL (a duration such as 24h).
+// The job is off unless the interval is set, and its runs are dry runs unless
+// RETENTION_JOB_DRY_RUN is explicitly false.
+func (s *RetentionService) StartScheduleFromEnv() {
+	v := os.Getenv("RETENTION_JOB_INTERVAL")
+	if v == "" {
+		logging.LogInfo("Retention job disabled")
+		return
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		logging.LogWarn("Invalid RETENTION_JOB_INTERVAL, retention job disabled", "value", v)
+		return
+	}
+	dryRun := true
+	if v := os.Getenv("RETENTION_JOB_DRY_RUN"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			logging.LogWarn("Invalid RETENTION_JOB_DRY_RUN, scheduled runs are dry runs", "value", v)
+		} else {
+			dryRun = parsed
+		}
+	}
+
+	logging.LogInfo("Retention job scheduled", "interval", interval.String(), "dry_run", dryRun)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.RunScheduled(dryRun)
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRetentionDueAction(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	years := func(n int) *int { return &n }
+	yearsAgo := func(n int) time.Time { return now.AddDate(-n, 0, -1) }
+	tests := []struct {
+		name         string
+		candidate    retentionCandidate
+		lastActivity time.Time
+		want         string
+	}{
+		{"recent activity", retentionCandidate{archiveAfterYears: years(5), purgeAfterYears: years(20), purgeAction: "anonymise"}, yearsAgo(2), ""},
+		{"archive due", retentionCandidate{archiveAfterYears: years(5), purgeAfterYears: years(20), purgeAction: "anonymise"}, yearsAgo(6), "archive"},
+		{"purge wins over archive", retentionCandidate{archiveAfterYears: years(5), purgeAfterYears: years(20), purgeAction: "delete"}, yearsAgo(21), "delete"},
+		{"archived patient isn't archived again", retentionCandidate{archived: true, archiveAfterYears: years(5), purgeAfterYears: years(20), purgeAction: "anonymise"}, yearsAgo(6), ""},
+		{"archived patient is purged", retentionCandidate{archived: true, archiveAfterYears: years(5), purgeAfterYears: years(20), purgeAction: "anonymise"}, yearsAgo(21), "anonymise"},
+		{"policy without purge", retentionCandidate{archiveAfterYears: years(5)}, yearsAgo(50), "archive"},
+		{"policy without archive", retentionCandidate{purgeAfterYears: years(10), purgeAction: "delete"}, yearsAgo(6), ""},
+		{"exactly at the limit", retentionCandidate{archiveAfterYears: years(5)}, now.AddDate(-5, 0, 0), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.candidate.dueAction(tt.lastActivity, now); got != tt.want {
+				t.Errorf("dueAction = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockingReason(t *testing.T) {
+	tests := []struct {
+		name            string
+		holdReasons     string
+		openRequest     bool
+		guardianOfMinor bool
+		want            string
+	}{
+		{"nothing blocks", "", false, false, ""},
+		{"legal hold", "Pending litigation", false, false, "Legal hold: Pending litigation"},
+		{"open data subject request", "", true, false, "Open data subject request"},
+		{"guardian of a minor", "", false, true, "Legal guardian of a minor patient"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			holds := 0
+			if tt.holdReasons != "" {
+				holds = 1
+			}
+			mock.ExpectQuery(`SELECT COUNT\(\*\), string_agg\(reason`).WithArgs(5).
+				WillReturnRows(sqlmock.NewRows([]string{"count", "string_agg"}).AddRow(holds, tt.holdReasons))
+			if holds == 0 {
+				mock.ExpectQuery(`FROM data_subject_requests`).WithArgs(5, adultAge).
+					WillReturnRows(sqlmock.NewRows([]string{"open_request", "guardian"}).AddRow(tt.openRequest, tt.guardianOfMinor))
+			}
+
+			got, err := blockingReason(db, 5)
+			if err != nil {
+				t.Fatalf("blockingReason error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("blockingReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionRunCount(t *testing.T) {
+	run := &RetentionRun{}
+	for _, item := range []RetentionRunItem{
+		{Action: "archive", Outcome: "done"},
+		{Action: "archive", Outcome: "done"},
+		{Action: "anonymise", Outcome: "done"},
+		{Action: "delete", Outcome: "done"},
+		{Action: "delete", Outcome: "skipped"},
+		{Action: "archive", Outcome: "failed"},
+		{Action: "archive", Outcome: "planned"},
+	} {
+		run.count(item)
+	}
+	if run.ArchivedCount != 2 || run.AnonymisedCount != 1 || run.DeletedCount != 1 || run.SkippedCount != 1 || run.FailedCount != 1 {
+		t.Errorf("counts = %+v", run)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// TimelineHandler groups patient timeline handlers
+type TimelineHandler struct {
+	timelineService *TimelineService
+	patientService  *PatientService
+}
+
+// NewTimelineHandler constructs a new TimelineHandler
+func NewTimelineHandler(timelineService *TimelineService, patientService *PatientService) *TimelineHandler {
+	return &TimelineHandler{timelineService: timelineService, patientService: patientService}
+}
+
+// GetTimeline returns the patient's events across services, newest first (?categories=,
+// ?from=, ?to=, ?limit=, ?offset=)
+func (h *TimelineHandler) GetTimeline(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+	filter := TimelineFilter{From: from, To: to}
+
+	if categories := c.Query("categories"); categories != "" {
+		known := map[string]bool{}
+		for _, category := range append(timelineLocalCategories, timelineAppointmentCategories...) {
+			known[category] = true
+		}
+		for _, category := range strings.Split(categories, ",") {
+			category = strings.TrimSpace(category)
+			if !known[category] {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   "Invalid category",
+					"details": "categories must be a comma-separated list of " + strings.Join(append(timelineLocalCategories, timelineAppointmentCategories...), ", "),
+				})
+				return
+			}
+			filter.Categories = append(filter.Categories, category)
+		}
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	if offset+limit > maxTimelineWindow {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Offset too large",
+			"details": "offset + limit must not exceed " + strconv.Itoa(maxTimelineWindow) + "; narrow the time range instead",
+		})
+		return
+	}
+	filter.Limit, filter.Offset = limit, offset
+
+	timeline, err := h.timelineService.GetPatientTimeline(patient, filter, forwardedIdentityHeaders(c))
+	if err != nil {
+		logging.LogError("Failed to get patient timeline", "error", err, "patient_id", patient.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get patient timeline"})
+		return
+	}
+	if timeline.Degraded {
+		logging.LogWarn("Patient timeline is incomplete", "patient_id", patient.ID, "sections", timeline.Sections)
+	}
+
+	c.JSON(http.StatusOK, timeline)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// timelineLocalCategories are the timeline categories recorded by this service
+var timelineLocalCategories = []string{"record", "clinical", "vitals", "document", "consent", "relationship"}
+
+// timelineAppointmentCategories are the timeline categories recorded by the appointment-service
+var timelineAppointmentCategories = []string{"appointment", "encounter_note"}
+
+// maxTimelineWindow caps offset + limit: every source is read from the most recent event down
+// to the end of the requested page
+const maxTimelineWindow = 1000
+
+// TimelineService merges the events of a patient recorded across services into one stream
+type TimelineService struct {
+	db                *sql.DB
+	appointmentClient *AppointmentClient
+	entityClient      *EntityClient
+}
+
+// NewTimelineService creates a new timeline service
+func NewTimelineService(db *sql.DB, appointmentClient *AppointmentClient, entityClient *EntityClient) *TimelineService {
+	return &TimelineService{db: db, appointmentClient: appointmentClient, entityClient: entityClient}
+}
+
+// selectedCategories returns the categories of all that the filter selects
+func (f TimelineFilter) selectedCategories(all []string) []string {
+	if len(f.Categories) == 0 {
+		return all
+	}
+	selected := []string{}
+	for _, category := range all {
+		for _, wanted := range f.Categories {
+			if category == wanted {
+				selected = append(selected, category)
+			}
+		}
+	}
+	return selected
+}
+
+// GetPatientTimeline returns a page of the patient's timeline. The appointment-service and the
+// user names are optional: when they cannot be read, their section is degraded and the page
+// holds what could be read.
+func (s *TimelineService) GetPatientTimeline(patient *Patient, filter TimelineFilter, headers map[string]string) (*PatientTimeline, error) {
+	window := filter.Offset + filter.Limit
+	timeline := &PatientTimeline{PatientID: patient.ID, Events: []TimelineEvent{}, Limit: filter.Limit, Offset: filter.Offset}
+
+	type remoteResult struct {
+		events []TimelineEvent
+		total  int
+		err    error
+	}
+	appointmentCategories := filter.selectedCategories(timelineAppointmentCategories)
+	var remote chan remoteResult
+	if len(appointmentCategories) > 0 {
+		remote = make(chan remoteResult, 1)
+		go func() {
+			events, total, err := s.appointmentClient.GetPatientTimeline(patient.ID, appointmentCategories, filter.From, filter.To, window, headers)
+			remote <- remoteResult{events, total, err}
+		}()
+	}
+
+	events, total, err := s.localEvents(patient.ID, filter.selectedCategories(timelineLocalCategories), filter, window)
+	if err != nil {
+		return nil, err
+	}
+	timeline.Sections = append(timeline.Sections, TimelineSection{Name: "patient_records", Status: "ok"})
+	timeline.TotalCount = total
+
+	if remote == nil {
+		timeline.Sections = append(timeline.Sections, TimelineSection{Name: "appointments", Status: "skipped"})
+	} else if result := <-remote; result.err != nil {
+		timeline.Sections = append(timeline.Sections, TimelineSection{Name: "appointments", Status: "degraded", Error: result.err.Error()})
+	} else {
+		timeline.Sections = append(timeline.Sections, TimelineSection{Name: "appointments", Status: "ok"})
+		events = append(events, result.events...)
+		timeline.TotalCount += result.total
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].OccurredAt.Equal(events[j].OccurredAt) {
+			return events[i].OccurredAt.After(events[j].OccurredAt)
+		}
+		return events[i].ID > events[j].ID
+	})
+	if filter.Offset < len(events) {
+		timeline.Events = events[filter.Offset:min(window, len(events))]
+	}
+
+	timeline.Sections = append(timeline.Sections, s.resolveActors(timeline.Events, headers))
+	for _, section := range timeline.Sections {
+		if section.Status == "degraded" {
+			timeline.Degraded = true
+		}
+	}
+	return timeline, nil
+}
+
+// resolveActors sets the actor names of the events from user-service
+func (s *TimelineService) resolveActors(events []TimelineEvent, headers map[string]string) TimelineSection {
+	seen := map[int]bool{}
+	ids := []int{}
+	for _, e := range events {
+		if e.ActorID != nil && *e.ActorID > 0 && !seen[*e.ActorID] {
+			seen[*e.ActorID] = true
+			ids = append(ids, *e.ActorID)
+		}
+	}
+	if len(ids) == 0 {
+		return TimelineSection{Name: "actors", Status: "skipped"}
+	}
+
+	actors, err := s.entityClient.UsersByIDs(ids, headers)
+	if err != nil {
+		return TimelineSection{Name: "actors", Status: "degraded", Error: err.Error()}
+	}
+	for i := range events {
+		if events[i].ActorID == nil {
+			continue
+		}
+		if actor, ok := actors[*events[i].ActorID]; ok {
+			events[i].Actor = &actor
+		}
+	}
+	return TimelineSection{Name: "actors", Status: "ok"}
+}
+
+// timelineEventsQuery selects the events of patient $1 recorded by this service, in
+// categories $2, between $3 and $4, most recent first, limited to $5, with their total
+const timelineEventsQuery = `
+	WITH events AS (
+		SELECT 'patient:' || p.id AS id, p.created_at AS occurred_at, 'record' AS category, 'patient.registered' AS type,
+			p.created_by AS actor_id, COALESCE(p.mrn, '') AS label, '{}'::JSONB AS details
+		FROM patients p WHERE p.id = $1
+		UNION ALL
+		SELECT 'patient-version:' || v.id, v.valid_from, 'record', 'patient.' || v.change_type, v.changed_by, v.change_reason,
+			jsonb_build_object('version', v.version, 'changed_fields', to_jsonb(v.changed_fields),
+				'restored_from_version', v.restored_from_version)
+		FROM patient_versions v WHERE v.patient_id = $1 AND v.change_type NOT IN ('migrated', 'created')
+		UNION ALL
+		SELECT 'clinical:' || h.id, h.changed_at, 'clinical', h.item_type || '.' || h.action, h.changed_by,
+			COALESCE(h.snapshot->>'substance', h.snapshot->>'name', h.snapshot->>'description', ''),
+			jsonb_build_object('item_type', h.item_type, 'item_id', h.item_id, 'status', h.snapshot->>'status')
+		FROM patient_clinical_history h WHERE h.patient_id = $1
+		UNION ALL
+		SELECT 'vitals:' || v.id, v.measured_at, 'vitals', 'vitals.recorded', v.recorded_by, '',
+			jsonb_build_object('vitals_id', v.id, 'appointment_id', v.appointment_id)
+		FROM patient_vitals v WHERE v.patient_id = $1
+		UNION ALL
+		SELECT 'vitals-deleted:' || v.id, v.deleted_at, 'vitals', 'vitals.deleted', v.deleted_by, v.deletion_reason,
+			jsonb_build_object('vitals_id', v.id)
+		FROM patient_vitals v WHERE v.patient_id = $1 AND v.deleted_at IS NOT NULL
+		UNION ALL
+		SELECT 'document:' || d.id, d.created_at, 'document', 'document.uploaded', d.uploaded_by, d.file_name,
+			jsonb_build_object('document_id', d.id, 'document_type', d.document_type, 'appointment_id', d.appointment_id)
+		FROM patient_documents d WHERE d.patient_id = $1
+		UNION ALL
+		SELECT 'document-deleted:' || d.id, d.deleted_at, 'document', 'document.deleted', d.deleted_by, d.file_name,
+			jsonb_build_object('document_id', d.id, 'document_type', d.document_type)
+		FROM patient_documents d WHERE d.patient_id = $1 AND d.deleted_at IS NOT NULL
+		UNION ALL
+		SELECT 'consent:' || c.id, c.granted_at, 'consent', 'consent.granted', c.granted_by, c.consent_type,
+			jsonb_build_object('consent_id', c.id, 'channel', c.grant_channel)
+		FROM patient_consents c WHERE c.patient_id = $1
+		UNION ALL
+		SELECT 'consent-revoked:' || c.id, c.revoked_at, 'consent', 'consent.revoked', c.revoked_by, c.consent_type,
+			jsonb_build_object('consent_id', c.id, 'channel', c.revoke_channel, 'reason', c.revocation_reason)
+		FROM patient_consents c WHERE c.patient_id = $1 AND c.revoked_at IS NOT NULL
+		UNION ALL
+		SELECT 'relationship:' || r.id, r.created_at, 'relationship', 'relationship.created', r.created_by,
+			o.first_name || ' ' || o.last_name,
+			jsonb_build_object('relationship_id', r.id, 'related_patient_id', o.id,
+				'relationship_type', r.relationship_type, 'inverse', r.patient_id <> $1)
+		FROM patient_relationships r
+		JOIN patients o ON o.id = CASE WHEN r.patient_id = $1 THEN r.related_patient_id ELSE r.patient_id END
+		WHERE r.patient_id = $1 OR r.related_patient_id = $1
+	)
+	SELECT id, occurred_at, category, type, actor_id, label, details, COUNT(*) OVER ()
+	FROM events
+	WHERE occurred_at IS NOT NULL AND category = ANY($2)
+	  AND ($3::TIMESTAMP IS NULL OR occurred_at >= $3) AND ($4::TIMESTAMP IS NULL OR occurred_at <= $4)
+	ORDER BY occurred_at DESC, id DESC
+	LIMIT $5
+`
+
+// localEvents reads the most recent events of the patient recorded by this service
+func (s *TimelineService) localEvents(patientID int, categories []string, filter TimelineFilter, limit int) ([]TimelineEvent, int, error) {
+	events := []TimelineEvent{}
+	if len(categories) == 0 {
+		return events, 0, nil
+	}
+	var from, to interface{}
+	if filter.From != nil {
+		from = filter.From.UTC()
+	}
+	if filter.To != nil {
+		to = filter.To.UTC()
+	}
+
+	rows, err := s.db.Query(timelineEventsQuery, patientID, pq.Array(categories), from, to, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get timeline events: %w", err)
+	}
+	defer rows.Close()
+
+	total := 0
+	for rows.Next() {
+		var e TimelineEvent
+		var label string
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.OccurredAt, &e.Category, &e.Type, &e.ActorID, &label, &details, &total); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan timeline event: %w", err)
+		}
+		if err := json.Unmarshal(details, &e.Details); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode timeline event details: %w", err)
+		}
+		e.Source = "patient-service"
+		e.Summary = timelineSummary(e, label)
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// clinicalItemLabels and clinicalActionLabels describe clinical history entries
+var (
+	clinicalItemLabels   = map[string]string{"allergy": "Allergy", "medication": "Medication", "problem": "Problem"}
+	clinicalActionLabels = map[string]string{"created": "added", "updated": "updated", "deleted": "removed", "migrated": "imported"}
+)
+
+// timelineSummary describes an event recorded by this service in one line
+func timelineSummary(e TimelineEvent, label string) string {
+	humanize := func(s string) string { return strings.ReplaceAll(s, "_", " ") }
+	switch e.Type {
+	case "patient.registered":
+		if label != "" {
+			return "Patient registered with MRN " + label
+		}
+		return "Patient registered"
+	case "patient.updated":
+		return "Patient record updated: " + versionChangedFields(e)
+	case "patient.restored":
+		return fmt.Sprintf("Patient record restored to version %v: %s", e.Details["restored_from_version"], label)
+	case "patient.deactivated":
+		return "Patient record deleted"
+	case "patient.anonymised":
+		return "Patient record anonymised"
+	case "vitals.recorded":
+		return "Vital signs recorded"
+	case "vitals.deleted":
+		if label != "" {
+			return "Vital signs marked as entered in error: " + label
+		}
+		return "Vital signs marked as entered in error"
+	case "document.uploaded":
+		return fmt.Sprintf("Document uploaded: %s (%v)", label, humanize(fmt.Sprint(e.Details["document_type"])))
+	case "document.deleted":
+		return "Document deleted: " + label
+	case "consent.granted":
+		return "Consent granted: " + humanize(label)
+	case "consent.revoked":
+		return "Consent revoked: " + humanize(label)
+	case "relationship.created":
+		relationship, _ := e.Details["relationship_type"].(string)
+		if inverse, _ := e.Details["inverse"].(bool); inverse {
+			relationship = relationshipInverse[relationship]
+		}
+		return fmt.Sprintf("Linked to %s as %s", label, humanize(relationship))
+	}
+	if e.Category == "clinical" {
+		itemType, action, _ := strings.Cut(e.Type, ".")
+		return fmt.Sprintf("%s %s: %s", clinicalItemLabels[itemType], clinicalActionLabels[action], label)
+	}
+	return e.Type
+}
+
+// versionChangedFields lists the fields changed by a patient version event
+func versionChangedFields(e TimelineEvent) string {
+	fields, _ := e.Details["changed_fields"].([]interface{})
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = strings.ReplaceAll(fmt.Sprint(field), "_", " ")
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// timelineServers starts an appointment-service answering with one appointment event (or
+// appointmentStatus) and a user-service knowing user 2
+func timelineServers(t *testing.T, appointmentStatus int, appointmentAt time.Time) (*AppointmentClient, *EntityClient) {
+	t.Helper()
+	appointments := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if appointmentStatus != http.StatusOK {
+			w.WriteHeader(appointmentStatus)
+			return
+		}
+		w.Write([]byte(`{"data":{"events":[{"id":"appointment:3","occurred_at":"` + appointmentAt.Format(time.RFC3339) +
+			`","category":"appointment","type":"appointment.scheduled","summary":"Consultation scheduled","actor_id":2,"appointment_id":3}],"total_count":1}}`))
+	}))
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[{"id":2,"first_name":"Karim","last_name":"Tazi","role":"doctor"}]}`))
+	}))
+	t.Cleanup(appointments.Close)
+	t.Cleanup(users.Close)
+	return &AppointmentClient{baseURL: appointments.URL, httpClient: http.DefaultClient},
+		&EntityClient{userServiceURL: users.URL, httpClient: http.DefaultClient}
+}
+
+func expectLocalTimeline(mock sqlmock.Sqlmock, registeredAt, allergyAt time.Time) {
+	mock.ExpectQuery(`WITH events AS`).WithArgs(5, sqlmock.AnyArg(), nil, nil, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "occurred_at", "category", "type", "actor_id", "label", "details", "count"}).
+			AddRow("clinical:8", allergyAt, "clinical", "allergy.created", 2, "Penicillin", []byte(`{"item_type":"allergy"}`), 2).
+			AddRow("patient:5", registeredAt, "record", "patient.registered", 2, "MRN-2025-000005-5", []byte(`{}`), 2))
+}
+
+func TestGetPatientTimelineMergesSources(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	registeredAt, appointmentAt, allergyAt := now.Add(-72*time.Hour), now.Add(-48*time.Hour), now.Add(-24*time.Hour)
+	db, mock := newMockDB(t)
+	expectLocalTimeline(mock, registeredAt, allergyAt)
+	appointments, entities := timelineServers(t, http.StatusOK, appointmentAt)
+
+	timeline, err := NewTimelineService(db, appointments, entities).
+		GetPatientTimeline(&Patient{ID: 5}, TimelineFilter{Limit: 10}, nil)
+	if err != nil {
+		t.Fatalf("GetPatientTimeline error = %v", err)
+	}
+
+	wantIDs := []string{"clinical:8", "appointment:3", "patient:5"}
+	if len(timeline.Events) != len(wantIDs) {
+		t.Fatalf("got %d events, want %d", len(timeline.Events), len(wantIDs))
+	}
+	for i, id := range wantIDs {
+		if timeline.Events[i].ID != id {
+			t.Errorf("event %d = %s, want %s", i, timeline.Events[i].ID, id)
+		}
+	}
+	if timeline.TotalCount != 3 || timeline.Degraded {
+		t.Errorf("total %d, degraded %v, want 3 events complete", timeline.TotalCount, timeline.Degraded)
+	}
+	if summary := timeline.Events[0].Summary; summary != "Allergy added: Penicillin" {
+		t.Errorf("summary = %q", summary)
+	}
+	if actor := timeline.Events[0].Actor; actor == nil || actor.Name != "Karim Tazi" {
+		t.Errorf("actor = %+v, want Karim Tazi", actor)
+	}
+}
+
+func TestGetPatientTimelineDegradesWithoutAppointments(t *testing.T) {
+	now := time.Now().UTC()
+	db, mock := newMockDB(t)
+	expectLocalTimeline(mock, now.Add(-72*time.Hour), now.Add(-24*time.Hour))
+	appointments, entities := timelineServers(t, http.StatusServiceUnavailable, now)
+
+	timeline, err := NewTimelineService(db, appointments, entities).
+		GetPatientTimeline(&Patient{ID: 5}, TimelineFilter{Limit: 10}, nil)
+	if err != nil {
+		t.Fatalf("GetPatientTimeline error = %v", err)
+	}
+	if !timeline.Degraded || len(timeline.Events) != 2 {
+		t.Fatalf("degraded %v with %d events, want the 2 local events degraded", timeline.Degraded, len(timeline.Events))
+	}
+	for _, section := range timeline.Sections {
+		if section.Name == "appointments" && section.Status != "degraded" {
+			t.Errorf("appointments section is %q, want degraded", section.Status)
+		}
+	}
+}
+
+func TestTimelineFilterSelectedCategories(t *testing.T) {
+	filter := TimelineFilter{Categories: []string{"vitals", "appointment"}}
+	if got := filter.selectedCategories(timelineLocalCategories); len(got) != 1 || got[0] != "vitals" {
+		t.Errorf("local categories = %v, want [vitals]", got)
+	}
+	if got := filter.selectedCategories(timelineAppointmentCategories); len(got) != 1 || got[0] != "appointment" {
+		t.Errorf("appointment categories = %v, want [appointment]", got)
+	}
+	if got := (TimelineFilter{}).selectedCategories(timelineLocalCategories); len(got) != len(timelineLocalCategories) {
+		t.Errorf("no filter selected %v, want every category", got)
+	}
+}
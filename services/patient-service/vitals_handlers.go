@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// maxVitalTrendPoints caps the sets of vital signs read for a trend
+const maxVitalTrendPoints = 1000
+
+// VitalsHandler groups vital signs handlers
+type VitalsHandler struct {
+	vitalsService     *VitalsService
+	patientService    *PatientService
+	entityClient      *EntityClient
+	appointmentClient *AppointmentClient
+	validator         *validator.Validate
+}
+
+// NewVitalsHandler constructs a new VitalsHandler
+func NewVitalsHandler(vitalsService *VitalsService, patientService *PatientService, entityClient *EntityClient, appointmentClient *AppointmentClient) *VitalsHandler {
+	return &VitalsHandler{
+		vitalsService:     vitalsService,
+		patientService:    patientService,
+		entityClient:      entityClient,
+		appointmentClient: appointmentClient,
+		validator:         validator.New(),
+	}
+}
+
+// displayUnits returns the unit system to render values in: ?units= when given, else the unit
+// system of the entity's country, falling back to metric when it cannot be looked up
+func (h *VitalsHandler) displayUnits(c *gin.Context, entityID int) (string, bool) {
+	if units := c.Query("units"); units != "" {
+		if units != unitSystemMetric && units != unitSystemImperial {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid units", "details": "units must be metric or imperial"})
+			return "", false
+		}
+		return units, true
+	}
+	system, err := h.entityClient.UnitSystem(entityID, forwardedIdentityHeaders(c))
+	if err != nil {
+		logging.LogWarn("Failed to get entity unit system, using metric", "error", err, "healthcare_entity_id", entityID)
+		return unitSystemMetric, true
+	}
+	return system, true
+}
+
+// parseTimeRange reads ?from= and ?to= (RFC3339 or YYYY-MM-DD, to is inclusive of the day)
+func parseTimeRange(c *gin.Context) (*time.Time, *time.Time, bool) {
+	parse := func(name string, endOfDay bool) (*time.Time, bool) {
+		value := c.Query(name)
+		if value == "" {
+			return nil, true
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return &t, true
+		}
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name, "details": name + " must be an RFC3339 timestamp or a YYYY-MM-DD date"})
+			return nil, false
+		}
+		if endOfDay {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return &t, true
+	}
+	from, ok := parse("from", false)
+	if !ok {
+		return nil, nil, false
+	}
+	to, ok := parse("to", true)
+	if !ok {
+		return nil, nil, false
+	}
+	return from, to, true
+}
+
+// GetVitals lists the patient's vital signs, newest first (?from=, ?to=, ?appointment_id=,
+// ?units=, ?limit=, ?offset=)
+func (h *VitalsHandler) GetVitals(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	system, ok := h.displayUnits(c, patient.HealthcareEntityID)
+	if !ok {
+		return
+	}
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+	filter := VitalSignsFilter{From: from, To: to}
+	if appointmentID := c.Query("appointment_id"); appointmentID != "" {
+		id, err := strconv.Atoi(appointmentID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid appointment ID"})
+			return
+		}
+		filter.AppointmentID = &id
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	filter.Limit, filter.Offset = limit, offset
+
+	vitals, err := h.vitalsService.ListVitals(patient.ID, filter)
+	if err != nil {
+		logging.LogError("Failed to get vital signs", "error", err, "patient_id", patient.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get vital signs"})
+		return
+	}
+	for i := range vitals {
+		presentVitals(&vitals[i], patient, system)
+	}
+	c.JSON(http.StatusOK, gin.H{"patient_id": patient.ID, "unit_system": system, "vitals": vitals, "limit": limit, "offset": offset})
+}
+
+// CreateVitals records a set of vital signs, optionally linked to an appointment of the patient
+func (h *VitalsHandler) CreateVitals(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	var req VitalSignsRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+
+	headers := forwardedIdentityHeaders(c)
+	system := req.UnitSystem
+	if system == "" {
+		var err error
+		if system, err = h.entityClient.UnitSystem(patient.HealthcareEntityID, headers); err != nil {
+			logging.LogError("Failed to get entity unit system", "error", err, "healthcare_entity_id", patient.HealthcareEntityID)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Could not determine the entity's unit system", "details": "Pass unit_system explicitly"})
+			return
+		}
+	}
+
+	vitals, err := vitalsFromRequest(&req, patient, system)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+	vitals.RecordedBy = c.GetInt("user_id")
+
+	if req.AppointmentID != nil {
+		appointmentPatientID, err := h.appointmentClient.GetAppointmentPatientID(*req.AppointmentID, headers)
+		if err != nil {
+			if errors.Is(err, ErrAppointmentNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Appointment not found"})
+				return
+			}
+			logging.LogError("Failed to check appointment", "error", err, "appointment_id", *req.AppointmentID)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to check appointment"})
+			return
+		}
+		if appointmentPatientID != patient.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Appointment belongs to another patient"})
+			return
+		}
+	}
+
+	if err := h.vitalsService.CreateVitals(vitals, patient); err != nil {
+		if errors.Is(err, ErrImplausibleBMI) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": "Weight and height give an implausible BMI; check their units"})
+			return
+		}
+		logging.LogError("Failed to record vital signs", "error", err, "patient_id", patient.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record vital signs"})
+		return
+	}
+
+	presentVitals(vitals, patient, system)
+	logging.LogInfo("Vital signs recorded", "vitals_id", vitals.ID, "patient_id", patient.ID,
+		"flags", len(vitals.Flags), "recorded_by", vitals.RecordedBy)
+	c.JSON(http.StatusCreated, vitals)
+}
+
+// GetVitalSigns returns one set of vital signs, including sets entered in error (?units=)
+func (h *VitalsHandler) GetVitalSigns(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	vitalsID, err := strconv.Atoi(c.Param("vitalsId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vital signs ID"})
+		return
+	}
+	system, ok := h.displayUnits(c, patient.HealthcareEntityID)
+	if !ok {
+		return
+	}
+	vitals, err := h.vitalsService.GetVitals(patient.ID, vitalsID)
+	if err != nil {
+		if errors.Is(err, ErrVitalsNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vital signs not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get vital signs"})
+		return
+	}
+	presentVitals(vitals, patient, system)
+	c.JSON(http.StatusOK, vitals)
+}
+
+// DeleteVitals marks a set of vital signs as entered in error, with a reason
+func (h *VitalsHandler) DeleteVitals(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	vitalsID, err := strconv.Atoi(c.Param("vitalsId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vital signs ID"})
+		return
+	}
+	var req VitalSignsDeleteRequest
+	if !bindAndValidate(c, h.validator, &req) {
+		return
+	}
+	userID := c.GetInt("user_id")
+	if _, err := h.vitalsService.DeleteVitals(patient.ID, vitalsID, userID, strings.TrimSpace(req.Reason)); err != nil {
+		if errors.Is(err, ErrVitalsNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vital signs not found or already marked as entered in error"})
+			return
+		}
+		logging.LogError("Failed to delete vital signs", "error", err, "vitals_id", vitalsID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete vital signs"})
+		return
+	}
+
+	logging.LogInfo("Vital signs marked as entered in error", "vitals_id", vitalsID, "patient_id", patient.ID, "user_id", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Vital signs marked as entered in error"})
+}
+
+// GetVitalTrends returns the series of each measurement in chronological order with summary
+// statistics (?measurements=weight,bmi, ?from=, ?to=, ?units=)
+func (h *VitalsHandler) GetVitalTrends(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	system, ok := h.displayUnits(c, patient.HealthcareEntityID)
+	if !ok {
+		return
+	}
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	measurements := vitalMeasurements
+	if requested := c.Query("measurements"); requested != "" {
+		measurements = []string{}
+		for _, measurement := range strings.Split(requested, ",") {
+			measurement = strings.TrimSpace(measurement)
+			if _, known := vitalUnits[unitSystemMetric][measurement]; !known {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid measurement", "details": measurement + " is not one of " + strings.Join(vitalMeasurements, ", ")})
+				return
+			}
+			measurements = append(measurements, measurement)
+		}
+	}
+
+	// Read the latest points of the period, then put them back in chronological order
+	vitals, err := h.vitalsService.ListVitals(patient.ID, VitalSignsFilter{From: from, To: to, Limit: maxVitalTrendPoints})
+	if err != nil {
+		logging.LogError("Failed to get vital signs", "error", err, "patient_id", patient.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get vital sign trends"})
+		return
+	}
+	for i, j := 0, len(vitals)-1; i < j; i, j = i+1, j-1 {
+		vitals[i], vitals[j] = vitals[j], vitals[i]
+	}
+	for i := range vitals {
+		presentVitals(&vitals[i], patient, system)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"patient_id":  patient.ID,
+		"unit_system": system,
+		"trends":      buildVitalTrends(vitals, measurements, system),
+	})
+}
+
+// GetReferenceRanges returns the reference ranges that apply to the patient's current age and
+// gender (?glucose_context=, ?units=)
+func (h *VitalsHandler) GetReferenceRanges(c *gin.Context) {
+	patient, ok := loadEntityPatient(c, h.patientService)
+	if !ok {
+		return
+	}
+	system, ok := h.displayUnits(c, patient.HealthcareEntityID)
+	if !ok {
+		return
+	}
+	glucoseContext := c.Query("glucose_context")
+	if glucoseContext != "" && glucoseContext != "fasting" && glucoseContext != "random" && glucoseContext != "post_meal" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid glucose_context", "details": "glucose_context must be fasting, random or post_meal"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"patient_id":  patient.ID,
+		"unit_system": system,
+		"ranges":      patientReferenceRanges(patient, glucoseContext, system),
+	})
+}
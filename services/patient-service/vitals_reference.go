@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Unit systems. Vital signs are stored in metric units; imperial follows US conventions.
+const (
+	unitSystemMetric   = "metric"
+	unitSystemImperial = "imperial"
+)
+
+// vitalMeasurements lists the measurements of a set of vital signs, in display order
+var vitalMeasurements = []string{"systolic", "diastolic", "heart_rate", "temperature", "spo2", "weight", "height", "bmi", "glucose"}
+
+// vitalUnits gives the unit of each measurement per unit system
+var vitalUnits = map[string]map[string]string{
+	unitSystemMetric: {
+		"systolic": "mmHg", "diastolic": "mmHg", "heart_rate": "bpm", "temperature": "°C", "spo2": "%",
+		"weight": "kg", "height": "cm", "bmi": "kg/m²", "glucose": "mmol/L",
+	},
+	unitSystemImperial: {
+		"systolic": "mmHg", "diastolic": "mmHg", "heart_rate": "bpm", "temperature": "°F", "spo2": "%",
+		"weight": "lb", "height": "in", "bmi": "kg/m²", "glucose": "mg/dL",
+	},
+}
+
+const (
+	poundsPerKilogram      = 2.20462262
+	centimetresPerInch     = 2.54
+	glucoseMgPerDlPerMmolL = 18.016
+)
+
+// toMetric converts a value entered in system to the metric unit of the measurement
+func toMetric(measurement string, value float64, system string) float64 {
+	if system != unitSystemImperial {
+		return value
+	}
+	switch measurement {
+	case "temperature":
+		return (value - 32) * 5 / 9
+	case "weight":
+		return value / poundsPerKilogram
+	case "height":
+		return value * centimetresPerInch
+	case "glucose":
+		return value / glucoseMgPerDlPerMmolL
+	}
+	return value
+}
+
+// fromMetric converts a metric value of the measurement to system, rounded for display
+func fromMetric(measurement string, value float64, system string) float64 {
+	places := 1
+	if system == unitSystemImperial {
+		switch measurement {
+		case "temperature":
+			value = value*9/5 + 32
+		case "weight":
+			value = value * poundsPerKilogram
+		case "height":
+			value = value / centimetresPerInch
+		case "glucose":
+			value = value * glucoseMgPerDlPerMmolL
+			places = 0
+		}
+	}
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
+
+// aapScreeningBP holds the 2017 AAP screening thresholds (systolic, diastolic in mmHg) for
+// children aged 1 to 12, indexed by age in years minus one. Values at or above them need
+// further evaluation.
+var aapScreeningBP = map[string][12][2]float64{
+	"male":   {{98, 52}, {100, 55}, {101, 58}, {102, 60}, {103, 63}, {105, 66}, {106, 68}, {107, 69}, {107, 70}, {108, 72}, {110, 74}, {113, 75}},
+	"female": {{98, 54}, {101, 58}, {102, 60}, {103, 62}, {104, 64}, {105, 67}, {106, 68}, {107, 69}, {108, 71}, {109, 72}, {111, 74}, {114, 75}},
+}
+
+// ageInMonths returns the completed months of age at a given time
+func ageInMonths(dateOfBirth, at time.Time) int {
+	months := (at.Year()-dateOfBirth.Year())*12 + int(at.Month()) - int(dateOfBirth.Month())
+	if at.Day() < dateOfBirth.Day() {
+		months--
+	}
+	return max(months, 0)
+}
+
+// ageBand names the paediatric age band of an age, as used for heart rate and blood pressure
+func ageBand(ageMonths int) string {
+	switch {
+	case ageMonths < 1:
+		return "neonate"
+	case ageMonths < 12:
+		return "infant"
+	case ageMonths < 36:
+		return "toddler (1-2 years)"
+	case ageMonths < 72:
+		return "preschool (3-5 years)"
+	case ageMonths < 144:
+		return "school age (6-11 years)"
+	case ageMonths < 216:
+		return "adolescent (12-17 years)"
+	}
+	return "adult"
+}
+
+func bound(value float64) *float64 {
+	return &value
+}
+
+// referenceRange returns the metric reference range of a measurement for a patient of the given
+// age and gender. ok is false when no range applies: weight and height, and the BMI of children,
+// need growth charts.
+func referenceRange(measurement string, ageMonths int, gender, glucoseContext string) (ReferenceRange, bool) {
+	r := ReferenceRange{Measurement: measurement, Unit: vitalUnits[unitSystemMetric][measurement], Basis: ageBand(ageMonths)}
+	years := ageMonths / 12
+
+	switch measurement {
+	case "heart_rate":
+		// Awake heart rate (PALS); critical limits for adults only
+		limits := map[string][2]float64{
+			"neonate": {100, 205}, "infant": {100, 180}, "toddler (1-2 years)": {98, 140},
+			"preschool (3-5 years)": {80, 120}, "school age (6-11 years)": {75, 118},
+		}
+		if l, ok := limits[r.Basis]; ok {
+			r.Low, r.High = bound(l[0]), bound(l[1])
+		} else {
+			r.Low, r.High = bound(60), bound(100)
+		}
+		if years >= 18 {
+			r.CriticalLow, r.CriticalHigh = bound(40), bound(130)
+		}
+
+	case "systolic", "diastolic":
+		index := 0
+		if measurement == "diastolic" {
+			index = 1
+		}
+		switch {
+		case ageMonths < 1:
+			r.Low, r.High = bound([2]float64{67, 35}[index]), bound([2]float64{84, 53}[index])
+		case ageMonths < 12:
+			r.Low, r.High = bound([2]float64{72, 37}[index]), bound([2]float64{104, 56}[index])
+		case years <= 12:
+			// Lower limits by age band (PALS), upper limits from the AAP screening table, which
+			// depends on gender; without a known gender the lower threshold of the two is used
+			lows := map[int][2]float64{1: {86, 42}, 2: {86, 42}, 3: {89, 46}, 4: {89, 46}, 5: {89, 46}}
+			low, ok := lows[years]
+			if !ok {
+				low = [2]float64{97, 57}
+			}
+			g := strings.ToLower(gender)
+			threshold := math.Min(aapScreeningBP["male"][years-1][index], aapScreeningBP["female"][years-1][index])
+			r.Basis = fmt.Sprintf("child %d years", years)
+			if table, ok := aapScreeningBP[g]; ok {
+				threshold = table[years-1][index]
+				r.Basis = fmt.Sprintf("child %d years, %s", years, g)
+			}
+			r.Low, r.High = bound(low[index]), bound(threshold-1)
+		default:
+			// From 13 years: hypertension from 130/80 (AAP 2017, ACC/AHA 2017), crisis from 180/120
+			r.Low = bound([2]float64{90, 60}[index])
+			r.High = bound([2]float64{129, 79}[index])
+			r.CriticalLow = bound([2]float64{70, 40}[index])
+			r.CriticalHigh = bound([2]float64{179, 119}[index])
+			if years < 18 {
+				r.Basis = "adolescent (13-17 years)"
+			}
+		}
+
+	case "temperature":
+		r.Low, r.High = bound(36.0), bound(37.9)
+		r.CriticalLow, r.CriticalHigh = bound(35.0), bound(39.9)
+		switch {
+		case ageMonths < 3:
+			// Any fever in an infant under three months needs urgent assessment
+			r.CriticalHigh = r.High
+			r.Basis = "infant under 3 months"
+		case years >= 65:
+			// Older adults run lower and mount weaker fevers
+			r.High = bound(37.7)
+			r.Basis = "older adult (65+ years)"
+		default:
+			r.Basis = "all ages"
+		}
+
+	case "spo2":
+		r.Low, r.CriticalLow = bound(95), bound(90)
+		r.Basis = "all ages"
+
+	case "bmi":
+		if years < 18 {
+			return r, false
+		}
+		r.Low, r.High = bound(18.5), bound(24.9)
+		r.CriticalLow, r.CriticalHigh = bound(16.0), bound(39.9)
+
+	case "glucose":
+		r.Low, r.CriticalLow, r.CriticalHigh = bound(3.9), bound(3.0), bound(16.6)
+		switch glucoseContext {
+		case "fasting":
+			r.High = bound(5.5)
+		case "post_meal":
+			r.High = bound(7.7)
+		default:
+			r.High = bound(11.0)
+		}
+		if ageMonths < 1 {
+			r.Low, r.CriticalLow = bound(2.6), bound(2.0)
+		} else {
+			r.Basis = "all ages"
+		}
+		if glucoseContext != "" {
+			r.Basis += ", " + strings.ReplaceAll(glucoseContext, "_", " ")
+		} else {
+			r.Basis += ", random"
+		}
+
+	default:
+		return r, false
+	}
+	return r, true
+}
+
+// flag returns the flag level of a metric value against the range, or "" when it is normal
+func (r ReferenceRange) flag(value float64) string {
+	switch {
+	case r.CriticalLow != nil && value < *r.CriticalLow:
+		return "critical_low"
+	case r.CriticalHigh != nil && value > *r.CriticalHigh:
+		return "critical_high"
+	case r.Low != nil && value < *r.Low:
+		return "low"
+	case r.High != nil && value > *r.High:
+		return "high"
+	}
+	return ""
+}
+
+// in converts a metric range to system
+func (r ReferenceRange) in(system string) ReferenceRange {
+	convert := func(value *float64) *float64 {
+		if value == nil {
+			return nil
+		}
+		return bound(fromMetric(r.Measurement, *value, system))
+	}
+	r.Unit = vitalUnits[system][r.Measurement]
+	r.Low, r.High = convert(r.Low), convert(r.High)
+	r.CriticalLow, r.CriticalHigh = convert(r.CriticalLow), convert(r.CriticalHigh)
+	return r
+}
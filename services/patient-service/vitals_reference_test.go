@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func formatBound(value *float64) string {
+	if value == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%g", *value)
+}
+
+func TestReferenceRange(t *testing.T) {
+	none := (*float64)(nil)
+	tests := []struct {
+		name                         string
+		measurement                  string
+		ageMonths                    int
+		gender, glucoseContext       string
+		low, high, critLow, critHigh *float64
+		basis                        string
+	}{
+		{"neonate heart rate", "heart_rate", 0, "", "", bound(100), bound(205), none, none, "neonate"},
+		{"toddler heart rate", "heart_rate", 24, "", "", bound(98), bound(140), none, none, "toddler (1-2 years)"},
+		{"adolescent heart rate", "heart_rate", 15 * 12, "", "", bound(60), bound(100), none, none, "adolescent (12-17 years)"},
+		{"adult heart rate", "heart_rate", 40 * 12, "", "", bound(60), bound(100), bound(40), bound(130), "adult"},
+		{"neonate systolic", "systolic", 0, "", "", bound(67), bound(84), none, none, "neonate"},
+		{"infant diastolic", "diastolic", 6, "", "", bound(37), bound(56), none, none, "infant"},
+		{"boy aged 1 systolic", "systolic", 12, "male", "", bound(86), bound(97), none, none, "child 1 years, male"},
+		{"girl aged 4 diastolic", "diastolic", 4 * 12, "Female", "", bound(46), bound(61), none, none, "child 4 years, female"},
+		{"child aged 12 without gender uses the lower threshold", "systolic", 12 * 12, "", "", bound(97), bound(112), none, none, "child 12 years"},
+		{"adolescent systolic", "systolic", 13 * 12, "male", "", bound(90), bound(129), bound(70), bound(179), "adolescent (13-17 years)"},
+		{"adult diastolic", "diastolic", 30 * 12, "", "", bound(60), bound(79), bound(40), bound(119), "adult"},
+		{"young infant temperature", "temperature", 2, "", "", bound(36.0), bound(37.9), bound(35.0), bound(37.9), "infant under 3 months"},
+		{"adult temperature", "temperature", 30 * 12, "", "", bound(36.0), bound(37.9), bound(35.0), bound(39.9), "all ages"},
+		{"older adult temperature", "temperature", 65 * 12, "", "", bound(36.0), bound(37.7), bound(35.0), bound(39.9), "older adult (65+ years)"},
+		{"spo2", "spo2", 30 * 12, "", "", bound(95), none, bound(90), none, "all ages"},
+		{"adult bmi", "bmi", 18 * 12, "", "", bound(18.5), bound(24.9), bound(16.0), bound(39.9), "adult"},
+		{"fasting glucose", "glucose", 30 * 12, "", "fasting", bound(3.9), bound(5.5), bound(3.0), bound(16.6), "all ages, fasting"},
+		{"post-meal glucose", "glucose", 30 * 12, "", "post_meal", bound(3.9), bound(7.7), bound(3.0), bound(16.6), "all ages, post meal"},
+		{"random glucose", "glucose", 30 * 12, "", "", bound(3.9), bound(11.0), bound(3.0), bound(16.6), "all ages, random"},
+		{"neonate glucose", "glucose", 0, "", "", bound(2.6), bound(11.0), bound(2.0), bound(16.6), "neonate, random"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := referenceRange(tt.measurement, tt.ageMonths, tt.gender, tt.glucoseContext)
+			if !ok {
+				t.Fatalf("referenceRange(%q, %d) has no range", tt.measurement, tt.ageMonths)
+			}
+			got := [4]string{formatBound(r.Low), formatBound(r.High), formatBound(r.CriticalLow), formatBound(r.CriticalHigh)}
+			want := [4]string{formatBound(tt.low), formatBound(tt.high), formatBound(tt.critLow), formatBound(tt.critHigh)}
+			if got != want {
+				t.Errorf("bounds (low, high, critical low, critical high) = %v, want %v", got, want)
+			}
+			if r.Basis != tt.basis {
+				t.Errorf("basis = %q, want %q", r.Basis, tt.basis)
+			}
+		})
+	}
+}
+
+func TestReferenceRangeNotApplicable(t *testing.T) {
+	tests := []struct {
+		measurement string
+		ageMonths   int
+	}{
+		{"weight", 30 * 12},
+		{"height", 30 * 12},
+		{"bmi", 17 * 12},
+		{"unknown", 30 * 12},
+	}
+	for _, tt := range tests {
+		if _, ok := referenceRange(tt.measurement, tt.ageMonths, "", ""); ok {
+			t.Errorf("referenceRange(%q, %d) returned a range, want none", tt.measurement, tt.ageMonths)
+		}
+	}
+}
+
+func TestReferenceRangeFlag(t *testing.T) {
+	adult, _ := referenceRange("heart_rate", 40*12, "", "")
+	spo2, _ := referenceRange("spo2", 40*12, "", "")
+	tests := []struct {
+		name  string
+		r     ReferenceRange
+		value float64
+		want  string
+	}{
+		{"normal", adult, 72, ""},
+		{"at the low bound", adult, 60, ""},
+		{"at the high bound", adult, 100, ""},
+		{"low", adult, 55, "low"},
+		{"high", adult, 101, "high"},
+		{"critical low", adult, 39, "critical_low"},
+		{"critical high", adult, 131, "critical_high"},
+		{"no high bound", spo2, 100, ""},
+		{"critical low without high bounds", spo2, 85, "critical_low"},
+	}
+	for _, tt := range tests {
+		if got := tt.r.flag(tt.value); got != tt.want {
+			t.Errorf("%s: flag(%g) = %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
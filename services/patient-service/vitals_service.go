@@ -0,0 +1,368 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrVitalsNotFound is returned when a set of vital signs does not exist for the patient
+	ErrVitalsNotFound = errors.New("vital signs not found")
+	// ErrImplausibleBMI is returned when the weight and height give a BMI no patient can have,
+	// which usually means one of them was entered in the wrong unit
+	ErrImplausibleBMI = errors.New("weight and height give an implausible BMI")
+)
+
+// plausibleBMI bounds the computed BMI
+var plausibleBMI = [2]float64{5, 150}
+
+// vitalsClockSkew is how far in the future a measurement time may be, to allow for device clocks
+const vitalsClockSkew = 5 * time.Minute
+
+// vitalPlausibleRanges bounds the metric values accepted for the measurements whose request
+// value depends on the unit system (the others are bounded by request validation)
+var vitalPlausibleRanges = map[string][2]float64{
+	"temperature": {25, 45},
+	"weight":      {0.2, 500},
+	"height":      {20, 260},
+	"glucose":     {0.5, 60},
+}
+
+// VitalSignsFilter narrows a patient's vital signs history
+type VitalSignsFilter struct {
+	From           *time.Time
+	To             *time.Time
+	AppointmentID  *int
+	IncludeDeleted bool
+	Ascending      bool
+	Limit          int // 0 for no limit
+	Offset         int
+}
+
+// VitalsService manages vital signs and clinical measurements
+type VitalsService struct {
+	db *sql.DB
+}
+
+// NewVitalsService creates a new vitals service
+func NewVitalsService(db *sql.DB) *VitalsService {
+	return &VitalsService{db: db}
+}
+
+const vitalsColumns = `id, patient_id, healthcare_entity_id, appointment_id, measured_at, systolic_mmhg, diastolic_mmhg,
+	heart_rate_bpm, temperature_c, spo2_percent, weight_kg, height_cm, bmi, glucose_mmol_l, glucose_context,
+	entered_unit_system, notes, recorded_by, created_at, deleted_at, deleted_by, deletion_reason`
+
+func scanVitals(row rowScanner) (*VitalSigns, error) {
+	v := &VitalSigns{UnitSystem: unitSystemMetric}
+	err := row.Scan(&v.ID, &v.PatientID, &v.HealthcareEntityID, &v.AppointmentID, &v.MeasuredAt, &v.Systolic, &v.Diastolic,
+		&v.HeartRate, &v.Temperature, &v.SpO2, &v.Weight, &v.Height, &v.BMI, &v.Glucose, &v.GlucoseContext,
+		&v.EnteredUnitSystem, &v.Notes, &v.RecordedBy, &v.CreatedAt, &v.DeletedAt, &v.DeletedBy, &v.DeletionReason)
+	return v, err
+}
+
+// vitalsFromRequest converts a request entered in system into metric vital signs of the patient
+// and checks that the values are consistent. Errors describe the invalid value.
+func vitalsFromRequest(req *VitalSignsRequest, patient *Patient, system string) (*VitalSigns, error) {
+	v := &VitalSigns{
+		PatientID:          patient.ID,
+		HealthcareEntityID: patient.HealthcareEntityID,
+		AppointmentID:      req.AppointmentID,
+		MeasuredAt:         time.Now().UTC(),
+		UnitSystem:         unitSystemMetric,
+		Systolic:           req.Systolic,
+		Diastolic:          req.Diastolic,
+		HeartRate:          req.HeartRate,
+		SpO2:               req.SpO2,
+		GlucoseContext:     req.GlucoseContext,
+		EnteredUnitSystem:  system,
+		Notes:              strings.TrimSpace(req.Notes),
+	}
+	if req.MeasuredAt != "" {
+		measuredAt, err := time.Parse(time.RFC3339, req.MeasuredAt)
+		if err != nil {
+			return nil, errors.New("measured_at must be an RFC3339 timestamp")
+		}
+		v.MeasuredAt = measuredAt.UTC()
+	}
+	if v.MeasuredAt.After(time.Now().Add(vitalsClockSkew)) {
+		return nil, errors.New("measured_at cannot be in the future")
+	}
+	if v.MeasuredAt.Before(patient.DateOfBirth) {
+		return nil, errors.New("measured_at cannot be before the patient's date of birth")
+	}
+
+	if (v.Systolic == nil) != (v.Diastolic == nil) {
+		return nil, errors.New("systolic and diastolic must be recorded together")
+	}
+	if v.Systolic != nil && *v.Diastolic >= *v.Systolic {
+		return nil, errors.New("diastolic must be lower than systolic")
+	}
+	if v.GlucoseContext != "" && req.Glucose == nil {
+		return nil, errors.New("glucose_context requires glucose")
+	}
+
+	convert := func(measurement string, value *float64) (*float64, error) {
+		if value == nil {
+			return nil, nil
+		}
+		metric := toMetric(measurement, *value, system)
+		limits := vitalPlausibleRanges[measurement]
+		if metric < limits[0] || metric > limits[1] {
+			unit := vitalUnits[system][measurement]
+			return nil, fmt.Errorf("%s must be between %g and %g %s", measurement,
+				fromMetric(measurement, limits[0], system), fromMetric(measurement, limits[1], system), unit)
+		}
+		return &metric, nil
+	}
+	var err error
+	if v.Temperature, err = convert("temperature", req.Temperature); err != nil {
+		return nil, err
+	}
+	if v.Weight, err = convert("weight", req.Weight); err != nil {
+		return nil, err
+	}
+	if v.Height, err = convert("height", req.Height); err != nil {
+		return nil, err
+	}
+	if v.Glucose, err = convert("glucose", req.Glucose); err != nil {
+		return nil, err
+	}
+
+	for _, measurement := range vitalMeasurements {
+		if v.value(measurement) != nil {
+			return v, nil
+		}
+	}
+	return nil, errors.New("at least one measurement is required")
+}
+
+// value returns a measurement of the set, or nil when it was not taken
+func (v *VitalSigns) value(measurement string) *float64 {
+	fromInt := func(value *int) *float64 {
+		if value == nil {
+			return nil
+		}
+		return bound(float64(*value))
+	}
+	switch measurement {
+	case "systolic":
+		return fromInt(v.Systolic)
+	case "diastolic":
+		return fromInt(v.Diastolic)
+	case "heart_rate":
+		return fromInt(v.HeartRate)
+	case "temperature":
+		return v.Temperature
+	case "spo2":
+		return fromInt(v.SpO2)
+	case "weight":
+		return v.Weight
+	case "height":
+		return v.Height
+	case "bmi":
+		return v.BMI
+	case "glucose":
+		return v.Glucose
+	}
+	return nil
+}
+
+// presentVitals flags the metric values of v against the patient's reference ranges at the
+// time of measurement, then converts them to system for display
+func presentVitals(v *VitalSigns, patient *Patient, system string) {
+	ageMonths := ageInMonths(patient.DateOfBirth, v.MeasuredAt)
+	v.Flags = []VitalFlag{}
+	for _, measurement := range vitalMeasurements {
+		value := v.value(measurement)
+		if value == nil {
+			continue
+		}
+		r, ok := referenceRange(measurement, ageMonths, patient.Gender, v.GlucoseContext)
+		if !ok {
+			continue
+		}
+		if level := r.flag(*value); level != "" {
+			display := r.in(system)
+			v.Flags = append(v.Flags, VitalFlag{Measurement: measurement, Level: level, ReferenceLow: display.Low, ReferenceHigh: display.High})
+		}
+	}
+
+	convert := func(measurement string, value *float64) *float64 {
+		if value == nil {
+			return nil
+		}
+		return bound(fromMetric(measurement, *value, system))
+	}
+	v.Temperature = convert("temperature", v.Temperature)
+	v.Weight = convert("weight", v.Weight)
+	v.Height = convert("height", v.Height)
+	v.BMI = convert("bmi", v.BMI)
+	v.Glucose = convert("glucose", v.Glucose)
+	v.UnitSystem = system
+	v.Units = vitalUnits[system]
+}
+
+// patientReferenceRanges returns the reference ranges that apply to the patient today, in system
+func patientReferenceRanges(patient *Patient, glucoseContext, system string) []ReferenceRange {
+	ageMonths := ageInMonths(patient.DateOfBirth, time.Now())
+	ranges := []ReferenceRange{}
+	for _, measurement := range vitalMeasurements {
+		if r, ok := referenceRange(measurement, ageMonths, patient.Gender, glucoseContext); ok {
+			ranges = append(ranges, r.in(system))
+		}
+	}
+	return ranges
+}
+
+// CreateVitals records a set of metric vital signs. BMI is computed from the weight and the
+// height of the same set or, for adults, the latest height on record.
+func (s *VitalsService) CreateVitals(v *VitalSigns, patient *Patient) error {
+	height := v.Height
+	if height == nil && v.Weight != nil && ageInMonths(patient.DateOfBirth, v.MeasuredAt) >= 18*12 {
+		err := s.db.QueryRow(`
+			SELECT height_cm FROM patient_vitals
+			WHERE patient_id = $1 AND deleted_at IS NULL AND height_cm IS NOT NULL AND measured_at <= $2
+			ORDER BY measured_at DESC LIMIT 1
+		`, v.PatientID, v.MeasuredAt).Scan(&height)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	v.BMI = nil
+	if v.Weight != nil && height != nil {
+		metres := *height / 100
+		bmi := math.Round(*v.Weight/(metres*metres)*10) / 10
+		if bmi < plausibleBMI[0] || bmi > plausibleBMI[1] {
+			return ErrImplausibleBMI
+		}
+		v.BMI = &bmi
+	}
+
+	return s.db.QueryRow(`
+		INSERT INTO patient_vitals (patient_id, healthcare_entity_id, appointment_id, measured_at, systolic_mmhg, diastolic_mmhg,
+			heart_rate_bpm, temperature_c, spo2_percent, weight_kg, height_cm, bmi, glucose_mmol_l, glucose_context,
+			entered_unit_system, notes, recorded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id, created_at
+	`, v.PatientID, v.HealthcareEntityID, v.AppointmentID, v.MeasuredAt, v.Systolic, v.Diastolic,
+		v.HeartRate, v.Temperature, v.SpO2, v.Weight, v.Height, v.BMI, v.Glucose, v.GlucoseContext,
+		v.EnteredUnitSystem, v.Notes, v.RecordedBy,
+	).Scan(&v.ID, &v.CreatedAt)
+}
+
+// ListVitals lists a patient's metric vital signs, newest first unless the filter asks otherwise
+func (s *VitalsService) ListVitals(patientID int, filter VitalSignsFilter) ([]VitalSigns, error) {
+	conditions := []string{"patient_id = $1"}
+	args := []interface{}{patientID}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if filter.From != nil {
+		addCondition("measured_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("measured_at <= $%d", *filter.To)
+	}
+	if filter.AppointmentID != nil {
+		addCondition("appointment_id = $%d", *filter.AppointmentID)
+	}
+
+	order := "DESC"
+	if filter.Ascending {
+		order = "ASC"
+	}
+	query := `SELECT ` + vitalsColumns + ` FROM patient_vitals WHERE ` + strings.Join(conditions, " AND ") +
+		` ORDER BY measured_at ` + order + `, id ` + order
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit, filter.Offset)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vitals := []VitalSigns{}
+	for rows.Next() {
+		v, err := scanVitals(rows)
+		if err != nil {
+			return nil, err
+		}
+		vitals = append(vitals, *v)
+	}
+	return vitals, rows.Err()
+}
+
+// GetVitals gets a set of vital signs of a patient, including sets entered in error
+func (s *VitalsService) GetVitals(patientID, id int) (*VitalSigns, error) {
+	v, err := scanVitals(s.db.QueryRow(`SELECT `+vitalsColumns+` FROM patient_vitals WHERE id = $1 AND patient_id = $2`, id, patientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrVitalsNotFound
+	}
+	return v, err
+}
+
+// DeleteVitals marks a set of vital signs as entered in error. The values are kept for the record
+// but no longer appear in the history or trends.
+func (s *VitalsService) DeleteVitals(patientID, id, userID int, reason string) (*VitalSigns, error) {
+	v, err := scanVitals(s.db.QueryRow(`
+		UPDATE patient_vitals SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $3, deletion_reason = $4
+		WHERE id = $1 AND patient_id = $2 AND deleted_at IS NULL
+		RETURNING `+vitalsColumns, id, patientID, userID, reason))
+	if err == sql.ErrNoRows {
+		return nil, ErrVitalsNotFound
+	}
+	return v, err
+}
+
+// buildVitalTrends builds the series of each measurement from presented vital signs in
+// chronological order
+func buildVitalTrends(vitals []VitalSigns, measurements []string, system string) []VitalTrend {
+	trends := make([]VitalTrend, 0, len(measurements))
+	for _, measurement := range measurements {
+		trend := VitalTrend{Measurement: measurement, Unit: vitalUnits[system][measurement], Points: []VitalTrendPoint{}}
+		sum := 0.0
+		for i := range vitals {
+			value := vitals[i].value(measurement)
+			if value == nil {
+				continue
+			}
+			point := VitalTrendPoint{VitalsID: vitals[i].ID, MeasuredAt: vitals[i].MeasuredAt, Value: *value}
+			for _, flag := range vitals[i].Flags {
+				if flag.Measurement == measurement {
+					point.Flag = flag.Level
+				}
+			}
+			trend.Points = append(trend.Points, point)
+
+			sum += *value
+			if trend.Min == nil || *value < *trend.Min {
+				trend.Min = bound(*value)
+			}
+			if trend.Max == nil || *value > *trend.Max {
+				trend.Max = bound(*value)
+			}
+		}
+
+		trend.Count = len(trend.Points)
+		if trend.Count > 0 {
+			first, latest := trend.Points[0].Value, trend.Points[trend.Count-1].Value
+			trend.Latest = bound(latest)
+			trend.Mean = bound(math.Round(sum/float64(trend.Count)*10) / 10)
+			trend.Change = bound(math.Round((latest-first)*10) / 10)
+		}
+		trends = append(trends, trend)
+	}
+	return trends
+}
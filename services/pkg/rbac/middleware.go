@@ -0,0 +1,84 @@
+package rbac
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Header carries the caller's permissions, comma separated, from the API Gateway to the
+	// services
+	Header = "X-User-Permissions"
+	// ContextKey is where services that validate tokens themselves store the permissions
+	ContextKey = "permissions"
+)
+
+// Permissions returns the caller's permissions: those stored in the context under ContextKey,
+// else those of the X-User-Permissions header. Callers without either have none; the role
+// header isn't trusted since only the permissions header is set by the gateway alone.
+func Permissions(c *gin.Context) []string {
+	if value, exists := c.Get(ContextKey); exists {
+		if permissions, ok := value.([]string); ok {
+			return permissions
+		}
+	}
+	if values, present := c.Request.Header[http.CanonicalHeaderKey(Header)]; present {
+		return Normalize(strings.Split(strings.Join(values, ","), ","))
+	}
+	return nil
+}
+
+// Has reports whether the caller has the permission
+func Has(c *gin.Context, permission string) bool {
+	for _, granted := range Permissions(c) {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAny reports whether the caller has at least one of the permissions
+func HasAny(c *gin.Context, permissions ...string) bool {
+	for _, permission := range permissions {
+		if Has(c, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Deny writes the 403 response for a caller missing a permission
+func Deny(c *gin.Context, permission string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":      "Access denied",
+		"code":       "PERMISSION_DENIED",
+		"permission": permission,
+	})
+}
+
+// Require restricts a route to callers with all of the permissions
+func Require(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if !Has(c, permission) {
+				Deny(c, permission)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireAny restricts a route to callers with at least one of the permissions
+func RequireAny(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasAny(c, permissions...) {
+			Deny(c, strings.Join(permissions, "|"))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,71 @@
+package rbac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		context []string
+		headers map[string]string
+		want    []string
+	}{
+		{"context first", []string{PatientRead}, map[string]string{Header: UserManage}, []string{PatientRead}},
+		{"permissions header", nil, map[string]string{Header: "patient.read, user.manage"}, []string{PatientRead, UserManage}},
+		{"empty permissions header", nil, map[string]string{Header: ""}, []string{}},
+		{"role header alone grants nothing", nil, map[string]string{"X-User-Role": "admin"}, nil},
+		{"no headers", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range tt.headers {
+				c.Request.Header.Set(key, value)
+			}
+			if tt.context != nil {
+				c.Set(ContextKey, tt.context)
+			}
+			got := Permissions(c)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Permissions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", Require(UserManage), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"holds the permission", map[string]string{Header: "patient.read,user.manage"}, http.StatusNoContent},
+		{"lacks the permission", map[string]string{Header: "patient.read"}, http.StatusForbidden},
+		{"admin role header without permissions", map[string]string{"X-User-Role": "admin"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package rbac
+
+import (
+	"sort"
+	"strings"
+)
+
+// Permissions checked by the services. Names are "<resource>.<action>", with a third part
+// for finer actions on the resource.
+const (
+	PatientRead          = "patient.read"           // Demographics, consents, relationships, coverages
+	PatientWrite         = "patient.write"          // Create and update patients and their administrative data
+	PatientDelete        = "patient.delete"         // Delete patients
+	PatientReadClinical  = "patient.read.clinical"  // Allergies, medications, problems, vitals, documents, timeline
+	PatientWriteClinical = "patient.write.clinical" // Record and change clinical data
+	PatientRecordsManage = "patient.records.manage" // MRN format, version restore, data requests, legal holds, retention
+
+	AppointmentRead   = "appointment.read"   // Appointments, slots, rooms, doctors and schedules
+	AppointmentWrite  = "appointment.write"  // Book, reschedule and change the status of appointments
+	AppointmentCancel = "appointment.cancel" // Cancel and delete appointments
+
+	AvailabilityManage = "availability.manage" // Doctor availability
+	ScheduleManage     = "schedule.manage"     // Rooms, durations and appointment settings of the entity
+
+	EncounterNoteRead  = "encounter_note.read"  // Read and search encounter notes
+	EncounterNoteWrite = "encounter_note.write" // Write notes and addenda
+	EncounterNoteSign  = "encounter_note.sign"  // Sign notes of one's own appointments
+
+	DepartmentManage = "department.manage" // Departments, their heads and members
+	ReportRead       = "report.read"       // Department reports
+
+	UserRead             = "user.read"              // Users of the entity, their sessions and lockouts
+	UserInvite           = "user.invite"            // Invite staff
+	UserManage           = "user.manage"            // Log users out, unlock them and assign their roles
+	RoleManage           = "role.manage"            // Define custom roles
+	SecurityAudit        = "security.audit"         // Security event log
+	ServiceAccountManage = "service_account.manage" // Service accounts and their API keys
+	EntityManage         = "entity.manage"          // Entity policies, forms and translations
+	ConfigManage         = "config.manage"          // Platform settings and feature flags
+
+	PlatformManage = "platform.manage" // Create, edit, deactivate and reactivate every entity
+)
+
+// Permission describes a permission of the catalog
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Catalog lists every permission, as shown to admins defining roles
+var Catalog = []Permission{
+	{PatientRead, "View patients, their consents, relationships and coverages"},
+	{PatientWrite, "Create and update patients and their administrative data"},
+	{PatientDelete, "Delete patients"},
+	{PatientReadClinical, "View allergies, medications, problems, vitals, documents and timelines"},
+	{PatientWriteClinical, "Record and change clinical data and documents"},
+	{PatientRecordsManage, "Manage MRN format, version restores, data requests, legal holds and retention"},
+	{AppointmentRead, "View appointments, slots, rooms, doctors and schedules"},
+	{AppointmentWrite, "Book and reschedule appointments and change their status"},
+	{AppointmentCancel, "Cancel and delete appointments"},
+	{AvailabilityManage, "Manage doctor availability"},
+	{ScheduleManage, "Manage rooms, appointment durations and settings"},
+	{EncounterNoteRead, "Read and search encounter notes"},
+	{EncounterNoteWrite, "Write encounter notes and addenda"},
+	{EncounterNoteSign, "Sign encounter notes of one's own appointments"},
+	{DepartmentManage, "Manage departments, their heads and members"},
+	{ReportRead, "View department reports"},
+	{UserRead, "View users, their sessions and lockouts"},
+	{UserInvite, "Invite staff"},
+	{UserManage, "Log users out, unlock them and assign their roles"},
+	{RoleManage, "Define custom roles"},
+	{SecurityAudit, "Review the security event log"},
+	{ServiceAccountManage, "Manage service accounts and their API keys"},
+	{EntityManage, "Manage entity policies, forms and translations"},
+	{ConfigManage, "Manage platform settings and feature flags"},
+	{PlatformManage, "Create, edit, deactivate and reactivate every healthcare entity"},
+}
+
+// ClinicalNotePermissions are limited to clinical staff: entity admins don't hold them, but
+// assign doctors and nurses, the built-in roles that carry them
+var ClinicalNotePermissions = []string{EncounterNoteRead, EncounterNoteWrite, EncounterNoteSign}
+
+// BuiltinRoles maps the built-in roles to their permissions. Users with a custom role get the
+// custom role's permissions instead. super_admin is the platform operator's role; entity
+// admins have every other permission except the clinical note ones.
+var BuiltinRoles = map[string][]string{
+	"super_admin": All(),
+	"admin":       without(All(), PlatformManage, EncounterNoteRead, EncounterNoteWrite, EncounterNoteSign),
+	"doctor": {
+		PatientRead, PatientWrite, PatientDelete, PatientReadClinical, PatientWriteClinical,
+		AppointmentRead, AppointmentWrite, AppointmentCancel, AvailabilityManage,
+		EncounterNoteRead, EncounterNoteWrite, EncounterNoteSign,
+	},
+	"nurse": {
+		PatientRead, PatientWrite, PatientDelete, PatientReadClinical, PatientWriteClinical,
+		AppointmentRead, AppointmentWrite, AppointmentCancel, AvailabilityManage,
+		EncounterNoteRead, EncounterNoteWrite,
+	},
+	"staff": {
+		PatientRead, PatientWrite, PatientDelete,
+		AppointmentRead, AppointmentWrite, AppointmentCancel, AvailabilityManage,
+	},
+}
+
+// All returns the name of every permission of the catalog
+func All() []string {
+	names := make([]string, len(Catalog))
+	for i, permission := range Catalog {
+		names[i] = permission.Name
+	}
+	return names
+}
+
+// without returns names minus the excluded ones
+func without(names []string, excluded ...string) []string {
+	kept := []string{}
+	for _, name := range names {
+		skip := false
+		for _, e := range excluded {
+			if name == e {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// IsClinicalNote reports whether name is one of the ClinicalNotePermissions
+func IsClinicalNote(name string) bool {
+	for _, permission := range ClinicalNotePermissions {
+		if permission == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsKnown reports whether name is a permission of the catalog
+func IsKnown(name string) bool {
+	for _, permission := range Catalog {
+		if permission.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePermissions returns the permissions of a built-in role, none for other roles
+func RolePermissions(role string) []string {
+	return BuiltinRoles[role]
+}
+
+// Normalize trims, deduplicates and sorts permission names
+func Normalize(names []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
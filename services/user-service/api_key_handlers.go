@@ -0,0 +1,410 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+	rbac "github.com/louhibi/healthcare-rbac"
+)
+
+// respondAPIKeyError maps API key and service account errors to HTTP responses
+func respondAPIKeyError(c *gin.Context, err error, operation string) {
+	switch {
+	case errors.Is(err, ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	case errors.Is(err, ErrServiceAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+	case errors.Is(err, ErrServiceAccountExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "A service account with this name already exists"})
+	case errors.Is(err, ErrServiceAccountInactive), errors.Is(err, ErrUnknownPermissions):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logging.LogError("API key operation failed", "operation", operation, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// apiKeyID reads the :keyId route parameter of API key routes
+func apiKeyID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("keyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// serviceAccountID reads the :id route parameter of service account routes
+func serviceAccountID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// RequireLoginToken rejects access tokens exchanged for an API key, so that a key can't
+// create keys, manage sessions or change the credentials of its owner
+func RequireLoginToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userClaims, ok := callerClaims(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+		if userClaims.APIKeyID != 0 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "This endpoint can't be used with an API key",
+				"code":  "API_KEY_NOT_ALLOWED",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestAPIKey reads the key from the X-API-Key header or an "Authorization: ApiKey" header
+func requestAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "ApiKey ") {
+		return strings.TrimSpace(strings.TrimPrefix(authHeader, "ApiKey "))
+	}
+	return ""
+}
+
+// apiKeyPermissions returns the permissions a key grants: its scopes for service accounts, and
+// for people the scopes they still hold in the key's entity
+func (h *UserHandler) apiKeyPermissions(user *User, key *APIKey) ([]string, error) {
+	if user.Role == ServiceAccountRole {
+		permissions := []string{}
+		for _, permission := range key.Permissions {
+			if rbac.IsKnown(permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+		return permissions, nil
+	}
+
+	current, err := h.roleService.UserPermissions(user)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool, len(current.Permissions))
+	for _, permission := range current.Permissions {
+		held[permission] = true
+	}
+	permissions := []string{}
+	for _, permission := range key.Permissions {
+		if held[permission] {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
+}
+
+// ExchangeAPIKey handles POST /api/auth/api-key/token - exchanges the API key of the X-API-Key
+// header for a 5 minute access token. The api-gateway calls it for requests carrying a key.
+func (h *UserHandler) ExchangeAPIKey(c *gin.Context) {
+	invalid := func() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key", "code": "API_KEY_INVALID"})
+	}
+
+	rawKey := requestAPIKey(c)
+	if rawKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-API-Key header required"})
+		return
+	}
+	key, err := h.apiKeyService.Authenticate(rawKey, c.ClientIP())
+	if errors.Is(err, ErrAPIKeyInvalid) {
+		invalid()
+		return
+	}
+	if err != nil {
+		logging.LogError("Failed to authenticate API key", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate API key"})
+		return
+	}
+
+	// The owner must still be active and a member of the key's entity
+	user, err := h.userService.GetUserByID(key.UserID)
+	if err != nil {
+		invalid()
+		return
+	}
+	scoped, err := h.membershipService.ScopeUser(user, key.HealthcareEntityID)
+	if errors.Is(err, ErrMembershipNotFound) || errors.Is(err, ErrEntityDeactivated) {
+		invalid()
+		return
+	}
+	if err != nil {
+		logging.LogError("Failed to scope API key owner", "error", err, "api_key_id", key.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate API key"})
+		return
+	}
+
+	// Personal keys are subject to the MFA policy of their owner's role: they are refused while
+	// it requires MFA and the owner hasn't enrolled. Only service accounts are exempt.
+	mfa := false
+	if scoped.Role != ServiceAccountRole {
+		required, err := h.mfaService.IsRequired(scoped)
+		if err == nil {
+			mfa, err = h.mfaService.IsEnabled(scoped.ID)
+		}
+		if err != nil {
+			logging.LogError("Failed to check the MFA of an API key owner", "error", err, "api_key_id", key.ID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate API key"})
+			return
+		}
+		if required && !mfa {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "The key owner's role requires multi-factor authentication, which they haven't enrolled in",
+				"code":  "MFA_REQUIRED",
+			})
+			return
+		}
+	}
+
+	permissions, err := h.apiKeyPermissions(scoped, key)
+	if err != nil {
+		logging.LogError("Failed to get API key permissions", "error", err, "api_key_id", key.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate API key"})
+		return
+	}
+	token, err := h.authService.GenerateAPIKeyToken(scoped, key.ID, permissions, mfa)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIKeyTokenResponse{
+		AccessToken:        token,
+		TokenType:          "Bearer",
+		ExpiresIn:          int(apiKeyTokenTTL.Seconds()),
+		APIKeyID:           key.ID,
+		UserID:             scoped.ID,
+		HealthcareEntityID: scoped.HealthcareEntityID,
+		Permissions:        permissions,
+	})
+}
+
+// createAPIKey creates a key of userID in the caller's entity, scoped to permissions the
+// caller holds
+func (h *UserHandler) createAPIKey(c *gin.Context, userClaims *Claims, userID int) {
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !grantable(c, req.Permissions) {
+		return
+	}
+
+	key, rawKey, err := h.apiKeyService.CreateKey(userClaims.HealthcareEntityID, userID, &req, userClaims.UserID)
+	if err != nil {
+		respondAPIKeyError(c, err, "create API key")
+		return
+	}
+
+	logging.LogInfo("API key created", "api_key_id", key.ID, "user_id", userID,
+		"healthcare_entity_id", key.HealthcareEntityID, "created_by", userClaims.UserID)
+	c.JSON(http.StatusCreated, CreatedAPIKeyResponse{
+		APIKey:  key,
+		Key:     rawKey,
+		Message: "Store this key now, it can't be shown again",
+	})
+}
+
+// revokeAPIKey revokes a key of userID in the caller's entity
+func (h *UserHandler) revokeAPIKey(c *gin.Context, userClaims *Claims, userID int) {
+	id, ok := apiKeyID(c)
+	if !ok {
+		return
+	}
+	key, err := h.apiKeyService.RevokeKey(userClaims.HealthcareEntityID, userID, id, userClaims.UserID)
+	if err != nil {
+		respondAPIKeyError(c, err, "revoke API key")
+		return
+	}
+
+	logging.LogInfo("API key revoked", "api_key_id", key.ID, "user_id", userID, "revoked_by", userClaims.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked", "api_key": key})
+}
+
+// GetAPIKeys handles GET /api/auth/api-keys - the caller's personal keys for the current entity
+func (h *UserHandler) GetAPIKeys(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	keys, err := h.apiKeyService.ListKeys(userClaims.HealthcareEntityID, userClaims.UserID)
+	if err != nil {
+		respondAPIKeyError(c, err, "get API keys")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// CreateAPIKey handles POST /api/auth/api-keys - a personal key for the current entity. Its
+// requests get the scopes the caller still holds when the key is used.
+func (h *UserHandler) CreateAPIKey(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	h.createAPIKey(c, userClaims, userClaims.UserID)
+}
+
+// RevokeAPIKey handles DELETE /api/auth/api-keys/:keyId
+func (h *UserHandler) RevokeAPIKey(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	h.revokeAPIKey(c, userClaims, userClaims.UserID)
+}
+
+// loadServiceAccount reads the :id route parameter and loads the service account of the
+// caller's entity
+func (h *UserHandler) loadServiceAccount(c *gin.Context) (*Claims, *ServiceAccount, bool) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return nil, nil, false
+	}
+	id, ok := serviceAccountID(c)
+	if !ok {
+		return nil, nil, false
+	}
+	account, err := h.serviceAccounts.GetServiceAccount(userClaims.HealthcareEntityID, id)
+	if err != nil {
+		respondAPIKeyError(c, err, "get service account")
+		return nil, nil, false
+	}
+	return userClaims, account, true
+}
+
+// GetServiceAccounts handles GET /api/service-accounts
+func (h *UserHandler) GetServiceAccounts(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	accounts, err := h.serviceAccounts.ListServiceAccounts(userClaims.HealthcareEntityID)
+	if err != nil {
+		respondAPIKeyError(c, err, "get service accounts")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"service_accounts": accounts})
+}
+
+// CreateServiceAccount handles POST /api/service-accounts
+func (h *UserHandler) CreateServiceAccount(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	var req ServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := h.serviceAccounts.CreateServiceAccount(userClaims.HealthcareEntityID, &req, userClaims.UserID)
+	if err != nil {
+		respondAPIKeyError(c, err, "create service account")
+		return
+	}
+
+	logging.LogInfo("Service account created", "service_account_id", account.ID,
+		"healthcare_entity_id", account.HealthcareEntityID, "created_by", userClaims.UserID)
+	c.JSON(http.StatusCreated, account)
+}
+
+// GetServiceAccount handles GET /api/service-accounts/:id
+func (h *UserHandler) GetServiceAccount(c *gin.Context) {
+	_, account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, account)
+}
+
+// DeactivateServiceAccount handles DELETE /api/service-accounts/:id - deactivates the account
+// and revokes its keys
+func (h *UserHandler) DeactivateServiceAccount(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := serviceAccountID(c)
+	if !ok {
+		return
+	}
+
+	account, revoked, err := h.serviceAccounts.DeactivateServiceAccount(userClaims.HealthcareEntityID, id, userClaims.UserID)
+	if err != nil {
+		respondAPIKeyError(c, err, "deactivate service account")
+		return
+	}
+
+	logging.LogInfo("Service account deactivated", "service_account_id", id, "revoked_keys", revoked,
+		"deactivated_by", userClaims.UserID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Service account deactivated",
+		"service_account":  account,
+		"revoked_api_keys": revoked,
+	})
+}
+
+// GetServiceAccountKeys handles GET /api/service-accounts/:id/api-keys
+func (h *UserHandler) GetServiceAccountKeys(c *gin.Context) {
+	userClaims, account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+	keys, err := h.apiKeyService.ListKeys(userClaims.HealthcareEntityID, account.ID)
+	if err != nil {
+		respondAPIKeyError(c, err, "get API keys")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"service_account_id": account.ID, "api_keys": keys})
+}
+
+// CreateServiceAccountKey handles POST /api/service-accounts/:id/api-keys - a key granting its
+// scopes to the service account
+func (h *UserHandler) CreateServiceAccountKey(c *gin.Context) {
+	userClaims, account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+	if !account.IsActive {
+		respondAPIKeyError(c, ErrServiceAccountInactive, "create API key")
+		return
+	}
+	h.createAPIKey(c, userClaims, account.ID)
+}
+
+// RevokeServiceAccountKey handles DELETE /api/service-accounts/:id/api-keys/:keyId
+func (h *UserHandler) RevokeServiceAccountKey(c *gin.Context) {
+	userClaims, account, ok := h.loadServiceAccount(c)
+	if !ok {
+		return
+	}
+	h.revokeAPIKey(c, userClaims, account.ID)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	rbac "github.com/louhibi/healthcare-rbac"
+)
+
+func TestAPIKeyPermissions(t *testing.T) {
+	scopes := []string{rbac.PatientRead, rbac.PatientWrite, rbac.UserManage, "retired.permission"}
+	tests := []struct {
+		name   string
+		role   string
+		expect func(sqlmock.Sqlmock)
+		want   []string
+	}{
+		{
+			name: "service accounts get their known scopes",
+			role: ServiceAccountRole,
+			want: []string{rbac.PatientRead, rbac.PatientWrite, rbac.UserManage},
+		},
+		{
+			name: "people get the scopes their built-in role still holds",
+			role: "nurse",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(`SELECT custom_role_id FROM entity_members`).WithArgs(7, 1).WillReturnError(sql.ErrNoRows)
+			},
+			want: []string{rbac.PatientRead, rbac.PatientWrite},
+		},
+		{
+			name: "people get the scopes their custom role still holds",
+			role: "admin",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(`SELECT custom_role_id FROM entity_members`).WithArgs(7, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"custom_role_id"}).AddRow(3))
+				m.ExpectQuery(`FROM custom_roles`).WithArgs(3, 1).
+					WillReturnRows(customRoleRows(3, 1, "Front desk", rbac.PatientRead))
+			},
+			want: []string{rbac.PatientRead},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+			h := &UserHandler{roleService: NewRoleService(db)}
+			user := &User{ID: 7, Role: tt.role, HealthcareEntityID: 1}
+			key := &APIKey{ID: 11, UserID: 7, HealthcareEntityID: 1, Permissions: scopes}
+
+			got, err := h.apiKeyPermissions(user, key)
+			if err != nil {
+				t.Fatalf("apiKeyPermissions error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apiKeyPermissions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// customRoleRows is the row of a custom role as selected with customRoleColumns
+func customRoleRows(id, entityID int, name string, permissions ...string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "healthcare_entity_id", "name", "description", "permissions", "created_by",
+		"created_at", "updated_at", "user_count"}).
+		AddRow(id, entityID, name, "", "{"+strings.Join(permissions, ",")+"}", nil, time.Now(), time.Now(), 0)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	rbac "github.com/louhibi/healthcare-rbac"
+)
+
+const (
+	// apiKeyMarker starts every key, so leaked keys are easy to recognise and scan for
+	apiKeyMarker = "hk_"
+	// defaultAPIKeyDays is the lifetime of keys created without expires_in_days
+	defaultAPIKeyDays = 90
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrAPIKeyInvalid  = errors.New("invalid, expired or revoked API key")
+)
+
+// APIKeyService manages the API keys of users and service accounts. A key is
+// hk_<prefix>_<secret>: the prefix looks the key up, only the SHA-256 of the secret is stored.
+type APIKeyService struct {
+	db *sql.DB
+}
+
+func NewAPIKeyService(db *sql.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+const apiKeyColumns = `id, user_id, healthcare_entity_id, name, prefix, permissions, expires_at, last_used_at,
+	COALESCE(last_used_ip, ''), revoked_at, created_by, created_at`
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	key := &APIKey{}
+	var createdBy sql.NullInt64
+	err := row.Scan(&key.ID, &key.UserID, &key.HealthcareEntityID, &key.Name, &key.Prefix,
+		pq.Array(&key.Permissions), &key.ExpiresAt, &key.LastUsedAt, &key.LastUsedIP, &key.RevokedAt,
+		&createdBy, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.CreatedBy = nullIntPtr(createdBy)
+	if key.Permissions == nil {
+		key.Permissions = []string{}
+	}
+	switch {
+	case key.RevokedAt != nil:
+		key.Status = "revoked"
+	case time.Now().After(key.ExpiresAt):
+		key.Status = "expired"
+	default:
+		key.Status = "active"
+	}
+	return key, nil
+}
+
+// newAPIKey returns a random key with the prefix and secret hash it is stored as
+func newAPIKey() (string, string, string, error) {
+	p := make([]byte, 6)
+	if _, err := rand.Read(p); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	prefix := hex.EncodeToString(p)
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return apiKeyMarker + prefix + "_" + secret, prefix, hashAPIKeySecret(secret), nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKey splits a key into its prefix and secret
+func parseAPIKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, apiKeyMarker) {
+		return "", "", false
+	}
+	prefix, secret, found := strings.Cut(strings.TrimPrefix(key, apiKeyMarker), "_")
+	if !found || len(prefix) != 12 || secret == "" {
+		return "", "", false
+	}
+	return prefix, secret, true
+}
+
+// validateAPIKeyPermissions normalizes the scopes of a key request and rejects unknown ones
+func validateAPIKeyPermissions(req *APIKeyRequest) error {
+	req.Permissions = rbac.Normalize(req.Permissions)
+	var unknown []string
+	for _, permission := range req.Permissions {
+		if !rbac.IsKnown(permission) {
+			unknown = append(unknown, permission)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownPermissions, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// CreateKey creates a key of a user for an entity and returns it with the full key, which
+// can't be retrieved later
+func (s *APIKeyService) CreateKey(entityID, userID int, req *APIKeyRequest, createdBy int) (*APIKey, string, error) {
+	if err := validateAPIKeyPermissions(req); err != nil {
+		return nil, "", err
+	}
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = defaultAPIKeyDays
+	}
+	key, prefix, secretHash, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiKey, err := scanAPIKey(s.db.QueryRow(`
+		INSERT INTO api_keys (user_id, healthcare_entity_id, name, prefix, secret_hash, permissions, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+apiKeyColumns,
+		userID, entityID, strings.TrimSpace(req.Name), prefix, secretHash, pq.Array(req.Permissions),
+		time.Now().AddDate(0, 0, days), createdBy))
+	if err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// ListKeys returns the keys of a user for an entity, newest first
+func (s *APIKeyService) ListKeys(entityID, userID int) ([]APIKey, error) {
+	rows, err := s.db.Query(`
+		SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE healthcare_entity_id = $1 AND user_id = $2
+		ORDER BY created_at DESC, id DESC
+	`, entityID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeKey revokes a key of a user for an entity. Revoking a revoked key is a no-op.
+func (s *APIKeyService) RevokeKey(entityID, userID, keyID, revokedBy int) (*APIKey, error) {
+	key, err := scanAPIKey(s.db.QueryRow(`
+		UPDATE api_keys SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP), revoked_by = COALESCE(revoked_by, $4)
+		WHERE id = $1 AND healthcare_entity_id = $2 AND user_id = $3
+		RETURNING `+apiKeyColumns, keyID, entityID, userID, revokedBy))
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// RevokeUserKeys revokes every active key of a user and returns how many were revoked
+func (s *APIKeyService) RevokeUserKeys(exec dbExecutor, userID, revokedBy int) (int64, error) {
+	result, err := exec.Exec(`
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $2
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, revokedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Authenticate checks a key is known, active and unexpired, and records its use
+func (s *APIKeyService) Authenticate(key, ipAddress string) (*APIKey, error) {
+	prefix, secret, ok := parseAPIKey(key)
+	if !ok {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	var id int
+	var secretHash string
+	err := s.db.QueryRow(`
+		SELECT id, secret_hash FROM api_keys
+		WHERE prefix = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	`, prefix).Scan(&id, &secretHash)
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(secretHash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	return scanAPIKey(s.db.QueryRow(`
+		UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = $2
+		WHERE id = $1
+		RETURNING `+apiKeyColumns, id, ipAddress))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewAPIKeyRoundTrip(t *testing.T) {
+	key, prefix, hash, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, apiKeyMarker) {
+		t.Errorf("key %q doesn't start with %q", key, apiKeyMarker)
+	}
+
+	gotPrefix, secret, ok := parseAPIKey(key)
+	if !ok {
+		t.Fatalf("parseAPIKey(%q) failed", key)
+	}
+	if gotPrefix != prefix {
+		t.Errorf("parsed prefix = %q, want %q", gotPrefix, prefix)
+	}
+	if hashAPIKeySecret(secret) != hash {
+		t.Error("hash of the parsed secret doesn't match the stored hash")
+	}
+
+	other, otherPrefix, _, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key || otherPrefix == prefix {
+		t.Error("two generated keys share their value or prefix")
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		wantPrefix string
+		wantSecret string
+		wantOK     bool
+	}{
+		{"valid", "hk_0123456789ab_c2VjcmV0", "0123456789ab", "c2VjcmV0", true},
+		{"secret containing underscores", "hk_0123456789ab_se_cr_et", "0123456789ab", "se_cr_et", true},
+		{"missing marker", "0123456789ab_c2VjcmV0", "", "", false},
+		{"other marker", "sk_0123456789ab_c2VjcmV0", "", "", false},
+		{"short prefix", "hk_0123456789a_c2VjcmV0", "", "", false},
+		{"long prefix", "hk_0123456789abc_c2VjcmV0", "", "", false},
+		{"no secret", "hk_0123456789ab_", "", "", false},
+		{"no separator", "hk_0123456789abc2VjcmV0", "", "", false},
+		{"empty", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, secret, ok := parseAPIKey(tt.key)
+			if prefix != tt.wantPrefix || secret != tt.wantSecret || ok != tt.wantOK {
+				t.Errorf("parseAPIKey(%q) = (%q, %q, %v), want (%q, %q, %v)", tt.key, prefix, secret, ok,
+					tt.wantPrefix, tt.wantSecret, tt.wantOK)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	rbac "github.com/louhibi/healthcare-rbac"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -36,43 +37,139 @@ func (a *AuthService) CheckPassword(password, hash string) bool {
 	return err == nil
 }
 
-// GenerateTokens generates access and refresh tokens
-func (a *AuthService) GenerateTokens(user *User) (string, string, error) {
-	// Access token (expires in 15 minutes)
+// GenerateAccessToken generates a 15 minute access token for a session of the user, carrying
+// the user's permissions. Refresh tokens are opaque and issued by the SessionService.
+func (a *AuthService) GenerateAccessToken(user *User, session *UserSession, permissions []string) (string, error) {
 	accessClaims := jwt.MapClaims{
 		"user_id":             user.ID,
 		"email":               user.Email,
 		"role":                user.Role,
 		"healthcare_entity_id": user.HealthcareEntityID,
 		"is_temp_password":    user.IsTempPassword,
+		"sid":                 session.ID,
+		"mfa":                 session.MFAVerified,
+		"permissions":         permissions,
 		"exp":                 time.Now().Add(time.Minute * 15).Unix(),
 		"iat":                 time.Now().Unix(),
 		"type":                "access",
 	}
 
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString(a.jwtSecret)
+	return accessToken.SignedString(a.jwtSecret)
+}
+
+// apiKeyTokenTTL is the lifetime of access tokens exchanged for an API key
+const apiKeyTokenTTL = 5 * time.Minute
+
+// GenerateAPIKeyToken generates the access token an API key is exchanged for. It has no
+// session and carries the key's ID, so key tokens can be told apart from login tokens. mfa
+// is set when the key's owner has enrolled in MFA.
+func (a *AuthService) GenerateAPIKeyToken(user *User, apiKeyID int, permissions []string, mfa bool) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id":              user.ID,
+		"email":                user.Email,
+		"role":                 user.Role,
+		"healthcare_entity_id": user.HealthcareEntityID,
+		"is_temp_password":     false,
+		"mfa":                  mfa,
+		"akid":                 apiKeyID,
+		"permissions":          permissions,
+		"exp":                  time.Now().Add(apiKeyTokenTTL).Unix(),
+		"iat":                  time.Now().Unix(),
+		"type":                 "access",
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(a.jwtSecret)
+}
+
+// mfaChallengeTTL is how long a user has to complete a login with the second factor
+const mfaChallengeTTL = 5 * time.Minute
+
+// GenerateMFAChallenge generates the short-lived token that stands for a password-checked login
+// until the second factor is verified ("verify") or enrolled ("enroll")
+func (a *AuthService) GenerateMFAChallenge(user *User, purpose string) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": user.ID,
+		"purpose": purpose,
+		"exp":     time.Now().Add(mfaChallengeTTL).Unix(),
+		"iat":     time.Now().Unix(),
+		"type":    "mfa_challenge",
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(a.jwtSecret)
+}
+
+// ValidateMFAChallenge validates an MFA challenge token issued for purpose and returns its user ID
+func (a *AuthService) ValidateMFAChallenge(tokenString, purpose string) (int, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+		return a.jwtSecret, nil
+	})
 	if err != nil {
-		return "", "", err
+		return 0, err
 	}
 
-	// Refresh token (expires in 7 days)
-	refreshClaims := jwt.MapClaims{
-		"user_id":             user.ID,
-		"email":               user.Email,
-		"healthcare_entity_id": user.HealthcareEntityID,
-		"exp":                 time.Now().Add(time.Hour * 24 * 7).Unix(),
-		"iat":                 time.Now().Unix(),
-		"type":                "refresh",
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return 0, errors.New("invalid token claims")
+	}
+	if tokenType, _ := claims["type"].(string); tokenType != "mfa_challenge" {
+		return 0, errors.New("invalid token type")
 	}
+	if tokenPurpose, _ := claims["purpose"].(string); tokenPurpose != purpose {
+		return 0, errors.New("invalid challenge purpose")
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, errors.New("invalid user_id in token")
+	}
+	return int(userID), nil
+}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshTokenString, err := refreshToken.SignedString(a.jwtSecret)
+// GenerateInvitationToken signs the token of an invitation link; nonce identifies the latest
+// sending of the invitation
+func (a *AuthService) GenerateInvitationToken(invitationID int, nonce string, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"invitation_id": invitationID,
+		"nonce":         nonce,
+		"exp":           expiresAt.Unix(),
+		"iat":           time.Now().Unix(),
+		"type":          "invitation",
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(a.jwtSecret)
+}
+
+// ValidateInvitationToken validates an invitation token and returns its invitation ID and nonce
+func (a *AuthService) ValidateInvitationToken(tokenString string) (int, string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+		return a.jwtSecret, nil
+	})
 	if err != nil {
-		return "", "", err
+		return 0, "", err
 	}
 
-	return accessTokenString, refreshTokenString, nil
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return 0, "", errors.New("invalid token claims")
+	}
+	if tokenType, _ := claims["type"].(string); tokenType != "invitation" {
+		return 0, "", errors.New("invalid token type")
+	}
+	invitationID, ok := claims["invitation_id"].(float64)
+	if !ok {
+		return 0, "", errors.New("invalid invitation_id in token")
+	}
+	nonce, ok := claims["nonce"].(string)
+	if !ok || nonce == "" {
+		return 0, "", errors.New("invalid nonce in token")
+	}
+	return int(invitationID), nonce, nil
 }
 
 // ValidateToken validates and parses JWT token
@@ -131,61 +228,41 @@ func (a *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 		}
 	}
 
-	return &Claims{
-		UserID:             int(userID),
-		Email:              email,
-		Role:               role,
-		HealthcareEntityID: int(healthcareEntityID),
-		IsTempPassword:     isTempPassword,
-	}, nil
-}
-
-// ValidateRefreshToken validates refresh token
-func (a *AuthService) ValidateRefreshToken(tokenString string) (*Claims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return a.jwtSecret, nil
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	if !token.Valid {
-		return nil, errors.New("invalid token")
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, errors.New("invalid token claims")
+	// sid is absent from tokens issued before sessions existed
+	sessionID := 0
+	if sid, ok := claims["sid"].(float64); ok {
+		sessionID = int(sid)
 	}
 
-	// Check token type
-	tokenType, ok := claims["type"].(string)
-	if !ok || tokenType != "refresh" {
-		return nil, errors.New("invalid token type")
-	}
-
-	userID, ok := claims["user_id"].(float64)
-	if !ok {
-		return nil, errors.New("invalid user_id in token")
-	}
+	// mfa is true when the session's login verified a second factor
+	mfa, _ := claims["mfa"].(bool)
 
-	email, ok := claims["email"].(string)
-	if !ok {
-		return nil, errors.New("invalid email in token")
+	// akid is set on tokens exchanged for an API key
+	apiKeyID := 0
+	if akid, ok := claims["akid"].(float64); ok {
+		apiKeyID = int(akid)
 	}
 
-	healthcareEntityID, ok := claims["healthcare_entity_id"].(float64)
-	if !ok {
-		return nil, errors.New("invalid healthcare_entity_id in token")
+	// Tokens issued before permissions existed get those of the built-in role
+	permissions := rbac.RolePermissions(role)
+	if values, ok := claims["permissions"].([]interface{}); ok {
+		permissions = make([]string, 0, len(values))
+		for _, value := range values {
+			if permission, ok := value.(string); ok {
+				permissions = append(permissions, permission)
+			}
+		}
 	}
 
 	return &Claims{
 		UserID:             int(userID),
 		Email:              email,
+		Role:               role,
 		HealthcareEntityID: int(healthcareEntityID),
+		IsTempPassword:     isTempPassword,
+		SessionID:          sessionID,
+		MFA:                mfa,
+		Permissions:        permissions,
+		APIKeyID:           apiKeyID,
 	}, nil
-}
\ No newline at end of file
+}
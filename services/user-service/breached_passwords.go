@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// defaultBreachedPasswordsPath is the list shipped with the service
+const defaultBreachedPasswordsPath = "data/breached_passwords.txt"
+
+// BreachedPasswordChecker tells whether a password is known from data breaches. Lookups use
+// the k-anonymity range model of Pwned Passwords: the first 5 hex characters of the
+// password's SHA-1 select a range and only the rest of the hash is compared against it.
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// NewBreachedPasswordCheckerFromEnv reads the list at BREACHED_PASSWORDS_PATH, or the list
+// shipped with the service. Returns nil when there is no list.
+func NewBreachedPasswordCheckerFromEnv() BreachedPasswordChecker {
+	path := os.Getenv("BREACHED_PASSWORDS_PATH")
+	if path == "" {
+		path = defaultBreachedPasswordsPath
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		logging.LogWarn("Breached password list not found, passwords are not checked against it", "path", path)
+		return nil
+	}
+	if info.IsDir() {
+		return &rangeDirChecker{dir: path}
+	}
+	return &rangeFileChecker{path: path}
+}
+
+// sha1Range splits the uppercase hex SHA-1 of a password into its range prefix and suffix
+func sha1Range(password string) (string, string) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return hash[:5], hash[5:]
+}
+
+// parseRangeLine returns the hash of a list line: "HASH" or "HASH:COUNT", blank lines and
+// # comments are skipped
+func parseRangeLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(line), true
+}
+
+// rangeFileChecker reads one file of full SHA-1 hashes, indexed by range prefix when first
+// used. Suited to lists of commonly used passwords.
+type rangeFileChecker struct {
+	path string
+
+	once   sync.Once
+	ranges map[string]map[string]bool
+	err    error
+}
+
+func (c *rangeFileChecker) load() {
+	file, err := os.Open(c.path)
+	if err != nil {
+		c.err = fmt.Errorf("failed to open breached password list: %v", err)
+		return
+	}
+	defer file.Close()
+
+	c.ranges = make(map[string]map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash, ok := parseRangeLine(scanner.Text())
+		if !ok || len(hash) != 40 {
+			continue
+		}
+		prefix := hash[:5]
+		if c.ranges[prefix] == nil {
+			c.ranges[prefix] = make(map[string]bool)
+		}
+		c.ranges[prefix][hash[5:]] = true
+	}
+	if err := scanner.Err(); err != nil {
+		c.err = fmt.Errorf("failed to read breached password list: %v", err)
+	}
+}
+
+func (c *rangeFileChecker) IsBreached(password string) (bool, error) {
+	c.once.Do(c.load)
+	if c.err != nil {
+		return false, c.err
+	}
+	prefix, suffix := sha1Range(password)
+	return c.ranges[prefix][suffix], nil
+}
+
+// rangeDirChecker reads a directory of range files named after their prefix (ABCDE or
+// ABCDE.txt) holding "SUFFIX:COUNT" lines, as written by the Pwned Passwords downloader.
+// Only the range of the password is read, so the full corpus can be used.
+type rangeDirChecker struct {
+	dir string
+}
+
+func (c *rangeDirChecker) IsBreached(password string) (bool, error) {
+	prefix, suffix := sha1Range(password)
+	file, err := os.Open(filepath.Join(c.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(filepath.Join(c.dir, prefix))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open breached password range: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if hash, ok := parseRangeLine(scanner.Text()); ok && hash == suffix {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// SHA-1 of "password", split into its range prefix and suffix
+const (
+	passwordRange  = "5BAA6"
+	passwordSuffix = "1E4C9B93F3F0682250B6CF8331B7EE68FD8"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSHA1Range(t *testing.T) {
+	prefix, suffix := sha1Range("password")
+	if prefix != passwordRange || suffix != passwordSuffix {
+		t.Errorf("sha1Range(password) = (%s, %s), want (%s, %s)", prefix, suffix, passwordRange, passwordSuffix)
+	}
+}
+
+func TestParseRangeLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   string
+		wantOK bool
+	}{
+		{passwordRange + passwordSuffix, passwordRange + passwordSuffix, true},
+		{"1e4c9b93f3f0682250b6cf8331b7ee68fd8:3861493", passwordSuffix, true},
+		{"  " + passwordSuffix + ":12\r", passwordSuffix, true},
+		{"", "", false},
+		{"   ", "", false},
+		{"# comment", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRangeLine(tt.line)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRangeLine(%q) = (%q, %v), want (%q, %v)", tt.line, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRangeFileChecker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	writeTestFile(t, path, "# breached passwords\n\n"+
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:3861493\n"+
+		"7C4A8D09CA3762AF61E59520943DC26494F8941B\n"+ // 123456
+		"ABCDEF\n") // not a full hash, ignored
+	checker := &rangeFileChecker{path: path}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"123456", true},
+		{"Password", false},
+		{"correct horse battery staple", false},
+	}
+	for _, tt := range tests {
+		got, err := checker.IsBreached(tt.password)
+		if err != nil {
+			t.Fatalf("IsBreached(%q) error = %v", tt.password, err)
+		}
+		if got != tt.want {
+			t.Errorf("IsBreached(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestRangeFileCheckerMissingFile(t *testing.T) {
+	checker := &rangeFileChecker{path: filepath.Join(t.TempDir(), "missing.txt")}
+	if _, err := checker.IsBreached("password"); err == nil {
+		t.Error("IsBreached with a missing list returned no error")
+	}
+}
+
+func TestRangeDirChecker(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, passwordRange+".txt"), "0018A45C4D1DEF81644B54AB7F969B88D65:1\n"+passwordSuffix+":3861493\n")
+	writeTestFile(t, filepath.Join(dir, "7C4A8"), "D09CA3762AF61E59520943DC26494F8941B:37359195\n") // 123456, without extension
+	checker := &rangeDirChecker{dir: dir}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"123456", true},
+		{"Password", false},                     // range file missing
+		{"correct horse battery staple", false}, // range file missing
+	}
+	for _, tt := range tests {
+		got, err := checker.IsBreached(tt.password)
+		if err != nil {
+			t.Fatalf("IsBreached(%q) error = %v", tt.password, err)
+		}
+		if got != tt.want {
+			t.Errorf("IsBreached(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestShippedBreachedPasswordList(t *testing.T) {
+	checker := &rangeFileChecker{path: defaultBreachedPasswordsPath}
+	breached, err := checker.IsBreached("password")
+	if err != nil {
+		t.Fatalf("IsBreached error = %v", err)
+	}
+	if !breached {
+		t.Error("the shipped list doesn't contain \"password\"")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config-service keys of the public registration policy
+const (
+	registrationEnabledFlag         = "registration.enabled"
+	registrationAllowedRolesKey     = "registration.allowed_roles"
+	registrationAllowedDomainsKey   = "registration.allowed_email_domains"
+	defaultRegistrationAllowedRoles = "staff"
+)
+
+// RegistrationPolicy limits public self-registration; invitations are not affected
+type RegistrationPolicy struct {
+	Enabled             bool
+	AllowedRoles        []string
+	AllowedEmailDomains []string // Empty allows any domain
+}
+
+// AllowsRole reports whether self-registration may choose the role
+func (p *RegistrationPolicy) AllowsRole(role string) bool {
+	for _, allowed := range p.AllowedRoles {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsEmail reports whether the domain of the email may self-register
+func (p *RegistrationPolicy) AllowsEmail(email string) bool {
+	if len(p.AllowedEmailDomains) == 0 {
+		return true
+	}
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	for _, allowed := range p.AllowedEmailDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigClient reads public feature flags and settings from config-service
+type ConfigClient struct {
+	configServiceURL string
+	httpClient       *http.Client
+}
+
+// NewConfigClientFromEnv reads the service location from CONFIG_SERVICE_URL
+func NewConfigClientFromEnv() *ConfigClient {
+	configServiceURL := os.Getenv("CONFIG_SERVICE_URL")
+	if configServiceURL == "" {
+		configServiceURL = "http://config-service:8085"
+	}
+	return &ConfigClient{
+		configServiceURL: configServiceURL,
+		httpClient:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// getJSON sends a GET request and decodes a 200 response into out
+func (c *ConfigClient) getJSON(path string, out interface{}) error {
+	resp, err := c.httpClient.Get(c.configServiceURL + path)
+	if err != nil {
+		return fmt.Errorf("config-service request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("config-service returned status %d for %s", resp.StatusCode, path)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode config-service response: %w", err)
+	}
+	return nil
+}
+
+// GetRegistrationPolicy reads the public registration flag and its limits. A missing flag
+// disables registration.
+func (c *ConfigClient) GetRegistrationPolicy() (*RegistrationPolicy, error) {
+	var flags []struct {
+		Name    string `json:"name"`
+		Enabled bool   `json:"enabled"`
+	}
+	if err := c.getJSON("/api/config/public/flags", &flags); err != nil {
+		return nil, err
+	}
+	var settings []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	if err := c.getJSON("/api/config/public/settings", &settings); err != nil {
+		return nil, err
+	}
+
+	policy := &RegistrationPolicy{}
+	for _, flag := range flags {
+		if flag.Name == registrationEnabledFlag {
+			policy.Enabled = flag.Enabled
+		}
+	}
+	roles := defaultRegistrationAllowedRoles
+	domains := ""
+	for _, setting := range settings {
+		switch setting.Key {
+		case registrationAllowedRolesKey:
+			roles = setting.Value
+		case registrationAllowedDomainsKey:
+			domains = setting.Value
+		}
+	}
+	policy.AllowedRoles = splitList(roles)
+	policy.AllowedEmailDomains = splitList(strings.ToLower(domains))
+	return policy, nil
+}
+
+// splitList splits a comma separated setting, dropping blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+	rbac "github.com/louhibi/healthcare-rbac"
+)
+
+// respondDepartmentError maps department service errors to HTTP responses
+func respondDepartmentError(c *gin.Context, err error, operation string) {
+	switch {
+	case errors.Is(err, ErrDepartmentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+	case errors.Is(err, ErrParentNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parent department not found"})
+	case errors.Is(err, ErrNotEntityMember):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Users must be members of this entity"})
+	case errors.Is(err, ErrDepartmentCycle), errors.Is(err, ErrDepartmentInactive):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrDepartmentExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "A department with this name already exists"})
+	case errors.Is(err, ErrActiveSubDepartments):
+		c.JSON(http.StatusConflict, gin.H{"error": "Deactivate or move the sub-departments first"})
+	case errors.Is(err, ErrNotDepartmentMember):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not assigned to this department"})
+	default:
+		logging.LogError("Department operation failed", "operation", operation, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// departmentID reads the :id route parameter of department routes
+func departmentID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// departmentFilter reads the optional ?department_id= filter and checks the department
+// belongs to the entity. Returns 0 without a filter.
+func (h *UserHandler) departmentFilter(c *gin.Context, entityID int) (int, bool) {
+	value := c.Query("department_id")
+	if value == "" {
+		return 0, true
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+		return 0, false
+	}
+	if _, err := h.departmentService.GetDepartment(entityID, id); err != nil {
+		respondDepartmentError(c, err, "get department")
+		return 0, false
+	}
+	return id, true
+}
+
+// canAssignDepartmentMembers allows department managers, and heads for their own department
+// and its sub-departments
+func (h *UserHandler) canAssignDepartmentMembers(c *gin.Context, userClaims *Claims, id int) bool {
+	if rbac.Has(c, rbac.DepartmentManage) {
+		return true
+	}
+	head, err := h.departmentService.IsHead(userClaims.HealthcareEntityID, id, userClaims.UserID)
+	if err != nil {
+		respondDepartmentError(c, err, "check department head")
+		return false
+	}
+	if !head {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return false
+	}
+	return true
+}
+
+// GetDepartments handles GET /api/departments - the departments of the caller's entity.
+// ?include_inactive=true adds deactivated departments, ?include_members=true the IDs of
+// each department's users.
+func (h *UserHandler) GetDepartments(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	departments, err := h.departmentService.ListDepartments(userClaims.HealthcareEntityID,
+		c.Query("include_inactive") == "true", c.Query("include_members") == "true")
+	if err != nil {
+		respondDepartmentError(c, err, "get departments")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"departments": departments})
+}
+
+// GetDepartment handles GET /api/departments/:id
+func (h *UserHandler) GetDepartment(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := departmentID(c)
+	if !ok {
+		return
+	}
+
+	department, err := h.departmentService.GetDepartment(userClaims.HealthcareEntityID, id)
+	if err != nil {
+		respondDepartmentError(c, err, "get department")
+		return
+	}
+	c.JSON(http.StatusOK, department)
+}
+
+// CreateDepartment handles POST /api/departments
+func (h *UserHandler) CreateDepartment(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	var req DepartmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	department, err := h.departmentService.CreateDepartment(userClaims.HealthcareEntityID, &req, userClaims.UserID)
+	if err != nil {
+		respondDepartmentError(c, err, "create department")
+		return
+	}
+
+	logging.LogInfo("Department created", "department_id", department.ID,
+		"healthcare_entity_id", userClaims.HealthcareEntityID, "created_by", userClaims.UserID)
+	c.JSON(http.StatusCreated, department)
+}
+
+// UpdateDepartment handles PUT /api/departments/:id - renames, moves, sets the head of,
+// deactivates or reactivates a department
+func (h *UserHandler) UpdateDepartment(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := departmentID(c)
+	if !ok {
+		return
+	}
+
+	var req DepartmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	department, err := h.departmentService.UpdateDepartment(userClaims.HealthcareEntityID, id, &req, userClaims.UserID)
+	if err != nil {
+		respondDepartmentError(c, err, "update department")
+		return
+	}
+
+	logging.LogInfo("Department updated", "department_id", id, "healthcare_entity_id", userClaims.HealthcareEntityID,
+		"is_active", department.IsActive, "updated_by", userClaims.UserID)
+	c.JSON(http.StatusOK, department)
+}
+
+// GetDepartmentMembers handles GET /api/departments/:id/members - the department's users.
+// ?include_subdepartments=true adds the users of its sub-departments; department_ids lists
+// the departments covered.
+func (h *UserHandler) GetDepartmentMembers(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := departmentID(c)
+	if !ok {
+		return
+	}
+
+	recursive := c.Query("include_subdepartments") == "true"
+	departmentIDs := []int{id}
+	if recursive {
+		var err error
+		if departmentIDs, err = h.departmentService.SubtreeIDs(userClaims.HealthcareEntityID, id); err != nil {
+			respondDepartmentError(c, err, "get department members")
+			return
+		}
+	}
+	members, err := h.departmentService.ListMembers(userClaims.HealthcareEntityID, id, recursive)
+	if err != nil {
+		respondDepartmentError(c, err, "get department members")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"department_ids": departmentIDs, "members": members})
+}
+
+// AddDepartmentMembers handles POST /api/departments/:id/members - assigns users of the entity
+// to the department
+func (h *UserHandler) AddDepartmentMembers(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := departmentID(c)
+	if !ok {
+		return
+	}
+
+	var req DepartmentMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.canAssignDepartmentMembers(c, userClaims, id) {
+		return
+	}
+
+	added, err := h.departmentService.AddMembers(userClaims.HealthcareEntityID, id, req.UserIDs, userClaims.UserID)
+	if err != nil {
+		respondDepartmentError(c, err, "assign department members")
+		return
+	}
+
+	logging.LogInfo("Department members assigned", "department_id", id, "added", added, "assigned_by", userClaims.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Users assigned successfully", "added": added})
+}
+
+// RemoveDepartmentMember handles DELETE /api/departments/:id/members/:userId
+func (h *UserHandler) RemoveDepartmentMember(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := departmentID(c)
+	if !ok {
+		return
+	}
+	userID, ok := memberUserID(c)
+	if !ok {
+		return
+	}
+	if !h.canAssignDepartmentMembers(c, userClaims, id) {
+		return
+	}
+
+	if err := h.departmentService.RemoveMember(userClaims.HealthcareEntityID, id, userID); err != nil {
+		respondDepartmentError(c, err, "remove department member")
+		return
+	}
+
+	logging.LogInfo("Department member removed", "department_id", id, "user_id", userID, "removed_by", userClaims.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "User removed from the department"})
+}
+
+// GetUserDepartments handles GET /api/users/:id/departments - the departments of the caller's
+// entity a user is assigned to
+func (h *UserHandler) GetUserDepartments(c *gin.Context) {
+	userClaims, user, ok := h.loadEntityMember(c)
+	if !ok {
+		return
+	}
+
+	departments, err := h.departmentService.UserDepartments(userClaims.HealthcareEntityID, user.ID)
+	if err != nil {
+		respondDepartmentError(c, err, "get user departments")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "departments": departments})
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDepartmentNotFound   = errors.New("department not found")
+	ErrDepartmentExists     = errors.New("a department with this name already exists")
+	ErrDepartmentInactive   = errors.New("department is deactivated")
+	ErrDepartmentCycle      = errors.New("a department can't be placed under itself or one of its sub-departments")
+	ErrActiveSubDepartments = errors.New("department has active sub-departments")
+	ErrParentNotFound       = errors.New("parent department not found")
+	ErrNotEntityMember      = errors.New("user is not a member of this entity")
+	ErrNotDepartmentMember  = errors.New("user is not assigned to this department")
+)
+
+// DepartmentService manages the department hierarchy of entities and the users assigned to
+// departments. Departments are deactivated rather than deleted, because rooms and reports of
+// other services refer to them.
+type DepartmentService struct {
+	db *sql.DB
+}
+
+func NewDepartmentService(db *sql.DB) *DepartmentService {
+	return &DepartmentService{db: db}
+}
+
+// departmentSubtree selects a department and its sub-departments at every depth; the verb is
+// the number of the department ID parameter
+const departmentSubtree = `WITH RECURSIVE subtree AS (
+		SELECT id FROM departments WHERE id = $%d
+		UNION
+		SELECT d.id FROM departments d JOIN subtree s ON d.parent_id = s.id
+	) SELECT id FROM subtree`
+
+// Members are counted while they still belong to the entity
+const departmentColumns = `d.id, d.healthcare_entity_id, d.parent_id, d.name, d.description, d.head_user_id,
+	COALESCE(h.first_name || ' ' || h.last_name, ''), d.is_active, d.created_by, d.created_at, d.updated_at,
+	(SELECT COUNT(*) FROM user_departments ud
+	 JOIN entity_members em ON em.user_id = ud.user_id AND em.healthcare_entity_id = d.healthcare_entity_id
+	 JOIN users u ON u.id = ud.user_id AND u.is_active = true
+	 WHERE ud.department_id = d.id)`
+
+const departmentFrom = ` FROM departments d LEFT JOIN users h ON h.id = d.head_user_id`
+
+func scanDepartment(row rowScanner) (*Department, error) {
+	d := &Department{}
+	var parentID, headUserID, createdBy sql.NullInt64
+	err := row.Scan(&d.ID, &d.HealthcareEntityID, &parentID, &d.Name, &d.Description, &headUserID, &d.HeadName,
+		&d.IsActive, &createdBy, &d.CreatedAt, &d.UpdatedAt, &d.MemberCount)
+	if err != nil {
+		return nil, err
+	}
+	d.ParentID = nullIntPtr(parentID)
+	d.HeadUserID = nullIntPtr(headUserID)
+	d.CreatedBy = nullIntPtr(createdBy)
+	return d, nil
+}
+
+// isDepartmentNameConflict reports whether err violates the unique department name per entity
+func isDepartmentNameConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// ListDepartments returns the departments of an entity by name. withMembers fills MemberIDs.
+func (s *DepartmentService) ListDepartments(entityID int, includeInactive, withMembers bool) ([]Department, error) {
+	query := `SELECT ` + departmentColumns + departmentFrom + ` WHERE d.healthcare_entity_id = $1`
+	if !includeInactive {
+		query += ` AND d.is_active = true`
+	}
+	rows, err := s.db.Query(query+` ORDER BY d.name`, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	departments := []Department{}
+	for rows.Next() {
+		department, err := scanDepartment(rows)
+		if err != nil {
+			return nil, err
+		}
+		departments = append(departments, *department)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !withMembers {
+		return departments, nil
+	}
+
+	members, err := s.memberIDsByDepartment(entityID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range departments {
+		departments[i].MemberIDs = members[departments[i].ID]
+		if departments[i].MemberIDs == nil {
+			departments[i].MemberIDs = []int{}
+		}
+	}
+	return departments, nil
+}
+
+// memberIDsByDepartment returns the active users of each department of the entity
+func (s *DepartmentService) memberIDsByDepartment(entityID int) (map[int][]int, error) {
+	rows, err := s.db.Query(`
+		SELECT ud.department_id, ud.user_id
+		FROM user_departments ud
+		JOIN departments d ON d.id = ud.department_id
+		JOIN entity_members em ON em.user_id = ud.user_id AND em.healthcare_entity_id = d.healthcare_entity_id
+		JOIN users u ON u.id = ud.user_id AND u.is_active = true
+		WHERE d.healthcare_entity_id = $1
+		ORDER BY ud.department_id, ud.user_id
+	`, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := map[int][]int{}
+	for rows.Next() {
+		var departmentID, userID int
+		if err := rows.Scan(&departmentID, &userID); err != nil {
+			return nil, err
+		}
+		members[departmentID] = append(members[departmentID], userID)
+	}
+	return members, rows.Err()
+}
+
+// GetDepartment returns a department of the entity, active or not
+func (s *DepartmentService) GetDepartment(entityID, id int) (*Department, error) {
+	department, err := scanDepartment(s.db.QueryRow(`SELECT `+departmentColumns+departmentFrom+`
+		WHERE d.id = $1 AND d.healthcare_entity_id = $2`, id, entityID))
+	if err == sql.ErrNoRows {
+		return nil, ErrDepartmentNotFound
+	}
+	return department, err
+}
+
+// SubtreeIDs returns the department and its sub-departments at every depth
+func (s *DepartmentService) SubtreeIDs(entityID, id int) ([]int, error) {
+	if _, err := s.GetDepartment(entityID, id); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(fmt.Sprintf(departmentSubtree, 1), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var departmentID int
+		if err := rows.Scan(&departmentID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, departmentID)
+	}
+	return ids, rows.Err()
+}
+
+// isEntityMember reports whether the user is an active user belonging to the entity
+func (s *DepartmentService) isEntityMember(entityID, userID int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM entity_members em JOIN users u ON u.id = em.user_id
+			WHERE em.healthcare_entity_id = $1 AND em.user_id = $2 AND u.is_active = true
+		)
+	`, entityID, userID).Scan(&exists)
+	return exists, err
+}
+
+// validateParent checks the parent is an active department of the entity and, for an
+// existing department, not the department itself or one of its sub-departments
+func (s *DepartmentService) validateParent(entityID, id int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+	parent, err := s.GetDepartment(entityID, *parentID)
+	if errors.Is(err, ErrDepartmentNotFound) {
+		return ErrParentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !parent.IsActive {
+		return ErrDepartmentInactive
+	}
+	if id == 0 {
+		return nil
+	}
+	subtree, err := s.SubtreeIDs(entityID, id)
+	if err != nil {
+		return err
+	}
+	for _, departmentID := range subtree {
+		if departmentID == *parentID {
+			return ErrDepartmentCycle
+		}
+	}
+	return nil
+}
+
+// validateHead checks the head belongs to the entity
+func (s *DepartmentService) validateHead(entityID int, headUserID *int) error {
+	if headUserID == nil {
+		return nil
+	}
+	member, err := s.isEntityMember(entityID, *headUserID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return ErrNotEntityMember
+	}
+	return nil
+}
+
+// assignHead makes the head a member of the department
+func assignHead(tx *sql.Tx, departmentID int, headUserID *int, assignedBy int) error {
+	if headUserID == nil {
+		return nil
+	}
+	_, err := tx.Exec(`
+		INSERT INTO user_departments (user_id, department_id, created_by) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, department_id) DO NOTHING
+	`, *headUserID, departmentID, assignedBy)
+	return err
+}
+
+// CreateDepartment adds an active department to the entity. The head, if any, is assigned to
+// the department.
+func (s *DepartmentService) CreateDepartment(entityID int, req *DepartmentRequest, createdBy int) (*Department, error) {
+	if err := s.validateParent(entityID, 0, req.ParentID); err != nil {
+		return nil, err
+	}
+	if err := s.validateHead(entityID, req.HeadUserID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO departments (healthcare_entity_id, parent_id, name, description, head_user_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, entityID, req.ParentID, strings.TrimSpace(req.Name), req.Description, req.HeadUserID, createdBy).Scan(&id)
+	if err != nil {
+		if isDepartmentNameConflict(err) {
+			return nil, ErrDepartmentExists
+		}
+		return nil, err
+	}
+	if err := assignHead(tx, id, req.HeadUserID, createdBy); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetDepartment(entityID, id)
+}
+
+// UpdateDepartment replaces a department's details, parent and head, and deactivates or
+// reactivates it when IsActive is set. A department with active sub-departments stays active.
+func (s *DepartmentService) UpdateDepartment(entityID, id int, req *DepartmentRequest, updatedBy int) (*Department, error) {
+	if _, err := s.GetDepartment(entityID, id); err != nil {
+		return nil, err
+	}
+	if err := s.validateParent(entityID, id, req.ParentID); err != nil {
+		return nil, err
+	}
+	if err := s.validateHead(entityID, req.HeadUserID); err != nil {
+		return nil, err
+	}
+	if req.IsActive != nil && !*req.IsActive {
+		var activeChildren bool
+		err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM departments WHERE parent_id = $1 AND is_active = true)`, id).
+			Scan(&activeChildren)
+		if err != nil {
+			return nil, err
+		}
+		if activeChildren {
+			return nil, ErrActiveSubDepartments
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE departments
+		SET parent_id = $3, name = $4, description = $5, head_user_id = $6, is_active = COALESCE($7, is_active),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND healthcare_entity_id = $2
+	`, id, entityID, req.ParentID, strings.TrimSpace(req.Name), req.Description, req.HeadUserID, req.IsActive)
+	if err != nil {
+		if isDepartmentNameConflict(err) {
+			return nil, ErrDepartmentExists
+		}
+		return nil, err
+	}
+	if err := assignHead(tx, id, req.HeadUserID, updatedBy); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetDepartment(entityID, id)
+}
+
+// IsHead reports whether the user heads the department or one of its parent departments
+func (s *DepartmentService) IsHead(entityID, id, userID int) (bool, error) {
+	var head bool
+	err := s.db.QueryRow(`
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, head_user_id FROM departments WHERE id = $1 AND healthcare_entity_id = $2
+			UNION
+			SELECT d.id, d.parent_id, d.head_user_id FROM departments d JOIN ancestors a ON d.id = a.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE head_user_id = $3)
+	`, id, entityID, userID).Scan(&head)
+	return head, err
+}
+
+// ListMembers returns the users of the department, and of its sub-departments when
+// recursive, who still belong to the entity
+func (s *DepartmentService) ListMembers(entityID, id int, recursive bool) ([]DepartmentMember, error) {
+	departmentIDs := []int{id}
+	if recursive {
+		var err error
+		if departmentIDs, err = s.SubtreeIDs(entityID, id); err != nil {
+			return nil, err
+		}
+	} else if _, err := s.GetDepartment(entityID, id); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT u.id, ud.department_id, u.first_name, u.last_name, em.role, COALESCE(u.specialization, ''),
+		       d.head_user_id IS NOT DISTINCT FROM u.id, ud.created_at
+		FROM user_departments ud
+		JOIN departments d ON d.id = ud.department_id
+		JOIN entity_members em ON em.user_id = ud.user_id AND em.healthcare_entity_id = d.healthcare_entity_id
+		JOIN users u ON u.id = ud.user_id AND u.is_active = true
+		WHERE d.healthcare_entity_id = $1 AND ud.department_id = ANY($2)
+		ORDER BY u.last_name, u.first_name, ud.department_id
+	`, entityID, pq.Array(departmentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []DepartmentMember{}
+	for rows.Next() {
+		var m DepartmentMember
+		if err := rows.Scan(&m.UserID, &m.DepartmentID, &m.FirstName, &m.LastName, &m.Role, &m.Specialization,
+			&m.IsHead, &m.AssignedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// AddMembers assigns users of the entity to an active department and returns how many were
+// not assigned already. Every user must belong to the entity.
+func (s *DepartmentService) AddMembers(entityID, id int, userIDs []int, assignedBy int) (int64, error) {
+	department, err := s.GetDepartment(entityID, id)
+	if err != nil {
+		return 0, err
+	}
+	if !department.IsActive {
+		return 0, ErrDepartmentInactive
+	}
+
+	var members int
+	err = s.db.QueryRow(`
+		SELECT COUNT(DISTINCT em.user_id) FROM entity_members em JOIN users u ON u.id = em.user_id
+		WHERE em.healthcare_entity_id = $1 AND em.user_id = ANY($2) AND u.is_active = true
+	`, entityID, pq.Array(userIDs)).Scan(&members)
+	if err != nil {
+		return 0, err
+	}
+	unique := map[int]bool{}
+	for _, userID := range userIDs {
+		unique[userID] = true
+	}
+	if members != len(unique) {
+		return 0, ErrNotEntityMember
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO user_departments (user_id, department_id, created_by)
+		SELECT DISTINCT unnest($1::int[]), $2::int, $3::int
+		ON CONFLICT (user_id, department_id) DO NOTHING
+	`, pq.Array(userIDs), id, assignedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RemoveMember unassigns a user from a department. A head removed from their department
+// stops heading it.
+func (s *DepartmentService) RemoveMember(entityID, id, userID int) error {
+	if _, err := s.GetDepartment(entityID, id); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM user_departments WHERE department_id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotDepartmentMember
+	}
+	_, err = tx.Exec(`
+		UPDATE departments SET head_user_id = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND head_user_id = $2
+	`, id, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UserDepartments returns the departments of the entity a user is assigned to
+func (s *DepartmentService) UserDepartments(entityID, userID int) ([]Department, error) {
+	rows, err := s.db.Query(`SELECT `+departmentColumns+departmentFrom+`
+		JOIN user_departments mine ON mine.department_id = d.id
+		WHERE d.healthcare_entity_id = $1 AND mine.user_id = $2
+		ORDER BY d.name`, entityID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	departments := []Department{}
+	for rows.Next() {
+		department, err := scanDepartment(rows)
+		if err != nil {
+			return nil, err
+		}
+		departments = append(departments, *department)
+	}
+	return departments, rows.Err()
+}
+
+// RemoveEntityAssignments unassigns a user from every department of an entity they no longer
+// belong to, and ends their headships there
+func (s *DepartmentService) RemoveEntityAssignments(entityID, userID int) error {
+	_, err := s.db.Exec(`
+		DELETE FROM user_departments
+		WHERE user_id = $2 AND department_id IN (SELECT id FROM departments WHERE healthcare_entity_id = $1)
+	`, entityID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		UPDATE departments SET head_user_id = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE healthcare_entity_id = $1 AND head_user_id = $2
+	`, entityID, userID)
+	return err
+}
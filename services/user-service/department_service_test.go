@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const departmentQuery = `FROM departments d LEFT JOIN users h ON h.id = d.head_user_id\s+WHERE d.id = \$1 AND d.healthcare_entity_id = \$2`
+
+// departmentRows returns a department of entity 1 under parentID (none when 0)
+func departmentRows(id, parentID int, active bool) *sqlmock.Rows {
+	now := time.Now()
+	var parent interface{}
+	if parentID != 0 {
+		parent = parentID
+	}
+	return sqlmock.NewRows([]string{"id", "healthcare_entity_id", "parent_id", "name", "description", "head_user_id",
+		"head_name", "is_active", "created_by", "created_at", "updated_at", "member_count"}).
+		AddRow(id, 1, parent, "Cardiology", "", nil, "", active, 2, now, now, 0)
+}
+
+func TestUpdateDepartmentParent(t *testing.T) {
+	tests := []struct {
+		name    string
+		expect  func(sqlmock.Sqlmock)
+		wantErr error
+	}{
+		{
+			name: "under one of its sub-departments",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(departmentQuery).WithArgs(5, 1).WillReturnRows(departmentRows(5, 3, true))
+				m.ExpectQuery(departmentQuery).WithArgs(3, 1).WillReturnRows(departmentRows(3, 0, true))
+				m.ExpectQuery(`WITH RECURSIVE subtree`).WithArgs(3).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(4).AddRow(5))
+			},
+			wantErr: ErrDepartmentCycle,
+		},
+		{
+			name: "under a deactivated department",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(departmentQuery).WithArgs(5, 1).WillReturnRows(departmentRows(5, 0, false))
+			},
+			wantErr: ErrDepartmentInactive,
+		},
+		{
+			name: "under a department of another entity",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(departmentQuery).WithArgs(5, 1).WillReturnRows(sqlmock.NewRows(nil))
+			},
+			wantErr: ErrParentNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(departmentQuery).WithArgs(3, 1).WillReturnRows(departmentRows(3, 0, true))
+			tt.expect(mock)
+
+			parentID := 5
+			_, err := NewDepartmentService(db).UpdateDepartment(1, 3, &DepartmentRequest{Name: "Cardiology", ParentID: &parentID}, 2)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("UpdateDepartment error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddMembersOutsideEntity(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(departmentQuery).WithArgs(3, 1).WillReturnRows(departmentRows(3, 0, true))
+	mock.ExpectQuery(`SELECT COUNT\(DISTINCT em.user_id\) FROM entity_members`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	if _, err := NewDepartmentService(db).AddMembers(1, 3, []int{7, 8, 7}, 2); !errors.Is(err, ErrNotEntityMember) {
+		t.Errorf("AddMembers error = %v, want ErrNotEntityMember", err)
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	t.Run("head stops heading the department", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(departmentQuery).WithArgs(3, 1).WillReturnRows(departmentRows(3, 0, true))
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM user_departments`).WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE departments SET head_user_id = NULL`).WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if err := NewDepartmentService(db).RemoveMember(1, 3, 7); err != nil {
+			t.Errorf("RemoveMember error = %v", err)
+		}
+	})
+	t.Run("not assigned", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(departmentQuery).WithArgs(3, 1).WillReturnRows(departmentRows(3, 0, true))
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM user_departments`).WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		if err := NewDepartmentService(db).RemoveMember(1, 3, 7); !errors.Is(err, ErrNotDepartmentMember) {
+			t.Errorf("RemoveMember error = %v, want ErrNotDepartmentMember", err)
+		}
+	})
+}
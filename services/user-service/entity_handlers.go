@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+	rbac "github.com/louhibi/healthcare-rbac"
+)
+
+// respondEntityError maps entity service errors to HTTP responses
+func respondEntityError(c *gin.Context, err error, operation string) {
+	switch {
+	case errors.Is(err, ErrEntityNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Healthcare entity not found"})
+	case errors.Is(err, ErrInvalidTimezone):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrEntityAlreadyActive):
+		c.JSON(http.StatusConflict, gin.H{"error": "Healthcare entity is already active"})
+	case errors.Is(err, ErrEntityInactive):
+		c.JSON(http.StatusConflict, gin.H{"error": "Healthcare entity is already deactivated"})
+	default:
+		logging.LogError("Entity operation failed", "operation", operation, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// entityID reads the :id route parameter of entity routes
+func entityID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entity ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// inviteEntityAdmin invites an admin to an entity on behalf of a platform super admin
+func (h *UserHandler) inviteEntityAdmin(entityID int, req EntityAdminRequest, invitedBy int) (*InvitationResponse, error) {
+	inv := &Invitation{
+		HealthcareEntityID: entityID,
+		Email:              req.Email,
+		Role:               "admin",
+		FirstName:          req.FirstName,
+		LastName:           req.LastName,
+		PreferredLocale:    req.PreferredLocale,
+		InvitedBy:          &invitedBy,
+	}
+	nonce, err := h.invitationService.CreateInvitation(inv)
+	if err != nil {
+		return nil, err
+	}
+	return h.invitationResponse(inv, nonce, "Invitation created successfully")
+}
+
+// GetEntities handles GET /api/platform/entities - every entity, filtered by
+// ?status=active|inactive
+func (h *UserHandler) GetEntities(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	status := c.Query("status")
+	if status != "" && status != "active" && status != "inactive" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or inactive"})
+		return
+	}
+
+	entities, err := h.entityService.ListEntities(status, limit, offset)
+	if err != nil {
+		respondEntityError(c, err, "get entities")
+		return
+	}
+	responses := make([]HealthcareEntityResponse, len(entities))
+	for i := range entities {
+		responses[i] = entities[i].ToHealthcareEntityResponse()
+	}
+	c.JSON(http.StatusOK, gin.H{"entities": responses, "limit": limit, "offset": offset})
+}
+
+// CreateEntity handles POST /api/platform/entities - creates an entity with the default form
+// configurations and invites its first admin
+func (h *UserHandler) CreateEntity(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	var req CreateEntityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// The admin is checked first so that an entity isn't left without one for a known reason
+	if exists, err := h.userService.EmailExists(req.Admin.Email); err != nil {
+		respondEntityError(c, err, "create entity")
+		return
+	} else if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "The admin's email already has an account, add them as a member instead"})
+		return
+	}
+
+	entity, err := h.entityService.CreateEntity(&req.Entity, userClaims.UserID)
+	if err != nil {
+		respondEntityError(c, err, "create entity")
+		return
+	}
+	logging.LogInfo("Healthcare entity created", "healthcare_entity_id", entity.ID, "created_by", userClaims.UserID)
+
+	response := CreateEntityResponse{Entity: entity.ToHealthcareEntityResponse()}
+	invitation, err := h.inviteEntityAdmin(entity.ID, req.Admin, userClaims.UserID)
+	if err != nil {
+		logging.LogError("Failed to invite entity admin", "error", err, "healthcare_entity_id", entity.ID)
+		response.AdminInvitationError = err.Error()
+	} else {
+		response.AdminInvitation = invitation
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// InviteEntityAdmin handles POST /api/platform/entities/:id/admins - invites an admin to any
+// entity, e.g. when the first admin's invitation expired
+func (h *UserHandler) InviteEntityAdmin(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := entityID(c)
+	if !ok {
+		return
+	}
+
+	var req EntityAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.entityService.GetEntity(id); err != nil {
+		respondEntityError(c, err, "invite admin")
+		return
+	}
+
+	response, err := h.inviteEntityAdmin(id, req, userClaims.UserID)
+	if err != nil {
+		respondInvitationError(c, err, "invite admin")
+		return
+	}
+
+	logging.LogInfo("Entity admin invited", "invitation_id", response.Invitation.ID, "healthcare_entity_id", id,
+		"invited_by", userClaims.UserID)
+	c.JSON(http.StatusCreated, response)
+}
+
+// UpdateEntity handles PUT /api/entities/:id - replaces the details and settings of the
+// caller's entity, or of any entity for platform super admins
+func (h *UserHandler) UpdateEntity(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := entityID(c)
+	if !ok {
+		return
+	}
+	if id != userClaims.HealthcareEntityID && !rbac.Has(c, rbac.PlatformManage) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this healthcare entity"})
+		return
+	}
+
+	var req HealthcareEntityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entity, err := h.entityService.UpdateEntity(id, &req)
+	if err != nil {
+		respondEntityError(c, err, "update entity")
+		return
+	}
+
+	logging.LogInfo("Healthcare entity updated", "healthcare_entity_id", id, "updated_by", userClaims.UserID)
+	c.JSON(http.StatusOK, entity.ToHealthcareEntityResponse())
+}
+
+// DeactivateEntity handles POST /api/platform/entities/:id/deactivate - closes an entity and
+// logs its users out
+func (h *UserHandler) DeactivateEntity(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := entityID(c)
+	if !ok {
+		return
+	}
+	if id == userClaims.HealthcareEntityID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't deactivate the entity you are signed in to"})
+		return
+	}
+
+	entity, revoked, err := h.entityService.DeactivateEntity(id, userClaims.UserID)
+	if err != nil {
+		respondEntityError(c, err, "deactivate entity")
+		return
+	}
+
+	logging.LogInfo("Healthcare entity deactivated", "healthcare_entity_id", id, "deactivated_by", userClaims.UserID,
+		"sessions_revoked", revoked)
+	c.JSON(http.StatusOK, gin.H{"entity": entity.ToHealthcareEntityResponse(), "sessions_revoked": revoked})
+}
+
+// ReactivateEntity handles POST /api/platform/entities/:id/reactivate - opens a deactivated
+// entity again
+func (h *UserHandler) ReactivateEntity(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := entityID(c)
+	if !ok {
+		return
+	}
+
+	entity, err := h.entityService.ReactivateEntity(id)
+	if err != nil {
+		respondEntityError(c, err, "reactivate entity")
+		return
+	}
+
+	logging.LogInfo("Healthcare entity reactivated", "healthcare_entity_id", id, "reactivated_by", userClaims.UserID)
+	c.JSON(http.StatusOK, entity.ToHealthcareEntityResponse())
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // The runtime image has no zoneinfo database
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrEntityNotFound      = errors.New("healthcare entity not found")
+	ErrInvalidTimezone     = errors.New("timezone must be an IANA time zone such as America/Toronto")
+	ErrEntityAlreadyActive = errors.New("healthcare entity is already active")
+	ErrEntityInactive      = errors.New("healthcare entity is already deactivated")
+)
+
+// EntityService manages the lifecycle of healthcare entities
+type EntityService struct {
+	db                *sql.DB
+	formConfigService *FormConfigService
+}
+
+func NewEntityService(db *sql.DB, formConfigService *FormConfigService) *EntityService {
+	return &EntityService{db: db, formConfigService: formConfigService}
+}
+
+// Country, state and city names predate the location service and are empty for entities
+// created with location IDs only
+const entityColumns = `id, name, type, country_id, address, state_id, city_id, COALESCE(country, ''),
+	COALESCE(state, ''), COALESCE(city, ''), postal_code, phone, email, COALESCE(website, ''),
+	COALESCE(license, ''), COALESCE(tax_id, ''), timezone, locale, currency,
+	COALESCE(require_room_assignment, false), is_active, created_at, updated_at`
+
+func scanHealthcareEntity(row rowScanner) (*HealthcareEntity, error) {
+	entity := &HealthcareEntity{}
+	var countryID, stateID, cityID sql.NullInt64
+	err := row.Scan(&entity.ID, &entity.Name, &entity.Type, &countryID, &entity.Address, &stateID, &cityID,
+		&entity.Country, &entity.State, &entity.City, &entity.PostalCode, &entity.Phone, &entity.Email,
+		&entity.Website, &entity.License, &entity.TaxID, &entity.Timezone,
+		&entity.Language, // Maps to locale in DB
+		&entity.Currency, &entity.RequireRoomAssignment, &entity.IsActive, &entity.CreatedAt, &entity.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	entity.CountryID = nullIntPtr(countryID)
+	entity.StateID = nullIntPtr(stateID)
+	entity.CityID = nullIntPtr(cityID)
+	return entity, nil
+}
+
+// validateTimezone checks the timezone is an IANA zone name
+func validateTimezone(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "Local" {
+		return ErrInvalidTimezone
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return ErrInvalidTimezone
+	}
+	return nil
+}
+
+// GetEntity returns a healthcare entity, active or not
+func (s *EntityService) GetEntity(id int) (*HealthcareEntity, error) {
+	entity, err := scanHealthcareEntity(s.db.QueryRow(`SELECT `+entityColumns+` FROM healthcare_entities WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrEntityNotFound
+	}
+	return entity, err
+}
+
+// ListEntities returns entities by name; status is "active", "inactive" or empty for all
+func (s *EntityService) ListEntities(status string, limit, offset int) ([]HealthcareEntity, error) {
+	query := `SELECT ` + entityColumns + ` FROM healthcare_entities`
+	switch status {
+	case "active":
+		query += ` WHERE is_active = true`
+	case "inactive":
+		query += ` WHERE is_active = false`
+	}
+	rows, err := s.db.Query(query+` ORDER BY name, id LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entities := []HealthcareEntity{}
+	for rows.Next() {
+		entity, err := scanHealthcareEntity(rows)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, *entity)
+	}
+	return entities, rows.Err()
+}
+
+// CreateEntity adds an active entity with the default form configurations
+func (s *EntityService) CreateEntity(req *HealthcareEntityRequest, createdBy int) (*HealthcareEntity, error) {
+	req.Timezone = strings.TrimSpace(req.Timezone)
+	if err := validateTimezone(req.Timezone); err != nil {
+		return nil, err
+	}
+	var id int
+	err := s.db.QueryRow(`
+		INSERT INTO healthcare_entities (name, type, country_id, state_id, city_id, address, postal_code, phone,
+			email, website, license, tax_id, timezone, locale, currency, require_room_assignment, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id
+	`, req.Name, req.Type, req.CountryID, req.StateID, req.CityID, req.Address, req.PostalCode, req.Phone,
+		req.Email, req.Website, req.License, req.TaxID, req.Timezone, req.Language, req.Currency,
+		req.RequireRoomAssignment, createdBy).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create entity: %v", err)
+	}
+
+	// Forms of an entity without configurations have no fields, so the entity is only kept
+	// once they exist
+	if err := s.formConfigService.CreateDefaultFieldConfigurations(id); err != nil {
+		if _, delErr := s.db.Exec(`DELETE FROM healthcare_entities WHERE id = $1`, id); delErr != nil {
+			return nil, fmt.Errorf("%v (and failed to remove the entity: %v)", err, delErr)
+		}
+		return nil, err
+	}
+	return s.GetEntity(id)
+}
+
+// UpdateEntity replaces the details and settings of an entity
+func (s *EntityService) UpdateEntity(id int, req *HealthcareEntityRequest) (*HealthcareEntity, error) {
+	req.Timezone = strings.TrimSpace(req.Timezone)
+	if err := validateTimezone(req.Timezone); err != nil {
+		return nil, err
+	}
+	result, err := s.db.Exec(`
+		UPDATE healthcare_entities
+		SET name = $2, type = $3, country_id = $4, state_id = $5, city_id = $6, address = $7, postal_code = $8,
+		    phone = $9, email = $10, website = $11, license = $12, tax_id = $13, timezone = $14, locale = $15,
+		    currency = $16, require_room_assignment = $17, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id, req.Name, req.Type, req.CountryID, req.StateID, req.CityID, req.Address, req.PostalCode, req.Phone,
+		req.Email, req.Website, req.License, req.TaxID, req.Timezone, req.Language, req.Currency,
+		req.RequireRoomAssignment)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrEntityNotFound
+	}
+	return s.GetEntity(id)
+}
+
+// DeactivateEntity closes an entity: its users can no longer log in and every session scoped
+// to it ends. Returns the number of sessions ended.
+func (s *EntityService) DeactivateEntity(id, deactivatedBy int) (*HealthcareEntity, int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	var active bool
+	if err := tx.QueryRow(`SELECT is_active FROM healthcare_entities WHERE id = $1 FOR UPDATE`, id).Scan(&active); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, 0, ErrEntityNotFound
+		}
+		return nil, 0, err
+	}
+	if !active {
+		return nil, 0, ErrEntityInactive
+	}
+	_, err = tx.Exec(`
+		UPDATE healthcare_entities
+		SET is_active = false, deactivated_at = CURRENT_TIMESTAMP, deactivated_by = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id, deactivatedBy)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Sessions of the entity's users, and of members of other entities switched to it
+	result, err := tx.Exec(`
+		UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $2, revoke_reason = 'entity_deactivated'
+		WHERE revoked_at IS NULL
+		  AND (healthcare_entity_id = $1 OR user_id IN (SELECT id FROM users WHERE healthcare_entity_id = $1))
+	`, id, deactivatedBy)
+	if err != nil {
+		return nil, 0, err
+	}
+	revoked, _ := result.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	entity, err := s.GetEntity(id)
+	return entity, revoked, err
+}
+
+// ReactivateEntity opens a deactivated entity again
+func (s *EntityService) ReactivateEntity(id int) (*HealthcareEntity, error) {
+	entity, err := s.GetEntity(id)
+	if err != nil {
+		return nil, err
+	}
+	if entity.IsActive {
+		return nil, ErrEntityAlreadyActive
+	}
+	_, err = s.db.Exec(`
+		UPDATE healthcare_entities
+		SET is_active = true, deactivated_at = NULL, deactivated_by = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetEntity(id)
+}
+
+// SyncSuperAdmins makes the listed users super admins and the other super admins entity
+// admins again, so that the SUPER_ADMIN_EMAILS setting decides who operates the platform
+func (s *EntityService) SyncSuperAdmins(emails []string) (promoted, demoted int64, err error) {
+	normalized := []string{}
+	for _, email := range emails {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			normalized = append(normalized, email)
+		}
+	}
+	result, err := s.db.Exec(`
+		UPDATE users SET role = 'super_admin', custom_role_id = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE LOWER(email) = ANY($1) AND role <> 'super_admin'
+	`, pq.Array(normalized))
+	if err != nil {
+		return 0, 0, err
+	}
+	promoted, _ = result.RowsAffected()
+	result, err = s.db.Exec(`
+		UPDATE users SET role = 'admin', updated_at = CURRENT_TIMESTAMP
+		WHERE role = 'super_admin' AND NOT (LOWER(email) = ANY($1))
+	`, pq.Array(normalized))
+	if err != nil {
+		return promoted, 0, err
+	}
+	demoted, _ = result.RowsAffected()
+	return promoted, demoted, nil
+}
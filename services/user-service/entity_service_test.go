@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// entityRows returns entity 4 with the given status
+func entityRows(active bool) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "name", "type", "country_id", "address", "state_id", "city_id", "country",
+		"state", "city", "postal_code", "phone", "email", "website", "license", "tax_id", "timezone", "locale",
+		"currency", "require_room_assignment", "is_active", "created_at", "updated_at"}).
+		AddRow(4, "Clinique Atlas", "clinic", 1, "12 Rue Allal", nil, nil, "", "", "", "20000", "+212522000000",
+			"contact@atlas.ma", "", "", "", "Africa/Casablanca", "fr-FR", "MAD", false, active, now, now)
+}
+
+func TestDeactivateEntityEndsSessions(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT is_active FROM healthcare_entities WHERE id = \$1 FOR UPDATE`).WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"is_active"}).AddRow(true))
+	mock.ExpectExec(`UPDATE healthcare_entities\s+SET is_active = false`).WithArgs(4, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP.*'entity_deactivated'`).WithArgs(4, 1).
+		WillReturnResult(sqlmock.NewResult(0, 6))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`FROM healthcare_entities WHERE id = \$1`).WithArgs(4).WillReturnRows(entityRows(false))
+
+	entity, revoked, err := NewEntityService(db, nil).DeactivateEntity(4, 1)
+	if err != nil {
+		t.Fatalf("DeactivateEntity error = %v", err)
+	}
+	if entity.IsActive || revoked != 6 {
+		t.Errorf("entity active %v with %d sessions ended, want inactive with 6", entity.IsActive, revoked)
+	}
+}
+
+func TestEntityLifecycleRefusals(t *testing.T) {
+	t.Run("deactivate twice", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT is_active FROM healthcare_entities`).WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"is_active"}).AddRow(false))
+		mock.ExpectRollback()
+
+		if _, _, err := NewEntityService(db, nil).DeactivateEntity(4, 1); !errors.Is(err, ErrEntityInactive) {
+			t.Errorf("DeactivateEntity error = %v, want ErrEntityInactive", err)
+		}
+	})
+	t.Run("reactivate an active entity", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`FROM healthcare_entities WHERE id = \$1`).WithArgs(4).WillReturnRows(entityRows(true))
+
+		if _, err := NewEntityService(db, nil).ReactivateEntity(4); !errors.Is(err, ErrEntityAlreadyActive) {
+			t.Errorf("ReactivateEntity error = %v, want ErrEntityAlreadyActive", err)
+		}
+	})
+	t.Run("unknown entity", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT is_active FROM healthcare_entities`).WithArgs(9).WillReturnRows(sqlmock.NewRows(nil))
+		mock.ExpectRollback()
+
+		if _, _, err := NewEntityService(db, nil).DeactivateEntity(9, 1); !errors.Is(err, ErrEntityNotFound) {
+			t.Errorf("DeactivateEntity error = %v, want ErrEntityNotFound", err)
+		}
+	})
+}
+
+func TestValidateTimezone(t *testing.T) {
+	for _, name := range []string{"Africa/Casablanca", "America/Toronto", "UTC"} {
+		if err := validateTimezone(name); err != nil {
+			t.Errorf("validateTimezone(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Local", "GMT+1", "Mars/Olympus"} {
+		if err := validateTimezone(name); !errors.Is(err, ErrInvalidTimezone) {
+			t.Errorf("validateTimezone(%q) = %v, want ErrInvalidTimezone", name, err)
+		}
+	}
+}
@@ -9,23 +9,6 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// AdminMiddleware checks if user has admin role
-func (s *UserService) AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userRole := c.GetHeader("X-User-Role")
-		if userRole != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error":     "Access denied",
-				"message":   "Only administrators can manage form configurations",
-				"timestamp": time.Now().UTC(),
-			})
-			c.Abort()
-			return
-		}
-		c.Next()
-	}
-}
-
 // GetFormMetadata returns the complete form configuration for a healthcare entity
 func (s *UserService) GetFormMetadata(c *gin.Context) {
 	formType := c.Param("formType")
@@ -3,12 +3,14 @@ module user-service
 go 1.21
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/gin-gonic/gin v1.9.1
 	github.com/go-playground/validator/v10 v10.16.0
 	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
 	github.com/louhibi/healthcare-logging v0.1.0
+	github.com/louhibi/healthcare-rbac v0.1.0
 	golang.org/x/crypto v0.17.0
 )
 
@@ -36,3 +38,5 @@ require (
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/louhibi/healthcare-rbac => ../pkg/rbac
@@ -1,28 +1,143 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	logging "github.com/louhibi/healthcare-logging"
+	rbac "github.com/louhibi/healthcare-rbac"
 )
 
 type UserHandler struct {
-	userService *UserService
-	authService *AuthService
-	validator   *validator.Validate
+	userService       *UserService
+	authService       *AuthService
+	sessionService    *SessionService
+	mfaService        *MFAService
+	invitationService *InvitationService
+	passwordService   *PasswordService
+	loginGuard        *LoginGuard
+	securityEvents    *SecurityEventLog
+	roleService       *RoleService
+	membershipService *MembershipService
+	entityService     *EntityService
+	departmentService *DepartmentService
+	apiKeyService     *APIKeyService
+	serviceAccounts   *ServiceAccountService
+	configClient      *ConfigClient
+	validator         *validator.Validate
 }
 
-func NewUserHandler(userService *UserService, authService *AuthService) *UserHandler {
+func NewUserHandler(userService *UserService, authService *AuthService, sessionService *SessionService, mfaService *MFAService,
+	invitationService *InvitationService, passwordService *PasswordService, loginGuard *LoginGuard,
+	securityEvents *SecurityEventLog, roleService *RoleService, membershipService *MembershipService,
+	entityService *EntityService, departmentService *DepartmentService, apiKeyService *APIKeyService,
+	serviceAccounts *ServiceAccountService, configClient *ConfigClient) *UserHandler {
 	return &UserHandler{
-		userService: userService,
-		authService: authService,
-		validator:   validator.New(),
+		userService:       userService,
+		authService:       authService,
+		sessionService:    sessionService,
+		mfaService:        mfaService,
+		invitationService: invitationService,
+		passwordService:   passwordService,
+		loginGuard:        loginGuard,
+		securityEvents:    securityEvents,
+		roleService:       roleService,
+		membershipService: membershipService,
+		entityService:     entityService,
+		departmentService: departmentService,
+		apiKeyService:     apiKeyService,
+		serviceAccounts:   serviceAccounts,
+		configClient:      configClient,
+		validator:         validator.New(),
 	}
 }
 
+// authResponse builds the token response for a session of the user
+func (h *UserHandler) authResponse(user *User, session *UserSession, refreshToken string) (*AuthResponse, error) {
+	permissions, err := h.roleService.UserPermissions(user)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := h.authService.GenerateAccessToken(user, session, permissions.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	// Lets clients warn before the password expires
+	passwordExpiresAt, err := h.passwordService.PasswordExpiresAt(user)
+	if err != nil {
+		logging.LogError("Failed to get password expiry", "error", err, "user_id", user.ID)
+	}
+	return &AuthResponse{
+		User:              user.ToUserResponse(),
+		AccessToken:       accessToken,
+		RefreshToken:      refreshToken,
+		ExpiresIn:         900, // 15 minutes
+		PasswordExpiresAt: passwordExpiresAt,
+		Permissions:       permissions.Permissions,
+	}, nil
+}
+
+// startSession opens a session for a user who just authenticated and returns its tokens
+func (h *UserHandler) startSession(c *gin.Context, user *User, mfaVerified bool) (*AuthResponse, error) {
+	session, refreshToken, err := h.sessionService.CreateSession(user, c.Request.UserAgent(), c.ClientIP(), mfaVerified)
+	if err != nil {
+		return nil, err
+	}
+	return h.authResponse(user, session, refreshToken)
+}
+
+// scopeLogin scopes a user who is logging in to the entity their session starts in. On failure
+// it writes the response: 403 ENTITY_INACTIVE when every entity of the user is deactivated.
+func (h *UserHandler) scopeLogin(c *gin.Context, user *User) (*User, bool) {
+	scoped, err := h.membershipService.ScopeLogin(user)
+	if errors.Is(err, ErrEntityDeactivated) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Your healthcare entity is deactivated",
+			"code":  "ENTITY_INACTIVE",
+		})
+		return nil, false
+	}
+	if err != nil {
+		logging.LogError("Failed to scope login", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return nil, false
+	}
+	return scoped, true
+}
+
+// mfaChallenge returns the MFA challenge a password-checked login continues with, or nil when
+// the user has no second factor and the policy doesn't require one
+func (h *UserHandler) mfaChallenge(user *User) (*MFAChallengeResponse, error) {
+	enabled, err := h.mfaService.IsEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	purpose := "verify"
+	if !enabled {
+		required, err := h.mfaService.IsRequired(user)
+		if err != nil || !required {
+			return nil, err
+		}
+		purpose = "enroll"
+	}
+
+	token, err := h.authService.GenerateMFAChallenge(user, purpose)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAChallengeResponse{
+		MFARequired:           enabled,
+		MFAEnrollmentRequired: !enabled,
+		MFAToken:              token,
+		ExpiresIn:             int64(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
 // Register handles user registration
 func (h *UserHandler) Register(c *gin.Context) {
 	var req UserRegistration
@@ -36,6 +151,32 @@ func (h *UserHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// Self-registration is switched on and limited in config-service; staff otherwise join
+	// through invitations
+	policy, err := h.configClient.GetRegistrationPolicy()
+	if err != nil {
+		logging.LogError("Failed to get registration policy", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Registration is temporarily unavailable"})
+		return
+	}
+	if !policy.Enabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Public registration is disabled, ask an administrator for an invitation"})
+		return
+	}
+	if !policy.AllowsRole(req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This role can only join through an invitation"})
+		return
+	}
+	if !policy.AllowsEmail(req.Email) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This email domain can only join through an invitation"})
+		return
+	}
+	entity, err := h.userService.GetHealthcareEntityByID(req.HealthcareEntityID)
+	if err != nil || !entity.IsActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid healthcare entity"})
+		return
+	}
+
 	// Check if email already exists
 	exists, err := h.userService.EmailExists(req.Email)
 	if err != nil {
@@ -47,6 +188,12 @@ func (h *UserHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// Check the password against the entity's policy
+	if err := h.passwordService.ValidateNewPassword(&User{HealthcareEntityID: req.HealthcareEntityID}, req.Password); err != nil {
+		respondPasswordError(c, err, "check password")
+		return
+	}
+
 	// Hash password
 	hashedPassword, err := h.authService.HashPassword(req.Password)
 	if err != nil {
@@ -56,11 +203,15 @@ func (h *UserHandler) Register(c *gin.Context) {
 
 	// Create user
 	user := &User{
-		Email:     req.Email,
-		Password:  hashedPassword,
-		FirstName: req.FirstName,
-		LastName:  req.LastName,
-		Role:      req.Role,
+		Email:              req.Email,
+		Password:           hashedPassword,
+		FirstName:          req.FirstName,
+		LastName:           req.LastName,
+		Role:               req.Role,
+		HealthcareEntityID: req.HealthcareEntityID,
+		LicenseNumber:      req.LicenseNumber,
+		Specialization:     req.Specialization,
+		PreferredLocale:    req.PreferredLocale,
 	}
 
 	if err := h.userService.CreateUser(user); err != nil {
@@ -68,18 +219,24 @@ func (h *UserHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate tokens
-	accessToken, refreshToken, err := h.authService.GenerateTokens(user)
+	// Users whose role must use MFA enrol before getting tokens
+	challenge, err := h.mfaChallenge(user)
 	if err != nil {
+		logging.LogError("Failed to check MFA", "error", err, "user_id", user.ID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
 		return
 	}
+	if challenge != nil {
+		c.JSON(http.StatusCreated, challenge)
+		return
+	}
 
-	response := AuthResponse{
-		User:         user.ToUserResponse(),
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    900, // 15 minutes
+	// Start a session
+	response, err := h.startSession(c, user, false)
+	if err != nil {
+		logging.LogError("Failed to start session", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
 	}
 
 	c.JSON(http.StatusCreated, response)
@@ -98,31 +255,78 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Get user by email
-	user, err := h.userService.GetUserByEmail(req.Email)
+	// Locked or throttled emails and IPs are refused before the password is checked
+	retryAfter, err := h.loginGuard.Check(req.Email, c.ClientIP())
+	if errors.Is(err, ErrLoginBlocked) {
+		respondLoginBlocked(c, retryAfter)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		logging.LogError("Failed to check login attempts", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
 		return
 	}
 
+	// Get user by email. Unknown emails, and service accounts which only authenticate with API
+	// keys, are checked against a dummy hash and counted like wrong passwords, so the response
+	// doesn't tell whether the account exists.
+	user, err := h.userService.GetUserByEmail(req.Email)
+	passwordHash := string(dummyPasswordHash)
+	if err == nil && user.Role != ServiceAccountRole {
+		passwordHash = user.Password
+	} else {
+		user = nil
+	}
+
 	// Check password
-	if !h.authService.CheckPassword(req.Password, user.Password) {
+	if !h.authService.CheckPassword(req.Password, passwordHash) || user == nil {
+		if err := h.loginGuard.RecordFailure(req.Email, c.ClientIP(), user); err != nil {
+			logging.LogError("Failed to record login failure", "error", err)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	if err := h.loginGuard.RecordSuccess(req.Email); err != nil {
+		logging.LogError("Failed to clear login failures", "error", err, "user_id", user.ID)
+	}
+	user, ok := h.scopeLogin(c, user)
+	if !ok {
+		return
+	}
 
-	// Generate tokens
-	accessToken, refreshToken, err := h.authService.GenerateTokens(user)
+	// Passwords older than the entity's maximum age have to be reset first
+	expired, err := h.passwordService.IsExpired(user)
 	if err != nil {
+		logging.LogError("Failed to check password expiry", "error", err, "user_id", user.ID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
 		return
 	}
+	if expired {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Password expired, reset it to log in",
+			"code":  "PASSWORD_EXPIRED",
+		})
+		return
+	}
 
-	response := AuthResponse{
-		User:         user.ToUserResponse(),
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    900, // 15 minutes
+	// Users with MFA, or whose role must use it, continue with an MFA challenge
+	challenge, err := h.mfaChallenge(user)
+	if err != nil {
+		logging.LogError("Failed to check MFA", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	// Start a session
+	response, err := h.startSession(c, user, false)
+	if err != nil {
+		logging.LogError("Failed to start session", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -141,34 +345,69 @@ func (h *UserHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	// Validate refresh token
-	claims, err := h.authService.ValidateRefreshToken(req.RefreshToken)
+	// Exchange the refresh token for a new one of the same session
+	session, refreshToken, err := h.sessionService.Rotate(req.RefreshToken, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		logging.LogError("Failed to refresh session", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
 		return
 	}
 
-	// Get user
-	user, err := h.userService.GetUserByID(claims.UserID)
+	// Get user; sessions of deactivated users end here
+	user, err := h.userService.GetUserByID(session.UserID)
 	if err != nil {
+		if err := h.sessionService.RevokeSession(session.UserID, session.ID, session.UserID, "user_inactive"); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			logging.LogError("Failed to revoke session", "error", err, "session_id", session.ID)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
 	}
 
-	// Generate new tokens
-	accessToken, refreshToken, err := h.authService.GenerateTokens(user)
+	// Sessions end when the membership of their entity does or the entity is deactivated
+	if session.HealthcareEntityID != 0 {
+		user, err = h.membershipService.ScopeUser(user, session.HealthcareEntityID)
+		if err != nil {
+			reason, message := "membership_ended", "No longer a member of this entity, please log in again"
+			if errors.Is(err, ErrEntityDeactivated) {
+				reason, message = "entity_deactivated", "This healthcare entity is deactivated, please log in again"
+			} else if !errors.Is(err, ErrMembershipNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+				return
+			}
+			if err := h.sessionService.RevokeSession(session.UserID, session.ID, session.UserID, reason); err != nil && !errors.Is(err, ErrSessionNotFound) {
+				logging.LogError("Failed to revoke session", "error", err, "session_id", session.ID)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+			return
+		}
+	}
+
+	// Sessions opened without a second factor end once the policy requires one
+	if !session.MFAVerified {
+		required, err := h.mfaService.IsRequired(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+			return
+		}
+		if required {
+			if err := h.sessionService.RevokeSession(user.ID, session.ID, user.ID, "mfa_required"); err != nil {
+				logging.LogError("Failed to revoke session", "error", err, "session_id", session.ID)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Multi-factor authentication required, please log in again"})
+			return
+		}
+	}
+
+	response, err := h.authResponse(user, session, refreshToken)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
 		return
 	}
 
-	response := AuthResponse{
-		User:         user.ToUserResponse(),
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    900, // 15 minutes
-	}
-
 	c.JSON(http.StatusOK, response)
 }
 
@@ -235,20 +474,13 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, user.ToUserResponse())
 }
 
-// GetUsers gets all users (admin only)
+// GetUsers gets all users (requires user.read)
 func (h *UserHandler) GetUsers(c *gin.Context) {
-	claims, exists := c.Get("claims")
-	if !exists {
+	if _, exists := c.Get("claims"); !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	userClaims := claims.(*Claims)
-	if userClaims.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-		return
-	}
-
 	// Parse pagination parameters
 	limitStr := c.DefaultQuery("limit", "10")
 	offsetStr := c.DefaultQuery("offset", "0")
@@ -297,7 +529,12 @@ func (h *UserHandler) GetDoctors(c *gin.Context) {
 		}
 	}
 
-	doctors, err := h.userService.GetDoctorsByEntity(healthcareEntityID)
+	departmentID, ok := h.departmentFilter(c, healthcareEntityID)
+	if !ok {
+		return
+	}
+
+	doctors, err := h.userService.GetDoctorsByEntity(healthcareEntityID, departmentID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get doctors", "details": err.Error()})
 		return
@@ -321,6 +558,58 @@ func (h *UserHandler) GetDoctors(c *gin.Context) {
 	})
 }
 
+// maxUserLookupIDs caps the number of users per by-ids request
+const maxUserLookupIDs = 200
+
+// GetUsersByIDs handles GET /api/users/by-ids?ids=1,2,3 - names of users of the caller's
+// healthcare entity, used by other services to show who performed an action
+func (h *UserHandler) GetUsersByIDs(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userClaims := claims.(*Claims)
+
+	var ids []int
+	for _, idStr := range strings.Split(c.Query("ids"), ",") {
+		if strings.TrimSpace(idStr) == "" {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(idStr))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format: " + idStr})
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 || len(ids) > maxUserLookupIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Between 1 and %d IDs are required", maxUserLookupIDs)})
+		return
+	}
+
+	users, err := h.userService.GetUsersByIDs(userClaims.HealthcareEntityID, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users", "details": err.Error()})
+		return
+	}
+
+	userResponses := []gin.H{}
+	for _, user := range users {
+		userResponses = append(userResponses, gin.H{
+			"id":         user.ID,
+			"first_name": user.FirstName,
+			"last_name":  user.LastName,
+			"role":       user.Role,
+			"is_active":  user.IsActive,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": userResponses,
+	})
+}
+
 // GetEntityComplete gets complete information for a healthcare entity
 func (h *UserHandler) GetEntityComplete(c *gin.Context) {
 	entityIDStr := c.Param("id")
@@ -390,13 +679,15 @@ func AuthMiddleware(authService *AuthService) gin.HandlerFunc {
 			return
 		}
 
-		// Set claims in context
+		// Set claims in context; route permission checks read the token's permissions
 		c.Set("claims", claims)
+		c.Set(rbac.ContextKey, claims.Permissions)
 		c.Next()
 	}
 }
 
-// AdminCreateDoctor handles POST /api/admin/doctors - Admin creates a doctor with temp password
+// AdminCreateDoctor handles POST /api/admin/doctors - Admin invites a doctor. This is an
+// invitation with the doctor role: the doctor sets a password when accepting it.
 func (h *UserHandler) AdminCreateDoctor(c *gin.Context) {
 	var req AdminCreateDoctorRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -409,7 +700,6 @@ func (h *UserHandler) AdminCreateDoctor(c *gin.Context) {
 		return
 	}
 
-	// Check authorization - only admins can create doctors
 	claims, exists := c.Get("claims")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
@@ -417,30 +707,16 @@ func (h *UserHandler) AdminCreateDoctor(c *gin.Context) {
 	}
 	
 	userClaims := claims.(*Claims)
-	if userClaims.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-		return
-	}
 
-	// Check if email already exists
-	exists, err := h.userService.EmailExists(req.Email)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-		return
-	}
-	if exists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
-		return
-	}
-
-	// Create doctor with temporary password
-	response, err := h.userService.CreateDoctorWithTempPassword(req, userClaims.HealthcareEntityID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusCreated, response)
+	h.createInvitation(c, userClaims, InvitationRequest{
+		Email:           req.Email,
+		Role:            "doctor",
+		FirstName:       req.FirstName,
+		LastName:        req.LastName,
+		LicenseNumber:   req.LicenseNumber,
+		Specialization:  req.Specialization,
+		PreferredLocale: req.PreferredLocale,
+	})
 }
 
 // ChangePassword handles POST /api/auth/change-password - Change user password
@@ -465,17 +741,23 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 	
 	userClaims := claims.(*Claims)
 
-	// Change password
-	err := h.userService.ChangePassword(userClaims.UserID, req.CurrentPassword, req.NewPassword)
+	user, err := h.userService.GetUserByID(userClaims.UserID)
 	if err != nil {
-		if err.Error() == "invalid current password" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
+	// Change password, enforcing the entity's policy
+	if err := h.passwordService.ChangePassword(user, req.CurrentPassword, req.NewPassword); err != nil {
+		respondPasswordError(c, err, "change password")
+		return
+	}
+
+	// Other devices have to log in again with the new password
+	if _, err := h.sessionService.RevokeUserSessions(userClaims.UserID, userClaims.SessionID, userClaims.UserID, "password_change"); err != nil {
+		logging.LogError("Failed to revoke sessions after password change", "error", err, "user_id", userClaims.UserID)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Password changed successfully",
 		"timestamp": "now",
@@ -484,7 +766,6 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 
 // AdminGetDoctors handles GET /api/admin/doctors - Admin lists doctors
 func (h *UserHandler) AdminGetDoctors(c *gin.Context) {
-	// Check authorization - only admins can list doctors
 	claims, exists := c.Get("claims")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
@@ -492,13 +773,14 @@ func (h *UserHandler) AdminGetDoctors(c *gin.Context) {
 	}
 
 	userClaims := claims.(*Claims)
-	if userClaims.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+
+	departmentID, ok := h.departmentFilter(c, userClaims.HealthcareEntityID)
+	if !ok {
 		return
 	}
 
-	// Get all doctors for the healthcare entity
-	doctors, err := h.userService.GetDoctorsByEntity(userClaims.HealthcareEntityID)
+	// Get all doctors for the healthcare entity, or of one of its departments
+	doctors, err := h.userService.GetDoctorsByEntity(userClaims.HealthcareEntityID, departmentID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
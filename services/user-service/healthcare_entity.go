@@ -53,6 +53,28 @@ type HealthcareEntityRequest struct {
 	RequireRoomAssignment bool   `json:"require_room_assignment"`
 }
 
+// EntityAdminRequest names the admin invited to an entity by a platform super admin
+type EntityAdminRequest struct {
+	Email           string `json:"email" validate:"required,email"`
+	FirstName       string `json:"first_name"`
+	LastName        string `json:"last_name"`
+	PreferredLocale string `json:"preferred_locale" validate:"omitempty,oneof=en-US en-CA fr-CA fr-FR ar-MA"`
+}
+
+// CreateEntityRequest creates an entity together with the invitation of its first admin
+type CreateEntityRequest struct {
+	Entity HealthcareEntityRequest `json:"entity"`
+	Admin  EntityAdminRequest      `json:"admin"`
+}
+
+// CreateEntityResponse is the created entity and the invitation of its first admin. When the
+// invitation failed, AdminInvitationError says why and the admin can be invited again.
+type CreateEntityResponse struct {
+	Entity               HealthcareEntityResponse `json:"entity"`
+	AdminInvitation      *InvitationResponse      `json:"admin_invitation,omitempty"`
+	AdminInvitationError string                   `json:"admin_invitation_error,omitempty"`
+}
+
 // HealthcareEntityResponse represents entity data returned to client
 type HealthcareEntityResponse struct {
 	ID         int       `json:"id"`
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// respondInvitationError maps invitation service errors to HTTP responses
+func respondInvitationError(c *gin.Context, err error, operation string) {
+	switch {
+	case errors.Is(err, ErrInvitationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+	case errors.Is(err, ErrInvitationPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "An invitation is already pending for this email"})
+	case errors.Is(err, ErrInvitationClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Invitation was already accepted or revoked"})
+	case errors.Is(err, ErrInvalidInvitation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+	case errors.Is(err, ErrEmailExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+	case errors.Is(err, ErrNameRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "first_name and last_name are required"})
+	default:
+		logging.LogError("Invitation operation failed", "operation", operation, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// callerClaims returns the claims of the caller; routes check permissions before handlers run
+func callerClaims(c *gin.Context) (*Claims, bool) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+	return claims.(*Claims), true
+}
+
+// invitationResponse signs the invitation's token. INVITATION_URL, when set, is the frontend
+// page the token is appended to.
+func (h *UserHandler) invitationResponse(inv *Invitation, nonce, message string) (*InvitationResponse, error) {
+	token, err := h.authService.GenerateInvitationToken(inv.ID, nonce, inv.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	response := &InvitationResponse{Invitation: inv, Token: token, Message: message}
+	if baseURL := os.Getenv("INVITATION_URL"); baseURL != "" {
+		response.InvitationURL = baseURL + "?token=" + url.QueryEscape(token)
+	}
+	return response, nil
+}
+
+// createInvitation invites someone to the caller's entity. The role's permissions must be
+// grantable by the caller, so that user.invite doesn't let anyone invite an admin.
+func (h *UserHandler) createInvitation(c *gin.Context, userClaims *Claims, req InvitationRequest) {
+	if !grantableRole(c, req.Role) {
+		return
+	}
+	invitedBy := userClaims.UserID
+	inv := &Invitation{
+		HealthcareEntityID: userClaims.HealthcareEntityID,
+		Email:              req.Email,
+		Role:               req.Role,
+		FirstName:          req.FirstName,
+		LastName:           req.LastName,
+		LicenseNumber:      req.LicenseNumber,
+		Specialization:     req.Specialization,
+		PreferredLocale:    req.PreferredLocale,
+		InvitedBy:          &invitedBy,
+	}
+	nonce, err := h.invitationService.CreateInvitation(inv)
+	if err != nil {
+		respondInvitationError(c, err, "create invitation")
+		return
+	}
+
+	response, err := h.invitationResponse(inv, nonce, "Invitation created successfully")
+	if err != nil {
+		respondInvitationError(c, err, "create invitation")
+		return
+	}
+
+	logging.LogInfo("Invitation created", "invitation_id", inv.ID, "role", inv.Role,
+		"healthcare_entity_id", inv.HealthcareEntityID, "invited_by", invitedBy)
+	c.JSON(http.StatusCreated, response)
+}
+
+// CreateInvitation handles POST /api/invitations - Admin invites someone to their entity
+func (h *UserHandler) CreateInvitation(c *gin.Context) {
+	var req InvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	h.createInvitation(c, userClaims, req)
+}
+
+// GetInvitations handles GET /api/invitations - Admin lists the invitations of their entity,
+// optionally by ?status=
+func (h *UserHandler) GetInvitations(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	status := c.Query("status")
+	switch status {
+	case "", "pending", "accepted", "revoked", "expired":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	invitations, err := h.invitationService.ListInvitations(userClaims.HealthcareEntityID, status)
+	if err != nil {
+		respondInvitationError(c, err, "get invitations")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations, "total": len(invitations)})
+}
+
+// invitationID parses the :id parameter
+func invitationID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// GetInvitation handles GET /api/invitations/:id
+func (h *UserHandler) GetInvitation(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := invitationID(c)
+	if !ok {
+		return
+	}
+
+	inv, err := h.invitationService.GetInvitation(userClaims.HealthcareEntityID, id)
+	if err != nil {
+		respondInvitationError(c, err, "get invitation")
+		return
+	}
+
+	c.JSON(http.StatusOK, inv)
+}
+
+// ResendInvitation handles POST /api/invitations/:id/resend - issues a new token with a new
+// expiry; tokens sent before stop working
+func (h *UserHandler) ResendInvitation(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := invitationID(c)
+	if !ok {
+		return
+	}
+
+	inv, nonce, err := h.invitationService.ResendInvitation(userClaims.HealthcareEntityID, id)
+	if err != nil {
+		respondInvitationError(c, err, "resend invitation")
+		return
+	}
+
+	response, err := h.invitationResponse(inv, nonce, "Invitation resent successfully")
+	if err != nil {
+		respondInvitationError(c, err, "resend invitation")
+		return
+	}
+
+	logging.LogInfo("Invitation resent", "invitation_id", inv.ID, "admin_id", userClaims.UserID)
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeInvitation handles DELETE /api/invitations/:id
+func (h *UserHandler) RevokeInvitation(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := invitationID(c)
+	if !ok {
+		return
+	}
+
+	inv, err := h.invitationService.RevokeInvitation(userClaims.HealthcareEntityID, id, userClaims.UserID)
+	if err != nil {
+		respondInvitationError(c, err, "revoke invitation")
+		return
+	}
+
+	logging.LogInfo("Invitation revoked", "invitation_id", inv.ID, "admin_id", userClaims.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully", "invitation": inv})
+}
+
+// openInvitation resolves the pending invitation of a token
+func (h *UserHandler) openInvitation(c *gin.Context, token string) (*Invitation, string, bool) {
+	id, nonce, err := h.authService.ValidateInvitationToken(token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return nil, "", false
+	}
+	inv, err := h.invitationService.GetOpenInvitation(id, nonce)
+	if err != nil {
+		respondInvitationError(c, err, "get invitation")
+		return nil, "", false
+	}
+	return inv, nonce, true
+}
+
+// GetInvitationDetails handles GET /api/auth/invitation?token= - what the invitee is invited
+// to, before accepting
+func (h *UserHandler) GetInvitationDetails(c *gin.Context) {
+	inv, _, ok := h.openInvitation(c, c.Query("token"))
+	if !ok {
+		return
+	}
+
+	details := InvitationDetails{
+		Email:     inv.Email,
+		Role:      inv.Role,
+		FirstName: inv.FirstName,
+		LastName:  inv.LastName,
+		ExpiresAt: inv.ExpiresAt,
+	}
+	if entity, err := h.userService.GetHealthcareEntityByID(inv.HealthcareEntityID); err == nil {
+		details.EntityName = entity.Name
+	}
+
+	c.JSON(http.StatusOK, details)
+}
+
+// AcceptInvitation handles POST /api/auth/invitation/accept - the invitee sets a password and
+// is logged in
+func (h *UserHandler) AcceptInvitation(c *gin.Context) {
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	inv, nonce, ok := h.openInvitation(c, req.Token)
+	if !ok {
+		return
+	}
+
+	// Check the password against the policy of the entity the invitation is for
+	if err := h.passwordService.ValidateNewPassword(&User{HealthcareEntityID: inv.HealthcareEntityID}, req.Password); err != nil {
+		respondPasswordError(c, err, "check password")
+		return
+	}
+
+	hashedPassword, err := h.authService.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	user, err := h.invitationService.AcceptInvitation(inv.ID, nonce, hashedPassword, req.FirstName, req.LastName)
+	if err != nil {
+		respondInvitationError(c, err, "accept invitation")
+		return
+	}
+	logging.LogInfo("Invitation accepted", "invitation_id", inv.ID, "user_id", user.ID)
+
+	// Users whose role must use MFA enrol before getting tokens
+	challenge, err := h.mfaChallenge(user)
+	if err != nil {
+		logging.LogError("Failed to check MFA", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusCreated, challenge)
+		return
+	}
+
+	response, err := h.startSession(c, user, false)
+	if err != nil {
+		logging.LogError("Failed to start session", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
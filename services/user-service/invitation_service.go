@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// invitationTTL is how long an invitation link can be used after it was (re)sent
+const invitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationPending  = errors.New("an invitation is already pending for this email")
+	ErrInvitationClosed   = errors.New("invitation was accepted or revoked")
+	ErrInvalidInvitation  = errors.New("invalid or expired invitation")
+	ErrEmailExists        = errors.New("email already exists")
+	ErrNameRequired       = errors.New("first_name and last_name are required")
+)
+
+// InvitationService manages invitations to join a healthcare entity
+type InvitationService struct {
+	db *sql.DB
+}
+
+func NewInvitationService(db *sql.DB) *InvitationService {
+	return &InvitationService{db: db}
+}
+
+const invitationColumns = `id, healthcare_entity_id, email, role, first_name, last_name, license_number, specialization,
+	preferred_locale, status, expires_at, sent_count, last_sent_at, invited_by, accepted_at, accepted_user_id,
+	revoked_at, revoked_by, created_at, updated_at`
+
+func scanInvitation(row rowScanner) (*Invitation, error) {
+	inv := &Invitation{}
+	var invitedBy, acceptedUserID, revokedBy sql.NullInt64
+	err := row.Scan(&inv.ID, &inv.HealthcareEntityID, &inv.Email, &inv.Role, &inv.FirstName, &inv.LastName,
+		&inv.LicenseNumber, &inv.Specialization, &inv.PreferredLocale, &inv.Status, &inv.ExpiresAt, &inv.SentCount,
+		&inv.LastSentAt, &invitedBy, &inv.AcceptedAt, &acceptedUserID, &inv.RevokedAt, &revokedBy,
+		&inv.CreatedAt, &inv.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	inv.InvitedBy = nullIntPtr(invitedBy)
+	inv.AcceptedUserID = nullIntPtr(acceptedUserID)
+	inv.RevokedBy = nullIntPtr(revokedBy)
+	// Pending invitations past their expiry are only marked expired when touched
+	if inv.Status == "pending" && time.Now().After(inv.ExpiresAt) {
+		inv.Status = "expired"
+	}
+	return inv, nil
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
+
+// newInvitationNonce returns a random nonce for an invitation token and the hash it is stored as
+func newInvitationNonce() (string, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate invitation nonce: %v", err)
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	return nonce, hashInvitationNonce(nonce), nil
+}
+
+func hashInvitationNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
+
+// dbExecutor is implemented by both *sql.DB and *sql.Tx
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// expireStaleInvitations marks the pending invitations of an email that are past their expiry
+func expireStaleInvitations(exec dbExecutor, email string) error {
+	_, err := exec.Exec(`
+		UPDATE user_invitations SET status = 'expired', updated_at = CURRENT_TIMESTAMP
+		WHERE LOWER(email) = LOWER($1) AND status = 'pending' AND expires_at < CURRENT_TIMESTAMP
+	`, email)
+	return err
+}
+
+// emailTaken reports whether an active or inactive user already has the email
+func emailTaken(exec dbExecutor, email string) (bool, error) {
+	var taken bool
+	err := exec.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER($1))`, email).Scan(&taken)
+	return taken, err
+}
+
+// isPendingEmailConflict reports whether err violates the one-pending-invitation-per-email index
+func isPendingEmailConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// CreateInvitation stores a pending invitation and returns the nonce of its token
+func (s *InvitationService) CreateInvitation(inv *Invitation) (string, error) {
+	inv.Email = strings.TrimSpace(inv.Email)
+	taken, err := emailTaken(s.db, inv.Email)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrEmailExists
+	}
+	if err := expireStaleInvitations(s.db, inv.Email); err != nil {
+		return "", err
+	}
+	if inv.PreferredLocale == "" {
+		inv.PreferredLocale = "en-US"
+	}
+
+	nonce, nonceHash, err := newInvitationNonce()
+	if err != nil {
+		return "", err
+	}
+	created, err := scanInvitation(s.db.QueryRow(`
+		INSERT INTO user_invitations (
+			healthcare_entity_id, email, role, first_name, last_name, license_number, specialization,
+			preferred_locale, nonce_hash, expires_at, invited_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+invitationColumns,
+		inv.HealthcareEntityID, inv.Email, inv.Role, inv.FirstName, inv.LastName, inv.LicenseNumber,
+		inv.Specialization, inv.PreferredLocale, nonceHash, time.Now().Add(invitationTTL), inv.InvitedBy))
+	if err != nil {
+		if isPendingEmailConflict(err) {
+			return "", ErrInvitationPending
+		}
+		return "", fmt.Errorf("failed to create invitation: %v", err)
+	}
+	*inv = *created
+	return nonce, nil
+}
+
+// ListInvitations returns the invitations of an entity, newest first, optionally by status
+func (s *InvitationService) ListInvitations(entityID int, status string) ([]Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM user_invitations WHERE healthcare_entity_id = $1`
+	args := []interface{}{entityID}
+	switch status {
+	case "":
+	case "pending":
+		query += ` AND status = 'pending' AND expires_at >= CURRENT_TIMESTAMP`
+	case "expired":
+		query += ` AND (status = 'expired' OR (status = 'pending' AND expires_at < CURRENT_TIMESTAMP))`
+	default:
+		query += ` AND status = $2`
+		args = append(args, status)
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+	return invitations, rows.Err()
+}
+
+// GetInvitation returns an invitation of an entity
+func (s *InvitationService) GetInvitation(entityID, id int) (*Invitation, error) {
+	inv, err := scanInvitation(s.db.QueryRow(`SELECT `+invitationColumns+`
+		FROM user_invitations WHERE id = $1 AND healthcare_entity_id = $2`, id, entityID))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	return inv, err
+}
+
+// ResendInvitation issues a new token for a pending or expired invitation, with a new expiry.
+// Tokens sent before stop working. Returns the nonce of the new token.
+func (s *InvitationService) ResendInvitation(entityID, id int) (*Invitation, string, error) {
+	inv, err := s.GetInvitation(entityID, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if inv.Status != "pending" && inv.Status != "expired" {
+		return nil, "", ErrInvitationClosed
+	}
+	taken, err := emailTaken(s.db, inv.Email)
+	if err != nil {
+		return nil, "", err
+	}
+	if taken {
+		return nil, "", ErrEmailExists
+	}
+
+	nonce, nonceHash, err := newInvitationNonce()
+	if err != nil {
+		return nil, "", err
+	}
+	inv, err = scanInvitation(s.db.QueryRow(`
+		UPDATE user_invitations
+		SET nonce_hash = $3, status = 'pending', expires_at = $4, sent_count = sent_count + 1,
+		    last_sent_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND healthcare_entity_id = $2 AND status IN ('pending', 'expired')
+		RETURNING `+invitationColumns, id, entityID, nonceHash, time.Now().Add(invitationTTL)))
+	if err == sql.ErrNoRows {
+		return nil, "", ErrInvitationClosed
+	}
+	if err != nil {
+		if isPendingEmailConflict(err) {
+			return nil, "", ErrInvitationPending
+		}
+		return nil, "", fmt.Errorf("failed to resend invitation: %v", err)
+	}
+	return inv, nonce, nil
+}
+
+// RevokeInvitation cancels a pending invitation
+func (s *InvitationService) RevokeInvitation(entityID, id, revokedBy int) (*Invitation, error) {
+	inv, err := scanInvitation(s.db.QueryRow(`
+		UPDATE user_invitations
+		SET status = 'revoked', revoked_at = CURRENT_TIMESTAMP, revoked_by = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND healthcare_entity_id = $2 AND status IN ('pending', 'expired')
+		RETURNING `+invitationColumns, id, entityID, revokedBy))
+	if err == sql.ErrNoRows {
+		if _, getErr := s.GetInvitation(entityID, id); getErr != nil {
+			return nil, getErr
+		}
+		return nil, ErrInvitationClosed
+	}
+	return inv, err
+}
+
+// GetOpenInvitation returns the pending invitation a token refers to
+func (s *InvitationService) GetOpenInvitation(id int, nonce string) (*Invitation, error) {
+	inv, err := scanInvitation(s.db.QueryRow(`SELECT `+invitationColumns+`
+		FROM user_invitations WHERE id = $1 AND nonce_hash = $2`, id, hashInvitationNonce(nonce)))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status != "pending" {
+		return nil, ErrInvalidInvitation
+	}
+	return inv, nil
+}
+
+// AcceptInvitation creates the invitee's account with the invitation's entity and role and
+// closes the invitation, so its token can't be used again
+func (s *InvitationService) AcceptInvitation(id int, nonce, passwordHash, firstName, lastName string) (*User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := scanInvitation(tx.QueryRow(`SELECT `+invitationColumns+`
+		FROM user_invitations WHERE id = $1 AND nonce_hash = $2 FOR UPDATE`, id, hashInvitationNonce(nonce)))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status != "pending" {
+		return nil, ErrInvalidInvitation
+	}
+	taken, err := emailTaken(tx, inv.Email)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrEmailExists
+	}
+
+	if firstName == "" {
+		firstName = inv.FirstName
+	}
+	if lastName == "" {
+		lastName = inv.LastName
+	}
+	if firstName == "" || lastName == "" {
+		return nil, ErrNameRequired
+	}
+
+	user := &User{
+		Email:              inv.Email,
+		Password:           passwordHash,
+		FirstName:          firstName,
+		LastName:           lastName,
+		Role:               inv.Role,
+		HealthcareEntityID: inv.HealthcareEntityID,
+		LicenseNumber:      inv.LicenseNumber,
+		Specialization:     inv.Specialization,
+		PreferredLocale:    inv.PreferredLocale,
+		IsActive:           true,
+	}
+	err = tx.QueryRow(`
+		INSERT INTO users (
+			email, password_hash, first_name, last_name, role, healthcare_entity_id,
+			license_number, specialization, preferred_locale, is_active, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, password_changed_at, created_at, updated_at
+	`, user.Email, user.Password, user.FirstName, user.LastName, user.Role, user.HealthcareEntityID,
+		user.LicenseNumber, user.Specialization, user.PreferredLocale).Scan(&user.ID, &user.PasswordChangedAt, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_invitations
+		SET status = 'accepted', accepted_at = CURRENT_TIMESTAMP, accepted_user_id = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, inv.ID, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to close invitation: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const openInvitationQuery = `FROM user_invitations WHERE id = \$1 AND nonce_hash = \$2`
+
+// invitationRows returns invitation 3 of entity 1 to nurse@example.com
+func invitationRows(status string, expiresAt time.Time) *sqlmock.Rows {
+	now := time.Now()
+	var acceptedAt, acceptedUserID, revokedAt, revokedBy driver.Value
+	switch status {
+	case "accepted":
+		acceptedAt, acceptedUserID = now, 9
+	case "revoked":
+		revokedAt, revokedBy = now, 2
+	}
+	return sqlmock.NewRows([]string{"id", "healthcare_entity_id", "email", "role", "first_name", "last_name",
+		"license_number", "specialization", "preferred_locale", "status", "expires_at", "sent_count", "last_sent_at",
+		"invited_by", "accepted_at", "accepted_user_id", "revoked_at", "revoked_by", "created_at", "updated_at"}).
+		AddRow(3, 1, "nurse@example.com", "nurse", "Amina", "Alaoui", "", "", "en-US", status, expiresAt, 1, now,
+			2, acceptedAt, acceptedUserID, revokedAt, revokedBy, now, now)
+}
+
+func TestGetOpenInvitation(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    string
+		expiresAt time.Time
+		wantErr   error
+	}{
+		{"pending", "pending", time.Now().Add(time.Hour), nil},
+		{"expired", "pending", time.Now().Add(-time.Minute), ErrInvalidInvitation},
+		{"accepted", "accepted", time.Now().Add(time.Hour), ErrInvalidInvitation},
+		{"revoked", "revoked", time.Now().Add(time.Hour), ErrInvalidInvitation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(openInvitationQuery).WithArgs(3, hashInvitationNonce("nonce")).
+				WillReturnRows(invitationRows(tt.status, tt.expiresAt))
+
+			inv, err := NewInvitationService(db).GetOpenInvitation(3, "nonce")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetOpenInvitation error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && inv.Status != "pending" {
+				t.Errorf("status = %q, want pending", inv.Status)
+			}
+		})
+	}
+}
+
+func TestScanInvitationMarksExpired(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`FROM user_invitations WHERE id = \$1 AND healthcare_entity_id = \$2`).WithArgs(3, 1).
+		WillReturnRows(invitationRows("pending", time.Now().Add(-time.Minute)))
+
+	inv, err := NewInvitationService(db).GetInvitation(1, 3)
+	if err != nil {
+		t.Fatalf("GetInvitation error = %v", err)
+	}
+	if inv.Status != "expired" {
+		t.Errorf("status = %q, want expired", inv.Status)
+	}
+}
+
+func TestAcceptInvitationIsSingleUse(t *testing.T) {
+	db, mock := newMockDB(t)
+	service := NewInvitationService(db)
+	nonceHash := hashInvitationNonce("nonce")
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(openInvitationQuery+` FOR UPDATE`).WithArgs(3, nonceHash).
+		WillReturnRows(invitationRows("pending", now.Add(time.Hour)))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users`).WithArgs("nurse@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs("nurse@example.com", "hash", "Amina", "Alaoui", "nurse", 1, "", "", "en-US").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password_changed_at", "created_at", "updated_at"}).AddRow(9, now, now, now))
+	mock.ExpectExec(`SET status = 'accepted'`).WithArgs(3, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := service.AcceptInvitation(3, "nonce", "hash", "", "")
+	if err != nil {
+		t.Fatalf("AcceptInvitation error = %v", err)
+	}
+	if user.ID != 9 || user.Role != "nurse" || user.HealthcareEntityID != 1 {
+		t.Errorf("created user %d as %q in entity %d, want user 9 as nurse in entity 1", user.ID, user.Role, user.HealthcareEntityID)
+	}
+
+	// The same link again finds the invitation accepted
+	mock.ExpectBegin()
+	mock.ExpectQuery(openInvitationQuery+` FOR UPDATE`).WithArgs(3, nonceHash).
+		WillReturnRows(invitationRows("accepted", now.Add(time.Hour)))
+	mock.ExpectRollback()
+
+	if _, err := service.AcceptInvitation(3, "nonce", "hash", "", ""); !errors.Is(err, ErrInvalidInvitation) {
+		t.Errorf("second AcceptInvitation error = %v, want ErrInvalidInvitation", err)
+	}
+}
+
+func TestAcceptInvitationRejectsClosedInvitations(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    string
+		expiresAt time.Time
+	}{
+		{"expired", "pending", time.Now().Add(-time.Minute)},
+		{"revoked", "revoked", time.Now().Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectBegin()
+			mock.ExpectQuery(openInvitationQuery+` FOR UPDATE`).WithArgs(3, hashInvitationNonce("nonce")).
+				WillReturnRows(invitationRows(tt.status, tt.expiresAt))
+			mock.ExpectRollback()
+
+			if _, err := NewInvitationService(db).AcceptInvitation(3, "nonce", "hash", "", ""); !errors.Is(err, ErrInvalidInvitation) {
+				t.Errorf("AcceptInvitation error = %v, want ErrInvalidInvitation", err)
+			}
+		})
+	}
+}
+
+func TestAcceptInvitationWithWrongNonce(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(openInvitationQuery+` FOR UPDATE`).WithArgs(3, hashInvitationNonce("guessed")).
+		WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectRollback()
+
+	if _, err := NewInvitationService(db).AcceptInvitation(3, "guessed", "hash", "", ""); !errors.Is(err, ErrInvalidInvitation) {
+		t.Errorf("AcceptInvitation error = %v, want ErrInvalidInvitation", err)
+	}
+}
+
+func TestRevokeInvitationThatIsClosed(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SET status = 'revoked'`).WithArgs(3, 1, 2).WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectQuery(`FROM user_invitations WHERE id = \$1 AND healthcare_entity_id = \$2`).WithArgs(3, 1).
+		WillReturnRows(invitationRows("accepted", time.Now().Add(time.Hour)))
+
+	if _, err := NewInvitationService(db).RevokeInvitation(1, 3, 2); !errors.Is(err, ErrInvitationClosed) {
+		t.Errorf("RevokeInvitation error = %v, want ErrInvitationClosed", err)
+	}
+}
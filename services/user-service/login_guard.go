@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "github.com/louhibi/healthcare-logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Failed logins before each further attempt has to wait, and how long the wait grows to
+const (
+	loginDelayAfter = 3
+	loginBaseDelay  = time.Second
+	loginMaxDelay   = 30 * time.Second
+)
+
+var ErrLoginBlocked = errors.New("too many failed login attempts")
+
+// dummyPasswordHash is checked for unknown emails, so that they take as long as a wrong
+// password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password for unknown accounts"), bcrypt.DefaultCost)
+
+// LoginGuard counts failed logins per account and per client IP. Each failure past
+// loginDelayAfter doubles the wait before the next attempt, and reaching the threshold locks
+// the account (or IP) for the lockout duration. Accounts are keyed by email whether or not
+// they exist, so responses don't tell which emails have an account.
+type LoginGuard struct {
+	db     *sql.DB
+	events *SecurityEventLog
+
+	accountMaxFailures int           // LOGIN_MAX_FAILURES
+	ipMaxFailures      int           // LOGIN_IP_MAX_FAILURES
+	lockoutDuration    time.Duration // LOGIN_LOCKOUT_MINUTES
+	failureWindow      time.Duration // LOGIN_FAILURE_WINDOW_MINUTES, counters restart after it
+}
+
+func NewLoginGuard(db *sql.DB, events *SecurityEventLog) *LoginGuard {
+	return &LoginGuard{
+		db:                 db,
+		events:             events,
+		accountMaxFailures: envInt("LOGIN_MAX_FAILURES", 5),
+		ipMaxFailures:      envInt("LOGIN_IP_MAX_FAILURES", 50),
+		lockoutDuration:    time.Duration(envInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		failureWindow:      time.Duration(envInt("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+	}
+}
+
+// envInt reads a positive integer setting, falling back to def
+func envInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginDelay is the wait before the attempt following the given number of failures
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+	delay := loginBaseDelay
+	for i := loginDelayAfter; i < failures && delay < loginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > loginMaxDelay {
+		delay = loginMaxDelay
+	}
+	return delay
+}
+
+// Check returns ErrLoginBlocked, with the time to wait, while the account or IP is locked or
+// has to wait after its last failure
+func (g *LoginGuard) Check(email, ipAddress string) (time.Duration, error) {
+	rows, err := g.db.Query(`
+		SELECT locked_until, next_attempt_at FROM login_failures
+		WHERE (scope = 'account' AND key = $1) OR (scope = 'ip' AND key = $2)
+	`, accountKey(email), ipAddress)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var wait time.Duration
+	for rows.Next() {
+		var lockedUntil, nextAttemptAt sql.NullTime
+		if err := rows.Scan(&lockedUntil, &nextAttemptAt); err != nil {
+			return 0, err
+		}
+		for _, until := range []sql.NullTime{lockedUntil, nextAttemptAt} {
+			if until.Valid && until.Time.Sub(now) > wait {
+				wait = until.Time.Sub(now)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if wait > 0 {
+		return wait, ErrLoginBlocked
+	}
+	return 0, nil
+}
+
+// countFailure adds a failure to a counter and returns whether it locked the counter's key
+func (g *LoginGuard) countFailure(tx *sql.Tx, scope, key string, maxFailures int, now time.Time) (bool, error) {
+	var count int
+	var lastFailedAt time.Time
+	var lockedUntil sql.NullTime
+	// The no-op update locks the row, so concurrent failures are counted one after the other
+	err := tx.QueryRow(`
+		INSERT INTO login_failures (scope, key, first_failed_at, last_failed_at) VALUES ($1, $2, $3, $3)
+		ON CONFLICT (scope, key) DO UPDATE SET scope = EXCLUDED.scope
+		RETURNING failed_count, last_failed_at, locked_until
+	`, scope, key, now).Scan(&count, &lastFailedAt, &lockedUntil)
+	if err != nil {
+		return false, err
+	}
+
+	lockActive := lockedUntil.Valid && lockedUntil.Time.After(now)
+	if lockedUntil.Valid && !lockActive {
+		// The lock ran out: start over
+		count = 0
+		lockedUntil = sql.NullTime{}
+	} else if !lockActive && now.Sub(lastFailedAt) > g.failureWindow {
+		count = 0
+	}
+	count++
+
+	var nextAttemptAt *time.Time
+	if delay := loginDelay(count); delay > 0 {
+		next := now.Add(delay)
+		nextAttemptAt = &next
+	}
+	locked := false
+	if !lockActive && count >= maxFailures {
+		lockedUntil = sql.NullTime{Time: now.Add(g.lockoutDuration), Valid: true}
+		locked = true
+	}
+
+	_, err = tx.Exec(`
+		UPDATE login_failures
+		SET failed_count = $3, first_failed_at = CASE WHEN $3 = 1 THEN $4 ELSE first_failed_at END,
+		    last_failed_at = $4, next_attempt_at = $5, locked_until = $6
+		WHERE scope = $1 AND key = $2
+	`, scope, key, count, now, nextAttemptAt, lockedUntil)
+	return locked, err
+}
+
+// RecordFailure counts a failed login for the email and IP. user is nil for unknown emails.
+func (g *LoginGuard) RecordFailure(email, ipAddress string, user *User) error {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	key := accountKey(email)
+	accountLocked, err := g.countFailure(tx, "account", key, g.accountMaxFailures, now)
+	if err != nil {
+		return fmt.Errorf("failed to count login failure: %v", err)
+	}
+	ipLocked, err := g.countFailure(tx, "ip", ipAddress, g.ipMaxFailures, now)
+	if err != nil {
+		return fmt.Errorf("failed to count login failure: %v", err)
+	}
+
+	if err := g.events.Record(tx, SecurityEventLoginFailed, user, key, ipAddress, nil, ""); err != nil {
+		return err
+	}
+	lockDetails := fmt.Sprintf("locked for %d minutes", int(g.lockoutDuration.Minutes()))
+	if accountLocked {
+		if err := g.events.Record(tx, SecurityEventAccountLocked, user, key, ipAddress, nil, lockDetails); err != nil {
+			return err
+		}
+		logging.LogWarn("Account locked after failed logins", "email", key, "ip_address", ipAddress)
+	}
+	if ipLocked {
+		if err := g.events.Record(tx, SecurityEventIPLocked, user, key, ipAddress, nil, lockDetails); err != nil {
+			return err
+		}
+		logging.LogWarn("Client IP locked after failed logins", "ip_address", ipAddress)
+	}
+	return tx.Commit()
+}
+
+// RecordSuccess clears the failed logins of an account. The IP's counter keeps running, so
+// one valid account doesn't reset an IP trying many others.
+func (g *LoginGuard) RecordSuccess(email string) error {
+	_, err := g.db.Exec(`DELETE FROM login_failures WHERE scope = 'account' AND key = $1`, accountKey(email))
+	return err
+}
+
+// Status returns the failed logins and lock of an account
+func (g *LoginGuard) Status(email string) (*LoginLockStatus, error) {
+	status := &LoginLockStatus{}
+	var lastFailedAt, nextAttemptAt, lockedUntil sql.NullTime
+	err := g.db.QueryRow(`
+		SELECT failed_count, last_failed_at, next_attempt_at, locked_until FROM login_failures
+		WHERE scope = 'account' AND key = $1
+	`, accountKey(email)).Scan(&status.FailedCount, &lastFailedAt, &nextAttemptAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if lastFailedAt.Valid {
+		status.LastFailedAt = &lastFailedAt.Time
+	}
+	if nextAttemptAt.Valid && nextAttemptAt.Time.After(now) {
+		status.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(now) {
+		status.Locked = true
+		status.LockedUntil = &lockedUntil.Time
+	}
+	return status, nil
+}
+
+// Unlock clears the lock and failed logins of a user's account. actorID is the admin, or nil
+// when the user unlocked it with a password reset.
+func (g *LoginGuard) Unlock(user *User, actorID *int, ipAddress, reason string) error {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM login_failures WHERE scope = 'account' AND key = $1`, accountKey(user.Email))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+	if err := g.events.Record(tx, SecurityEventAccountUnlocked, user, accountKey(user.Email), ipAddress, actorID, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
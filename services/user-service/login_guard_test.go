@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{-1, 0},
+		{0, 0},
+		{1, 0},
+		{loginDelayAfter - 1, 0},
+		{loginDelayAfter, time.Second},
+		{loginDelayAfter + 1, 2 * time.Second},
+		{loginDelayAfter + 2, 4 * time.Second},
+		{loginDelayAfter + 3, 8 * time.Second},
+		{loginDelayAfter + 4, 16 * time.Second},
+		{loginDelayAfter + 5, loginMaxDelay}, // 32s, capped
+		{loginDelayAfter + 6, loginMaxDelay},
+		{1000, loginMaxDelay},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
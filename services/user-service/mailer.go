@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// MailMessage is a plain text email to one recipient
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// MailSender delivers emails
+type MailSender interface {
+	Send(msg MailMessage) error
+}
+
+// NewMailSenderFromEnv picks the sender from MAIL_SENDER: "smtp", or "log" for local
+// development. It defaults to smtp when SMTP_HOST is set.
+func NewMailSenderFromEnv() MailSender {
+	sender := os.Getenv("MAIL_SENDER")
+	if sender == "" && os.Getenv("SMTP_HOST") != "" {
+		sender = "smtp"
+	}
+	if sender != "smtp" {
+		logging.LogWarn("Emails are logged instead of sent, set SMTP_HOST to deliver them")
+		return &logMailSender{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM_EMAIL")
+	if from == "" {
+		from = "noreply@healthcare-platform.com"
+	}
+	return &smtpMailSender{
+		host:     os.Getenv("SMTP_HOST"),
+		port:     port,
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     from,
+	}
+}
+
+// logMailSender writes emails to the log instead of sending them. Messages include their
+// links, so it is only meant for local development.
+type logMailSender struct{}
+
+func (s *logMailSender) Send(msg MailMessage) error {
+	logging.LogInfo("Email (not sent)", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// smtpMailSender sends emails through an SMTP server, with PLAIN auth when a username is set
+type smtpMailSender struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func (s *smtpMailSender) Send(msg MailMessage) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	// Header values come from the service, but a newline would still start a new header
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+	body := "From: " + s.from + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + msg.Body
+	if err := smtp.SendMail(s.host+":"+s.port, auth, s.from, []string{msg.To}, []byte(body)); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	logging "github.com/louhibi/healthcare-logging"
+	rbac "github.com/louhibi/healthcare-rbac"
 )
 
 func main() {
@@ -36,16 +38,52 @@ func main() {
 	authService := NewAuthService()
 	formConfigService := NewFormConfigService(db)
 	translationService := NewTranslationService(db)
+	sessionService := NewSessionService(db)
+	mfaService := NewMFAService(db)
+	invitationService := NewInvitationService(db)
+	configClient := NewConfigClientFromEnv()
+	passwordService := NewPasswordService(db, NewBreachedPasswordCheckerFromEnv(), NewMailSenderFromEnv())
+	securityEvents := NewSecurityEventLog(db)
+	loginGuard := NewLoginGuard(db, securityEvents)
+	roleService := NewRoleService(db)
+	membershipService := NewMembershipService(db)
+	entityService := NewEntityService(db, formConfigService)
+	departmentService := NewDepartmentService(db)
+	apiKeyService := NewAPIKeyService(db)
+	serviceAccountService := NewServiceAccountService(db, apiKeyService)
+
+	// SUPER_ADMIN_EMAILS lists the platform operators; other super admins become entity admins
+	if emails := os.Getenv("SUPER_ADMIN_EMAILS"); emails != "" {
+		promoted, demoted, err := entityService.SyncSuperAdmins(strings.Split(emails, ","))
+		if err != nil {
+			logging.LogError("Failed to sync super admins", "error", err)
+			os.Exit(1)
+		}
+		logging.LogInfo("Super admins synced", "promoted", promoted, "demoted", demoted)
+	}
 
 	// Add services to user service
 	userService.formConfigService = formConfigService
 	userService.translationService = translationService
 
 	// Initialize handlers
-	userHandler := NewUserHandler(userService, authService)
+	userHandler := NewUserHandler(userService, authService, sessionService, mfaService, invitationService, passwordService, loginGuard,
+		securityEvents, roleService, membershipService, entityService, departmentService, apiKeyService,
+		serviceAccountService, configClient)
 
 	// Setup router
 	router := gin.Default()
+
+	// Login throttling is keyed on the client IP, so X-Forwarded-For is only trusted from the
+	// API Gateway's network (TRUSTED_PROXIES, comma separated addresses or CIDRs)
+	trustedProxies := []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+	if value := os.Getenv("TRUSTED_PROXIES"); value != "" {
+		trustedProxies = strings.Split(strings.ReplaceAll(value, " ", ""), ",")
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		logging.LogError("Invalid TRUSTED_PROXIES", "error", err)
+		os.Exit(1)
+	}
 	
 	// Add request/response logging middleware
 	router.Use(logging.RequestLoggingMiddleware())
@@ -54,7 +92,7 @@ func main() {
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -80,12 +118,46 @@ func main() {
 		auth.POST("/register", userHandler.Register)
 		auth.POST("/login", userHandler.Login)
 		auth.POST("/refresh", userHandler.RefreshToken)
+		auth.POST("/logout", userHandler.Logout)
+		auth.POST("/mfa/verify", userHandler.VerifyMFA)                    // Second step of a login
+		auth.POST("/mfa/enroll", userHandler.EnrollMFA)                    // Enrolment required by the policy at login
+		auth.POST("/mfa/enroll/confirm", userHandler.ConfirmMFAEnrollment) // First code, completes the login
+		auth.GET("/invitation", userHandler.GetInvitationDetails)          // What a token invites to
+		auth.POST("/invitation/accept", userHandler.AcceptInvitation)      // Invitee sets a password
+		auth.POST("/password/forgot", userHandler.ForgotPassword)          // Email a reset link
+		auth.POST("/password/reset", userHandler.ResetPassword)            // New password with the emailed token
+		auth.POST("/api-key/token", userHandler.ExchangeAPIKey)            // Short-lived access token for an API key
 	}
 
-	// Protected auth routes
-	authProtected := apiProtected.Group("/auth")
+	// Protected auth routes; tokens exchanged for an API key can't use them
+	apiProtected.GET("/auth/permissions", userHandler.GetMyPermissions) // Caller's current permissions
+	authProtected := apiProtected.Group("/auth", RequireLoginToken())
 	{
 		authProtected.POST("/change-password", userHandler.ChangePassword)
+		authProtected.GET("/sessions", userHandler.GetSessions)                  // Caller's active sessions
+		authProtected.DELETE("/sessions", userHandler.RevokeOtherSessions)       // Log out other devices
+		authProtected.DELETE("/sessions/:sessionId", userHandler.RevokeSession) // Log out one device
+		authProtected.GET("/mfa", userHandler.GetMFAStatus)
+		authProtected.POST("/mfa/setup", userHandler.SetupMFA)
+		authProtected.POST("/mfa/activate", userHandler.ActivateMFA)
+		authProtected.POST("/mfa/recovery-codes", userHandler.RegenerateRecoveryCodes)
+		authProtected.POST("/mfa/disable", userHandler.DisableMFA)
+		authProtected.GET("/entities", userHandler.GetMyEntities)        // Entities the caller belongs to
+		authProtected.POST("/switch-entity", userHandler.SwitchEntity)   // Access token for another entity
+		authProtected.GET("/api-keys", userHandler.GetAPIKeys)            // Caller's personal API keys
+		authProtected.POST("/api-keys", userHandler.CreateAPIKey)
+		authProtected.DELETE("/api-keys/:keyId", userHandler.RevokeAPIKey)
+	}
+
+	// Permission catalog and custom roles
+	apiProtected.GET("/permissions", userHandler.GetPermissionCatalog)
+	roles := apiProtected.Group("/roles", rbac.Require(rbac.RoleManage))
+	{
+		roles.GET("", userHandler.GetRoles)
+		roles.POST("", userHandler.CreateRole)
+		roles.GET("/:id", userHandler.GetRole)
+		roles.PUT("/:id", userHandler.UpdateRole)
+		roles.DELETE("/:id", userHandler.DeleteRole)
 	}
 
 	// doctors routes
@@ -98,7 +170,12 @@ func main() {
 	entities := apiProtected.Group("/entities")
 	{
 		entities.GET("/:id", userHandler.GetEntityComplete) // Get entities for appointment service
+		entities.PUT("/:id", rbac.RequireAny(rbac.EntityManage, rbac.PlatformManage), userHandler.UpdateEntity) // Details, timezone, locale, currency, room requirement
 		entities.GET(":id/room-requirement", userHandler.GetEntityRoomRequirement) // Get entity room requirement setting
+		entities.GET("/:id/mfa-policy", userHandler.GetMFAPolicy)                  // Roles that must use MFA
+		entities.PUT("/:id/mfa-policy", rbac.Require(rbac.EntityManage), userHandler.UpdateMFAPolicy)
+		entities.GET("/:id/password-policy", userHandler.GetPasswordPolicy)        // Password rules of the entity
+		entities.PUT("/:id/password-policy", rbac.Require(rbac.EntityManage), userHandler.UpdatePasswordPolicy)
 	}
 
 	forms := apiProtected.Group("/forms")
@@ -122,19 +199,82 @@ func main() {
 	{
 		users.GET("/profile", userHandler.GetProfile)
 		users.PUT("/profile", userHandler.UpdateProfile)
-		users.GET("/", userHandler.GetUsers) // Admin only (enforced in handler/middleware)
+		users.GET("/", rbac.Require(rbac.UserRead), userHandler.GetUsers)
+		users.GET("/by-ids", userHandler.GetUsersByIDs) // Names of users of the caller's entity
+		users.GET("/:id/sessions", rbac.Require(rbac.UserRead), userHandler.AdminGetUserSessions)
+		users.DELETE("/:id/sessions", rbac.Require(rbac.UserManage), userHandler.AdminRevokeUserSessions) // Force logout
+		users.GET("/:id/lockout", rbac.Require(rbac.UserRead), userHandler.AdminGetUserLockout)           // Failed logins and lock of a user
+		users.POST("/:id/unlock", rbac.Require(rbac.UserManage), userHandler.AdminUnlockUser)             // Lift a lockout
+		users.GET("/:id/permissions", rbac.Require(rbac.UserRead), userHandler.GetUserPermissions)        // Role and permissions of a user
+		users.PUT("/:id/role", rbac.Require(rbac.UserManage), userHandler.AssignUserRole)                 // Set or clear a custom role
+		users.GET("/:id/departments", rbac.Require(rbac.UserRead), userHandler.GetUserDepartments)        // Departments a user works in
+	}
+
+	// Entity lifecycle, for platform super admins
+	platform := apiProtected.Group("/platform", rbac.Require(rbac.PlatformManage))
+	{
+		platform.GET("/entities", userHandler.GetEntities)
+		platform.POST("/entities", userHandler.CreateEntity)                         // Entity, default forms and first admin's invitation
+		platform.POST("/entities/:id/admins", userHandler.InviteEntityAdmin)         // Invite an admin to any entity
+		platform.POST("/entities/:id/deactivate", userHandler.DeactivateEntity)      // Close an entity and log its users out
+		platform.POST("/entities/:id/reactivate", userHandler.ReactivateEntity)
+	}
+
+	// Departments of the caller's entity; heads assign the members of their departments
+	departments := apiProtected.Group("/departments")
+	{
+		departments.GET("", userHandler.GetDepartments)
+		departments.POST("", rbac.Require(rbac.DepartmentManage), userHandler.CreateDepartment)
+		departments.GET("/:id", userHandler.GetDepartment)
+		departments.PUT("/:id", rbac.Require(rbac.DepartmentManage), userHandler.UpdateDepartment) // Rename, move, head, deactivate
+		departments.GET("/:id/members", userHandler.GetDepartmentMembers)
+		departments.POST("/:id/members", userHandler.AddDepartmentMembers)
+		departments.DELETE("/:id/members/:userId", userHandler.RemoveDepartmentMember)
+	}
+
+	// Members from other entities
+	memberships := apiProtected.Group("/memberships")
+	{
+		memberships.GET("", rbac.Require(rbac.UserRead), userHandler.GetMemberships)
+		memberships.POST("", rbac.Require(rbac.UserInvite), userHandler.AddMembership) // Add an existing user
+		memberships.PUT("/:userId", rbac.Require(rbac.UserManage), userHandler.UpdateMembership)
+		memberships.DELETE("/:userId", rbac.Require(rbac.UserManage), userHandler.RemoveMembership)
+	}
+
+	// Service accounts of the entity and their API keys, for integrations
+	serviceAccounts := apiProtected.Group("/service-accounts", RequireLoginToken(), rbac.Require(rbac.ServiceAccountManage))
+	{
+		serviceAccounts.GET("", userHandler.GetServiceAccounts)
+		serviceAccounts.POST("", userHandler.CreateServiceAccount)
+		serviceAccounts.GET("/:id", userHandler.GetServiceAccount)
+		serviceAccounts.DELETE("/:id", userHandler.DeactivateServiceAccount) // Deactivate and revoke its keys
+		serviceAccounts.GET("/:id/api-keys", userHandler.GetServiceAccountKeys)
+		serviceAccounts.POST("/:id/api-keys", userHandler.CreateServiceAccountKey)
+		serviceAccounts.DELETE("/:id/api-keys/:keyId", userHandler.RevokeServiceAccountKey)
+	}
+
+	// Security event log
+	apiProtected.GET("/security-events", rbac.Require(rbac.SecurityAudit), userHandler.GetSecurityEvents)
+
+	// Invitation routes
+	invitations := apiProtected.Group("/invitations", rbac.Require(rbac.UserInvite))
+	{
+		invitations.POST("", userHandler.CreateInvitation)
+		invitations.GET("", userHandler.GetInvitations)
+		invitations.GET("/:id", userHandler.GetInvitation)
+		invitations.POST("/:id/resend", userHandler.ResendInvitation)
+		invitations.DELETE("/:id", userHandler.RevokeInvitation)
 	}
 
 	// Admin base group (protected)
 	admin := apiProtected.Group("/admin")
-	admin.Use(userService.AdminMiddleware())
 	{
 		// Doctor admin routes
-		admin.POST("/doctors", userHandler.AdminCreateDoctor) // Admin creates doctors with temp passwords
-		admin.GET("/doctors", userHandler.AdminGetDoctors)    // Admin lists doctors
+		admin.POST("/doctors", rbac.Require(rbac.UserInvite), userHandler.AdminCreateDoctor) // Admin invites doctors
+		admin.GET("/doctors", rbac.Require(rbac.UserRead), userHandler.AdminGetDoctors)      // Admin lists doctors
 
-		// Admin-only form configuration management
-		formsAdmin := admin.Group("/forms")
+		// Form configuration management
+		formsAdmin := admin.Group("/forms", rbac.Require(rbac.EntityManage))
 		{
 			formsAdmin.PUT("/:formType/fields", userService.UpdateMultipleFieldConfigurations) // Update multiple field configurations
 			formsAdmin.PUT("/:formType/fields/:fieldId", userService.UpdateFieldConfiguration) // Update single field configuration
@@ -142,8 +282,8 @@ func main() {
 			formsAdmin.POST("/:formType/reset", userService.ResetFormConfiguration)            // Reset form to defaults
 		}
 
-		// Translation management (Admin only)
-		i18nAdmin := admin.Group("/i18n")
+		// Translation management
+		i18nAdmin := admin.Group("/i18n", rbac.Require(rbac.EntityManage))
 		{
 			i18nAdmin.GET("/translations/:locale", userService.GetTranslations)                 // Get all translations for locale
 			i18nAdmin.POST("/translations", userService.CreateOrUpdateTranslation)             // Create/update translation
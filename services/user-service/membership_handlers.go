@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// respondMembershipError maps membership service errors to HTTP responses
+func respondMembershipError(c *gin.Context, err error, operation string) {
+	switch {
+	case errors.Is(err, ErrMembershipNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Membership not found"})
+	case errors.Is(err, ErrMembershipExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member of this entity"})
+	case errors.Is(err, ErrHomeEntity):
+		c.JSON(http.StatusConflict, gin.H{"error": "User already belongs to this entity"})
+	default:
+		logging.LogError("Membership operation failed", "operation", operation, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// loadEntityMember loads the :id user of the caller's entity or of another entity who is a
+// member of it, scoped to the caller's entity
+func (h *UserHandler) loadEntityMember(c *gin.Context) (*Claims, *User, bool) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, nil, false
+	}
+	user, err := h.userService.GetUserByID(userID)
+	if err == nil {
+		user, err = h.membershipService.ScopeUser(user, userClaims.HealthcareEntityID)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, nil, false
+	}
+	return userClaims, user, true
+}
+
+// memberUserID reads the :userId route parameter of membership routes
+func memberUserID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// GetMyEntities handles GET /api/auth/entities - the entities the caller belongs to and their
+// role in each
+func (h *UserHandler) GetMyEntities(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	entities, err := h.membershipService.ListUserEntities(userClaims.UserID, userClaims.HealthcareEntityID)
+	if err != nil {
+		respondMembershipError(c, err, "get entities")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entities": entities})
+}
+
+// SwitchEntity handles POST /api/auth/switch-entity - scopes the caller's session to another
+// of their entities and returns an access token for it. The refresh token stays valid and
+// keeps issuing tokens for the chosen entity.
+func (h *UserHandler) SwitchEntity(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	var req SwitchEntityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.GetUserByID(userClaims.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	scoped, err := h.membershipService.ScopeUser(user, req.HealthcareEntityID)
+	if err != nil {
+		if errors.Is(err, ErrMembershipNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this entity"})
+			return
+		}
+		if errors.Is(err, ErrEntityDeactivated) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This healthcare entity is deactivated", "code": "ENTITY_INACTIVE"})
+			return
+		}
+		respondMembershipError(c, err, "switch entity")
+		return
+	}
+
+	// The MFA policy of the target entity applies to sessions opened without a second factor
+	if !userClaims.MFA {
+		required, err := h.mfaService.IsRequired(scoped)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch entity"})
+			return
+		}
+		if required {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This entity requires multi-factor authentication, please log in again"})
+			return
+		}
+	}
+
+	session, err := h.sessionService.SwitchEntity(userClaims.UserID, userClaims.SessionID, scoped.HealthcareEntityID)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has ended, please log in again"})
+			return
+		}
+		logging.LogError("Failed to switch session entity", "error", err, "session_id", userClaims.SessionID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch entity"})
+		return
+	}
+
+	response, err := h.authResponse(scoped, session, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
+	}
+
+	logging.LogInfo("User switched entity", "user_id", user.ID, "session_id", session.ID,
+		"from_entity_id", userClaims.HealthcareEntityID, "to_entity_id", scoped.HealthcareEntityID)
+	c.JSON(http.StatusOK, response)
+}
+
+// GetMemberships handles GET /api/memberships - users of other entities who are members of
+// the caller's entity
+func (h *UserHandler) GetMemberships(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	members, err := h.membershipService.ListMembers(userClaims.HealthcareEntityID)
+	if err != nil {
+		respondMembershipError(c, err, "get memberships")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"memberships": members})
+}
+
+// AddMembership handles POST /api/memberships - makes an existing user of another entity a
+// member of the caller's entity. New people are invited instead.
+func (h *UserHandler) AddMembership(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	var req MembershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !grantableRole(c, req.Role) {
+		return
+	}
+
+	user, err := h.userService.GetUserByEmail(req.Email)
+	if err != nil || user.Role == ServiceAccountRole {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active user with this email, invite them instead"})
+		return
+	}
+	member, err := h.membershipService.AddMembership(userClaims.HealthcareEntityID, user, req.Role, userClaims.UserID)
+	if err != nil {
+		respondMembershipError(c, err, "add membership")
+		return
+	}
+
+	logging.LogInfo("Membership added", "user_id", user.ID, "healthcare_entity_id", userClaims.HealthcareEntityID,
+		"role", req.Role, "added_by", userClaims.UserID)
+	c.JSON(http.StatusCreated, member)
+}
+
+// UpdateMembership handles PUT /api/memberships/:userId - changes a member's role or suspends
+// the membership
+func (h *UserHandler) UpdateMembership(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	userID, ok := memberUserID(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateMembershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if userID == userClaims.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't change your own membership"})
+		return
+	}
+	if !grantableRole(c, req.Role) {
+		return
+	}
+
+	member, err := h.membershipService.UpdateMembership(userClaims.HealthcareEntityID, userID, req.Role, req.IsActive)
+	if err != nil {
+		respondMembershipError(c, err, "update membership")
+		return
+	}
+	// Suspended members are logged out of the entity
+	if !member.IsActive {
+		if _, err := h.sessionService.RevokeEntitySessions(userID, userClaims.HealthcareEntityID, userClaims.UserID, "membership_suspended"); err != nil {
+			logging.LogError("Failed to revoke member sessions", "error", err, "user_id", userID)
+		}
+	}
+
+	logging.LogInfo("Membership updated", "user_id", userID, "healthcare_entity_id", userClaims.HealthcareEntityID,
+		"role", member.Role, "is_active", member.IsActive, "updated_by", userClaims.UserID)
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMembership handles DELETE /api/memberships/:userId - removes a member from the
+// caller's entity and logs them out of it
+func (h *UserHandler) RemoveMembership(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	userID, ok := memberUserID(c)
+	if !ok {
+		return
+	}
+	if userID == userClaims.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't remove your own membership"})
+		return
+	}
+
+	if err := h.membershipService.RemoveMembership(userClaims.HealthcareEntityID, userID); err != nil {
+		respondMembershipError(c, err, "remove membership")
+		return
+	}
+	if err := h.departmentService.RemoveEntityAssignments(userClaims.HealthcareEntityID, userID); err != nil {
+		logging.LogError("Failed to remove member from departments", "error", err, "user_id", userID)
+	}
+	if _, err := h.sessionService.RevokeEntitySessions(userID, userClaims.HealthcareEntityID, userClaims.UserID, "membership_removed"); err != nil {
+		logging.LogError("Failed to revoke member sessions", "error", err, "user_id", userID)
+	}
+
+	logging.LogInfo("Membership removed", "user_id", userID, "healthcare_entity_id", userClaims.HealthcareEntityID,
+		"removed_by", userClaims.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Membership removed successfully"})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrMembershipNotFound = errors.New("membership not found")
+	ErrMembershipExists   = errors.New("user is already a member of this entity")
+	ErrHomeEntity         = errors.New("this is the user's home entity")
+	ErrEntityDeactivated  = errors.New("healthcare entity is deactivated")
+)
+
+// MembershipService manages the entities users work at besides their home entity. A user's
+// home entity and role stay on the users row; memberships add an entity with its own role.
+type MembershipService struct {
+	db *sql.DB
+}
+
+func NewMembershipService(db *sql.DB) *MembershipService {
+	return &MembershipService{db: db}
+}
+
+const membershipColumns = `m.user_id, m.healthcare_entity_id, u.email, u.first_name, u.last_name,
+	COALESCE(u.specialization, ''), COALESCE(u.healthcare_entity_id, 0), m.role, m.custom_role_id, m.is_active,
+	m.created_by, m.created_at, m.updated_at`
+
+func scanMembership(row rowScanner) (*Membership, error) {
+	m := &Membership{}
+	var customRoleID, createdBy sql.NullInt64
+	err := row.Scan(&m.UserID, &m.HealthcareEntityID, &m.Email, &m.FirstName, &m.LastName, &m.Specialization,
+		&m.HomeEntityID, &m.Role, &customRoleID, &m.IsActive, &createdBy, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.CustomRoleID = nullIntPtr(customRoleID)
+	m.CreatedBy = nullIntPtr(createdBy)
+	return m, nil
+}
+
+// ScopeUser returns the user as a member of entityID: a copy with the entity and the role the
+// user has there. ErrMembershipNotFound means the user doesn't belong to the entity and
+// ErrEntityDeactivated that the entity is deactivated. Super admins keep access to their
+// deactivated home entity, from which they manage the platform.
+func (s *MembershipService) ScopeUser(user *User, entityID int) (*User, error) {
+	if entityID == user.HealthcareEntityID {
+		var active bool
+		err := s.db.QueryRow(`SELECT is_active FROM healthcare_entities WHERE id = $1`, entityID).Scan(&active)
+		if err == sql.ErrNoRows {
+			return nil, ErrMembershipNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !active && user.Role != SuperAdminRole {
+			return nil, ErrEntityDeactivated
+		}
+		return user, nil
+	}
+	var role string
+	var active bool
+	err := s.db.QueryRow(`
+		SELECT m.role, e.is_active FROM user_entity_memberships m
+		JOIN healthcare_entities e ON e.id = m.healthcare_entity_id
+		WHERE m.user_id = $1 AND m.healthcare_entity_id = $2 AND m.is_active = true
+	`, user.ID, entityID).Scan(&role, &active)
+	if err == sql.ErrNoRows {
+		return nil, ErrMembershipNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrEntityDeactivated
+	}
+	scoped := *user
+	scoped.HealthcareEntityID = entityID
+	scoped.Role = role
+	return &scoped, nil
+}
+
+// ScopeLogin returns the user scoped to the entity a new session starts in: their home entity,
+// or while it is deactivated the first active entity they are a member of
+func (s *MembershipService) ScopeLogin(user *User) (*User, error) {
+	scoped, err := s.ScopeUser(user, user.HealthcareEntityID)
+	if !errors.Is(err, ErrEntityDeactivated) {
+		return scoped, err
+	}
+	var entityID int
+	err = s.db.QueryRow(`
+		SELECT m.healthcare_entity_id FROM user_entity_memberships m
+		JOIN healthcare_entities e ON e.id = m.healthcare_entity_id
+		WHERE m.user_id = $1 AND m.is_active = true AND e.is_active = true
+		ORDER BY e.name LIMIT 1
+	`, user.ID).Scan(&entityID)
+	if err == sql.ErrNoRows {
+		return nil, ErrEntityDeactivated
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.ScopeUser(user, entityID)
+}
+
+// ListUserEntities returns the entities a user belongs to, home entity first. currentEntityID
+// marks the entity of the caller's access token.
+func (s *MembershipService) ListUserEntities(userID, currentEntityID int) ([]EntityMembership, error) {
+	rows, err := s.db.Query(`
+		SELECT em.healthcare_entity_id, e.name, em.role, em.custom_role_id, em.is_home, em.joined_at
+		FROM entity_members em
+		JOIN healthcare_entities e ON e.id = em.healthcare_entity_id
+		WHERE em.user_id = $1 AND e.is_active = true
+		ORDER BY em.is_home DESC, e.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entities := []EntityMembership{}
+	for rows.Next() {
+		var entity EntityMembership
+		var customRoleID sql.NullInt64
+		if err := rows.Scan(&entity.HealthcareEntityID, &entity.EntityName, &entity.Role, &customRoleID,
+			&entity.IsHome, &entity.JoinedAt); err != nil {
+			return nil, err
+		}
+		entity.CustomRoleID = nullIntPtr(customRoleID)
+		entity.IsCurrent = entity.HealthcareEntityID == currentEntityID
+		entities = append(entities, entity)
+	}
+	return entities, rows.Err()
+}
+
+// ListMembers returns the users of other entities who are members of the entity
+func (s *MembershipService) ListMembers(entityID int) ([]Membership, error) {
+	rows, err := s.db.Query(`SELECT `+membershipColumns+`
+		FROM user_entity_memberships m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.healthcare_entity_id = $1 AND u.is_active = true
+		ORDER BY u.last_name, u.first_name`, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []Membership{}
+	for rows.Next() {
+		member, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *member)
+	}
+	return members, rows.Err()
+}
+
+// GetMembership returns the membership of a user in the entity
+func (s *MembershipService) GetMembership(entityID, userID int) (*Membership, error) {
+	member, err := scanMembership(s.db.QueryRow(`SELECT `+membershipColumns+`
+		FROM user_entity_memberships m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.healthcare_entity_id = $1 AND m.user_id = $2 AND u.is_active = true`, entityID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrMembershipNotFound
+	}
+	return member, err
+}
+
+// AddMembership makes a user of another entity a member of the entity with the role
+func (s *MembershipService) AddMembership(entityID int, user *User, role string, createdBy int) (*Membership, error) {
+	if user.HealthcareEntityID == entityID {
+		return nil, ErrHomeEntity
+	}
+	result, err := s.db.Exec(`
+		INSERT INTO user_entity_memberships (user_id, healthcare_entity_id, role, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, healthcare_entity_id) DO NOTHING
+	`, user.ID, entityID, role, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrMembershipExists
+	}
+	return s.GetMembership(entityID, user.ID)
+}
+
+// UpdateMembership changes the role of a member and, when isActive is set, suspends or
+// resumes the membership. A changed built-in role clears the member's custom role.
+func (s *MembershipService) UpdateMembership(entityID, userID int, role string, isActive *bool) (*Membership, error) {
+	result, err := s.db.Exec(`
+		UPDATE user_entity_memberships
+		SET custom_role_id = CASE WHEN role = $3 THEN custom_role_id END,
+		    role = $3, is_active = COALESCE($4, is_active), updated_at = CURRENT_TIMESTAMP
+		WHERE healthcare_entity_id = $1 AND user_id = $2
+	`, entityID, userID, role, isActive)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrMembershipNotFound
+	}
+	return s.GetMembership(entityID, userID)
+}
+
+// RemoveMembership removes a user from an entity that isn't their home entity
+func (s *MembershipService) RemoveMembership(entityID, userID int) error {
+	result, err := s.db.Exec(`DELETE FROM user_entity_memberships WHERE healthcare_entity_id = $1 AND user_id = $2`,
+		entityID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMembershipNotFound
+	}
+	return nil
+}
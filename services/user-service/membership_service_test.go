@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestScopeUser(t *testing.T) {
+	const homeQuery = `SELECT is_active FROM healthcare_entities`
+	const memberQuery = `SELECT m.role, e.is_active FROM user_entity_memberships`
+
+	tests := []struct {
+		name     string
+		role     string
+		entityID int
+		expect   func(sqlmock.Sqlmock)
+		wantErr  error
+		wantRole string
+	}{
+		{
+			name: "active home entity", role: "doctor", entityID: 1,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(homeQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"is_active"}).AddRow(true))
+			},
+			wantRole: "doctor",
+		},
+		{
+			name: "deactivated home entity", role: "doctor", entityID: 1,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(homeQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"is_active"}).AddRow(false))
+			},
+			wantErr: ErrEntityDeactivated,
+		},
+		{
+			name: "super admin keeps a deactivated home entity", role: SuperAdminRole, entityID: 1,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(homeQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"is_active"}).AddRow(false))
+			},
+			wantRole: SuperAdminRole,
+		},
+		{
+			name: "membership of an active entity", role: "doctor", entityID: 2,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(memberQuery).WithArgs(7, 2).WillReturnRows(sqlmock.NewRows([]string{"role", "is_active"}).AddRow("nurse", true))
+			},
+			wantRole: "nurse",
+		},
+		{
+			name: "membership of a deactivated entity", role: "doctor", entityID: 2,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(memberQuery).WithArgs(7, 2).WillReturnRows(sqlmock.NewRows([]string{"role", "is_active"}).AddRow("nurse", false))
+			},
+			wantErr: ErrEntityDeactivated,
+		},
+		{
+			name: "not a member", role: "doctor", entityID: 3,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(memberQuery).WithArgs(7, 3).WillReturnError(sql.ErrNoRows)
+			},
+			wantErr: ErrMembershipNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+			user := &User{ID: 7, Role: tt.role, HealthcareEntityID: 1}
+
+			scoped, err := NewMembershipService(db).ScopeUser(user, tt.entityID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ScopeUser error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if scoped.HealthcareEntityID != tt.entityID || scoped.Role != tt.wantRole {
+				t.Errorf("scoped to entity %d as %q, want entity %d as %q", scoped.HealthcareEntityID, scoped.Role, tt.entityID, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestScopeLoginFallsBackToAnotherEntity(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT is_active FROM healthcare_entities`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_active"}).AddRow(false))
+	mock.ExpectQuery(`SELECT m.healthcare_entity_id FROM user_entity_memberships`).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"healthcare_entity_id"}).AddRow(4))
+	mock.ExpectQuery(`SELECT m.role, e.is_active FROM user_entity_memberships`).WithArgs(7, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "is_active"}).AddRow("doctor", true))
+
+	scoped, err := NewMembershipService(db).ScopeLogin(&User{ID: 7, Role: "admin", HealthcareEntityID: 1})
+	if err != nil {
+		t.Fatalf("ScopeLogin error = %v", err)
+	}
+	if scoped.HealthcareEntityID != 4 || scoped.Role != "doctor" {
+		t.Errorf("scoped to entity %d as %q, want entity 4 as doctor", scoped.HealthcareEntityID, scoped.Role)
+	}
+}
+
+func TestScopeLoginWithoutActiveEntity(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT is_active FROM healthcare_entities`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_active"}).AddRow(false))
+	mock.ExpectQuery(`SELECT m.healthcare_entity_id FROM user_entity_memberships`).WithArgs(7).
+		WillReturnError(sql.ErrNoRows)
+
+	_, err := NewMembershipService(db).ScopeLogin(&User{ID: 7, Role: "admin", HealthcareEntityID: 1})
+	if !errors.Is(err, ErrEntityDeactivated) {
+		t.Errorf("ScopeLogin error = %v, want ErrEntityDeactivated", err)
+	}
+}
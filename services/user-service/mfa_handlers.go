@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// respondMFAError maps MFA service errors to HTTP responses
+func respondMFAError(c *gin.Context, err error, operation string) {
+	switch {
+	case errors.Is(err, ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+	case errors.Is(err, ErrMFALocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes, try again later"})
+	case errors.Is(err, ErrMFANotEnrolled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Multi-factor authentication is not set up"})
+	case errors.Is(err, ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Multi-factor authentication is already enabled"})
+	default:
+		logging.LogError("MFA operation failed", "operation", operation, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// challengeUser resolves the user of an MFA challenge token issued for purpose
+func (h *UserHandler) challengeUser(c *gin.Context, mfaToken, purpose string) (*User, bool) {
+	userID, err := h.authService.ValidateMFAChallenge(mfaToken, purpose)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return nil, false
+	}
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return h.scopeLogin(c, user)
+}
+
+// VerifyMFA handles POST /api/auth/mfa/verify - completes a login with a TOTP or recovery code
+func (h *UserHandler) VerifyMFA(c *gin.Context) {
+	var req MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.challengeUser(c, req.MFAToken, "verify")
+	if !ok {
+		return
+	}
+	if err := h.mfaService.Verify(user.ID, req.Code, req.RecoveryCode); err != nil {
+		respondMFAError(c, err, "verify code")
+		return
+	}
+
+	response, err := h.startSession(c, user, true)
+	if err != nil {
+		logging.LogError("Failed to start session", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// EnrollMFA handles POST /api/auth/mfa/enroll - starts the enrolment a login requires
+func (h *UserHandler) EnrollMFA(c *gin.Context) {
+	var req MFAEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.challengeUser(c, req.MFAToken, "enroll")
+	if !ok {
+		return
+	}
+	enrollment, err := h.mfaService.BeginEnrollment(user)
+	if err != nil {
+		respondMFAError(c, err, "start MFA enrolment")
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMFAEnrollment handles POST /api/auth/mfa/enroll/confirm - enables MFA with a first
+// code and completes the login
+func (h *UserHandler) ConfirmMFAEnrollment(c *gin.Context) {
+	var req MFAEnrollConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.challengeUser(c, req.MFAToken, "enroll")
+	if !ok {
+		return
+	}
+	recoveryCodes, err := h.mfaService.ConfirmEnrollment(user.ID, req.Code)
+	if err != nil {
+		respondMFAError(c, err, "confirm MFA enrolment")
+		return
+	}
+
+	response, err := h.startSession(c, user, true)
+	if err != nil {
+		logging.LogError("Failed to start session", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MFAEnrollmentResponse{AuthResponse: *response, RecoveryCodes: recoveryCodes})
+}
+
+// currentUser loads the user of the request's access token
+func (h *UserHandler) currentUser(c *gin.Context) (*Claims, *User, bool) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, nil, false
+	}
+	userClaims := claims.(*Claims)
+	user, err := h.userService.GetUserByID(userClaims.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, nil, false
+	}
+	return userClaims, user, true
+}
+
+// GetMFAStatus handles GET /api/auth/mfa - MFA state of the caller
+func (h *UserHandler) GetMFAStatus(c *gin.Context) {
+	_, user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	status, err := h.mfaService.GetStatus(user)
+	if err != nil {
+		respondMFAError(c, err, "get MFA status")
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// SetupMFA handles POST /api/auth/mfa/setup - starts an enrolment of the caller
+func (h *UserHandler) SetupMFA(c *gin.Context) {
+	_, user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	enrollment, err := h.mfaService.BeginEnrollment(user)
+	if err != nil {
+		respondMFAError(c, err, "start MFA enrolment")
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ActivateMFA handles POST /api/auth/mfa/activate - enables MFA with a first code. The current
+// session counts as verified from its next refresh on.
+func (h *UserHandler) ActivateMFA(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userClaims, user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	recoveryCodes, err := h.mfaService.ConfirmEnrollment(user.ID, req.Code)
+	if err != nil {
+		respondMFAError(c, err, "enable MFA")
+		return
+	}
+	if userClaims.SessionID != 0 {
+		if err := h.sessionService.MarkMFAVerified(user.ID, userClaims.SessionID); err != nil {
+			logging.LogError("Failed to mark session as MFA verified", "error", err, "session_id", userClaims.SessionID)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Multi-factor authentication enabled",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// RegenerateRecoveryCodes handles POST /api/auth/mfa/recovery-codes - replaces the caller's
+// recovery codes
+func (h *UserHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	recoveryCodes, err := h.mfaService.RegenerateRecoveryCodes(user.ID, req.Code)
+	if err != nil {
+		respondMFAError(c, err, "regenerate recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+// DisableMFA handles POST /api/auth/mfa/disable - removes the caller's second factor unless the
+// policy requires one
+func (h *UserHandler) DisableMFA(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	required, err := h.mfaService.IsRequired(user)
+	if err != nil {
+		respondMFAError(c, err, "disable MFA")
+		return
+	}
+	if required {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Multi-factor authentication is mandatory for your role"})
+		return
+	}
+	if err := h.mfaService.Disable(user.ID, req.Code); err != nil {
+		respondMFAError(c, err, "disable MFA")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Multi-factor authentication disabled"})
+}
+
+// GetMFAPolicy handles GET /api/entities/:id/mfa-policy - roles of the caller's entity that must
+// use MFA. The api-gateway reads it to reject tokens without the mfa claim.
+func (h *UserHandler) GetMFAPolicy(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userClaims := claims.(*Claims)
+
+	entityID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entity ID"})
+		return
+	}
+	if entityID != userClaims.HealthcareEntityID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this healthcare entity"})
+		return
+	}
+
+	policy, err := h.mfaService.GetPolicy(entityID)
+	if err != nil {
+		respondMFAError(c, err, "get MFA policy")
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// UpdateMFAPolicy handles PUT /api/entities/:id/mfa-policy - Admin sets the roles of the entity
+// that must use MFA
+func (h *UserHandler) UpdateMFAPolicy(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userClaims := claims.(*Claims)
+
+	entityID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entity ID"})
+		return
+	}
+	if entityID != userClaims.HealthcareEntityID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this healthcare entity"})
+		return
+	}
+
+	var req MFAPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := h.mfaService.UpdatePolicy(entityID, req.RequiredRoles, userClaims.UserID)
+	if err != nil {
+		respondMFAError(c, err, "update MFA policy")
+		return
+	}
+
+	logging.LogInfo("MFA policy updated", "healthcare_entity_id", entityID, "required_roles", policy.EntityRoles,
+		"updated_by", userClaims.UserID)
+	c.JSON(http.StatusOK, policy)
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+const (
+	recoveryCodeCount    = 10
+	mfaMaxFailedAttempts = 5
+	mfaLockoutDuration   = 15 * time.Minute
+)
+
+// recoveryCodeAlphabet leaves out characters that are easily confused (0/o, 1/l/i)
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+var (
+	ErrMFANotEnrolled    = errors.New("mfa not enrolled")
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+	ErrInvalidMFACode    = errors.New("invalid mfa code")
+	ErrMFALocked         = errors.New("mfa verification locked")
+)
+
+// MFAService manages TOTP enrolment and verification, recovery codes and MFA policies
+type MFAService struct {
+	db     *sql.DB
+	issuer string
+	// defaultRequiredRoles must use MFA in every entity (MFA_REQUIRED_ROLES)
+	defaultRequiredRoles []string
+}
+
+func NewMFAService(db *sql.DB) *MFAService {
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "Healthcare Platform"
+	}
+	var roles []string
+	for _, role := range strings.Split(os.Getenv("MFA_REQUIRED_ROLES"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return &MFAService{db: db, issuer: issuer, defaultRequiredRoles: roles}
+}
+
+// GetPolicy returns the MFA policy of an entity, merged with the service-wide roles
+func (s *MFAService) GetPolicy(entityID int) (*MFAPolicy, error) {
+	policy := &MFAPolicy{HealthcareEntityID: entityID, EntityRoles: []string{}}
+	var updatedAt time.Time
+	var updatedBy sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT required_roles, updated_by, updated_at FROM mfa_policies WHERE healthcare_entity_id = $1
+	`, entityID).Scan(pq.Array(&policy.EntityRoles), &updatedBy, &updatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		policy.UpdatedAt = &updatedAt
+		if updatedBy.Valid {
+			id := int(updatedBy.Int64)
+			policy.UpdatedBy = &id
+		}
+	}
+	policy.RequiredRoles = mergeRoles(policy.EntityRoles, s.defaultRequiredRoles)
+	return policy, nil
+}
+
+// UpdatePolicy replaces the roles of an entity that must use MFA
+func (s *MFAService) UpdatePolicy(entityID int, roles []string, updatedBy int) (*MFAPolicy, error) {
+	roles = mergeRoles(roles, nil)
+	_, err := s.db.Exec(`
+		INSERT INTO mfa_policies (healthcare_entity_id, required_roles, updated_by, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (healthcare_entity_id) DO UPDATE
+		SET required_roles = EXCLUDED.required_roles, updated_by = EXCLUDED.updated_by, updated_at = CURRENT_TIMESTAMP
+	`, entityID, pq.Array(roles), updatedBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update MFA policy: %v", err)
+	}
+	return s.GetPolicy(entityID)
+}
+
+// mergeRoles returns the sorted union of role lists without duplicates
+func mergeRoles(lists ...[]string) []string {
+	seen := map[string]bool{}
+	roles := []string{}
+	for _, list := range lists {
+		for _, role := range list {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// IsRequired reports whether the policy of the user's entity makes MFA mandatory for their role
+func (s *MFAService) IsRequired(user *User) (bool, error) {
+	policy, err := s.GetPolicy(user.HealthcareEntityID)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range policy.RequiredRoles {
+		if role == user.Role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IsEnabled reports whether the user completed an MFA enrolment
+func (s *MFAService) IsEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM user_mfa WHERE user_id = $1 AND enabled_at IS NOT NULL)
+	`, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// GetStatus returns the MFA state of a user
+func (s *MFAService) GetStatus(user *User) (*MFAStatus, error) {
+	status := &MFAStatus{}
+	err := s.db.QueryRow(`SELECT enabled_at FROM user_mfa WHERE user_id = $1`, user.ID).Scan(&status.EnabledAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	status.Enabled = status.EnabledAt != nil
+	if status.Enabled {
+		err = s.db.QueryRow(`
+			SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+		`, user.ID).Scan(&status.RecoveryCodesRemaining)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if status.Required, err = s.IsRequired(user); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// BeginEnrollment generates a new TOTP secret for the user. The secret only becomes active
+// once ConfirmEnrollment accepted a code from it; a pending enrolment is replaced.
+func (s *MFAService) BeginEnrollment(user *User) (*MFAEnrollment, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.db.Exec(`
+		INSERT INTO user_mfa (user_id, totp_secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET totp_secret = EXCLUDED.totp_secret, last_used_step = 0, failed_attempts = 0, locked_until = NULL,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE user_mfa.enabled_at IS NULL
+	`, user.ID, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store TOTP secret: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	return &MFAEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totpURI(s.issuer, user.Email, secret),
+		Issuer:     s.issuer,
+		Account:    user.Email,
+	}, nil
+}
+
+// ConfirmEnrollment enables MFA once the user proved with a code that the authenticator app
+// holds the secret, and returns the user's recovery codes. They are only shown this once.
+func (s *MFAService) ConfirmEnrollment(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var secret string
+	var enabledAt *time.Time
+	err = tx.QueryRow(`SELECT totp_secret, enabled_at FROM user_mfa WHERE user_id = $1 FOR UPDATE`, userID).
+		Scan(&secret, &enabledAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	step, ok := matchTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_mfa SET enabled_at = CURRENT_TIMESTAMP, last_used_step = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1
+	`, userID, step)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable MFA: %v", err)
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	logging.LogInfo("MFA enabled", "user_id", userID)
+	return codes, nil
+}
+
+// Verify checks the second factor of a login: a TOTP code or, when the device is lost, an
+// unused recovery code. Repeated failures lock verification for mfaLockoutDuration.
+func (s *MFAService) Verify(userID int, code, recoveryCode string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := verifyLocked(tx, userID, code, recoveryCode); err != nil {
+		// Failed attempts are counted, so the transaction is committed either way
+		if commitErr := tx.Commit(); commitErr != nil {
+			return commitErr
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a TOTP code
+func (s *MFAService) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := verifyLocked(tx, userID, code, ""); err != nil {
+		if commitErr := tx.Commit(); commitErr != nil {
+			return nil, commitErr
+		}
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes the user's TOTP secret and recovery codes after checking a TOTP code
+func (s *MFAService) Disable(userID int, code string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := verifyLocked(tx, userID, code, ""); err != nil {
+		if commitErr := tx.Commit(); commitErr != nil {
+			return commitErr
+		}
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logging.LogInfo("MFA disabled", "user_id", userID)
+	return nil
+}
+
+// verifyLocked checks a TOTP or recovery code of an enabled user within tx, locking the
+// user's MFA row, and records the outcome
+func verifyLocked(tx *sql.Tx, userID int, code, recoveryCode string) error {
+	var secret string
+	var lastStep int64
+	var failedAttempts int
+	var lockedUntil *time.Time
+	err := tx.QueryRow(`
+		SELECT totp_secret, last_used_step, failed_attempts, locked_until
+		FROM user_mfa WHERE user_id = $1 AND enabled_at IS NOT NULL
+		FOR UPDATE
+	`, userID).Scan(&secret, &lastStep, &failedAttempts, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if lockedUntil != nil && time.Now().Before(*lockedUntil) {
+		return ErrMFALocked
+	}
+
+	ok := false
+	if recoveryCode != "" {
+		result, err := tx.Exec(`
+			UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+		`, userID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		ok = n > 0
+		if ok {
+			logging.LogInfo("MFA recovery code used", "user_id", userID)
+		}
+	} else if step, matched := matchTOTP(secret, code, time.Now(), lastStep); matched {
+		ok = true
+		lastStep = step
+	}
+
+	if !ok {
+		failedAttempts++
+		var lockUntil *time.Time
+		if failedAttempts >= mfaMaxFailedAttempts {
+			until := time.Now().Add(mfaLockoutDuration)
+			lockUntil = &until
+			failedAttempts = 0
+			logging.LogWarn("MFA verification locked after failed attempts", "user_id", userID)
+		}
+		_, err := tx.Exec(`
+			UPDATE user_mfa SET failed_attempts = $2, locked_until = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1
+		`, userID, failedAttempts, lockUntil)
+		if err != nil {
+			return err
+		}
+		return ErrInvalidMFACode
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_mfa SET last_used_step = $2, failed_attempts = 0, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1
+	`, userID, lastStep)
+	return err
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes for the user, invalidating the
+// previous ones
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hashRecoveryCode(code)); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %v", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a random code formatted as xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+	// Bytes above the largest multiple of the alphabet size are skipped to keep letters uniform
+	limit := 256 - 256%len(recoveryCodeAlphabet)
+	code := make([]byte, 0, 10)
+	b := make([]byte, 16)
+	for len(code) < cap(code) {
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		for _, v := range b {
+			if int(v) < limit && len(code) < cap(code) {
+				code = append(code, recoveryCodeAlphabet[int(v)%len(recoveryCodeAlphabet)])
+			}
+		}
+	}
+	return string(code[:5]) + "-" + string(code[5:]), nil
+}
+
+// hashRecoveryCode hashes a recovery code as typed, ignoring case, spaces and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
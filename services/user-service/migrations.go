@@ -436,6 +436,388 @@ func GetMigrations() []Migration {
 				DROP COLUMN IF EXISTS country_id;
 			`,
 		},
+		{
+			Version:     7,
+			Description: "Create user sessions and rotating refresh tokens",
+			Up: `
+				-- A session is a login on one device. Its refresh tokens form a family: each refresh
+				-- replaces the token used, and reusing a replaced token revokes the whole session.
+				CREATE TABLE IF NOT EXISTS user_sessions (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					healthcare_entity_id INTEGER NOT NULL,
+					family_id UUID NOT NULL UNIQUE DEFAULT gen_random_uuid(),
+					user_agent VARCHAR(500) NOT NULL DEFAULT '',
+					ip_address VARCHAR(64) NOT NULL DEFAULT '',
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					last_used_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP NOT NULL, -- Absolute limit, whatever the refreshes
+					revoked_at TIMESTAMP,
+					revoked_by INTEGER,
+					revoke_reason VARCHAR(50) NOT NULL DEFAULT ''
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id) WHERE revoked_at IS NULL;
+
+				-- Refresh tokens are stored as SHA-256 hashes; replaced_at is set when a refresh rotates them
+				CREATE TABLE IF NOT EXISTS refresh_tokens (
+					id SERIAL PRIMARY KEY,
+					session_id INTEGER NOT NULL REFERENCES user_sessions(id) ON DELETE CASCADE,
+					token_hash CHAR(64) NOT NULL UNIQUE,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP NOT NULL,
+					replaced_at TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens(session_id);
+			`,
+			Down: `
+				DROP TABLE IF EXISTS refresh_tokens;
+				DROP TABLE IF EXISTS user_sessions;
+			`,
+		},
+		{
+			Version:     8,
+			Description: "Create TOTP multi-factor authentication, recovery codes and MFA policies",
+			Up: `
+				-- TOTP secret of a user; enabled_at is set once the first code confirmed the enrolment
+				CREATE TABLE IF NOT EXISTS user_mfa (
+					user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					totp_secret VARCHAR(64) NOT NULL,
+					enabled_at TIMESTAMP,
+					last_used_step BIGINT NOT NULL DEFAULT 0, -- Last accepted TOTP time step, codes can't be replayed
+					failed_attempts INTEGER NOT NULL DEFAULT 0,
+					locked_until TIMESTAMP,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+
+				-- Single-use recovery codes, stored as SHA-256 hashes
+				CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					code_hash CHAR(64) NOT NULL,
+					used_at TIMESTAMP,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user ON mfa_recovery_codes(user_id) WHERE used_at IS NULL;
+
+				-- Roles of an entity that must use MFA; listing every role makes it mandatory entity-wide
+				CREATE TABLE IF NOT EXISTS mfa_policies (
+					healthcare_entity_id INTEGER PRIMARY KEY REFERENCES healthcare_entities(id) ON DELETE CASCADE,
+					required_roles TEXT[] NOT NULL DEFAULT '{}',
+					updated_by INTEGER REFERENCES users(id),
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+
+				ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS mfa_verified BOOLEAN NOT NULL DEFAULT false;
+			`,
+			Down: `
+				ALTER TABLE user_sessions DROP COLUMN IF EXISTS mfa_verified;
+				DROP TABLE IF EXISTS mfa_policies;
+				DROP TABLE IF EXISTS mfa_recovery_codes;
+				DROP TABLE IF EXISTS user_mfa;
+			`,
+		},
+		{
+			Version:     9,
+			Description: "Create user invitations",
+			Up: `
+				-- An invitation to join an entity with a role. The emailed token is a signed JWT carrying a
+				-- nonce; only its hash is stored and resending replaces it, so older links stop working.
+				CREATE TABLE IF NOT EXISTS user_invitations (
+					id SERIAL PRIMARY KEY,
+					healthcare_entity_id INTEGER NOT NULL REFERENCES healthcare_entities(id),
+					email VARCHAR(255) NOT NULL,
+					role VARCHAR(20) NOT NULL CHECK (role IN ('admin', 'doctor', 'nurse', 'staff')),
+					first_name VARCHAR(100) NOT NULL DEFAULT '',
+					last_name VARCHAR(100) NOT NULL DEFAULT '',
+					license_number VARCHAR(100) NOT NULL DEFAULT '',
+					specialization VARCHAR(100) NOT NULL DEFAULT '',
+					preferred_locale VARCHAR(10) NOT NULL DEFAULT 'en-US',
+					nonce_hash CHAR(64) NOT NULL,
+					status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'revoked', 'expired')),
+					expires_at TIMESTAMP NOT NULL,
+					sent_count INTEGER NOT NULL DEFAULT 1,
+					last_sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					invited_by INTEGER REFERENCES users(id),
+					accepted_at TIMESTAMP,
+					accepted_user_id INTEGER REFERENCES users(id),
+					revoked_at TIMESTAMP,
+					revoked_by INTEGER REFERENCES users(id),
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+
+				-- One open invitation per email address
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_user_invitations_pending_email
+					ON user_invitations(LOWER(email)) WHERE status = 'pending';
+				CREATE INDEX IF NOT EXISTS idx_user_invitations_entity ON user_invitations(healthcare_entity_id, status);
+			`,
+			Down: `
+				DROP TABLE IF EXISTS user_invitations;
+			`,
+		},
+		{
+			Version:     10,
+			Description: "Add password policies, password history and password reset tokens",
+			Up: `
+				-- Per-entity password rules; entities without a row use the service defaults
+				CREATE TABLE IF NOT EXISTS password_policies (
+					healthcare_entity_id INTEGER PRIMARY KEY REFERENCES healthcare_entities(id),
+					min_length INTEGER NOT NULL CHECK (min_length BETWEEN 8 AND 128),
+					require_uppercase BOOLEAN NOT NULL,
+					require_lowercase BOOLEAN NOT NULL,
+					require_digit BOOLEAN NOT NULL,
+					require_symbol BOOLEAN NOT NULL,
+					check_breached BOOLEAN NOT NULL,
+					history_count INTEGER NOT NULL CHECK (history_count BETWEEN 0 AND 24),
+					max_age_days INTEGER NOT NULL CHECK (max_age_days >= 0),
+					updated_by INTEGER REFERENCES users(id),
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+
+				-- Previous password hashes, checked so that recent passwords aren't reused
+				CREATE TABLE IF NOT EXISTS password_history (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					password_hash VARCHAR(255) NOT NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+				CREATE INDEX IF NOT EXISTS idx_password_history_user ON password_history(user_id, created_at DESC);
+
+				-- Emailed single-use reset tokens, stored as SHA-256 hashes
+				CREATE TABLE IF NOT EXISTS password_reset_tokens (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					token_hash CHAR(64) NOT NULL UNIQUE,
+					expires_at TIMESTAMP NOT NULL,
+					used_at TIMESTAMP,
+					requested_ip VARCHAR(45) NOT NULL DEFAULT '',
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+				CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user ON password_reset_tokens(user_id, created_at DESC);
+
+				-- Start of the maximum password age; existing passwords count from the last account update
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP;
+				UPDATE users SET password_changed_at = updated_at WHERE password_changed_at IS NULL;
+				ALTER TABLE users ALTER COLUMN password_changed_at SET DEFAULT CURRENT_TIMESTAMP;
+				ALTER TABLE users ALTER COLUMN password_changed_at SET NOT NULL;
+			`,
+			Down: `
+				ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at;
+				DROP TABLE IF EXISTS password_reset_tokens;
+				DROP TABLE IF EXISTS password_history;
+				DROP TABLE IF EXISTS password_policies;
+			`,
+		},
+		{
+			Version:     11,
+			Description: "Add login failure counters and security events",
+			Up: `
+				-- Failed logins per account (lowercased email, known or not) and per client IP
+				CREATE TABLE IF NOT EXISTS login_failures (
+					scope VARCHAR(10) NOT NULL CHECK (scope IN ('account', 'ip')),
+					key VARCHAR(255) NOT NULL,
+					failed_count INTEGER NOT NULL DEFAULT 0,
+					first_failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					last_failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					next_attempt_at TIMESTAMP,
+					locked_until TIMESTAMP,
+					PRIMARY KEY (scope, key)
+				);
+
+				-- Security relevant events: failed logins, lockouts and unlocks
+				CREATE TABLE IF NOT EXISTS security_events (
+					id BIGSERIAL PRIMARY KEY,
+					event_type VARCHAR(50) NOT NULL,
+					user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					healthcare_entity_id INTEGER REFERENCES healthcare_entities(id),
+					email VARCHAR(255) NOT NULL DEFAULT '',
+					ip_address VARCHAR(45) NOT NULL DEFAULT '',
+					actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					details TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+				CREATE INDEX IF NOT EXISTS idx_security_events_entity ON security_events(healthcare_entity_id, created_at DESC);
+				CREATE INDEX IF NOT EXISTS idx_security_events_user ON security_events(user_id, created_at DESC);
+			`,
+			Down: `
+				DROP TABLE IF EXISTS security_events;
+				DROP TABLE IF EXISTS login_failures;
+			`,
+		},
+		{
+			Version:     12,
+			Description: "Add custom roles",
+			Up: `
+				-- Roles defined by entity admins as a set of permissions
+				CREATE TABLE IF NOT EXISTS custom_roles (
+					id SERIAL PRIMARY KEY,
+					healthcare_entity_id INTEGER NOT NULL REFERENCES healthcare_entities(id) ON DELETE CASCADE,
+					name VARCHAR(50) NOT NULL,
+					description TEXT NOT NULL DEFAULT '',
+					permissions TEXT[] NOT NULL DEFAULT '{}',
+					created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					UNIQUE (healthcare_entity_id, name)
+				);
+
+				-- Users with a custom role get its permissions instead of their built-in role's
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS custom_role_id INTEGER REFERENCES custom_roles(id);
+				CREATE INDEX IF NOT EXISTS idx_users_custom_role ON users(custom_role_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_users_custom_role;
+				ALTER TABLE users DROP COLUMN IF EXISTS custom_role_id;
+				DROP TABLE IF EXISTS custom_roles;
+			`,
+		},
+		{
+			Version:     13,
+			Description: "Add user entity memberships",
+			Up: `
+				-- Entities a user works at besides their home entity (users.healthcare_entity_id),
+				-- each with its own role
+				CREATE TABLE IF NOT EXISTS user_entity_memberships (
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					healthcare_entity_id INTEGER NOT NULL REFERENCES healthcare_entities(id) ON DELETE CASCADE,
+					role VARCHAR(20) NOT NULL CHECK (role IN ('admin', 'doctor', 'nurse', 'staff')),
+					custom_role_id INTEGER REFERENCES custom_roles(id),
+					is_active BOOLEAN NOT NULL DEFAULT true,
+					created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (user_id, healthcare_entity_id)
+				);
+				CREATE INDEX IF NOT EXISTS idx_memberships_entity ON user_entity_memberships(healthcare_entity_id, role);
+				CREATE INDEX IF NOT EXISTS idx_memberships_custom_role ON user_entity_memberships(custom_role_id);
+
+				-- Every entity a user belongs to with their role there: the home entity and the
+				-- active memberships
+				CREATE OR REPLACE VIEW entity_members AS
+					SELECT id AS user_id, healthcare_entity_id, role, custom_role_id, true AS is_home, created_at AS joined_at
+					FROM users
+					UNION ALL
+					SELECT user_id, healthcare_entity_id, role, custom_role_id, false AS is_home, created_at AS joined_at
+					FROM user_entity_memberships
+					WHERE is_active = true;
+			`,
+			Down: `
+				DROP VIEW IF EXISTS entity_members;
+				DROP TABLE IF EXISTS user_entity_memberships;
+			`,
+		},
+		{
+			Version:     14,
+			Description: "Add entity lifecycle and the super_admin role",
+			Up: `
+				-- Platform operators manage every entity
+				ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+				ALTER TABLE users ADD CONSTRAINT users_role_check
+					CHECK (role IN ('super_admin', 'admin', 'doctor', 'nurse', 'staff'));
+
+				-- Entities created through the API are located by country_id/state_id/city_id
+				ALTER TABLE healthcare_entities ALTER COLUMN country DROP NOT NULL;
+				ALTER TABLE healthcare_entities ALTER COLUMN city DROP NOT NULL;
+
+				ALTER TABLE healthcare_entities
+				ADD COLUMN IF NOT EXISTS created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+				ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMP,
+				ADD COLUMN IF NOT EXISTS deactivated_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+			`,
+			Down: `
+				ALTER TABLE healthcare_entities
+				DROP COLUMN IF EXISTS deactivated_by,
+				DROP COLUMN IF EXISTS deactivated_at,
+				DROP COLUMN IF EXISTS created_by;
+				UPDATE users SET role = 'admin' WHERE role = 'super_admin';
+				ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+				ALTER TABLE users ADD CONSTRAINT users_role_check
+					CHECK (role IN ('admin', 'doctor', 'nurse', 'staff'));
+			`,
+		},
+		{
+			Version:     15,
+			Description: "Add departments",
+			Up: `
+				-- Organisational units of an entity, nested through parent_id
+				CREATE TABLE IF NOT EXISTS departments (
+					id SERIAL PRIMARY KEY,
+					healthcare_entity_id INTEGER NOT NULL REFERENCES healthcare_entities(id) ON DELETE CASCADE,
+					parent_id INTEGER REFERENCES departments(id),
+					name VARCHAR(100) NOT NULL,
+					description TEXT NOT NULL DEFAULT '',
+					head_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					is_active BOOLEAN NOT NULL DEFAULT true,
+					created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					UNIQUE (healthcare_entity_id, name)
+				);
+				CREATE INDEX IF NOT EXISTS idx_departments_parent ON departments(parent_id);
+
+				-- A user can work in several departments of each entity they belong to
+				CREATE TABLE IF NOT EXISTS user_departments (
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					department_id INTEGER NOT NULL REFERENCES departments(id) ON DELETE CASCADE,
+					created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (user_id, department_id)
+				);
+				CREATE INDEX IF NOT EXISTS idx_user_departments_department ON user_departments(department_id);
+			`,
+			Down: `
+				DROP TABLE IF EXISTS user_departments;
+				DROP TABLE IF EXISTS departments;
+			`,
+		},
+		{
+			Version:     16,
+			Description: "Add service accounts and API keys",
+			Up: `
+				-- Service accounts are users of the 'service' role that only authenticate with API keys
+				ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+				ALTER TABLE users ADD CONSTRAINT users_role_check
+					CHECK (role IN ('super_admin', 'admin', 'doctor', 'nurse', 'staff', 'service'));
+
+				CREATE TABLE IF NOT EXISTS service_accounts (
+					user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					description TEXT NOT NULL DEFAULT '',
+					created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+
+				-- Keys are shown once as hk_<prefix>_<secret>; only the SHA-256 of the secret is kept
+				CREATE TABLE IF NOT EXISTS api_keys (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					healthcare_entity_id INTEGER NOT NULL REFERENCES healthcare_entities(id) ON DELETE CASCADE,
+					name VARCHAR(100) NOT NULL,
+					prefix VARCHAR(16) NOT NULL UNIQUE,
+					secret_hash VARCHAR(64) NOT NULL,
+					permissions TEXT[] NOT NULL DEFAULT '{}',
+					expires_at TIMESTAMP NOT NULL,
+					last_used_at TIMESTAMP,
+					last_used_ip VARCHAR(45),
+					revoked_at TIMESTAMP,
+					revoked_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				);
+				CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id, healthcare_entity_id);
+			`,
+			Down: `
+				DROP TABLE IF EXISTS api_keys;
+				DROP TABLE IF EXISTS service_accounts;
+				DELETE FROM users WHERE role = 'service';
+				ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+				ALTER TABLE users ADD CONSTRAINT users_role_check
+					CHECK (role IN ('super_admin', 'admin', 'doctor', 'nurse', 'staff'));
+			`,
+		},
 	}
 }
 
@@ -11,7 +11,7 @@ type User struct {
 	Password             string    `json:"-" db:"password_hash"`
 	FirstName            string    `json:"first_name" db:"first_name" validate:"required"`
 	LastName             string    `json:"last_name" db:"last_name" validate:"required"`
-	Role                 string    `json:"role" db:"role" validate:"required,oneof=admin doctor nurse staff"`
+	Role                 string    `json:"role" db:"role" validate:"required,oneof=super_admin admin doctor nurse staff service"`
 	HealthcareEntityID   int       `json:"healthcare_entity_id" db:"healthcare_entity_id" validate:"required"`
 	LicenseNumber        string    `json:"license_number" db:"license_number"` // Professional license for doctors/nurses
 	Specialization       string    `json:"specialization" db:"specialization"` // Medical specialization
@@ -19,6 +19,7 @@ type User struct {
 	IsActive             bool      `json:"is_active" db:"is_active"`
 	IsTempPassword       bool      `json:"is_temp_password" db:"is_temp_password"`
 	TempPasswordExpires  *time.Time `json:"temp_password_expires" db:"temp_password_expires"`
+	PasswordChangedAt    time.Time `json:"password_changed_at" db:"password_changed_at"`
 	CreatedAt            time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt            time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -59,10 +60,12 @@ type UserResponse struct {
 
 // AuthResponse represents authentication response
 type AuthResponse struct {
-	User         UserResponse `json:"user"`
-	AccessToken  string       `json:"access_token"`
-	RefreshToken string       `json:"refresh_token"`
-	ExpiresIn    int64        `json:"expires_in"`
+	User              UserResponse `json:"user"`
+	AccessToken       string       `json:"access_token"`
+	RefreshToken      string       `json:"refresh_token,omitempty"` // Not reissued when switching entities
+	ExpiresIn         int64        `json:"expires_in"`
+	PasswordExpiresAt *time.Time   `json:"password_expires_at,omitempty"` // Set when the entity's policy has a maximum age
+	Permissions       []string     `json:"permissions"`                   // Also in the access token's permissions claim
 }
 
 // RefreshTokenRequest represents refresh token request
@@ -77,6 +80,309 @@ type Claims struct {
 	Role               string `json:"role"`
 	HealthcareEntityID int    `json:"healthcare_entity_id"`
 	IsTempPassword     bool   `json:"is_temp_password"`
+	SessionID          int      `json:"session_id"`
+	MFA                bool     `json:"mfa"`
+	Permissions        []string `json:"permissions"`
+	APIKeyID           int      `json:"api_key_id,omitempty"` // Set on tokens exchanged for an API key
+}
+
+// UserSession represents a login session; its refresh tokens rotate on every refresh
+type UserSession struct {
+	ID                 int        `json:"id"`
+	UserID             int        `json:"user_id"`
+	HealthcareEntityID int        `json:"-"`
+	FamilyID           string     `json:"-"`
+	Device             string     `json:"device"` // User agent of the last login or refresh
+	IPAddress          string     `json:"ip_address"`
+	CreatedAt          time.Time  `json:"created_at"`
+	LastUsedAt         time.Time  `json:"last_used_at"`
+	ExpiresAt          time.Time  `json:"expires_at"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+	RevokeReason       string     `json:"revoke_reason,omitempty"`
+	MFAVerified        bool       `json:"mfa_verified"`
+	Current            bool       `json:"current"`
+}
+
+// MFAChallengeResponse is returned by login instead of tokens when a second factor is needed.
+// mfa_token is exchanged at /api/auth/mfa/verify, or at /api/auth/mfa/enroll when the user
+// must first enrol.
+type MFAChallengeResponse struct {
+	MFARequired           bool   `json:"mfa_required"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required"`
+	MFAToken              string `json:"mfa_token"`
+	ExpiresIn             int64  `json:"expires_in"`
+}
+
+// MFAEnrollmentResponse is the login response of a completed enrolment; the recovery codes
+// are only shown this once
+type MFAEnrollmentResponse struct {
+	AuthResponse
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAVerifyRequest completes a login with a TOTP code or a recovery code
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAEnrollRequest starts the enrolment required during login
+type MFAEnrollRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+// MFAEnrollConfirmRequest confirms the enrolment required during login with a first code
+type MFAEnrollConfirmRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// MFACodeRequest carries a TOTP code confirming an MFA change of a logged in user
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// MFAEnrollment is the secret of a pending enrolment, to be added to an authenticator app
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"` // Payload of the QR code
+	Issuer     string `json:"issuer"`
+	Account    string `json:"account"`
+}
+
+// MFAStatus describes the MFA state of a user
+type MFAStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	Required               bool       `json:"required"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// MFAPolicy lists the roles of an entity that must use MFA
+type MFAPolicy struct {
+	HealthcareEntityID int        `json:"healthcare_entity_id"`
+	RequiredRoles      []string   `json:"required_roles"` // Entity policy and service-wide roles
+	EntityRoles        []string   `json:"entity_roles"`   // Set by the entity's admins
+	UpdatedBy          *int       `json:"updated_by,omitempty"`
+	UpdatedAt          *time.Time `json:"updated_at,omitempty"`
+}
+
+// MFAPolicyRequest replaces the roles of an entity that must use MFA
+type MFAPolicyRequest struct {
+	RequiredRoles []string `json:"required_roles" validate:"dive,oneof=super_admin admin doctor nurse staff"`
+}
+
+// SecurityEvent is an entry of the security event log
+type SecurityEvent struct {
+	ID                 int64     `json:"id"`
+	EventType          string    `json:"event_type"`
+	UserID             *int      `json:"user_id,omitempty"`
+	HealthcareEntityID *int      `json:"healthcare_entity_id,omitempty"`
+	Email              string    `json:"email,omitempty"`
+	IPAddress          string    `json:"ip_address,omitempty"`
+	ActorID            *int      `json:"actor_id,omitempty"` // Admin who caused the event
+	Details            string    `json:"details,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// LoginLockStatus describes the failed logins of an account
+type LoginLockStatus struct {
+	Locked        bool       `json:"locked"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+	FailedCount   int        `json:"failed_count"`
+	LastFailedAt  *time.Time `json:"last_failed_at,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // Earlier attempts are refused
+}
+
+// CustomRole is a role defined by an entity admin as a set of permissions. Users keep their
+// built-in role, and get the custom role's permissions instead of the built-in role's.
+type CustomRole struct {
+	ID                 int       `json:"id"`
+	HealthcareEntityID int       `json:"healthcare_entity_id"`
+	Name               string    `json:"name"`
+	Description        string    `json:"description"`
+	Permissions        []string  `json:"permissions"`
+	UserCount          int       `json:"user_count"`
+	CreatedBy          *int      `json:"created_by,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// CustomRoleRequest creates or replaces a custom role
+type CustomRoleRequest struct {
+	Name        string   `json:"name" validate:"required,max=50"`
+	Description string   `json:"description" validate:"max=500"`
+	Permissions []string `json:"permissions" validate:"required,min=1"`
+}
+
+// AssignRoleRequest sets the custom role of a user; null goes back to the built-in role
+type AssignRoleRequest struct {
+	CustomRoleID *int `json:"custom_role_id"`
+}
+
+// UserPermissions describes where a user's permissions come from
+type UserPermissions struct {
+	UserID      int         `json:"user_id"`
+	Role        string      `json:"role"`
+	CustomRole  *CustomRole `json:"custom_role,omitempty"`
+	Permissions []string    `json:"permissions"`
+}
+
+// EntityMembership is an entity a user belongs to and their role there
+type EntityMembership struct {
+	HealthcareEntityID int       `json:"healthcare_entity_id"`
+	EntityName         string    `json:"entity_name"`
+	Role               string    `json:"role"`
+	CustomRoleID       *int      `json:"custom_role_id,omitempty"`
+	IsHome             bool      `json:"is_home"`    // The entity the user was created in
+	IsCurrent          bool      `json:"is_current"` // The entity of the caller's access token
+	JoinedAt           time.Time `json:"joined_at"`
+}
+
+// Membership is a user of another entity who also works at an entity
+type Membership struct {
+	UserID             int       `json:"user_id"`
+	HealthcareEntityID int       `json:"healthcare_entity_id"`
+	Email              string    `json:"email"`
+	FirstName          string    `json:"first_name"`
+	LastName           string    `json:"last_name"`
+	Specialization     string    `json:"specialization"`
+	HomeEntityID       int       `json:"home_entity_id"`
+	Role               string    `json:"role"`
+	CustomRoleID       *int      `json:"custom_role_id,omitempty"`
+	IsActive           bool      `json:"is_active"`
+	CreatedBy          *int      `json:"created_by,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// MembershipRequest adds an existing user of another entity to the admin's entity
+type MembershipRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=admin doctor nurse staff"`
+}
+
+// UpdateMembershipRequest changes the role of a member or suspends the membership
+type UpdateMembershipRequest struct {
+	Role     string `json:"role" validate:"required,oneof=admin doctor nurse staff"`
+	IsActive *bool  `json:"is_active"`
+}
+
+// SwitchEntityRequest asks for an access token scoped to another entity of the user
+type SwitchEntityRequest struct {
+	HealthcareEntityID int `json:"healthcare_entity_id" validate:"required"`
+}
+
+// Department is an organisational unit of an entity, such as cardiology. Departments form a
+// hierarchy through ParentID.
+type Department struct {
+	ID                 int       `json:"id"`
+	HealthcareEntityID int       `json:"healthcare_entity_id"`
+	ParentID           *int      `json:"parent_id"`
+	Name               string    `json:"name"`
+	Description        string    `json:"description"`
+	HeadUserID         *int      `json:"head_user_id"`
+	HeadName           string    `json:"head_name,omitempty"`
+	IsActive           bool      `json:"is_active"`
+	MemberCount        int       `json:"member_count"` // Direct members, sub-departments excluded
+	MemberIDs          []int     `json:"member_ids,omitempty"`
+	CreatedBy          *int      `json:"created_by,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// DepartmentRequest creates or replaces a department. IsActive is only read on updates.
+type DepartmentRequest struct {
+	Name        string `json:"name" validate:"required,min=2,max=100"`
+	Description string `json:"description" validate:"max=500"`
+	ParentID    *int   `json:"parent_id"`
+	HeadUserID  *int   `json:"head_user_id"`
+	IsActive    *bool  `json:"is_active"`
+}
+
+// DepartmentMember is a user assigned to a department, with their role in the entity
+type DepartmentMember struct {
+	UserID         int       `json:"user_id"`
+	DepartmentID   int       `json:"department_id"`
+	FirstName      string    `json:"first_name"`
+	LastName       string    `json:"last_name"`
+	Role           string    `json:"role"`
+	Specialization string    `json:"specialization"`
+	IsHead         bool      `json:"is_head"`
+	AssignedAt     time.Time `json:"assigned_at"`
+}
+
+// DepartmentMembersRequest assigns users of the entity to a department
+type DepartmentMembersRequest struct {
+	UserIDs []int `json:"user_ids" validate:"required,min=1,max=200,dive,min=1"`
+}
+
+// ServiceAccountRole is the role of service accounts, users that only authenticate with API keys
+const ServiceAccountRole = "service"
+
+// SuperAdminRole is the role of the platform operators listed in SUPER_ADMIN_EMAILS
+const SuperAdminRole = "super_admin"
+
+// ServiceAccount is a non-human user of an entity used by integrations
+type ServiceAccount struct {
+	ID                 int       `json:"id"` // ID of the account's user
+	HealthcareEntityID int       `json:"healthcare_entity_id"`
+	Name               string    `json:"name"`
+	Description        string    `json:"description"`
+	IsActive           bool      `json:"is_active"`
+	ActiveKeyCount     int       `json:"active_key_count"`
+	CreatedBy          *int      `json:"created_by,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// ServiceAccountRequest creates a service account
+type ServiceAccountRequest struct {
+	Name        string `json:"name" validate:"required,min=2,max=100"`
+	Description string `json:"description" validate:"max=500"`
+}
+
+// APIKey is an API key of a user or service account, scoped to one entity. The secret is only
+// returned when the key is created.
+type APIKey struct {
+	ID                 int        `json:"id"`
+	UserID             int        `json:"user_id"`
+	HealthcareEntityID int        `json:"healthcare_entity_id"`
+	Name               string     `json:"name"`
+	Prefix             string     `json:"prefix"` // Identifies the key in lists and logs
+	Permissions        []string   `json:"permissions"`
+	Status             string     `json:"status"` // active, expired or revoked
+	ExpiresAt          time.Time  `json:"expires_at"`
+	LastUsedAt         *time.Time `json:"last_used_at"`
+	LastUsedIP         string     `json:"last_used_ip,omitempty"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy          *int       `json:"created_by,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// APIKeyRequest creates an API key; it expires after 90 days unless expires_in_days is set
+type APIKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Permissions   []string `json:"permissions" validate:"required,min=1,dive,required"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+// CreatedAPIKeyResponse is the answer to a key creation, the only one carrying the full key
+type CreatedAPIKeyResponse struct {
+	*APIKey
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// APIKeyTokenResponse is a short-lived access token exchanged for an API key
+type APIKeyTokenResponse struct {
+	AccessToken        string   `json:"access_token"`
+	TokenType          string   `json:"token_type"`
+	ExpiresIn          int      `json:"expires_in"`
+	APIKeyID           int      `json:"api_key_id"`
+	UserID             int      `json:"user_id"`
+	HealthcareEntityID int      `json:"healthcare_entity_id"`
+	Permissions        []string `json:"permissions"`
 }
 
 // AdminCreateDoctorRequest represents admin request to create a doctor
@@ -89,12 +395,66 @@ type AdminCreateDoctorRequest struct {
 	PreferredLocale   string `json:"preferred_locale" validate:"oneof=en-US en-CA fr-CA fr-FR ar-MA"`
 }
 
-// AdminCreateDoctorResponse represents response after creating a doctor
-type AdminCreateDoctorResponse struct {
-	Doctor          UserResponse `json:"doctor"`
-	TempPassword    string       `json:"temp_password"`
-	PasswordExpires time.Time    `json:"password_expires"`
-	Message         string       `json:"message"`
+// Invitation is an invitation to join a healthcare entity with a role
+type Invitation struct {
+	ID                 int        `json:"id"`
+	HealthcareEntityID int        `json:"healthcare_entity_id"`
+	Email              string     `json:"email"`
+	Role               string     `json:"role"`
+	FirstName          string     `json:"first_name"`
+	LastName           string     `json:"last_name"`
+	LicenseNumber      string     `json:"license_number"`
+	Specialization     string     `json:"specialization"`
+	PreferredLocale    string     `json:"preferred_locale"`
+	Status             string     `json:"status"` // pending, accepted, revoked or expired
+	ExpiresAt          time.Time  `json:"expires_at"`
+	SentCount          int        `json:"sent_count"`
+	LastSentAt         time.Time  `json:"last_sent_at"`
+	InvitedBy          *int       `json:"invited_by,omitempty"`
+	AcceptedAt         *time.Time `json:"accepted_at,omitempty"`
+	AcceptedUserID     *int       `json:"accepted_user_id,omitempty"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy          *int       `json:"revoked_by,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// InvitationRequest represents an admin request to invite someone to the admin's entity
+type InvitationRequest struct {
+	Email           string `json:"email" validate:"required,email"`
+	Role            string `json:"role" validate:"required,oneof=admin doctor nurse staff"`
+	FirstName       string `json:"first_name"`
+	LastName        string `json:"last_name"`
+	LicenseNumber   string `json:"license_number"`
+	Specialization  string `json:"specialization"`
+	PreferredLocale string `json:"preferred_locale" validate:"omitempty,oneof=en-US en-CA fr-CA fr-FR ar-MA"`
+}
+
+// InvitationResponse is returned when an invitation is created or resent. The token is only
+// shown then; it is what the invitee's link carries.
+type InvitationResponse struct {
+	Invitation    *Invitation `json:"invitation"`
+	Token         string      `json:"token"`
+	InvitationURL string      `json:"invitation_url,omitempty"`
+	Message       string      `json:"message"`
+}
+
+// InvitationDetails is what the invitee sees before accepting
+type InvitationDetails struct {
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	EntityName string    `json:"healthcare_entity_name"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// AcceptInvitationRequest creates the invitee's account
+type AcceptInvitationRequest struct {
+	Token     string `json:"token" validate:"required"`
+	Password  string `json:"password" validate:"required,min=8"`
+	FirstName string `json:"first_name"` // Defaults to the invitation's
+	LastName  string `json:"last_name"`  // Defaults to the invitation's
 }
 
 // PasswordChangeRequest represents password change request
@@ -103,6 +463,45 @@ type PasswordChangeRequest struct {
 	NewPassword     string `json:"new_password" validate:"required,min=8"`
 }
 
+// ForgotPasswordRequest asks for a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ResetPasswordRequest sets a new password with an emailed reset token
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8"`
+}
+
+// PasswordPolicy holds the password rules of an entity
+type PasswordPolicy struct {
+	HealthcareEntityID int        `json:"healthcare_entity_id"`
+	MinLength          int        `json:"min_length"`
+	RequireUppercase   bool       `json:"require_uppercase"`
+	RequireLowercase   bool       `json:"require_lowercase"`
+	RequireDigit       bool       `json:"require_digit"`
+	RequireSymbol      bool       `json:"require_symbol"`
+	CheckBreached      bool       `json:"check_breached"` // Reject passwords of the breached password list
+	HistoryCount       int        `json:"history_count"`  // Last N passwords that can't be reused (0 for none)
+	MaxAgeDays         int        `json:"max_age_days"`   // Days before a password expires (0 for never)
+	IsDefault          bool       `json:"is_default"`     // The entity has no policy of its own
+	UpdatedBy          *int       `json:"updated_by,omitempty"`
+	UpdatedAt          *time.Time `json:"updated_at,omitempty"`
+}
+
+// PasswordPolicyRequest replaces the password policy of an entity
+type PasswordPolicyRequest struct {
+	MinLength        int  `json:"min_length" validate:"min=8,max=128"`
+	RequireUppercase bool `json:"require_uppercase"`
+	RequireLowercase bool `json:"require_lowercase"`
+	RequireDigit     bool `json:"require_digit"`
+	RequireSymbol    bool `json:"require_symbol"`
+	CheckBreached    bool `json:"check_breached"`
+	HistoryCount     int  `json:"history_count" validate:"min=0,max=24"`
+	MaxAgeDays       int  `json:"max_age_days" validate:"min=0,max=3650"`
+}
+
 // ToUserResponse converts User to UserResponse
 func (u *User) ToUserResponse() UserResponse {
 	return UserResponse{
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// respondPasswordError maps password service errors to HTTP responses
+func respondPasswordError(c *gin.Context, err error, operation string) {
+	var policyErr *PasswordPolicyError
+	switch {
+	case errors.As(err, &policyErr):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Password does not meet the password policy",
+			"code":       "PASSWORD_POLICY",
+			"violations": policyErr.Violations,
+		})
+	case errors.Is(err, ErrInvalidCurrentPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+	case errors.Is(err, ErrInvalidResetToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+	default:
+		logging.LogError("Password operation failed", "operation", operation, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// ForgotPassword handles POST /api/auth/password/forgot - emails a reset link. The answer is
+// the same whether or not the email belongs to an account.
+func (h *UserHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.passwordService.RequestReset(req.Email, c.ClientIP()); err != nil {
+		logging.LogError("Failed to send password reset", "error", err, "ip_address", c.ClientIP())
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email belongs to an account, a reset link has been sent"})
+}
+
+// ResetPassword handles POST /api/auth/password/reset - sets a new password with an emailed
+// token and logs the user out everywhere
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := h.passwordService.ResetPassword(req.Token, req.NewPassword)
+	if err != nil {
+		respondPasswordError(c, err, "reset password")
+		return
+	}
+
+	// Whoever knew the old password may still have a session
+	if _, err := h.sessionService.RevokeUserSessions(userID, 0, userID, "password_reset"); err != nil {
+		logging.LogError("Failed to revoke sessions after password reset", "error", err, "user_id", userID)
+	}
+	// The reset proved access to the mailbox, which lifts a lockout
+	if user, err := h.userService.GetUserByID(userID); err == nil {
+		if err := h.loginGuard.Unlock(user, nil, c.ClientIP(), "password_reset"); err != nil {
+			logging.LogError("Failed to unlock account after password reset", "error", err, "user_id", userID)
+		}
+	}
+
+	logging.LogInfo("Password reset", "user_id", userID, "ip_address", c.ClientIP())
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully, log in with the new password"})
+}
+
+// GetPasswordPolicy handles GET /api/entities/:id/password-policy - password rules of the
+// caller's entity
+func (h *UserHandler) GetPasswordPolicy(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userClaims := claims.(*Claims)
+
+	entityID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entity ID"})
+		return
+	}
+	if entityID != userClaims.HealthcareEntityID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this healthcare entity"})
+		return
+	}
+
+	policy, err := h.passwordService.GetPolicy(entityID)
+	if err != nil {
+		respondPasswordError(c, err, "get password policy")
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// UpdatePasswordPolicy handles PUT /api/entities/:id/password-policy - Admin replaces the
+// password rules of their entity
+func (h *UserHandler) UpdatePasswordPolicy(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userClaims := claims.(*Claims)
+
+	entityID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entity ID"})
+		return
+	}
+	if entityID != userClaims.HealthcareEntityID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this healthcare entity"})
+		return
+	}
+
+	var req PasswordPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := h.passwordService.UpdatePolicy(entityID, &req, userClaims.UserID)
+	if err != nil {
+		respondPasswordError(c, err, "update password policy")
+		return
+	}
+
+	logging.LogInfo("Password policy updated", "healthcare_entity_id", entityID, "updated_by", userClaims.UserID)
+	c.JSON(http.StatusOK, policy)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	logging "github.com/louhibi/healthcare-logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTTL is how long an emailed reset link can be used
+const passwordResetTTL = time.Hour
+
+// passwordResetInterval is the minimum time between two reset emails to the same user
+const passwordResetInterval = time.Minute
+
+// passwordHistoryLimit is how many previous passwords are kept per user
+const passwordHistoryLimit = 24
+
+var (
+	ErrInvalidCurrentPassword = errors.New("invalid current password")
+	ErrInvalidResetToken      = errors.New("invalid or expired reset token")
+)
+
+// PasswordPolicyError lists the rules of the password policy a new password breaks
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy: " + strings.Join(e.Violations, "; ")
+}
+
+// PasswordService enforces password policies and handles password changes and resets
+type PasswordService struct {
+	db       *sql.DB
+	breached BreachedPasswordChecker // nil when there is no breached password list
+	mailer   MailSender
+	// resetURL is the frontend page reset tokens are appended to (PASSWORD_RESET_URL)
+	resetURL string
+}
+
+func NewPasswordService(db *sql.DB, breached BreachedPasswordChecker, mailer MailSender) *PasswordService {
+	return &PasswordService{db: db, breached: breached, mailer: mailer, resetURL: os.Getenv("PASSWORD_RESET_URL")}
+}
+
+// defaultPasswordPolicy applies to entities without a policy of their own
+func defaultPasswordPolicy(entityID int) *PasswordPolicy {
+	return &PasswordPolicy{
+		HealthcareEntityID: entityID,
+		MinLength:          10,
+		RequireUppercase:   true,
+		RequireLowercase:   true,
+		RequireDigit:       true,
+		CheckBreached:      true,
+		HistoryCount:       5,
+		IsDefault:          true,
+	}
+}
+
+// GetPolicy returns the password policy of an entity
+func (s *PasswordService) GetPolicy(entityID int) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{HealthcareEntityID: entityID}
+	var updatedAt time.Time
+	var updatedBy sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT min_length, require_uppercase, require_lowercase, require_digit, require_symbol,
+		       check_breached, history_count, max_age_days, updated_by, updated_at
+		FROM password_policies WHERE healthcare_entity_id = $1
+	`, entityID).Scan(&policy.MinLength, &policy.RequireUppercase, &policy.RequireLowercase, &policy.RequireDigit,
+		&policy.RequireSymbol, &policy.CheckBreached, &policy.HistoryCount, &policy.MaxAgeDays, &updatedBy, &updatedAt)
+	if err == sql.ErrNoRows {
+		return defaultPasswordPolicy(entityID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.UpdatedAt = &updatedAt
+	policy.UpdatedBy = nullIntPtr(updatedBy)
+	return policy, nil
+}
+
+// UpdatePolicy replaces the password policy of an entity. Passwords already set are only
+// checked against it when they expire.
+func (s *PasswordService) UpdatePolicy(entityID int, req *PasswordPolicyRequest, updatedBy int) (*PasswordPolicy, error) {
+	_, err := s.db.Exec(`
+		INSERT INTO password_policies (
+			healthcare_entity_id, min_length, require_uppercase, require_lowercase, require_digit, require_symbol,
+			check_breached, history_count, max_age_days, updated_by, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_TIMESTAMP)
+		ON CONFLICT (healthcare_entity_id) DO UPDATE
+		SET min_length = EXCLUDED.min_length, require_uppercase = EXCLUDED.require_uppercase,
+		    require_lowercase = EXCLUDED.require_lowercase, require_digit = EXCLUDED.require_digit,
+		    require_symbol = EXCLUDED.require_symbol, check_breached = EXCLUDED.check_breached,
+		    history_count = EXCLUDED.history_count, max_age_days = EXCLUDED.max_age_days,
+		    updated_by = EXCLUDED.updated_by, updated_at = CURRENT_TIMESTAMP
+	`, entityID, req.MinLength, req.RequireUppercase, req.RequireLowercase, req.RequireDigit, req.RequireSymbol,
+		req.CheckBreached, req.HistoryCount, req.MaxAgeDays, updatedBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update password policy: %v", err)
+	}
+	return s.GetPolicy(entityID)
+}
+
+// PasswordExpiresAt returns when the user's password expires under the policy of their
+// entity, or nil when it doesn't expire
+func (s *PasswordService) PasswordExpiresAt(user *User) (*time.Time, error) {
+	policy, err := s.GetPolicy(user.HealthcareEntityID)
+	if err != nil {
+		return nil, err
+	}
+	if policy.MaxAgeDays == 0 {
+		return nil, nil
+	}
+	expiresAt := user.PasswordChangedAt.AddDate(0, 0, policy.MaxAgeDays)
+	return &expiresAt, nil
+}
+
+// IsExpired reports whether the user's password is older than their entity's policy allows
+func (s *PasswordService) IsExpired(user *User) (bool, error) {
+	expiresAt, err := s.PasswordExpiresAt(user)
+	if err != nil || expiresAt == nil {
+		return false, err
+	}
+	return time.Now().After(*expiresAt), nil
+}
+
+// ValidateNewPassword checks a password against the policy of the user's entity and, for
+// existing users, against their recent passwords. Broken rules are returned as a
+// *PasswordPolicyError.
+func (s *PasswordService) ValidateNewPassword(user *User, password string) error {
+	policy, err := s.GetPolicy(user.HealthcareEntityID)
+	if err != nil {
+		return err
+	}
+
+	var violations []string
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", policy.MinLength))
+	}
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUppercase && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if policy.RequireLowercase && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+	if policy.CheckBreached && s.breached != nil {
+		breached, err := s.breached.IsBreached(password)
+		if err != nil {
+			// An unreadable list must not block every password change
+			logging.LogError("Failed to check breached password list", "error", err)
+		} else if breached {
+			violations = append(violations, "appears in a list of breached passwords")
+		}
+	}
+	if user.ID != 0 && policy.HistoryCount > 0 {
+		reused, err := s.isRecentPassword(user.ID, password, policy.HistoryCount)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, fmt.Sprintf("must not be one of the last %d passwords", policy.HistoryCount))
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// isRecentPassword reports whether a password is the user's current one or one of the
+// count-1 passwords before it
+func (s *PasswordService) isRecentPassword(userID int, password string, count int) (bool, error) {
+	rows, err := s.db.Query(`
+		(SELECT password_hash FROM users WHERE id = $1)
+		UNION ALL
+		(SELECT password_hash FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2)
+	`, userID, count-1)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return false, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// setPassword replaces the user's password, keeping the previous one in the history
+func setPassword(tx *sql.Tx, userID int, passwordHash string) error {
+	_, err := tx.Exec(`
+		INSERT INTO password_history (user_id, password_hash)
+		SELECT id, password_hash FROM users WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to store password history: %v", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE users
+		SET password_hash = $2, password_changed_at = CURRENT_TIMESTAMP, is_temp_password = false,
+		    temp_password_expires = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, userID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+	_, err = tx.Exec(`
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2
+		)
+	`, userID, passwordHistoryLimit)
+	return err
+}
+
+// ChangePassword replaces the password of a user who knows the current one
+func (s *PasswordService) ChangePassword(user *User, currentPassword, newPassword string) error {
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)) != nil {
+		return ErrInvalidCurrentPassword
+	}
+	if err := s.ValidateNewPassword(user, newPassword); err != nil {
+		return err
+	}
+	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash new password: %v", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := setPassword(tx, user.ID, string(newHash)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// newResetToken returns a random reset token and the hash it is stored as
+func newResetToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate reset token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestReset emails a reset link to the user. Links sent before stop working. Unknown or
+// inactive emails are ignored, so callers can't learn which accounts exist.
+func (s *PasswordService) RequestReset(email, ipAddress string) error {
+	var userID int
+	var firstName string
+	var lastRequest sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT u.id, u.first_name,
+		       (SELECT MAX(created_at) FROM password_reset_tokens WHERE user_id = u.id)
+		FROM users u
+		WHERE u.email = $1 AND u.is_active = true AND u.role <> 'service'
+	`, email).Scan(&userID, &firstName, &lastRequest)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if lastRequest.Valid && time.Since(lastRequest.Time) < passwordResetInterval {
+		logging.LogWarn("Password reset requested too often", "user_id", userID, "ip_address", ipAddress)
+		return nil
+	}
+
+	token, tokenHash, err := newResetToken()
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		UPDATE password_reset_tokens SET expires_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, requested_ip)
+		VALUES ($1, $2, $3, $4)
+	`, userID, tokenHash, time.Now().Add(passwordResetTTL), ipAddress)
+	if err != nil {
+		return fmt.Errorf("failed to store reset token: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	link := token
+	if s.resetURL != "" {
+		link = s.resetURL + "?token=" + url.QueryEscape(token)
+	}
+	return s.mailer.Send(MailMessage{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nUse this link to choose a new password:\n\n%s\n\n"+
+			"The link can be used once and expires in %d minutes. If you didn't ask for a password reset, "+
+			"you can ignore this email.\n", firstName, link, int(passwordResetTTL.Minutes())),
+	})
+}
+
+// ResetPassword sets a new password with a reset token and uses the token up. Returns the
+// ID of the user.
+func (s *PasswordService) ResetPassword(token, newPassword string) (int, error) {
+	var tokenID int
+	user := &User{}
+	err := s.db.QueryRow(`
+		SELECT t.id, u.id, u.healthcare_entity_id
+		FROM password_reset_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP AND u.is_active = true
+	`, hashResetToken(token)).Scan(&tokenID, &user.ID, &user.HealthcareEntityID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidResetToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := s.ValidateNewPassword(user, newPassword); err != nil {
+		return 0, err
+	}
+	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash new password: %v", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// Only one of concurrent resets with the same token gets to use it
+	result, err := tx.Exec(`
+		UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	`, tokenID)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, ErrInvalidResetToken
+	}
+	if err := setPassword(tx, user.ID, string(newHash)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+	rbac "github.com/louhibi/healthcare-rbac"
+)
+
+// respondRoleError maps role service errors to HTTP responses
+func respondRoleError(c *gin.Context, err error, operation string) {
+	switch {
+	case errors.Is(err, ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+	case errors.Is(err, ErrRoleNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists"})
+	case errors.Is(err, ErrRoleInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "Role is assigned to users, assign them another role first"})
+	case errors.Is(err, ErrBuiltinRoleName):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Custom roles can't use the name of a built-in role"})
+	case errors.Is(err, ErrUnknownPermissions):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logging.LogError("Role operation failed", "operation", operation, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + operation})
+	}
+}
+
+// grantable checks the caller holds every permission they grant, so that managing roles
+// doesn't raise one's own permissions. On failure it writes the 403 response.
+func grantable(c *gin.Context, permissions []string) bool {
+	for _, permission := range permissions {
+		if rbac.IsKnown(permission) && !rbac.Has(c, permission) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":      "You can't grant a permission you don't have",
+				"code":       "PERMISSION_DENIED",
+				"permission": permission,
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// grantableRole checks the caller can give someone a built-in role. Admins don't hold the
+// clinical note permissions, but users with user.manage can still assign the clinical built-in
+// roles that carry them; custom roles and API keys only get them from callers who hold them.
+func grantableRole(c *gin.Context, role string) bool {
+	permissions := rbac.RolePermissions(role)
+	if rbac.Has(c, rbac.UserManage) {
+		kept := make([]string, 0, len(permissions))
+		for _, permission := range permissions {
+			if !rbac.IsClinicalNote(permission) {
+				kept = append(kept, permission)
+			}
+		}
+		permissions = kept
+	}
+	return grantable(c, permissions)
+}
+
+// roleID reads the :id route parameter of role routes
+func roleID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// GetPermissionCatalog handles GET /api/permissions - every permission and the permissions of
+// the built-in roles
+func (h *UserHandler) GetPermissionCatalog(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"permissions":   rbac.Catalog,
+		"builtin_roles": rbac.BuiltinRoles,
+	})
+}
+
+// GetMyPermissions handles GET /api/auth/permissions - the caller's current permissions, which
+// may be newer than those of their access token
+func (h *UserHandler) GetMyPermissions(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.GetUserByID(userClaims.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	permissions, err := h.roleService.UserPermissions(user)
+	if err != nil {
+		respondRoleError(c, err, "get permissions")
+		return
+	}
+	c.JSON(http.StatusOK, permissions)
+}
+
+// GetRoles handles GET /api/roles - custom roles of the caller's entity
+func (h *UserHandler) GetRoles(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	roles, err := h.roleService.ListRoles(userClaims.HealthcareEntityID)
+	if err != nil {
+		respondRoleError(c, err, "get roles")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// GetRole handles GET /api/roles/:id
+func (h *UserHandler) GetRole(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := roleID(c)
+	if !ok {
+		return
+	}
+
+	role, err := h.roleService.GetRole(userClaims.HealthcareEntityID, id)
+	if err != nil {
+		respondRoleError(c, err, "get role")
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// CreateRole handles POST /api/roles - defines a custom role of the caller's entity
+func (h *UserHandler) CreateRole(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	var req CustomRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !grantable(c, req.Permissions) {
+		return
+	}
+
+	role, err := h.roleService.CreateRole(userClaims.HealthcareEntityID, &req, userClaims.UserID)
+	if err != nil {
+		respondRoleError(c, err, "create role")
+		return
+	}
+
+	logging.LogInfo("Custom role created", "role_id", role.ID, "healthcare_entity_id", role.HealthcareEntityID,
+		"created_by", userClaims.UserID)
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole handles PUT /api/roles/:id - replaces a custom role. Its users get the new
+// permissions when their access token is next refreshed.
+func (h *UserHandler) UpdateRole(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := roleID(c)
+	if !ok {
+		return
+	}
+
+	var req CustomRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !grantable(c, req.Permissions) {
+		return
+	}
+
+	role, err := h.roleService.UpdateRole(userClaims.HealthcareEntityID, id, &req)
+	if err != nil {
+		respondRoleError(c, err, "update role")
+		return
+	}
+
+	logging.LogInfo("Custom role updated", "role_id", role.ID, "updated_by", userClaims.UserID)
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole handles DELETE /api/roles/:id - removes a custom role no user has
+func (h *UserHandler) DeleteRole(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+	id, ok := roleID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.roleService.DeleteRole(userClaims.HealthcareEntityID, id); err != nil {
+		respondRoleError(c, err, "delete role")
+		return
+	}
+
+	logging.LogInfo("Custom role deleted", "role_id", id, "deleted_by", userClaims.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// GetUserPermissions handles GET /api/users/:id/permissions - a user's role and permissions
+func (h *UserHandler) GetUserPermissions(c *gin.Context) {
+	_, user, ok := h.loadEntityMember(c)
+	if !ok {
+		return
+	}
+
+	permissions, err := h.roleService.UserPermissions(user)
+	if err != nil {
+		respondRoleError(c, err, "get permissions")
+		return
+	}
+	c.JSON(http.StatusOK, permissions)
+}
+
+// AssignUserRole handles PUT /api/users/:id/role - sets or clears the custom role of a user
+func (h *UserHandler) AssignUserRole(c *gin.Context) {
+	userClaims, user, ok := h.loadEntityMember(c)
+	if !ok {
+		return
+	}
+
+	var req AssignRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if user.ID == userClaims.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't change your own role"})
+		return
+	}
+
+	// The permissions the user ends up with have to be grantable by the caller
+	if req.CustomRoleID == nil {
+		if !grantableRole(c, user.Role) {
+			return
+		}
+	} else {
+		role, err := h.roleService.GetRole(userClaims.HealthcareEntityID, *req.CustomRoleID)
+		if err != nil {
+			respondRoleError(c, err, "assign role")
+			return
+		}
+		if !grantable(c, role.Permissions) {
+			return
+		}
+	}
+
+	if err := h.roleService.AssignRole(user, req.CustomRoleID); err != nil {
+		respondRoleError(c, err, "assign role")
+		return
+	}
+	permissions, err := h.roleService.UserPermissions(user)
+	if err != nil {
+		respondRoleError(c, err, "get permissions")
+		return
+	}
+
+	logging.LogInfo("User role assigned", "user_id", user.ID, "custom_role_id", req.CustomRoleID,
+		"assigned_by", userClaims.UserID)
+	c.JSON(http.StatusOK, permissions)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	rbac "github.com/louhibi/healthcare-rbac"
+)
+
+// testContext returns a gin context whose caller holds the permissions
+func testContext(permissions []string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Set(rbac.ContextKey, permissions)
+	return c, w
+}
+
+func TestGrantable(t *testing.T) {
+	admin := rbac.RolePermissions("admin")
+	tests := []struct {
+		name        string
+		held        []string
+		permissions []string
+		want        bool
+	}{
+		{"held permissions", admin, []string{rbac.PatientRead, rbac.UserManage}, true},
+		{"nothing to grant", nil, nil, true},
+		{"unknown permissions are ignored", nil, []string{"not.a.permission"}, true},
+		{"permission not held", rbac.RolePermissions("nurse"), []string{rbac.UserManage}, false},
+		{"admin can't grant platform.manage", admin, []string{rbac.PlatformManage}, false},
+		{"admin can't grant clinical notes", admin, []string{rbac.EncounterNoteRead}, false},
+		{"doctor can grant clinical notes", rbac.RolePermissions("doctor"), []string{rbac.EncounterNoteRead}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := testContext(tt.held)
+			if got := grantable(c, tt.permissions); got != tt.want {
+				t.Fatalf("grantable = %v, want %v", got, tt.want)
+			}
+			if !tt.want && w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", w.Code)
+			}
+		})
+	}
+}
+
+func TestGrantableRole(t *testing.T) {
+	tests := []struct {
+		name string
+		held []string
+		role string
+		want bool
+	}{
+		{"admin assigns doctor", rbac.RolePermissions("admin"), "doctor", true},
+		{"admin assigns nurse", rbac.RolePermissions("admin"), "nurse", true},
+		{"admin assigns admin", rbac.RolePermissions("admin"), "admin", true},
+		{"admin can't assign super_admin", rbac.RolePermissions("admin"), "super_admin", false},
+		{"clinical notes need user.manage", without(rbac.RolePermissions("admin"), rbac.UserManage), "doctor", false},
+		{"nurse can't assign doctor", rbac.RolePermissions("nurse"), "doctor", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testContext(tt.held)
+			if got := grantableRole(c, tt.role); got != tt.want {
+				t.Errorf("grantableRole(%q) = %v, want %v", tt.role, got, tt.want)
+			}
+		})
+	}
+}
+
+// without returns permissions minus the excluded ones
+func without(permissions []string, excluded ...string) []string {
+	kept := []string{}
+	for _, permission := range permissions {
+		skip := false
+		for _, name := range excluded {
+			skip = skip || permission == name
+		}
+		if !skip {
+			kept = append(kept, permission)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	rbac "github.com/louhibi/healthcare-rbac"
+)
+
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleNameTaken      = errors.New("a role with this name already exists")
+	ErrRoleInUse          = errors.New("role is assigned to users")
+	ErrBuiltinRoleName    = errors.New("custom roles can't use the name of a built-in role")
+	ErrUnknownPermissions = errors.New("unknown permissions")
+)
+
+// RoleService manages the custom roles of entities and resolves the permissions of users
+type RoleService struct {
+	db *sql.DB
+}
+
+func NewRoleService(db *sql.DB) *RoleService {
+	return &RoleService{db: db}
+}
+
+const customRoleColumns = `r.id, r.healthcare_entity_id, r.name, r.description, r.permissions, r.created_by,
+	r.created_at, r.updated_at, (SELECT COUNT(*) FROM entity_members em JOIN users u ON u.id = em.user_id
+		WHERE em.custom_role_id = r.id AND em.healthcare_entity_id = r.healthcare_entity_id AND u.is_active = true)`
+
+func scanCustomRole(row rowScanner) (*CustomRole, error) {
+	role := &CustomRole{}
+	var createdBy sql.NullInt64
+	err := row.Scan(&role.ID, &role.HealthcareEntityID, &role.Name, &role.Description, pq.Array(&role.Permissions),
+		&createdBy, &role.CreatedAt, &role.UpdatedAt, &role.UserCount)
+	if err != nil {
+		return nil, err
+	}
+	role.CreatedBy = nullIntPtr(createdBy)
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	return role, nil
+}
+
+// validateRole normalizes the request and checks its name and permissions
+func validateRole(req *CustomRoleRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	// The service account role is reserved too: the api-gateway exempts it from the MFA policy
+	if _, builtin := rbac.BuiltinRoles[strings.ToLower(req.Name)]; builtin || strings.EqualFold(req.Name, ServiceAccountRole) {
+		return ErrBuiltinRoleName
+	}
+	req.Permissions = rbac.Normalize(req.Permissions)
+	var unknown []string
+	for _, permission := range req.Permissions {
+		if !rbac.IsKnown(permission) {
+			unknown = append(unknown, permission)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownPermissions, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// isRoleNameConflict reports whether err violates the unique role name per entity
+func isRoleNameConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// ListRoles returns the custom roles of an entity by name
+func (s *RoleService) ListRoles(entityID int) ([]CustomRole, error) {
+	rows, err := s.db.Query(`SELECT `+customRoleColumns+` FROM custom_roles r
+		WHERE r.healthcare_entity_id = $1 ORDER BY r.name`, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []CustomRole{}
+	for rows.Next() {
+		role, err := scanCustomRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+	return roles, rows.Err()
+}
+
+// GetRole returns a custom role of the entity
+func (s *RoleService) GetRole(entityID, roleID int) (*CustomRole, error) {
+	role, err := scanCustomRole(s.db.QueryRow(`SELECT `+customRoleColumns+` FROM custom_roles r
+		WHERE r.id = $1 AND r.healthcare_entity_id = $2`, roleID, entityID))
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	return role, err
+}
+
+// CreateRole adds a custom role to the entity
+func (s *RoleService) CreateRole(entityID int, req *CustomRoleRequest, createdBy int) (*CustomRole, error) {
+	if err := validateRole(req); err != nil {
+		return nil, err
+	}
+	var roleID int
+	err := s.db.QueryRow(`
+		INSERT INTO custom_roles (healthcare_entity_id, name, description, permissions, created_by)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id
+	`, entityID, req.Name, req.Description, pq.Array(req.Permissions), createdBy).Scan(&roleID)
+	if isRoleNameConflict(err) {
+		return nil, ErrRoleNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRole(entityID, roleID)
+}
+
+// UpdateRole replaces the name, description and permissions of a custom role. Its users get
+// the new permissions with their next access token.
+func (s *RoleService) UpdateRole(entityID, roleID int, req *CustomRoleRequest) (*CustomRole, error) {
+	if err := validateRole(req); err != nil {
+		return nil, err
+	}
+	result, err := s.db.Exec(`
+		UPDATE custom_roles SET name = $3, description = $4, permissions = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND healthcare_entity_id = $2
+	`, roleID, entityID, req.Name, req.Description, pq.Array(req.Permissions))
+	if isRoleNameConflict(err) {
+		return nil, ErrRoleNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrRoleNotFound
+	}
+	return s.GetRole(entityID, roleID)
+}
+
+// DeleteRole removes a custom role that no user or suspended member has
+func (s *RoleService) DeleteRole(entityID, roleID int) error {
+	var inUse bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users WHERE custom_role_id = $1)
+		    OR EXISTS(SELECT 1 FROM user_entity_memberships WHERE custom_role_id = $1)`, roleID).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrRoleInUse
+	}
+	result, err := s.db.Exec(`DELETE FROM custom_roles WHERE id = $1 AND healthcare_entity_id = $2`, roleID, entityID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+// AssignRole sets the custom role of a user in the entity they are scoped to, or clears it
+// when roleID is nil
+func (s *RoleService) AssignRole(user *User, roleID *int) error {
+	if roleID != nil {
+		if _, err := s.GetRole(user.HealthcareEntityID, *roleID); err != nil {
+			return err
+		}
+	}
+	// The home entity's role is on the users row, the others on the memberships
+	_, err := s.db.Exec(`UPDATE users SET custom_role_id = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND healthcare_entity_id = $2`, user.ID, user.HealthcareEntityID, roleID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE user_entity_memberships SET custom_role_id = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND healthcare_entity_id = $2`, user.ID, user.HealthcareEntityID, roleID)
+	return err
+}
+
+// UserPermissions returns the permissions of a user in the entity they are scoped to: those
+// of their custom role there when they have one, else those of their built-in role
+func (s *RoleService) UserPermissions(user *User) (*UserPermissions, error) {
+	permissions := &UserPermissions{UserID: user.ID, Role: user.Role}
+	var roleID sql.NullInt64
+	err := s.db.QueryRow(`SELECT custom_role_id FROM entity_members WHERE user_id = $1 AND healthcare_entity_id = $2`,
+		user.ID, user.HealthcareEntityID).Scan(&roleID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if roleID.Valid {
+		role, err := s.GetRole(user.HealthcareEntityID, int(roleID.Int64))
+		if err != nil {
+			return nil, err
+		}
+		permissions.CustomRole = role
+		permissions.Permissions = role.Permissions
+		return permissions, nil
+	}
+	permissions.Permissions = rbac.Normalize(rbac.RolePermissions(user.Role))
+	return permissions, nil
+}
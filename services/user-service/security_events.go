@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Security event types
+const (
+	SecurityEventLoginFailed     = "login_failed"
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventIPLocked        = "ip_locked"
+	SecurityEventAccountUnlocked = "account_unlocked"
+)
+
+// SecurityEventLog stores security relevant events for admins to review
+type SecurityEventLog struct {
+	db *sql.DB
+}
+
+func NewSecurityEventLog(db *sql.DB) *SecurityEventLog {
+	return &SecurityEventLog{db: db}
+}
+
+// Record adds an event. user, when known, sets the event's user and entity.
+func (l *SecurityEventLog) Record(exec dbExecutor, eventType string, user *User, email, ipAddress string, actorID *int, details string) error {
+	var userID, entityID *int
+	if user != nil {
+		userID = &user.ID
+		entityID = &user.HealthcareEntityID
+	}
+	_, err := exec.Exec(`
+		INSERT INTO security_events (event_type, user_id, healthcare_entity_id, email, ip_address, actor_id, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, eventType, userID, entityID, email, ipAddress, actorID, details)
+	if err != nil {
+		return fmt.Errorf("failed to record security event: %v", err)
+	}
+	return nil
+}
+
+// List returns the events of an entity's users, newest first, optionally of one type or user
+func (l *SecurityEventLog) List(entityID int, eventType string, userID, limit, offset int) ([]SecurityEvent, error) {
+	query := `SELECT id, event_type, user_id, healthcare_entity_id, email, ip_address, actor_id, details, created_at
+		FROM security_events WHERE healthcare_entity_id = $1`
+	args := []interface{}{entityID}
+	if eventType != "" {
+		args = append(args, eventType)
+		query += fmt.Sprintf(" AND event_type = $%d", len(args))
+	}
+	if userID != 0 {
+		args = append(args, userID)
+		query += fmt.Sprintf(" AND user_id = $%d", len(args))
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []SecurityEvent{}
+	for rows.Next() {
+		var event SecurityEvent
+		var eventUserID, eventEntityID, actorID sql.NullInt64
+		err := rows.Scan(&event.ID, &event.EventType, &eventUserID, &eventEntityID, &event.Email, &event.IPAddress,
+			&actorID, &event.Details, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.UserID = nullIntPtr(eventUserID)
+		event.HealthcareEntityID = nullIntPtr(eventEntityID)
+		event.ActorID = nullIntPtr(actorID)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// respondLoginBlocked answers a login refused by the login guard. The answer is the same for
+// accounts that don't exist.
+func respondLoginBlocked(c *gin.Context, retryAfter time.Duration) {
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts, try again later",
+		"code":        "TOO_MANY_ATTEMPTS",
+		"retry_after": seconds,
+	})
+}
+
+// GetSecurityEvents handles GET /api/security-events - Admin reviews the security events of
+// their entity's users, filtered by ?event_type= and ?user_id=
+func (h *UserHandler) GetSecurityEvents(c *gin.Context) {
+	userClaims, ok := callerClaims(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	userID := 0
+	if value := c.Query("user_id"); value != "" {
+		if userID, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+	}
+
+	events, err := h.securityEvents.List(userClaims.HealthcareEntityID, c.Query("event_type"), userID, limit, offset)
+	if err != nil {
+		logging.LogError("Failed to get security events", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get security events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events, "limit": limit, "offset": offset})
+}
+
+// AdminGetUserLockout handles GET /api/users/:id/lockout - failed logins and lock of a user
+func (h *UserHandler) AdminGetUserLockout(c *gin.Context) {
+	_, user, ok := h.loadEntityUser(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.loginGuard.Status(user.Email)
+	if err != nil {
+		logging.LogError("Failed to get lockout status", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lockout status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "lockout": status})
+}
+
+// AdminUnlockUser handles POST /api/users/:id/unlock - Admin lifts the lockout of a user and
+// clears their failed logins
+func (h *UserHandler) AdminUnlockUser(c *gin.Context) {
+	adminClaims, user, ok := h.loadEntityUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.loginGuard.Unlock(user, &adminClaims.UserID, c.ClientIP(), "admin_unlock"); err != nil {
+		logging.LogError("Failed to unlock user", "error", err, "user_id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	logging.LogInfo("User unlocked by admin", "user_id", user.ID, "admin_id", adminClaims.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
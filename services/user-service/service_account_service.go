@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	ErrServiceAccountExists   = errors.New("a service account with this name already exists")
+	ErrServiceAccountInactive = errors.New("service account is deactivated")
+)
+
+// ServiceAccountService manages service accounts: users of the 'service' role that belong to
+// one entity and only authenticate with API keys. They have no password and an undeliverable
+// email, so they can't log in or reset a password.
+type ServiceAccountService struct {
+	db      *sql.DB
+	apiKeys *APIKeyService
+}
+
+func NewServiceAccountService(db *sql.DB, apiKeys *APIKeyService) *ServiceAccountService {
+	return &ServiceAccountService{db: db, apiKeys: apiKeys}
+}
+
+const serviceAccountColumns = `u.id, u.healthcare_entity_id, u.first_name, sa.description, u.is_active,
+	(SELECT COUNT(*) FROM api_keys k WHERE k.user_id = u.id AND k.revoked_at IS NULL AND k.expires_at > CURRENT_TIMESTAMP),
+	sa.created_by, sa.created_at`
+
+const serviceAccountFrom = ` FROM service_accounts sa JOIN users u ON u.id = sa.user_id`
+
+func scanServiceAccount(row rowScanner) (*ServiceAccount, error) {
+	account := &ServiceAccount{}
+	var createdBy sql.NullInt64
+	err := row.Scan(&account.ID, &account.HealthcareEntityID, &account.Name, &account.Description, &account.IsActive,
+		&account.ActiveKeyCount, &createdBy, &account.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	account.CreatedBy = nullIntPtr(createdBy)
+	return account, nil
+}
+
+// serviceAccountEmail returns a unique address under the reserved .invalid domain
+func serviceAccountEmail(entityID int) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate service account email: %v", err)
+	}
+	return fmt.Sprintf("service-account-%s@entity-%d.service.invalid", hex.EncodeToString(b), entityID), nil
+}
+
+// ListServiceAccounts returns the service accounts of an entity, deactivated ones included
+func (s *ServiceAccountService) ListServiceAccounts(entityID int) ([]ServiceAccount, error) {
+	rows, err := s.db.Query(`SELECT `+serviceAccountColumns+serviceAccountFrom+`
+		WHERE u.healthcare_entity_id = $1 ORDER BY u.is_active DESC, u.first_name`, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []ServiceAccount{}
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *account)
+	}
+	return accounts, rows.Err()
+}
+
+// GetServiceAccount returns a service account of an entity
+func (s *ServiceAccountService) GetServiceAccount(entityID, id int) (*ServiceAccount, error) {
+	account, err := scanServiceAccount(s.db.QueryRow(`SELECT `+serviceAccountColumns+serviceAccountFrom+`
+		WHERE u.healthcare_entity_id = $1 AND u.id = $2`, entityID, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrServiceAccountNotFound
+	}
+	return account, err
+}
+
+// CreateServiceAccount creates a service account in an entity. Names are unique among the
+// entity's active service accounts.
+func (s *ServiceAccountService) CreateServiceAccount(entityID int, req *ServiceAccountRequest, createdBy int) (*ServiceAccount, error) {
+	name := strings.TrimSpace(req.Name)
+	email, err := serviceAccountEmail(entityID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users
+		              WHERE healthcare_entity_id = $1 AND role = $2 AND is_active = true AND LOWER(first_name) = LOWER($3))
+	`, entityID, ServiceAccountRole, name).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrServiceAccountExists
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO users (email, password_hash, first_name, last_name, role, healthcare_entity_id,
+		                   license_number, specialization, preferred_locale, is_active, created_at, updated_at)
+		VALUES ($1, '', $2, '', $3, $4, '', '', 'en-US', true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id
+	`, email, name, ServiceAccountRole, entityID).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service account user: %v", err)
+	}
+	_, err = tx.Exec(`INSERT INTO service_accounts (user_id, description, created_by) VALUES ($1, $2, $3)`,
+		id, strings.TrimSpace(req.Description), createdBy)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetServiceAccount(entityID, id)
+}
+
+// DeactivateServiceAccount deactivates a service account and revokes its keys. It returns the
+// account and the number of keys revoked.
+func (s *ServiceAccountService) DeactivateServiceAccount(entityID, id, deactivatedBy int) (*ServiceAccount, int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE users SET is_active = false, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND healthcare_entity_id = $2 AND role = $3
+		  AND EXISTS (SELECT 1 FROM service_accounts WHERE user_id = users.id)
+	`, id, entityID, ServiceAccountRole)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, 0, ErrServiceAccountNotFound
+	}
+	revoked, err := s.apiKeys.RevokeUserKeys(tx, id, deactivatedBy)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	account, err := s.GetServiceAccount(entityID, id)
+	return account, revoked, err
+}
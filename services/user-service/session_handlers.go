@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// Logout handles POST /api/auth/logout - ends the session of the given refresh token.
+// Access tokens of the session stay valid until they expire (15 minutes).
+func (h *UserHandler) Logout(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.sessionService.RevokeByRefreshToken(req.RefreshToken); err != nil {
+		logging.LogError("Failed to end session", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// GetSessions handles GET /api/auth/sessions - lists the caller's active sessions
+func (h *UserHandler) GetSessions(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userClaims := claims.(*Claims)
+
+	sessions, err := h.sessionService.ListSessions(userClaims.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == userClaims.SessionID
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession handles DELETE /api/auth/sessions/:sessionId - ends one of the caller's sessions
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userClaims := claims.(*Claims)
+
+	sessionID, err := strconv.Atoi(c.Param("sessionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := h.sessionService.RevokeSession(userClaims.UserID, sessionID, userClaims.UserID, "logout"); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeOtherSessions handles DELETE /api/auth/sessions - ends every session of the caller
+// except the current one
+func (h *UserHandler) RevokeOtherSessions(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userClaims := claims.(*Claims)
+
+	revoked, err := h.sessionService.RevokeUserSessions(userClaims.UserID, userClaims.SessionID, userClaims.UserID, "logout")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked successfully", "sessions_revoked": revoked})
+}
+
+// loadEntityUser loads the :id user of the caller's entity
+func (h *UserHandler) loadEntityUser(c *gin.Context) (*Claims, *User, bool) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, nil, false
+	}
+	userClaims := claims.(*Claims)
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, nil, false
+	}
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil || user.HealthcareEntityID != userClaims.HealthcareEntityID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, nil, false
+	}
+	return userClaims, user, true
+}
+
+// AdminGetUserSessions handles GET /api/users/:id/sessions - Admin lists a user's active sessions
+func (h *UserHandler) AdminGetUserSessions(c *gin.Context) {
+	_, user, ok := h.loadEntityUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.sessionService.ListSessions(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "sessions": sessions})
+}
+
+// AdminRevokeUserSessions handles DELETE /api/users/:id/sessions - Admin logs a user out of
+// every session
+func (h *UserHandler) AdminRevokeUserSessions(c *gin.Context) {
+	adminClaims, user, ok := h.loadEntityUser(c)
+	if !ok {
+		return
+	}
+
+	revoked, err := h.sessionService.RevokeUserSessions(user.ID, 0, adminClaims.UserID, "admin_logout")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	logging.LogInfo("User sessions revoked by admin", "user_id", user.ID, "admin_id", adminClaims.UserID, "sessions_revoked", revoked)
+	c.JSON(http.StatusOK, gin.H{"message": "User logged out of all sessions", "sessions_revoked": revoked})
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	logging "github.com/louhibi/healthcare-logging"
+)
+
+// refreshTokenTTL is how long a refresh token can be used; every refresh issues a new one
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+// sessionMaxAge is how long a session lasts after login, however often it is refreshed
+const sessionMaxAge = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// SessionService keeps the server-side sessions behind refresh tokens
+type SessionService struct {
+	db *sql.DB
+}
+
+func NewSessionService(db *sql.DB) *SessionService {
+	return &SessionService{db: db}
+}
+
+const sessionColumns = `id, user_id, healthcare_entity_id, family_id, user_agent, ip_address, created_at, last_used_at,
+	expires_at, revoked_at, revoke_reason, mfa_verified`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row rowScanner) (*UserSession, error) {
+	session := &UserSession{}
+	err := row.Scan(&session.ID, &session.UserID, &session.HealthcareEntityID, &session.FamilyID, &session.Device,
+		&session.IPAddress, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt,
+		&session.RevokeReason, &session.MFAVerified)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// newRefreshToken returns a random opaque refresh token and the hash it is stored as
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken adds a new refresh token to a session, valid for refreshTokenTTL but not
+// beyond the session itself
+func issueRefreshToken(tx *sql.Tx, session *UserSession) (string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(refreshTokenTTL)
+	if session.ExpiresAt.Before(expiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+	_, err = tx.Exec(`INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		session.ID, hash, expiresAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %v", err)
+	}
+	return token, nil
+}
+
+// CreateSession starts a session for a user who just authenticated and returns its first
+// refresh token. mfaVerified records whether the login included a second factor.
+func (s *SessionService) CreateSession(user *User, userAgent, ipAddress string, mfaVerified bool) (*UserSession, string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+	session, err := scanSession(tx.QueryRow(`
+		INSERT INTO user_sessions (user_id, healthcare_entity_id, user_agent, ip_address, expires_at, mfa_verified)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+sessionColumns,
+		user.ID, user.HealthcareEntityID, userAgent, ipAddress, time.Now().Add(sessionMaxAge), mfaVerified))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create session: %v", err)
+	}
+	token, err := issueRefreshToken(tx, session)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+// Rotate exchanges a refresh token for a new one of the same session. A token that was
+// already exchanged means it was copied: the whole session is revoked and
+// ErrRefreshTokenReused returned.
+func (s *SessionService) Rotate(refreshToken, userAgent, ipAddress string) (*UserSession, string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	var tokenID int
+	var tokenExpiresAt time.Time
+	var replacedAt *time.Time
+	var sessionID int
+	err = tx.QueryRow(`
+		SELECT rt.id, rt.expires_at, rt.replaced_at, rt.session_id
+		FROM refresh_tokens rt
+		WHERE rt.token_hash = $1
+	`, hashRefreshToken(refreshToken)).Scan(&tokenID, &tokenExpiresAt, &replacedAt, &sessionID)
+	if err == sql.ErrNoRows {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	// Concurrent refreshes of the same session are serialised on the session row
+	session, err := scanSession(tx.QueryRow(`SELECT `+sessionColumns+` FROM user_sessions WHERE id = $1 FOR UPDATE`, sessionID))
+	if err != nil {
+		return nil, "", err
+	}
+	if session.RevokedAt != nil {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if replacedAt != nil {
+		_, err = tx.Exec(`
+			UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = 'refresh_token_reuse'
+			WHERE id = $1
+		`, session.ID)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to revoke session: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, "", err
+		}
+		logging.LogWarn("Refresh token reused, session revoked",
+			"session_id", session.ID, "user_id", session.UserID, "ip_address", ipAddress)
+		return nil, "", ErrRefreshTokenReused
+	}
+	now := time.Now()
+	if now.After(tokenExpiresAt) || now.After(session.ExpiresAt) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET replaced_at = CURRENT_TIMESTAMP WHERE id = $1`, tokenID); err != nil {
+		return nil, "", fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+	// Expired tokens can no longer be presented, so they are not needed to detect reuse
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE session_id = $1 AND expires_at < CURRENT_TIMESTAMP`, session.ID); err != nil {
+		return nil, "", err
+	}
+	token, err := issueRefreshToken(tx, session)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+	session, err = scanSession(tx.QueryRow(`
+		UPDATE user_sessions SET last_used_at = CURRENT_TIMESTAMP, user_agent = $2, ip_address = $3
+		WHERE id = $1
+		RETURNING `+sessionColumns, session.ID, userAgent, ipAddress))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to update session: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+// RevokeByRefreshToken ends the session a refresh token belongs to (logout). Unknown tokens
+// and ended sessions are ignored.
+func (s *SessionService) RevokeByRefreshToken(refreshToken string) error {
+	_, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP, revoked_by = user_id, revoke_reason = 'logout'
+		WHERE id = (SELECT session_id FROM refresh_tokens WHERE token_hash = $1) AND revoked_at IS NULL
+	`, hashRefreshToken(refreshToken))
+	return err
+}
+
+// MarkMFAVerified records that the user proved a second factor in an active session, so the
+// access tokens of its next refreshes carry the mfa claim
+func (s *SessionService) MarkMFAVerified(userID, sessionID int) error {
+	_, err := s.db.Exec(`
+		UPDATE user_sessions SET mfa_verified = true
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID)
+	return err
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func (s *SessionService) ListSessions(userID int) ([]UserSession, error) {
+	rows, err := s.db.Query(`SELECT `+sessionColumns+`
+		FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_used_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []UserSession{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession ends one active session of a user
+func (s *SessionService) RevokeSession(userID, sessionID, revokedBy int, reason string) error {
+	result, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $3, revoke_reason = $4
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID, revokedBy, reason)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// SwitchEntity scopes an active session of the user to another of their entities; its next
+// refreshes issue access tokens for that entity
+func (s *SessionService) SwitchEntity(userID, sessionID, entityID int) (*UserSession, error) {
+	session, err := scanSession(s.db.QueryRow(`
+		UPDATE user_sessions SET healthcare_entity_id = $3, last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING `+sessionColumns, sessionID, userID, entityID))
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
+	return session, err
+}
+
+// RevokeEntitySessions ends the active sessions of a user scoped to an entity and returns how
+// many were ended
+func (s *SessionService) RevokeEntitySessions(userID, entityID, revokedBy int, reason string) (int64, error) {
+	result, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $3, revoke_reason = $4
+		WHERE user_id = $1 AND healthcare_entity_id = $2 AND revoked_at IS NULL
+	`, userID, entityID, revokedBy, reason)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RevokeUserSessions ends every active session of a user except exceptSessionID (0 for none)
+// and returns how many were ended
+func (s *SessionService) RevokeUserSessions(userID, exceptSessionID, revokedBy int, reason string) (int64, error) {
+	result, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $3, revoke_reason = $4
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	`, userID, exceptSessionID, revokedBy, reason)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	refreshTokenQuery = `SELECT rt.id, rt.expires_at, rt.replaced_at, rt.session_id\s+FROM refresh_tokens`
+	sessionLockQuery  = `FROM user_sessions WHERE id = \$1 FOR UPDATE`
+)
+
+// newMockDB returns a database whose queries are matched by regular expressions
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// sessionRows returns session 10 of user 7, revoked at revokedAt if it isn't nil
+func sessionRows(revokedAt *time.Time) *sqlmock.Rows {
+	now := time.Now()
+	var revoked driver.Value
+	if revokedAt != nil {
+		revoked = *revokedAt
+	}
+	return sqlmock.NewRows([]string{"id", "user_id", "healthcare_entity_id", "family_id", "user_agent", "ip_address",
+		"created_at", "last_used_at", "expires_at", "revoked_at", "revoke_reason", "mfa_verified"}).
+		AddRow(10, 7, 1, "family", "test", "10.0.0.1", now, now, now.Add(sessionMaxAge), revoked, "", false)
+}
+
+func refreshTokenRows(id int, expiresAt time.Time, replacedAt *time.Time) *sqlmock.Rows {
+	var replaced driver.Value
+	if replacedAt != nil {
+		replaced = *replacedAt
+	}
+	return sqlmock.NewRows([]string{"id", "expires_at", "replaced_at", "session_id"}).AddRow(id, expiresAt, replaced, 10)
+}
+
+func TestRotateThenReuseRevokesSession(t *testing.T) {
+	db, mock := newMockDB(t)
+	service := NewSessionService(db)
+	oldToken, oldHash, err := newRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// First use: the token is marked replaced and a new one issued
+	mock.ExpectBegin()
+	mock.ExpectQuery(refreshTokenQuery).WithArgs(oldHash).
+		WillReturnRows(refreshTokenRows(1, time.Now().Add(time.Hour), nil))
+	mock.ExpectQuery(sessionLockQuery).WithArgs(10).WillReturnRows(sessionRows(nil))
+	mock.ExpectExec(`UPDATE refresh_tokens SET replaced_at`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM refresh_tokens`).WithArgs(10).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO refresh_tokens`).WithArgs(10, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectQuery(`UPDATE user_sessions SET last_used_at`).WithArgs(10, "test", "10.0.0.1").
+		WillReturnRows(sessionRows(nil))
+	mock.ExpectCommit()
+
+	_, newToken, err := service.Rotate(oldToken, "test", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Rotate error = %v", err)
+	}
+	if newToken == "" || newToken == oldToken {
+		t.Fatalf("Rotate returned refresh token %q, want a new one", newToken)
+	}
+
+	// Second use of the old token: the session is revoked
+	replacedAt := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery(refreshTokenQuery).WithArgs(oldHash).
+		WillReturnRows(refreshTokenRows(1, time.Now().Add(time.Hour), &replacedAt))
+	mock.ExpectQuery(sessionLockQuery).WithArgs(10).WillReturnRows(sessionRows(nil))
+	mock.ExpectExec(`UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = 'refresh_token_reuse'`).
+		WithArgs(10).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, _, err := service.Rotate(oldToken, "attacker", "10.0.0.2"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Rotate with a used token error = %v, want ErrRefreshTokenReused", err)
+	}
+
+	// The token issued by the first rotation died with the session
+	revokedAt := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery(refreshTokenQuery).WithArgs(hashRefreshToken(newToken)).
+		WillReturnRows(refreshTokenRows(2, time.Now().Add(time.Hour), nil))
+	mock.ExpectQuery(sessionLockQuery).WithArgs(10).WillReturnRows(sessionRows(&revokedAt))
+	mock.ExpectRollback()
+
+	if _, _, err := service.Rotate(newToken, "test", "10.0.0.1"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate in a revoked session error = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRotateRejectsInvalidTokens(t *testing.T) {
+	t.Run("unknown token", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(refreshTokenQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "expires_at", "replaced_at", "session_id"}))
+		mock.ExpectRollback()
+
+		if _, _, err := NewSessionService(db).Rotate("unknown", "test", "10.0.0.1"); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Rotate error = %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+	t.Run("expired token", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(refreshTokenQuery).WillReturnRows(refreshTokenRows(1, time.Now().Add(-time.Minute), nil))
+		mock.ExpectQuery(sessionLockQuery).WithArgs(10).WillReturnRows(sessionRows(nil))
+		mock.ExpectRollback()
+
+		if _, _, err := NewSessionService(db).Rotate("expired", "test", "10.0.0.1"); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Rotate error = %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 test key of RFC 6238 ("12345678901234567890"), base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	key, _ := totpEncoding.DecodeString(rfc6238Secret)
+	now := time.Unix(1111111109, 0)
+	current := now.Unix() / totpPeriod
+	code := func(step int64) string { return totpCode(key, step) }
+
+	tests := []struct {
+		name      string
+		secret    string
+		code      string
+		lastStep  int64
+		wantStep  int64
+		wantMatch bool
+	}{
+		{"current step", rfc6238Secret, code(current), 0, current, true},
+		{"previous step within skew", rfc6238Secret, code(current - 1), 0, current - 1, true},
+		{"next step within skew", rfc6238Secret, code(current + 1), 0, current + 1, true},
+		{"two steps behind", rfc6238Secret, code(current - 2), 0, 0, false},
+		{"two steps ahead", rfc6238Secret, code(current + 2), 0, 0, false},
+		{"replayed code", rfc6238Secret, code(current), current, 0, false},
+		{"code older than the last used step", rfc6238Secret, code(current - 1), current - 1, 0, false},
+		{"newer code after a used step", rfc6238Secret, code(current + 1), current, current + 1, true},
+		{"code with spaces", rfc6238Secret, " " + code(current)[:3] + " " + code(current)[3:] + " ", 0, current, true},
+		{"lower-case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code(current), 0, current, true},
+		{"wrong code", rfc6238Secret, "000000", 0, 0, false},
+		{"short code", rfc6238Secret, code(current)[:5], 0, 0, false},
+		{"invalid secret", "not base32!", code(current), 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := matchTOTP(tt.secret, tt.code, now, tt.lastStep)
+			if ok != tt.wantMatch || step != tt.wantStep {
+				t.Errorf("matchTOTP = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestMatchTOTPStepBoundaries(t *testing.T) {
+	key, _ := totpEncoding.DecodeString(rfc6238Secret)
+	stepStart := time.Unix(1111111110, 0) // first second of a step
+	step := stepStart.Unix() / totpPeriod
+	tests := []struct {
+		name      string
+		now       time.Time
+		wantMatch bool
+	}{
+		{"last second of the next step's window", stepStart.Add(2*totpPeriod*time.Second - time.Second), true},
+		{"first second after the window", stepStart.Add(2 * totpPeriod * time.Second), false},
+		{"first second of the previous step's window", stepStart.Add(-totpPeriod * time.Second), true},
+		{"last second before the window", stepStart.Add(-totpPeriod*time.Second - time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := matchTOTP(rfc6238Secret, totpCode(key, step), tt.now, 0); ok != tt.wantMatch {
+				t.Errorf("matchTOTP at %v = %v, want %v", tt.now.Unix(), ok, tt.wantMatch)
+			}
+		})
+	}
+}